@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/franksops/gofast/engine"
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+// runCleanup implements `gfast cleanup`, which aborts the unfinished
+// multipart uploads that crashed runs left under a destination, so their
+// parts stop accruing storage cost. It returns the process exit code.
+func runCleanup(args []string) int {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	var (
+		olderThan time.Duration
+		stateDir  string
+		dryRun    bool
+	)
+	fs.DurationVar(&olderThan, "older-than", 24*time.Hour, "Abort uploads started longer ago than this")
+	fs.StringVar(&stateDir, "state-dir", "./.gofast-state", "State directory of runs whose checkpointed uploads must be kept for resuming")
+	fs.BoolVar(&dryRun, "dry-run", false, "List the uploads that would be aborted without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast cleanup <s3://bucket/prefix> [-older-than 24h] [-dry-run]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	p, root, err := createProvider(positional[0], false, "")
+	if err != nil {
+		log.Printf("Failed to create provider: %v", err)
+		return 1
+	}
+
+	var tracker *engine.JobTracker
+	statePath := filepath.Join(stateDir, "state.db")
+	if _, err := os.Stat(statePath); err == nil {
+		stateStore, err := store.NewBoltStore(statePath)
+		if err != nil {
+			log.Printf("Failed to open state store: %v", err)
+			return 1
+		}
+		defer stateStore.Close()
+		tracker = engine.NewJobTracker(stateStore, engine.DefaultCheckpointConfig)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if !abortStaleUploads(ctx, p, root, olderThan, tracker, dryRun) {
+		return 1
+	}
+	return 0
+}
+
+// abortStaleUploads aborts the uploads under root on dst started longer ago
+// than olderThan, except those tracker has checkpointed, and logs what it
+// did. It reports whether every stale upload was dealt with.
+func abortStaleUploads(ctx context.Context, dst provider.Provider, root string, olderThan time.Duration, tracker *engine.JobTracker, dryRun bool) bool {
+	var keep map[string]bool
+	if tracker != nil {
+		var err error
+		if keep, err = tracker.RecordedUploads(); err != nil {
+			log.Printf("Failed to read checkpointed uploads: %v", err)
+			return false
+		}
+	}
+
+	uploads, err := engine.AbortStaleUploads(ctx, dst, root, time.Now().Add(-olderThan), keep, dryRun)
+	if errors.Is(err, provider.ErrNotSupported) {
+		log.Printf("The destination has no multipart uploads to clean up")
+		return true
+	}
+	for _, u := range uploads {
+		verb := "aborted"
+		if dryRun {
+			verb = "would abort"
+		}
+		fmt.Printf("%s upload %s of %s (started %s)\n", verb, u.UploadID, u.Path, u.Initiated.Format(time.RFC3339))
+	}
+	if err != nil {
+		log.Printf("Failed to clean up stale uploads: %v", err)
+		return false
+	}
+	if !dryRun && len(uploads) > 0 {
+		log.Printf("Aborted %d stale multipart uploads", len(uploads))
+	}
+	return true
+}
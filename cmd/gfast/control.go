@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/franksops/gofast/engine"
+)
+
+// serveControl serves the control API on ln until ctx is done:
+//
+//	GET  /streams                         running streams and their limits
+//	POST /streams/limit?rate=50MB/s       default limit of every stream
+//	POST /streams/limit?job=<id>&rate=... limit of one stream (0 = unlimited)
+//	GET  /throughput                      throughput of the run and each stream
+//	GET  /stats                           files and bytes by state, rate and ETA
+//
+// The API is unauthenticated, so ln should listen on a loopback address.
+func serveControl(ctx context.Context, ln net.Listener, limits *engine.StreamLimits, meter *engine.Meter, stats *engine.RunStats) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /streams", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			DefaultRate int64                 `json:"default_rate"`
+			Streams     []engine.StreamStatus `json:"streams"`
+		}{limits.DefaultRate(), limits.Streams()})
+	})
+	mux.HandleFunc("GET /throughput", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Rate    float64             `json:"rate"`
+			Streams []engine.StreamRate `json:"streams"`
+		}{meter.Rate(), meter.Streams()})
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		s := stats.Stats()
+		var eta *time.Duration
+		if d, ok := s.ETA(); ok {
+			eta = &d
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			engine.Stats
+			ETA *time.Duration `json:"eta,omitempty"`
+		}{s, eta})
+	})
+	mux.HandleFunc("POST /streams/limit", func(w http.ResponseWriter, r *http.Request) {
+		rate, err := engine.ParseRate(r.URL.Query().Get("rate"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jobID := r.URL.Query().Get("job")
+		if jobID == "" {
+			limits.SetDefaultRate(rate)
+			log.Printf("Control: default stream limit set to %d bytes/s", rate)
+		} else if err := limits.SetRate(jobID, rate); errors.Is(err, engine.ErrStreamNotFound) {
+			http.Error(w, fmt.Sprintf("no running stream for %s", jobID), http.StatusNotFound)
+			return
+		} else {
+			log.Printf("Control: limit of %s set to %d bytes/s", jobID, rate)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
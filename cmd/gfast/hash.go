@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/franksops/gofast/engine"
+	"github.com/franksops/gofast/provider"
+)
+
+// runHash implements `gfast hash`, which checksums every file under a URL
+// through its provider and writes a manifest of the results. It returns the
+// process exit code.
+func runHash(args []string) int {
+	fs := flag.NewFlagSet("hash", flag.ExitOnError)
+	var (
+		algoName   string
+		recursive  bool
+		streams    int
+		bufferSize int
+		output     string
+		determ     bool
+	)
+	fs.StringVar(&algoName, "algo", string(engine.AlgorithmXXH3), "Checksum algorithm (crc64, xxh3)")
+	fs.BoolVar(&recursive, "recursive", false, "Descend into subdirectories")
+	fs.IntVar(&streams, "streams", defaultStreams, "Number of concurrent hashing streams")
+	fs.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "Buffer size in bytes for each stream")
+	fs.StringVar(&output, "o", "", "Write the manifest to this file instead of stdout")
+	fs.BoolVar(&determ, "deterministic", false, "Walk in sorted order and write the manifest sorted by path")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast hash <url> [-algo xxh3] [-recursive] [-o manifest.jsonl]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+	url := positional[0]
+
+	algo, err := engine.ParseChecksumAlgorithm(algoName)
+	if err != nil {
+		log.Printf("Invalid -algo: %v", err)
+		return 2
+	}
+	hashers, _ := engine.NewChecksumPoolFor(algo)
+
+	p, root, err := createProvider(url, false, "")
+	if err != nil {
+		log.Printf("Failed to create provider: %v", err)
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Printf("Failed to create manifest: %v", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	manifest := engine.NewManifestWriter(out)
+	if determ {
+		manifest = engine.NewSortedManifestWriter(out)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	bufferPool := engine.NewBufferPool(bufferSize)
+	jobChan := make(engine.JobChannel, 1000)
+	var failures atomic.Int64
+
+	pool := engine.NewWorkerPool(ctx, jobChan, func(ctx context.Context, job engine.TransferJob) error {
+		h := hashers.Get()
+		defer hashers.Put(h)
+		buf := bufferPool.Get()
+		defer bufferPool.Put(buf)
+
+		sum, n, err := engine.HashFile(ctx, p, job.SourcePath, h, *buf)
+		if err != nil {
+			failures.Add(1)
+			log.Printf("Failed to hash %s: %v", job.SourcePath, err)
+			return err
+		}
+		return manifest.Write(engine.ManifestEntry{
+			Path:      manifestPath(job),
+			Size:      n,
+			ModTime:   job.FileInfo.ModTime(),
+			Algorithm: algo,
+			Checksum:  engine.FormatChecksum(sum),
+		})
+	})
+	pool.SetWorkerCount(streams)
+
+	go func() {
+		defer close(jobChan)
+		var err error
+		if recursive {
+			walker := engine.NewWalker(p, jobChan)
+			walker.Sorted = determ
+			err = walker.Walk(ctx, root, "")
+		} else {
+			err = enqueueShallow(ctx, p, root, determ, jobChan)
+		}
+		if err != nil {
+			failures.Add(1)
+			log.Printf("Walker error: %v", err)
+		}
+	}()
+
+	pool.Wait()
+	pool.Stop()
+
+	if err := manifest.Flush(); err != nil {
+		log.Printf("Failed to flush manifest: %v", err)
+		return 1
+	}
+	if n := failures.Load(); n > 0 {
+		log.Printf("Hashed %d files, %d failed", manifest.Count(), n)
+		return 1
+	}
+	return 0
+}
+
+// enqueueShallow queues root if it is a file, or the files directly inside
+// it if it is a directory, optionally in name order.
+func enqueueShallow(ctx context.Context, p provider.Provider, root string, sorted bool, jobChan engine.JobChannel) error {
+	stat, err := p.Stat(ctx, root)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", root, err)
+	}
+
+	entries := []provider.FileInfo{stat}
+	if stat.IsDir() {
+		if entries, err = p.List(ctx, root); err != nil {
+			return fmt.Errorf("failed to list %s: %w", root, err)
+		}
+	}
+	if sorted {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		job := engine.TransferJob{
+			ID:              root,
+			SourcePath:      root,
+			DestinationPath: entry.Name(),
+			FileInfo:        entry,
+			Ctx:             ctx,
+		}
+		if stat.IsDir() {
+			job.ID = filepath.Join(root, entry.Name())
+			job.SourcePath = job.ID
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case jobChan <- job:
+		}
+	}
+	return nil
+}
+
+// manifestPath returns the root-relative path recorded for a hashed file.
+// Jobs are generated with an empty destination root, so the destination
+// path is already relative; a lone file falls back to its base name.
+func manifestPath(job engine.TransferJob) string {
+	if job.DestinationPath != "" {
+		return filepath.ToSlash(job.DestinationPath)
+	}
+	return path.Base(filepath.ToSlash(job.SourcePath))
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments, e.g. `gfast hash <url> -algo xxh3`, and returns the positionals.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"github.com/franksops/gofast/engine"
@@ -27,68 +31,700 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hash":
+			os.Exit(runHash(os.Args[2:]))
+		case "undelete":
+			os.Exit(runUndelete(os.Args[2:]))
+		case "resume":
+			os.Exit(runResume(os.Args[2:]))
+		case "retry":
+			os.Exit(runRetry(os.Args[2:]))
+		case "plan":
+			os.Exit(runPlan(os.Args[2:]))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "pull-state":
+			os.Exit(runPullState(os.Args[2:]))
+		case "presign":
+			os.Exit(runPresign(os.Args[2:]))
+		case "presigned":
+			os.Exit(runPresigned(os.Args[2:]))
+		case "cleanup":
+			os.Exit(runCleanup(os.Args[2:]))
+		}
+	}
+
+	os.Exit(runMigrate(os.Args[1:], ""))
+}
+
+// runMigrate parses the migration flags in args and runs the migration. A
+// non-empty runID continues an earlier run recorded in the state store. It
+// returns the process exit code.
+func runMigrate(args []string, runID string) int {
+	fs := flag.NewFlagSet("gfast", flag.ExitOnError)
+
 	// CLI flags
 	var (
-		source      string
-		dest        string
-		streams     int
-		bufferSize  int
-		stateDir    string
-		noMetadata  bool
-		checksum    bool
-		tuiEnabled  bool
+		sources    pathList
+		collisions string
+		dests      pathList
+		streams    int
+		autoTune   bool
+		tuneMin    int
+		tuneMax    int
+		bufferSize int
+		stateDir   string
+		noMetadata bool
+		checksum   bool
+		tuiEnabled bool
+		progressIv time.Duration
+		drain      time.Duration
+		validate   string
+		transform  string
+		transKey   string
+		onComplete string
+		onFailure  string
+		manifest   string
+		manifestAs string
+		scrub      bool
+		determ     bool
+		flatList   bool
+		listers    int
+		prescan    bool
+		snapshot   bool
+		walkErrs   string
+		errBudget  string
+		srcChanges string
+		maxDepth   int
+		oneFS      bool
+		queueMem   int64
+		memLimit   string
+		retries    int
+		bwLimit    string
+		streamBW   string
+		pathLimit  string
+		control    string
+		metaErrors string
+		cacheTTL   time.Duration
+		cacheSave  bool
+		chaos      string
+		sparse     bool
+		replicate  time.Duration
+		directIO   bool
+		ioUring    bool
+		uringSize  int
+		uringPoll  bool
+		fsync      bool
+		srcProfile string
+		dstProfile string
+		mtimeMode  string
+		clockSkew  time.Duration
+		sidecar    string
+		srcSidecar string
+		strictLink bool
+		symlinks   string
+		keepATime  bool
+		keepBTime  bool
+		restore    bool
+		restoreTr  string
+		restoreDay int
+		restorePol time.Duration
+		noTags     bool
+		typeMap    string
+		versions   bool
+		adaptive   bool
+		staleAge   time.Duration
+		recopy     bool
+		update     bool
+		mtimeWin   time.Duration
+		compare    string
+		mirror     bool
+		mirrorDry  bool
+		maxDelete  int
+		minSize    string
+		maxSize    string
+		minAge     string
+		maxAge     string
+		sweeps     int
+		failedOnly bool
+		largeFile  string
+		largeChunk string
+		largeSplit int
+		bundleMax  string
+		bundleSize string
+		bundleN    int
+		dedup      bool
+		dedupLink  bool
+		schedule   string
 	)
 
-	flag.StringVar(&source, "source", "", "Source path (local or s3://bucket/prefix)")
-	flag.StringVar(&dest, "dest", "", "Destination path (local or s3://bucket/prefix)")
-	flag.IntVar(&streams, "streams", defaultStreams, "Number of concurrent transfer streams")
-	flag.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "Buffer size in bytes for each stream")
-	flag.StringVar(&stateDir, "state-dir", "./.gofast-state", "Directory to store state/checkpoint files")
-	flag.BoolVar(&noMetadata, "no-metadata", false, "Disable metadata preservation (UID/GID/mode)")
-	flag.BoolVar(&checksum, "checksum", false, "Enable streaming checksum verification (CRC64)")
-	flag.BoolVar(&tuiEnabled, "tui", true, "Enable TUI (disable for headless operation)")
-	flag.Parse()
-
-	if source == "" || dest == "" {
+	fs.Var(&sources, "source", "Source path (local or s3://bucket/prefix); repeat it to merge several sources into one destination tree")
+	fs.StringVar(&collisions, "source-collisions", string(provider.CollisionFirst), "With several -source paths, which file to copy when the same relative path exists in more than one: first (from the earliest -source), newest, largest or skip")
+	fs.Var(&dests, "dest", "Destination path (local or s3://bucket/prefix); repeat it to write every file to several destinations at once, each with its own job state, with the other options applying to the first")
+	fs.StringVar(&s3Defaults.Endpoint, "s3-endpoint", s3Defaults.Endpoint, "Endpoint URL of an S3-compatible service (MinIO, Ceph, Wasabi) for s3:// paths; defaults to $GOFAST_S3_ENDPOINT")
+	fs.StringVar(&s3Defaults.Region, "s3-region", s3Defaults.Region, "Region for s3:// paths, overriding the AWS configuration; defaults to $GOFAST_S3_REGION")
+	fs.BoolVar(&s3Defaults.UsePathStyle, "s3-path-style", s3Defaults.UsePathStyle, "Address buckets as endpoint/bucket instead of bucket.endpoint; defaults to $GOFAST_S3_PATH_STYLE")
+	fs.BoolVar(&s3Defaults.DirPlaceholders, "s3-dir-placeholders", false, "Write a zero-byte \"dir/\" object for each source directory, empty ones included, in an S3 destination, for tools that expect them")
+	fs.IntVar(&s3Defaults.DownloadConcurrency, "s3-download-concurrency", 1, "Ranged GETs fetched at once per S3 source object; above 1, large objects download in parallel parts")
+	fs.Int64Var(&s3Defaults.DownloadPartSize, "s3-download-part-size", 16*1024*1024, "Size in bytes of the parts S3 source objects are downloaded in")
+	fs.Int64Var(&s3Defaults.UploadPartSize, "s3-upload-part-size", 5*1024*1024, "Size in bytes of the parts objects are uploaded to S3 in, at least 5 MiB")
+	fs.IntVar(&s3Defaults.UploadConcurrency, "s3-upload-concurrency", 5, "Parts uploaded at once per S3 object; each stream buffers that many parts")
+	fs.IntVar(&s3Defaults.MaxUploadParts, "s3-max-upload-parts", 10000, "Most parts per S3 multipart upload, up to 10000")
+	fs.StringVar(&s3Defaults.SSE, "s3-sse", "", "Server-side encryption of objects written to S3: none, sse-s3, sse-kms or sse-c")
+	fs.StringVar(&s3Defaults.SSEKMSKeyID, "s3-sse-kms-key-id", "", "KMS key ID or alias for -s3-sse sse-kms, instead of the bucket's default key")
+	fs.StringVar(&s3Defaults.SSECustomerKeyFile, "s3-sse-c-key-file", "", "File holding the base64 256-bit key for -s3-sse sse-c, also sent when reading")
+	fs.StringVar(&srcProfile, "source-profile", "", "JSON provider profile for an s3:// source, e.g. {\"headers\": {\"X-Tenant-Id\": \"acme\"}}")
+	fs.StringVar(&dstProfile, "dest-profile", "", "JSON provider profile for an s3:// destination")
+	fs.IntVar(&streams, "streams", defaultStreams, "Number of concurrent transfer streams")
+	fs.StringVar(&pathLimit, "path-streams", "", "Cap the concurrent streams of files below a source or destination prefix, as source:prefix=N or dest:prefix=N pairs, e.g. 'source:/mnt/nfs=4,dest:archive/=64'; prefixes match paths as the run sees them")
+	fs.BoolVar(&autoTune, "auto-tune", false, "Adjust the number of streams while running, starting from -streams, to the count that gives the most throughput")
+	fs.IntVar(&tuneMin, "auto-tune-min", 1, "Fewest streams -auto-tune may use")
+	fs.IntVar(&tuneMax, "auto-tune-max", 0, "Most streams -auto-tune may use (0 = 4 times -streams)")
+	fs.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "Largest buffer size in bytes for each stream; smaller files get smaller buffers")
+	fs.StringVar(&stateDir, "state-dir", "./.gofast-state", "Directory to store state/checkpoint files")
+	fs.BoolVar(&noMetadata, "no-metadata", false, "Disable metadata preservation (UID/GID/mode)")
+	fs.BoolVar(&noTags, "no-tags", false, "Do not copy S3 object tags to S3 destinations")
+	fs.BoolVar(&update, "update", false, "Skip files the destination already holds with the same size and mtime, so re-syncs only move what changed")
+	fs.DurationVar(&mtimeWin, "mtime-window", engine.DefaultModTimeWindow, "Tolerance when comparing modification times for -update")
+	fs.StringVar(&compare, "compare", string(engine.CompareSizeMTime), "How -update decides a destination file is current: size-mtime, or checksum (compare stored checksums or re-hash both sides; implies -update)")
+	fs.StringVar(&minSize, "min-size", "", "Only copy files of at least this size, e.g. 1M (K, M, G, T are powers of 1024; KB, MB, GB, TB of 1000)")
+	fs.StringVar(&maxSize, "max-size", "", "Only copy files of at most this size")
+	fs.StringVar(&minAge, "min-age", "", "Only copy files last modified at least this long ago, e.g. 90d or 36h")
+	fs.StringVar(&maxAge, "max-age", "", "Only copy files last modified at most this long ago")
+	fs.BoolVar(&mirror, "delete", false, "Mirror the source: once the walk completes, delete destination files that no longer exist at the source")
+	fs.BoolVar(&mirrorDry, "delete-dry-run", false, "List the files -delete would remove without removing them")
+	fs.IntVar(&maxDelete, "max-delete", 0, "Refuse to delete anything if -delete would remove more files than this (0 = no limit)")
+	fs.IntVar(&sweeps, "retry-passes", 2, "Passes over the files that failed, once the rest have been transferred (0 disables)")
+	fs.BoolVar(&failedOnly, "failed-only", false, "Instead of walking the source, transfer only the files the state directory records as failed, as gfast retry does")
+	fs.BoolVar(&recopy, "recopy", false, "Copy every file again, instead of skipping those an earlier run with this state directory completed while their source size and mtime are unchanged")
+	fs.DurationVar(&staleAge, "abort-stale-uploads", 0, "Before starting, abort multipart uploads under the destination started longer ago than this, e.g. 24h, except those this state directory can resume (0 disables)")
+	fs.StringVar(&typeMap, "content-type-map", "", "Content-Type given to objects written to S3 by extension, overriding the source's and detection, e.g. '.md=text/markdown,.wasm=application/wasm'")
+	fs.StringVar(&sidecar, "metadata-sidecar", string(provider.SidecarNone), "Also record metadata in JSON sidecars at the destination, for destinations that cannot store it: none, files (<file>.gofast-meta) or manifest (one .gofast-meta.jsonl)")
+	fs.StringVar(&srcSidecar, "source-sidecars", string(provider.SidecarNone), "Restore metadata from sidecars written by -metadata-sidecar at the source: none, files or manifest")
+	fs.StringVar(&metaErrors, "metadata-errors", string(provider.MetadataErrorsWarn), "What to do when metadata cannot be applied to a copied file: ignore, warn (record and continue) or fail")
+	fs.BoolVar(&checksum, "checksum", false, "Checksum (CRC64) each file as it is read and as it is written, retry it if the two differ, and record the checksum in the state store")
+	fs.BoolVar(&tuiEnabled, "tui", true, "Enable TUI (disable for headless operation)")
+	fs.DurationVar(&progressIv, "progress-interval", time.Minute, "Without the TUI, log throughput and ETA at this interval (0 disables)")
+	fs.DurationVar(&drain, "drain", 0, "On SIGINT or SIGTERM, start no new files but give those running this long to finish before aborting them; a second signal aborts them at once (0 aborts them at once)")
+	fs.StringVar(&transform, "transform", "", "Pass file contents through transforms on the way: stages gzip, gunzip, encrypt and decrypt joined by '+', for every file or per glob, e.g. 'gzip+encrypt' or '*.log=gzip,*.enc=decrypt'")
+	fs.StringVar(&transKey, "transform-key", "", "File holding the 32-byte AES-256 key of the encrypt and decrypt transforms, raw or in hex")
+	fs.StringVar(&validate, "validate", "", "Validate file formats at the destination: 'auto' or glob=format pairs (gzip, zip, parquet), e.g. '*.gz=gzip,*.parquet=parquet'")
+	fs.StringVar(&onComplete, "on-complete", "", "Command run after each file completes; arguments may use {{.Src}}, {{.Dst}}, {{.Size}}, {{.Checksum}}, {{.State}}")
+	fs.StringVar(&onFailure, "on-failure", "", "Command run after each file fails; same templates as -on-complete plus {{.Error}}")
+	fs.StringVar(&manifest, "manifest", "", "Write a manifest of every file transferred, with its size, mtime, checksum and destination path, to this local file at the end of the run")
+	fs.StringVar(&manifestAs, "manifest-format", string(engine.ManifestJSONL), "Format of the -manifest file: jsonl or csv")
+	fs.BoolVar(&scrub, "scrub", false, "Re-verify completed files against the source while workers are otherwise idle")
+	fs.StringVar(&schedule, "schedule", string(engine.ScheduleFIFO), "Order files are handed to workers in: fifo (as walked), largest-first (no huge file left for last), smallest-first (most files done early) or locality (directory by directory)")
+	fs.BoolVar(&determ, "deterministic", false, "Walk the source in sorted order so job order is identical across runs")
+	fs.BoolVar(&restore, "restore", false, "Request restores of archived source objects (S3 Glacier, Deep Archive) and transfer them once readable, instead of failing them")
+	fs.StringVar(&restoreTr, "restore-tier", provider.RestoreStandard, "Retrieval tier for -restore: expedited, standard or bulk")
+	fs.IntVar(&restoreDay, "restore-days", 1, "Days a restored copy stays readable, for -restore")
+	fs.DurationVar(&restorePol, "restore-poll", engine.DefaultRestorePoll, "How often files waiting for a restore are checked")
+	fs.BoolVar(&versions, "all-versions", false, "Replay every version of each S3 source object, oldest first and delete markers included, into a destination bucket with versioning enabled")
+	fs.BoolVar(&flatList, "flat-list", false, "List S3 sources with one recursive key scan instead of one listing per directory")
+	fs.IntVar(&maxDepth, "max-depth", 0, "Only walk this many directory levels below the source root; 1 copies the root's files only (0 = no limit)")
+	fs.BoolVar(&oneFS, "one-file-system", false, "Do not cross into other filesystems (mount points, bind mounts) below a local source root")
+	fs.BoolVar(&oneFS, "x", false, "Shorthand for -one-file-system")
+	fs.StringVar(&walkErrs, "walk-errors", string(engine.WalkErrorsFail), "What an unreadable source directory does to the walk: fail (stop the run) or skip (log it, leave it out and continue)")
+	fs.StringVar(&srcChanges, "source-changes", string(engine.SourceChangesRetry), "What to do with a file whose local source changed while it was copied: retry (discard the copy and copy it again), inconsistent (keep the copy but record it as Inconsistent, so later runs copy it again) or ignore")
+	fs.StringVar(&errBudget, "error-budget", "continue", "Failed files the run tolerates: continue (finish and report them), fail-fast (abort at the first), a count such as 25, or a rate such as 2% of the files finished so far")
+	fs.BoolVar(&prescan, "prescan", false, "Count the files and bytes to transfer before starting, so progress and ETA are exact from the start; otherwise totals grow as the walk finds files")
+	fs.BoolVar(&snapshot, "snapshot", false, "List the whole source into the state store before transferring, then transfer exactly the files listed and report those that appeared or disappeared since, for a point-in-time copy of a changing source; resumed runs transfer the same listing")
+	fs.IntVar(&listers, "listers", 8, "Source directories listed at once while walking, ahead of the walk; files are still queued in walk order")
+	fs.BoolVar(&adaptive, "adaptive-concurrency", true, "Open fewer streams against an S3 bucket while it throttles requests (SlowDown, 503), and more again as throttling subsides")
+	fs.IntVar(&retries, "retries", provider.DefaultRetryPolicy.MaxAttempts, "Attempts for provider operations failing with transient errors (1 disables retries)")
+	fs.StringVar(&bwLimit, "bwlimit", "0", "Cap on the total transfer rate across all streams, in bytes per second or with a unit, e.g. 200MB/s (0 = unlimited)")
+	fs.StringVar(&streamBW, "stream-bwlimit", "0", "Cap on the rate of each stream, e.g. 50MB/s; it and the caps of single streams can be changed while running through -control-addr (0 = unlimited)")
+	fs.StringVar(&control, "control-addr", "", "Serve the control API on this address, e.g. 127.0.0.1:7070, to list streams and change their rate limits while running")
+	fs.DurationVar(&cacheTTL, "cache-ttl", 0, "Cache Stat/List results for this long to cut API calls on repeated runs (0 disables)")
+	fs.BoolVar(&cacheSave, "cache-persist", false, "Keep the -cache-ttl cache in the state directory between runs")
+	fs.StringVar(&chaos, "chaos", "", "Testing only: inject faults into both providers, e.g. 'error=0.1,eof=0.01,partial=0.01,latency=5ms,seed=42'")
+	fs.BoolVar(&keepATime, "preserve-atime", false, "Give local destination files the source's access time instead of the time they were written")
+	fs.BoolVar(&keepBTime, "preserve-btime", false, "Give local destination files the source's birth (creation) time, on macOS and Windows")
+	fs.StringVar(&mtimeMode, "mtime-policy", string(engine.MTimePreserve), "What to do with source mtimes in the future or before 1970: preserve (record only) or clamp (to now or 1970)")
+	fs.DurationVar(&clockSkew, "clock-skew", engine.DefaultClockSkew, "How far in the future an mtime may be before -mtime-policy applies")
+	fs.StringVar(&symlinks, "symlinks", string(engine.SymlinkPreserve), "How to treat symbolic links in the source: preserve (recreate as links), follow (copy what they point to) or skip")
+	fs.BoolVar(&strictLink, "strict-symlinks", false, "Refuse to read or write local paths that reach outside -source or -dest through symbolic links, and to create links pointing outside -dest")
+	fs.BoolVar(&fsync, "fsync", false, "Flush each local destination file and its directory to stable storage before marking it completed")
+	fs.BoolVar(&directIO, "direct-io", false, "Bypass the page cache (O_DIRECT) for local files, falling back to buffered I/O where unsupported")
+	fs.BoolVar(&ioUring, "io-uring", false, "Submit reads and writes of local files through io_uring with registered buffers, on Linux; falls back to ordinary I/O where unavailable")
+	fs.IntVar(&uringSize, "io-uring-entries", provider.DefaultIOUringEntries, "Submission queue size of -io-uring")
+	fs.BoolVar(&uringPoll, "io-uring-sqpoll", false, "Have a kernel thread poll the -io-uring submission queue instead of a system call per submission")
+	fs.BoolVar(&sparse, "sparse", false, "Read only the data of sparse source files and recreate their holes at a local destination")
+	fs.DurationVar(&replicate, "replicate-state", 0, "Copy the state database to <dest>/.gofast-state at this interval, so another host can resume after gfast pull-state (0 disables)")
+	fs.StringVar(&largeFile, "large-file-threshold", "0", "Split files larger than this, e.g. 10GB, into chunks transferred on several streams and reassembled at the destination (0 disables)")
+	fs.StringVar(&largeChunk, "large-file-chunk-size", "64MiB", "Size of the chunks of -large-file-threshold files; at least 5 MiB for S3 destinations")
+	fs.IntVar(&largeSplit, "large-file-streams", 4, "Chunks of one large file transferred at once")
+	fs.StringVar(&bundleMax, "bundle-threshold", "0", "Write files up to this size, e.g. 64K, into tar bundles under .gofast-bundles at the destination, indexed so each file can still be read on its own (0 disables)")
+	fs.StringVar(&bundleSize, "bundle-size", "256MiB", "Close a bundle once it holds this much content")
+	fs.IntVar(&bundleN, "bundle-files", 10000, "Close a bundle once it holds this many files")
+	fs.BoolVar(&dedup, "dedup", false, "Before copying a file, look for content of the same size and checksum already at the destination: skip the file if it is at its own path, or copy it server-side from where it is")
+	fs.BoolVar(&dedupLink, "dedup-hardlink", false, "With -dedup, hard link identical files at a local destination to one another instead of storing a copy of each (implies -dedup)")
+	fs.Int64Var(&queueMem, "queue-memory", 0, "Approximate memory limit in bytes for queued jobs; beyond it file metadata is re-read when a job starts (0 = unlimited)")
+	fs.StringVar(&memLimit, "memory-limit", "0", "Limit the data held in transfer buffers across all streams, including the parts S3 buffers, e.g. 2GiB; transfers wait for memory and the walk pauses near the limit (0 = unlimited)")
+	fs.Parse(args)
+
+	if len(sources) == 0 || len(dests) == 0 {
 		fmt.Println("Usage: gfast -source <src> -dest <dst> [options]")
+		fmt.Println("       gfast hash <url> [-algo xxh3] [-recursive]")
+		fmt.Println("       gfast undelete <url> [-since 24h] [-dry-run]")
+		fmt.Println("       gfast resume <token>")
+		fmt.Println("       gfast retry <token>")
+		fmt.Println("       gfast plan <source> <dest> [-o plan.json]")
+		fmt.Println("       gfast verify <source> <dest> [-o report.json] [-size-only]")
+		fmt.Println("       gfast presign <source> [s3://dest] [-expires 1h] [-o transfers.jsonl]")
+		fmt.Println("       gfast presigned <transfers.jsonl> [-local dir]")
+		fmt.Println("       gfast cleanup <s3://bucket/prefix> [-older-than 24h] [-dry-run]")
 		fmt.Println("\nOptions:")
-		flag.PrintDefaults()
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
 		fmt.Println("\nExamples:")
 		fmt.Println("  gfast -source /data/old -dest /data/new -streams 64")
 		fmt.Println("  gfast -source /data/local -dest s3://bucket/prefix -streams 32")
-		os.Exit(1)
+		fmt.Println("  gfast -source /mnt/nas1 -source /mnt/nas2 -dest /data/merged -source-collisions newest")
+		fmt.Println("  gfast -source /data/share -dest s3://dr-bucket/share -dest /cache/share")
+		return 1
+	}
+
+	chaosConfig, err := provider.ParseChaosConfig(chaos)
+	if err != nil {
+		log.Printf("Invalid -chaos: %v", err)
+		return 2
+	}
+
+	metaErrPolicy, err := provider.ParseMetadataErrorPolicy(metaErrors)
+	if err != nil {
+		log.Printf("Invalid -metadata-errors: %v", err)
+		return 2
+	}
+	sidecarMode, err := provider.ParseSidecarMode(sidecar)
+	if err != nil {
+		log.Printf("Invalid -metadata-sidecar: %v", err)
+		return 2
+	}
+	srcSidecarMode, err := provider.ParseSidecarMode(srcSidecar)
+	if err != nil {
+		log.Printf("Invalid -source-sidecars: %v", err)
+		return 2
+	}
+	collisionPolicy, err := provider.ParseCollisionPolicy(collisions)
+	if err != nil {
+		log.Printf("Invalid -source-collisions: %v", err)
+		return 2
+	}
+	if len(sources) > 1 && srcSidecarMode == provider.SidecarManifest {
+		log.Printf("Cannot use -source-sidecars manifest with several -source paths")
+		return 2
+	}
+	walkErrPolicy, err := engine.ParseWalkErrorPolicy(walkErrs)
+	if err != nil {
+		log.Printf("Invalid -walk-errors: %v", err)
+		return 2
+	}
+	sourceChanges, err := engine.ParseSourceChangePolicy(srcChanges)
+	if err != nil {
+		log.Printf("Invalid -source-changes: %v", err)
+		return 2
+	}
+	errorBudget, err := engine.ParseErrorBudget(errBudget)
+	if err != nil {
+		log.Printf("Invalid -error-budget: %v", err)
+		return 2
+	}
+	symlinkPolicy, err := engine.ParseSymlinkPolicy(symlinks)
+	if err != nil {
+		log.Printf("Invalid -symlinks: %v", err)
+		return 2
+	}
+	mtimePolicy, err := engine.ParseMTimePolicy(mtimeMode)
+	if err != nil {
+		log.Printf("Invalid -mtime-policy: %v", err)
+		return 2
+	}
+	schedulePolicy, err := engine.ParseSchedulePolicy(schedule)
+	if err != nil {
+		log.Printf("Invalid -schedule: %v", err)
+		return 2
+	}
+	compareMode, err := engine.ParseCompareMode(compare)
+	if err != nil {
+		log.Printf("Invalid -compare: %v", err)
+		return 2
+	}
+	bwRate, err := engine.ParseRate(bwLimit)
+	if err != nil {
+		log.Printf("Invalid -bwlimit: %v", err)
+		return 2
+	}
+	streamRate, err := engine.ParseRate(streamBW)
+	if err != nil {
+		log.Printf("Invalid -stream-bwlimit: %v", err)
+		return 2
+	}
+	pathLimits, err := engine.ParsePathLimits(pathLimit)
+	if err != nil {
+		log.Printf("Invalid -path-streams: %v", err)
+		return 2
+	}
+	chunked := engine.ChunkedOptions{Streams: largeSplit}
+	if chunked.Threshold, err = engine.ParseSize(largeFile); err != nil {
+		log.Printf("Invalid -large-file-threshold: %v", err)
+		return 2
+	}
+	if chunked.ChunkSize, err = engine.ParseSize(largeChunk); err != nil || chunked.ChunkSize <= 0 {
+		log.Printf("Invalid -large-file-chunk-size: %q", largeChunk)
+		return 2
+	}
+	memoryLimit, err := engine.ParseSize(memLimit)
+	if err != nil {
+		log.Printf("Invalid -memory-limit: %v", err)
+		return 2
+	}
+	bundling := engine.BundleOptions{MaxFiles: bundleN}
+	if bundling.Threshold, err = engine.ParseSize(bundleMax); err != nil {
+		log.Printf("Invalid -bundle-threshold: %v", err)
+		return 2
+	}
+	if bundling.MaxBytes, err = engine.ParseSize(bundleSize); err != nil {
+		log.Printf("Invalid -bundle-size: %v", err)
+		return 2
+	}
+	if len(dests) > 1 && bundling.Threshold > 0 {
+		log.Printf("Cannot use -bundle-threshold with several -dest paths")
+		return 2
+	}
+	var filter engine.FileFilter
+	if minSize != "" {
+		if filter.MinSize, err = engine.ParseSize(minSize); err != nil {
+			log.Printf("Invalid -min-size: %v", err)
+			return 2
+		}
+	}
+	if maxSize != "" {
+		if filter.MaxSize, err = engine.ParseSize(maxSize); err != nil {
+			log.Printf("Invalid -max-size: %v", err)
+			return 2
+		}
+	}
+	if minAge != "" {
+		if filter.MinAge, err = engine.ParseAge(minAge); err != nil {
+			log.Printf("Invalid -min-age: %v", err)
+			return 2
+		}
+	}
+	if maxAge != "" {
+		if filter.MaxAge, err = engine.ParseAge(maxAge); err != nil {
+			log.Printf("Invalid -max-age: %v", err)
+			return 2
+		}
+	}
+	restoreTier, err := provider.ParseRestoreTier(restoreTr)
+	if err != nil {
+		log.Printf("Invalid -restore-tier: %v", err)
+		return 2
+	}
+	contentTypes, err := provider.ParseContentTypeMap(typeMap)
+	if err != nil {
+		log.Printf("Invalid -content-type-map: %v", err)
+		return 2
+	}
+
+	validationRules, err := engine.ParseValidationRules(validate)
+	if err != nil {
+		log.Printf("Invalid -validate: %v", err)
+		return 2
+	}
+	var transformKey []byte
+	if transKey != "" {
+		if transformKey, err = engine.LoadEncryptionKey(transKey); err != nil {
+			log.Printf("Invalid -transform-key: %v", err)
+			return 2
+		}
+	}
+	transformRules, err := engine.ParseTransformRules(transform, engine.BuiltinTransforms(transformKey))
+	if err != nil {
+		log.Printf("Invalid -transform: %v", err)
+		return 2
+	}
+
+	var hooks engine.JobHooks
+	if onComplete != "" {
+		if hooks.OnComplete, err = engine.ParseHook(onComplete); err != nil {
+			log.Printf("Invalid -on-complete: %v", err)
+			return 2
+		}
+	}
+	if onFailure != "" {
+		if hooks.OnFailure, err = engine.ParseHook(onFailure); err != nil {
+			log.Printf("Invalid -on-failure: %v", err)
+			return 2
+		}
+	}
+
+	if autoTune && (tuneMin < 1 || (tuneMax > 0 && tuneMax < tuneMin)) {
+		log.Printf("Invalid -auto-tune-min/-auto-tune-max: need 1 <= min <= max")
+		return 2
+	}
+
+	manifestFormat, err := engine.ParseManifestFormat(manifestAs)
+	if err != nil {
+		log.Printf("Invalid -manifest-format: %v", err)
+		return 2
 	}
 
 	// Create state directory
 	if err := os.MkdirAll(stateDir, 0755); err != nil {
-		log.Fatalf("Failed to create state directory: %v", err)
+		log.Printf("Failed to create state directory: %v", err)
+		return 1
 	}
 
 	// Initialize state store
 	stateStorePath := filepath.Join(stateDir, "state.db")
 	stateStore, err := store.NewBoltStore(stateStorePath)
 	if err != nil {
-		log.Fatalf("Failed to initialize state store: %v", err)
+		log.Printf("Failed to initialize state store: %v", err)
+		return 1
 	}
 	defer stateStore.Close()
 
+	// Record the run so it can be resumed with the same options, and print
+	// its token however we exit from here on
+	run, err := loadOrCreateRun(stateStore, runID, args)
+	if err != nil {
+		log.Printf("Failed to record run: %v", err)
+		return 1
+	}
+	defer printResumeToken(run.ID, stateDir)
+
 	// Initialize job tracker
 	jobTracker := engine.NewJobTracker(stateStore, engine.DefaultCheckpointConfig)
 
-	// Create source provider
-	srcProvider, err := createProvider(source, !noMetadata)
-	if err != nil {
-		log.Fatalf("Failed to create source provider: %v", err)
+	var ring *provider.IOUring
+	if ioUring {
+		if uringSize <= 0 {
+			log.Printf("Invalid -io-uring-entries: must be positive")
+			return 2
+		}
+		// Each stream reads into and writes from one pool buffer
+		ring, err = provider.NewIOUring(provider.IOUringOptions{
+			Entries:      uringSize,
+			SQPoll:       uringPoll,
+			FixedBuffers: 2 * streams,
+		})
+		if err != nil {
+			log.Printf("Warning: io_uring unavailable, using ordinary I/O: %v", err)
+		} else {
+			defer ring.Close()
+		}
+	}
+
+	// Create source providers; several are merged into a single tree
+	var members []provider.UnionMember
+	for _, source := range sources {
+		p, root, err := createProvider(source, !noMetadata, srcProfile)
+		if err != nil {
+			log.Printf("Failed to create source provider for %s: %v", source, err)
+			return 1
+		}
+		if local, ok := p.(*provider.LocalProvider); ok {
+			local.WithDirectIO(directIO).
+				WithStrictSymlinks(strictLink)
+			if ring != nil {
+				local.WithIOUring(ring)
+			}
+		}
+		if s3Provider, ok := p.(*provider.S3Provider); ok {
+			s3Provider.WithTags(!noTags)
+		}
+		members = append(members, provider.UnionMember{Provider: p, Root: root})
+	}
+	srcProvider, srcRoot := members[0].Provider, members[0].Root
+	collided := new(atomic.Int64)
+	if len(members) > 1 {
+		srcProvider = provider.NewUnionProvider(collisionPolicy, members...).
+			WithCollisionHandler(func(c provider.Collision) {
+				collided.Add(1)
+				var in []string
+				for _, i := range c.Sources {
+					in = append(in, sources[i])
+				}
+				if c.Winner < 0 {
+					log.Printf("Skipping %s: it exists in %s", c.Path, strings.Join(in, ", "))
+				} else {
+					log.Printf("Copying %s from %s: it exists in %s", c.Path, sources[c.Winner], strings.Join(in, ", "))
+				}
+			})
+		srcRoot = ""
 	}
 
-	// Create destination provider
-	dstProvider, err := createProvider(dest, !noMetadata)
+	// Create destination providers; files are written to the first and
+	// replicated to the others
+	configureDest := func(p provider.Provider) {
+		if local, ok := p.(*provider.LocalProvider); ok {
+			local.WithMetadataErrorPolicy(metaErrPolicy).
+				WithSparseWrites(sparse).
+				WithDirectIO(directIO).
+				WithFsync(fsync).
+				WithStrictSymlinks(strictLink).
+				WithAccessTimes(keepATime).
+				WithBirthTimes(keepBTime)
+			if ring != nil {
+				local.WithIOUring(ring)
+			}
+		}
+		if s3Provider, ok := p.(*provider.S3Provider); ok {
+			s3Provider.WithTags(!noTags).
+				WithContentTypes(contentTypes)
+		}
+	}
+	dstProvider, dstRoot, err := createProvider(dests[0], !noMetadata, dstProfile)
 	if err != nil {
-		log.Fatalf("Failed to create destination provider: %v", err)
+		log.Printf("Failed to create destination provider: %v", err)
+		return 1
+	}
+	configureDest(dstProvider)
+	var replicas []engine.Replica
+	for _, dest := range dests[1:] {
+		profile := ""
+		if strings.HasPrefix(dest, "s3://") {
+			profile = dstProfile
+		}
+		p, root, err := createProvider(dest, !noMetadata, profile)
+		if err != nil {
+			log.Printf("Failed to create destination provider for %s: %v", dest, err)
+			return 1
+		}
+		configureDest(p)
+		replicas = append(replicas, engine.Replica{Name: dest, Provider: p, Root: root})
+	}
+	if keepBTime && !provider.BirthTimesSupported {
+		log.Printf("Warning: birth times cannot be set on %s; -preserve-btime has no effect", runtime.GOOS)
+	}
+
+	// Replayed versions only keep their order in a bucket that keeps them
+	if versions {
+		if srcSidecarMode != provider.SidecarNone {
+			log.Printf("Cannot use -all-versions with -source-sidecars")
+			return 2
+		}
+		if err := checkVersioned(context.Background(), srcProvider, dstProvider); err != nil {
+			log.Printf("Cannot use -all-versions: %v", err)
+			return 2
+		}
+	}
+
+	if versions && len(replicas) > 0 {
+		log.Printf("Cannot use -all-versions with several -dest paths")
+		return 2
+	}
+	if failedOnly && (mirror || mirrorDry || versions) {
+		log.Printf("Cannot use -failed-only with -delete, -delete-dry-run or -all-versions")
+		return 2
+	}
+	if snapshot && (failedOnly || versions) {
+		log.Printf("Cannot use -snapshot with -failed-only or -all-versions")
+		return 2
+	}
+	if mirror && !mirrorDry && !provider.CapabilitiesOf(dstProvider).Delete {
+		log.Printf("Cannot use -delete: the destination does not support deleting files")
+		return 2
+	}
+
+	// Abort the uploads crashed runs left behind, keeping those this run
+	// can still resume
+	if staleAge > 0 {
+		abortStaleUploads(context.Background(), dstProvider, dstRoot, staleAge, jobTracker, false)
+	}
+
+	_, srcS3 := srcProvider.(*provider.S3Provider)
+	_, dstS3 := dstProvider.(*provider.S3Provider)
+
+	// Injected faults sit directly on the backends so that everything
+	// above them, retries included, is exercised
+	if chaos != "" {
+		srcProvider = provider.WithChaos(srcProvider, chaosConfig)
+		dstProvider = provider.WithChaos(dstProvider, chaosConfig)
+	}
+
+	// Measure each backend directly, beneath retries and throttling, so
+	// latencies and error rates reflect the storage itself
+	srcMetrics, dstMetrics := provider.NewMetrics(), provider.NewMetrics()
+	srcProvider = provider.WithMetrics(srcProvider, srcMetrics)
+	dstProvider = provider.WithMetrics(dstProvider, dstMetrics)
+
+	// Adapt the streams against each bucket beneath the retries, so every
+	// throttled attempt counts and a backing-off stream gives up its slot
+	if adaptive {
+		if srcS3 {
+			srcProvider = provider.WithAdaptiveConcurrency(srcProvider, throttlingLimiter("source", streams))
+		}
+		if dstS3 {
+			dstProvider = provider.WithAdaptiveConcurrency(dstProvider, throttlingLimiter("destination", streams))
+		}
+	}
+
+	// Retry transient backend errors uniformly for every provider
+	retryPolicy := provider.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = retries
+	srcProvider = provider.WithRetry(srcProvider, retryPolicy)
+	dstProvider = provider.WithRetry(dstProvider, retryPolicy)
+	for i := range replicas {
+		replicas[i].Provider = provider.WithRetry(replicas[i].Provider, retryPolicy)
+	}
+
+	// Memoize Stat/List above the retries so cache hits skip the backend
+	if cacheTTL > 0 {
+		srcCache := provider.WithCache(srcProvider, cacheTTL)
+		dstCache := provider.WithCache(dstProvider, cacheTTL)
+		if cacheSave {
+			srcCacheFile := filepath.Join(stateDir, "cache-source.json")
+			dstCacheFile := filepath.Join(stateDir, "cache-dest.json")
+			for _, c := range []struct {
+				cache *provider.CachingProvider
+				file  string
+			}{{srcCache, srcCacheFile}, {dstCache, dstCacheFile}} {
+				if err := c.cache.Load(c.file); err != nil {
+					log.Printf("Warning: ignoring cache: %v", err)
+				}
+			}
+			defer func() {
+				if err := srcCache.Save(srcCacheFile); err != nil {
+					log.Printf("Failed to save cache: %v", err)
+				}
+				if err := dstCache.Save(dstCacheFile); err != nil {
+					log.Printf("Failed to save cache: %v", err)
+				}
+			}()
+		}
+		srcProvider, dstProvider = srcCache, dstCache
+	}
+
+	// Sidecars sit above the retries and the cache, so that their own
+	// reads and writes are retried and cached like any other
+	var dstSidecars *provider.SidecarProvider
+	if srcSidecarMode != provider.SidecarNone {
+		srcProvider = provider.WithSidecars(srcProvider, srcSidecarMode, srcRoot)
+	}
+	if sidecarMode != provider.SidecarNone && !noMetadata {
+		dstSidecars = provider.WithSidecars(dstProvider, sidecarMode, dstRoot)
+		dstProvider = dstSidecars
+	}
+
+	warnCapabilities(srcProvider, dstProvider, !noMetadata, symlinkPolicy)
+
+	// Every copied byte is read from the source once, so throttling the
+	// source alone caps the whole migration, including scrub reads
+	if bwRate > 0 {
+		srcProvider = provider.WithThrottle(srcProvider, provider.NewTokenBucket(bwRate))
 	}
 
 	// Create buffer pool
 	bufferPool := engine.NewBufferPool(bufferSize)
+	if directIO {
+		bufferPool = engine.NewAlignedBufferPool(bufferSize, provider.DirectIOAlignment)
+	}
 
 	// Job channel for work distribution
 	jobChan := make(engine.JobChannel, 1000)
@@ -97,12 +733,32 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// TUI state
-	tuiState := &ui.UIState{
-		ActiveStreams: make([]*ui.ActiveStream, 0),
-		MaxWorkers:    streams,
-		ActiveWorkers: streams,
-		IsRunning:     true,
+	// Throughput is measured from the bytes written to the destination,
+	// and per stream from the bytes read from each source
+	meter := engine.NewMeter(engine.DefaultMeterWindow)
+	meter.Bytes = func() int64 { return dstMetrics.Op(provider.OpWrite).Bytes }
+	go meter.Run(ctx, time.Second)
+
+	// The run's progress is published as events, counted into the stats
+	// the TUI, progress log, control API and summary all show
+	events := &engine.EventBus{}
+	stats := engine.NewRunStats(meter)
+	events.Subscribe(stats.Handle)
+	tracker := ui.NewStateTracker(stats, streams)
+	go publishProgress(ctx, events, meter, 500*time.Millisecond)
+
+	// Workers can be paused from the TUI or with SIGUSR1, and resumed with
+	// SIGUSR2, to yield I/O to other workloads
+	pauses := &pauseControl{state: tracker}
+	// startPool readies each pool of the run: its own limits, since a
+	// stopped pool may leave jobs parked, the drain on interrupt, and the
+	// pause control
+	startPool := func(pool *engine.WorkerPool) {
+		if len(pathLimits) > 0 {
+			pool.SetPathLimits(engine.NewPathLimits(pathLimits))
+		}
+		pool.SetDrainTimeout(drain)
+		pauses.add(pool)
 	}
 
 	// Create TUI model
@@ -110,7 +766,8 @@ func main() {
 	var teaProgram *tea.Program
 
 	if tuiEnabled {
-		tuiModel = ui.NewTUIModel(tuiState)
+		tuiModel = ui.NewTUIModel(tracker.State())
+		tuiModel.TogglePause = pauses.toggle
 		teaProgram = tea.NewProgram(tuiModel, tea.WithAltScreen())
 
 		// Start TUI update loop
@@ -123,21 +780,302 @@ func main() {
 					return
 				case <-ticker.C:
 					// Send update to TUI
-					teaProgram.Send(ui.TUIUpdateMsg{State: tuiState})
+					teaProgram.Send(ui.TUIUpdateMsg{State: tracker.State()})
 				}
 			}
 		}()
+	} else if progressIv > 0 {
+		go logProgress(ctx, stats, progressIv)
 	}
 
 	// Handle signals for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+	signal.Notify(sigChan, runSignals...)
+
+	// Each stream gets a limit of its own when one is set or may be set
+	// through the control API
+	var streamLimits *engine.StreamLimits
+	if streamRate > 0 || control != "" {
+		streamLimits = engine.NewStreamLimits(streamRate)
+	}
+	if control != "" {
+		ln, err := net.Listen("tcp", control)
+		if err != nil {
+			log.Printf("Failed to start control API: %v", err)
+			return 1
+		}
+		go func() {
+			if err := serveControl(ctx, ln, streamLimits, meter, stats); err != nil {
+				log.Printf("Control API error: %v", err)
+			}
+		}()
+	}
+
+	opts := transferOptions{
+		streamLimits:     streamLimits,
+		meter:            meter,
+		chunked:          chunked,
+		memory:           engine.NewMemoryBudget(memoryLimit),
+		bundledFiles:     new(atomic.Int64),
+		bundles:          new(atomic.Int64),
+		checksum:         checksum,
+		validation:       validationRules,
+		transforms:       transformRules,
+		events:           events,
+		metadataWarnings: new(atomic.Int64),
+		restarts:         new(atomic.Int64),
+		sparse:           sparse,
+		sourceChanges:    sourceChanges,
+		sourceChanged:    new(atomic.Int64),
+		inconsistent:     new(atomic.Int64),
+		sparseFiles:      new(atomic.Int64),
+		mtimes:           engine.MTimeChecker{Policy: mtimePolicy, Skew: clockSkew},
+		mtimeAdjusted:    new(atomic.Int64),
+		skipCompleted:    !recopy,
+		skipped:          new(atomic.Int64),
+		update:           update || compareMode == engine.CompareChecksum,
+		compare:          compareMode,
+		mtimeWindow:      mtimeWin,
+		upToDate:         new(atomic.Int64),
+		restores: &engine.RestoreQueue{
+			Src:      srcProvider,
+			Tracker:  jobTracker,
+			Request:  restore,
+			Options:  provider.RestoreOptions{Tier: restoreTier, Days: int32(restoreDay)},
+			Interval: restorePol,
+		},
+	}
+
+	// Every file is written to the replicas too, each tracked on its own
+	if len(replicas) > 0 {
+		opts.fanOut = &engine.FanOut{Root: dstRoot, Replicas: replicas, Tracker: jobTracker}
+	}
+
+	// Bundles are named after the run and when this attempt started, so a
+	// resumed run does not overwrite its earlier bundles and later copies
+	// of a file take precedence
+	if bundling.Threshold > 0 {
+		opts.bundler = &engine.Bundler{
+			Dst:     dstProvider,
+			Root:    dstRoot,
+			Prefix:  time.Now().UTC().Format("20060102T150405") + "-" + run.ID,
+			Options: bundling,
+			Streams: streams,
+		}
+	}
+
+	// Content already at the destination is found by size and checksum
+	if dedupLink && !provider.CapabilitiesOf(dstProvider).Hardlinks {
+		log.Printf("Warning: the destination cannot hard link files; -dedup-hardlink copies them instead")
+	}
+	if dedup || dedupLink {
+		index, err := engine.BuildDedupIndex(ctx, dstProvider, dstRoot)
+		if err != nil {
+			log.Printf("Failed to index destination for -dedup: %v", err)
+			return 1
+		}
+		index.Hardlink = dedupLink
+		log.Printf("Indexed %d destination files for -dedup", index.Len())
+		opts.dedup = index
+		opts.deduped = new(atomic.Int64)
+		opts.dedupBytes = new(atomic.Int64)
+	}
+
+	// Transferred files are listed in the manifest as they complete, or by
+	// path with -deterministic; it replaces any earlier manifest once the
+	// run ends
+	var manifestFile *engine.ManifestFile
+	if manifest != "" {
+		if manifestFile, err = engine.CreateManifestFile(manifest, manifestFormat, determ); err != nil {
+			log.Printf("Failed to create -manifest: %v", err)
+			return 1
+		}
+	}
+	addToManifest := func(job engine.TransferJob, checksum string) {
+		if manifestFile == nil {
+			return
+		}
+		if err := manifestFile.Write(engine.TransferManifestEntry(job, dstRoot, checksum)); err != nil {
+			log.Printf("Failed to add %s to the manifest: %v", job.DestinationPath, err)
+		}
+	}
 
 	// Worker pool
-	workerPool := engine.NewWorkerPool(ctx, jobChan, func(ctx context.Context, job engine.TransferJob) error {
-		return transferFile(ctx, job, srcProvider, dstProvider, jobTracker, bufferPool, checksum, tuiState)
-	})
+	var scrubber *engine.Scrubber
+	if scrub {
+		scrubber = engine.NewScrubber(srcProvider, dstProvider, bufferPool, 0)
+		scrubber.OnResult = func(job engine.TransferJob, err error) {
+			if err != nil {
+				log.Printf("Scrub failed for %s: %v", job.DestinationPath, err)
+				jobTracker.MarkFailed(job.ID, fmt.Errorf("scrub: %w", err))
+			}
+		}
+	}
+
+	queueBudget := engine.NewQueueBudget(queueMem)
+
+	// Files that fail are retried once the rest have been transferred
+	failed := &engine.FailedJobs{}
+
+	// Failures beyond the error budget abort the run, which can then be
+	// resumed once their cause is fixed
+	failures := &engine.FailureCounter{Budget: errorBudget}
+	countFailure := func(err error) {
+		if ctx.Err() != nil || !failures.Record(err) {
+			return
+		}
+		n, files := failures.Counts()
+		log.Printf("Aborting: %d of %d files failed, exhausting -error-budget %s", n, files, errorBudget)
+		cancel()
+	}
+
+	// Snapshot runs record the source listing in the state store and
+	// transfer from it
+	var snap *engine.Snapshot
+	if snapshot {
+		snap = &engine.Snapshot{Store: stateStore, RunID: run.ID}
+	}
+
+	handler := func(ctx context.Context, job engine.TransferJob) error {
+		var result transferResult
+		// Jobs take the ID an earlier run recorded for the same file, and
+		// only one job writes a destination at a time
+		job, err := jobTracker.Identify(job)
+		if err != nil {
+			log.Printf("Failed to look up the state of %s: %v", job.SourcePath, err)
+		}
+		release, err := jobTracker.Claim(job)
+		if err != nil {
+			if ctx.Err() == nil {
+				events.Publish(engine.JobFailed{Job: job, Err: err})
+				failed.Add(job)
+			}
+			return err
+		}
+		defer release()
+		events.Publish(engine.JobStarted{Job: job})
+		if job.Versions != nil {
+			result, err = replayVersions(ctx, job, srcProvider, dstProvider, jobTracker, bufferPool, opts)
+		} else {
+			// Jobs queued under memory pressure carry no FileInfo
+			job, err = engine.EnsureFileInfo(ctx, srcProvider, job)
+			if err == nil {
+				result, err = transferFile(ctx, job, srcProvider, dstProvider, jobTracker, bufferPool, opts)
+			}
+		}
+		// Files a snapshot run listed may be gone by the time they are
+		// copied; the comparison at the end reports them
+		if err != nil && snap != nil && provider.IsNotExist(err) {
+			if _, statErr := srcProvider.Stat(ctx, job.SourcePath); provider.IsNotExist(statErr) {
+				var size int64
+				if job.FileInfo != nil {
+					size = job.FileInfo.Size()
+				}
+				events.Publish(engine.JobCompleted{Job: job, Bytes: size, Skipped: true})
+				return nil
+			}
+		}
+		// Parked jobs come back once their source is restored, skipped
+		// ones were dealt with by an earlier run, and bundled ones are
+		// reported when their bundle is stored
+		if result.parked || result.skipped || result.bundled {
+			return nil
+		}
+		// Server-side copies rely on the provider's integrity checks, the
+		// current version of a replayed history may be a delete marker, and
+		// transformed files differ from their source by design
+		if err == nil && scrubber != nil && !result.serverSide && !result.inconsistent && !result.transformed && job.Versions == nil {
+			scrubber.Enqueue(job)
+		}
+		if hookErr := hooks.Fire(ctx, job, result.checksum, err); hookErr != nil {
+			log.Printf("Hook error for %s: %v", job.SourcePath, hookErr)
+		}
+		if err == nil {
+			addToManifest(job, result.checksum)
+		}
+		if err != nil && ctx.Err() == nil {
+			// A source that changed is stated afresh when retried
+			var modified *engine.SourceModifiedError
+			if errors.As(err, &modified) {
+				job.FileInfo = nil
+			}
+			events.Publish(engine.JobFailed{Job: job, Err: err})
+			failed.Add(job)
+		}
+		return err
+	}
+	if opts.bundler != nil {
+		opts.bundler.OnCommit = func(jobs []engine.TransferJob, err error) {
+			if err == nil {
+				opts.bundles.Add(1)
+			}
+			for _, job := range jobs {
+				jobErr := err
+				if jobErr == nil {
+					jobErr = jobTracker.MarkCompleted(job.ID)
+				} else {
+					jobTracker.MarkFailed(job.ID, jobErr)
+				}
+				if jobErr == nil {
+					opts.bundledFiles.Add(1)
+					addToManifest(job, "")
+					events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size()})
+				} else if ctx.Err() == nil {
+					events.Publish(engine.JobFailed{Job: job, Err: jobErr})
+					failed.Add(job)
+					countFailure(jobErr)
+				}
+				if hookErr := hooks.Fire(ctx, job, "", jobErr); hookErr != nil {
+					log.Printf("Hook error for %s: %v", job.SourcePath, hookErr)
+				}
+			}
+		}
+	}
+	// The main pool counts its files against the error budget; retry
+	// sweeps only work off the failures it left
+	poolHandler := func(ctx context.Context, job engine.TransferJob) error {
+		err := handler(ctx, job)
+		countFailure(err)
+		return err
+	}
+
+	// The auto-tuner measures the bytes written to the destination and
+	// how long each job of the main pool takes
+	var tuner *engine.AutoTuner
+	if autoTune {
+		if tuneMax <= 0 {
+			tuneMax = 4 * streams
+		}
+		tuner = &engine.AutoTuner{
+			Min:   tuneMin,
+			Max:   tuneMax,
+			Bytes: func() int64 { return dstMetrics.Op(provider.OpWrite).Bytes },
+			OnChange: func(workers int, throughput float64) {
+				log.Printf("Auto-tune: %d streams after %.1f MB/s", workers, throughput/(1024*1024))
+				tracker.SetWorkers(workers)
+			},
+		}
+		counted := poolHandler
+		poolHandler = func(ctx context.Context, job engine.TransferJob) error {
+			start := time.Now()
+			err := counted(ctx, job)
+			tuner.Observe(time.Since(start))
+			return err
+		}
+	}
+	workerPool := engine.NewWorkerPool(ctx, engine.Schedule(ctx, jobChan, schedulePolicy, engine.DefaultScheduleWindow), poolHandler)
+	if scrubber != nil {
+		workerPool.SetIdleTask(scrubber.RunOnce, scrubber.Wake())
+	}
+	workerPool.SetQueueBudget(queueBudget)
+	startPool(workerPool)
 	workerPool.SetWorkerCount(streams)
+	tuneCtx, stopTuning := context.WithCancel(ctx)
+	defer stopTuning()
+	if tuner != nil {
+		tuner.Pool = workerPool
+		go tuner.Run(tuneCtx)
+	}
 
 	// Handle worker count changes from TUI
 	if tuiEnabled {
@@ -156,65 +1094,627 @@ func main() {
 
 	// Start walker
 	walker := engine.NewWalker(srcProvider, jobChan)
+	walker.Sorted = determ
+	walker.Flat = flatList
+	walker.Listers = listers
+	walker.Versions = versions
+	walker.Budget = queueBudget
+	walker.Memory = opts.memory
+	walker.Symlinks = symlinkPolicy
+	walker.ErrorPolicy = walkErrPolicy
+	walker.MaxDepth = maxDepth
+	walker.OneFileSystem = oneFS
+	// Directories are only recorded for destinations that have them of
+	// their own, as a bucket does not
+	var dirsFailed int
+	hasDirs := provider.CapabilitiesOf(dstProvider).Directories
+	for _, r := range replicas {
+		hasDirs = hasDirs || provider.CapabilitiesOf(r.Provider).Directories
+	}
+	if hasDirs {
+		walker.Dirs = &engine.DirSet{MTimes: engine.MTimeChecker{Policy: mtimePolicy, Skew: clockSkew}}
+		walker.Dirs.OnError = func(path string, err error) {
+			dirsFailed++
+			log.Printf("Failed to create directory %s: %v", path, err)
+		}
+	}
+	walker.OnError = func(e *engine.WalkError) {
+		log.Printf("Skipping unreadable %s", e)
+		events.Publish(e)
+	}
+	if filter.Active() {
+		walker.Filter = &filter
+	}
+	if mirror || mirrorDry {
+		walker.Seen = engine.NewPathSet()
+	}
+	// Mirror the state to the destination so the run survives the loss of
+	// this host
+	var replicator *engine.StateReplicator
+	if replicate > 0 {
+		replicator = &engine.StateReplicator{
+			State:    stateStore,
+			Dst:      dstProvider,
+			Path:     engine.StateReplicaPath(dstRoot),
+			Interval: replicate,
+		}
+		go replicator.Run(ctx)
+	}
+
 	walkCtx, walkCancel := context.WithCancel(ctx)
 
-	// Start walking in background
+	// Start walking in background. walkErr is read once walked is closed.
+	var walkErr error
+	walked := make(chan struct{})
 	go func() {
+		defer close(walked)
 		defer walkCancel()
 		defer close(jobChan)
 
-		// Determine destination root
-		destRoot := dest
-		if filepath.Separator == '/' && len(dest) > 0 && dest[0] != '/' {
-			// Relative path, keep as is
+		// Totals grow as files are queued, unless a pre-scan counted them
+		var queuedFiles, queuedBytes int64
+		prescanned := false
+		defer func() {
+			if !prescanned {
+				events.Publish(engine.ScanFinished{Files: queuedFiles, Bytes: queuedBytes})
+			}
+		}()
+		countQueued := func(job engine.TransferJob) {
+			queuedFiles++
+			if job.FileInfo != nil {
+				queuedBytes += job.FileInfo.Size()
+			}
+			events.Publish(engine.JobQueued{Job: job})
 		}
-
-		if err := walker.Walk(walkCtx, source, destRoot); err != nil {
-			log.Printf("Walker error: %v", err)
+		walker.OnQueue = countQueued
+		if failedOnly {
+			walkErr = queueFailed(walkCtx, jobTracker, opts.fanOut, jobChan, countQueued)
+		} else if snap != nil && !run.ListedAt.IsZero() {
+			log.Printf("Transferring the source as listed at %s", run.ListedAt.Format(time.RFC3339))
+			walkErr = snap.Queue(walkCtx, walker)
+		} else if snap != nil {
+			if files, bytes, err := snap.Record(walkCtx, walker, srcRoot, dstRoot); err != nil {
+				walkErr = fmt.Errorf("listing: %w", err)
+			} else {
+				log.Printf("Listed %d files (%d bytes); transferring them as listed", files, bytes)
+				events.Publish(engine.ScanFinished{Files: files, Bytes: bytes})
+				prescanned = true
+				run.ListedAt = time.Now()
+				if err := stateStore.SaveRun(run); err != nil {
+					log.Printf("Failed to record run: %v", err)
+				}
+				walkErr = snap.Queue(walkCtx, walker)
+			}
+		} else if !prescan {
+			walkErr = walker.Walk(walkCtx, srcRoot, dstRoot)
+		} else if files, bytes, err := walker.Prescan(walkCtx, srcRoot); err != nil {
+			walkErr = fmt.Errorf("pre-scan: %w", err)
+		} else {
+			log.Printf("Pre-scan found %d files (%d bytes)", files, bytes)
+			events.Publish(engine.ScanFinished{Files: files, Bytes: bytes})
+			prescanned = true
+			walkErr = walker.Walk(walkCtx, srcRoot, dstRoot)
+		}
+		if walkErr != nil {
+			log.Printf("Walker error: %v", walkErr)
 		}
 	}()
 
 	// Wait for completion or signal
 	done := make(chan struct{})
 	go func() {
-		<-sigChan
-		cancel()
-		close(done)
+		for sig := range sigChan {
+			if pause, ok := pauseSignal(sig); ok {
+				if pause && pauses.set(true) {
+					log.Printf("Paused: running files finish, no new ones start until SIGUSR2")
+				} else if !pause && pauses.set(false) {
+					log.Printf("Resumed")
+				}
+				continue
+			}
+			if ctx.Err() == nil && drain > 0 {
+				log.Printf("Interrupted: draining, running files have %s to finish; signal again to abort them", drain)
+				cancel()
+				continue
+			}
+			if drain > 0 {
+				log.Printf("Interrupted again: aborting the files still running")
+			}
+			cancel()
+			pauses.abort()
+			close(done)
+			return
+		}
 	}()
 
-	// Wait for jobs to complete
-	<-walkCtx.Done()
+	// Wait for every queued file to finish or fail. The walk closes the
+	// queue once done; an interrupted run leaves the files not yet started,
+	// giving those running the -drain timeout.
+	workerPool.Wait()
+	<-walked
 	workerPool.Stop()
+	stopTuning()
+
+	// Transfer archived files as their restores complete
+	if n := opts.restores.Parked(); n > 0 && ctx.Err() == nil {
+		log.Printf("Waiting for %d archived files to be restored", n)
+		restoredChan := make(engine.JobChannel)
+		restorePool := engine.NewWorkerPool(ctx, restoredChan, handler)
+		startPool(restorePool)
+		restorePool.SetWorkerCount(streams)
+		opts.restores.Release(ctx, restoredChan)
+		restorePool.Wait()
+	}
+
+	// Store the bundles still open, even when interrupted, so the files
+	// already in them are not lost
+	if opts.bundler != nil {
+		opts.bundler.Close(context.WithoutCancel(ctx))
+	}
 
+	// Sweep up the files that failed, which often succeed once transient
+	// trouble has passed
+	sweepPasses := 0
+	if n := failed.Len(); n > 0 && sweeps > 0 && ctx.Err() == nil {
+		log.Printf("Retrying %d failed files", n)
+		sweepPasses = engine.RetrySweep(ctx, failed, handler, streams, sweeps, startPool)
+		if opts.bundler != nil {
+			opts.bundler.Close(context.WithoutCancel(ctx))
+		}
+	}
+
+	events.Publish(engine.RunFinished{Interrupted: ctx.Err() != nil})
 	if tuiEnabled {
-		tuiState.Done = true
-		tuiState.IsRunning = false
-		teaProgram.Send(ui.TUIUpdateMsg{State: tuiState})
+		teaProgram.Send(ui.TUIUpdateMsg{State: tracker.State()})
 		time.Sleep(200 * time.Millisecond)
 		teaProgram.Quit()
 	}
 
+	// Snapshot runs report the files that appeared at or disappeared from
+	// the source since it was listed
+	var changes engine.SnapshotChanges
+	var compareErr error
+	if snap != nil && walkErr == nil && ctx.Err() == nil {
+		log.Printf("Comparing the source with its listing")
+		if changes, compareErr = snap.Compare(ctx, walker, srcRoot, dstRoot); compareErr != nil {
+			log.Printf("%v", compareErr)
+		}
+		for _, p := range changes.Appeared {
+			log.Printf("Appeared since the listing, not transferred: %s", p)
+		}
+		for _, p := range changes.Disappeared {
+			log.Printf("Disappeared since the listing: %s", p)
+		}
+	}
+
+	// Mirroring deletes what the walk did not find, so an incomplete walk
+	// would delete files that still exist at the source
+	var pruned []string
+	if walker.Seen != nil && ctx.Err() == nil {
+		if walkErr != nil {
+			log.Printf("Not deleting extraneous files: the source walk did not complete")
+		} else if compareErr != nil {
+			log.Printf("Not deleting extraneous files: the source could not be compared with its listing")
+		} else if walker.SkippedErrors > 0 {
+			log.Printf("Not deleting extraneous files: the source walk skipped %d unreadable directories", walker.SkippedErrors)
+		} else {
+			var err error
+			pruned, err = engine.PruneDestination(ctx, dstProvider, dstRoot, walker.Seen, engine.MirrorOptions{DryRun: mirrorDry, MaxDelete: maxDelete})
+			if err != nil {
+				log.Printf("Failed to delete extraneous files: %v", err)
+			}
+			if mirrorDry {
+				for _, p := range pruned {
+					fmt.Printf("would delete %s\n", p)
+				}
+			}
+		}
+	}
+
+	interrupted := ctx.Err() != nil
+	run.EndedAt = time.Now()
+	run.Completed = !interrupted
+	if err := stateStore.SaveRun(run); err != nil {
+		log.Printf("Failed to record run: %v", err)
+	}
+	if manifestFile != nil {
+		if err := manifestFile.Commit(); err != nil {
+			log.Printf("%v", err)
+		} else {
+			log.Printf("Wrote a manifest of %d transferred files to %s", manifestFile.Count(), manifest)
+		}
+	}
+	if dstSidecars != nil {
+		if err := dstSidecars.Flush(context.Background()); err != nil {
+			log.Printf("Failed to write metadata sidecars: %v", err)
+		}
+	}
+	if replicator != nil {
+		if err := replicator.Replicate(context.Background()); err != nil {
+			log.Printf("State replication failed: %v", err)
+		}
+	}
+
+	// Directories are created, and their times set, once nothing more is
+	// written below them, so one the source has read-only does not refuse
+	// its files and the destination's directories stat as the source's
+	if !interrupted && walker.Dirs != nil {
+		walker.Dirs.Create(ctx, dstProvider, nil)
+		if opts.fanOut != nil {
+			for _, r := range opts.fanOut.Replicas {
+				walker.Dirs.Create(ctx, r.Provider, func(path string) (string, error) {
+					return opts.fanOut.ReplicaPath(r, path)
+				})
+			}
+		}
+	}
+
+	if failures.Exceeded() {
+		n, files := failures.Counts()
+		fmt.Printf("\nMigration aborted: %d of %d files failed (-error-budget %s); once the cause is fixed, continue with the resume token below\n", n, files, errorBudget)
+		return 1
+	}
+	st := stats.Stats()
+	if interrupted {
+		fmt.Println("\nMigration interrupted.")
+		printStats(st)
+		return 130
+	}
 	fmt.Println("\nMigration complete.")
+	printStats(st)
+	fmt.Printf("Source I/O:\n%sDestination I/O:\n%s", srcMetrics, dstMetrics)
+	if n := opts.skipped.Load(); n > 0 {
+		fmt.Printf("Skipped %d files completed by an earlier run\n", n)
+	}
+	if n := opts.upToDate.Load(); n > 0 {
+		fmt.Printf("Skipped %d files already up to date at the destination\n", n)
+	}
+	if n := st.Failed.Files; n > 0 {
+		fmt.Printf("%d files failed after %d retry passes; once the cause is fixed, retry them with gfast retry\n", n, sweepPasses)
+	}
+	if opts.dedup != nil {
+		if n := opts.deduped.Load(); n > 0 {
+			fmt.Printf("Deduplicated %d files (%d bytes) against content already at the destination\n", n, opts.dedupBytes.Load())
+		}
+	}
+	if n := opts.bundledFiles.Load(); n > 0 {
+		fmt.Printf("Bundled %d small files into %d bundles under %s\n", n, opts.bundles.Load(), engine.BundleDir)
+	}
+	if n := len(pruned); n > 0 {
+		if mirrorDry {
+			fmt.Printf("Would delete %d files no longer at the source\n", n)
+		} else {
+			fmt.Printf("Deleted %d files no longer at the source\n", n)
+		}
+	}
+	if n := len(changes.Appeared); n > 0 {
+		fmt.Printf("%d files appeared at the source after it was listed and were not transferred (see the log)\n", n)
+	}
+	if n := len(changes.Disappeared); n > 0 {
+		fmt.Printf("%d listed files disappeared from the source (see the log)\n", n)
+	}
+	if n := opts.sourceChanged.Load(); n > 0 {
+		fmt.Printf("Discarded %d copies of files whose source changed while they were copied\n", n)
+	}
+	if n := opts.inconsistent.Load(); n > 0 {
+		fmt.Printf("Kept %d inconsistent copies of files whose source changed while they were copied; later runs copy them again\n", n)
+	}
+	if n := opts.restarts.Load(); n > 0 {
+		fmt.Printf("Restarted %d partially transferred files whose source changed since the last checkpoint\n", n)
+	}
+	if n := opts.metadataWarnings.Load(); n > 0 {
+		fmt.Printf("Metadata could not be applied to %d files; see metadata_error in the state store\n", n)
+	}
+	if n := opts.mtimeAdjusted.Load(); n > 0 {
+		fmt.Printf("Found out-of-range mtimes on %d files (policy %s); see mtime_adjustment in the state store\n", n, mtimePolicy)
+	}
+	if dirsFailed > 0 {
+		fmt.Printf("Could not create, or apply the metadata of, %d directories (see the log)\n", dirsFailed)
+	}
+	if n := walker.Filtered; n > 0 {
+		fmt.Printf("Left out %d files by -min-size, -max-size, -min-age or -max-age\n", n)
+	}
+	if n := walker.SkippedMounts; n > 0 {
+		fmt.Printf("Left out %d entries on other filesystems (-one-file-system)\n", n)
+	}
+	if n := walker.SkippedErrors; n > 0 {
+		fmt.Printf("Skipped %d unreadable source directories or links (see the log)\n", n)
+	}
+	if n := collided.Load(); n > 0 {
+		fmt.Printf("Found %d paths in more than one source (policy %s; see the log)\n", n, collisionPolicy)
+	}
+	if n := walker.SkippedLinks; n > 0 {
+		fmt.Printf("Skipped %d symbolic links (policy %s)\n", n, symlinkPolicy)
+	}
+	if n := opts.sparseFiles.Load(); n > 0 {
+		fmt.Printf("Sparse files: %d, %d bytes of holes not transferred\n", n, st.SparseBytes)
+	}
+	if n := queueBudget.Stripped(); n > 0 {
+		fmt.Printf("Queue memory peaked at %d bytes; %d jobs re-read their metadata\n", queueBudget.Peak(), n)
+	}
+	if n := opts.memory.Waits(); n > 0 {
+		fmt.Printf("Transfer buffers peaked at %d bytes; %d transfers waited for memory\n", opts.memory.Peak(), n)
+	}
+	if scrubber != nil {
+		fmt.Printf("Scrubbed %d files: %d verified, %d failed, %d not scrubbed\n",
+			scrubber.Verified()+scrubber.Failed(), scrubber.Verified(), scrubber.Failed(),
+			int64(scrubber.Pending())+scrubber.Dropped())
+	}
+	if st.Failed.Files > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printStats prints the files a run got through, and how fast.
+func printStats(s engine.Stats) {
+	rate := 0.0
+	if secs := s.Elapsed.Seconds(); secs > 0 {
+		rate = float64(s.Completed.Bytes-s.Skipped.Bytes-s.SparseBytes) / secs
+	}
+	fmt.Printf("Completed %d of %d files (%d of %d bytes) in %s, %.1f MB/s on average\n",
+		s.Completed.Files, s.Total.Files, s.Completed.Bytes, s.Total.Bytes,
+		s.Elapsed.Round(time.Second), rate/(1024*1024))
+}
+
+// loadOrCreateRun returns the run to record progress under. An empty runID
+// starts a new run with args as its options; otherwise the existing run is
+// marked as resumed.
+func loadOrCreateRun(s *store.BoltStore, runID string, args []string) (*store.RunRecord, error) {
+	if runID == "" {
+		wd, _ := os.Getwd()
+		run := &store.RunRecord{
+			ID:        newRunID(),
+			Args:      args,
+			WorkDir:   wd,
+			StartedAt: time.Now(),
+		}
+		return run, s.SaveRun(run)
+	}
+
+	run, err := s.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	run.Resumes++
+	run.EndedAt = time.Time{}
+	run.Completed = false
+	return run, s.SaveRun(run)
+}
+
+// queueFailed queues a job for every file the state store records as
+// failed, in place of a walk, including those only a replica of fan failed
+// to store.
+func queueFailed(ctx context.Context, tracker *engine.JobTracker, fan *engine.FanOut, jobChan engine.JobChannel, onQueue func(engine.TransferJob)) error {
+	jobs, err := tracker.Failed()
+	if err != nil {
+		return fmt.Errorf("failed to list failed jobs: %w", err)
+	}
+	// A replica that failed is written again by its file's job, which is
+	// queued once for all of its destinations
+	queued := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Target != "" {
+			if fan == nil {
+				continue
+			}
+			primary, ok := fan.Primary(job)
+			if !ok {
+				continue
+			}
+			job = primary
+		}
+		if queued[job.DestinationPath] {
+			continue
+		}
+		queued[job.DestinationPath] = true
+		job.Ctx = ctx
+		select {
+		case jobChan <- job:
+			onQueue(job)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// printResumeToken tells the operator how to pick the run up again.
+func printResumeToken(runID, stateDir string) {
+	token := encodeResumeToken(runID, stateDir)
+	fmt.Fprintf(os.Stderr, "Resume token: %s\n  gfast resume %s\n", token, token)
+}
+
+// s3Defaults holds the S3 client settings from the -s3-* flags, or from the
+// GOFAST_S3_* environment variables for subcommands without those flags.
+// Provider profiles override them field by field.
+var s3Defaults = provider.S3Options{
+	Endpoint:     os.Getenv("GOFAST_S3_ENDPOINT"),
+	Region:       os.Getenv("GOFAST_S3_REGION"),
+	UsePathStyle: envBool("GOFAST_S3_PATH_STYLE"),
+}
+
+// envBool reports whether the environment variable name is set to a true
+// value as strconv.ParseBool reads it.
+func envBool(name string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(name))
+	return v
+}
+
+// pathList collects the values of a repeated path flag, such as -source.
+type pathList []string
+
+func (s *pathList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *pathList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
 }
 
-func createProvider(path string, withMetadata bool) (provider.Provider, error) {
+// createProvider builds the provider for a source or destination URL and
+// returns the root path to walk within it. S3 providers are already scoped to
+// their prefix, so their root is empty; local providers act on the path as
+// given and reject paths outside it.
+// profile, if set, is a JSON provider profile for an S3 provider, applied
+// over s3Defaults.
+func createProvider(path string, withMetadata bool, profile string) (provider.Provider, string, error) {
 	// Check if S3 path
 	if len(path) >= 5 && path[:5] == "s3://" {
 		ctx := context.Background()
+		var opts provider.S3Options
+		if profile != "" {
+			var err error
+			if opts, err = provider.LoadS3Options(profile); err != nil {
+				return nil, "", err
+			}
+		}
+		opts = opts.WithDefaults(s3Defaults)
 		// Parse s3://bucket/prefix
 		s3Path := path[5:] // Remove "s3://"
 		bucket, prefix, _ := strings.Cut(s3Path, "/")
-		return provider.NewS3Provider(ctx, bucket, prefix)
+		s3Provider, err := provider.NewS3ProviderWithOptions(ctx, bucket, prefix, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return s3Provider.WithMetadata(withMetadata), "", nil
+	}
+	if profile != "" {
+		return nil, "", fmt.Errorf("provider profile %s only applies to s3:// paths", profile)
 	}
 
-	// Local provider
-	localProvider := provider.NewLocalProvider("")
+	// Local provider, confined to the tree it was given
+	localProvider := provider.NewLocalProvider("").WithRoot(path)
 	if withMetadata {
 		localProvider.WithMetadataMapper(provider.NewMetadataMapper())
+	} else {
+		localProvider.WithMetadataMapper(nil)
+	}
+	return localProvider, path, nil
+}
+
+// warnCapabilities logs requested features that the chosen providers cannot
+// honour, so operators learn about them before the run rather than after.
+func warnCapabilities(src, dst provider.Provider, preserveMetadata bool, symlinks engine.SymlinkPolicy) {
+	srcCaps := provider.CapabilitiesOf(src)
+	dstCaps := provider.CapabilitiesOf(dst)
+
+	if preserveMetadata && !dstCaps.Metadata {
+		log.Printf("Warning: destination cannot store ownership or permissions; they will not be preserved (use -metadata-sidecar to record them, or -no-metadata to silence)")
+	}
+	if srcCaps.Symlinks && !dstCaps.Symlinks && symlinks == engine.SymlinkPreserve {
+		log.Printf("Warning: destination cannot store symbolic links; links will be copied as the files they point to")
 	}
-	return localProvider, nil
 }
 
+// transferOptions carries the per-run settings used by transferFile.
+type transferOptions struct {
+	checksum   bool
+	validation engine.ValidationRules
+	// transforms pick the pipeline each file's content passes through
+	transforms engine.TransformRules
+	// events receives the completion of each file
+	events *engine.EventBus
+
+	// metadataWarnings counts files completed despite a metadata error.
+	metadataWarnings *atomic.Int64
+	// restarts counts checkpoints discarded because the source changed.
+	restarts *atomic.Int64
+
+	// sparse reads only the data extents of sparse files; sparseFiles
+	// counts those files, whose holes the run's stats count.
+	sparse      bool
+	sparseFiles *atomic.Int64
+
+	// mtimes checks source mtimes for clock skew; mtimeAdjusted counts
+	// the files it flagged.
+	mtimes        engine.MTimeChecker
+	mtimeAdjusted *atomic.Int64
+
+	// restores parks jobs whose source is archived until it is restored.
+	restores *engine.RestoreQueue
+
+	// streamLimits, if set, limits the rate of each stream.
+	streamLimits *engine.StreamLimits
+
+	// meter, if set, measures the throughput of each stream.
+	meter *engine.Meter
+
+	// chunked splits large files into chunks copied on several streams.
+	chunked engine.ChunkedOptions
+
+	// memory limits the data held in transfer buffers at once.
+	memory *engine.MemoryBudget
+
+	// bundler, if set, writes small files into bundles; bundledFiles and
+	// bundles count the files and bundles stored.
+	bundler      *engine.Bundler
+	bundledFiles *atomic.Int64
+	bundles      *atomic.Int64
+
+	// dedup, if set, finds content the destination already holds; deduped
+	// and dedupBytes count the files and bytes not transferred.
+	dedup      *engine.DedupIndex
+	deduped    *atomic.Int64
+	dedupBytes *atomic.Int64
+
+	// skipCompleted skips files an earlier run completed whose source is
+	// unchanged; skipped counts them.
+	skipCompleted bool
+	skipped       *atomic.Int64
+
+	// sourceChanges decides what happens to copies of sources that changed
+	// while they were read; sourceChanged and inconsistent count the
+	// copies discarded and kept.
+	sourceChanges engine.SourceChangePolicy
+	sourceChanged *atomic.Int64
+	inconsistent  *atomic.Int64
+
+	// update skips files the destination already holds with the same size
+	// and, depending on compare, an mtime within mtimeWindow or the same
+	// content; upToDate counts them.
+	update      bool
+	compare     engine.CompareMode
+	mtimeWindow time.Duration
+	upToDate    *atomic.Int64
+
+	// fanOut, if set, writes every file to replicas as well; replicas are
+	// the jobs of those a file has yet to be written to.
+	fanOut   *engine.FanOut
+	replicas []engine.TransferJob
+}
+
+// transferResult describes how transferFile completed a job.
+type transferResult struct {
+	// checksum is the "algorithm:value" checksum of the file, if known.
+	checksum string
+	// serverSide means the destination copied the file itself and the
+	// content was never hashed locally.
+	serverSide bool
+	// parked means the source is archived and the job waits for a
+	// restore.
+	parked bool
+	// skipped means an earlier run already completed the file.
+	skipped bool
+	// bundled means the file was added to a bundle, and completes once
+	// the bundle is stored.
+	bundled bool
+	// inconsistent means the source changed while it was copied and the
+	// copy was kept anyway.
+	inconsistent bool
+	// transformed means the content passed through transforms, so the
+	// destination does not hold the source's bytes.
+	transformed bool
+	// replicated means the replicas were written in the same stream as
+	// the destination.
+	replicated bool
+}
+
+// transferFile copies job to the destination and to the replicas it has
+// yet to be written to. Replicas receive the stream the destination does;
+// files the destination stores without one, or already holds, are read
+// again for them.
 func transferFile(
 	ctx context.Context,
 	job engine.TransferJob,
@@ -222,68 +1722,597 @@ func transferFile(
 	dstProvider provider.Provider,
 	tracker *engine.JobTracker,
 	bufferPool *engine.BufferPool,
-	checksum bool,
-	tuiState *ui.UIState,
-) error {
-	// Initialize job in store
-	if err := tracker.InitJob(job); err != nil {
-		return fmt.Errorf("failed to init job: %w", err)
+	opts transferOptions,
+) (transferResult, error) {
+	if opts.fanOut == nil {
+		return transferToDestination(ctx, job, srcProvider, dstProvider, tracker, bufferPool, opts)
+	}
+	replicas, err := opts.fanOut.Pending(job, opts.skipCompleted)
+	if err != nil {
+		return transferResult{}, err
+	}
+	opts.replicas = replicas
+	// Transformed content is only produced on its way to the destination,
+	// so replicas missing it have the destination written again
+	if len(replicas) > 0 && opts.transforms.Match(job.DestinationPath) != nil {
+		opts.skipCompleted, opts.update = false, false
+	}
+	result, err := transferToDestination(ctx, job, srcProvider, dstProvider, tracker, bufferPool, opts)
+	if err != nil || result.parked || result.replicated || len(replicas) == 0 {
+		return result, err
+	}
+
+	buf := bufferPool.GetFor(job.FileInfo.Size())
+	defer bufferPool.Put(buf)
+	if err := opts.fanOut.Copy(ctx, job, replicas, srcProvider, *buf); err != nil {
+		result.skipped = false
+		return result, err
+	}
+	return result, nil
+}
+
+// transferToDestination copies job to the destination.
+func transferToDestination(
+	ctx context.Context,
+	job engine.TransferJob,
+	srcProvider provider.Provider,
+	dstProvider provider.Provider,
+	tracker *engine.JobTracker,
+	bufferPool *engine.BufferPool,
+	opts transferOptions,
+) (transferResult, error) {
+	// Files an earlier run completed are not copied again while their
+	// source is unchanged
+	if opts.skipCompleted {
+		done, err := tracker.CompletedUnchanged(job)
+		if err != nil {
+			return transferResult{}, fmt.Errorf("failed to check job state: %w", err)
+		}
+		if done {
+			opts.skipped.Add(1)
+			opts.events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size(), Skipped: true})
+			return transferResult{skipped: true}, nil
+		}
+	}
+
+	// In update mode files the destination already holds are not copied,
+	// but recorded as completed so later reruns skip them without a stat
+	if opts.update {
+		var current bool
+		var err error
+		if opts.compare == engine.CompareChecksum {
+			buf := bufferPool.Get()
+			current, err = engine.DestinationMatches(ctx, job, srcProvider, dstProvider, *buf)
+			bufferPool.Put(buf)
+		} else {
+			current, err = engine.DestinationCurrent(ctx, job, dstProvider, opts.mtimeWindow)
+		}
+		if err != nil {
+			return transferResult{}, err
+		}
+		if current {
+			if err := tracker.InitJob(job); err != nil {
+				return transferResult{}, fmt.Errorf("failed to init job: %w", err)
+			}
+			opts.upToDate.Add(1)
+			opts.events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size(), Skipped: true})
+			return transferResult{skipped: true}, tracker.MarkCompleted(job.ID)
+		}
+	}
+
+	// Listings from object stores lack the metadata to preserve
+	job, err := engine.SourceMetadata(ctx, job, srcProvider, dstProvider)
+	if err != nil {
+		return transferResult{}, err
+	}
+
+	// Transformed content has to be streamed through the pipeline
+	pipeline := opts.transforms.Match(job.DestinationPath)
+
+	// Initialize job in store, discarding the progress of an earlier
+	// attempt if the source changed since its last checkpoint. Multipart
+	// uploads continue from their last stored part and local files from
+	// their last checkpoint; other copies start from zero.
+	resume, err := tracker.ResumeJob(ctx, job, srcProvider)
+	if err != nil {
+		return transferResult{}, fmt.Errorf("failed to init job: %w", err)
+	}
+	if resume.Restarted {
+		log.Printf("Restarting %s: %s", job.SourcePath, resume.Reason)
+		if opts.restarts != nil {
+			opts.restarts.Add(1)
+		}
+	}
+
+	// Archived sources wait for a restore instead of failing on read
+	if opts.restores != nil {
+		parked, err := opts.restores.Park(ctx, job)
+		if err != nil {
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, err
+		}
+		if parked {
+			log.Printf("Waiting for %s to be restored from archive", job.SourcePath)
+			return transferResult{parked: true}, nil
+		}
 	}
 
 	// Mark as in progress
 	if err := tracker.MarkInProgress(job.ID); err != nil {
-		return fmt.Errorf("failed to mark job in progress: %w", err)
+		return transferResult{}, fmt.Errorf("failed to mark job in progress: %w", err)
 	}
 
-	// Open source
-	srcReader, err := srcProvider.OpenRead(ctx, job.SourcePath)
-	if err != nil {
-		tracker.MarkFailed(job.ID, err)
-		return fmt.Errorf("failed to open source: %w", err)
+	// Flag, and per policy fix, mtimes the destination should not inherit.
+	// The original time stays in the job's source fingerprint, and is what
+	// the source is checked against once copied.
+	walked := job
+	if info, adjustment := opts.mtimes.Check(job.FileInfo); adjustment != "" {
+		log.Printf("Warning: %s: %s", job.SourcePath, adjustment)
+		job.FileInfo = info
+		tracker.RecordMTimeAdjustment(job.ID, adjustment)
+		if opts.mtimeAdjusted != nil {
+			opts.mtimeAdjusted.Add(1)
+		}
+	}
+
+	// Recreate symbolic links instead of copying their targets' content
+	if handled, err := engine.TransferSymlink(ctx, job, dstProvider); handled || err != nil {
+		if err != nil {
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, fmt.Errorf("failed to create symlink: %w", err)
+		}
+		if err := tracker.MarkCompleted(job.ID); err != nil {
+			return transferResult{}, err
+		}
+		opts.events.Publish(engine.JobCompleted{Job: job})
+		return transferResult{}, nil
+	}
+
+	// Content the destination already holds is not transferred again
+	if opts.dedup != nil && pipeline == nil {
+		match, err := opts.dedup.Apply(ctx, job, srcProvider)
+		if match == "" && err != nil {
+			log.Printf("Dedup lookup failed for %s, transferring it: %v", job.SourcePath, err)
+		}
+		if match != "" {
+			if err := recordMetadataError(job, tracker, err, opts); err != nil {
+				tracker.MarkFailed(job.ID, err)
+				return transferResult{}, fmt.Errorf("dedup copy from %s failed: %w", match, err)
+			}
+			if err := tracker.MarkCompleted(job.ID); err != nil {
+				return transferResult{}, fmt.Errorf("failed to mark job completed: %w", err)
+			}
+			opts.deduped.Add(1)
+			opts.dedupBytes.Add(job.FileInfo.Size())
+			opts.events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size()})
+			return transferResult{serverSide: true}, nil
+		}
 	}
-	defer srcReader.Close()
 
-	// Wrap with checksum if enabled
-	var reader io.Reader = srcReader
-	// TODO: Add CRC64/XXHash wrapper here
+	// Small files are written into bundles, whose commit completes them
+	if opts.bundler.Applies(job) && opts.validation.NewValidator(job.DestinationPath) == nil && pipeline == nil {
+		buf := bufferPool.GetFor(job.FileInfo.Size())
+		err := opts.bundler.Add(ctx, job, srcProvider, *buf)
+		bufferPool.Put(buf)
+		if err != nil {
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, fmt.Errorf("failed to bundle: %w", err)
+		}
+		return transferResult{bundled: true}, nil
+	}
+
+	// Let the destination copy the file itself when it can read the
+	// source directly. There is nothing to validate or hash locally, so
+	// the provider's checksum is recorded in the ledger instead.
+	if opts.validation.NewValidator(job.DestinationPath) == nil && pipeline == nil {
+		digest, handled, err := engine.CopyServerSide(ctx, job, srcProvider, dstProvider)
+		if handled {
+			if err := recordMetadataError(job, tracker, err, opts); err != nil {
+				tracker.MarkFailed(job.ID, err)
+				return transferResult{}, fmt.Errorf("server-side copy failed: %w", err)
+			}
+			result := transferResult{checksum: engine.FormatDigest(digest), serverSide: true}
+			if result.checksum != "" {
+				if err := tracker.RecordProviderChecksum(job.ID, result.checksum); err != nil {
+					return result, fmt.Errorf("failed to record checksum: %w", err)
+				}
+			}
+			if err := tracker.MarkCompleted(job.ID); err != nil {
+				return result, fmt.Errorf("failed to mark job completed: %w", err)
+			}
+			opts.events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size()})
+			return result, nil
+		}
+	}
+
+	// Sparse files are read extent by extent instead of as one stream,
+	// unless the stream is checksummed
+	var extents []provider.Extent
+	if opts.sparse && !opts.checksum && pipeline == nil {
+		extents, err = engine.SparseExtents(ctx, job, srcProvider)
+		if err != nil {
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, fmt.Errorf("failed to map sparse file: %w", err)
+		}
+	}
 
-	// Open destination
-	dstWriter, err := dstProvider.OpenWrite(ctx, job.DestinationPath, job.FileInfo)
+	// Large files are copied in chunks on several streams when the
+	// destination can assemble them. Validators, checksums and sparse
+	// copies need the whole stream, and a multipart upload in progress is
+	// continued instead.
+	if extents == nil && pipeline == nil && !opts.checksum && opts.validation.NewValidator(job.DestinationPath) == nil && resume.Upload == nil && resume.Offset == 0 && opts.chunked.Applies(job) {
+		release, err := opts.memory.Acquire(ctx, opts.chunked.Memory(job.FileInfo.Size()))
+		if err != nil {
+			return transferResult{}, err
+		}
+		handled, err := engine.CopyChunked(ctx, job, srcProvider, dstProvider, opts.chunked)
+		release()
+		if handled {
+			if err := recordMetadataError(job, tracker, err, opts); err != nil {
+				tracker.MarkFailed(job.ID, err)
+				return transferResult{}, fmt.Errorf("chunked transfer failed: %w", err)
+			}
+			inconsistent, err := completeJob(ctx, walked, srcProvider, dstProvider, tracker, opts)
+			if err != nil {
+				return transferResult{}, err
+			}
+			if opts.dedup != nil && !inconsistent {
+				opts.dedup.Add(job.DestinationPath, job.FileInfo.Size())
+			}
+			opts.events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size()})
+			return transferResult{inconsistent: inconsistent}, nil
+		}
+	}
+
+	// Validators, checksums, sparse and transformed copies need the whole
+	// stream, so they start over, as do copies replicas receive
+	if extents != nil || pipeline != nil || opts.checksum || opts.validation.NewValidator(job.DestinationPath) != nil || len(opts.replicas) > 0 {
+		resume = resume.WithoutUpload()
+	}
+
+	// Wait until the copy buffer and what the providers buffer of their
+	// own fit in the memory budget
+	release, err := opts.memory.Acquire(ctx, int64(bufferPool.SizeFor(job.FileInfo.Size()))+
+		provider.StreamMemory(srcProvider, job.FileInfo.Size(), false)+
+		provider.StreamMemory(dstProvider, job.FileInfo.Size(), true))
+	if err != nil {
+		return transferResult{}, err
+	}
+	defer release()
+
+	// Open destination first, since what a resumed copy kept of it
+	// decides where the source is read from. Multipart uploads are sized
+	// for the source, so transformed content is written as a stream.
+	var dstWriter io.WriteCloser
+	var offset int64
+	if pipeline != nil {
+		dstWriter, err = dstProvider.OpenWrite(ctx, job.DestinationPath, job.FileInfo)
+	} else {
+		dstWriter, offset, err = tracker.OpenDestination(ctx, job, dstProvider, resume)
+	}
 	if err != nil {
 		tracker.MarkFailed(job.ID, err)
-		return fmt.Errorf("failed to open destination: %w", err)
+		return transferResult{}, fmt.Errorf("failed to open destination: %w", err)
+	}
+	if offset > 0 {
+		log.Printf("Resuming %s at byte %d", job.SourcePath, offset)
 	}
 
-	// Wrap writer with tracking
-	trackedWriter := tracker.NewTrackedWriter(dstWriter, job.ID, 0)
+	// Open source
+	var reader io.Reader
+	if extents == nil {
+		var srcReader io.ReadCloser
+		if offset > 0 {
+			srcReader, err = provider.OpenReadRange(ctx, srcProvider, job.SourcePath, offset, -1)
+		} else {
+			srcReader, err = srcProvider.OpenRead(ctx, job.SourcePath)
+		}
+		if err != nil {
+			dstWriter.Close()
+			// A source gone since it was listed leaves no empty copy behind
+			if provider.IsNotExist(err) {
+				if d, ok := dstProvider.(provider.Deleter); ok {
+					_ = d.Delete(ctx, job.DestinationPath)
+				}
+			}
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, fmt.Errorf("failed to open source: %w", err)
+		}
+		defer srcReader.Close()
+		reader = srcReader
+		if opts.streamLimits != nil {
+			var done func()
+			reader, done = opts.streamLimits.Reader(ctx, job, reader)
+			defer done()
+		}
+		if opts.meter != nil {
+			var done func()
+			reader, done = opts.meter.Reader(job, reader)
+			defer done()
+		}
+	}
 
-	// Perform transfer
-	buf := bufferPool.Get()
+	// Wrap writer with tracking, and the source to record how far it was
+	// read apart from what the destination committed
+	trackedWriter := tracker.NewTrackedWriter(dstWriter, job.ID, offset)
+	var writer io.Writer = trackedWriter
+	if reader != nil {
+		trackedReader := engine.NewTrackedReader(reader, offset)
+		trackedWriter.SetSource(trackedReader)
+		reader = trackedReader
+	}
+
+	// Replicas receive what the destination does; they fail with it
+	// unless committed
+	var replicas *engine.FanOutWriter
+	if len(opts.replicas) > 0 {
+		replicas = opts.fanOut.Open(ctx, job, opts.replicas)
+		defer replicas.Abort(errDestinationFailed)
+		writer = io.MultiWriter(trackedWriter, replicas)
+	}
+
+	// Tee the destination stream through a format validator if one applies
+	validator := opts.validation.NewValidator(job.DestinationPath)
+	if validator != nil {
+		writer = io.MultiWriter(writer, validator)
+	}
+
+	// Checksum what is read from the source and what reaches the
+	// destination, to compare them once the copy is done
+	var checksums *engine.StreamChecksums
+	if opts.checksum && pipeline == nil {
+		checksums = engine.NewStreamChecksums(reader, writer)
+		reader, writer = checksums.Reader(), checksums.Writer()
+	}
+
+	// Pass the content through the transforms that apply to it
+	var flush io.Closer
+	if pipeline != nil {
+		reader, writer, flush, err = pipeline.Apply(job, reader, writer)
+		if err != nil {
+			if validator != nil {
+				validator.Close()
+			}
+			dstWriter.Close()
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, err
+		}
+	}
+
+	// Perform transfer, with a buffer no larger than the file needs
+	buf := bufferPool.GetFor(job.FileInfo.Size())
 	defer bufferPool.Put(buf)
 
-	_, err = io.CopyBuffer(trackedWriter, reader, *buf)
+	var holes int64
+	if extents != nil {
+		var transferred int64
+		transferred, err = engine.CopySparse(ctx, writer, srcProvider, job.SourcePath, job.FileInfo.Size(), extents, *buf)
+		holes = job.FileInfo.Size() - transferred
+	} else {
+		// Local copies nothing needs to see go through the kernel
+		handled := false
+		if checksums == nil && validator == nil && pipeline == nil && replicas == nil {
+			_, handled, err = engine.SendFile(ctx, writer, reader)
+		}
+		if !handled {
+			_, err = io.CopyBuffer(writer, reader, *buf)
+		}
+	}
+	if err == nil && flush != nil {
+		err = flush.Close()
+	}
 	if err != nil {
+		if validator != nil {
+			validator.Close()
+		}
 		dstWriter.Close()
+		// An aborted copy is resumed from what it wrote, not its last
+		// periodic checkpoint
+		if ctx.Err() != nil {
+			trackedWriter.Checkpoint()
+		}
 		tracker.MarkFailed(job.ID, err)
-		return fmt.Errorf("transfer failed: %w", err)
+		return transferResult{}, fmt.Errorf("transfer failed: %w", err)
+	}
+
+	// A destination that fails validation or does not match its source is
+	// removed, and the job left failed for the retry sweep, so no later
+	// run takes it for a good copy
+	if validator != nil {
+		if err := validator.Close(); err != nil {
+			discardDestination(ctx, dstProvider, dstWriter, job)
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, err
+		}
+	}
+	result := transferResult{transformed: pipeline != nil}
+	if checksums != nil {
+		result.checksum, err = checksums.Verify(job.SourcePath)
+		if err != nil {
+			discardDestination(ctx, dstProvider, dstWriter, job)
+			tracker.MarkFailed(job.ID, err)
+			return transferResult{}, err
+		}
 	}
 
 	// Close destination (applies metadata)
-	if err := dstWriter.Close(); err != nil {
+	if err := recordMetadataError(job, tracker, dstWriter.Close(), opts); err != nil {
 		tracker.MarkFailed(job.ID, err)
-		return fmt.Errorf("failed to close destination: %w", err)
+		return transferResult{}, fmt.Errorf("failed to close destination: %w", err)
+	}
+
+	if result.checksum != "" {
+		if err := tracker.RecordChecksum(job.ID, result.checksum); err != nil {
+			return result, fmt.Errorf("failed to record checksum: %w", err)
+		}
 	}
 
 	// Mark as completed
+	if result.inconsistent, err = completeJob(ctx, walked, srcProvider, dstProvider, tracker, opts); err != nil {
+		return result, err
+	}
+	if replicas != nil {
+		result.replicated = true
+		if err := replicas.Commit(result.checksum, result.inconsistent); err != nil {
+			return result, err
+		}
+	}
+
+	if extents != nil {
+		opts.sparseFiles.Add(1)
+	}
+	if opts.dedup != nil && !result.inconsistent {
+		opts.dedup.Add(job.DestinationPath, job.FileInfo.Size())
+	}
+
+	opts.events.Publish(engine.JobCompleted{Job: job, Bytes: job.FileInfo.Size(), Sparse: holes})
+
+	return result, nil
+}
+
+// errDestinationFailed fails the replicas of a file whose copy to the
+// destination failed.
+var errDestinationFailed = errors.New("copy to the first destination failed")
+
+// replayVersions replays the history of a file queued with -all-versions.
+func replayVersions(
+	ctx context.Context,
+	job engine.TransferJob,
+	srcProvider provider.Provider,
+	dstProvider provider.Provider,
+	tracker *engine.JobTracker,
+	bufferPool *engine.BufferPool,
+	opts transferOptions,
+) (transferResult, error) {
+	buf := bufferPool.Get()
+	defer bufferPool.Put(buf)
+	if err := engine.ReplayVersions(ctx, job, srcProvider, dstProvider, tracker, *buf); err != nil {
+		return transferResult{}, err
+	}
+	opts.events.Publish(engine.JobCompleted{Job: job})
+	return transferResult{}, nil
+}
+
+// publishProgress publishes the rates measured by meter every interval
+// until ctx is done.
+func publishProgress(ctx context.Context, events *engine.EventBus, meter *engine.Meter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			events.Publish(engine.Progress{Rate: meter.Rate(), Streams: meter.Streams()})
+		}
+	}
+}
+
+// logProgress logs the run's progress, throughput and ETA every interval
+// until ctx is done, for runs without the TUI.
+func logProgress(ctx context.Context, stats *engine.RunStats, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s := stats.Stats()
+		eta := "unknown"
+		if d, ok := s.ETA(); ok {
+			eta = d.Round(time.Second).String()
+		}
+		log.Printf("Progress: %d/%d files, %d/%d bytes, %d failed, %.1f MB/s over %d streams, ETA %s",
+			s.Completed.Files, s.Total.Files, s.Completed.Bytes, s.Total.Bytes, s.Failed.Files,
+			s.Rate/(1024*1024), len(s.Streams), eta)
+	}
+}
+
+// throttlingLimiter returns the limiter adapting the streams open against
+// the named bucket, logging each change of its limit.
+func throttlingLimiter(name string, streams int) *provider.ConcurrencyLimiter {
+	limiter := provider.NewConcurrencyLimiter(streams)
+	limiter.OnChange = func(limit int) {
+		log.Printf("Adjusted %s streams to %d of %d for S3 throttling", name, limit, streams)
+	}
+	return limiter
+}
+
+// checkVersioned returns an error unless src keeps versions and dst is a
+// bucket with versioning enabled.
+func checkVersioned(ctx context.Context, src, dst provider.Provider) error {
+	if !provider.CapabilitiesOf(src).Versions {
+		return fmt.Errorf("the source keeps no versions")
+	}
+	sd, ok := dst.(provider.SoftDeleter)
+	if !ok {
+		return fmt.Errorf("the destination keeps no versions")
+	}
+	enabled, err := sd.SoftDeletes(ctx)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("the destination bucket does not have versioning enabled")
+	}
+	return nil
+}
+
+// completeJob records a copied job as Completed, once it has checked, as
+// opts.sourceChanges asks, that the source did not change while it was
+// read. Under the retry policy the copy of a changed source is deleted and
+// the job failed; under inconsistent the copy is kept, the job recorded as
+// Inconsistent, and true returned.
+func completeJob(ctx context.Context, job engine.TransferJob, srcProvider, dstProvider provider.Provider, tracker *engine.JobTracker, opts transferOptions) (bool, error) {
+	if opts.sourceChanges != engine.SourceChangesIgnore {
+		err := engine.CheckSourceUnchanged(ctx, job, srcProvider)
+		var modified *engine.SourceModifiedError
+		switch {
+		case errors.As(err, &modified) && opts.sourceChanges == engine.SourceChangesInconsistent:
+			log.Printf("Warning: %v; keeping the copy as inconsistent", err)
+			opts.inconsistent.Add(1)
+			return true, tracker.MarkInconsistent(job.ID, err)
+		case modified != nil:
+			if d, ok := dstProvider.(provider.Deleter); ok {
+				_ = d.Delete(ctx, job.DestinationPath)
+			}
+			opts.sourceChanged.Add(1)
+			tracker.MarkFailed(job.ID, err)
+			return false, err
+		case err != nil:
+			tracker.MarkFailed(job.ID, err)
+			return false, err
+		}
+	}
 	if err := tracker.MarkCompleted(job.ID); err != nil {
-		return fmt.Errorf("failed to mark job completed: %w", err)
+		return false, fmt.Errorf("failed to mark job completed: %w", err)
 	}
+	return false, nil
+}
 
-	// Update TUI state
-	if tuiState != nil {
-		tuiState.CompletedFiles++
-		tuiState.CompletedBytes += job.FileInfo.Size()
+// discardDestination closes the copy of job written to dst and deletes it,
+// where dst can delete.
+func discardDestination(ctx context.Context, dst provider.Provider, w io.Closer, job engine.TransferJob) {
+	w.Close()
+	if d, ok := dst.(provider.Deleter); ok {
+		_ = d.Delete(ctx, job.DestinationPath)
 	}
+}
 
+// recordMetadataError records a non-fatal *provider.MetadataError from
+// writing job as a warning and returns nil, since the content is complete.
+// Any other error is returned unchanged.
+func recordMetadataError(job engine.TransferJob, tracker *engine.JobTracker, err error, opts transferOptions) error {
+	var metaErr *provider.MetadataError
+	if err == nil || !errors.As(err, &metaErr) || metaErr.Fatal() {
+		return err
+	}
+	log.Printf("Warning: %v", err)
+	tracker.MarkMetadataError(job.ID, err)
+	if opts.metadataWarnings != nil {
+		opts.metadataWarnings.Add(1)
+	}
 	return nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/franksops/gofast/engine"
+	"github.com/franksops/gofast/ui"
+)
+
+// pauseControl pauses, resumes and aborts every worker pool of a run
+// together. Pools started while the run is paused, such as those of the retry sweep,
+// start paused.
+type pauseControl struct {
+	mu     sync.Mutex
+	paused bool
+	pools  []*engine.WorkerPool
+	state  *ui.StateTracker
+}
+
+// add makes pool follow the run's pause state.
+func (c *pauseControl) add(pool *engine.WorkerPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools = append(c.pools, pool)
+	if c.paused {
+		pool.Pause()
+	}
+}
+
+// set pauses or resumes every pool and reports whether that changed the
+// state.
+func (c *pauseControl) set(paused bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused == paused {
+		return false
+	}
+	c.apply(paused)
+	return true
+}
+
+// toggle pauses a running run or resumes a paused one, and returns whether
+// it is now paused.
+func (c *pauseControl) toggle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apply(!c.paused)
+	return c.paused
+}
+
+// abort stops every pool, cancelling the files they are running, such as
+// those a drain is waiting for.
+func (c *pauseControl) abort() {
+	c.mu.Lock()
+	pools := append([]*engine.WorkerPool(nil), c.pools...)
+	c.mu.Unlock()
+	for _, pool := range pools {
+		pool.Stop()
+	}
+}
+
+func (c *pauseControl) apply(paused bool) {
+	c.paused = paused
+	for _, pool := range c.pools {
+		if paused {
+			pool.Pause()
+		} else {
+			pool.Resume()
+		}
+	}
+	if c.state != nil {
+		c.state.SetPaused(paused)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/franksops/gofast/engine"
+)
+
+// runPlan implements `gfast plan`, a dry run that compares a source and a
+// destination and writes a cutover-readiness report as JSON: the files a
+// sync would copy, update, fix metadata on or delete, with totals and the
+// shape of the source tree. It returns the process exit code.
+func runPlan(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	var (
+		output         string
+		srcManifest    string
+		dstManifest    string
+		mtimeWindow    time.Duration
+		includeSkipped bool
+	)
+	fs.StringVar(&output, "o", "", "Write the report to this file instead of stdout")
+	fs.StringVar(&srcManifest, "source-manifest", "", "Checksum manifest of the source from `gfast hash`, to detect content changes")
+	fs.StringVar(&dstManifest, "dest-manifest", "", "Checksum manifest of the destination from `gfast hash`")
+	fs.DurationVar(&mtimeWindow, "mtime-window", engine.DefaultModTimeWindow, "Tolerance when comparing modification times")
+	fs.BoolVar(&includeSkipped, "include-skipped", false, "List files that are already in sync as well")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast plan <source> <dest> [-o plan.json] [-source-manifest m.jsonl -dest-manifest m.jsonl]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	opts := engine.PlanOptions{ModTimeWindow: mtimeWindow, IncludeSkipped: includeSkipped}
+	if (srcManifest == "") != (dstManifest == "") {
+		log.Printf("-source-manifest and -dest-manifest must be given together")
+		return 2
+	}
+	if srcManifest != "" {
+		var err error
+		if opts.SourceManifest, err = readManifestFile(srcManifest); err != nil {
+			log.Printf("Failed to read source manifest: %v", err)
+			return 1
+		}
+		if opts.DestManifest, err = readManifestFile(dstManifest); err != nil {
+			log.Printf("Failed to read destination manifest: %v", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	src, srcRoot, err := createProvider(positional[0], true, "")
+	if err != nil {
+		log.Printf("Failed to create source provider: %v", err)
+		return 1
+	}
+	dst, dstRoot, err := createProvider(positional[1], true, "")
+	if err != nil {
+		log.Printf("Failed to create destination provider: %v", err)
+		return 1
+	}
+
+	srcFiles, err := engine.ListTree(ctx, src, srcRoot)
+	if err != nil {
+		log.Printf("Failed to list source: %v", err)
+		return 1
+	}
+	// A destination that does not exist yet simply needs everything copied
+	dstFiles, err := engine.ListTree(ctx, dst, dstRoot)
+	if err != nil {
+		if _, statErr := dst.Stat(ctx, dstRoot); statErr == nil {
+			log.Printf("Failed to list destination: %v", err)
+			return 1
+		}
+		dstFiles = nil
+	}
+
+	plan := engine.BuildPlan(srcFiles, dstFiles, opts)
+	plan.Source, plan.Destination = positional[0], positional[1]
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Printf("Failed to create report: %v", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		log.Printf("Failed to write report: %v", err)
+		return 1
+	}
+
+	for _, action := range []engine.PlanAction{engine.ActionCopy, engine.ActionUpdate, engine.ActionMetadata, engine.ActionDelete, engine.ActionSkip} {
+		total := plan.Totals[action]
+		fmt.Fprintf(os.Stderr, "%-8s %8d files %14d bytes\n", action, total.Files, total.Bytes)
+	}
+	printShape(os.Stderr, plan.Shape)
+	return 0
+}
+
+// printShape writes the namespace shape of the source and the tuning
+// recommendations that follow from it.
+func printShape(w io.Writer, shape *engine.NamespaceShape) {
+	if shape == nil || shape.Files == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nSource shape: %d files in %d directories, max depth %d\n", shape.Files, shape.Directories, shape.MaxDepth())
+	fmt.Fprintln(w, "File sizes:")
+	for i, b := range shape.FileSizes {
+		if b.Count > 0 {
+			fmt.Fprintf(w, "  %-12s %8d\n", engine.ShapeBucketLabel(shape.FileSizes, i, true), b.Count)
+		}
+	}
+	fmt.Fprintln(w, "Files per directory:")
+	for i, b := range shape.FilesPerDir {
+		if b.Count > 0 {
+			fmt.Fprintf(w, "  %-12s %8d\n", engine.ShapeBucketLabel(shape.FilesPerDir, i, false), b.Count)
+		}
+	}
+	fmt.Fprintln(w, "Depth:")
+	for depth, n := range shape.Depth {
+		if n > 0 {
+			fmt.Fprintf(w, "  %-12d %8d\n", depth, n)
+		}
+	}
+	if recs := shape.Recommendations(); len(recs) > 0 {
+		fmt.Fprintln(w, "Recommendations:")
+		for _, rec := range recs {
+			fmt.Fprintf(w, "  - %s\n", rec)
+		}
+	}
+}
+
+// readManifestFile reads a manifest written by `gfast hash -o`.
+func readManifestFile(name string) ([]engine.ManifestEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return engine.ReadManifest(f)
+}
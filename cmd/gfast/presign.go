@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/franksops/gofast/engine"
+	"github.com/franksops/gofast/provider"
+)
+
+// runPresign implements `gfast presign`, which walks a source and writes a
+// list of presigned requests moving each file, so that a worker host can
+// carry out the transfer with `gfast presigned` without holding AWS
+// credentials. It returns the process exit code.
+func runPresign(args []string) int {
+	fs := flag.NewFlagSet("presign", flag.ExitOnError)
+	var (
+		expires time.Duration
+		output  string
+		determ  bool
+	)
+	fs.DurationVar(&expires, "expires", time.Hour, "How long the presigned requests stay valid, at most 7 days")
+	fs.StringVar(&output, "o", "", "Write the transfer list to this file instead of stdout")
+	fs.BoolVar(&determ, "deterministic", false, "Walk the source in sorted order")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast presign <source> [s3://dest] [-expires 1h] [-o transfers.jsonl]")
+		fmt.Fprintln(fs.Output(), "Without a destination the worker writes files to its -local directory; a local source is read by the worker from its -local directory.")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 1 || len(positional) > 2 {
+		fs.Usage()
+		return 2
+	}
+
+	src, srcRoot, err := createProvider(positional[0], true, "")
+	if err != nil {
+		log.Printf("Failed to create source provider: %v", err)
+		return 1
+	}
+	var dst provider.Provider
+	if len(positional) == 2 {
+		if !strings.HasPrefix(positional[1], "s3://") {
+			log.Printf("The destination must be an s3:// URL; to write to the worker's own storage, omit it")
+			return 2
+		}
+		if dst, _, err = createProvider(positional[1], true, ""); err != nil {
+			log.Printf("Failed to create destination provider: %v", err)
+			return 1
+		}
+	}
+	if !provider.CapabilitiesOf(src).Presign && (dst == nil || !provider.CapabilitiesOf(dst).Presign) {
+		log.Printf("Nothing to presign: %s cannot presign requests and no s3:// destination was given", positional[0])
+		return 2
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Printf("Failed to create transfer list: %v", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	jobChan := make(engine.JobChannel, 1000)
+	walker := engine.NewWalker(src, jobChan)
+	walker.Sorted = determ
+	errc := make(chan error, 1)
+	go func() {
+		defer close(jobChan)
+		errc <- walker.Walk(ctx, srcRoot, "")
+	}()
+
+	var count, failed int
+	for job := range jobChan {
+		t, err := engine.PresignTransfer(ctx, job, src, dst, expires)
+		if err == nil {
+			err = enc.Encode(t)
+		}
+		if err != nil {
+			failed++
+			log.Printf("Failed to presign %s: %v", job.SourcePath, err)
+			continue
+		}
+		count++
+	}
+	if err := <-errc; err != nil {
+		log.Printf("Walker error: %v", err)
+		failed++
+	}
+	if err := bw.Flush(); err != nil {
+		log.Printf("Failed to write transfer list: %v", err)
+		return 1
+	}
+
+	log.Printf("Presigned %d files, valid until %s", count, time.Now().Add(expires).Format(time.RFC3339))
+	if failed > 0 {
+		log.Printf("%d files could not be presigned", failed)
+		return 1
+	}
+	return 0
+}
+
+// runPresigned implements `gfast presigned`, which carries out a transfer
+// list from `gfast presign` using plain HTTP, with no credentials of its
+// own. It returns the process exit code.
+func runPresigned(args []string) int {
+	fs := flag.NewFlagSet("presigned", flag.ExitOnError)
+	var (
+		local      string
+		streams    int
+		bufferSize int
+		retries    int
+	)
+	fs.StringVar(&local, "local", "", "Directory files are read from or written to when the list has no presigned request for that side")
+	fs.IntVar(&streams, "streams", defaultStreams, "Number of concurrent transfer streams")
+	fs.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "Buffer size in bytes for each stream")
+	fs.IntVar(&retries, "retries", provider.DefaultRetryPolicy.MaxAttempts, "Attempts for transfers failing with transient errors (1 disables retries)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast presigned <transfers.jsonl> [-local dir] [-streams 16]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		log.Printf("Failed to open transfer list: %v", err)
+		return 1
+	}
+	defer f.Close()
+
+	var localProvider provider.Provider
+	if local != "" {
+		localProvider = provider.NewLocalProvider("").WithRoot(local)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	policy := provider.DefaultRetryPolicy
+	policy.MaxAttempts = retries
+	bufferPool := engine.NewBufferPool(bufferSize)
+	client := &http.Client{}
+
+	transfers := make(chan engine.PresignedTransfer, streams)
+	var (
+		mu           sync.Mutex
+		done, failed int
+		transferred  int64
+		wg           sync.WaitGroup
+	)
+	for range max(streams, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := bufferPool.Get()
+			defer bufferPool.Put(buf)
+			for t := range transfers {
+				var n int64
+				err := policy.Do(ctx, func() (err error) {
+					n, err = engine.RunPresigned(ctx, client, t, localProvider, local, *buf)
+					return err
+				})
+				mu.Lock()
+				if err != nil {
+					failed++
+					log.Printf("Failed to transfer %s: %v", t.Path, err)
+				} else {
+					done++
+					transferred += n
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var readErr error
+	for ctx.Err() == nil {
+		var t engine.PresignedTransfer
+		if err := dec.Decode(&t); err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+		transfers <- t
+	}
+	close(transfers)
+	wg.Wait()
+
+	log.Printf("Transferred %d files (%d bytes), %d failed", done, transferred, failed)
+	if readErr != nil {
+		log.Printf("Failed to read transfer list: %v", readErr)
+		return 1
+	}
+	if failed > 0 || ctx.Err() != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/franksops/gofast/engine"
+	"github.com/franksops/gofast/store"
+)
+
+// runPullState implements `gfast pull-state <dest>`, which fetches the state
+// database a run replicated to its destination with -replicate-state, so
+// that another host can resume the run. It returns the process exit code.
+func runPullState(args []string) int {
+	fs := flag.NewFlagSet("pull-state", flag.ExitOnError)
+	stateDir := fs.String("state-dir", "./.gofast-state", "Directory to store the pulled state in")
+	profile := fs.String("profile", "", "JSON provider profile for an s3:// destination")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast pull-state <dest> [-state-dir dir] [-profile profile.json]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dst, dstRoot, err := createProvider(positional[0], false, *profile)
+	if err != nil {
+		log.Printf("Failed to create destination provider: %v", err)
+		return 1
+	}
+
+	statePath := filepath.Join(*stateDir, "state.db")
+	if _, err := os.Stat(statePath); err == nil {
+		log.Printf("Refusing to overwrite existing state store %s", statePath)
+		return 1
+	}
+	if err := os.MkdirAll(*stateDir, 0755); err != nil {
+		log.Printf("Failed to create state directory: %v", err)
+		return 1
+	}
+
+	rc, err := dst.OpenRead(ctx, engine.StateReplicaPath(dstRoot))
+	if err != nil {
+		log.Printf("Failed to open state replica: %v", err)
+		return 1
+	}
+	defer rc.Close()
+
+	f, err := os.OpenFile(statePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to create state store: %v", err)
+		return 1
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(statePath)
+		log.Printf("Failed to download state replica: %v", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("Failed to write state store: %v", err)
+		return 1
+	}
+
+	stateStore, err := store.NewBoltStore(statePath)
+	if err != nil {
+		log.Printf("Failed to open pulled state store: %v", err)
+		return 1
+	}
+	defer stateStore.Close()
+	run, err := stateStore.LatestRun()
+	if err != nil {
+		log.Printf("Pulled state store has no run to resume: %v", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Pulled state of run %s (started %s) into %s\n", run.ID, run.StartedAt.Format("2006-01-02 15:04:05"), *stateDir)
+	printResumeToken(run.ID, *stateDir)
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/franksops/gofast/store"
+)
+
+// runResume implements `gfast resume <token>`, which re-runs an earlier
+// migration with the options recorded for it. Jobs that already completed
+// are found in the same state store. It returns the process exit code.
+func runResume(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: gfast resume <token>")
+		return 2
+	}
+	return rerun(args[0])
+}
+
+// runRetry implements `gfast retry <token>`, which re-runs an earlier
+// migration with the options recorded for it, but transfers only the files
+// its state store records as failed instead of walking the source again.
+// It returns the process exit code.
+func runRetry(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: gfast retry <token>")
+		return 2
+	}
+	return rerun(args[0], "-failed-only")
+}
+
+// rerun continues the run a resume token identifies with its recorded
+// options followed by extra.
+func rerun(token string, extra ...string) int {
+	runID, stateDir, err := decodeResumeToken(token)
+	if err != nil {
+		log.Printf("Invalid resume token: %v", err)
+		return 2
+	}
+
+	stateStore, err := store.NewBoltStore(filepath.Join(stateDir, "state.db"))
+	if err != nil {
+		log.Printf("Failed to open state store: %v", err)
+		return 1
+	}
+	run, err := stateStore.GetRun(runID)
+	stateStore.Close()
+	if err != nil {
+		log.Printf("Failed to load run %s from %s: %v", runID, stateDir, err)
+		return 1
+	}
+
+	// Relative paths in the recorded options refer to the original working
+	// directory.
+	if run.WorkDir != "" {
+		if err := os.Chdir(run.WorkDir); err != nil {
+			// Expected when resuming state pulled onto another host
+			log.Printf("Warning: cannot enter original working directory, relative paths resolve against the current one: %v", err)
+		}
+	}
+
+	// The recorded -state-dir may have been relative to another working
+	// directory; the token carries the absolute one, and the last flag wins.
+	runArgs := append(append([]string{}, run.Args...), "-state-dir", stateDir)
+	runArgs = append(runArgs, extra...)
+	return runMigrate(runArgs, runID)
+}
+
+// newRunID returns a short random identifier for a run.
+func newRunID() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// encodeResumeToken packs a run ID and its state directory into a single
+// copy-pasteable token.
+func encodeResumeToken(runID, stateDir string) string {
+	if abs, err := filepath.Abs(stateDir); err == nil {
+		stateDir = abs
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(runID + ":" + stateDir))
+}
+
+// decodeResumeToken reverses encodeResumeToken.
+func decodeResumeToken(token string) (runID, stateDir string, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil {
+		return "", "", err
+	}
+	runID, stateDir, ok := strings.Cut(string(data), ":")
+	if !ok || runID == "" || stateDir == "" {
+		return "", "", errors.New("malformed token")
+	}
+	return runID, stateDir, nil
+}
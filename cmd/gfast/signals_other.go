@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// runSignals are the signals a migration handles. Without user signals
+// the run is paused from the TUI only.
+var runSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// pauseSignal reports whether sig pauses or resumes the run, which no
+// signal does here.
+func pauseSignal(sig os.Signal) (pause, ok bool) {
+	return false, false
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// runSignals are the signals a migration handles: those interrupting it,
+// and the user signals pausing and resuming it. Job control (SIGTSTP,
+// SIGCONT) is left to the shell.
+var runSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// pauseSignal reports whether sig pauses (SIGUSR1) or resumes (SIGUSR2)
+// the run; ok is false for the signals interrupting it.
+func pauseSignal(sig os.Signal) (pause, ok bool) {
+	switch sig {
+	case syscall.SIGUSR1:
+		return true, true
+	case syscall.SIGUSR2:
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// runUndelete implements `gfast undelete`, which restores files soft-deleted
+// from a versioned destination within a recent time window, e.g. after a
+// mirror run removed files it should not have. It returns the exit code.
+func runUndelete(args []string) int {
+	fs := flag.NewFlagSet("undelete", flag.ExitOnError)
+	var (
+		since  time.Duration
+		dryRun bool
+	)
+	fs.DurationVar(&since, "since", 24*time.Hour, "Restore files deleted within this window")
+	fs.BoolVar(&dryRun, "dry-run", false, "List the files that would be restored without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast undelete <url> [-since 24h] [-dry-run]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	p, _, err := createProvider(positional[0], false, "")
+	if err != nil {
+		log.Printf("Failed to create provider: %v", err)
+		return 1
+	}
+	sd, ok := p.(provider.SoftDeleter)
+	if !ok {
+		log.Printf("%s does not support soft deletes; nothing can be restored", positional[0])
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	entries, err := sd.ListDeleted(ctx, time.Now().Add(-since))
+	if err != nil {
+		log.Printf("Failed to list deleted files: %v", err)
+		return 1
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		if dryRun {
+			fmt.Printf("would restore %s (deleted %s)\n", entry.Path, entry.DeletedAt.Format(time.RFC3339))
+			continue
+		}
+		if err := sd.Undelete(ctx, entry); err != nil {
+			log.Printf("Failed to restore %s: %v", entry.Path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("restored %s\n", entry.Path)
+	}
+
+	if failed > 0 {
+		log.Printf("Restored %d of %d files", len(entries)-failed, len(entries))
+		return 1
+	}
+	return 0
+}
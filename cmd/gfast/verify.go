@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/franksops/gofast/engine"
+)
+
+// runVerify implements `gfast verify`, which compares a destination with
+// its source after a migration without copying anything, and writes a JSON
+// report of the files missing at the destination or differing in size or
+// content. It returns the process exit code: 0 if every file verified, 1 if
+// not.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		output     string
+		algoName   string
+		streams    int
+		bufferSize int
+		sizeOnly   bool
+	)
+	fs.StringVar(&output, "o", "", "Write the report to this file instead of stdout")
+	fs.StringVar(&algoName, "algo", string(engine.AlgorithmXXH3), "Checksum algorithm for files whose providers store no comparable checksums (crc64, xxh3)")
+	fs.IntVar(&streams, "streams", defaultStreams, "Number of files compared at once")
+	fs.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "Buffer size in bytes for each stream")
+	fs.BoolVar(&sizeOnly, "size-only", false, "Compare sizes only, without reading content")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gfast verify <source> <dest> [-o report.json] [-size-only]")
+		fs.PrintDefaults()
+	}
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	algo, err := engine.ParseChecksumAlgorithm(algoName)
+	if err != nil {
+		log.Printf("Invalid -algo: %v", err)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	src, srcRoot, err := createProvider(positional[0], true, "")
+	if err != nil {
+		log.Printf("Failed to create source provider: %v", err)
+		return 1
+	}
+	dst, dstRoot, err := createProvider(positional[1], true, "")
+	if err != nil {
+		log.Printf("Failed to create destination provider: %v", err)
+		return 1
+	}
+
+	verifier := &engine.Verifier{
+		Source:      src,
+		Destination: dst,
+		Algorithm:   algo,
+		Streams:     streams,
+		BufferSize:  bufferSize,
+		SizeOnly:    sizeOnly,
+		OnEntry: func(entry engine.VerifyEntry) {
+			if entry.Error != "" {
+				log.Printf("%s: %s: %s", entry.Problem, entry.Path, entry.Error)
+				return
+			}
+			log.Printf("%s: %s", entry.Problem, entry.Path)
+		},
+	}
+	report, err := verifier.Verify(ctx, srcRoot, dstRoot)
+	if err != nil {
+		log.Printf("Verification failed: %v", err)
+		return 1
+	}
+	report.Source, report.Destination = positional[0], positional[1]
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Printf("Failed to create report: %v", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Printf("Failed to write report: %v", err)
+		return 1
+	}
+
+	fmt.Fprintln(os.Stderr, report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
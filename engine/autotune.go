@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTuneInterval is how long an AutoTuner measures each worker count
+// before deciding on the next, when Interval is unset.
+const DefaultTuneInterval = 10 * time.Second
+
+// DefaultTuneTolerance is the relative change in throughput an AutoTuner
+// treats as noise, when Tolerance is unset.
+const DefaultTuneTolerance = 0.05
+
+// TunablePool is the part of a WorkerPool an AutoTuner drives.
+type TunablePool interface {
+	SetWorkerCount(count int)
+	WorkerCount() int
+	Paused() bool
+}
+
+// AutoTuner adjusts the worker count of a pool to the throughput it
+// observes, by hill climbing: it keeps changing the count in one direction
+// while throughput improves and turns back when it falls. When throughput
+// holds steady, the per-job latency decides: more workers that only make
+// each job slower are taken away again. The count stays within Min and Max.
+type AutoTuner struct {
+	Pool TunablePool
+	Min  int
+	Max  int
+	// Bytes returns the number of bytes transferred so far; the throughput
+	// of an interval is the difference between two calls.
+	Bytes func() int64
+	// Interval is the time each worker count is measured for. Zero means
+	// DefaultTuneInterval.
+	Interval time.Duration
+	// Tolerance is the relative change in throughput or latency treated
+	// as noise. Zero means DefaultTuneTolerance.
+	Tolerance float64
+	// OnChange, if set, is called with each new worker count and the
+	// throughput in bytes per second that led to it.
+	OnChange func(workers int, throughput float64)
+
+	mu      sync.Mutex
+	jobs    int64
+	jobTime time.Duration
+
+	// The measurements of the previous interval, and the direction of the
+	// last change: +1, -1 or 0 before the first.
+	prevThroughput float64
+	prevLatency    time.Duration
+	direction      int
+}
+
+// Observe records the duration of one finished job.
+func (t *AutoTuner) Observe(d time.Duration) {
+	t.mu.Lock()
+	t.jobs++
+	t.jobTime += d
+	t.mu.Unlock()
+}
+
+// Run tunes the pool every Interval until ctx is done.
+func (t *AutoTuner) Run(ctx context.Context) {
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultTuneInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastBytes := t.Bytes()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			bytes := t.Bytes()
+			throughput := float64(bytes-lastBytes) / now.Sub(last).Seconds()
+			lastBytes, last = bytes, now
+			t.tune(throughput, t.latency())
+		}
+	}
+}
+
+// latency returns the mean duration of the jobs observed since the last
+// call, or 0 if none finished.
+func (t *AutoTuner) latency() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var mean time.Duration
+	if t.jobs > 0 {
+		mean = t.jobTime / time.Duration(t.jobs)
+	}
+	t.jobs, t.jobTime = 0, 0
+	return mean
+}
+
+// tune takes the measurements of one interval and sets the next worker
+// count.
+func (t *AutoTuner) tune(throughput float64, latency time.Duration) {
+	// Intervals spent paused or idle say nothing about the worker count;
+	// measuring starts over from the next one
+	if t.Pool.Paused() || throughput == 0 {
+		t.prevThroughput, t.prevLatency, t.direction = 0, 0, 0
+		return
+	}
+	tolerance := t.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTuneTolerance
+	}
+
+	direction := t.direction
+	switch {
+	case direction == 0 || t.prevThroughput == 0:
+		// Explore upwards first
+		direction = 1
+	case throughput > t.prevThroughput*(1+tolerance):
+		// Keep going while it helps
+	case throughput < t.prevThroughput*(1-tolerance):
+		direction = -direction
+	case t.prevLatency > 0 && float64(latency) > float64(t.prevLatency)*(1+tolerance):
+		// As fast as before, but each job takes longer: the extra
+		// workers only queue behind each other
+		direction = -direction
+	}
+	t.prevThroughput, t.prevLatency = throughput, latency
+
+	workers := t.Pool.WorkerCount()
+	next := min(max(workers+direction*max(workers/8, 1), t.Min, 1), max(t.Max, 1))
+	if next == workers {
+		// At a bound: turn around so the next interval probes inwards
+		t.direction = -direction
+		return
+	}
+	t.direction = direction
+	t.Pool.SetWorkerCount(next)
+	if t.OnChange != nil {
+		t.OnChange(next, throughput)
+	}
+}
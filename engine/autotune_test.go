@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTunablePool is a TunablePool that only records its worker count.
+type fakeTunablePool struct {
+	workers int
+	paused  bool
+}
+
+func (p *fakeTunablePool) SetWorkerCount(count int) { p.workers = count }
+func (p *fakeTunablePool) WorkerCount() int         { return p.workers }
+func (p *fakeTunablePool) Paused() bool             { return p.paused }
+
+// saturating models a backend that scales with workers up to knee, beyond
+// which throughput stays flat and each job takes proportionally longer.
+func saturating(workers, knee int) (float64, time.Duration) {
+	return float64(min(workers, knee)) * 100, time.Duration(max(workers, knee)) * time.Second / time.Duration(knee)
+}
+
+func TestAutoTuner_Converges(t *testing.T) {
+	for _, start := range []int{1, 8, 40, 64} {
+		pool := &fakeTunablePool{workers: start}
+		tuner := &AutoTuner{Pool: pool, Min: 1, Max: 64}
+		for range 60 {
+			tuner.tune(saturating(pool.workers, 16))
+		}
+		if pool.workers < 12 || pool.workers > 20 {
+			t.Errorf("Starting at %d workers, expected to settle near 16, got %d", start, pool.workers)
+		}
+	}
+}
+
+func TestAutoTuner_Bounds(t *testing.T) {
+	pool := &fakeTunablePool{workers: 4}
+	var changes []int
+	tuner := &AutoTuner{Pool: pool, Min: 2, Max: 6, OnChange: func(workers int, _ float64) {
+		changes = append(changes, workers)
+	}}
+	// Throughput that always grows pushes the count to Max, never past it
+	for i := range 20 {
+		tuner.tune(float64(100*(i+1)), time.Second)
+		if pool.workers < 2 || pool.workers > 6 {
+			t.Fatalf("Worker count %d left the bounds", pool.workers)
+		}
+	}
+	if len(changes) == 0 || changes[0] != 5 {
+		t.Errorf("Expected the first change to add a worker, got %v", changes)
+	}
+}
+
+func TestAutoTuner_PausedOrIdle(t *testing.T) {
+	pool := &fakeTunablePool{workers: 8}
+	tuner := &AutoTuner{Pool: pool, Min: 1, Max: 64}
+	tuner.tune(800, time.Second)
+	before := pool.workers
+
+	// A paused or idle interval looks like a collapse, but must not count
+	pool.paused = true
+	tuner.tune(0, 0)
+	pool.paused = false
+	tuner.tune(0, 0)
+	if pool.workers != before {
+		t.Errorf("Expected no change while paused or idle, got %d workers from %d", pool.workers, before)
+	}
+	tuner.tune(800, time.Second)
+	if pool.workers != before+1 {
+		t.Errorf("Expected measuring to start over upwards, got %d workers from %d", pool.workers, before)
+	}
+}
+
+func TestAutoTuner_Run(t *testing.T) {
+	pool := &fakeTunablePool{workers: 2}
+	var bytes atomic.Int64
+	changed := make(chan int, 10)
+	tuner := &AutoTuner{
+		Pool:     pool,
+		Min:      1,
+		Max:      8,
+		Bytes:    func() int64 { return bytes.Add(1000) },
+		Interval: 5 * time.Millisecond,
+		OnChange: func(workers int, _ float64) { changed <- workers },
+	}
+	tuner.Observe(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tuner.Run(ctx)
+		close(done)
+	}()
+	select {
+	case workers := <-changed:
+		if workers != 3 {
+			t.Errorf("Expected the first change to 3 workers, got %d", workers)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the tuner to change the worker count")
+	}
+	cancel()
+	<-done
+}
@@ -2,15 +2,27 @@ package engine
 
 import (
 	"sync"
+	"unsafe"
 )
 
 // DefaultBufferSize is the default size of byte buffers allocated for file transfers.
 // 1MB is generally a good balance for modern fast I/O operations (network/disk).
 const DefaultBufferSize = 1 * 1024 * 1024
 
+// MinBufferSize is the size of the smallest buffer class of a BufferPool;
+// each larger class is 16 times the one below, up to the pool's size.
+const MinBufferSize = 4 * 1024
+
 // BufferPool manages reusable byte buffers to minimize GC overhead during
-// multi-terabyte transfers.
+// multi-terabyte transfers. Buffers come in size classes, so that copying a
+// file of a few KB does not tie up a buffer sized for large streams.
 type BufferPool struct {
+	// classes are ordered by size; the last is the pool's full size.
+	classes []*bufferClass
+}
+
+type bufferClass struct {
+	size int
 	pool sync.Pool
 }
 
@@ -20,27 +32,94 @@ func NewBufferPool(size int) *BufferPool {
 	if size <= 0 {
 		size = DefaultBufferSize
 	}
-	return &BufferPool{
-		pool: sync.Pool{
-			New: func() any {
-				b := make([]byte, size)
-				return &b
-			},
-		},
+	return newBufferPool(size, 1, func(n int) []byte {
+		return make([]byte, n)
+	})
+}
+
+// NewAlignedBufferPool creates a BufferPool whose buffers start at a
+// multiple of align bytes in memory and whose size is rounded up to a
+// multiple of align, as direct I/O requires. If size is <= 0,
+// DefaultBufferSize is used.
+func NewAlignedBufferPool(size, align int) *BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
 	}
+	return newBufferPool(size, align, func(n int) []byte {
+		raw := make([]byte, n+align)
+		offset := 0
+		if rem := int(uintptr(unsafe.Pointer(&raw[0])) % uintptr(align)); rem != 0 {
+			offset = align - rem
+		}
+		return raw[offset : offset+n : offset+n]
+	})
 }
 
-// Get retrieves a reusable byte buffer from the pool.
+// newBufferPool sets up the size classes up to size, each rounded up to a
+// multiple of align, with buffers allocated by alloc.
+func newBufferPool(size, align int, alloc func(n int) []byte) *BufferPool {
+	roundUp := func(n int) int { return (n + align - 1) / align * align }
+	size = roundUp(size)
+
+	bp := &BufferPool{}
+	for n := MinBufferSize; ; n *= 16 {
+		n = min(roundUp(n), size)
+		class := &bufferClass{size: n}
+		class.pool.New = func() any {
+			b := alloc(class.size)
+			return &b
+		}
+		if len(bp.classes) == 0 || bp.classes[len(bp.classes)-1].size < n {
+			bp.classes = append(bp.classes, class)
+		}
+		if n == size {
+			return bp
+		}
+	}
+}
+
+// Get retrieves a reusable byte buffer of the pool's full size.
 // The caller should defer calling Put on this buffer once finished.
 func (bp *BufferPool) Get() *[]byte {
-	return bp.pool.Get().(*[]byte)
+	return bp.classes[len(bp.classes)-1].pool.Get().(*[]byte)
+}
+
+// GetFor retrieves a buffer for copying a file of size bytes: the smallest
+// that holds the whole file, or one of the pool's full size for files
+// larger than that. Sizes below zero are unknown and get a full buffer.
+func (bp *BufferPool) GetFor(size int64) *[]byte {
+	return bp.classFor(size).pool.Get().(*[]byte)
+}
+
+// SizeFor returns the size of the buffer GetFor retrieves for a file of size
+// bytes.
+func (bp *BufferPool) SizeFor(size int64) int {
+	return bp.classFor(size).size
+}
+
+func (bp *BufferPool) classFor(size int64) *bufferClass {
+	if size >= 0 {
+		for _, class := range bp.classes {
+			// One byte more than the file lets a single read reach EOF
+			if int64(class.size) > size {
+				return class
+			}
+		}
+	}
+	return bp.classes[len(bp.classes)-1]
 }
 
 // Put returns the byte buffer to the pool so it can be reused.
 // The caller should not hold onto or read/write to the buffer after calling Put.
 func (bp *BufferPool) Put(b *[]byte) {
 	// A basic sanity check to avoid returning nil pointers.
-	if b != nil {
-		bp.pool.Put(b)
+	if b == nil {
+		return
+	}
+	for _, class := range bp.classes {
+		if class.size == cap(*b) {
+			class.pool.Put(b)
+			return
+		}
 	}
 }
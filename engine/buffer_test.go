@@ -2,6 +2,7 @@ package engine
 
 import (
 	"testing"
+	"unsafe"
 )
 
 func TestBufferPool_DefaultSize(t *testing.T) {
@@ -42,3 +43,60 @@ func TestBufferPool_CustomSize(t *testing.T) {
 
 	bp.Put(buf2)
 }
+
+func TestBufferPool_Aligned(t *testing.T) {
+	bp := NewAlignedBufferPool(10000, 4096)
+	for range 4 {
+		buf := bp.Get()
+		if len(*buf) != 12288 {
+			t.Errorf("expected size rounded up to 12288, got %d", len(*buf))
+		}
+		if addr := uintptr(unsafe.Pointer(&(*buf)[0])); addr%4096 != 0 {
+			t.Errorf("buffer at %#x is not 4096-byte aligned", addr)
+		}
+		bp.Put(buf)
+	}
+}
+
+func TestBufferPool_GetFor(t *testing.T) {
+	bp := NewBufferPool(DefaultBufferSize)
+	for _, tc := range []struct {
+		size int64
+		want int
+	}{
+		{0, MinBufferSize},
+		{100, MinBufferSize},
+		{MinBufferSize, 16 * MinBufferSize},
+		{50 * 1024, 16 * MinBufferSize},
+		{200 * 1024, DefaultBufferSize},
+		{10 * DefaultBufferSize, DefaultBufferSize},
+		{-1, DefaultBufferSize},
+	} {
+		buf := bp.GetFor(tc.size)
+		if len(*buf) != tc.want {
+			t.Errorf("GetFor(%d) returned %d bytes, want %d", tc.size, len(*buf), tc.want)
+		}
+		if got := bp.SizeFor(tc.size); got != tc.want {
+			t.Errorf("SizeFor(%d) = %d, want %d", tc.size, got, tc.want)
+		}
+		bp.Put(buf)
+	}
+
+	// Buffers go back to their own class
+	small := bp.GetFor(10)
+	bp.Put(small)
+	if buf := bp.Get(); len(*buf) != DefaultBufferSize {
+		t.Errorf("Expected a full buffer after returning a small one, got %d bytes", len(*buf))
+	}
+}
+
+func TestBufferPool_AlignedClasses(t *testing.T) {
+	bp := NewAlignedBufferPool(DefaultBufferSize, 4096)
+	for _, size := range []int64{1, 5000, 100000} {
+		buf := bp.GetFor(size)
+		if len(*buf)%4096 != 0 || uintptr(unsafe.Pointer(&(*buf)[0]))%4096 != 0 {
+			t.Errorf("GetFor(%d) returned an unaligned buffer of %d bytes", size, len(*buf))
+		}
+		bp.Put(buf)
+	}
+}
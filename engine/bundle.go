@@ -0,0 +1,384 @@
+package engine
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// BundleDir is the directory, relative to the destination root, that
+// bundles of small files are written to. Plans and mirrors leave it alone.
+const BundleDir = ".gofast-bundles"
+
+// bundleIndexSuffix names the index written next to each bundle.
+const bundleIndexSuffix = ".index.jsonl"
+
+// BundleEntry locates one file stored in a bundle. The file's content is
+// the Size bytes at Offset in the bundle, so it can be read on its own with
+// a ranged read. Paths are slash-separated and relative to the destination
+// root, so the index stays valid if the tree is moved.
+type BundleEntry struct {
+	// Path is where the file would have been written.
+	Path string `json:"path"`
+	// Bundle is the tar bundle holding it.
+	Bundle  string      `json:"bundle"`
+	Offset  int64       `json:"offset"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// BundleOptions configures the bundling of small files.
+type BundleOptions struct {
+	// Threshold is the size up to which files are bundled; 0 writes every
+	// file on its own.
+	Threshold int64
+	// MaxBytes and MaxFiles close a bundle once it holds that much
+	// content or that many files.
+	MaxBytes int64
+	MaxFiles int
+}
+
+// Bundler writes small files into tar bundles under BundleDir instead of as
+// objects of their own, cutting the per-file requests that dominate the
+// transfer of millions of tiny files. Each bundle gets an index mapping its
+// files to their offsets, so they stay individually addressable through
+// ReadBundleIndex and OpenBundled. Up to Streams bundles are written at
+// once.
+type Bundler struct {
+	Dst  provider.Provider
+	Root string
+	// Prefix starts the name of every bundle, so runs do not overwrite
+	// each other's bundles. Later runs need prefixes that sort after
+	// those of earlier ones, so ReadBundleIndex finds the latest copy of a
+	// file.
+	Prefix  string
+	Options BundleOptions
+	Streams int
+	// OnCommit is called with the jobs of each bundle once it and its
+	// index are stored, or with the error that lost them.
+	OnCommit func(jobs []TransferJob, err error)
+
+	mu   sync.Mutex
+	idle []*bundle
+	open int
+	seq  int
+	wake chan struct{}
+}
+
+// bundle is a tar bundle being written.
+type bundle struct {
+	root    string
+	path    string
+	name    string // relative to root
+	w       io.WriteCloser
+	counter *countingWriter
+	tw      *tar.Writer
+	jobs    []TransferJob
+	entries []BundleEntry
+	err     error
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Applies reports whether job is small enough to be bundled.
+func (b *Bundler) Applies(job TransferJob) bool {
+	if b == nil || b.Options.Threshold <= 0 || job.FileInfo == nil || job.FileInfo.IsDir() {
+		return false
+	}
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return false
+	}
+	return job.FileInfo.Size() <= b.Options.Threshold
+}
+
+// Add copies job from src into an open bundle. The job is not complete
+// until OnCommit reports its bundle stored. An error means the job was not
+// added; a bundle that fails to be written loses every job in it, which
+// OnCommit reports.
+func (b *Bundler) Add(ctx context.Context, job TransferJob, src provider.Provider, buf []byte) error {
+	bn, err := b.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	if err := bn.add(ctx, job, src, buf); err != nil {
+		if bn.err != nil {
+			// The bundle itself is broken
+			b.commit(ctx, bn)
+		} else {
+			b.release(ctx, bn)
+		}
+		return err
+	}
+	b.release(ctx, bn)
+	return nil
+}
+
+// Close stores the bundles still open. Call it once no more jobs are added.
+func (b *Bundler) Close(ctx context.Context) {
+	b.mu.Lock()
+	idle := b.idle
+	b.idle = nil
+	b.mu.Unlock()
+	for _, bn := range idle {
+		b.commit(ctx, bn)
+	}
+}
+
+// acquire returns an idle bundle, or opens a new one if fewer than Streams
+// are open, or waits for one to become idle.
+func (b *Bundler) acquire(ctx context.Context) (*bundle, error) {
+	for {
+		b.mu.Lock()
+		if b.wake == nil {
+			b.wake = make(chan struct{})
+		}
+		if n := len(b.idle); n > 0 {
+			bn := b.idle[n-1]
+			b.idle = b.idle[:n-1]
+			b.mu.Unlock()
+			return bn, nil
+		}
+		if b.open < max(b.Streams, 1) {
+			b.open++
+			b.seq++
+			name := fmt.Sprintf("%s-%06d.tar", b.Prefix, b.seq)
+			b.mu.Unlock()
+			bn, err := b.create(ctx, name)
+			if err != nil {
+				b.closed()
+				return nil, err
+			}
+			return bn, nil
+		}
+		wake := b.wake
+		b.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *Bundler) create(ctx context.Context, name string) (*bundle, error) {
+	pth := filepath.Join(b.Root, BundleDir, name)
+	w, err := b.Dst.OpenWrite(ctx, pth, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle %s: %w", pth, err)
+	}
+	counter := &countingWriter{w: w}
+	return &bundle{
+		root:    b.Root,
+		path:    pth,
+		name:    BundleDir + "/" + name,
+		w:       w,
+		counter: counter,
+		tw:      tar.NewWriter(counter),
+	}, nil
+}
+
+// release returns bn to the idle bundles, or stores it if it is full.
+func (b *Bundler) release(ctx context.Context, bn *bundle) {
+	if (b.Options.MaxBytes > 0 && bn.counter.n >= b.Options.MaxBytes) ||
+		(b.Options.MaxFiles > 0 && len(bn.jobs) >= b.Options.MaxFiles) {
+		b.commit(ctx, bn)
+		return
+	}
+	b.mu.Lock()
+	b.idle = append(b.idle, bn)
+	b.signal()
+	b.mu.Unlock()
+}
+
+// commit finishes bn, writes its index and reports its jobs.
+func (b *Bundler) commit(ctx context.Context, bn *bundle) {
+	err := bn.close(ctx, b.Dst)
+	b.closed()
+	if b.OnCommit != nil && len(bn.jobs) > 0 {
+		b.OnCommit(bn.jobs, err)
+	}
+}
+
+// closed forgets a bundle that is no longer open, letting another be
+// opened.
+func (b *Bundler) closed() {
+	b.mu.Lock()
+	b.open--
+	b.signal()
+	b.mu.Unlock()
+}
+
+// signal wakes the goroutines waiting for a bundle. b.mu must be held.
+func (b *Bundler) signal() {
+	if b.wake != nil {
+		close(b.wake)
+		b.wake = make(chan struct{})
+	}
+}
+
+// add appends job to the bundle. Errors reading the source leave the bundle
+// usable only if no content was written yet; otherwise bn.err is set.
+func (bn *bundle) add(ctx context.Context, job TransferJob, src provider.Provider, buf []byte) error {
+	rc, err := src.OpenRead(ctx, job.SourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	defer rc.Close()
+
+	name := job.DestinationPath
+	if rel, err := filepath.Rel(bn.root, job.DestinationPath); err == nil && bn.root != "" {
+		name = rel
+	}
+	mode := fs.FileMode(0644)
+	if m, ok := job.FileInfo.(interface{ Mode() fs.FileMode }); ok {
+		mode = m.Mode().Perm()
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Size:     job.FileInfo.Size(),
+		Mode:     int64(mode),
+		// Whole seconds keep the header in the compact USTAR format; the
+		// index has the exact time
+		ModTime: job.FileInfo.ModTime().Truncate(time.Second),
+	}
+	if err := bn.tw.WriteHeader(hdr); err != nil {
+		bn.err = fmt.Errorf("failed to write bundle %s: %w", bn.path, err)
+		return bn.err
+	}
+	offset := bn.counter.n
+	if _, err := io.CopyBuffer(bn.tw, io.LimitReader(rc, hdr.Size), buf); err != nil {
+		bn.err = fmt.Errorf("failed to bundle %s: %w", job.SourcePath, err)
+		return bn.err
+	}
+	if bn.counter.n-offset != hdr.Size {
+		// The source shrank; the tar entry cannot be completed
+		bn.err = fmt.Errorf("failed to bundle %s: %w", job.SourcePath, io.ErrUnexpectedEOF)
+		return bn.err
+	}
+	bn.jobs = append(bn.jobs, job)
+	bn.entries = append(bn.entries, BundleEntry{
+		Path:    hdr.Name,
+		Bundle:  bn.name,
+		Offset:  offset,
+		Size:    hdr.Size,
+		Mode:    mode,
+		ModTime: job.FileInfo.ModTime(),
+	})
+	return nil
+}
+
+// close finishes the tar stream and writes the index next to it. A bundle
+// that failed is discarded.
+func (bn *bundle) close(ctx context.Context, dst provider.Provider) error {
+	if bn.err != nil {
+		bn.w.Close()
+		provider.DeleteAll(ctx, dst, []string{bn.path})
+		return bn.err
+	}
+	if err := bn.tw.Close(); err != nil {
+		bn.w.Close()
+		return fmt.Errorf("failed to write bundle %s: %w", bn.path, err)
+	}
+	if err := bn.w.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle %s: %w", bn.path, err)
+	}
+
+	w, err := dst.OpenWrite(ctx, bn.path+bundleIndexSuffix, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open index of %s: %w", bn.path, err)
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range bn.entries {
+		if err := enc.Encode(e); err != nil {
+			w.Close()
+			return fmt.Errorf("failed to write index of %s: %w", bn.path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write index of %s: %w", bn.path, err)
+	}
+	return nil
+}
+
+// ReadBundleIndex reads the indexes of the bundles under root on p and
+// returns their entries sorted by path. A file bundled by several runs is
+// listed once, from its most recent bundle.
+func ReadBundleIndex(ctx context.Context, p provider.Provider, root string) ([]BundleEntry, error) {
+	dir := filepath.Join(root, BundleDir)
+	infos, err := p.List(ctx, dir)
+	if provider.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bundles: %w", err)
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), bundleIndexSuffix) {
+			names = append(names, info.Name())
+		}
+	}
+	// Later runs' prefixes sort after earlier ones', so their copies win
+	sort.Strings(names)
+
+	latest := make(map[string]BundleEntry)
+	for _, name := range names {
+		if err := readBundleIndex(ctx, p, filepath.Join(dir, name), latest); err != nil {
+			return nil, err
+		}
+	}
+	entries := make([]BundleEntry, 0, len(latest))
+	for _, e := range latest {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func readBundleIndex(ctx context.Context, p provider.Provider, pth string, into map[string]BundleEntry) error {
+	rc, err := p.OpenRead(ctx, pth)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle index %s: %w", pth, err)
+	}
+	defer rc.Close()
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		var e BundleEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("invalid bundle index %s: %w", pth, err)
+		}
+		into[e.Path] = e
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read bundle index %s: %w", pth, err)
+	}
+	return nil
+}
+
+// OpenBundled opens the content of a bundled file with a ranged read of its
+// bundle under root.
+func OpenBundled(ctx context.Context, p provider.Provider, root string, e BundleEntry) (io.ReadCloser, error) {
+	return provider.OpenReadRange(ctx, p, filepath.Join(root, filepath.FromSlash(e.Bundle)), e.Offset, e.Size)
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestBundler(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	src, dst := provider.NewLocalProvider(srcDir), provider.NewLocalProvider("").WithRoot(dstDir)
+
+	var jobs []TransferJob
+	for i := range 7 {
+		name := fmt.Sprintf("f%d.txt", i)
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(fmt.Sprintf("content of file %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := src.Stat(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, TransferJob{ID: name, SourcePath: name, DestinationPath: filepath.Join(dstDir, "sub", name), FileInfo: info})
+	}
+
+	var mu sync.Mutex
+	committed := 0
+	b := &Bundler{
+		Dst:     dst,
+		Root:    dstDir,
+		Prefix:  "run1",
+		Options: BundleOptions{Threshold: 100, MaxFiles: 3},
+		Streams: 2,
+		OnCommit: func(jobs []TransferJob, err error) {
+			if err != nil {
+				t.Errorf("bundle failed: %v", err)
+			}
+			mu.Lock()
+			committed += len(jobs)
+			mu.Unlock()
+		},
+	}
+	if !b.Applies(jobs[0]) {
+		t.Fatal("expected a small file to be bundled")
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.Add(ctx, job, src, make([]byte, 8)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	b.Close(ctx)
+	if committed != len(jobs) {
+		t.Fatalf("expected %d jobs committed, got %d", len(jobs), committed)
+	}
+
+	entries, err := ReadBundleIndex(ctx, dst, dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(jobs) {
+		t.Fatalf("expected %d index entries, got %d", len(jobs), len(entries))
+	}
+	if entries[0].Path != "sub/f0.txt" {
+		t.Errorf("expected paths relative to the root, got %q", entries[0].Path)
+	}
+	for i, e := range entries {
+		rc, err := OpenBundled(ctx, dst, dstDir, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if want := fmt.Sprintf("content of file %d", i); string(got) != want {
+			t.Errorf("%s: got %q, want %q", e.Path, got, want)
+		}
+	}
+
+	// Bundles are plain tar files holding the paths below the root
+	f, err := os.Open(filepath.Join(dstDir, entries[0].Bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hdr, err := tar.NewReader(f).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(hdr.Name) != "sub" {
+		t.Errorf("unexpected tar entry %q", hdr.Name)
+	}
+
+	big := jobs[0]
+	big.FileInfo = mockFileInfo{name: "big", size: 1000}
+	if b.Applies(big) {
+		t.Error("expected a file above the threshold not to be bundled")
+	}
+}
+
+func TestBundler_ShrunkSource(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	src, dst := provider.NewLocalProvider(srcDir), provider.NewLocalProvider("").WithRoot(dstDir)
+	os.WriteFile(filepath.Join(srcDir, "a"), []byte("short"), 0644)
+	job := TransferJob{ID: "a", SourcePath: "a", DestinationPath: filepath.Join(dstDir, "a"), FileInfo: mockFileInfo{name: "a", size: 50}}
+
+	var lost []TransferJob
+	b := &Bundler{Dst: dst, Root: dstDir, Prefix: "run", Options: BundleOptions{Threshold: 100}, OnCommit: func(jobs []TransferJob, err error) {
+		if err == nil {
+			t.Error("expected the broken bundle to fail")
+		}
+		lost = jobs
+	}}
+	if err := b.Add(ctx, job, src, make([]byte, 32)); err == nil {
+		t.Fatal("expected a source shorter than listed to fail")
+	}
+	if lost != nil {
+		t.Errorf("expected no other jobs lost, got %v", lost)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dstDir, BundleDir)); len(entries) != 0 {
+		t.Errorf("expected the broken bundle to be removed, found %d files", len(entries))
+	}
+}
@@ -1,12 +1,56 @@
 package engine
 
 import (
+	"context"
+	"fmt"
 	"hash"
 	"hash/crc64"
 	"io"
 	"sync"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/zeebo/xxh3"
+)
+
+// ChecksumAlgorithm names a supported 64-bit streaming hash.
+type ChecksumAlgorithm string
+
+const (
+	// AlgorithmCRC64 is CRC-64 with the ISO polynomial, the engine default.
+	AlgorithmCRC64 ChecksumAlgorithm = "crc64"
+	// AlgorithmXXH3 is the 64-bit XXH3 hash, considerably faster than CRC64
+	// on modern CPUs.
+	AlgorithmXXH3 ChecksumAlgorithm = "xxh3"
 )
 
+var crc64Table = crc64.MakeTable(crc64.ISO)
+
+// ParseChecksumAlgorithm validates an algorithm name as given on the command line.
+func ParseChecksumAlgorithm(name string) (ChecksumAlgorithm, error) {
+	switch algo := ChecksumAlgorithm(name); algo {
+	case AlgorithmCRC64, AlgorithmXXH3:
+		return algo, nil
+	}
+	return "", fmt.Errorf("unsupported checksum algorithm %q", name)
+}
+
+// NewHash64 returns a fresh hasher for the given algorithm.
+func NewHash64(algo ChecksumAlgorithm) (hash.Hash64, error) {
+	switch algo {
+	case AlgorithmCRC64:
+		return crc64.New(crc64Table), nil
+	case AlgorithmXXH3:
+		return xxh3.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+}
+
+// FormatChecksum renders a 64-bit digest as fixed-width lowercase hex, the
+// representation used in manifests and the state store.
+func FormatChecksum(sum uint64) string {
+	return fmt.Sprintf("%016x", sum)
+}
+
 // ChecksumWriter wraps an io.Writer to compute a checksum while writing.
 type ChecksumWriter struct {
 	w    io.Writer
@@ -24,7 +68,7 @@ type Hash64 interface {
 func NewChecksumWriter(w io.Writer) *ChecksumWriter {
 	return &ChecksumWriter{
 		w:    w,
-		hash: crc64.New(crc64.MakeTable(crc64.ISO)),
+		hash: crc64.New(crc64Table),
 	}
 }
 
@@ -60,7 +104,7 @@ type ChecksumReader struct {
 func NewChecksumReader(r io.Reader) *ChecksumReader {
 	return &ChecksumReader{
 		r:    r,
-		hash: crc64.New(crc64.MakeTable(crc64.ISO)),
+		hash: crc64.New(crc64Table),
 	}
 }
 
@@ -84,20 +128,96 @@ func (cr *ChecksumReader) BytesRead() int64 {
 	return cr.n
 }
 
+// ChecksumMismatchError reports a transfer whose destination stream did not
+// hash to the same digest as its source stream.
+type ChecksumMismatchError struct {
+	Path        string
+	Source      uint64
+	Destination uint64
+	// SourceBytes and DestinationBytes are the lengths of the two streams.
+	SourceBytes      int64
+	DestinationBytes int64
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: source %s (%d bytes), destination %s (%d bytes)",
+		e.Path, FormatChecksum(e.Source), e.SourceBytes, FormatChecksum(e.Destination), e.DestinationBytes)
+}
+
+// StreamChecksums checksums both ends of one transfer: the source as it is
+// read and the destination as it is written.
+type StreamChecksums struct {
+	reader *ChecksumReader
+	writer *ChecksumWriter
+}
+
+// NewStreamChecksums wraps the source reader and destination writer of a
+// transfer. Copy from Reader to Writer, then call Verify.
+func NewStreamChecksums(r io.Reader, w io.Writer) *StreamChecksums {
+	return &StreamChecksums{
+		reader: NewChecksumReader(r),
+		writer: NewChecksumWriter(w),
+	}
+}
+
+// Reader returns the checksummed source.
+func (s *StreamChecksums) Reader() io.Reader {
+	return s.reader
+}
+
+// Writer returns the checksummed destination.
+func (s *StreamChecksums) Writer() io.Writer {
+	return s.writer
+}
+
+// Verify compares the digests of everything read and written so far. It
+// returns the checksum for the ledger as "crc64:value", or a
+// *ChecksumMismatchError for path.
+func (s *StreamChecksums) Verify(path string) (string, error) {
+	src, dst := s.reader.Checksum(), s.writer.Checksum()
+	if src != dst || s.reader.BytesRead() != s.writer.BytesWritten() {
+		return "", &ChecksumMismatchError{
+			Path:             path,
+			Source:           src,
+			Destination:      dst,
+			SourceBytes:      s.reader.BytesRead(),
+			DestinationBytes: s.writer.BytesWritten(),
+		}
+	}
+	return string(AlgorithmCRC64) + ":" + FormatChecksum(src), nil
+}
+
 // ChecksumPool manages reusable checksum hashers to reduce allocations.
 type ChecksumPool struct {
 	pool sync.Pool
+	algo ChecksumAlgorithm
 }
 
-// NewChecksumPool creates a new ChecksumPool.
+// NewChecksumPool creates a new ChecksumPool of CRC64 hashers.
 func NewChecksumPool() *ChecksumPool {
+	pool, _ := NewChecksumPoolFor(AlgorithmCRC64)
+	return pool
+}
+
+// NewChecksumPoolFor creates a ChecksumPool handing out hashers for algo.
+func NewChecksumPoolFor(algo ChecksumAlgorithm) (*ChecksumPool, error) {
+	if _, err := NewHash64(algo); err != nil {
+		return nil, err
+	}
 	return &ChecksumPool{
+		algo: algo,
 		pool: sync.Pool{
 			New: func() any {
-				return crc64.New(crc64.MakeTable(crc64.ISO))
+				h, _ := NewHash64(algo)
+				return h
 			},
 		},
-	}
+	}, nil
+}
+
+// Algorithm returns the algorithm of the hashers managed by the pool.
+func (cp *ChecksumPool) Algorithm() ChecksumAlgorithm {
+	return cp.algo
 }
 
 // Get retrieves a hasher from the pool.
@@ -115,3 +235,53 @@ func (cp *ChecksumPool) Put(h hash.Hash64) {
 func VerifyChecksum(actual, expected uint64) bool {
 	return actual == expected
 }
+
+// HashFile streams path from p through h using buf and returns the digest
+// and the number of bytes hashed. h is reset before use.
+func HashFile(ctx context.Context, p provider.Provider, path string, h hash.Hash64, buf []byte) (uint64, int64, error) {
+	rc, err := p.OpenRead(ctx, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rc.Close()
+
+	h.Reset()
+	n, err := io.CopyBuffer(h, rc, buf)
+	if err != nil {
+		return 0, n, err
+	}
+	return h.Sum64(), n, nil
+}
+
+// CompareNativeChecksums compares the digests the source and destination
+// providers report for a file, without the engine reading the content.
+// comparable is false when either provider does not implement
+// provider.Checksummer or the two digests use different algorithms; callers
+// should then fall back to streaming verification.
+func CompareNativeChecksums(ctx context.Context, src provider.Provider, srcPath string, dst provider.Provider, dstPath string) (match, comparable bool, err error) {
+	if !provider.CapabilitiesOf(src).Checksums || !provider.CapabilitiesOf(dst).Checksums {
+		return false, false, nil
+	}
+	srcSummer, ok := src.(provider.Checksummer)
+	if !ok {
+		return false, false, nil
+	}
+	dstSummer, ok := dst.(provider.Checksummer)
+	if !ok {
+		return false, false, nil
+	}
+
+	srcDigest, err := srcSummer.Checksum(ctx, srcPath)
+	if err != nil {
+		return false, false, fmt.Errorf("source checksum: %w", err)
+	}
+	dstDigest, err := dstSummer.Checksum(ctx, dstPath)
+	if err != nil {
+		return false, false, fmt.Errorf("destination checksum: %w", err)
+	}
+
+	if !srcDigest.Comparable(dstDigest) {
+		return false, false, nil
+	}
+	return srcDigest.Value == dstDigest.Value, true, nil
+}
@@ -2,9 +2,16 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"hash/crc64"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/franksops/gofast/provider"
 )
 
 func TestChecksumWriter(t *testing.T) {
@@ -163,3 +170,109 @@ func TestChecksumWriterMultipleWrites(t *testing.T) {
 		t.Error("Expected non-zero checksum")
 	}
 }
+
+func TestNewHash64(t *testing.T) {
+	data := []byte("hello world")
+
+	for _, algo := range []ChecksumAlgorithm{AlgorithmCRC64, AlgorithmXXH3} {
+		h1, err := NewHash64(algo)
+		if err != nil {
+			t.Fatalf("NewHash64(%s) failed: %v", algo, err)
+		}
+		h2, _ := NewHash64(algo)
+		h1.Write(data)
+		h2.Write(data)
+		if h1.Sum64() != h2.Sum64() {
+			t.Errorf("%s: expected deterministic checksums", algo)
+		}
+	}
+
+	if _, err := ParseChecksumAlgorithm("md4"); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+}
+
+func TestChecksumPoolFor(t *testing.T) {
+	pool, err := NewChecksumPoolFor(AlgorithmXXH3)
+	if err != nil {
+		t.Fatalf("NewChecksumPoolFor failed: %v", err)
+	}
+	if pool.Algorithm() != AlgorithmXXH3 {
+		t.Errorf("expected xxh3, got %s", pool.Algorithm())
+	}
+
+	expected, _ := NewHash64(AlgorithmXXH3)
+	expected.Write([]byte("data"))
+
+	h := pool.Get()
+	h.Write([]byte("data"))
+	if h.Sum64() != expected.Sum64() {
+		t.Error("pooled hasher does not match a fresh xxh3 hasher")
+	}
+	pool.Put(h)
+}
+
+func TestFormatChecksum(t *testing.T) {
+	if got := FormatChecksum(0xab); got != "00000000000000ab" {
+		t.Errorf("FormatChecksum(0xab) = %q", got)
+	}
+}
+
+func TestCompareNativeChecksums(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "same.txt"), []byte("payload"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "same.txt"), []byte("payload"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "diff.txt"), []byte("changed"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "diff.txt"), []byte("payload"), 0644)
+
+	src := provider.NewLocalProvider(srcDir)
+	dst := provider.NewLocalProvider(dstDir)
+	ctx := context.Background()
+
+	match, comparable, err := CompareNativeChecksums(ctx, src, "same.txt", dst, "same.txt")
+	if err != nil || !comparable || !match {
+		t.Errorf("same.txt: match=%v comparable=%v err=%v", match, comparable, err)
+	}
+
+	match, comparable, err = CompareNativeChecksums(ctx, src, "diff.txt", dst, "diff.txt")
+	if err != nil || !comparable || match {
+		t.Errorf("diff.txt: match=%v comparable=%v err=%v", match, comparable, err)
+	}
+
+	_, comparable, err = CompareNativeChecksums(ctx, newMockProvider(), "x", dst, "same.txt")
+	if err != nil || comparable {
+		t.Errorf("expected non-comparable result for provider without Checksummer, err=%v", err)
+	}
+}
+
+func TestStreamChecksums(t *testing.T) {
+	data := []byte("the quick brown fox")
+
+	var dst bytes.Buffer
+	sc := NewStreamChecksums(bytes.NewReader(data), &dst)
+	if _, err := io.Copy(sc.Writer(), sc.Reader()); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := sc.Verify("fox.txt")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if want := "crc64:" + FormatChecksum(crc64.Checksum(data, crc64Table)); sum != want {
+		t.Errorf("Verify() = %q, want %q", sum, want)
+	}
+
+	// A destination that received other bytes than were read mismatches
+	sc = NewStreamChecksums(bytes.NewReader(data), io.Discard)
+	read, _ := io.ReadAll(sc.Reader())
+	read[0] ^= 0xff
+	sc.Writer().Write(read[:len(read)-1])
+	_, err = sc.Verify("fox.txt")
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Verify() error = %v, want a ChecksumMismatchError", err)
+	}
+	if mismatch.Path != "fox.txt" || mismatch.SourceBytes != int64(len(data)) || mismatch.DestinationBytes != int64(len(data)-1) {
+		t.Errorf("Unexpected mismatch %+v", mismatch)
+	}
+}
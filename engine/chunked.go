@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// ChunkedOptions configures the transfer of large files in chunks.
+type ChunkedOptions struct {
+	// Threshold is the size above which files are split into chunks; 0
+	// transfers every file as one stream.
+	Threshold int64
+	// ChunkSize is the length of each chunk but the last.
+	ChunkSize int64
+	// Streams is the number of chunks transferred at once.
+	Streams int
+}
+
+// Applies reports whether job is large enough to be split.
+func (o ChunkedOptions) Applies(job TransferJob) bool {
+	return o.Threshold > 0 && o.ChunkSize > 0 && o.Streams > 1 &&
+		job.FileInfo != nil && !job.FileInfo.IsDir() && job.FileInfo.Size() > o.Threshold
+}
+
+// Memory estimates the bytes buffered while a file of size bytes is copied
+// in chunks: at most one chunk per stream.
+func (o ChunkedOptions) Memory(size int64) int64 {
+	return min(size, int64(max(o.Streams, 1))*o.ChunkSize)
+}
+
+// CopyChunked copies a large job as chunks read with ranged reads and
+// written on opts.Streams streams at once, so one file is not limited to
+// the throughput of a single stream. The destination assembles the chunks
+// in place or as the parts of a multipart upload, and discards them if any
+// chunk fails. It returns false when the job is below the threshold or the
+// destination cannot write chunks; the caller then streams it.
+func CopyChunked(ctx context.Context, job TransferJob, src, dst provider.Provider, opts ChunkedOptions) (bool, error) {
+	if !opts.Applies(job) || !provider.CapabilitiesOf(dst).ChunkedWrite {
+		return false, nil
+	}
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return false, nil
+	}
+	cw, ok := dst.(provider.ChunkedWriter)
+	if !ok {
+		return false, nil
+	}
+	size := job.FileInfo.Size()
+	file, err := cw.OpenChunked(ctx, job.DestinationPath, size, opts.ChunkSize, job.FileInfo)
+	if errors.Is(err, provider.ErrNotSupported) {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to open destination: %w", err)
+	}
+
+	chunkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for range min(opts.Streams, provider.ChunkCount(size, opts.ChunkSize)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range chunks {
+				if err := copyChunk(chunkCtx, job, src, file, index, size, opts.ChunkSize); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for index := range provider.ChunkCount(size, opts.ChunkSize) {
+		select {
+		case chunks <- index:
+		case <-chunkCtx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		// Abort even when the run is cancelled, so no parts are left behind
+		file.Abort(context.WithoutCancel(ctx))
+		return true, firstErr
+	}
+	return true, file.Commit(ctx)
+}
+
+// copyChunk copies chunk index of job from src to file.
+func copyChunk(ctx context.Context, job TransferJob, src provider.Provider, file provider.ChunkedFile, index int, size, chunkSize int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	offset := int64(index) * chunkSize
+	rc, err := provider.OpenReadRange(ctx, src, job.SourcePath, offset, min(chunkSize, size-offset))
+	if err != nil {
+		return fmt.Errorf("failed to open chunk %d of source: %w", index, err)
+	}
+	defer rc.Close()
+	return file.WriteChunk(ctx, index, rc)
+}
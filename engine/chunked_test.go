@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestCopyChunked(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i * 13)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "big.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, dst := provider.NewLocalProvider(srcDir), provider.NewLocalProvider(dstDir)
+	info, err := src.Stat(ctx, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := TransferJob{SourcePath: "big.bin", DestinationPath: "big.bin", FileInfo: info}
+	opts := ChunkedOptions{Threshold: 500, ChunkSize: 64, Streams: 4}
+
+	if handled, err := CopyChunked(ctx, job, src, dst, ChunkedOptions{Threshold: 2000, ChunkSize: 64, Streams: 4}); handled || err != nil {
+		t.Fatalf("expected a file below the threshold to be streamed, got %v, %v", handled, err)
+	}
+	if handled, err := CopyChunked(ctx, job, src, provider.WithMetrics(dst, provider.NewMetrics()), opts); !handled || err != nil {
+		t.Fatalf("CopyChunked() = %v, %v; want handled", handled, err)
+	}
+	got, err := os.ReadFile(filepath.Join(dstDir, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("chunked copy differs from the source")
+	}
+
+	// A source shorter than listed fails its last chunk, and the partial
+	// file is discarded
+	if err := os.Truncate(filepath.Join(srcDir, "big.bin"), 900); err != nil {
+		t.Fatal(err)
+	}
+	job.DestinationPath = "short.bin"
+	if handled, err := CopyChunked(ctx, job, src, dst, opts); !handled || err == nil {
+		t.Fatalf("expected a short source to fail, got %v, %v", handled, err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "short.bin")); !os.IsNotExist(err) {
+		t.Error("expected the partial file to be removed")
+	}
+
+	if handled, _ := CopyChunked(ctx, job, src, provider.NewLocalProvider(dstDir).WithSparseWrites(true), opts); handled {
+		t.Error("expected a destination refusing chunks to fall back to streaming")
+	}
+}
+
+func TestChunkedOptions_Memory(t *testing.T) {
+	opts := ChunkedOptions{Threshold: 1 << 20, ChunkSize: 64 << 20, Streams: 4}
+	if got := opts.Memory(1 << 30); got != 256<<20 {
+		t.Errorf("Memory() = %d, want %d", got, 256<<20)
+	}
+	if got := opts.Memory(100 << 20); got != 100<<20 {
+		t.Errorf("Memory() of a file smaller than the streams' chunks = %d, want %d", got, 100<<20)
+	}
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// AbortStaleUploads aborts the unfinished multipart uploads below root on
+// dst that were started before cutoff, which crashed runs leave behind to
+// accrue storage cost. Uploads in keep, checkpointed for a resume, are left
+// alone. With dryRun nothing is aborted. It returns the uploads aborted, or
+// that would have been, with paths relative to root, and the errors of the
+// aborts that failed.
+func AbortStaleUploads(ctx context.Context, dst provider.Provider, root string, cutoff time.Time, keep map[string]bool, dryRun bool) ([]provider.PendingUpload, error) {
+	lister, ok := dst.(provider.UploadLister)
+	if !ok || !provider.CapabilitiesOf(dst).ResumableWrite {
+		return nil, provider.ErrNotSupported
+	}
+
+	var stale []provider.PendingUpload
+	err := lister.ListUploads(ctx, root, func(u provider.PendingUpload) error {
+		if u.Initiated.Before(cutoff) && !keep[u.UploadID] {
+			stale = append(stale, u)
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return stale, err
+	}
+
+	var aborted []provider.PendingUpload
+	var errs []error
+	for _, u := range stale {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		if err := lister.AbortUpload(ctx, path.Join(root, u.Path), u.UploadID); err != nil {
+			errs = append(errs, fmt.Errorf("upload %s of %s: %w", u.UploadID, u.Path, err))
+			continue
+		}
+		aborted = append(aborted, u)
+	}
+	return aborted, errors.Join(errs...)
+}
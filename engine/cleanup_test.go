@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// uploadStore holds unfinished multipart uploads, like S3.
+type uploadStore struct {
+	*mockProvider
+	uploads []provider.PendingUpload
+	aborted []string
+	fail    string
+}
+
+func (s *uploadStore) Capabilities() provider.Capabilities {
+	return provider.Capabilities{ResumableWrite: true}
+}
+
+func (s *uploadStore) OpenWriteResumable(ctx context.Context, path string, metadata provider.FileInfo, resume *provider.UploadCheckpoint, onPart func(provider.UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	w, err := s.OpenWrite(ctx, path, metadata)
+	return w, 0, err
+}
+
+func (s *uploadStore) AbortUpload(ctx context.Context, path, uploadID string) error {
+	if uploadID == s.fail {
+		return errors.New("access denied")
+	}
+	s.aborted = append(s.aborted, path+"#"+uploadID)
+	return nil
+}
+
+func (s *uploadStore) ListUploads(ctx context.Context, path string, fn func(provider.PendingUpload) error) error {
+	for _, u := range s.uploads {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	now := time.Now()
+	dst := &uploadStore{mockProvider: newMockProvider(), uploads: []provider.PendingUpload{
+		{Path: "old.bin", UploadID: "u1", Initiated: now.Add(-48 * time.Hour)},
+		{Path: "new.bin", UploadID: "u2", Initiated: now.Add(-time.Hour)},
+		{Path: "resumable.bin", UploadID: "u3", Initiated: now.Add(-72 * time.Hour)},
+	}}
+	ctx := context.Background()
+	cutoff := now.Add(-24 * time.Hour)
+	keep := map[string]bool{"u3": true}
+
+	stale, err := AbortStaleUploads(ctx, dst, "prefix", cutoff, keep, true)
+	if err != nil || len(stale) != 1 || stale[0].UploadID != "u1" || len(dst.aborted) != 0 {
+		t.Fatalf("Expected a dry run to report only u1, got %v, %v, aborted %v", stale, err, dst.aborted)
+	}
+
+	aborted, err := AbortStaleUploads(ctx, dst, "prefix", cutoff, keep, false)
+	if err != nil || len(aborted) != 1 {
+		t.Fatalf("Expected u1 aborted, got %v, %v", aborted, err)
+	}
+	if len(dst.aborted) != 1 || dst.aborted[0] != "prefix/old.bin#u1" {
+		t.Errorf("Expected the upload aborted at its full path, got %v", dst.aborted)
+	}
+
+	dst.aborted, dst.fail = nil, "u1"
+	if aborted, err := AbortStaleUploads(ctx, dst, "", cutoff, nil, false); err == nil || len(aborted) != 1 || aborted[0].UploadID != "u3" {
+		t.Errorf("Expected u1 to fail and u3 to be aborted, got %v, %v", aborted, err)
+	}
+
+	if _, err := AbortStaleUploads(ctx, newMockProvider(), "", cutoff, nil, false); !errors.Is(err, provider.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported without multipart uploads, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// CopyServerSide copies a job with the destination's server-side copy, so
+// the content never passes through gofast and is not hashed locally. It
+// returns the digest the backend reported for the copy, and false when the
+// providers cannot copy this job that way; the caller then streams it.
+func CopyServerSide(ctx context.Context, job TransferJob, src, dst provider.Provider) (provider.Digest, bool, error) {
+	if !provider.CanCopyServerSide(src, dst) {
+		return provider.Digest{}, false, nil
+	}
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return provider.Digest{}, false, nil
+	}
+	copier := dst.(provider.ServerSideCopier)
+	digest, err := copier.CopyFrom(ctx, src, job.SourcePath, job.DestinationPath, job.FileInfo)
+	if errors.Is(err, provider.ErrNotSupported) {
+		return provider.Digest{}, false, nil
+	}
+	return digest, true, err
+}
+
+// FormatDigest renders a digest as "algorithm:value" for the ledger, or ""
+// if the backend reported none.
+func FormatDigest(d provider.Digest) string {
+	if d.Algorithm == "" {
+		return ""
+	}
+	return d.Algorithm + ":" + d.Value
+}
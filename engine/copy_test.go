@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// copyingProvider is a LocalProvider that claims server-side copies from
+// other local providers, recording what it was asked to copy.
+type copyingProvider struct {
+	*provider.LocalProvider
+	err    error
+	copied []string
+}
+
+func (c *copyingProvider) Capabilities() provider.Capabilities {
+	caps := c.LocalProvider.Capabilities()
+	caps.ServerSideCopy = true
+	return caps
+}
+
+func (c *copyingProvider) CanCopyFrom(src provider.Provider) bool {
+	_, ok := provider.Unwrap(src).(*provider.LocalProvider)
+	return ok
+}
+
+func (c *copyingProvider) CopyFrom(ctx context.Context, src provider.Provider, srcPath, dstPath string, info provider.FileInfo) (provider.Digest, error) {
+	if c.err != nil {
+		return provider.Digest{}, c.err
+	}
+	c.copied = append(c.copied, srcPath+"->"+dstPath)
+	return provider.Digest{Algorithm: provider.DigestCRC32C, Value: "c99465aa"}, nil
+}
+
+func TestCopyServerSide(t *testing.T) {
+	ctx := context.Background()
+	src := provider.NewLocalProvider(t.TempDir())
+	dst := &copyingProvider{LocalProvider: provider.NewLocalProvider(t.TempDir())}
+	job := TransferJob{SourcePath: "a.txt", DestinationPath: "b.txt"}
+
+	digest, handled, err := CopyServerSide(ctx, job, provider.WithMetrics(src, provider.NewMetrics()), dst)
+	if err != nil || !handled {
+		t.Fatalf("CopyServerSide() = %v, %v; want handled", handled, err)
+	}
+	if got := FormatDigest(digest); got != "crc32c:c99465aa" {
+		t.Errorf("FormatDigest() = %q", got)
+	}
+	if len(dst.copied) != 1 || dst.copied[0] != "a.txt->b.txt" {
+		t.Errorf("unexpected copies %v", dst.copied)
+	}
+
+	dst.err = provider.ErrNotSupported
+	if _, handled, err := CopyServerSide(ctx, job, src, dst); handled || err != nil {
+		t.Errorf("expected ErrNotSupported to fall back to streaming, got %v, %v", handled, err)
+	}
+
+	if _, handled, _ := CopyServerSide(ctx, job, src, &provider.S3Provider{}); handled {
+		t.Error("expected a destination that cannot read the source to be skipped")
+	}
+}
@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// DefaultDedupCandidates is the number of destination files of a job's
+// size whose checksums are compared with it.
+const DefaultDedupCandidates = 8
+
+// DedupIndex finds content the destination already holds, by size and then
+// by the checksums the providers report, so a file is not transferred
+// again: at its own path it is skipped, and elsewhere it is copied
+// server-side within the destination. Files written during the run are
+// added as they complete, so duplicates within the source are transferred
+// once. It is safe for concurrent use.
+type DedupIndex struct {
+	dst provider.Provider
+	// MaxCandidates bounds the files of the same size compared per job;
+	// 0 means DefaultDedupCandidates.
+	MaxCandidates int
+	// Hardlink links matches elsewhere at the destination instead of
+	// copying them, when the destination implements provider.Linker, so
+	// identical files are stored once.
+	Hardlink bool
+
+	mu      sync.Mutex
+	bySize  map[int64][]string
+	digests map[string]provider.Digest
+}
+
+// BuildDedupIndex lists the files below root on dst into an index. gofast's
+// own state replica, bundles and sidecars are left out.
+func BuildDedupIndex(ctx context.Context, dst provider.Provider, root string) (*DedupIndex, error) {
+	d := &DedupIndex{
+		dst:     dst,
+		bySize:  make(map[int64][]string),
+		digests: make(map[string]provider.Digest),
+	}
+	info, err := dst.Stat(ctx, root)
+	if provider.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat destination %s: %w", root, err)
+	}
+	if !info.IsDir() {
+		return d, nil
+	}
+	files, err := ListTree(ctx, dst, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+	for rel, info := range files {
+		if strings.HasPrefix(rel, StateReplicaDir+"/") || strings.HasPrefix(rel, BundleDir+"/") || provider.IsSidecarPath(rel) {
+			continue
+		}
+		d.Add(filepath.Join(root, filepath.FromSlash(rel)), info.Size())
+	}
+	return d, nil
+}
+
+// Add records a destination file of size bytes.
+func (d *DedupIndex) Add(path string, size int64) {
+	if size <= 0 {
+		// Empty files cost nothing to write
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The file may have been rewritten since its checksum was asked for
+	delete(d.digests, path)
+	for _, p := range d.bySize[size] {
+		if p == path {
+			return
+		}
+	}
+	d.bySize[size] = append(d.bySize[size], path)
+}
+
+// Len returns the number of files indexed.
+func (d *DedupIndex) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, paths := range d.bySize {
+		n += len(paths)
+	}
+	return n
+}
+
+// Find returns a destination file with job's size and checksum, preferring
+// job's own destination path, or "" if there is none. Only checksums the
+// providers report and that are comparable count, so files whose digests
+// differ in kind are never matched.
+func (d *DedupIndex) Find(ctx context.Context, job TransferJob, src provider.Provider) (string, error) {
+	if job.FileInfo == nil || job.FileInfo.IsDir() {
+		return "", nil
+	}
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return "", nil
+	}
+	candidates := d.candidates(job)
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	srcSummer, ok := src.(provider.Checksummer)
+	if !ok || !provider.CapabilitiesOf(src).Checksums {
+		return "", nil
+	}
+	dstSummer, ok := d.dst.(provider.Checksummer)
+	if !ok || !provider.CapabilitiesOf(d.dst).Checksums {
+		return "", nil
+	}
+
+	srcDigest, err := srcSummer.Checksum(ctx, job.SourcePath)
+	if err != nil {
+		return "", fmt.Errorf("source checksum: %w", err)
+	}
+	for _, path := range candidates {
+		dstDigest, err := d.digest(ctx, dstSummer, path)
+		if provider.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("destination checksum: %w", err)
+		}
+		if srcDigest.Comparable(dstDigest) && srcDigest.Value == dstDigest.Value {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// candidates returns the indexed files of job's size, its own destination
+// path first, at most MaxCandidates of them.
+func (d *DedupIndex) candidates(job TransferJob) []string {
+	limit := d.MaxCandidates
+	if limit <= 0 {
+		limit = DefaultDedupCandidates
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var candidates []string
+	for _, p := range d.bySize[job.FileInfo.Size()] {
+		if p == job.DestinationPath {
+			candidates = append([]string{p}, candidates...)
+		} else {
+			candidates = append(candidates, p)
+		}
+	}
+	return candidates[:min(len(candidates), limit)]
+}
+
+// digest returns the checksum of a destination file, asking dst only once.
+func (d *DedupIndex) digest(ctx context.Context, summer provider.Checksummer, path string) (provider.Digest, error) {
+	d.mu.Lock()
+	digest, ok := d.digests[path]
+	d.mu.Unlock()
+	if ok {
+		return digest, nil
+	}
+	digest, err := summer.Checksum(ctx, path)
+	if err != nil {
+		return provider.Digest{}, err
+	}
+	d.mu.Lock()
+	d.digests[path] = digest
+	d.mu.Unlock()
+	return digest, nil
+}
+
+// Apply completes job from content the destination already holds. It
+// returns the destination file the content came from, which is job's own
+// destination path if that already holds it, or "" when the job has to be
+// transferred: no match was found, or the match is elsewhere and the
+// destination can neither link nor copy it server-side. A
+// *provider.MetadataError is returned along with the match, as the content
+// was copied.
+func (d *DedupIndex) Apply(ctx context.Context, job TransferJob, src provider.Provider) (string, error) {
+	match, err := d.Find(ctx, job, src)
+	if err != nil || match == "" || match == job.DestinationPath {
+		return match, err
+	}
+	if linked, err := d.link(ctx, job, match); linked || err != nil {
+		if err != nil {
+			return "", err
+		}
+		return match, nil
+	}
+	if !provider.CanCopyServerSide(d.dst, d.dst) {
+		return "", nil
+	}
+	copier := d.dst.(provider.ServerSideCopier)
+	_, err = copier.CopyFrom(ctx, d.dst, match, job.DestinationPath, job.FileInfo)
+	if errors.Is(err, provider.ErrNotSupported) {
+		return "", nil
+	}
+	var metaErr *provider.MetadataError
+	if err != nil && !errors.As(err, &metaErr) {
+		return "", err
+	}
+	// The content is in place even if its metadata could not be applied
+	d.Add(job.DestinationPath, job.FileInfo.Size())
+	return match, err
+}
+
+// link makes job's destination path a hard link to match when Hardlink is
+// set. The names share their metadata, so files are only linked when their
+// permissions, and ownership where the destination preserves it, agree.
+func (d *DedupIndex) link(ctx context.Context, job TransferJob, match string) (bool, error) {
+	if !d.Hardlink || !provider.CapabilitiesOf(d.dst).Hardlinks {
+		return false, nil
+	}
+	info, err := d.dst.Stat(ctx, match)
+	if err != nil {
+		return false, err
+	}
+	if !sameOwnership(job.FileInfo, info, provider.CapabilitiesOf(d.dst).Metadata) {
+		return false, nil
+	}
+	err = d.dst.(provider.Linker).Link(ctx, match, job.DestinationPath)
+	if errors.Is(err, provider.ErrNotSupported) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	d.Add(job.DestinationPath, job.FileInfo.Size())
+	return true, nil
+}
+
+// sameOwnership reports whether a file written from src would get the
+// permissions of dst and, with owners set, its owner and group. Sources
+// that report no permissions are written as 0644.
+func sameOwnership(src, dst provider.FileInfo, owners bool) bool {
+	mode := os.FileMode(0644)
+	us, ok := src.(provider.UnixFileInfo)
+	if ok && us.Mode() != 0 {
+		mode = us.Mode()
+	}
+	ud, okDst := dst.(provider.UnixFileInfo)
+	if !okDst {
+		return true
+	}
+	if mode.Perm() != ud.Mode().Perm() {
+		return false
+	}
+	return !owners || !ok || us.UID() == ud.UID() && us.GID() == ud.GID()
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestDedupIndex(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	write := func(dir, name, content string) {
+		t.Helper()
+		full := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(full), 0755)
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(srcDir, "same.txt", "unchanged content")
+	write(srcDir, "moved.txt", "content that moved")
+	write(srcDir, "new.txt", "brand new content!")
+	write(dstDir, "same.txt", "unchanged content")
+	write(dstDir, "old/place.txt", "content that moved")
+	write(dstDir, ".gofast-state/state.db", "brand new content!")
+
+	src := provider.NewLocalProvider("").WithRoot(srcDir)
+	dst := provider.NewLocalProvider("").WithRoot(dstDir)
+	index, err := BuildDedupIndex(ctx, dst, dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := index.Len(); n != 2 {
+		t.Fatalf("expected 2 files indexed, got %d", n)
+	}
+	job := func(name string) TransferJob {
+		info, err := src.Stat(ctx, filepath.Join(srcDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return TransferJob{SourcePath: filepath.Join(srcDir, name), DestinationPath: filepath.Join(dstDir, name), FileInfo: info}
+	}
+
+	if match, err := index.Apply(ctx, job("same.txt"), src); err != nil || match != filepath.Join(dstDir, "same.txt") {
+		t.Errorf("expected the file at its own path to match, got %q, %v", match, err)
+	}
+	// Same size, different content, and the state replica is not data
+	if match, err := index.Apply(ctx, job("new.txt"), src); err != nil || match != "" {
+		t.Errorf("expected no match for new content, got %q, %v", match, err)
+	}
+
+	if !provider.CapabilitiesOf(dst).ServerSideCopy {
+		t.Skip("no server-side copies on this platform")
+	}
+	match, err := index.Apply(ctx, job("moved.txt"), src)
+	if err != nil || match != filepath.Join(dstDir, "old", "place.txt") {
+		t.Fatalf("expected the moved content to be found, got %q, %v", match, err)
+	}
+	got, err := os.ReadFile(filepath.Join(dstDir, "moved.txt"))
+	if err != nil || string(got) != "content that moved" {
+		t.Errorf("expected the content copied within the destination, got %q, %v", got, err)
+	}
+}
+
+func TestDedupIndex_Hardlink(t *testing.T) {
+	if !provider.CapabilitiesOf(provider.NewLocalProvider("")).Hardlinks {
+		t.Skip("no hard links on this platform")
+	}
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	for name, mode := range map[string]os.FileMode{"copy.txt": 0644, "private.txt": 0600} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("identical"), mode); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(filepath.Join(srcDir, name), mode)
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, "original.txt"), []byte("identical"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(filepath.Join(dstDir, "original.txt"), 0644)
+
+	src := provider.NewLocalProvider("").WithRoot(srcDir)
+	dst := provider.NewLocalProvider("").WithRoot(dstDir).WithMetadataMapper(nil)
+	index, err := BuildDedupIndex(ctx, dst, dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Hardlink = true
+	apply := func(name string) string {
+		t.Helper()
+		info, err := src.Stat(ctx, filepath.Join(srcDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := TransferJob{SourcePath: filepath.Join(srcDir, name), DestinationPath: filepath.Join(dstDir, name), FileInfo: info}
+		match, err := index.Apply(ctx, job, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return match
+	}
+
+	if match := apply("copy.txt"); match != filepath.Join(dstDir, "original.txt") {
+		t.Fatalf("expected a match, got %q", match)
+	}
+	original, _ := os.Stat(filepath.Join(dstDir, "original.txt"))
+	linked, _ := os.Stat(filepath.Join(dstDir, "copy.txt"))
+	if !os.SameFile(original, linked) {
+		t.Error("expected copy.txt to be linked to original.txt")
+	}
+
+	// Different permissions cannot share a file, so it is copied instead
+	if match := apply("private.txt"); match == "" {
+		t.Fatal("expected a match")
+	}
+	private, err := os.Stat(filepath.Join(dstDir, "private.txt"))
+	if err != nil || os.SameFile(original, private) {
+		t.Errorf("expected private.txt to be a separate file, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// DirSet collects the directories a walk visits, as jobs carrying each
+// directory's FileInfo, so they can be created at the destination with
+// their ownership, permissions and times once their files are written.
+// Creating them last keeps a read-only directory from refusing its own
+// files, and covers empty directories, which no file write creates; going
+// deepest first keeps the entries made below a directory from moving its
+// modification time on again. It is safe for concurrent use.
+type DirSet struct {
+	// MTimes adjusts out-of-range modification times of directories as
+	// for files; the zero value preserves them.
+	MTimes MTimeChecker
+
+	// OnError, if set, is called with each directory Create failed on.
+	OnError func(path string, err error)
+
+	mu   sync.Mutex
+	jobs []TransferJob
+}
+
+// Add records the directory of job, with its FileInfo compacted.
+func (d *DirSet) Add(job TransferJob) {
+	job.FileInfo = CompactFileInfo(job.FileInfo)
+	d.mu.Lock()
+	d.jobs = append(d.jobs, job)
+	d.mu.Unlock()
+}
+
+// Len returns the number of directories recorded.
+func (d *DirSet) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.jobs)
+}
+
+// Create creates every recorded directory on dst, deepest first, applying
+// the metadata of its source. Call it once nothing more is written to dst,
+// deletes included, or the times it sets move on. rebase, if set, maps
+// each destination path, e.g. to a replica's root. Directories that fail
+// are reported to OnError and left out; Create returns the number created,
+// and an error only if it was cancelled. Destinations without directories
+// of their own (provider.DirMaker) are left alone.
+func (d *DirSet) Create(ctx context.Context, dst provider.Provider, rebase func(string) (string, error)) (int, error) {
+	if !provider.CapabilitiesOf(dst).Directories {
+		return 0, nil
+	}
+	d.mu.Lock()
+	jobs := append([]TransferJob(nil), d.jobs...)
+	d.mu.Unlock()
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return dirDepth(jobs[i].DestinationPath) > dirDepth(jobs[j].DestinationPath)
+	})
+
+	created := 0
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return created, err
+		}
+		path := job.DestinationPath
+		var err error
+		if rebase != nil {
+			path, err = rebase(path)
+		}
+		if err == nil {
+			info, _ := d.MTimes.Check(job.FileInfo)
+			err = provider.MakeDir(ctx, dst, path, info)
+		}
+		if err != nil && d.OnError != nil {
+			d.OnError(path, err)
+		}
+		// A directory whose metadata could not be applied still exists
+		var metaErr *provider.MetadataError
+		if err == nil || errors.As(err, &metaErr) {
+			created++
+		}
+	}
+	return created, nil
+}
+
+// dirDepth returns the number of path elements of p.
+func dirDepth(p string) int {
+	return strings.Count(strings.ReplaceAll(p, "\\", "/"), "/")
+}
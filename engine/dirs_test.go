@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestDirSet_WalkAndCreate(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir, replicaDir := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, dir := range []string{"empty", "locked/inner"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "locked/inner/f.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(srcDir, "locked"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{"", "empty", "locked", "locked/inner"}
+	for i, dir := range dirs {
+		mtime := time.Date(2001, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(srcDir, dir), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(srcDir, "locked"), 0o755)
+		os.Chmod(filepath.Join(dstDir, "locked"), 0o755)
+	})
+
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(provider.NewLocalProvider("/"), jobChan)
+	walker.Dirs = &DirSet{}
+	if err := walker.Walk(ctx, srcDir, dstDir); err != nil {
+		t.Fatal(err)
+	}
+	close(jobChan)
+	if n := walker.Dirs.Len(); n != 4 {
+		t.Fatalf("Expected the root and 3 directories recorded, got %d", n)
+	}
+	for _, job := range walker.Dirs.jobs {
+		// Windows attributes are kept uncompacted
+		if _, ok := job.FileInfo.(*compactFileInfo); !ok && runtime.GOOS != "windows" {
+			t.Errorf("Expected %s recorded with a compact FileInfo, got %T", job.DestinationPath, job.FileInfo)
+		}
+	}
+
+	// The file is written first, as by the run's workers
+	for job := range jobChan {
+		if err := os.MkdirAll(filepath.Dir(job.DestinationPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(job.DestinationPath, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var failed []string
+	walker.Dirs.OnError = func(path string, err error) { failed = append(failed, path) }
+	n, err := walker.Dirs.Create(ctx, provider.NewLocalProvider("/"), nil)
+	if err != nil || n != 4 || len(failed) != 0 {
+		t.Fatalf("Expected 4 directories created, got %d (%v, failed %v)", n, err, failed)
+	}
+	if info, err := os.Stat(filepath.Join(dstDir, "empty")); err != nil || !info.IsDir() {
+		t.Errorf("Expected the empty directory to be created, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dstDir, "locked")); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o555 {
+		t.Errorf("Expected the directory's mode to be applied, got %v", info.Mode().Perm())
+	}
+	// Parents keep their times although entries were made below them after
+	for _, dir := range dirs {
+		src, err := os.Stat(filepath.Join(srcDir, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.Stat(filepath.Join(dstDir, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !dst.ModTime().Equal(src.ModTime()) {
+			t.Errorf("Expected %q to have mtime %v, got %v", dir, src.ModTime(), dst.ModTime())
+		}
+	}
+
+	// Replicas take the same directories below their own root
+	n, err = walker.Dirs.Create(ctx, provider.NewLocalProvider("/"), func(p string) (string, error) {
+		rel, err := filepath.Rel(dstDir, p)
+		return filepath.Join(replicaDir, rel), err
+	})
+	if err != nil || n != 4 {
+		t.Fatalf("Expected 4 directories created on the replica, got %d (%v)", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(replicaDir, "empty")); err != nil {
+		t.Errorf("Expected the replica's empty directory, got %v", err)
+	}
+	os.Chmod(filepath.Join(replicaDir, "locked"), 0o755)
+
+	// Destinations without directories are left alone
+	plain := struct{ provider.Provider }{provider.NewLocalProvider("/")}
+	if n, err := walker.Dirs.Create(ctx, plain, nil); err != nil || n != 0 || len(failed) != 0 {
+		t.Errorf("Expected nothing created, got %d (%v, failed %v)", n, err, failed)
+	}
+}
@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// EncryptionKeySize is the size of the AES-256 keys the encrypt and decrypt
+// transforms take.
+const EncryptionKeySize = 32
+
+const (
+	// encryptSegment is the plaintext each sealed segment holds.
+	encryptSegment = 64 * 1024
+	// streamSaltSize is the random salt each stream's key is derived with,
+	// so that streams sealed under one key never share a key of their own.
+	streamSaltSize = 32
+	// noncePrefixSize is the random part of every segment's nonce; the
+	// rest is the segment's number and whether it is the last.
+	noncePrefixSize = 7
+	// encryptHeaderSize is the size of the header starting every stream:
+	// the magic number, the salt and the nonce prefix.
+	encryptHeaderSize = 4 + streamSaltSize + noncePrefixSize
+)
+
+// encryptMagic starts every encrypted stream.
+var encryptMagic = []byte("GFE2")
+
+// ErrDecrypt is returned when an encrypted stream was not written with the
+// key, or was modified or cut short since.
+var ErrDecrypt = errors.New("encrypted stream is corrupt, truncated or was written with another key")
+
+// LoadEncryptionKey reads a key of EncryptionKeySize bytes from name, as raw
+// bytes or hex.
+func LoadEncryptionKey(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == EncryptionKeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("%s does not hold a %d-byte key, raw or in hex", name, EncryptionKeySize)
+	}
+	return key, nil
+}
+
+// NewEncryptWriter returns a writer that encrypts what is written to it
+// with AES-256-GCM, writing to w. The stream starts with a 4-byte magic
+// number, a random salt and a random nonce prefix, followed by segments of
+// 64 KiB of plaintext sealed one by one, so that it can be decrypted as it
+// streams. Segments are sealed under a key derived from key and the salt
+// with HKDF-SHA256, so that nonces need only be unique within the stream
+// however many files share key. Each segment's nonce holds its number and
+// whether it is the last, so segments cannot be reordered, dropped or cut
+// off undetected. Close seals the last segment; it does not close w.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key is %d bytes, want %d", len(key), EncryptionKeySize)
+	}
+	header := make([]byte, encryptHeaderSize)
+	copy(header, encryptMagic)
+	if _, err := rand.Read(header[len(encryptMagic):]); err != nil {
+		return nil, err
+	}
+	aead, err := newStreamAEAD(key, header[len(encryptMagic):len(encryptMagic)+streamSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, encryptSegment)}
+	copy(e.prefix[:], header[len(encryptMagic)+streamSaltSize:])
+	return e, nil
+}
+
+// NewDecryptReader returns a reader of the plaintext of the stream r holds,
+// as NewEncryptWriter writes it. Reads fail with ErrDecrypt if it does not
+// authenticate.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key is %d bytes, want %d", len(key), EncryptionKeySize)
+	}
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(encryptMagic)], encryptMagic) {
+		return nil, ErrDecrypt
+	}
+	aead, err := newStreamAEAD(key, header[len(encryptMagic):len(encryptMagic)+streamSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	d := &decryptReader{r: bufio.NewReaderSize(r, encryptSegment+aead.Overhead()+1), aead: aead}
+	copy(d.prefix[:], header[len(encryptMagic)+streamSaltSize:])
+	return d, nil
+}
+
+// newStreamAEAD returns the cipher of a stream, keyed with the key derived
+// from key and the stream's salt.
+func newStreamAEAD(key, salt []byte) (cipher.AEAD, error) {
+	streamKey, err := hkdf.Key(sha256.New, key, salt, string(encryptMagic), EncryptionKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce returns the nonce of segment n of a stream.
+func segmentNonce(prefix [noncePrefixSize]byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix[:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, n)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix [noncePrefixSize]byte
+	header []byte // written before the first segment, then nil
+	buf    []byte
+	sealed []byte
+	n      uint32
+	err    error
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more follows it, as the last
+		// segment is sealed differently
+		if len(e.buf) == encryptSegment {
+			if e.err = e.seal(false); e.err != nil {
+				return written, e.err
+			}
+		}
+		n := min(len(p), encryptSegment-len(e.buf))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.header != nil {
+		header := e.header
+		e.header = nil
+		if _, err := e.w.Write(header); err != nil {
+			return err
+		}
+	}
+	if e.n == ^uint32(0) {
+		return errors.New("stream too long to encrypt")
+	}
+	e.sealed = e.aead.Seal(e.sealed[:0], segmentNonce(e.prefix, e.n, last), e.buf, nil)
+	e.n++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.seal(true)
+	if e.err != nil {
+		return e.err
+	}
+	e.err = errors.New("write to closed encrypt writer")
+	return nil
+}
+
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix [noncePrefixSize]byte
+	n      uint32
+	buf    []byte
+	plain  []byte
+	done   bool
+	err    error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open decrypts the next segment. A full segment followed by more bytes is
+// not the last; anything else must be.
+func (d *decryptReader) open() error {
+	sealedSize := encryptSegment + d.aead.Overhead()
+	next, err := d.r.Peek(sealedSize + 1)
+	last := len(next) <= sealedSize
+	if last && err != io.EOF {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if last {
+		sealedSize = len(next)
+	}
+	plain, err := d.aead.Open(d.buf[:0], segmentNonce(d.prefix, d.n, last), next[:sealedSize], nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.r.Discard(sealedSize)
+	d.n++
+	d.buf, d.plain, d.done = plain, plain, last
+	return nil
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encrypt(t *testing.T, key, data []byte, chunk int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) > 0 {
+		n := min(len(data), chunk)
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{42}, EncryptionKeySize)
+	for _, size := range []int{0, 1, encryptSegment - 1, encryptSegment, encryptSegment + 1, 3*encryptSegment + 100} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 31)
+		}
+		sealed := encrypt(t, key, data, 1000)
+		got, err := decrypt(key, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: decrypt failed: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: round trip returned %d bytes", size, len(got))
+		}
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{42}, EncryptionKeySize)
+	data := bytes.Repeat([]byte("x"), 2*encryptSegment+10)
+	sealed := encrypt(t, key, data, len(data))
+	segment := encryptSegment + 16
+	header := encryptHeaderSize
+
+	flipped := bytes.Clone(sealed)
+	flipped[header+10] ^= 1
+	swapped := bytes.Clone(sealed)
+	copy(swapped[header:], sealed[header+segment:header+2*segment])
+	copy(swapped[header+segment:], sealed[header:header+segment])
+
+	tests := map[string][]byte{
+		"modified":         flipped,
+		"reordered":        swapped,
+		"cut at a segment": sealed[:header+2*segment],
+		"cut mid-segment":  sealed[:len(sealed)-5],
+		"header only":      sealed[:header],
+	}
+	for name, stream := range tests {
+		if _, err := decrypt(key, stream); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: expected ErrDecrypt, got %v", name, err)
+		}
+	}
+	other := bytes.Repeat([]byte{43}, EncryptionKeySize)
+	if _, err := decrypt(other, sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected another key to fail, got %v", err)
+	}
+	if _, err := NewDecryptReader(bytes.NewReader([]byte("plain text")), key); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected a stream without the header to fail, got %v", err)
+	}
+}
+
+func TestEncryptStreamKeys(t *testing.T) {
+	key := bytes.Repeat([]byte{42}, EncryptionKeySize)
+	data := bytes.Repeat([]byte("x"), 100)
+	a, b := encrypt(t, key, data, len(data)), encrypt(t, key, data, len(data))
+	salt := a[len(encryptMagic) : len(encryptMagic)+streamSaltSize]
+	if bytes.Equal(salt, b[len(encryptMagic):len(encryptMagic)+streamSaltSize]) {
+		t.Fatal("Expected every stream to have a salt of its own")
+	}
+
+	// Segments are sealed under a key derived from the salt, not key itself
+	copy(b[len(encryptMagic):], salt)
+	if _, err := decrypt(key, b); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected a stream to fail under another's salt, got %v", err)
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{9}, EncryptionKeySize)
+	files := map[string][]byte{
+		"raw": key,
+		"hex": []byte(hex.EncodeToString(key) + "\n"),
+		"bad": []byte("too short"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"raw", "hex"} {
+		got, err := LoadEncryptionKey(filepath.Join(dir, name))
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("%s: got %x (%v), want %x", name, got, err, key)
+		}
+	}
+	if _, err := LoadEncryptionKey(filepath.Join(dir, "bad")); err == nil {
+		t.Error("Expected a malformed key file to be rejected")
+	}
+}
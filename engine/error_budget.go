@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrorBudget is how many failed files a run tolerates before it aborts.
+// The zero value tolerates any number: the run continues to completion and
+// reports its failures at the end.
+type ErrorBudget struct {
+	// Failures aborts the run once this many files have failed; 0 sets no
+	// limit. A budget of 1 fails fast.
+	Failures int64
+	// Rate aborts the run once more than this share of the files finished
+	// so far has failed, e.g. 0.02 for 2%, counted from
+	// ErrorRateMinFiles files on; 0 sets no limit.
+	Rate float64
+}
+
+// ErrorRateMinFiles is the number of finished files below which a rate
+// budget is not applied, so the first failure of a run does not count as
+// all of it failing.
+const ErrorRateMinFiles = 100
+
+// ParseErrorBudget parses an -error-budget flag value: "continue" (or an
+// empty string) for no limit, "fail-fast" to abort at the first failure, a
+// count such as "25", or a rate such as "2%".
+func ParseErrorBudget(s string) (ErrorBudget, error) {
+	switch s = strings.TrimSpace(s); s {
+	case "", "continue":
+		return ErrorBudget{}, nil
+	case "fail-fast":
+		return ErrorBudget{Failures: 1}, nil
+	}
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		rate, err := strconv.ParseFloat(pct, 64)
+		if err != nil || rate <= 0 || rate >= 100 {
+			return ErrorBudget{}, fmt.Errorf("invalid error rate %q (want a percentage between 0 and 100)", s)
+		}
+		return ErrorBudget{Rate: rate / 100}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return ErrorBudget{}, fmt.Errorf("invalid error budget %q (want continue, fail-fast, a count or a percentage)", s)
+	}
+	return ErrorBudget{Failures: n}, nil
+}
+
+// String returns the budget in the form ParseErrorBudget accepts.
+func (b ErrorBudget) String() string {
+	switch {
+	case b.Failures == 1:
+		return "fail-fast"
+	case b.Failures > 0:
+		return strconv.FormatInt(b.Failures, 10)
+	case b.Rate > 0:
+		return strconv.FormatFloat(b.Rate*100, 'f', -1, 64) + "%"
+	}
+	return "continue"
+}
+
+// Exceeded reports whether failures out of files finished exhaust the
+// budget.
+func (b ErrorBudget) Exceeded(failures, files int64) bool {
+	if b.Failures > 0 && failures >= b.Failures {
+		return true
+	}
+	return b.Rate > 0 && files >= ErrorRateMinFiles && float64(failures) > b.Rate*float64(files)
+}
+
+// FailureCounter counts the files of a run as they finish and tells when
+// their failures exhaust an ErrorBudget. It is safe for concurrent use.
+type FailureCounter struct {
+	Budget ErrorBudget
+
+	mu       sync.Mutex
+	files    int64
+	failures int64
+	exceeded bool
+}
+
+// Record counts a finished file, failed if err is set, and reports whether
+// it exhausted the budget. That is reported once; files recorded later
+// return false.
+func (c *FailureCounter) Record(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files++
+	if err != nil {
+		c.failures++
+	}
+	if c.exceeded || !c.Budget.Exceeded(c.failures, c.files) {
+		return false
+	}
+	c.exceeded = true
+	return true
+}
+
+// Exceeded reports whether the budget has been exhausted.
+func (c *FailureCounter) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exceeded
+}
+
+// Counts returns the failed and finished files recorded so far.
+func (c *FailureCounter) Counts() (failures, files int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures, c.files
+}
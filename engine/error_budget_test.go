@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseErrorBudget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ErrorBudget
+		wantErr bool
+	}{
+		{"", ErrorBudget{}, false},
+		{"continue", ErrorBudget{}, false},
+		{"fail-fast", ErrorBudget{Failures: 1}, false},
+		{"25", ErrorBudget{Failures: 25}, false},
+		{"2%", ErrorBudget{Rate: 0.02}, false},
+		{"0", ErrorBudget{}, true},
+		{"-3", ErrorBudget{}, true},
+		{"100%", ErrorBudget{}, true},
+		{"often", ErrorBudget{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseErrorBudget(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseErrorBudget(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseErrorBudget(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if err == nil && tt.in != "" {
+			if s := got.String(); s != tt.in {
+				t.Errorf("ParseErrorBudget(%q).String() = %q", tt.in, s)
+			}
+		}
+	}
+}
+
+func TestFailureCounter(t *testing.T) {
+	failure := errors.New("boom")
+
+	t.Run("continue", func(t *testing.T) {
+		c := &FailureCounter{}
+		for range 1000 {
+			if c.Record(failure) {
+				t.Fatal("Expected an unlimited budget never to be exceeded")
+			}
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		c := &FailureCounter{Budget: ErrorBudget{Failures: 2}}
+		if c.Record(failure) || c.Record(nil) {
+			t.Fatal("Expected one failure to stay within a budget of 2")
+		}
+		if !c.Record(failure) {
+			t.Fatal("Expected the second failure to exhaust the budget")
+		}
+		if c.Record(failure) {
+			t.Error("Expected exhaustion to be reported once")
+		}
+		if !c.Exceeded() {
+			t.Error("Expected Exceeded to stay true")
+		}
+		if failures, files := c.Counts(); failures != 3 || files != 4 {
+			t.Errorf("Counts() = %d, %d, want 3, 4", failures, files)
+		}
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		c := &FailureCounter{Budget: ErrorBudget{Rate: 0.1}}
+		if c.Record(failure) {
+			t.Fatal("Expected the rate not to apply to the first files")
+		}
+		for range ErrorRateMinFiles - 2 {
+			if c.Record(nil) {
+				t.Fatal("Expected successes not to exhaust the budget")
+			}
+		}
+		exceeded := false
+		for range 20 {
+			exceeded = exceeded || c.Record(failure)
+		}
+		if !exceeded {
+			t.Fatal("Expected failures above 10% to exhaust the budget")
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package engine
+
+import "sync"
+
+// Event is something that happened during a run, published on an
+// EventBus: one of JobQueued, JobStarted, Progress, JobCompleted,
+// JobFailed, ScanFinished, *WalkError or RunFinished.
+type Event interface {
+	isEvent()
+}
+
+// JobQueued is published once the walk has queued Job.
+type JobQueued struct {
+	Job TransferJob
+}
+
+// JobStarted is published when a worker takes up Job, including each retry.
+type JobStarted struct {
+	Job TransferJob
+}
+
+// Progress is published at intervals with the rates a Meter measured.
+type Progress struct {
+	// Rate is the run's throughput in bytes per second.
+	Rate    float64
+	Streams []StreamRate
+}
+
+// JobCompleted is published once Job is done with.
+type JobCompleted struct {
+	Job TransferJob
+	// Bytes counts the file's bytes, whether transferred or not; Sparse
+	// counts the holes among them that were never read.
+	Bytes  int64
+	Sparse int64
+	// Skipped means nothing was written: the destination was already
+	// current, or the file was gone from the source.
+	Skipped bool
+}
+
+// JobFailed is published when Job fails, before any retry.
+type JobFailed struct {
+	Job TransferJob
+	Err error
+}
+
+// ScanFinished is published once the source has been counted, by a walk
+// that ended or a pre-scan, with the files and bytes it found.
+type ScanFinished struct {
+	Files int64
+	Bytes int64
+}
+
+// RunFinished is published once the run's transfers are over.
+type RunFinished struct {
+	// Interrupted means the run was cancelled before it got through its
+	// files.
+	Interrupted bool
+}
+
+func (JobQueued) isEvent()    {}
+func (JobStarted) isEvent()   {}
+func (Progress) isEvent()     {}
+func (JobCompleted) isEvent() {}
+func (JobFailed) isEvent()    {}
+func (ScanFinished) isEvent() {}
+func (*WalkError) isEvent()   {}
+func (RunFinished) isEvent()  {}
+
+// EventBus delivers the events of a run to its subscribers, such as the
+// TUI, progress logs or library code following the run. The zero value is
+// ready to use, and a nil bus drops what is published. It is safe for
+// concurrent use.
+type EventBus struct {
+	mu   sync.RWMutex
+	next int
+	subs []subscriber
+}
+
+type subscriber struct {
+	id int
+	fn func(Event)
+}
+
+// Subscribe calls fn with every event published from now on, until the
+// returned function is called. fn runs on the goroutine publishing, which
+// may be any worker's, so it must be safe for concurrent use and quick:
+// hand slow work off, e.g. to a channel.
+func (b *EventBus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subs = append(b.subs, subscriber{id: id, fn: fn})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s.id == id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers e to every subscriber, in the order they subscribed.
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	// Subscribers may unsubscribe while called; the slice is never
+	// modified in place
+	for _, s := range subs {
+		s.fn(e)
+	}
+}
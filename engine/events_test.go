@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestEventBus_Subscribe(t *testing.T) {
+	var bus EventBus
+	var (
+		mu     sync.Mutex
+		first  []Event
+		second []Event
+	)
+	bus.Subscribe(func(e Event) {
+		mu.Lock()
+		first = append(first, e)
+		mu.Unlock()
+	})
+	unsubscribe := bus.Subscribe(func(e Event) {
+		mu.Lock()
+		second = append(second, e)
+		mu.Unlock()
+	})
+
+	job := TransferJob{ID: "a", SourcePath: "a", DestinationPath: "b", FileInfo: mockFileInfo{name: "a", size: 10}}
+	bus.Publish(JobQueued{Job: job})
+	bus.Publish(JobCompleted{Job: job, Bytes: 10})
+	unsubscribe()
+	bus.Publish(&WalkError{Path: "locked", Err: errors.New("permission denied")})
+
+	if len(first) != 3 || len(second) != 2 {
+		t.Fatalf("Expected 3 and 2 events, got %d and %d", len(first), len(second))
+	}
+	if e, ok := first[1].(JobCompleted); !ok || e.Job.ID != "a" || e.Bytes != 10 {
+		t.Errorf("Expected the completion of a, got %#v", first[1])
+	}
+	if _, ok := first[2].(*WalkError); !ok {
+		t.Errorf("Expected the walk error, got %#v", first[2])
+	}
+
+	// Publishing on no bus does nothing
+	var none *EventBus
+	none.Publish(RunFinished{})
+}
+
+func TestEventBus_Concurrent(t *testing.T) {
+	var bus EventBus
+	var mu sync.Mutex
+	count := 0
+	bus.Subscribe(func(e Event) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bus.Publish(Progress{})
+			}
+			// Subscribers may come and go while others publish
+			bus.Subscribe(func(Event) {})()
+		}()
+	}
+	wg.Wait()
+	if count != 800 {
+		t.Errorf("Expected 800 events, got %d", count)
+	}
+}
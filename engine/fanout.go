@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// errReplicaInconsistent is recorded on replicas of a file whose source
+// changed while it was copied.
+var errReplicaInconsistent = errors.New("source changed while it was copied")
+
+// Replica is a destination a run writes every file to besides its first.
+type Replica struct {
+	// Name identifies the replica's jobs in the state store. It must be
+	// unique among the replicas of a run and stay the same across its
+	// runs, such as the replica's URL.
+	Name     string
+	Provider provider.Provider
+	// Root is the path files are written below, as the first
+	// destination's root is.
+	Root string
+}
+
+// FanOut writes the files of a run to replicas as well as to its first
+// destination, such as a disaster recovery bucket and a local cache at
+// once. Each replica has a job of its own for every file, recorded in the
+// state store under the replica's name, so that one that fails is retried
+// without writing the others again.
+type FanOut struct {
+	// Root is the root of the first destination, below which the
+	// destination paths of jobs lie.
+	Root     string
+	Replicas []Replica
+	Tracker  *JobTracker
+}
+
+// ReplicaPath returns the path in r of path, a path below the first
+// destination's root.
+func (f *FanOut) ReplicaPath(r Replica, path string) (string, error) {
+	rel, err := filepath.Rel(f.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("destination %s is outside %s", path, f.Root)
+	}
+	return filepath.Join(r.Root, rel), nil
+}
+
+// replicaJob returns the job writing job's file to r.
+func (f *FanOut) replicaJob(job TransferJob, r Replica) (TransferJob, error) {
+	dest, err := f.ReplicaPath(r, job.DestinationPath)
+	if err != nil {
+		return job, err
+	}
+	rjob := job
+	rjob.ID = NewJobID()
+	rjob.Target = r.Name
+	rjob.DestinationPath = dest
+	return f.Tracker.Identify(rjob)
+}
+
+func (f *FanOut) replica(name string) (Replica, bool) {
+	for _, r := range f.Replicas {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Replica{}, false
+}
+
+// Pending returns the jobs writing job's file to the replicas, leaving out,
+// if skipCompleted is set, those an earlier run completed while the source
+// is unchanged.
+func (f *FanOut) Pending(job TransferJob, skipCompleted bool) ([]TransferJob, error) {
+	var jobs []TransferJob
+	for _, r := range f.Replicas {
+		rjob, err := f.replicaJob(job, r)
+		if err != nil {
+			return nil, err
+		}
+		if skipCompleted {
+			done, err := f.Tracker.CompletedUnchanged(rjob)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check job state: %w", err)
+			}
+			if done {
+				continue
+			}
+		}
+		jobs = append(jobs, rjob)
+	}
+	return jobs, nil
+}
+
+// Primary returns the job writing to the first destination the file a
+// replica's job, as Failed returns it, writes. It returns false for jobs of
+// replicas the run no longer has.
+func (f *FanOut) Primary(job TransferJob) (TransferJob, bool) {
+	r, ok := f.replica(job.Target)
+	if !ok {
+		return job, false
+	}
+	rel, err := filepath.Rel(r.Root, job.DestinationPath)
+	if err != nil {
+		return job, false
+	}
+	return TransferJob{
+		ID:              NewJobID(),
+		SourcePath:      job.SourcePath,
+		DestinationPath: filepath.Join(f.Root, rel),
+		Ctx:             job.Ctx,
+	}, true
+}
+
+// Open starts writing job's content to the replicas of jobs, as Pending
+// returns them, and returns the writer to pass the content to. Replicas
+// that cannot be opened are recorded as failed and left out.
+func (f *FanOut) Open(ctx context.Context, job TransferJob, jobs []TransferJob) *FanOutWriter {
+	w := &FanOutWriter{fan: f}
+	for _, rjob := range jobs {
+		rjob.FileInfo = job.FileInfo
+		s := &replicaStream{job: rjob}
+		w.streams = append(w.streams, s)
+		r, _ := f.replica(rjob.Target)
+		if r.Provider == nil {
+			s.fail(f.Tracker, fmt.Errorf("unknown replica %q", rjob.Target))
+			continue
+		}
+		if err := f.Tracker.InitJob(rjob); err != nil {
+			s.fail(f.Tracker, err)
+			continue
+		}
+		f.Tracker.MarkInProgress(rjob.ID)
+		dst, err := r.Provider.OpenWrite(ctx, rjob.DestinationPath, rjob.FileInfo)
+		if err != nil {
+			s.fail(f.Tracker, fmt.Errorf("failed to open destination: %w", err))
+			continue
+		}
+		s.dst = dst
+		s.w = f.Tracker.NewTrackedWriter(dst, rjob.ID, 0)
+	}
+	return w
+}
+
+// Copy writes job's file to the replicas of jobs alone, reading the source
+// again, for files whose first destination was written without streaming
+// them, such as by a server-side copy, or was not written at all. Symbolic
+// links are recreated as links where the replica can store them.
+func (f *FanOut) Copy(ctx context.Context, job TransferJob, jobs []TransferJob, src provider.Provider, buf []byte) error {
+	var errs []error
+	var streamed []TransferJob
+	for _, rjob := range jobs {
+		r, _ := f.replica(rjob.Target)
+		if r.Provider == nil {
+			streamed = append(streamed, rjob)
+			continue
+		}
+		rjob.FileInfo = job.FileInfo
+		handled, err := TransferSymlink(ctx, rjob, r.Provider)
+		if !handled {
+			streamed = append(streamed, rjob)
+			continue
+		}
+		if initErr := f.Tracker.InitJob(rjob); err == nil {
+			err = initErr
+		}
+		if err == nil {
+			err = f.Tracker.MarkCompleted(rjob.ID)
+		} else {
+			f.Tracker.MarkFailed(rjob.ID, err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("replica %s: %w", rjob.Target, err))
+		}
+	}
+	if len(streamed) == 0 {
+		return errors.Join(errs...)
+	}
+
+	// Listings from object stores lack the metadata to preserve
+	if r, ok := f.replica(streamed[0].Target); ok {
+		var err error
+		if job, err = SourceMetadata(ctx, job, src, r.Provider); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+	w := f.Open(ctx, job, streamed)
+	rc, err := src.OpenRead(ctx, job.SourcePath)
+	if err == nil {
+		_, err = io.CopyBuffer(w, rc, buf)
+		rc.Close()
+	}
+	if err != nil {
+		w.Abort(err)
+		return errors.Join(append(errs, err)...)
+	}
+	return errors.Join(append(errs, w.Commit("", false))...)
+}
+
+// FanOutWriter writes one file's content to its replicas. A replica that
+// fails is recorded as failed and dropped without failing the writes, so
+// the others and the first destination carry on.
+type FanOutWriter struct {
+	fan     *FanOut
+	streams []*replicaStream
+}
+
+// replicaStream is the content of a file being written to one replica.
+type replicaStream struct {
+	job  TransferJob
+	dst  io.WriteCloser
+	w    *TrackedWriter
+	err  error
+	done bool
+}
+
+// fail records the stream as failed with err, closing it if it is open.
+func (s *replicaStream) fail(tracker *JobTracker, err error) {
+	if s.dst != nil {
+		s.dst.Close()
+	}
+	s.err, s.done = err, true
+	tracker.MarkFailed(s.job.ID, err)
+}
+
+func (w *FanOutWriter) Write(p []byte) (int, error) {
+	for _, s := range w.streams {
+		if s.done {
+			continue
+		}
+		n, err := s.w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			s.fail(w.fan.Tracker, err)
+			log.Printf("Replica %s of %s failed: %v", s.job.Target, s.job.SourcePath, err)
+		}
+	}
+	return len(p), nil
+}
+
+// Commit closes the replicas once the whole file was written, and records
+// them as completed with checksum, if set, or, if the source changed while
+// it was read, as inconsistent. It returns the errors of the replicas that
+// failed, including earlier in the copy, and has no effect once called.
+func (w *FanOutWriter) Commit(checksum string, inconsistent bool) error {
+	tracker := w.fan.Tracker
+	var errs []error
+	for _, s := range w.streams {
+		if !s.done {
+			err := s.dst.Close()
+			var metaErr *provider.MetadataError
+			if errors.As(err, &metaErr) && !metaErr.Fatal() {
+				log.Printf("Warning: replica %s: %v", s.job.Target, err)
+				tracker.MarkMetadataError(s.job.ID, err)
+				err = nil
+			}
+			s.done = true
+			switch {
+			case err != nil:
+				s.err = err
+				tracker.MarkFailed(s.job.ID, err)
+			case inconsistent:
+				tracker.MarkInconsistent(s.job.ID, errReplicaInconsistent)
+			default:
+				if checksum != "" {
+					tracker.RecordChecksum(s.job.ID, checksum)
+				}
+				s.err = tracker.MarkCompleted(s.job.ID)
+			}
+		}
+		if s.err != nil {
+			errs = append(errs, fmt.Errorf("replica %s: %w", s.job.Target, s.err))
+		}
+	}
+	w.streams = nil
+	return errors.Join(errs...)
+}
+
+// Abort closes the replicas still being written and records them as
+// failed with cause, as the copy did not complete. It has no effect after
+// Commit.
+func (w *FanOutWriter) Abort(cause error) {
+	for _, s := range w.streams {
+		if !s.done {
+			s.fail(w.fan.Tracker, cause)
+		}
+	}
+	w.streams = nil
+}
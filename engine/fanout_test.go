@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+// failingWriteProvider fails every write to the files it opens.
+type failingWriteProvider struct {
+	provider.Provider
+}
+
+func (p failingWriteProvider) OpenWrite(ctx context.Context, path string, metadata provider.FileInfo) (io.WriteCloser, error) {
+	return failingWriter{}, nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+func (failingWriter) Close() error                { return nil }
+
+func newFanOutTest(t *testing.T, replicas ...Replica) (*FanOut, *MockStore, string) {
+	t.Helper()
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	root := t.TempDir()
+	return &FanOut{Root: root, Replicas: replicas, Tracker: NewJobTracker(mockStore, DefaultCheckpointConfig)}, mockStore, root
+}
+
+func TestFanOut_Stream(t *testing.T) {
+	cacheDir, drDir := t.TempDir(), t.TempDir()
+	fan, mockStore, root := newFanOutTest(t,
+		Replica{Name: "cache", Provider: provider.NewLocalProvider("").WithRoot(cacheDir), Root: cacheDir},
+		Replica{Name: "broken", Provider: failingWriteProvider{provider.NewLocalProvider("")}, Root: "/nowhere"},
+		Replica{Name: "dr", Provider: provider.NewLocalProvider("").WithRoot(drDir), Root: drDir},
+	)
+	data := []byte("replicated content")
+	job := TransferJob{ID: "primary", SourcePath: "/src/a/b.txt", DestinationPath: filepath.Join(root, "a/b.txt"), FileInfo: mockFileInfo{name: "b.txt", size: int64(len(data))}}
+
+	jobs, err := fan.Pending(job, true)
+	if err != nil || len(jobs) != 3 {
+		t.Fatalf("Expected 3 pending replicas, got %d (%v)", len(jobs), err)
+	}
+	w := fan.Open(context.Background(), job, jobs)
+	if n, err := w.Write(data); n != len(data) || err != nil {
+		t.Fatalf("Expected a failing replica not to fail the write, got %d, %v", n, err)
+	}
+	if err := w.Commit("xxh3:1234", false); err == nil {
+		t.Error("Expected Commit to report the failed replica")
+	}
+
+	for _, dir := range []string{cacheDir, drDir} {
+		if got, err := os.ReadFile(filepath.Join(dir, "a/b.txt")); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Replica in %s holds %q (%v)", dir, got, err)
+		}
+	}
+	states := make(map[string]store.JobState)
+	for _, record := range mockStore.Jobs {
+		states[record.Target] = record.State
+		if record.Target == "cache" && record.Checksum != "xxh3:1234" {
+			t.Errorf("Expected the checksum to be recorded on replicas, got %q", record.Checksum)
+		}
+	}
+	if states["cache"] != store.StateCompleted || states["dr"] != store.StateCompleted || states["broken"] != store.StateFailed {
+		t.Errorf("Expected independent replica states, got %v", states)
+	}
+
+	// Completed replicas are not written again while the source is
+	// unchanged, and the failed one maps back to the file's own job
+	jobs, err = fan.Pending(job, true)
+	if err != nil || len(jobs) != 1 || jobs[0].Target != "broken" {
+		t.Fatalf("Expected only the failed replica to be pending, got %+v (%v)", jobs, err)
+	}
+	primary, ok := fan.Primary(TransferJob{SourcePath: jobs[0].SourcePath, DestinationPath: jobs[0].DestinationPath, Target: jobs[0].Target})
+	if !ok || primary.DestinationPath != job.DestinationPath || primary.SourcePath != job.SourcePath || primary.Target != "" {
+		t.Errorf("Expected the replica's job to map to the first destination, got %+v", primary)
+	}
+}
+
+func TestFanOut_Copy(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "f.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("f.txt", filepath.Join(srcDir, "l")); err != nil {
+		t.Fatal(err)
+	}
+	src := provider.NewLocalProvider(srcDir)
+	fan, _, root := newFanOutTest(t, Replica{Name: "dr", Provider: provider.NewLocalProvider(dstDir), Root: ""})
+	ctx := context.Background()
+
+	entries, err := src.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		job := TransferJob{SourcePath: entry.Name(), DestinationPath: filepath.Join(root, entry.Name()), FileInfo: entry}
+		jobs, err := fan.Pending(job, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fan.Copy(ctx, job, jobs, src, make([]byte, 1024)); err != nil {
+			t.Fatalf("Copy of %s failed: %v", entry.Name(), err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dstDir, "f.txt")); err != nil || string(got) != "hello" {
+		t.Errorf("Replica holds %q (%v)", got, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dstDir, "l")); err != nil || target != "f.txt" {
+		t.Errorf("Expected the link to be recreated, got %q (%v)", target, err)
+	}
+}
+
+func TestFanOut_Abort(t *testing.T) {
+	dir := t.TempDir()
+	fan, mockStore, root := newFanOutTest(t, Replica{Name: "dr", Provider: provider.NewLocalProvider("").WithRoot(dir), Root: dir})
+	job := TransferJob{SourcePath: "s", DestinationPath: filepath.Join(root, "s"), FileInfo: mockFileInfo{name: "s", size: 10}}
+	jobs, _ := fan.Pending(job, true)
+	w := fan.Open(context.Background(), job, jobs)
+	w.Write([]byte("part"))
+	w.Abort(errors.New("source read failed"))
+	if err := w.Commit("", false); err != nil {
+		t.Errorf("Expected Commit after Abort to do nothing, got %v", err)
+	}
+	for _, record := range mockStore.Jobs {
+		if record.State != store.StateFailed || record.Error != "source read failed" {
+			t.Errorf("Expected the replica to fail with the cause, got %s %q", record.State, record.Error)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// FileFilter selects files by size and age. Zero fields do not filter.
+// Directories are never filtered out, so the walk still descends into
+// them.
+type FileFilter struct {
+	// MinSize and MaxSize bound the file size in bytes, inclusively.
+	MinSize int64
+	MaxSize int64
+	// MinAge keeps files last modified at least this long ago; MaxAge
+	// keeps files modified at most this long ago.
+	MinAge time.Duration
+	MaxAge time.Duration
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Active reports whether the filter excludes anything.
+func (f *FileFilter) Active() bool {
+	return f != nil && (f.MinSize > 0 || f.MaxSize > 0 || f.MinAge > 0 || f.MaxAge > 0)
+}
+
+// Match reports whether info passes the filter. A nil filter matches
+// everything.
+func (f *FileFilter) Match(info provider.FileInfo) bool {
+	if !f.Active() || info == nil || info.IsDir() {
+		return true
+	}
+	size := info.Size()
+	if f.MinSize > 0 && size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	if f.MinAge > 0 || f.MaxAge > 0 {
+		now := time.Now()
+		if f.Now != nil {
+			now = f.Now()
+		}
+		age := now.Sub(info.ModTime())
+		if f.MinAge > 0 && age < f.MinAge {
+			return false
+		}
+		if f.MaxAge > 0 && age > f.MaxAge {
+			return false
+		}
+	}
+	return true
+}
+
+// sizeUnits are the suffixes ParseSize accepts, following rsync: K, M, G
+// and T and their iB forms are powers of 1024, the B forms powers of 1000.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KIB": 1 << 10,
+	"KB":  1e3,
+	"M":   1 << 20,
+	"MIB": 1 << 20,
+	"MB":  1e6,
+	"G":   1 << 30,
+	"GIB": 1 << 30,
+	"GB":  1e9,
+	"T":   1 << 40,
+	"TIB": 1 << 40,
+	"TB":  1e12,
+}
+
+// ParseSize parses a byte count such as "512", "1M", "1.5GiB" or "10MB".
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, s[i:])
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// ParseRate parses a transfer rate in bytes per second, written as a size
+// for ParseSize with an optional "/s", such as "200MB/s" or "50M".
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	trimmed, _ := strings.CutSuffix(s, "/s")
+	n, err := ParseSize(trimmed)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
+
+// ParseAge parses a duration as time.ParseDuration does, also accepting
+// whole days such as "90d".
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileFilter_Match(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	day := 24 * time.Hour
+	filter := &FileFilter{MinSize: 10, MaxSize: 100, MinAge: 90 * day, MaxAge: 365 * day, Now: func() time.Time { return now }}
+	file := func(size int64, age time.Duration) mockFileInfo {
+		return mockFileInfo{name: "f", size: size, modTime: now.Add(-age)}
+	}
+
+	tests := []struct {
+		name string
+		info mockFileInfo
+		want bool
+	}{
+		{"in range", file(50, 100*day), true},
+		{"bounds inclusive", file(10, 90*day), true},
+		{"too small", file(9, 100*day), false},
+		{"too large", file(101, 100*day), false},
+		{"too new", file(50, day), false},
+		{"too old", file(50, 400*day), false},
+		{"directory", mockFileInfo{name: "d", isDir: true, modTime: now}, true},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.info); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	var none *FileFilter
+	if !none.Match(file(0, 0)) || (&FileFilter{}).Active() {
+		t.Error("Expected nil and zero filters to match everything")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"512":   512,
+		"1K":    1024,
+		"1KiB":  1024,
+		"1kb":   1000,
+		"1.5M":  3 << 19,
+		"10MB":  10e6,
+		"2G":    2 << 30,
+		"1TB":   1e12,
+		" 64b ": 64,
+	}
+	for s, want := range tests {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "M", "1X", "-1", "1.2.3K"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q): expected error", s)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]int64{
+		"0":        0,
+		"1048576":  1 << 20,
+		"200MB/s":  200e6,
+		"50M":      50 << 20,
+		"1.5GiB/s": 3 << 29,
+	}
+	for s, want := range tests {
+		if got, err := ParseRate(s); err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "/s", "fast", "10MB/h"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q): expected error", s)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"90d": 90 * 24 * time.Hour,
+		"36h": 36 * time.Hour,
+		"15m": 15 * time.Minute,
+	}
+	for s, want := range tests {
+		if got, err := ParseAge(s); err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "d", "1.5d", "-1h", "soon"} {
+		if _, err := ParseAge(s); err == nil {
+			t.Errorf("ParseAge(%q): expected error", s)
+		}
+	}
+}
+
+func TestWalker_Walk_Filter(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{
+		{name: "big.bin", size: 2000},
+		{name: "small.txt", size: 10},
+		{name: "dir", isDir: true},
+	}
+	mp.dirs["/root/dir"] = []mockFileInfo{{name: "big2.bin", size: 5000}}
+
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(mp, jobChan)
+	walker.Sorted = true
+	walker.Filter = &FileFilter{MinSize: 1000}
+	walker.Seen = NewPathSet()
+	if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	close(jobChan)
+
+	var got []string
+	for job := range jobChan {
+		got = append(got, job.SourcePath)
+	}
+	if want := []string{"/root/big.bin", "/root/dir/big2.bin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if walker.Filtered != 1 {
+		t.Errorf("Expected 1 filtered file, got %d", walker.Filtered)
+	}
+	// Filtered files still exist at the source and must survive mirroring
+	if !walker.Seen.Has("/dest/small.txt") {
+		t.Error("Expected filtered file recorded as seen")
+	}
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/franksops/gofast/store"
+)
+
+// HookContext is the job data exposed to external hook commands, both as
+// GOFAST_* environment variables and as fields for argument templates, e.g.
+// `update-cmdb --path {{.Dst}} --bytes {{.Size}}`.
+type HookContext struct {
+	JobID    string
+	Src      string
+	Dst      string
+	Size     int64
+	Checksum string
+	State    store.JobState
+	Error    string
+}
+
+// NewHookContext builds the hook context for a finished job. A nil err
+// means the job completed.
+func NewHookContext(job TransferJob, checksum string, err error) HookContext {
+	hc := HookContext{
+		JobID:    job.ID,
+		Src:      job.SourcePath,
+		Dst:      job.DestinationPath,
+		Checksum: checksum,
+		State:    store.StateCompleted,
+	}
+	if job.FileInfo != nil {
+		hc.Size = job.FileInfo.Size()
+	}
+	if err != nil {
+		hc.State = store.StateFailed
+		hc.Error = err.Error()
+	}
+	return hc
+}
+
+// Env returns the context as environment variable assignments.
+func (hc HookContext) Env() []string {
+	return []string{
+		"GOFAST_JOB_ID=" + hc.JobID,
+		"GOFAST_SRC=" + hc.Src,
+		"GOFAST_DST=" + hc.Dst,
+		"GOFAST_SIZE=" + strconv.FormatInt(hc.Size, 10),
+		"GOFAST_CHECKSUM=" + hc.Checksum,
+		"GOFAST_STATE=" + string(hc.State),
+		"GOFAST_ERROR=" + hc.Error,
+	}
+}
+
+// Hook is an external command run for a job. The command line is split into
+// arguments once, and each argument is then expanded as a Go template, so
+// paths containing spaces or shell metacharacters are passed through safely.
+type Hook struct {
+	args []*template.Template
+}
+
+// ParseHook parses a hook command line. Arguments are separated by
+// whitespace; single or double quotes group an argument.
+func ParseHook(command string) (*Hook, error) {
+	words, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty hook command")
+	}
+
+	h := &Hook{}
+	for i, word := range words {
+		tmpl, err := template.New(strconv.Itoa(i)).Option("missingkey=error").Parse(word)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template in hook argument %q: %w", word, err)
+		}
+		h.args = append(h.args, tmpl)
+	}
+	return h, nil
+}
+
+// Args expands the argument templates for hc.
+func (h *Hook) Args(hc HookContext) ([]string, error) {
+	args := make([]string, len(h.args))
+	for i, tmpl := range h.args {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, hc); err != nil {
+			return nil, fmt.Errorf("failed to expand hook argument: %w", err)
+		}
+		args[i] = buf.String()
+	}
+	return args, nil
+}
+
+// Run executes the hook for hc and waits for it to exit. The command inherits
+// the process environment plus the GOFAST_* variables of hc.
+func (h *Hook) Run(ctx context.Context, hc HookContext) error {
+	args, err := h.Args(hc)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), hc.Env()...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// JobHooks holds the hooks fired when jobs finish.
+type JobHooks struct {
+	OnComplete *Hook
+	OnFailure  *Hook
+}
+
+// Fire runs the hook matching the job outcome, if configured. Hook errors
+// are returned for logging but never change the job's own result.
+func (jh JobHooks) Fire(ctx context.Context, job TransferJob, checksum string, jobErr error) error {
+	hook := jh.OnComplete
+	if jobErr != nil {
+		hook = jh.OnFailure
+	}
+	if hook == nil {
+		return nil
+	}
+	return hook.Run(ctx, NewHookContext(job, checksum, jobErr))
+}
+
+// splitCommand splits a command line on whitespace, honouring single and
+// double quotes.
+func splitCommand(command string) ([]string, error) {
+	var (
+		words   []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+	)
+	for _, r := range command {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", command)
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/franksops/gofast/store"
+)
+
+func TestParseHook_Args(t *testing.T) {
+	h, err := ParseHook(`notify --path "{{.Dst}}" --state={{.State}} '{{.Size}} bytes'`)
+	if err != nil {
+		t.Fatalf("ParseHook failed: %v", err)
+	}
+
+	job := TransferJob{ID: "1", SourcePath: "/src/my file", DestinationPath: "/dst/my file", FileInfo: mockFileInfo{size: 10}}
+	args, err := h.Args(NewHookContext(job, "", nil))
+	if err != nil {
+		t.Fatalf("Args failed: %v", err)
+	}
+
+	expected := []string{"notify", "--path", "/dst/my file", "--state=Completed", "10 bytes"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	if _, err := ParseHook(`echo "unterminated`); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+	if _, err := ParseHook("   "); err == nil {
+		t.Error("expected error for empty command")
+	}
+}
+
+func TestJobHooks_Fire(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.out")
+	onFailure, err := ParseHook(`sh -c 'echo "$GOFAST_STATE $GOFAST_SRC $GOFAST_ERROR" > "$0"' ` + out)
+	if err != nil {
+		t.Fatalf("ParseHook failed: %v", err)
+	}
+
+	hooks := JobHooks{OnFailure: onFailure}
+	job := TransferJob{ID: "1", SourcePath: "/src/a.txt", DestinationPath: "/dst/a.txt"}
+
+	// Completed jobs do not trigger the failure hook.
+	if err := hooks.Fire(context.Background(), job, "", nil); err != nil {
+		t.Fatalf("Fire failed: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("failure hook ran for a completed job")
+	}
+
+	if err := hooks.Fire(context.Background(), job, "", errors.New("disk full")); err != nil {
+		t.Fatalf("Fire failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook output missing: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != string(store.StateFailed)+" /src/a.txt disk full" {
+		t.Errorf("unexpected hook output %q", got)
+	}
+}
@@ -2,6 +2,8 @@ package engine
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 
 	"github.com/franksops/gofast/provider"
 )
@@ -9,6 +11,9 @@ import (
 // TransferJob represents a single file transfer operation from a source
 // provider to a destination provider.
 type TransferJob struct {
+	// ID identifies the job in the state store: a UUID from NewJobID, or
+	// the ID an earlier run recorded for the same source and destination
+	// (see JobTracker.Identify).
 	ID string
 	// SourcePath is the file path to read from the source provider.
 	SourcePath string
@@ -20,6 +25,18 @@ type TransferJob struct {
 	// checked at the destination.
 	FileInfo provider.FileInfo
 
+	// Versions, when set, is the history of the source to replay in
+	// order instead of copying its current content; see ReplayVersions.
+	Versions []provider.ObjectVersion
+
+	// VersionID is set on the jobs ReplayVersions tracks for each version
+	// it writes.
+	VersionID string
+
+	// Target is set on the jobs a FanOut tracks for each replica it
+	// writes, to the replica's name.
+	Target string
+
 	// Ctx allows cancellation or timeout settings for this specific job.
 	Ctx context.Context
 }
@@ -27,3 +44,12 @@ type TransferJob struct {
 // JobChannel is a channel used to queue and dispatch TransferJobs to workers
 // in the worker pool.
 type JobChannel chan TransferJob
+
+// NewJobID returns a random (version 4) UUID for a new job.
+func NewJobID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -2,6 +2,7 @@ package engine_test
 
 import (
 	"context"
+	"regexp"
 	"testing"
 
 	"github.com/franksops/gofast/engine"
@@ -38,3 +39,18 @@ func TestJobChannel(t *testing.T) {
 		t.Errorf("Expected /tmp/foo.txt, got %s", received.SourcePath)
 	}
 }
+
+func TestNewJobID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for range 100 {
+		id := engine.NewJobID()
+		if !uuid.MatchString(id) {
+			t.Fatalf("NewJobID() = %q, want a version 4 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("NewJobID() returned %q twice", id)
+		}
+		seen[id] = true
+	}
+}
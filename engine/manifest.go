@@ -0,0 +1,283 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestFormat is the encoding of a manifest file.
+type ManifestFormat string
+
+const (
+	// ManifestJSONL writes one JSON object per line, the format
+	// ReadManifest reads.
+	ManifestJSONL ManifestFormat = "jsonl"
+	// ManifestCSV writes a header row followed by one row per entry.
+	ManifestCSV ManifestFormat = "csv"
+)
+
+// ParseManifestFormat validates a manifest format as given on the command
+// line.
+func ParseManifestFormat(name string) (ManifestFormat, error) {
+	switch format := ManifestFormat(name); format {
+	case ManifestJSONL, ManifestCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported manifest format %q (want jsonl or csv)", name)
+}
+
+// manifestCSVHeader names the columns of a CSV manifest.
+var manifestCSVHeader = []string{"path", "size", "mod_time", "algorithm", "checksum", "source", "destination"}
+
+// ManifestEntry is one line of a checksum manifest. Paths are relative to the
+// root that was hashed or transferred so that manifests taken on the source
+// and on the destination can be compared line by line.
+type ManifestEntry struct {
+	Path      string            `json:"path"`
+	Size      int64             `json:"size"`
+	ModTime   time.Time         `json:"mod_time"`
+	Algorithm ChecksumAlgorithm `json:"algorithm,omitempty"`
+	Checksum  string            `json:"checksum,omitempty"`
+	// Source and Destination are the full paths of a transferred file on
+	// its providers; manifests of a single tree leave them empty.
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// TransferManifestEntry describes a transferred job for a manifest, with
+// its path relative to the destination root dstRoot. checksum is the
+// "algorithm:value" checksum of the file, if known.
+func TransferManifestEntry(job TransferJob, dstRoot, checksum string) ManifestEntry {
+	rel, err := filepath.Rel(dstRoot, job.DestinationPath)
+	if err != nil {
+		rel = job.DestinationPath
+	}
+	entry := ManifestEntry{
+		Path:        filepath.ToSlash(rel),
+		Source:      job.SourcePath,
+		Destination: job.DestinationPath,
+	}
+	if job.FileInfo != nil {
+		entry.Size = job.FileInfo.Size()
+		entry.ModTime = job.FileInfo.ModTime()
+	}
+	if algo, sum, ok := strings.Cut(checksum, ":"); ok {
+		entry.Algorithm, entry.Checksum = ChecksumAlgorithm(algo), sum
+	}
+	return entry
+}
+
+// ManifestWriter writes ManifestEntries as JSON lines. It is safe for
+// concurrent use by multiple workers.
+type ManifestWriter struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	n   int64
+
+	// csv writers write rows instead of JSON, after a header.
+	csv *csv.Writer
+
+	// sorted writers hold entries until Flush so they can be ordered by path.
+	sorted   bool
+	buffered []ManifestEntry
+}
+
+// NewManifestWriter creates a ManifestWriter that writes to w. Flush must be
+// called once all entries have been written.
+func NewManifestWriter(w io.Writer) *ManifestWriter {
+	bw := bufio.NewWriter(w)
+	return &ManifestWriter{
+		w:   bw,
+		enc: json.NewEncoder(bw),
+	}
+}
+
+// NewCSVManifestWriter creates a ManifestWriter that writes CSV rows to w,
+// starting with a header. Flush must be called once all entries have been
+// written.
+func NewCSVManifestWriter(w io.Writer) *ManifestWriter {
+	mw := NewManifestWriter(w)
+	mw.csv = csv.NewWriter(mw.w)
+	mw.csv.Write(manifestCSVHeader)
+	return mw
+}
+
+// NewSortedManifestWriter creates a ManifestWriter that emits entries
+// sorted by path on Flush, so that manifests of the same tree are identical
+// regardless of the order in which workers finished. Entries are held in
+// memory until then.
+func NewSortedManifestWriter(w io.Writer) *ManifestWriter {
+	mw := NewManifestWriter(w)
+	mw.sorted = true
+	return mw
+}
+
+// NewSortedCSVManifestWriter creates a ManifestWriter that writes CSV rows
+// to w sorted by path on Flush, like NewSortedManifestWriter.
+func NewSortedCSVManifestWriter(w io.Writer) *ManifestWriter {
+	mw := NewCSVManifestWriter(w)
+	mw.sorted = true
+	return mw
+}
+
+// Write appends a single entry to the manifest.
+func (mw *ManifestWriter) Write(entry ManifestEntry) error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if mw.sorted {
+		mw.buffered = append(mw.buffered, entry)
+		mw.n++
+		return nil
+	}
+
+	if err := mw.encode(entry); err != nil {
+		return err
+	}
+	mw.n++
+	return nil
+}
+
+// encode writes entry in the writer's format.
+func (mw *ManifestWriter) encode(entry ManifestEntry) error {
+	var err error
+	if mw.csv != nil {
+		err = mw.csv.Write([]string{
+			entry.Path,
+			strconv.FormatInt(entry.Size, 10),
+			entry.ModTime.UTC().Format(time.RFC3339Nano),
+			string(entry.Algorithm),
+			entry.Checksum,
+			entry.Source,
+			entry.Destination,
+		})
+	} else {
+		err = mw.enc.Encode(entry)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest entry: %w", err)
+	}
+	return nil
+}
+
+// Count returns the number of entries written so far.
+func (mw *ManifestWriter) Count() int64 {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	return mw.n
+}
+
+// Flush writes any buffered entries to the underlying writer.
+func (mw *ManifestWriter) Flush() error {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if mw.sorted {
+		// Entries sharing a path, such as the replicas of a file, are
+		// ordered by destination
+		sort.Slice(mw.buffered, func(i, j int) bool {
+			a, b := mw.buffered[i], mw.buffered[j]
+			if a.Path != b.Path {
+				return a.Path < b.Path
+			}
+			return a.Destination < b.Destination
+		})
+		for _, entry := range mw.buffered {
+			if err := mw.encode(entry); err != nil {
+				return err
+			}
+		}
+		mw.buffered = nil
+	}
+	if mw.csv != nil {
+		mw.csv.Flush()
+		if err := mw.csv.Error(); err != nil {
+			return fmt.Errorf("failed to write manifest entry: %w", err)
+		}
+	}
+	return mw.w.Flush()
+}
+
+// ManifestFile is a manifest written to a temporary file and renamed into
+// place by Commit, so readers never see a partial manifest.
+type ManifestFile struct {
+	*ManifestWriter
+	f    *os.File
+	name string
+}
+
+// CreateManifestFile starts a manifest that Commit will store as name. A
+// sorted manifest lists its entries by path, so runs over the same tree
+// write identical manifests, and holds them in memory until Commit. It is
+// safe for concurrent use by multiple workers, like ManifestWriter.
+func CreateManifestFile(name string, format ManifestFormat, sorted bool) (*ManifestFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	// Temporary files are private, but the manifest is for others to read
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	mf := &ManifestFile{f: f, name: name}
+	switch {
+	case format == ManifestCSV && sorted:
+		mf.ManifestWriter = NewSortedCSVManifestWriter(f)
+	case format == ManifestCSV:
+		mf.ManifestWriter = NewCSVManifestWriter(f)
+	case sorted:
+		mf.ManifestWriter = NewSortedManifestWriter(f)
+	default:
+		mf.ManifestWriter = NewManifestWriter(f)
+	}
+	return mf, nil
+}
+
+// Commit flushes the manifest and renames it to its final name.
+func (mf *ManifestFile) Commit() error {
+	err := mf.Flush()
+	if err == nil {
+		err = mf.f.Sync()
+	}
+	if closeErr := mf.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(mf.f.Name(), mf.name)
+	}
+	if err != nil {
+		os.Remove(mf.f.Name())
+		return fmt.Errorf("failed to write manifest %s: %w", mf.name, err)
+	}
+	return nil
+}
+
+// ReadManifest parses a JSON lines manifest produced by ManifestWriter.
+func ReadManifest(r io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	dec := json.NewDecoder(r)
+	for {
+		var entry ManifestEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManifestWriter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewManifestWriter(&buf)
+
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			err := mw.Write(ManifestEntry{
+				Path:      name,
+				Size:      42,
+				ModTime:   modTime,
+				Algorithm: AlgorithmXXH3,
+				Checksum:  FormatChecksum(7),
+			})
+			if err != nil {
+				t.Errorf("Write failed: %v", err)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if mw.Count() != 3 {
+		t.Errorf("expected 3 entries, got %d", mw.Count())
+	}
+
+	entries, err := ReadManifest(&buf)
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Size != 42 || e.Algorithm != AlgorithmXXH3 || e.Checksum != "0000000000000007" {
+			t.Errorf("unexpected entry %+v", e)
+		}
+		if !e.ModTime.Equal(modTime) {
+			t.Errorf("expected mod time %v, got %v", modTime, e.ModTime)
+		}
+	}
+}
+
+func TestReadManifest_Invalid(t *testing.T) {
+	if _, err := ReadManifest(bytes.NewBufferString("{\"path\":\"a\"}\nnot json\n")); err == nil {
+		t.Error("expected error for malformed manifest")
+	}
+}
+
+func TestSortedManifestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewSortedManifestWriter(&buf)
+	for _, name := range []string{"c", "a/b", "b", "a"} {
+		mw.Write(ManifestEntry{Path: name})
+	}
+	if buf.Len() != 0 {
+		t.Error("sorted writer must not emit entries before Flush")
+	}
+	if err := mw.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	entries, err := ReadManifest(&buf)
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	expected := []string{"a", "a/b", "b", "c"}
+	for i, e := range entries {
+		if e.Path != expected[i] {
+			t.Errorf("entry %d = %s; want %s", i, e.Path, expected[i])
+		}
+	}
+}
+
+func TestCSVManifestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewCSVManifestWriter(&buf)
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := mw.Write(ManifestEntry{Path: "a, b.txt", Size: 3, ModTime: modTime, Algorithm: AlgorithmCRC64, Checksum: "00000000000000ff", Destination: "/dst/a, b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "path,size,mod_time,algorithm,checksum,source,destination\n" +
+		"\"a, b.txt\",3,2024-05-01T10:00:00Z,crc64,00000000000000ff,,\"/dst/a, b.txt\"\n"
+	if buf.String() != want {
+		t.Errorf("Unexpected CSV manifest:\n%s", buf.String())
+	}
+}
+
+func TestTransferManifestEntry(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	job := TransferJob{
+		SourcePath:      "/src/dir/a.txt",
+		DestinationPath: filepath.Join("/dst", "dir", "a.txt"),
+		FileInfo:        mockFileInfo{name: "a.txt", size: 5, modTime: modTime},
+	}
+	entry := TransferManifestEntry(job, "/dst", "crc64:00000000000000ff")
+	if entry.Path != "dir/a.txt" || entry.Size != 5 || !entry.ModTime.Equal(modTime) {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if entry.Algorithm != AlgorithmCRC64 || entry.Checksum != "00000000000000ff" {
+		t.Errorf("Expected the checksum to be split, got %q %q", entry.Algorithm, entry.Checksum)
+	}
+	if entry.Source != job.SourcePath || entry.Destination != job.DestinationPath {
+		t.Errorf("Expected the full paths, got %q and %q", entry.Source, entry.Destination)
+	}
+	if entry := TransferManifestEntry(job, "/dst", ""); entry.Algorithm != "" || entry.Checksum != "" {
+		t.Errorf("Expected no checksum, got %+v", entry)
+	}
+}
+
+func TestManifestFile_Commit(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "manifest.jsonl")
+	mf, err := CreateManifestFile(name, ManifestJSONL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mf.Write(ManifestEntry{Path: "a.txt", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("Expected no manifest before Commit, got %v", err)
+	}
+	if err := mf.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := ReadManifest(f)
+	if err != nil || len(entries) != 1 || entries[0].Path != "a.txt" {
+		t.Errorf("Unexpected manifest %+v, %v", entries, err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(filepath.Dir(name), ".*.tmp")); len(left) != 0 {
+		t.Errorf("Expected the temporary file to be renamed, found %v", left)
+	}
+}
+
+func TestManifestFile_Deterministic(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var entries []ManifestEntry
+	for _, path := range []string{"b.txt", "a/c.txt", "a.txt", "d/e/f.txt", "a.txt"} {
+		entries = append(entries, ManifestEntry{
+			Path:        path,
+			Size:        int64(len(path)),
+			ModTime:     modTime,
+			Source:      "/src/" + path,
+			Destination: fmt.Sprintf("/dst%d/%s", len(entries), path),
+		})
+	}
+
+	for _, format := range []ManifestFormat{ManifestJSONL, ManifestCSV} {
+		dir := t.TempDir()
+		// Each run's workers finish the files in another order
+		run := func(name string, seed int64) []byte {
+			mf, err := CreateManifestFile(filepath.Join(dir, name), format, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			order := rand.New(rand.NewSource(seed)).Perm(len(entries))
+			var wg sync.WaitGroup
+			for _, i := range order {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					mf.Write(entries[i])
+				}()
+			}
+			wg.Wait()
+			if err := mf.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		}
+		first, second := run("first", 1), run("second", 2)
+		if !bytes.Equal(first, second) {
+			t.Errorf("Expected identical %s manifests, got:\n%s\nand:\n%s", format, first, second)
+		}
+		if !bytes.HasPrefix(first, []byte(map[ManifestFormat]string{ManifestJSONL: `{"path":"a.txt"`, ManifestCSV: "path,size"}[format])) {
+			t.Errorf("Expected the %s manifest sorted by path, got:\n%s", format, first)
+		}
+	}
+}
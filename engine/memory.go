@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBudget limits the data held in transfer buffers across all workers:
+// the copy buffer of each transfer plus whatever its providers buffer of
+// their own, such as the parts of an S3 multipart upload. Workers acquire a
+// transfer's share before streaming it and release it when done; workers
+// whose share does not fit wait for others to finish. While the budget is
+// nearly used up, the walker waits too, instead of queueing more work.
+type MemoryBudget struct {
+	limit int64
+
+	mu      sync.Mutex
+	used    int64
+	peak    int64
+	waits   int64
+	changed chan struct{}
+}
+
+// memoryHighWater is the share of the limit above which the walker stops
+// queueing jobs.
+const memoryHighWater = 0.9
+
+// NewMemoryBudget creates a budget of limit bytes. A limit of zero or less
+// only measures, without ever blocking.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// Acquire accounts for n bytes of buffers and returns the function that
+// gives them back, which may be called more than once. It blocks while the
+// bytes do not fit, unless nothing else is acquired, so that a transfer
+// larger than the whole budget still runs on its own. It returns early if
+// ctx is cancelled.
+func (b *MemoryBudget) Acquire(ctx context.Context, n int64) (func(), error) {
+	n = max(n, 0)
+	waited := false
+	for {
+		b.mu.Lock()
+		if b.limit <= 0 || b.used+n <= b.limit || b.used == 0 {
+			b.used += n
+			b.peak = max(b.peak, b.used)
+			b.mu.Unlock()
+			var once sync.Once
+			return func() { once.Do(func() { b.release(n) }) }, nil
+		}
+		if !waited {
+			waited = true
+			b.waits++
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return func() {}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (b *MemoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used = max(b.used-n, 0)
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// WaitBelowHighWater blocks while more than nine tenths of the budget is
+// acquired, and returns early if ctx is cancelled. Producers of jobs call it
+// so the queue does not keep growing while workers are starved of memory.
+func (b *MemoryBudget) WaitBelowHighWater(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		if b.limit <= 0 || float64(b.used) <= float64(b.limit)*memoryHighWater {
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Used returns the bytes currently acquired.
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Peak returns the largest number of bytes acquired at once.
+func (b *MemoryBudget) Peak() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
+
+// Waits returns how many Acquire calls had to wait for memory.
+func (b *MemoryBudget) Waits() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waits
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unlimited", func(t *testing.T) {
+		b := NewMemoryBudget(0)
+		for i := 0; i < 10; i++ {
+			if _, err := b.Acquire(ctx, 1<<30); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if b.Used() != 10<<30 || b.Waits() != 0 {
+			t.Errorf("Unexpected accounting: used %d, waits %d", b.Used(), b.Waits())
+		}
+		if err := b.WaitBelowHighWater(ctx); err != nil {
+			t.Errorf("Expected an unlimited budget never to hold back the walker, got %v", err)
+		}
+	})
+
+	t.Run("blocks until released", func(t *testing.T) {
+		b := NewMemoryBudget(100)
+		release, err := b.Acquire(ctx, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		acquired := make(chan struct{})
+		go func() {
+			b.Acquire(ctx, 60)
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("Expected Acquire to block on a full budget")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		release()
+		release() // releasing twice gives the bytes back once
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("Expected release to unblock Acquire")
+		}
+		if b.Used() != 60 || b.Peak() != 60 || b.Waits() != 1 {
+			t.Errorf("Unexpected accounting: used %d, peak %d, waits %d", b.Used(), b.Peak(), b.Waits())
+		}
+	})
+
+	t.Run("oversized runs alone", func(t *testing.T) {
+		b := NewMemoryBudget(100)
+		release, err := b.Acquire(ctx, 1000)
+		if err != nil {
+			t.Fatal("Expected a transfer larger than the budget to run when nothing else does")
+		}
+		release()
+		if b.Used() != 0 {
+			t.Errorf("Expected the budget to be empty again, used %d", b.Used())
+		}
+	})
+
+	t.Run("high water", func(t *testing.T) {
+		b := NewMemoryBudget(100)
+		release, _ := b.Acquire(ctx, 95)
+
+		below := make(chan struct{})
+		go func() {
+			b.WaitBelowHighWater(ctx)
+			close(below)
+		}()
+		select {
+		case <-below:
+			t.Fatal("Expected the walker to wait above the high-water mark")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		release()
+		select {
+		case <-below:
+		case <-time.After(time.Second):
+			t.Fatal("Expected release to let the walker continue")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		b := NewMemoryBudget(1)
+		b.Acquire(ctx, 1)
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := b.Acquire(cctx, 1); err == nil {
+			t.Error("Expected an error from a cancelled Acquire")
+		}
+		if err := b.WaitBelowHighWater(cctx); err == nil {
+			t.Error("Expected an error from a cancelled WaitBelowHighWater")
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// SourceMetadata returns job with its FileInfo re-read from the source when
+// the listing it came from carried no ownership or mode, no tags, or no
+// content type, but the source can report them and the destination keep
+// them, as S3 returns user metadata, tags and Content-Type only for single
+// objects. Jobs the destination will copy
+// server-side are returned unchanged, so the extra request is only made
+// when it pays.
+func SourceMetadata(ctx context.Context, job TransferJob, src, dst provider.Provider) (TransferJob, error) {
+	if job.FileInfo == nil {
+		return job, nil
+	}
+	srcCaps, dstCaps := provider.CapabilitiesOf(src), provider.CapabilitiesOf(dst)
+	_, hasMetadata := job.FileInfo.(provider.UnixFileInfo)
+	_, hasTags := provider.ObjectTags(job.FileInfo)
+	needMetadata := srcCaps.Metadata && dstCaps.Metadata && !hasMetadata
+	needTags := srcCaps.Tags && dstCaps.Tags && !hasTags
+	_, hasType := provider.ObjectContentType(job.FileInfo)
+	needType := srcCaps.ContentType && dstCaps.ContentType && !hasType
+	if !needMetadata && !needTags && !needType {
+		return job, nil
+	}
+	if provider.CanCopyServerSide(src, dst) {
+		return job, nil
+	}
+
+	info, err := src.Stat(ctx, job.SourcePath)
+	if err != nil {
+		return job, fmt.Errorf("failed to read metadata of %s: %w", job.SourcePath, err)
+	}
+	job.FileInfo = info
+	return job, nil
+}
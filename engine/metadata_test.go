@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// objectStore lists files without metadata but reports it from Stat, like
+// S3 with user metadata.
+type objectStore struct {
+	*mockProvider
+	stats int
+}
+
+func (o *objectStore) Capabilities() provider.Capabilities {
+	return provider.Capabilities{Metadata: true}
+}
+
+func (o *objectStore) Stat(ctx context.Context, path string) (provider.FileInfo, error) {
+	o.stats++
+	info, err := o.mockProvider.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return provider.NewUnixFileInfo(info, 1000, 1000, 0640), nil
+}
+
+func TestSourceMetadata(t *testing.T) {
+	ctx := context.Background()
+	src := &objectStore{mockProvider: newMockProvider()}
+	src.files["a.txt"] = mockFileInfo{name: "a.txt", size: 3}
+	job := TransferJob{ID: "a.txt", SourcePath: "a.txt", FileInfo: src.files["a.txt"]}
+
+	// A destination without metadata needs none
+	got, err := SourceMetadata(ctx, job, src, provider.NewLocalProvider(t.TempDir()).WithMetadataMapper(nil))
+	if err != nil || got.FileInfo != job.FileInfo || src.stats != 0 {
+		t.Fatalf("Expected the job unchanged without a stat, got %+v, %v", got, err)
+	}
+
+	got, err = SourceMetadata(ctx, job, src, provider.NewLocalProvider(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unix, ok := got.FileInfo.(provider.UnixFileInfo)
+	if !ok || unix.UID() != 1000 || unix.Mode() != 0640 {
+		t.Fatalf("Expected the source's metadata, got %+v", got.FileInfo)
+	}
+
+	// Listings that already carry metadata are not re-read
+	if _, err := SourceMetadata(ctx, got, src, provider.NewLocalProvider(t.TempDir())); err != nil || src.stats != 1 {
+		t.Errorf("Expected no second stat, got %d stats, %v", src.stats, err)
+	}
+}
+
+// taggedInfo is a mockFileInfo whose tags were looked up.
+type taggedInfo struct {
+	mockFileInfo
+	tags map[string]string
+}
+
+func (t taggedInfo) Tags() (map[string]string, bool) { return t.tags, true }
+
+// tagStore reports tags from Stat only, like S3.
+type tagStore struct {
+	*mockProvider
+	stats int
+}
+
+func (s *tagStore) Capabilities() provider.Capabilities {
+	return provider.Capabilities{Tags: true}
+}
+
+func (s *tagStore) Stat(ctx context.Context, path string) (provider.FileInfo, error) {
+	s.stats++
+	info, err := s.mockProvider.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return taggedInfo{mockFileInfo: info.(mockFileInfo), tags: map[string]string{"team": "data"}}, nil
+}
+
+func TestSourceMetadata_Tags(t *testing.T) {
+	ctx := context.Background()
+	src := &tagStore{mockProvider: newMockProvider()}
+	src.files["a.txt"] = mockFileInfo{name: "a.txt", size: 3}
+	job := TransferJob{ID: "a.txt", SourcePath: "a.txt", FileInfo: src.files["a.txt"]}
+
+	// A destination without tags needs none
+	if got, err := SourceMetadata(ctx, job, src, provider.NewLocalProvider(t.TempDir()).WithMetadataMapper(nil)); err != nil || got.FileInfo != job.FileInfo || src.stats != 0 {
+		t.Fatalf("Expected the job unchanged without a stat, got %+v, %v", got, err)
+	}
+
+	got, err := SourceMetadata(ctx, job, src, &tagStore{mockProvider: newMockProvider()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags, ok := provider.ObjectTags(got.FileInfo); !ok || tags["team"] != "data" {
+		t.Fatalf("Expected the source's tags, got %+v", got.FileInfo)
+	}
+	if _, err := SourceMetadata(ctx, got, src, &tagStore{mockProvider: newMockProvider()}); err != nil || src.stats != 1 {
+		t.Errorf("Expected no second stat, got %d stats, %v", src.stats, err)
+	}
+}
+
+// typedInfo is a mockFileInfo whose content type was looked up.
+type typedInfo struct {
+	mockFileInfo
+	contentType string
+}
+
+func (t typedInfo) ContentType() (string, bool) { return t.contentType, true }
+
+// typeStore reports content types from Stat only, like S3.
+type typeStore struct {
+	*mockProvider
+	stats int
+}
+
+func (s *typeStore) Capabilities() provider.Capabilities {
+	return provider.Capabilities{ContentType: true}
+}
+
+func (s *typeStore) Stat(ctx context.Context, path string) (provider.FileInfo, error) {
+	s.stats++
+	info, err := s.mockProvider.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return typedInfo{mockFileInfo: info.(mockFileInfo), contentType: "text/markdown"}, nil
+}
+
+func TestSourceMetadata_ContentType(t *testing.T) {
+	ctx := context.Background()
+	src := &typeStore{mockProvider: newMockProvider()}
+	src.files["a.md"] = mockFileInfo{name: "a.md", size: 3}
+	job := TransferJob{ID: "a.md", SourcePath: "a.md", FileInfo: src.files["a.md"]}
+
+	// A destination without content types needs none
+	if got, err := SourceMetadata(ctx, job, src, provider.NewLocalProvider(t.TempDir()).WithMetadataMapper(nil)); err != nil || got.FileInfo != job.FileInfo || src.stats != 0 {
+		t.Fatalf("Expected the job unchanged without a stat, got %+v, %v", got, err)
+	}
+
+	got, err := SourceMetadata(ctx, job, src, &typeStore{mockProvider: newMockProvider()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ, ok := provider.ObjectContentType(got.FileInfo); !ok || typ != "text/markdown" {
+		t.Fatalf("Expected the source's content type, got %+v", got.FileInfo)
+	}
+	if _, err := SourceMetadata(ctx, got, src, &typeStore{mockProvider: newMockProvider()}); err != nil || src.stats != 1 {
+		t.Errorf("Expected no second stat, got %d stats, %v", src.stats, err)
+	}
+}
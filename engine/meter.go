@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMeterWindow is the span a Meter averages rates over when Window
+// is unset.
+const DefaultMeterWindow = 10 * time.Second
+
+// Meter measures throughput over a sliding window, for the run as a whole
+// and for each running stream, from byte counters sampled at intervals. It
+// is safe for concurrent use.
+type Meter struct {
+	// Bytes, if set, returns the bytes the run has transferred so far,
+	// including those of transfers not read through Reader, such as
+	// chunked copies; the aggregate rate is taken from it. Otherwise the
+	// aggregate is the bytes read through Reader.
+	Bytes func() int64
+	// Window is the span rates are averaged over. Zero means
+	// DefaultMeterWindow.
+	Window time.Duration
+
+	read atomic.Int64
+
+	mu      sync.Mutex
+	total   meterWindow
+	streams map[string]*meteredStream
+}
+
+// StreamRate describes a running stream as measured by a Meter.
+type StreamRate struct {
+	JobID string `json:"job_id"`
+	Path  string `json:"path"`
+	// Bytes counts the bytes read so far, and Size is the file's size, or
+	// -1 if not known.
+	Bytes int64 `json:"bytes"`
+	Size  int64 `json:"size"`
+	// Rate is in bytes per second over the Meter's window.
+	Rate float64 `json:"rate"`
+}
+
+// Progress returns the share of the stream's file read so far, from 0 to 1,
+// or 0 if its size is not known.
+func (s StreamRate) Progress() float64 {
+	if s.Size <= 0 {
+		return 0
+	}
+	return min(float64(s.Bytes)/float64(s.Size), 1)
+}
+
+type meteredStream struct {
+	job     TransferJob
+	bytes   atomic.Int64
+	samples meterWindow
+}
+
+type meterSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// meterWindow holds the samples of one counter that fall in the window,
+// and the last one before it, so the rate spans the whole window.
+type meterWindow []meterSample
+
+func (w *meterWindow) add(s meterSample, span time.Duration) {
+	*w = append(*w, s)
+	cutoff := s.at.Add(-span)
+	drop := 0
+	for drop+1 < len(*w) && !(*w)[drop+1].at.After(cutoff) {
+		drop++
+	}
+	*w = (*w)[drop:]
+}
+
+func (w meterWindow) rate() float64 {
+	if len(w) < 2 {
+		return 0
+	}
+	first, last := w[0], w[len(w)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
+
+// NewMeter creates a Meter averaging rates over window; zero means
+// DefaultMeterWindow.
+func NewMeter(window time.Duration) *Meter {
+	return &Meter{Window: window, streams: make(map[string]*meteredStream)}
+}
+
+// Reader registers job as a running stream and returns r counting the bytes
+// read from it. done unregisters the stream; call it once the transfer
+// ends.
+func (m *Meter) Reader(job TransferJob, r io.Reader) (metered io.Reader, done func()) {
+	s := &meteredStream{job: job}
+	s.samples.add(meterSample{at: time.Now()}, m.window())
+	m.mu.Lock()
+	m.streams[job.ID] = s
+	m.mu.Unlock()
+
+	done = func() {
+		m.mu.Lock()
+		if m.streams[job.ID] == s {
+			delete(m.streams, job.ID)
+		}
+		m.mu.Unlock()
+	}
+	return &meteredReader{r: r, m: m, stream: s}, done
+}
+
+// Sample records the current counters at now; rates are computed from the
+// samples within the window.
+func (m *Meter) Sample(now time.Time) {
+	total := m.read.Load()
+	if m.Bytes != nil {
+		total = m.Bytes()
+	}
+	span := m.window()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total.add(meterSample{at: now, bytes: total}, span)
+	for _, s := range m.streams {
+		s.samples.add(meterSample{at: now, bytes: s.bytes.Load()}, span)
+	}
+}
+
+// Run samples the counters every interval until ctx is done.
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	m.Sample(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Sample(now)
+		}
+	}
+}
+
+// Rate returns the run's throughput in bytes per second over the window,
+// or 0 before two samples were taken.
+func (m *Meter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total.rate()
+}
+
+// ETA returns the time remaining bytes take at the current rate, and false
+// if there is no rate to estimate from.
+func (m *Meter) ETA(remaining int64) (time.Duration, bool) {
+	rate := m.Rate()
+	if rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(max(remaining, 0)) / rate * float64(time.Second)), true
+}
+
+// Streams returns the running streams, sorted by job ID.
+func (m *Meter) Streams() []StreamRate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rates := make([]StreamRate, 0, len(m.streams))
+	for _, s := range m.streams {
+		size := int64(-1)
+		if s.job.FileInfo != nil {
+			size = s.job.FileInfo.Size()
+		}
+		rates = append(rates, StreamRate{
+			JobID: s.job.ID,
+			Path:  s.job.SourcePath,
+			Bytes: s.bytes.Load(),
+			Size:  size,
+			Rate:  s.samples.rate(),
+		})
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].JobID < rates[j].JobID })
+	return rates
+}
+
+func (m *Meter) window() time.Duration {
+	if m.Window <= 0 {
+		return DefaultMeterWindow
+	}
+	return m.Window
+}
+
+type meteredReader struct {
+	r      io.Reader
+	m      *Meter
+	stream *meteredStream
+}
+
+// Unwrap returns the wrapped reader, for provider.SourceFile.
+func (r *meteredReader) Unwrap() io.Reader { return r.r }
+
+// Sent counts bytes copied from the source in the kernel.
+func (r *meteredReader) Sent(n int64) error {
+	r.stream.bytes.Add(n)
+	r.m.read.Add(n)
+	return nil
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.stream.bytes.Add(int64(n))
+		r.m.read.Add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	m := NewMeter(10 * time.Second)
+	start := time.Now()
+	a := TransferJob{ID: "/src/a", SourcePath: "/src/a", FileInfo: mockFileInfo{name: "a", size: 4000}}
+	b := TransferJob{ID: "/src/b", SourcePath: "/src/b"}
+
+	ra, doneA := m.Reader(a, bytes.NewReader(make([]byte, 4000)))
+	_, doneB := m.Reader(b, bytes.NewReader(nil))
+	m.Sample(start)
+	if m.Rate() != 0 {
+		t.Errorf("Expected no rate from a single sample, got %f", m.Rate())
+	}
+	if _, ok := m.ETA(100); ok {
+		t.Error("Expected no ETA without a rate")
+	}
+
+	io.CopyN(io.Discard, ra, 2000)
+	m.Sample(start.Add(2 * time.Second))
+	if rate := m.Rate(); rate != 1000 {
+		t.Errorf("Rate() = %f, want 1000", rate)
+	}
+	if eta, ok := m.ETA(5000); !ok || eta != 5*time.Second {
+		t.Errorf("ETA(5000) = %v, %v, want 5s", eta, ok)
+	}
+
+	streams := m.Streams()
+	if len(streams) != 2 {
+		t.Fatalf("Expected 2 streams, got %+v", streams)
+	}
+	if s := streams[0]; s.JobID != a.ID || s.Bytes != 2000 || s.Size != 4000 || s.Progress() != 0.5 || s.Rate <= 0 {
+		t.Errorf("Unexpected stream a: %+v", s)
+	}
+	if s := streams[1]; s.JobID != b.ID || s.Bytes != 0 || s.Size != -1 || s.Progress() != 0 || s.Rate != 0 {
+		t.Errorf("Unexpected stream b: %+v", s)
+	}
+
+	doneA()
+	doneB()
+	if len(m.Streams()) != 0 {
+		t.Error("Expected finished streams to be unregistered")
+	}
+}
+
+func TestMeter_Window(t *testing.T) {
+	var total int64
+	m := NewMeter(10 * time.Second)
+	m.Bytes = func() int64 { return total }
+	start := time.Now()
+
+	// A burst early on no longer counts once it leaves the window
+	m.Sample(start)
+	total = 100000
+	m.Sample(start.Add(time.Second))
+	for i := 2; i <= 30; i++ {
+		total += 1000
+		m.Sample(start.Add(time.Duration(i) * time.Second))
+	}
+	if rate := m.Rate(); math.Abs(rate-1000) > 1 {
+		t.Errorf("Rate() = %f, want 1000 after the burst left the window", rate)
+	}
+}
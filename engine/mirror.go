@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// PathSet records the destination paths of the jobs a walk queued, so a
+// mirroring sync can tell which destination files no longer have a source.
+// It is safe for concurrent use.
+type PathSet struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+	trees map[string]struct{}
+}
+
+// NewPathSet returns an empty PathSet.
+func NewPathSet() *PathSet {
+	return &PathSet{paths: make(map[string]struct{}), trees: make(map[string]struct{})}
+}
+
+// AddTree records every path below dir, for directories the walk left out
+// on purpose, so mirroring keeps what the destination holds there.
+func (s *PathSet) AddTree(dir string) {
+	s.mu.Lock()
+	s.trees[filepath.Clean(dir)] = struct{}{}
+	s.mu.Unlock()
+}
+
+// Add records path.
+func (s *PathSet) Add(path string) {
+	s.mu.Lock()
+	s.paths[filepath.Clean(path)] = struct{}{}
+	s.mu.Unlock()
+}
+
+// Has reports whether path was recorded, by itself or below a tree.
+func (s *PathSet) Has(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := s.paths[path]; ok {
+		return true
+	}
+	for len(s.trees) > 0 {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+		if _, ok := s.trees[path]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of recorded paths, not counting trees.
+func (s *PathSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.paths)
+}
+
+// TooManyDeletesError is returned by PruneDestination when more files
+// would be deleted than the configured maximum. Nothing was deleted.
+type TooManyDeletesError struct {
+	Count int
+	Max   int
+}
+
+func (e *TooManyDeletesError) Error() string {
+	return fmt.Sprintf("%d files to delete exceed the limit of %d; nothing was deleted", e.Count, e.Max)
+}
+
+// MirrorOptions controls PruneDestination.
+type MirrorOptions struct {
+	// DryRun only reports the files that would be deleted.
+	DryRun bool
+	// MaxDelete refuses to delete anything if more files than this are
+	// extraneous, guarding against a wrong or empty source wiping the
+	// destination. Zero means no limit.
+	MaxDelete int
+}
+
+// Extraneous lists the files below root on dst that are not in seen,
+// sorted. gofast's state replica, bundles and metadata sidecars are never
+// extraneous. A missing root, or one that is a file, has no extraneous
+// files.
+func Extraneous(ctx context.Context, dst provider.Provider, root string, seen *PathSet) ([]string, error) {
+	info, err := dst.Stat(ctx, root)
+	if provider.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat destination %s: %w", root, err)
+	}
+	if !info.IsDir() {
+		return nil, nil
+	}
+	files, err := ListTree(ctx, dst, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination: %w", err)
+	}
+
+	var paths []string
+	for rel := range files {
+		if strings.HasPrefix(rel, StateReplicaDir+"/") || strings.HasPrefix(rel, BundleDir+"/") || provider.IsSidecarPath(rel) {
+			continue
+		}
+		full := filepath.Join(root, filepath.FromSlash(rel))
+		if !seen.Has(full) {
+			paths = append(paths, full)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// PruneDestination deletes the files below root on dst that the walk did
+// not queue, as recorded in seen, making dst a mirror of the source. It
+// returns the files deleted, or under DryRun those that would be; files
+// that could not be deleted are reported in a *provider.BatchDeleteError.
+// Call it only after a walk that completed, or files whose source was never
+// listed are deleted too.
+func PruneDestination(ctx context.Context, dst provider.Provider, root string, seen *PathSet, opts MirrorOptions) ([]string, error) {
+	paths, err := Extraneous(ctx, dst, root, seen)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxDelete > 0 && len(paths) > opts.MaxDelete {
+		return nil, &TooManyDeletesError{Count: len(paths), Max: opts.MaxDelete}
+	}
+	if opts.DryRun || len(paths) == 0 {
+		return paths, nil
+	}
+	err = provider.DeleteAll(ctx, dst, paths)
+	var batchErr *provider.BatchDeleteError
+	if errors.As(err, &batchErr) {
+		failed := make(map[string]bool, len(batchErr.Failures))
+		for _, f := range batchErr.Failures {
+			failed[f.Path] = true
+		}
+		deleted := paths[:0]
+		for _, p := range paths {
+			if !failed[p] {
+				deleted = append(deleted, p)
+			}
+		}
+		return deleted, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
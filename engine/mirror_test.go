@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestPruneDestination(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	for _, name := range []string{"keep.txt", "sub/keep.txt"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(srcDir, name)), 0755)
+		os.WriteFile(filepath.Join(srcDir, name), []byte("x"), 0644)
+	}
+	for _, name := range []string{"keep.txt", "sub/keep.txt", "gone.txt", "sub/gone.txt", StateReplicaDir + "/state.db"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dstDir, name)), 0755)
+		os.WriteFile(filepath.Join(dstDir, name), []byte("x"), 0644)
+	}
+
+	// Paths are absolute, as they are for an unconfined local provider
+	src := provider.NewLocalProvider("/")
+	dst := provider.NewLocalProvider("/")
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(src, jobChan)
+	walker.Seen = NewPathSet()
+	if err := walker.Walk(ctx, srcDir, dstDir); err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	close(jobChan)
+	if walker.Seen.Len() != 2 {
+		t.Fatalf("Expected 2 queued paths, got %d", walker.Seen.Len())
+	}
+
+	want := []string{filepath.Join(dstDir, "gone.txt"), filepath.Join(dstDir, "sub", "gone.txt")}
+
+	paths, err := PruneDestination(ctx, dst, dstDir, walker.Seen, MirrorOptions{MaxDelete: 1})
+	var tooMany *TooManyDeletesError
+	if !errors.As(err, &tooMany) || tooMany.Count != 2 {
+		t.Fatalf("Expected TooManyDeletesError for 2 files, got %v", err)
+	}
+
+	paths, err = PruneDestination(ctx, dst, dstDir, walker.Seen, MirrorOptions{DryRun: true})
+	if err != nil || !reflect.DeepEqual(paths, want) {
+		t.Fatalf("Dry run: expected %v, got %v, %v", want, paths, err)
+	}
+	if _, err := os.Stat(want[0]); err != nil {
+		t.Fatalf("Dry run deleted %s", want[0])
+	}
+
+	paths, err = PruneDestination(ctx, dst, dstDir, walker.Seen, MirrorOptions{MaxDelete: 2})
+	if err != nil || !reflect.DeepEqual(paths, want) {
+		t.Fatalf("Expected %v deleted, got %v, %v", want, paths, err)
+	}
+	for _, p := range want {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Expected %s deleted, got %v", p, err)
+		}
+	}
+	for _, name := range []string{"keep.txt", "sub/keep.txt", StateReplicaDir + "/state.db"} {
+		if _, err := os.Stat(filepath.Join(dstDir, name)); err != nil {
+			t.Errorf("Expected %s kept, got %v", name, err)
+		}
+	}
+}
+
+func TestPruneDestination_MissingRoot(t *testing.T) {
+	dst := provider.NewLocalProvider(t.TempDir())
+	paths, err := PruneDestination(context.Background(), dst, "missing", NewPathSet(), MirrorOptions{})
+	if err != nil || len(paths) != 0 {
+		t.Errorf("Expected nothing to delete, got %v, %v", paths, err)
+	}
+}
+
+func TestPathSet_AddTree(t *testing.T) {
+	s := NewPathSet()
+	s.Add("/dst/a.txt")
+	s.AddTree("/dst/mnt")
+	for path, want := range map[string]bool{
+		"/dst/a.txt":       true,
+		"/dst/mnt/x/y.txt": true,
+		"/dst/mnt":         false,
+		"/dst/mntother/z":  false,
+		"/dst/b.txt":       false,
+	} {
+		if got := s.Has(path); got != want {
+			t.Errorf("Has(%s) = %v, want %v", path, got, want)
+		}
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len = %d, want 1", s.Len())
+	}
+}
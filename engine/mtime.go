@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// MTimePolicy decides what happens to source modification times that lie
+// in the future or before the Unix epoch, usually from clock skew or
+// corrupted metadata.
+type MTimePolicy string
+
+const (
+	// MTimePreserve copies out-of-range times as they are, only recording
+	// them.
+	MTimePreserve MTimePolicy = "preserve"
+	// MTimeClamp replaces future times with the current time and times
+	// before the epoch with the epoch.
+	MTimeClamp MTimePolicy = "clamp"
+)
+
+// DefaultClockSkew is how far in the future a modification time may lie
+// before it counts as out of range.
+const DefaultClockSkew = 24 * time.Hour
+
+// ParseMTimePolicy parses "preserve" or "clamp".
+func ParseMTimePolicy(s string) (MTimePolicy, error) {
+	switch policy := MTimePolicy(s); policy {
+	case MTimePreserve, MTimeClamp:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown mtime policy %q (want preserve or clamp)", s)
+}
+
+// MTimeChecker finds out-of-range modification times and applies a policy
+// to them.
+type MTimeChecker struct {
+	Policy MTimePolicy
+	// Skew is the tolerance for times in the future.
+	Skew time.Duration
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Check returns info, with its modification time adjusted if the policy
+// requires it, and a description of the problem for the report, or "" if
+// the time is in range. A zero time means unknown and is left alone.
+func (c MTimeChecker) Check(info provider.FileInfo) (provider.FileInfo, string) {
+	if info == nil || info.ModTime().IsZero() {
+		return info, ""
+	}
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+
+	mtime := info.ModTime()
+	var problem string
+	var clamped time.Time
+	switch epoch := time.Unix(0, 0); {
+	case mtime.After(now.Add(c.Skew)):
+		problem, clamped = "in the future", now
+	case mtime.Before(epoch):
+		problem, clamped = "before the epoch", epoch
+	default:
+		return info, ""
+	}
+
+	stamp := mtime.UTC().Format(time.RFC3339)
+	if c.Policy != MTimeClamp {
+		return info, fmt.Sprintf("mtime %s is %s; preserved", stamp, problem)
+	}
+	return provider.WithModTime(info, clamped),
+		fmt.Sprintf("mtime %s is %s; set to %s", stamp, problem, clamped.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestMTimeChecker(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	info := func(mtime time.Time) provider.FileInfo {
+		return provider.NewUnixFileInfo(mockFileInfo{name: "f", size: 1, modTime: mtime}, 1000, 1000, 0o640)
+	}
+
+	tests := []struct {
+		name    string
+		policy  MTimePolicy
+		mtime   time.Time
+		want    time.Time
+		flagged bool
+	}{
+		{"in range", MTimeClamp, now.Add(-time.Hour), now.Add(-time.Hour), false},
+		{"within skew", MTimeClamp, now.Add(time.Hour), now.Add(time.Hour), false},
+		{"unknown", MTimeClamp, time.Time{}, time.Time{}, false},
+		{"future preserved", MTimePreserve, now.AddDate(50, 0, 0), now.AddDate(50, 0, 0), true},
+		{"future clamped", MTimeClamp, now.AddDate(50, 0, 0), now, true},
+		{"pre-epoch clamped", MTimeClamp, time.Date(1901, 1, 1, 0, 0, 0, 0, time.UTC), time.Unix(0, 0), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := MTimeChecker{Policy: tt.policy, Skew: DefaultClockSkew, Now: func() time.Time { return now }}
+			got, adjustment := c.Check(info(tt.mtime))
+			if (adjustment != "") != tt.flagged {
+				t.Fatalf("adjustment = %q; flagged %v", adjustment, tt.flagged)
+			}
+			if !got.ModTime().Equal(tt.want) {
+				t.Errorf("mtime = %v; want %v", got.ModTime(), tt.want)
+			}
+			if u, ok := got.(provider.UnixFileInfo); !ok || u.Mode() != 0o640 || u.UID() != 1000 {
+				t.Errorf("expected ownership and mode to survive, got %#v", got)
+			}
+			if tt.flagged && tt.policy == MTimeClamp && !strings.Contains(adjustment, "set to") {
+				t.Errorf("expected the adjustment to name the new time, got %q", adjustment)
+			}
+		})
+	}
+
+	if _, err := ParseMTimePolicy("ignore"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
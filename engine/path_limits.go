@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PathLimit caps the jobs running at once whose source or destination path
+// lies below Prefix, such as the streams against a fragile NFS export.
+type PathLimit struct {
+	// Source matches the prefix against the source path of jobs rather
+	// than their destination path.
+	Source bool
+	Prefix string
+	Max    int
+}
+
+func (l PathLimit) matches(job TransferJob) bool {
+	p := job.DestinationPath
+	if l.Source {
+		p = job.SourcePath
+	}
+	p = strings.ReplaceAll(p, "\\", "/")
+	prefix := strings.TrimSuffix(strings.ReplaceAll(l.Prefix, "\\", "/"), "/")
+	if prefix == "" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// ParsePathLimits parses a comma-separated list of side:prefix=N rules,
+// e.g. "source:/mnt/nfs=4,dest:archive/=64". The side is source or dest;
+// prefixes are matched against the paths of jobs as the run sees them, so
+// absolute for local trees and relative to the bucket prefix for object
+// stores.
+func ParsePathLimits(spec string) ([]PathLimit, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var limits []PathLimit
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		// Prefixes may hold '=' themselves; the count follows the last
+		eq := strings.LastIndex(part, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid path limit %q, expected source:prefix=N or dest:prefix=N", part)
+		}
+		count := part[eq+1:]
+		side, prefix, ok := strings.Cut(part[:eq], ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid path limit %q, expected source:prefix=N or dest:prefix=N", part)
+		}
+		var l PathLimit
+		switch side {
+		case "source":
+			l.Source = true
+		case "dest":
+		default:
+			return nil, fmt.Errorf("invalid side %q in path limit %q (want source or dest)", side, part)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid count in path limit %q: want a positive number", part)
+		}
+		l.Prefix, l.Max = prefix, n
+		limits = append(limits, l)
+	}
+	return limits, nil
+}
+
+// PathLimits enforces PathLimit rules across the workers of a pool; see
+// WorkerPool.SetPathLimits. A job matching several rules counts against
+// each. A worker given a job whose limit is reached parks it and moves on
+// to other jobs; the parked job runs on the worker of the next matching
+// job to finish, so the pool's other work is not held up behind it. It is
+// safe for concurrent use.
+type PathLimits struct {
+	limits []PathLimit
+
+	mu     sync.Mutex
+	active []int
+	// parked holds jobs waiting for a limit, by the first rule found full
+	parked [][]TransferJob
+}
+
+// NewPathLimits returns limits enforcing rules.
+func NewPathLimits(rules []PathLimit) *PathLimits {
+	return &PathLimits{
+		limits: rules,
+		active: make([]int, len(rules)),
+		parked: make([][]TransferJob, len(rules)),
+	}
+}
+
+// rules returns the indexes of the limits job counts against.
+func (l *PathLimits) rules(job TransferJob) []int {
+	var idx []int
+	for i, limit := range l.limits {
+		if limit.matches(job) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// full returns the first of rules at its limit, or -1. l.mu must be held.
+func (l *PathLimits) full(rules []int) int {
+	for _, i := range rules {
+		if l.active[i] >= l.limits[i].Max {
+			return i
+		}
+	}
+	return -1
+}
+
+// Acquire counts job against its limits and returns true if it may run
+// now. Otherwise the job is parked, to be returned by a later Release,
+// and Acquire returns false.
+func (l *PathLimits) Acquire(job TransferJob) bool {
+	rules := l.rules(job)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := l.full(rules); i >= 0 {
+		l.parked[i] = append(l.parked[i], job)
+		return false
+	}
+	for _, i := range rules {
+		l.active[i]++
+	}
+	return true
+}
+
+// Release stops counting job, which Acquire let run, against its limits.
+// If that lets a parked job run, Release counts it and returns it for the
+// caller to run in job's place.
+func (l *PathLimits) Release(job TransferJob) (TransferJob, bool) {
+	rules := l.rules(job)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, i := range rules {
+		l.active[i]--
+	}
+	// Any parked job may be the one able to run: it was parked on the
+	// first full rule only, and may be held by others since
+	for i := range l.parked {
+		for j, next := range l.parked[i] {
+			nextRules := l.rules(next)
+			if l.full(nextRules) >= 0 {
+				continue
+			}
+			l.parked[i] = append(l.parked[i][:j], l.parked[i][j+1:]...)
+			for _, r := range nextRules {
+				l.active[r]++
+			}
+			return next, true
+		}
+	}
+	return TransferJob{}, false
+}
+
+// Active returns the number of running jobs counted against each rule.
+func (l *PathLimits) Active() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int(nil), l.active...)
+}
+
+// Parked returns the number of jobs waiting for a limit.
+func (l *PathLimits) Parked() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, jobs := range l.parked {
+		n += len(jobs)
+	}
+	return n
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParsePathLimits(t *testing.T) {
+	limits, err := ParsePathLimits("source:/mnt/nfs=4, dest:archive/=64,dest:a=b/c=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PathLimit{
+		{Source: true, Prefix: "/mnt/nfs", Max: 4},
+		{Prefix: "archive/", Max: 64},
+		{Prefix: "a=b/c", Max: 2},
+	}
+	if len(limits) != len(want) {
+		t.Fatalf("Expected %d limits, got %+v", len(want), limits)
+	}
+	for i := range want {
+		if limits[i] != want[i] {
+			t.Errorf("Limit %d = %+v, want %+v", i, limits[i], want[i])
+		}
+	}
+
+	for _, spec := range []string{"/mnt/nfs=4", "src:/mnt=4", "dest:x=0", "dest:x=many", "dest:x"} {
+		if _, err := ParsePathLimits(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestPathLimit_Matches(t *testing.T) {
+	nfs := PathLimit{Source: true, Prefix: "/mnt/nfs/", Max: 1}
+	for path, want := range map[string]bool{
+		"/mnt/nfs":          true,
+		"/mnt/nfs/a/b.txt":  true,
+		"/mnt/nfs2/a.txt":   false,
+		"/srv/mnt/nfs/a":    false,
+		`\mnt\nfs\win.txt`:  true,
+		"/mnt/other/nfs/xx": false,
+	} {
+		if got := nfs.matches(TransferJob{SourcePath: path, DestinationPath: "/mnt/nfs/x"}); got != want {
+			t.Errorf("matches(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestPathLimits_ParkAndRelease(t *testing.T) {
+	l := NewPathLimits([]PathLimit{
+		{Source: true, Prefix: "/nfs", Max: 1},
+		{Prefix: "slow", Max: 1},
+	})
+	a := TransferJob{ID: "a", SourcePath: "/nfs/a", DestinationPath: "fast/a"}
+	b := TransferJob{ID: "b", SourcePath: "/nfs/b", DestinationPath: "slow/b"}
+	c := TransferJob{ID: "c", SourcePath: "/local/c", DestinationPath: "slow/c"}
+	free := TransferJob{ID: "d", SourcePath: "/local/d", DestinationPath: "fast/d"}
+
+	if !l.Acquire(a) {
+		t.Fatal("Expected the first job to run")
+	}
+	if !l.Acquire(c) {
+		t.Fatal("Expected a job under another limit to run")
+	}
+	if l.Acquire(b) {
+		t.Fatal("Expected a job over its limit to be parked")
+	}
+	if !l.Acquire(free) {
+		t.Fatal("Expected a job under no limit to run")
+	}
+
+	// b still waits on slow once nfs frees, and runs once both have
+	if _, ok := l.Release(a); ok {
+		t.Fatal("Expected the parked job to wait for its other limit")
+	}
+	next, ok := l.Release(c)
+	if !ok || next.ID != "b" {
+		t.Fatalf("Expected b to run once both limits freed, got %v %v", next.ID, ok)
+	}
+	if got := l.Active(); got[0] != 1 || got[1] != 1 || l.Parked() != 0 {
+		t.Errorf("Expected b counted against both limits, got %v with %d parked", got, l.Parked())
+	}
+}
+
+func TestWorkerPool_PathLimits(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		running, maxSlow  int
+		handled           = make(map[string]bool)
+		fastDoneWhileSlow bool
+	)
+	release := make(chan struct{})
+	handler := func(ctx context.Context, job TransferJob) error {
+		slow := strings.HasPrefix(job.DestinationPath, "nfs/")
+		mu.Lock()
+		if slow {
+			running++
+			maxSlow = max(maxSlow, running)
+		} else if running > 0 {
+			fastDoneWhileSlow = true
+		}
+		mu.Unlock()
+		if slow {
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+		mu.Lock()
+		handled[job.ID] = true
+		mu.Unlock()
+		return nil
+	}
+
+	ch := make(JobChannel)
+	pool := NewWorkerPool(context.Background(), ch, handler)
+	pool.SetPathLimits(NewPathLimits([]PathLimit{{Prefix: "nfs", Max: 2}}))
+	pool.SetWorkerCount(4)
+
+	for i := 0; i < 6; i++ {
+		ch <- TransferJob{ID: fmt.Sprint("nfs", i), DestinationPath: fmt.Sprint("nfs/", i)}
+	}
+	// Workers are not tied up by the parked jobs
+	for i := 0; i < 3; i++ {
+		select {
+		case ch <- TransferJob{ID: fmt.Sprint("s3", i), DestinationPath: fmt.Sprint("s3/", i)}:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected workers to take other jobs while nfs jobs are parked")
+		}
+	}
+	close(ch)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	pool.Wait()
+
+	if len(handled) != 9 {
+		t.Errorf("Expected every job to run, got %d", len(handled))
+	}
+	if maxSlow != 2 {
+		t.Errorf("Expected 2 nfs jobs at once, got %d", maxSlow)
+	}
+	if !fastDoneWhileSlow {
+		t.Error("Expected other jobs to run while nfs jobs were running")
+	}
+}
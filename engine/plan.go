@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// PlanAction is what a sync would do with one path.
+type PlanAction string
+
+const (
+	// ActionCopy means the file is missing at the destination.
+	ActionCopy PlanAction = "copy"
+	// ActionUpdate means the destination holds different content.
+	ActionUpdate PlanAction = "update"
+	// ActionMetadata means the content matches but ownership or
+	// permissions do not.
+	ActionMetadata PlanAction = "metadata"
+	// ActionDelete means the file only exists at the destination and a
+	// mirroring sync would remove it.
+	ActionDelete PlanAction = "delete"
+	// ActionSkip means the file is already in sync.
+	ActionSkip PlanAction = "skip"
+)
+
+// PlanEntry is the planned action for one path, relative to the roots.
+type PlanEntry struct {
+	Path   string     `json:"path"`
+	Action PlanAction `json:"action"`
+	Size   int64      `json:"size"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// PlanTotal counts the files and bytes for one action.
+type PlanTotal struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Plan is a cutover-readiness report: what a sync from source to
+// destination would do, without doing any of it.
+type Plan struct {
+	GeneratedAt time.Time                `json:"generated_at"`
+	Source      string                   `json:"source"`
+	Destination string                   `json:"destination"`
+	Totals      map[PlanAction]PlanTotal `json:"totals"`
+	Shape       *NamespaceShape          `json:"shape"`
+	Entries     []PlanEntry              `json:"entries"`
+}
+
+// PlanOptions tunes the comparison.
+type PlanOptions struct {
+	// ModTimeWindow is the tolerance when comparing modification times,
+	// for filesystems with coarse timestamps.
+	ModTimeWindow time.Duration
+	// SourceManifest and DestManifest, if both set, are checksum manifests
+	// from `gfast hash`; files of equal size whose checksums differ are
+	// planned as updates.
+	SourceManifest []ManifestEntry
+	DestManifest   []ManifestEntry
+	// IncludeSkipped lists in-sync files as ActionSkip entries too.
+	IncludeSkipped bool
+}
+
+// ListTree walks root on p and returns every file keyed by its slash-separated
+// path relative to root. A root that is itself a file is keyed by its name.
+func ListTree(ctx context.Context, p provider.Provider, root string) (map[string]provider.FileInfo, error) {
+	jobs, err := listTreeJobs(ctx, p, root)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]provider.FileInfo, len(jobs))
+	for rel, job := range jobs {
+		files[rel] = job.FileInfo
+	}
+	return files, nil
+}
+
+// listTreeJobs is ListTree returning the walker's jobs, whose SourcePath is
+// the full path of each file on p.
+func listTreeJobs(ctx context.Context, p provider.Provider, root string) (map[string]TransferJob, error) {
+	jobChan := make(JobChannel, 1000)
+	walker := NewWalker(p, jobChan)
+
+	errc := make(chan error, 1)
+	go func() {
+		defer close(jobChan)
+		errc <- walker.Walk(ctx, root, "")
+	}()
+
+	jobs := make(map[string]TransferJob)
+	for job := range jobChan {
+		rel := filepath.ToSlash(job.DestinationPath)
+		if rel == "" {
+			rel = path.Base(filepath.ToSlash(job.SourcePath))
+		}
+		jobs[rel] = job
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// BuildPlan compares the source and destination trees, as returned by
+// ListTree, and returns the actions a sync would take, sorted by path.
+func BuildPlan(src, dst map[string]provider.FileInfo, opts PlanOptions) *Plan {
+	plan := &Plan{
+		GeneratedAt: time.Now(),
+		Totals:      make(map[PlanAction]PlanTotal),
+		Shape:       ShapeOf(src),
+	}
+
+	srcSums := manifestIndex(opts.SourceManifest)
+	dstSums := manifestIndex(opts.DestManifest)
+
+	add := func(entry PlanEntry) {
+		total := plan.Totals[entry.Action]
+		total.Files++
+		total.Bytes += entry.Size
+		plan.Totals[entry.Action] = total
+		if entry.Action != ActionSkip || opts.IncludeSkipped {
+			plan.Entries = append(plan.Entries, entry)
+		}
+	}
+
+	for rel, srcInfo := range src {
+		dstInfo, ok := dst[rel]
+		entry := PlanEntry{Path: rel, Size: srcInfo.Size(), Action: ActionSkip}
+		switch {
+		case !ok:
+			entry.Action = ActionCopy
+		case dstInfo.Size() != srcInfo.Size():
+			entry.Action = ActionUpdate
+			entry.Reason = fmt.Sprintf("size %d at destination", dstInfo.Size())
+		case srcInfo.ModTime().Sub(dstInfo.ModTime()) > opts.ModTimeWindow:
+			entry.Action = ActionUpdate
+			entry.Reason = "source is newer"
+		case checksumsDiffer(srcSums[rel], dstSums[rel]):
+			entry.Action = ActionUpdate
+			entry.Reason = "checksum differs"
+		default:
+			if reason := metadataDiff(srcInfo, dstInfo); reason != "" {
+				entry.Action = ActionMetadata
+				entry.Reason = reason
+				entry.Size = 0
+			}
+		}
+		add(entry)
+	}
+
+	for rel, dstInfo := range dst {
+		// gofast's own state replica, bundles and metadata sidecars are not data
+		if strings.HasPrefix(rel, StateReplicaDir+"/") || strings.HasPrefix(rel, BundleDir+"/") || provider.IsSidecarPath(rel) {
+			continue
+		}
+		if _, ok := src[rel]; !ok {
+			add(PlanEntry{Path: rel, Action: ActionDelete, Size: dstInfo.Size()})
+		}
+	}
+
+	sort.Slice(plan.Entries, func(i, j int) bool { return plan.Entries[i].Path < plan.Entries[j].Path })
+	return plan
+}
+
+func manifestIndex(entries []ManifestEntry) map[string]ManifestEntry {
+	index := make(map[string]ManifestEntry, len(entries))
+	for _, entry := range entries {
+		index[entry.Path] = entry
+	}
+	return index
+}
+
+// checksumsDiffer reports whether two manifest entries were hashed with the
+// same algorithm and disagree. Missing entries never differ.
+func checksumsDiffer(a, b ManifestEntry) bool {
+	return a.Checksum != "" && b.Checksum != "" && a.Algorithm == b.Algorithm && a.Checksum != b.Checksum
+}
+
+// metadataDiff describes how the ownership or permissions of dst differ
+// from src, or returns "" if they match or either side has none.
+func metadataDiff(src, dst provider.FileInfo) string {
+	s, ok := src.(provider.UnixFileInfo)
+	if !ok {
+		return ""
+	}
+	d, ok := dst.(provider.UnixFileInfo)
+	if !ok {
+		return ""
+	}
+	switch {
+	case s.Mode() != d.Mode():
+		return fmt.Sprintf("mode %v at destination, %v at source", d.Mode(), s.Mode())
+	case s.UID() != d.UID() || s.GID() != d.GID():
+		return fmt.Sprintf("owner %d:%d at destination, %d:%d at source", d.UID(), d.GID(), s.UID(), s.GID())
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestBuildPlan(t *testing.T) {
+	now := time.Now()
+	older := now.Add(-time.Hour)
+	unix := func(size int64, mtime time.Time, mode uint32) provider.FileInfo {
+		return provider.NewUnixFileInfo(mockFileInfo{name: "f", size: size, modTime: mtime}, 1000, 1000, os.FileMode(0o644|mode))
+	}
+
+	src := map[string]provider.FileInfo{
+		"new.txt":     mockFileInfo{name: "new.txt", size: 10, modTime: now},
+		"grown.txt":   mockFileInfo{name: "grown.txt", size: 20, modTime: now},
+		"touched.txt": mockFileInfo{name: "touched.txt", size: 5, modTime: now},
+		"same.txt":    mockFileInfo{name: "same.txt", size: 5, modTime: now},
+		"chmod.txt":   unix(7, now, 0o100),
+		"hashed.txt":  mockFileInfo{name: "hashed.txt", size: 3, modTime: now},
+	}
+	dst := map[string]provider.FileInfo{
+		"grown.txt":   mockFileInfo{name: "grown.txt", size: 10, modTime: now},
+		"touched.txt": mockFileInfo{name: "touched.txt", size: 5, modTime: older},
+		"same.txt":    mockFileInfo{name: "same.txt", size: 5, modTime: now.Add(time.Minute)},
+		"chmod.txt":   unix(7, now, 0),
+		"hashed.txt":  mockFileInfo{name: "hashed.txt", size: 3, modTime: now},
+		"stale.txt":   mockFileInfo{name: "stale.txt", size: 4, modTime: now},
+
+		StateReplicaDir + "/state.db": mockFileInfo{name: "state.db", size: 32768, modTime: now},
+		"same.txt.gofast-meta":        mockFileInfo{name: "same.txt.gofast-meta", size: 100, modTime: now},
+	}
+
+	plan := BuildPlan(src, dst, PlanOptions{
+		ModTimeWindow:  time.Second,
+		SourceManifest: []ManifestEntry{{Path: "hashed.txt", Algorithm: AlgorithmXXH3, Checksum: "aa"}},
+		DestManifest:   []ManifestEntry{{Path: "hashed.txt", Algorithm: AlgorithmXXH3, Checksum: "bb"}},
+	})
+
+	want := map[string]PlanAction{
+		"chmod.txt":   ActionMetadata,
+		"grown.txt":   ActionUpdate,
+		"hashed.txt":  ActionUpdate,
+		"new.txt":     ActionCopy,
+		"stale.txt":   ActionDelete,
+		"touched.txt": ActionUpdate,
+	}
+	if len(plan.Entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %+v", len(want), plan.Entries)
+	}
+	for i, entry := range plan.Entries {
+		if i > 0 && plan.Entries[i-1].Path >= entry.Path {
+			t.Errorf("Expected entries sorted by path, got %s after %s", entry.Path, plan.Entries[i-1].Path)
+		}
+		if want[entry.Path] != entry.Action {
+			t.Errorf("%s: expected %s, got %s (%s)", entry.Path, want[entry.Path], entry.Action, entry.Reason)
+		}
+	}
+
+	if total := plan.Totals[ActionUpdate]; total.Files != 3 || total.Bytes != 28 {
+		t.Errorf("Unexpected update totals: %+v", total)
+	}
+	if total := plan.Totals[ActionSkip]; total.Files != 1 {
+		t.Errorf("Expected 1 skipped file, got %+v", total)
+	}
+}
+
+func TestListTree(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{{name: "a.txt", size: 1}, {name: "sub", isDir: true}}
+	mp.dirs["/root/sub"] = []mockFileInfo{{name: "b.txt", size: 2}}
+
+	files, err := ListTree(context.Background(), mp, "/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files["a.txt"] == nil || files["sub/b.txt"] == nil {
+		t.Errorf("Unexpected tree: %v", files)
+	}
+}
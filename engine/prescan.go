@@ -0,0 +1,42 @@
+package engine
+
+import "context"
+
+// Prescan counts the files below sourcePath a walk with w's settings would
+// queue, and their bytes, without queueing anything, so progress can be
+// reported against real totals from the start. It lists the whole source
+// once more, which is quick for local trees and flat object listings.
+// Jobs queued without their FileInfo count as empty files.
+func (w *Walker) Prescan(ctx context.Context, sourcePath string) (files, bytes int64, err error) {
+	scan := w.scanner()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for job := range scan.JobChan {
+			files++
+			bytes += jobSize(job)
+		}
+	}()
+	err = scan.Walk(ctx, sourcePath, sourcePath)
+	close(scan.JobChan)
+	<-done
+	return files, bytes, err
+}
+
+// scanner returns a walker selecting the files w would, for a walk of its
+// own that counts or records them rather than queueing them for transfer.
+func (w *Walker) scanner() *Walker {
+	return &Walker{
+		SourceProvider: w.SourceProvider,
+		JobChan:        make(JobChannel, 1000),
+		Sorted:         w.Sorted,
+		Flat:           w.Flat,
+		Versions:       w.Versions,
+		Symlinks:       w.Symlinks,
+		Filter:         w.Filter,
+		ErrorPolicy:    w.ErrorPolicy,
+		MaxDepth:       w.MaxDepth,
+		OneFileSystem:  w.OneFileSystem,
+		Listers:        w.Listers,
+	}
+}
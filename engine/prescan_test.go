@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWalker_Prescan(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{
+		{name: "a.txt", size: 10},
+		{name: "big.bin", size: 5000},
+		{name: "dir", isDir: true},
+	}
+	mp.dirs["/root/dir"] = []mockFileInfo{{name: "b.txt", size: 20}}
+
+	jobChan := make(JobChannel)
+	walker := NewWalker(mp, jobChan)
+	walker.Seen = NewPathSet()
+	walker.Filter = &FileFilter{MaxSize: 100}
+	var queued int
+	walker.OnQueue = func(TransferJob) { queued++ }
+
+	files, bytes, err := walker.Prescan(context.Background(), "/root")
+	if err != nil {
+		t.Fatalf("Prescan failed: %v", err)
+	}
+	if files != 2 || bytes != 30 {
+		t.Errorf("Prescan = %d files, %d bytes; want 2 files, 30 bytes", files, bytes)
+	}
+	// Nothing is queued, recorded or reported by the scan
+	select {
+	case job := <-jobChan:
+		t.Errorf("Prescan queued %s", job.SourcePath)
+	default:
+	}
+	if queued != 0 || walker.Seen.Len() != 0 || walker.Filtered != 0 {
+		t.Errorf("Prescan touched the walker: %d queued, %d seen, %d filtered", queued, walker.Seen.Len(), walker.Filtered)
+	}
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// PresignedTransfer is one file of a presigned transfer list: requests
+// signed by a host holding credentials, which a worker without any carries
+// out over plain HTTP. A side without a request is the worker's own
+// storage, read or written at Path.
+type PresignedTransfer struct {
+	Path    string                     `json:"path"`
+	Size    int64                      `json:"size"`
+	ModTime time.Time                  `json:"mtime"`
+	Get     *provider.PresignedRequest `json:"get,omitempty"`
+	Put     *provider.PresignedRequest `json:"put,omitempty"`
+}
+
+// PresignTransfer signs the requests moving job from src to dst, valid for
+// ttl, on whichever of the two providers are Presigners. At least one must
+// be.
+func PresignTransfer(ctx context.Context, job TransferJob, src, dst provider.Provider, ttl time.Duration) (PresignedTransfer, error) {
+	t := PresignedTransfer{
+		Path:    filepath.ToSlash(job.DestinationPath),
+		Size:    job.FileInfo.Size(),
+		ModTime: job.FileInfo.ModTime(),
+	}
+	if t.Path == "" {
+		t.Path = path.Base(filepath.ToSlash(job.SourcePath))
+	}
+
+	if p, ok := src.(provider.Presigner); ok && provider.CapabilitiesOf(src).Presign {
+		get, err := p.PresignGet(ctx, job.SourcePath, ttl)
+		if err != nil {
+			return t, err
+		}
+		t.Get = &get
+	}
+	if p, ok := dst.(provider.Presigner); ok && provider.CapabilitiesOf(dst).Presign {
+		put, err := p.PresignPut(ctx, job.DestinationPath, job.FileInfo, ttl)
+		if err != nil {
+			return t, err
+		}
+		t.Put = &put
+	}
+	if t.Get == nil && t.Put == nil {
+		return t, fmt.Errorf("neither the source nor the destination of %s can presign requests", job.SourcePath)
+	}
+	return t, nil
+}
+
+// RunPresigned carries out t with client, reading the file with t.Get or
+// from local, and writing it with t.Put or to local, at t.Path under root.
+// It returns the number of bytes moved.
+func RunPresigned(ctx context.Context, client *http.Client, t PresignedTransfer, local provider.Provider, root string, buf []byte) (int64, error) {
+	localPath := filepath.Join(root, filepath.FromSlash(t.Path))
+	var rc io.ReadCloser
+	var err error
+	if t.Get != nil {
+		rc, err = provider.OpenPresigned(ctx, client, *t.Get)
+	} else if local != nil {
+		rc, err = local.OpenRead(ctx, localPath)
+	} else {
+		err = fmt.Errorf("no presigned read of %s and no local source", t.Path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	if t.Put != nil {
+		counter := &countingReader{r: rc}
+		err := provider.PutPresigned(ctx, client, *t.Put, io.LimitReader(counter, t.Size), t.Size)
+		return counter.n, err
+	}
+	if local == nil {
+		return 0, fmt.Errorf("no presigned write of %s and no local destination", t.Path)
+	}
+
+	w, err := local.OpenWrite(ctx, localPath, &presignedInfo{name: path.Base(t.Path), size: t.Size, modTime: t.ModTime})
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.CopyBuffer(w, rc, buf)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != t.Size {
+		err = fmt.Errorf("presigned read of %s returned %d bytes, expected %d", t.Path, n, t.Size)
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// presignedInfo describes a file of a presigned transfer list.
+type presignedInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *presignedInfo) Name() string       { return i.name }
+func (i *presignedInfo) Size() int64        { return i.size }
+func (i *presignedInfo) IsDir() bool        { return false }
+func (i *presignedInfo) ModTime() time.Time { return i.modTime }
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// presignProvider signs requests for paths under url.
+type presignProvider struct {
+	*mockProvider
+	url string
+}
+
+func (p *presignProvider) PresignGet(ctx context.Context, path string, ttl time.Duration) (provider.PresignedRequest, error) {
+	return provider.PresignedRequest{Method: http.MethodGet, URL: p.url + "/" + path, Expires: time.Now().Add(ttl)}, nil
+}
+
+func (p *presignProvider) PresignPut(ctx context.Context, path string, info provider.FileInfo, ttl time.Duration) (provider.PresignedRequest, error) {
+	return provider.PresignedRequest{Method: http.MethodPut, URL: p.url + "/" + path, Expires: time.Now().Add(ttl)}, nil
+}
+
+func TestPresignTransfer(t *testing.T) {
+	src := &presignProvider{mockProvider: newMockProvider(), url: "https://src.test"}
+	dst := &presignProvider{mockProvider: newMockProvider(), url: "https://dst.test"}
+	mtime := time.Unix(1700000000, 0)
+	job := TransferJob{SourcePath: "/data/a.txt", DestinationPath: "a.txt", FileInfo: mockFileInfo{name: "a.txt", size: 3, modTime: mtime}}
+
+	tr, err := PresignTransfer(context.Background(), job, src, dst, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Path != "a.txt" || tr.Size != 3 || !tr.ModTime.Equal(mtime) {
+		t.Errorf("Expected a.txt of 3 bytes, got %+v", tr)
+	}
+	if tr.Get == nil || tr.Get.URL != "https://src.test//data/a.txt" || tr.Put == nil || tr.Put.URL != "https://dst.test/a.txt" {
+		t.Errorf("Expected a presigned GET and PUT, got %+v %+v", tr.Get, tr.Put)
+	}
+
+	// Only the side that can presign gets a request
+	tr, err = PresignTransfer(context.Background(), job, src, nil, time.Hour)
+	if err != nil || tr.Get == nil || tr.Put != nil {
+		t.Errorf("Expected only a GET, got %+v, %v", tr, err)
+	}
+	if _, err := PresignTransfer(context.Background(), job, newMockProvider(), nil, time.Hour); err == nil {
+		t.Error("Expected a transfer with nothing to presign to fail")
+	}
+}
+
+func TestRunPresigned(t *testing.T) {
+	stored := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			io.WriteString(w, "remote content")
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			stored[r.URL.Path] = string(b)
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	local := provider.NewLocalProvider("").WithRoot(root)
+	buf := make([]byte, 4)
+	mtime := time.Unix(1700000000, 0)
+
+	// Download into the worker's directory
+	down := PresignedTransfer{
+		Path: "sub/down.txt", Size: 14, ModTime: mtime,
+		Get: &provider.PresignedRequest{Method: http.MethodGet, URL: srv.URL + "/down"},
+	}
+	n, err := RunPresigned(context.Background(), srv.Client(), down, local, root, buf)
+	if err != nil || n != 14 {
+		t.Fatalf("Expected 14 bytes downloaded, got %d, %v", n, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "sub", "down.txt")); string(b) != "remote content" {
+		t.Errorf("Expected the downloaded content, got %q", b)
+	}
+
+	// Upload from the worker's directory
+	up := PresignedTransfer{
+		Path: "sub/down.txt", Size: 14,
+		Put: &provider.PresignedRequest{Method: http.MethodPut, URL: srv.URL + "/up"},
+	}
+	if n, err := RunPresigned(context.Background(), srv.Client(), up, local, root, buf); err != nil || n != 14 {
+		t.Fatalf("Expected 14 bytes uploaded, got %d, %v", n, err)
+	}
+	if stored["/up"] != "remote content" {
+		t.Errorf("Expected the local content to be uploaded, got %q", stored["/up"])
+	}
+
+	// A short download is not mistaken for a complete file
+	down.Size = 20
+	if _, err := RunPresigned(context.Background(), srv.Client(), down, local, root, buf); err == nil {
+		t.Error("Expected a short download to fail")
+	}
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// compactFileInfo packs the metadata of a queued file into a single small
+// allocation. Providers typically return richer structures (the platform
+// stat buffer, nested wrappers) that are wasteful to keep alive for tens of
+// millions of queued jobs.
+type compactFileInfo struct {
+	name    string
+	size    int64
+	modTime int64 // UnixNano
+	atime   int64 // UnixNano, 0 if not reported
+	btime   int64 // UnixNano, 0 if not reported
+	mode    os.FileMode
+	uid     uint32
+	gid     uint32
+	isDir   bool
+}
+
+func (c *compactFileInfo) Name() string       { return c.name }
+func (c *compactFileInfo) Size() int64        { return c.size }
+func (c *compactFileInfo) IsDir() bool        { return c.isDir }
+func (c *compactFileInfo) ModTime() time.Time { return time.Unix(0, c.modTime) }
+func (c *compactFileInfo) UID() uint32        { return c.uid }
+func (c *compactFileInfo) GID() uint32        { return c.gid }
+func (c *compactFileInfo) Mode() os.FileMode  { return c.mode }
+
+func (c *compactFileInfo) FileTimes() (atime, btime time.Time) {
+	return unixNanoTime(c.atime), unixNanoTime(c.btime)
+}
+
+// unixNanoTime and timeUnixNano convert between times and UnixNano,
+// keeping the zero time zero.
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func timeUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// CompactFileInfo returns a compact copy of info for holding in a queue.
+// Infos carrying metadata the compact form cannot represent, such as
+// symbolic link targets, Windows attributes or an archived storage class,
+// are returned unchanged.
+func CompactFileInfo(info provider.FileInfo) provider.FileInfo {
+	switch info.(type) {
+	case nil, *compactFileInfo, *plainCompactFileInfo, provider.SymlinkFileInfo, provider.WindowsFileInfo:
+		return info
+	}
+	if provider.Archived(info.Name(), info) != nil {
+		return info
+	}
+
+	c := &compactFileInfo{
+		name:    info.Name(),
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+		isDir:   info.IsDir(),
+	}
+	atime, btime := provider.FileTimes(info)
+	c.atime, c.btime = timeUnixNano(atime), timeUnixNano(btime)
+	if unixInfo, ok := info.(provider.UnixFileInfo); ok {
+		c.mode = unixInfo.Mode()
+		c.uid = unixInfo.UID()
+		c.gid = unixInfo.GID()
+		return c
+	}
+	// Preserve the distinction between "no ownership data" and uid/gid 0.
+	return &plainCompactFileInfo{c}
+}
+
+// plainCompactFileInfo hides the Unix accessors of a compactFileInfo built
+// from a FileInfo that had no ownership data.
+type plainCompactFileInfo struct{ c *compactFileInfo }
+
+func (p *plainCompactFileInfo) Name() string       { return p.c.Name() }
+func (p *plainCompactFileInfo) Size() int64        { return p.c.Size() }
+func (p *plainCompactFileInfo) IsDir() bool        { return p.c.IsDir() }
+func (p *plainCompactFileInfo) ModTime() time.Time { return p.c.ModTime() }
+
+func (p *plainCompactFileInfo) FileTimes() (atime, btime time.Time) { return p.c.FileTimes() }
+
+// Approximate sizes used to account for queued jobs.
+const (
+	jobOverhead     = int64(unsafe.Sizeof(TransferJob{}))
+	compactInfoSize = int64(unsafe.Sizeof(compactFileInfo{}))
+	// fullInfoSize is a conservative guess for an uncompacted provider
+	// FileInfo, which may retain a platform stat buffer.
+	fullInfoSize = 256
+	// versionSize is a guess for one entry of TransferJob.Versions.
+	versionSize = int64(unsafe.Sizeof(provider.ObjectVersion{})) + fullInfoSize
+)
+
+// JobFootprint estimates the memory retained by a queued job.
+func JobFootprint(job TransferJob) int64 {
+	size := jobOverhead + int64(len(job.ID)+len(job.SourcePath)+len(job.DestinationPath))
+	switch job.FileInfo.(type) {
+	case nil:
+	case *compactFileInfo, *plainCompactFileInfo:
+		size += compactInfoSize + int64(len(job.FileInfo.Name()))
+	default:
+		size += fullInfoSize
+	}
+	size += int64(len(job.Versions)) * versionSize
+	return size
+}
+
+// QueueBudget limits the memory held by jobs waiting in a JobChannel. The
+// producer reserves each job before queueing it and the consumer releases
+// it once dequeued. Under pressure, jobs are queued without their FileInfo,
+// which workers restore with EnsureFileInfo; if even that does not fit, the
+// producer waits for workers to drain the queue.
+type QueueBudget struct {
+	limit int64
+
+	mu       sync.Mutex
+	used     int64
+	peak     int64
+	stripped int64
+	changed  chan struct{}
+}
+
+// NewQueueBudget creates a budget of limit bytes. A limit of zero or less
+// only compacts and measures, without ever blocking or stripping.
+func NewQueueBudget(limit int64) *QueueBudget {
+	return &QueueBudget{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// Reserve accounts for job before it is queued and returns the job to queue,
+// which may carry a compacted FileInfo or none at all. It blocks while the
+// budget is exhausted, unless the queue is empty, and returns early if ctx is
+// cancelled.
+func (b *QueueBudget) Reserve(ctx context.Context, job TransferJob) (TransferJob, error) {
+	job.FileInfo = CompactFileInfo(job.FileInfo)
+	strippable := isCompact(job.FileInfo)
+
+	for {
+		b.mu.Lock()
+		cost := JobFootprint(job)
+		if b.limit > 0 && b.used+cost > b.limit && strippable {
+			// Drop the metadata first; it can be fetched again later.
+			job.FileInfo = nil
+			strippable = false
+			b.stripped++
+			cost = JobFootprint(job)
+		}
+		if b.limit <= 0 || b.used+cost <= b.limit || b.used == 0 {
+			b.used += cost
+			if b.used > b.peak {
+				b.peak = b.used
+			}
+			b.mu.Unlock()
+			return job, nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release returns the memory accounted for job to the budget.
+func (b *QueueBudget) Release(job TransferJob) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= JobFootprint(job)
+	if b.used < 0 {
+		b.used = 0
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Used returns the bytes currently accounted to queued jobs.
+func (b *QueueBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Peak returns the largest number of bytes accounted at once.
+func (b *QueueBudget) Peak() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
+
+// Stripped returns how many jobs were queued without their FileInfo.
+func (b *QueueBudget) Stripped() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stripped
+}
+
+// isCompact reports whether info is a compact representation, i.e. one that
+// a fresh Stat can fully reproduce.
+func isCompact(info provider.FileInfo) bool {
+	switch info.(type) {
+	case *compactFileInfo, *plainCompactFileInfo:
+		return true
+	}
+	return false
+}
+
+// EnsureFileInfo fills in job.FileInfo by stating the source if the job was
+// queued without it.
+func EnsureFileInfo(ctx context.Context, src provider.Provider, job TransferJob) (TransferJob, error) {
+	if job.FileInfo != nil {
+		return job, nil
+	}
+	info, err := src.Stat(ctx, job.SourcePath)
+	if err != nil {
+		return job, fmt.Errorf("failed to stat source %s: %w", job.SourcePath, err)
+	}
+	job.FileInfo = info
+	return job, nil
+}
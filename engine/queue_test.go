@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestCompactFileInfo(t *testing.T) {
+	modTime := time.Unix(1700000000, 123)
+	base := mockFileInfo{name: "a.txt", size: 42, modTime: modTime}
+
+	t.Run("unix", func(t *testing.T) {
+		info := CompactFileInfo(provider.NewUnixFileInfo(base, 1000, 100, 0640))
+		unixInfo, ok := info.(provider.UnixFileInfo)
+		if !ok {
+			t.Fatal("Expected compact info to keep Unix metadata")
+		}
+		if unixInfo.Name() != "a.txt" || unixInfo.Size() != 42 || !unixInfo.ModTime().Equal(modTime) {
+			t.Errorf("Unexpected basic fields: %s %d %v", unixInfo.Name(), unixInfo.Size(), unixInfo.ModTime())
+		}
+		if unixInfo.UID() != 1000 || unixInfo.GID() != 100 || unixInfo.Mode() != 0640 {
+			t.Errorf("Unexpected ownership: %d %d %o", unixInfo.UID(), unixInfo.GID(), unixInfo.Mode())
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		info := CompactFileInfo(base)
+		if _, ok := info.(provider.UnixFileInfo); ok {
+			t.Error("Expected compact info of a plain FileInfo not to report ownership")
+		}
+		if CompactFileInfo(info) != info {
+			t.Error("Expected compacting twice to be a no-op")
+		}
+	})
+
+	t.Run("symlink kept", func(t *testing.T) {
+		link := &testSymlinkInfo{FileInfo: base, target: "b.txt"}
+		if CompactFileInfo(link) != provider.FileInfo(link) {
+			t.Error("Expected symlink info to be kept as is")
+		}
+	})
+
+	t.Run("archived kept", func(t *testing.T) {
+		cold := archivedInfo{mockFileInfo: base, archived: true}
+		if CompactFileInfo(cold) != provider.FileInfo(cold) {
+			t.Error("Expected archived info to be kept as is")
+		}
+		if _, ok := CompactFileInfo(archivedInfo{mockFileInfo: base}).(*plainCompactFileInfo); !ok {
+			t.Error("Expected readable info to be compacted")
+		}
+	})
+}
+
+type testSymlinkInfo struct {
+	provider.FileInfo
+	target string
+}
+
+func (s *testSymlinkInfo) LinkTarget() string { return s.target }
+
+func TestQueueBudget(t *testing.T) {
+	job := func(name string) TransferJob {
+		return TransferJob{
+			ID:              "/src/" + name,
+			SourcePath:      "/src/" + name,
+			DestinationPath: "/dst/" + name,
+			FileInfo:        mockFileInfo{name: name, size: 1},
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("unlimited", func(t *testing.T) {
+		b := NewQueueBudget(0)
+		for i := 0; i < 100; i++ {
+			queued, err := b.Reserve(ctx, job("f"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if queued.FileInfo == nil {
+				t.Fatal("Expected an unlimited budget never to strip metadata")
+			}
+		}
+		if b.Stripped() != 0 || b.Used() == 0 {
+			t.Errorf("Unexpected accounting: used %d, stripped %d", b.Used(), b.Stripped())
+		}
+	})
+
+	t.Run("strips then blocks", func(t *testing.T) {
+		full := JobFootprint(TransferJob{
+			ID: "/src/f", SourcePath: "/src/f", DestinationPath: "/dst/f",
+			FileInfo: CompactFileInfo(mockFileInfo{name: "f"}),
+		})
+		bare := JobFootprint(TransferJob{ID: "/src/f", SourcePath: "/src/f", DestinationPath: "/dst/f"})
+		b := NewQueueBudget(full + bare)
+
+		first, _ := b.Reserve(ctx, job("f"))
+		if first.FileInfo == nil {
+			t.Fatal("Expected the first job to keep its metadata")
+		}
+		second, _ := b.Reserve(ctx, job("f"))
+		if second.FileInfo != nil {
+			t.Fatal("Expected the second job to be stripped")
+		}
+		if b.Stripped() != 1 {
+			t.Errorf("Expected 1 stripped job, got %d", b.Stripped())
+		}
+
+		reserved := make(chan struct{})
+		go func() {
+			b.Reserve(ctx, job("f"))
+			close(reserved)
+		}()
+		select {
+		case <-reserved:
+			t.Fatal("Expected Reserve to block on a full budget")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		b.Release(first)
+		select {
+		case <-reserved:
+		case <-time.After(time.Second):
+			t.Fatal("Expected Release to unblock Reserve")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		b := NewQueueBudget(1)
+		b.Reserve(ctx, job("f"))
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := b.Reserve(cctx, job("f")); err == nil {
+			t.Error("Expected an error from a cancelled Reserve")
+		}
+	})
+}
+
+func TestEnsureFileInfo(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/src/a"] = mockFileInfo{name: "a", size: 7}
+
+	job, err := EnsureFileInfo(context.Background(), mp, TransferJob{SourcePath: "/src/a"})
+	if err != nil {
+		t.Fatalf("EnsureFileInfo failed: %v", err)
+	}
+	if job.FileInfo == nil || job.FileInfo.Size() != 7 {
+		t.Errorf("Expected re-read FileInfo of size 7, got %v", job.FileInfo)
+	}
+
+	if _, err := EnsureFileInfo(context.Background(), mp, TransferJob{SourcePath: "/src/missing"}); err == nil {
+		t.Error("Expected an error for a missing source")
+	}
+}
+
+func TestQueueBudget_KeepsFileTimes(t *testing.T) {
+	dir := t.TempDir()
+	atime := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "f"), atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	info, err := provider.NewLocalProvider(dir).Stat(ctx, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantA, wantB := provider.FileTimes(info)
+	if !wantA.Equal(atime) {
+		t.Skipf("the platform does not report access times: got %v", wantA)
+	}
+
+	queued, err := NewQueueBudget(0).Reserve(ctx, TransferJob{SourcePath: "f", DestinationPath: "f", FileInfo: info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := queued.FileInfo.(*compactFileInfo); !ok {
+		t.Fatalf("Expected the queued info compacted, got %T", queued.FileInfo)
+	}
+	gotA, gotB := provider.FileTimes(queued.FileInfo)
+	if !gotA.Equal(wantA) || !gotB.Equal(wantB) {
+		t.Errorf("Expected access and birth times %v and %v kept, got %v and %v", wantA, wantB, gotA, gotB)
+	}
+	if !queued.FileInfo.ModTime().Equal(mtime) {
+		t.Errorf("Expected modification time %v, got %v", mtime, queued.FileInfo.ModTime())
+	}
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// StateReplicaDir is the directory, relative to the destination root, that
+// replicated state is written to. Plans and mirrors leave it alone.
+const StateReplicaDir = ".gofast-state"
+
+// StateReplicaPath returns where the state database is replicated under a
+// destination root.
+func StateReplicaPath(root string) string {
+	return filepath.Join(root, StateReplicaDir, "state.db")
+}
+
+// Snapshotter writes a consistent copy of the state, such as
+// store.BoltStore.
+type Snapshotter interface {
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// StateReplicator periodically copies the state database to the
+// destination, so that if the migration host is lost another host can pull
+// the state from there and resume.
+type StateReplicator struct {
+	State    Snapshotter
+	Dst      provider.Provider
+	Path     string
+	Interval time.Duration
+}
+
+// Replicate writes one snapshot of the state to the destination.
+func (r *StateReplicator) Replicate(ctx context.Context) error {
+	w, err := r.Dst.OpenWrite(ctx, r.Path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open state replica: %w", err)
+	}
+	if _, err := r.State.WriteTo(w); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write state replica: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write state replica: %w", err)
+	}
+	return nil
+}
+
+// Run replicates the state every Interval until ctx is done. Failures are
+// logged and retried at the next tick.
+func (r *StateReplicator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Replicate(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("State replication failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+type snapshotFunc func(w io.Writer) (int64, error)
+
+func (f snapshotFunc) WriteTo(w io.Writer) (int64, error) { return f(w) }
+
+func TestStateReplicator(t *testing.T) {
+	dir := t.TempDir()
+	var version byte
+	state := snapshotFunc(func(w io.Writer) (int64, error) {
+		n, err := w.Write(bytes.Repeat([]byte{version}, 16))
+		return int64(n), err
+	})
+
+	r := &StateReplicator{
+		State:    state,
+		Dst:      provider.NewLocalProvider(dir),
+		Path:     StateReplicaPath(""),
+		Interval: 5 * time.Millisecond,
+	}
+	replica := filepath.Join(dir, StateReplicaDir, "state.db")
+
+	version = 1
+	if err := r.Replicate(context.Background()); err != nil {
+		t.Fatalf("Replicate failed: %v", err)
+	}
+	if got, err := os.ReadFile(replica); err != nil || !bytes.Equal(got, bytes.Repeat([]byte{1}, 16)) {
+		t.Fatalf("unexpected replica %v (%v)", got, err)
+	}
+
+	version = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if got, _ := os.ReadFile(replica); len(got) > 0 && got[0] == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected Run to replicate the state again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// DefaultRestorePoll is how often parked jobs are checked when
+// RestoreQueue.Interval is unset. Even expedited restores take minutes.
+const DefaultRestorePoll = 5 * time.Minute
+
+// RestoreQueue holds back jobs whose source is archived, such as objects in
+// S3 Glacier, until a restore of the source has completed, then hands them
+// back to be transferred.
+type RestoreQueue struct {
+	Src     provider.Provider
+	Tracker *JobTracker
+	// Request makes Park ask the source to restore archived files. Without
+	// it only files whose restore is already in progress are parked.
+	Request  bool
+	Options  provider.RestoreOptions
+	Interval time.Duration
+
+	mu     sync.Mutex
+	parked []TransferJob
+}
+
+// Park checks whether the source of job is archived. If it is being
+// restored, or Request is set and a restore could be requested, the job is
+// marked WaitingRestore and held for Release, and Park returns true. An
+// archived source that is not being restored fails with a
+// *provider.ArchivedError.
+func (q *RestoreQueue) Park(ctx context.Context, job TransferJob) (bool, error) {
+	if provider.Archived(job.SourcePath, job.FileInfo) == nil {
+		return false, nil
+	}
+
+	// Listings cannot tell an archived object from one already restored,
+	// nor can a cached Stat
+	info, err := q.Src.Stat(provider.WithoutCache(ctx), job.SourcePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat archived source %s: %w", job.SourcePath, err)
+	}
+	archived := provider.Archived(job.SourcePath, info)
+	if archived == nil {
+		return false, nil
+	}
+	if !archived.Restoring {
+		r, ok := q.Src.(provider.Restorer)
+		if !q.Request || !ok {
+			return false, archived
+		}
+		if err := r.Restore(ctx, job.SourcePath, q.Options); err != nil {
+			return false, fmt.Errorf("failed to request restore of %s: %w", job.SourcePath, err)
+		}
+	}
+
+	if err := q.Tracker.MarkWaitingRestore(job.ID, archived.StorageClass); err != nil {
+		return false, fmt.Errorf("failed to mark job waiting for restore: %w", err)
+	}
+	q.mu.Lock()
+	q.parked = append(q.parked, job)
+	q.mu.Unlock()
+	return true, nil
+}
+
+// Parked returns the number of jobs waiting for a restore.
+func (q *RestoreQueue) Parked() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.parked)
+}
+
+// Release checks the parked jobs every Interval and sends those whose
+// restore has completed to jobs, with their FileInfo re-read, until no job
+// is parked or ctx is done. It closes jobs when it returns. Jobs whose
+// source can no longer be found are marked failed.
+func (q *RestoreQueue) Release(ctx context.Context, jobs JobChannel) {
+	defer close(jobs)
+
+	interval := q.Interval
+	if interval <= 0 {
+		interval = DefaultRestorePoll
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for q.Parked() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.poll(ctx, jobs)
+		}
+	}
+}
+
+// poll sends each parked job whose source has been restored to jobs,
+// keeping the rest parked.
+func (q *RestoreQueue) poll(ctx context.Context, jobs JobChannel) {
+	q.mu.Lock()
+	parked := q.parked
+	q.parked = nil
+	q.mu.Unlock()
+
+	var waiting []TransferJob
+	defer func() {
+		q.mu.Lock()
+		q.parked = append(q.parked, waiting...)
+		q.mu.Unlock()
+	}()
+
+	for i, job := range parked {
+		info, err := q.Src.Stat(provider.WithoutCache(ctx), job.SourcePath)
+		if ctx.Err() != nil {
+			waiting = append(waiting, parked[i:]...)
+			return
+		}
+		if err != nil {
+			q.Tracker.MarkFailed(job.ID, err)
+			continue
+		}
+		if provider.Archived(job.SourcePath, info) != nil {
+			waiting = append(waiting, job)
+			continue
+		}
+
+		job.FileInfo = info
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			waiting = append(waiting, parked[i:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+// archivedInfo is a mockFileInfo with an archive state.
+type archivedInfo struct {
+	mockFileInfo
+	archived, restoring bool
+}
+
+func (a archivedInfo) ArchiveState() (string, bool, bool) { return "GLACIER", a.archived, a.restoring }
+
+// glacierStore holds files in archive storage, restoring them on request.
+type glacierStore struct {
+	*mockProvider
+	mu       sync.Mutex
+	restored map[string]bool
+	restores []string
+}
+
+func (g *glacierStore) Stat(ctx context.Context, path string) (provider.FileInfo, error) {
+	info, err := g.mockProvider.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	restored, requested := g.restored[path]
+	return archivedInfo{mockFileInfo: info.(mockFileInfo), archived: !restored, restoring: requested && !restored}, nil
+}
+
+func (g *glacierStore) Restore(ctx context.Context, path string, opts provider.RestoreOptions) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.restores = append(g.restores, path+":"+opts.Tier)
+	g.restored[path] = false
+	return nil
+}
+
+func (g *glacierStore) finish(path string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.restored[path] = true
+}
+
+func TestRestoreQueue(t *testing.T) {
+	ctx := context.Background()
+	src := &glacierStore{mockProvider: newMockProvider(), restored: make(map[string]bool)}
+	src.files["cold.bin"] = mockFileInfo{name: "cold.bin", size: 5}
+	src.files["warm.bin"] = mockFileInfo{name: "warm.bin", size: 5}
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, DefaultCheckpointConfig)
+
+	cold := TransferJob{ID: "cold", SourcePath: "cold.bin", FileInfo: archivedInfo{mockFileInfo: src.files["cold.bin"], archived: true}}
+	warm := TransferJob{ID: "warm", SourcePath: "warm.bin", FileInfo: src.files["warm.bin"]}
+	for _, job := range []TransferJob{cold, warm} {
+		if err := tracker.InitJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without Request an archived source fails
+	q := &RestoreQueue{Src: src, Tracker: tracker, Options: provider.RestoreOptions{Tier: provider.RestoreBulk}, Interval: time.Millisecond}
+	var archived *provider.ArchivedError
+	if _, err := q.Park(ctx, cold); !errors.As(err, &archived) {
+		t.Fatalf("Expected ArchivedError, got %v", err)
+	}
+
+	q.Request = true
+	if parked, err := q.Park(ctx, warm); parked || err != nil {
+		t.Fatalf("Expected a readable source to pass, got %v, %v", parked, err)
+	}
+	if parked, err := q.Park(ctx, cold); !parked || err != nil {
+		t.Fatalf("Expected the archived source parked, got %v, %v", parked, err)
+	}
+	if len(src.restores) != 1 || src.restores[0] != "cold.bin:Bulk" {
+		t.Errorf("Unexpected restore requests %v", src.restores)
+	}
+	if record := mockStore.Jobs["cold"]; record.State != store.StateWaitingRestore || record.StorageClass != "GLACIER" {
+		t.Errorf("Expected WaitingRestore from GLACIER, got %s %q", record.State, record.StorageClass)
+	}
+
+	// A restore already in progress is not requested again
+	if parked, err := q.Park(ctx, cold); !parked || err != nil || len(src.restores) != 1 {
+		t.Fatalf("Expected a second park without a request, got %v, %v, %v", parked, err, src.restores)
+	}
+
+	jobs := make(JobChannel)
+	go q.Release(ctx, jobs)
+	time.Sleep(10 * time.Millisecond)
+	if q.Parked() != 2 {
+		t.Fatalf("Expected jobs held until restored, %d parked", q.Parked())
+	}
+	src.finish("cold.bin")
+
+	var released []TransferJob
+	for job := range jobs {
+		released = append(released, job)
+	}
+	if len(released) != 2 || provider.Archived(released[0].SourcePath, released[0].FileInfo) != nil {
+		t.Fatalf("Expected the jobs released with readable info, got %+v", released)
+	}
+	if parked, err := q.Park(ctx, released[0]); parked || err != nil {
+		t.Errorf("Expected a released job to pass, got %v, %v", parked, err)
+	}
+}
+
+func TestRestoreQueue_ReleaseCancelled(t *testing.T) {
+	src := &glacierStore{mockProvider: newMockProvider(), restored: make(map[string]bool)}
+	src.files["cold.bin"] = mockFileInfo{name: "cold.bin"}
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, DefaultCheckpointConfig)
+	job := TransferJob{ID: "cold", SourcePath: "cold.bin", FileInfo: archivedInfo{archived: true}}
+	tracker.InitJob(job)
+
+	q := &RestoreQueue{Src: src, Tracker: tracker, Request: true, Interval: time.Millisecond}
+	if _, err := q.Park(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	jobs := make(JobChannel)
+	q.Release(ctx, jobs)
+	if _, ok := <-jobs; ok {
+		t.Error("Expected the channel closed without jobs")
+	}
+	if q.Parked() != 1 {
+		t.Errorf("Expected the job still parked, got %d", q.Parked())
+	}
+}
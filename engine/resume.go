@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+// FingerprintHeadSize is how much of the start of a file is hashed while it
+// is transferred, and re-read from the source to validate a resume.
+const FingerprintHeadSize = 64 * 1024
+
+// ResumeDecision says where a job should continue from.
+type ResumeDecision struct {
+	// Offset is the number of bytes already at the destination that can be
+	// kept. It is zero for new jobs and for restarted ones.
+	Offset int64
+	// Restarted is set when a checkpoint existed but had to be discarded;
+	// Reason says why.
+	Restarted bool
+	Reason    string
+
+	// Upload is the checkpoint of the destination's unfinished multipart
+	// upload, to be continued. StaleUpload names an upload that cannot be
+	// continued and should be aborted.
+	Upload      *provider.UploadCheckpoint
+	StaleUpload string
+}
+
+// WithoutUpload returns d for a copy that has to rewrite the destination
+// from the start, marking its upload as stale.
+func (d ResumeDecision) WithoutUpload() ResumeDecision {
+	d.Offset = 0
+	if d.Upload != nil {
+		d.StaleUpload = d.Upload.UploadID
+		d.Upload = nil
+	}
+	return d
+}
+
+// OpenDestination opens job's destination for writing, continuing the
+// multipart upload in resume if the destination still holds its parts and
+// checkpointing each part it stores, or else continuing a partially written
+// file from the checkpointed offset where the destination can append. It
+// returns the writer and the offset in the file from which to write; stale
+// uploads are aborted first.
+func (jt *JobTracker) OpenDestination(ctx context.Context, job TransferJob, dst provider.Provider, resume ResumeDecision) (io.WriteCloser, int64, error) {
+	if resume.StaleUpload != "" {
+		if rw, ok := dst.(provider.ResumableWriter); ok {
+			// Leftovers are only a cost; uploads expire or are cleaned up
+			_ = rw.AbortUpload(ctx, job.DestinationPath, resume.StaleUpload)
+		}
+	}
+	if resume.Upload == nil && resume.Offset > 0 {
+		return provider.OpenWriteAt(ctx, dst, job.DestinationPath, job.FileInfo, resume.Offset)
+	}
+	return provider.OpenWriteResumable(ctx, dst, job.DestinationPath, job.FileInfo, resume.Upload, func(cp provider.UploadCheckpoint) {
+		_ = jt.RecordUpload(job.ID, cp)
+	})
+}
+
+// ResumeJob initializes job in the store like InitJob, but keeps the
+// progress of an earlier, unfinished attempt if the source is unchanged
+// since its last checkpoint: same size and modification time, and the same
+// content over the head that was hashed while it was transferred. If the
+// source changed, the job restarts from zero and the reason is recorded.
+// A multipart upload recorded for the job is returned to be continued, or
+// to be aborted if the job restarts.
+func (jt *JobTracker) ResumeJob(ctx context.Context, job TransferJob, src provider.Provider) (ResumeDecision, error) {
+	record, err := jt.store.GetJob(job.ID)
+	if errors.Is(err, store.ErrJobNotFound) || (err == nil && (record.State == store.StateCompleted || (record.BytesTransferred == 0 && record.UploadID == ""))) {
+		return ResumeDecision{}, jt.InitJob(job)
+	}
+	if err != nil {
+		return ResumeDecision{}, err
+	}
+
+	upload := uploadCheckpoint(record)
+	reason, err := sourceChanged(ctx, record, job, src)
+	if err != nil {
+		return ResumeDecision{}, err
+	}
+	if reason != "" {
+		restarts := record.Restarts + 1
+		if err := jt.InitJob(job); err != nil {
+			return ResumeDecision{}, err
+		}
+		if record, err = jt.store.GetJob(job.ID); err != nil {
+			return ResumeDecision{}, err
+		}
+		record.Restarts = restarts
+		record.RestartReason = reason
+		decision := ResumeDecision{Restarted: true, Reason: reason, Upload: upload}
+		return decision.WithoutUpload(), jt.store.SaveJob(record)
+	}
+
+	record.State = store.StatePending
+	return ResumeDecision{Offset: record.BytesTransferred, Upload: upload}, jt.store.SaveJob(record)
+}
+
+// sourceChanged compares the source against the fingerprint in record and
+// returns why it no longer matches, or "" if it does.
+func sourceChanged(ctx context.Context, record *store.JobRecord, job TransferJob, src provider.Provider) (string, error) {
+	if job.FileInfo == nil {
+		return "", fmt.Errorf("job %s has no source file info", job.ID)
+	}
+	if record.SourceSize != job.FileInfo.Size() {
+		return fmt.Sprintf("source size changed from %d to %d bytes", record.SourceSize, job.FileInfo.Size()), nil
+	}
+	if !record.SourceModTime.Equal(job.FileInfo.ModTime()) {
+		return "source modification time changed", nil
+	}
+	if record.HeadHash == "" {
+		return "", nil
+	}
+
+	rc, err := provider.OpenReadRange(ctx, src, job.SourcePath, 0, record.HeadSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source head: %w", err)
+	}
+	defer rc.Close()
+	h, _ := NewHash64(AlgorithmXXH3)
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", fmt.Errorf("failed to read source head: %w", err)
+	}
+	if FormatChecksum(h.Sum64()) != record.HeadHash {
+		return "source content changed", nil
+	}
+	return "", nil
+}
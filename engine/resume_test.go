@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+func TestJobTracker_ResumeJob(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.bin")
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := provider.NewLocalProvider("")
+
+	jobFor := func() TransferJob {
+		info, err := src.Stat(ctx, srcPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return TransferJob{ID: srcPath, SourcePath: srcPath, DestinationPath: "dst.bin", FileInfo: info}
+	}
+
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, CheckpointConfig{BytesInterval: 1000})
+
+	// A new job starts from zero
+	decision, err := tracker.ResumeJob(ctx, jobFor(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Offset != 0 || decision.Restarted {
+		t.Fatalf("Expected a fresh start, got %+v", decision)
+	}
+
+	// Transfer part of the file, then fail
+	tw := tracker.NewTrackedWriter(io.Discard, srcPath, 0)
+	tw.Write(content[:5000])
+	tracker.MarkFailed(srcPath, io.ErrUnexpectedEOF)
+
+	decision, err = tracker.ResumeJob(ctx, jobFor(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Offset != 5000 || decision.Restarted {
+		t.Fatalf("Expected to resume at 5000, got %+v", decision)
+	}
+
+	// Change the content within the hashed head but keep size and mtime
+	info, _ := os.Stat(srcPath)
+	changed := append([]byte("X"), content[1:]...)
+	if err := os.WriteFile(srcPath, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(srcPath, info.ModTime(), info.ModTime())
+
+	decision, err = tracker.ResumeJob(ctx, jobFor(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Restarted || decision.Offset != 0 || decision.Reason != "source content changed" {
+		t.Fatalf("Expected a restart for changed content, got %+v", decision)
+	}
+	record, _ := mockStore.GetJob(srcPath)
+	if record.Restarts != 1 || record.BytesTransferred != 0 || record.RestartReason == "" {
+		t.Errorf("Expected the restart to be recorded, got %+v", record)
+	}
+
+	// A size change is detected without reading the source
+	tw = tracker.NewTrackedWriter(io.Discard, srcPath, 0)
+	tw.Write(changed[:2000])
+	if err := os.WriteFile(srcPath, changed[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	decision, err = tracker.ResumeJob(ctx, jobFor(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Restarted {
+		t.Fatalf("Expected a restart for a changed size, got %+v", decision)
+	}
+	if record, _ := mockStore.GetJob(srcPath); record.Restarts != 2 {
+		t.Errorf("Expected 2 restarts, got %d", record.Restarts)
+	}
+}
+
+func TestJobTracker_ResumeUpload(t *testing.T) {
+	ctx := context.Background()
+	srcPath := filepath.Join(t.TempDir(), "src.bin")
+	if err := os.WriteFile(srcPath, make([]byte, 300), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := provider.NewLocalProvider("")
+	info, err := src.Stat(ctx, srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := TransferJob{ID: srcPath, SourcePath: srcPath, DestinationPath: "dst.bin", FileInfo: info}
+
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, DefaultCheckpointConfig)
+	if _, err := tracker.ResumeJob(ctx, job, src); err != nil {
+		t.Fatal(err)
+	}
+
+	// An upload whose parts were stored before any byte checkpoint
+	cp := provider.UploadCheckpoint{UploadID: "u1", PartSize: 100, Parts: []provider.UploadedPart{{Number: 1, ETag: "e1"}}}
+	if err := tracker.RecordUpload(job.ID, cp); err != nil {
+		t.Fatal(err)
+	}
+	decision, err := tracker.ResumeJob(ctx, job, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Upload == nil || decision.Upload.UploadID != "u1" || decision.Upload.Offset() != 100 {
+		t.Fatalf("Expected the upload to be resumed, got %+v", decision)
+	}
+	if d := decision.WithoutUpload(); d.Upload != nil || d.StaleUpload != "u1" {
+		t.Errorf("Expected WithoutUpload to mark u1 stale, got %+v", d)
+	}
+
+	// A changed source makes the upload stale
+	if err := os.WriteFile(srcPath, make([]byte, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job.FileInfo, _ = src.Stat(ctx, srcPath)
+	decision, err = tracker.ResumeJob(ctx, job, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Restarted || decision.Upload != nil || decision.StaleUpload != "u1" {
+		t.Fatalf("Expected a restart with a stale upload, got %+v", decision)
+	}
+	if record, _ := mockStore.GetJob(job.ID); record.UploadID != "" {
+		t.Errorf("Expected the restart to forget the upload, got %q", record.UploadID)
+	}
+
+	tracker.RecordUpload(job.ID, cp)
+	tracker.MarkCompleted(job.ID)
+	if record, _ := mockStore.GetJob(job.ID); record.UploadID != "" || record.UploadParts != nil {
+		t.Errorf("Expected completion to clear the upload, got %+v", record)
+	}
+}
+
+func TestJobTracker_ResumeLocalFile(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	srcPath := filepath.Join(srcDir, "src.bin")
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := provider.NewLocalProvider("")
+	dst := provider.NewLocalProvider(dstDir)
+	info, err := src.Stat(ctx, srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := TransferJob{ID: srcPath, SourcePath: srcPath, DestinationPath: "dst.bin", FileInfo: info}
+
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, CheckpointConfig{BytesInterval: 1000})
+	decision, err := tracker.ResumeJob(ctx, job, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write 6000 bytes, of which the last checkpoint covers 5000, then fail
+	w, offset, err := tracker.OpenDestination(ctx, job, dst, decision)
+	if err != nil || offset != 0 {
+		t.Fatalf("Expected a fresh destination, got offset %d (%v)", offset, err)
+	}
+	tw := tracker.NewTrackedWriter(w, job.ID, 0)
+	tw.Write(content[:5000])
+	w.Write([]byte("uncheckpointed"))
+	w.Close()
+	tracker.MarkFailed(job.ID, io.ErrUnexpectedEOF)
+
+	decision, err = tracker.ResumeJob(ctx, job, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, offset, err = tracker.OpenDestination(ctx, job, dst, decision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 5000 {
+		t.Fatalf("Expected to continue the file at 5000, got %d", offset)
+	}
+	rc, err := provider.OpenReadRange(ctx, src, srcPath, offset, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := io.Copy(tracker.NewTrackedWriter(w, job.ID, offset), rc); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dstDir, "dst.bin")); !bytes.Equal(got, content) {
+		t.Errorf("Expected the continued file to match the source, got %d bytes", len(got))
+	}
+
+	// A copy that has to see the whole stream rewrites the file
+	if d := decision.WithoutUpload(); d.Offset != 0 {
+		t.Errorf("Expected WithoutUpload to start over, got offset %d", d.Offset)
+	}
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// SchedulePolicy decides the order queued jobs are handed to workers in.
+type SchedulePolicy string
+
+const (
+	// ScheduleFIFO dispatches jobs in the order the walk found them.
+	ScheduleFIFO SchedulePolicy = "fifo"
+	// ScheduleLargestFirst starts the biggest files first, so the long
+	// tail of a run is not one huge file started last.
+	ScheduleLargestFirst SchedulePolicy = "largest-first"
+	// ScheduleSmallestFirst completes as many files as early as possible.
+	ScheduleSmallestFirst SchedulePolicy = "smallest-first"
+	// ScheduleLocality dispatches the files of one directory together, in
+	// path order, which suits backends that cache per directory.
+	ScheduleLocality SchedulePolicy = "locality"
+)
+
+// DefaultScheduleWindow is the number of queued jobs a policy other than
+// FIFO chooses from.
+const DefaultScheduleWindow = 100000
+
+// ParseSchedulePolicy validates a scheduling policy name.
+func ParseSchedulePolicy(s string) (SchedulePolicy, error) {
+	switch p := SchedulePolicy(s); p {
+	case ScheduleFIFO, ScheduleLargestFirst, ScheduleSmallestFirst, ScheduleLocality:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown schedule %q (want fifo, largest-first, smallest-first or locality)", s)
+}
+
+// Schedule reorders the jobs sent on in by policy and returns the channel
+// workers should take them from. Up to window jobs are held back to choose
+// from; beyond that the producer waits, so the order is exact for runs of
+// up to window files and best effort for larger ones. The returned channel
+// is closed once in is closed and drained, or ctx is done. FIFO returns in
+// itself. Jobs queued without their FileInfo count as empty files.
+func Schedule(ctx context.Context, in JobChannel, policy SchedulePolicy, window int) JobChannel {
+	if policy == ScheduleFIFO || policy == "" {
+		return in
+	}
+	if window <= 0 {
+		window = DefaultScheduleWindow
+	}
+	out := make(JobChannel)
+	go func() {
+		defer close(out)
+		q := &jobHeap{compare: scheduleOrder(policy)}
+		var seq uint64
+		for in != nil || q.Len() > 0 {
+			recv := in
+			if q.Len() >= window {
+				recv = nil
+			}
+			// Take in every job already waiting before choosing, so the
+			// choice is made from as many as possible
+			if recv != nil {
+				select {
+				case job, ok := <-recv:
+					if !ok {
+						in = nil
+					} else {
+						heap.Push(q, scheduledJob{job: job, seq: seq})
+						seq++
+					}
+					continue
+				default:
+				}
+			}
+			var send JobChannel
+			var next TransferJob
+			if q.Len() > 0 {
+				send, next = out, q.items[0].job
+			}
+			select {
+			case job, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				heap.Push(q, scheduledJob{job: job, seq: seq})
+				seq++
+			case send <- next:
+				heap.Pop(q)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// scheduledJob is a queued job with the order it arrived in, which breaks
+// ties so equal jobs stay in walk order.
+type scheduledJob struct {
+	job TransferJob
+	seq uint64
+}
+
+// scheduleOrder returns how policy orders two jobs.
+func scheduleOrder(policy SchedulePolicy) func(a, b TransferJob) int {
+	switch policy {
+	case ScheduleLargestFirst:
+		return func(a, b TransferJob) int { return cmp.Compare(jobSize(b), jobSize(a)) }
+	case ScheduleSmallestFirst:
+		return func(a, b TransferJob) int { return cmp.Compare(jobSize(a), jobSize(b)) }
+	case ScheduleLocality:
+		return func(a, b TransferJob) int {
+			if da, db := filepath.Dir(a.SourcePath), filepath.Dir(b.SourcePath); da != db {
+				return cmp.Compare(da, db)
+			}
+			return cmp.Compare(a.SourcePath, b.SourcePath)
+		}
+	}
+	return func(a, b TransferJob) int { return 0 }
+}
+
+func jobSize(job TransferJob) int64 {
+	if job.FileInfo == nil {
+		return 0
+	}
+	return job.FileInfo.Size()
+}
+
+// jobHeap is a heap of queued jobs ordered by compare, then arrival.
+type jobHeap struct {
+	items   []scheduledJob
+	compare func(a, b TransferJob) int
+}
+
+func (h *jobHeap) Len() int { return len(h.items) }
+
+func (h *jobHeap) Less(i, j int) bool {
+	if c := h.compare(h.items[i].job, h.items[j].job); c != 0 {
+		return c < 0
+	}
+	return h.items[i].seq < h.items[j].seq
+}
+
+func (h *jobHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *jobHeap) Push(x any) { h.items = append(h.items, x.(scheduledJob)) }
+
+func (h *jobHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items[n-1] = scheduledJob{}
+	h.items = h.items[:n-1]
+	return item
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseSchedulePolicy(t *testing.T) {
+	for _, name := range []string{"fifo", "largest-first", "smallest-first", "locality"} {
+		if p, err := ParseSchedulePolicy(name); err != nil || string(p) != name {
+			t.Errorf("ParseSchedulePolicy(%q) = %q, %v", name, p, err)
+		}
+	}
+	if _, err := ParseSchedulePolicy("random"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	jobs := []TransferJob{
+		{SourcePath: "b/x", FileInfo: mockFileInfo{name: "x", size: 30}},
+		{SourcePath: "a/z", FileInfo: mockFileInfo{name: "z", size: 10}},
+		{SourcePath: "b/w"},
+		{SourcePath: "a/y", FileInfo: mockFileInfo{name: "y", size: 50}},
+		{SourcePath: "c/v", FileInfo: mockFileInfo{name: "v", size: 10}},
+	}
+	tests := []struct {
+		policy SchedulePolicy
+		want   []string
+	}{
+		{ScheduleFIFO, []string{"b/x", "a/z", "b/w", "a/y", "c/v"}},
+		{ScheduleLargestFirst, []string{"a/y", "b/x", "a/z", "c/v", "b/w"}},
+		{ScheduleSmallestFirst, []string{"b/w", "a/z", "c/v", "b/x", "a/y"}},
+		{ScheduleLocality, []string{"a/y", "a/z", "b/w", "b/x", "c/v"}},
+	}
+	for _, tt := range tests {
+		in := make(JobChannel, len(jobs))
+		for _, job := range jobs {
+			in <- job
+		}
+		close(in)
+
+		var got []string
+		for job := range Schedule(context.Background(), in, tt.policy, 0) {
+			got = append(got, job.SourcePath)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.policy, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.policy, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSchedule_Window(t *testing.T) {
+	in := make(JobChannel, 4)
+	for _, size := range []int64{1, 2, 3, 4} {
+		in <- TransferJob{SourcePath: "f", FileInfo: mockFileInfo{size: size}}
+	}
+	close(in)
+
+	// With room for two jobs, the largest of the first two goes first
+	out := Schedule(context.Background(), in, ScheduleLargestFirst, 2)
+	if first := <-out; first.FileInfo.Size() != 2 {
+		t.Errorf("expected the larger of the first two jobs, got size %d", first.FileInfo.Size())
+	}
+	n := 1
+	for range out {
+		n++
+	}
+	if n != 4 {
+		t.Errorf("expected all 4 jobs, got %d", n)
+	}
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// RunShare is the portion of a shared host one migration run may use.
+type RunShare struct {
+	// Weight is the run's share of contended transfer slots relative to
+	// the other runs; it defaults to 1.
+	Weight int
+	// MaxWorkers caps the slots the run holds at once, even when others
+	// are idle (0 = no cap).
+	MaxWorkers int
+	// BytesPerSec caps the bandwidth of the streams the run opens through
+	// RunSlots.Throttle, all its workers together (0 = unlimited).
+	BytesPerSec int64
+}
+
+// Scheduler divides a fixed number of transfer slots between concurrent
+// runs in one process, so one huge migration cannot starve a small one.
+// When slots are contended each is granted to the waiting run holding the
+// fewest slots for its weight; idle slots go to whoever asks, up to their
+// MaxWorkers.
+type Scheduler struct {
+	mu    sync.Mutex
+	slots int
+	used  int
+	runs  map[string]*RunSlots
+}
+
+// NewScheduler returns a scheduler with slots transfer slots in total.
+func NewScheduler(slots int) *Scheduler {
+	return &Scheduler{slots: slots, runs: make(map[string]*RunSlots)}
+}
+
+// RunSlots is one run's handle on a Scheduler. Its worker pool acquires a
+// slot around every job; see WorkerPool.SetRunSlots.
+type RunSlots struct {
+	id     string
+	share  RunShare
+	sched  *Scheduler
+	bucket *provider.TokenBucket
+
+	// active and waiters are guarded by sched.mu.
+	active  int
+	waiters []chan struct{}
+}
+
+// Register adds a run to the scheduler. Registering an id twice is an error.
+func (s *Scheduler) Register(id string, share RunShare) (*RunSlots, error) {
+	if share.Weight <= 0 {
+		share.Weight = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.runs[id]; ok {
+		return nil, fmt.Errorf("run %s is already registered", id)
+	}
+	r := &RunSlots{id: id, share: share, sched: s}
+	if share.BytesPerSec > 0 {
+		r.bucket = provider.NewTokenBucket(share.BytesPerSec)
+	}
+	s.runs[id] = r
+	return r, nil
+}
+
+// Active returns the number of slots the run holds.
+func (r *RunSlots) Active() int {
+	r.sched.mu.Lock()
+	defer r.sched.mu.Unlock()
+	return r.active
+}
+
+// Bucket returns the token bucket enforcing the run's bandwidth cap, or nil
+// if the run is unlimited.
+func (r *RunSlots) Bucket() *provider.TokenBucket {
+	return r.bucket
+}
+
+// Throttle wraps p so that the streams the run's jobs open through it are
+// paid for from the run's bucket. Wrap the source the run reads from, or
+// the destination, not both, or bytes are paid for twice. It returns p
+// itself if the run is unlimited.
+func (r *RunSlots) Throttle(p provider.Provider) provider.Provider {
+	return provider.WithThrottle(p, r.bucket)
+}
+
+// Acquire blocks until the run is granted a slot or ctx is done.
+func (r *RunSlots) Acquire(ctx context.Context) error {
+	s := r.sched
+	s.mu.Lock()
+	grant := make(chan struct{})
+	r.waiters = append(r.waiters, grant)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-grant:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-grant:
+		// Granted while giving up; hand the slot back
+		r.active--
+		s.used--
+		s.dispatch()
+	default:
+		for i, w := range r.waiters {
+			if w == grant {
+				r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// Release returns a slot acquired with Acquire.
+func (r *RunSlots) Release() {
+	s := r.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.active--
+	s.used--
+	s.dispatch()
+}
+
+// Close unregisters the run. Its slots must have been released.
+func (r *RunSlots) Close() {
+	s := r.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.runs, r.id)
+	s.dispatch()
+}
+
+// dispatch grants free slots to waiting runs, most underserved first. The
+// caller holds s.mu.
+func (s *Scheduler) dispatch() {
+	for s.used < s.slots {
+		var next *RunSlots
+		for _, r := range s.runs {
+			if len(r.waiters) == 0 || (r.share.MaxWorkers > 0 && r.active >= r.share.MaxWorkers) {
+				continue
+			}
+			// Compare active/weight without dividing; break ties by id so
+			// grants do not depend on map order
+			if next == nil || r.active*next.share.Weight < next.active*r.share.Weight ||
+				(r.active*next.share.Weight == next.active*r.share.Weight && r.id < next.id) {
+				next = r
+			}
+		}
+		if next == nil {
+			return
+		}
+		grant := next.waiters[0]
+		next.waiters = next.waiters[1:]
+		next.active++
+		s.used++
+		close(grant)
+	}
+}
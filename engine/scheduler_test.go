@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// acquireAsync starts n goroutines acquiring slots of r and returns a
+// counter of the ones granted so far.
+func acquireAsync(ctx context.Context, r *RunSlots, n int, wg *sync.WaitGroup) *atomic.Int64 {
+	var granted atomic.Int64
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.Acquire(ctx) == nil {
+				granted.Add(1)
+			}
+		}()
+	}
+	return &granted
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScheduler_WeightedShares(t *testing.T) {
+	s := NewScheduler(3)
+	hold, err := s.Register("hold", RunShare{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Occupy every slot so the contenders queue up behind it
+	for range 3 {
+		if err := hold.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	big, _ := s.Register("big", RunShare{Weight: 2})
+	small, _ := s.Register("small", RunShare{Weight: 1})
+	if _, err := s.Register("big", RunShare{}); err == nil {
+		t.Error("expected registering a run twice to fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	bigGranted := acquireAsync(ctx, big, 10, &wg)
+	smallGranted := acquireAsync(ctx, small, 10, &wg)
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(big.waiters) == 10 && len(small.waiters) == 10
+	})
+
+	hold.Release()
+	hold.Release()
+	hold.Release()
+	hold.Close()
+	waitFor(t, func() bool { return bigGranted.Load()+smallGranted.Load() == 3 })
+	if big.Active() != 2 || small.Active() != 1 {
+		t.Errorf("expected slots split 2:1, got big=%d small=%d", big.Active(), small.Active())
+	}
+
+	cancel()
+	wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(big.waiters)+len(small.waiters) != 0 {
+		t.Error("expected cancelled waiters to be removed")
+	}
+}
+
+func TestScheduler_MaxWorkers(t *testing.T) {
+	s := NewScheduler(10)
+	r, _ := s.Register("capped", RunShare{MaxWorkers: 2})
+
+	for range 2 {
+		if err := r.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Acquire(ctx); err == nil {
+		t.Fatal("expected the third slot to be refused despite free capacity")
+	}
+
+	r.Release()
+	if err := r.Acquire(context.Background()); err != nil {
+		t.Fatalf("expected a released slot to be granted again: %v", err)
+	}
+	if r.Bucket() != nil {
+		t.Error("expected no bandwidth cap")
+	}
+}
+
+func TestWorkerPool_RunSlots(t *testing.T) {
+	s := NewScheduler(2)
+	slots, _ := s.Register("run", RunShare{BytesPerSec: 1 << 20})
+	if slots.Bucket() == nil || slots.Bucket().Rate() != 1<<20 {
+		t.Error("expected a bandwidth cap of 1 MiB/s")
+	}
+
+	var running, peak atomic.Int64
+	var done sync.WaitGroup
+	ch := make(JobChannel, 10)
+	pool := NewWorkerPool(context.Background(), ch, func(ctx context.Context, job TransferJob) error {
+		defer done.Done()
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+	pool.SetRunSlots(slots)
+	pool.SetWorkerCount(6)
+
+	for range 10 {
+		done.Add(1)
+		ch <- TransferJob{SourcePath: "file.txt"}
+	}
+	done.Wait()
+	close(ch)
+	pool.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 concurrent jobs, got %d", peak.Load())
+	}
+}
+
+func TestWorkerPool_RunSlotsBandwidth(t *testing.T) {
+	dir := t.TempDir()
+	s := NewScheduler(6)
+
+	// Each run copies one and a half seconds of its rate, the first second
+	// of which the bucket's burst lets through at once
+	runs := []struct {
+		id   string
+		rate int64
+	}{{"fast", 20000}, {"slow", 10000}}
+	elapsed := make([]time.Duration, len(runs))
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		content := make([]byte, run.rate/2)
+		for j := range 3 {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%s%d", run.id, j)), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		slots, err := s.Register(run.id, RunShare{BytesPerSec: run.rate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := slots.Throttle(provider.NewLocalProvider(dir))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch := make(JobChannel, 3)
+			pool := NewWorkerPool(context.Background(), ch, func(ctx context.Context, job TransferJob) error {
+				rc, err := src.OpenRead(ctx, job.SourcePath)
+				if err != nil {
+					t.Error(err)
+					return err
+				}
+				defer rc.Close()
+				_, err = io.Copy(io.Discard, rc)
+				return err
+			})
+			pool.SetRunSlots(slots)
+			pool.SetWorkerCount(3)
+
+			start := time.Now()
+			for j := range 3 {
+				ch <- TransferJob{SourcePath: fmt.Sprintf("%s%d", run.id, j)}
+			}
+			close(ch)
+			pool.Wait()
+			elapsed[i] = time.Since(start)
+			slots.Close()
+		}()
+	}
+	wg.Wait()
+
+	for i, run := range runs {
+		if elapsed[i] < 400*time.Millisecond {
+			t.Errorf("expected run %s held to %d B/s to take about 500ms, took %v", run.id, run.rate, elapsed[i])
+		}
+		// Sharing even the faster bucket, the runs would take 1.25s
+		if elapsed[i] > time.Second {
+			t.Errorf("expected run %s to keep its own rate, took %v", run.id, elapsed[i])
+		}
+	}
+}
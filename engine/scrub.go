@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// ErrChecksumMismatch is returned when source and destination content differ.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// DefaultScrubQueueSize bounds the number of completed jobs waiting to be
+// scrubbed. Jobs completing while the queue is full are not scrubbed.
+const DefaultScrubQueueSize = 10000
+
+// Scrubber re-verifies completed files against their source while workers
+// would otherwise be idle. Install it on a WorkerPool with
+// pool.SetIdleTask(scrubber.RunOnce, scrubber.Wake()).
+type Scrubber struct {
+	src     provider.Provider
+	dst     provider.Provider
+	buffers *BufferPool
+	hashers *ChecksumPool
+
+	// OnResult, if set, is called after each verification with a nil error
+	// or the reason the destination copy is bad.
+	OnResult func(job TransferJob, err error)
+
+	mu       sync.Mutex
+	pending  []TransferJob
+	maxQueue int
+	wake     chan struct{}
+
+	verified atomic.Int64
+	failed   atomic.Int64
+	dropped  atomic.Int64
+}
+
+// NewScrubber creates a Scrubber comparing files on dst to src. maxQueue
+// bounds the pending jobs; values <= 0 use DefaultScrubQueueSize.
+func NewScrubber(src, dst provider.Provider, buffers *BufferPool, maxQueue int) *Scrubber {
+	if maxQueue <= 0 {
+		maxQueue = DefaultScrubQueueSize
+	}
+	hashers, _ := NewChecksumPoolFor(AlgorithmXXH3)
+	return &Scrubber{
+		src:      src,
+		dst:      dst,
+		buffers:  buffers,
+		hashers:  hashers,
+		maxQueue: maxQueue,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Enqueue schedules a completed job for verification. Symbolic links have
+// no content of their own and are ignored.
+func (s *Scrubber) Enqueue(job TransferJob) {
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return
+	}
+
+	s.mu.Lock()
+	if len(s.pending) >= s.maxQueue {
+		s.mu.Unlock()
+		s.dropped.Add(1)
+		return
+	}
+	s.pending = append(s.pending, job)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wake returns a channel that receives when new jobs are enqueued.
+func (s *Scrubber) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// RunOnce verifies the oldest pending job. It returns false if there was
+// nothing to verify, which makes it usable as a WorkerPool IdleTask.
+func (s *Scrubber) RunOnce(ctx context.Context) bool {
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	job := s.pending[0]
+	s.pending = s.pending[1:]
+	s.mu.Unlock()
+
+	err := s.Verify(ctx, job)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown rather than a verdict on the file.
+		return true
+	}
+	if err != nil {
+		s.failed.Add(1)
+	} else {
+		s.verified.Add(1)
+	}
+	if s.OnResult != nil {
+		s.OnResult(job, err)
+	}
+	return true
+}
+
+// Verify compares the destination copy of job with its source. Digests
+// stored by both providers are used when comparable; otherwise both sides
+// are read and hashed.
+func (s *Scrubber) Verify(ctx context.Context, job TransferJob) error {
+	match, comparable, err := CompareNativeChecksums(ctx, s.src, job.SourcePath, s.dst, job.DestinationPath)
+	if err != nil {
+		return err
+	}
+	if comparable {
+		if !match {
+			return ErrChecksumMismatch
+		}
+		return nil
+	}
+
+	h := s.hashers.Get()
+	defer s.hashers.Put(h)
+	buf := s.buffers.Get()
+	defer s.buffers.Put(buf)
+
+	srcSum, srcSize, err := HashFile(ctx, s.src, job.SourcePath, h, *buf)
+	if err != nil {
+		return fmt.Errorf("failed to hash source: %w", err)
+	}
+	dstSum, dstSize, err := HashFile(ctx, s.dst, job.DestinationPath, h, *buf)
+	if err != nil {
+		return fmt.Errorf("failed to hash destination: %w", err)
+	}
+	if srcSize != dstSize {
+		return fmt.Errorf("%w: size %d != %d", ErrChecksumMismatch, dstSize, srcSize)
+	}
+	if srcSum != dstSum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Pending returns the number of jobs waiting to be verified.
+func (s *Scrubber) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Verified returns the number of files that matched their source.
+func (s *Scrubber) Verified() int64 { return s.verified.Load() }
+
+// Failed returns the number of files that did not match or could not be read.
+func (s *Scrubber) Failed() int64 { return s.failed.Load() }
+
+// Dropped returns the number of jobs not scrubbed because the queue was full.
+func (s *Scrubber) Dropped() int64 { return s.dropped.Load() }
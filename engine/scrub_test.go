@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// streamOnly hides the optional interfaces of a provider, such as
+// Checksummer, so the streaming verification path is used.
+type streamOnly struct {
+	provider.Provider
+}
+
+func TestScrubber_Verify(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "ok.txt"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "ok.txt"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "bad.txt"), []byte("good"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "bad.txt"), []byte("evil"), 0644)
+
+	for _, tt := range []struct {
+		name     string
+		src, dst provider.Provider
+	}{
+		{"native", provider.NewLocalProvider(srcDir), provider.NewLocalProvider(dstDir)},
+		{"streaming", streamOnly{provider.NewLocalProvider(srcDir)}, streamOnly{provider.NewLocalProvider(dstDir)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testScrubberVerify(t, NewScrubber(tt.src, tt.dst, NewBufferPool(1024), 0))
+		})
+	}
+}
+
+func testScrubberVerify(t *testing.T, s *Scrubber) {
+
+	var mu sync.Mutex
+	results := map[string]error{}
+	s.OnResult = func(job TransferJob, err error) {
+		mu.Lock()
+		results[job.SourcePath] = err
+		mu.Unlock()
+	}
+
+	s.Enqueue(TransferJob{SourcePath: "ok.txt", DestinationPath: "ok.txt"})
+	s.Enqueue(TransferJob{SourcePath: "bad.txt", DestinationPath: "bad.txt"})
+
+	ctx := context.Background()
+	for s.RunOnce(ctx) {
+	}
+
+	if results["ok.txt"] != nil {
+		t.Errorf("expected ok.txt to verify, got %v", results["ok.txt"])
+	}
+	if !errors.Is(results["bad.txt"], ErrChecksumMismatch) {
+		t.Errorf("expected mismatch for bad.txt, got %v", results["bad.txt"])
+	}
+	if s.Verified() != 1 || s.Failed() != 1 {
+		t.Errorf("expected 1 verified and 1 failed, got %d and %d", s.Verified(), s.Failed())
+	}
+}
+
+func TestScrubber_QueueBound(t *testing.T) {
+	s := NewScrubber(newMockProvider(), newMockProvider(), NewBufferPool(1024), 2)
+	for i := 0; i < 5; i++ {
+		s.Enqueue(TransferJob{})
+	}
+	if s.Pending() != 2 || s.Dropped() != 3 {
+		t.Errorf("expected 2 pending and 3 dropped, got %d and %d", s.Pending(), s.Dropped())
+	}
+}
+
+func TestWorkerPool_IdleTaskYieldsToJobs(t *testing.T) {
+	jobChan := make(JobChannel, 10)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+
+	pool := NewWorkerPool(context.Background(), jobChan, func(ctx context.Context, job TransferJob) error {
+		record("job")
+		return nil
+	})
+
+	idleRuns := 0
+	wake := make(chan struct{}, 1)
+	pool.SetIdleTask(func(ctx context.Context) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if idleRuns >= 1 {
+			return false
+		}
+		idleRuns++
+		order = append(order, "idle")
+		return true
+	}, wake)
+
+	jobChan <- TransferJob{}
+	jobChan <- TransferJob{}
+	pool.SetWorkerCount(1)
+
+	time.Sleep(50 * time.Millisecond)
+	close(jobChan)
+	pool.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 3 || order[0] != "job" || order[1] != "job" || order[2] != "idle" {
+		t.Errorf("expected queued jobs before idle work, got %v", order)
+	}
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// SendFileChunk is how many bytes SendFile hands the kernel at a time,
+// between which progress is reported and the run's context checked.
+const SendFileChunk = 8 * 1024 * 1024
+
+// SendFile copies src to dst in the kernel, with copy_file_range or
+// sendfile, when src reads a local file and dst writes one, through
+// wrappers that only account for the bytes (see provider.FileSender and
+// provider.FileSource). It saves copying every byte twice through
+// userspace, which is what bounds local copies on fast NVMe arrays. It
+// returns false, having copied nothing, when the kernel cannot copy these
+// streams; the caller then copies them itself.
+func SendFile(ctx context.Context, dst io.Writer, src io.Reader) (written int64, handled bool, err error) {
+	file, ok := provider.SourceFile(src)
+	if !ok {
+		return 0, false, nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, true, err
+		}
+		n, err := provider.SendFile(ctx, dst, file, SendFileChunk)
+		if written == 0 && errors.Is(err, provider.ErrNotSupported) {
+			return 0, false, nil
+		}
+		written += n
+		if n > 0 {
+			if serr := provider.Sent(src, n); serr != nil && err == nil {
+				err = serr
+			}
+		}
+		if err != nil || n < SendFileChunk {
+			return written, true, err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// ShapeBucket counts the values that are at most Max; the last bucket of a
+// distribution has Max -1 and catches everything larger.
+type ShapeBucket struct {
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// fileSizeBounds and dirSizeBounds are the upper bounds of the buckets of
+// the size and files-per-directory distributions.
+var (
+	fileSizeBounds = []int64{0, 4 << 10, 64 << 10, 1 << 20, 16 << 20, 256 << 20, 4 << 30}
+	dirSizeBounds  = []int64{1, 10, 100, 1000, 10000, 100000}
+)
+
+// NamespaceShape describes how a tree is laid out: how deep it goes, how
+// files spread over directories and how large they are. It guides tuning,
+// since a tree of millions of small files wants very different settings
+// from one of a few large ones.
+type NamespaceShape struct {
+	Files       int64 `json:"files"`
+	Directories int64 `json:"directories"`
+	Bytes       int64 `json:"bytes"`
+	// Depth[i] is the number of files i directories below the root.
+	Depth       []int64       `json:"depth_histogram"`
+	FilesPerDir []ShapeBucket `json:"files_per_directory"`
+	FileSizes   []ShapeBucket `json:"file_sizes"`
+	// LargestDir is the directory holding the most files, and
+	// LargestDirFiles how many it holds.
+	LargestDir      string `json:"largest_directory"`
+	LargestDirFiles int64  `json:"largest_directory_files"`
+}
+
+// ShapeOf computes the shape of a tree as returned by ListTree. Only
+// directories that contain files, directly or below, are counted.
+func ShapeOf(files map[string]provider.FileInfo) *NamespaceShape {
+	shape := &NamespaceShape{
+		FilesPerDir: newBuckets(dirSizeBounds),
+		FileSizes:   newBuckets(fileSizeBounds),
+	}
+	perDir := make(map[string]int64)
+	for rel, info := range files {
+		shape.Files++
+		shape.Bytes += info.Size()
+		addToBucket(shape.FileSizes, info.Size())
+
+		depth := strings.Count(rel, "/")
+		for len(shape.Depth) <= depth {
+			shape.Depth = append(shape.Depth, 0)
+		}
+		shape.Depth[depth]++
+
+		dir := path.Dir(rel)
+		perDir[dir]++
+		// Register the ancestors too so that directories holding only
+		// subdirectories are counted
+		for dir != "." {
+			dir = path.Dir(dir)
+			if _, ok := perDir[dir]; !ok {
+				perDir[dir] = 0
+			}
+		}
+	}
+
+	shape.Directories = int64(len(perDir))
+	for dir, n := range perDir {
+		if n > 0 {
+			addToBucket(shape.FilesPerDir, n)
+		}
+		if n > shape.LargestDirFiles || (n == shape.LargestDirFiles && n > 0 && dir < shape.LargestDir) {
+			shape.LargestDir, shape.LargestDirFiles = dir, n
+		}
+	}
+	return shape
+}
+
+// SmallFileRatio returns the fraction of files no larger than 64 KiB, whose
+// transfer time is dominated by per-file overhead rather than bandwidth.
+func (s *NamespaceShape) SmallFileRatio() float64 {
+	if s.Files == 0 {
+		return 0
+	}
+	var small int64
+	for _, b := range s.FileSizes {
+		if b.Max >= 0 && b.Max <= 64<<10 {
+			small += b.Count
+		}
+	}
+	return float64(small) / float64(s.Files)
+}
+
+// MaxDepth returns the depth of the deepest file.
+func (s *NamespaceShape) MaxDepth() int {
+	return len(s.Depth) - 1
+}
+
+// Recommendations returns tuning hints for migrating a tree of this shape,
+// phrased in terms of gfast's flags.
+func (s *NamespaceShape) Recommendations() []string {
+	if s.Files == 0 {
+		return nil
+	}
+	var recs []string
+	if ratio := s.SmallFileRatio(); ratio >= 0.5 {
+		recs = append(recs, fmt.Sprintf("%.0f%% of files are 64 KiB or smaller; per-file overhead dominates, so raise -streams (e.g. 256) and keep -buffer-size small", ratio*100))
+	}
+	if avg := s.Bytes / s.Files; avg >= 256<<20 {
+		recs = append(recs, fmt.Sprintf("files average %s; fewer -streams with a larger -buffer-size (e.g. 8388608) make better use of each connection", formatShapeBytes(avg)))
+	}
+	if s.LargestDirFiles >= 100000 {
+		recs = append(recs, fmt.Sprintf("%s holds %d files; listing it is serial, so expect a slow start and consider -cache-ttl for repeated runs", s.LargestDir, s.LargestDirFiles))
+	}
+	if s.MaxDepth() >= 32 {
+		recs = append(recs, fmt.Sprintf("the tree is %d levels deep; check that destination paths stay within length limits", s.MaxDepth()))
+	}
+	return recs
+}
+
+func newBuckets(bounds []int64) []ShapeBucket {
+	buckets := make([]ShapeBucket, len(bounds)+1)
+	for i, bound := range bounds {
+		buckets[i].Max = bound
+	}
+	buckets[len(bounds)].Max = -1
+	return buckets
+}
+
+func addToBucket(buckets []ShapeBucket, v int64) {
+	for i := range buckets {
+		if buckets[i].Max < 0 || v <= buckets[i].Max {
+			buckets[i].Count++
+			return
+		}
+	}
+}
+
+// ShapeBucketLabel renders the bucket at index i of a distribution,
+// formatting the bound as a byte size when bytes is set.
+func ShapeBucketLabel(buckets []ShapeBucket, i int, bytes bool) string {
+	format := func(v int64) string {
+		if bytes {
+			return formatShapeBytes(v)
+		}
+		return fmt.Sprint(v)
+	}
+	if buckets[i].Max < 0 {
+		return "> " + format(buckets[i-1].Max)
+	}
+	return "<= " + format(buckets[i].Max)
+}
+
+// formatShapeBytes formats n in whole binary units, truncating; the bucket
+// bounds are all exact.
+func formatShapeBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d %s", n, units[i])
+}
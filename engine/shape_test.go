@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestShapeOf(t *testing.T) {
+	files := map[string]provider.FileInfo{
+		"top.txt":         mockFileInfo{name: "top.txt", size: 0},
+		"a/one.txt":       mockFileInfo{name: "one.txt", size: 100},
+		"a/two.txt":       mockFileInfo{name: "two.txt", size: 5000},
+		"a/b/c/deep.bin":  mockFileInfo{name: "deep.bin", size: 2 << 20},
+		"a/b/c/deeper.gz": mockFileInfo{name: "deeper.gz", size: 1 << 30},
+	}
+	shape := ShapeOf(files)
+
+	if shape.Files != 5 || shape.Bytes != 100+5000+2<<20+1<<30 {
+		t.Errorf("Unexpected totals: %+v", shape)
+	}
+	// ".", "a", "a/b" and "a/b/c"; "a/b" only holds a directory
+	if shape.Directories != 4 {
+		t.Errorf("Expected 4 directories, got %d", shape.Directories)
+	}
+	if fmt.Sprint(shape.Depth) != "[1 2 0 2]" || shape.MaxDepth() != 3 {
+		t.Errorf("Unexpected depth histogram %v", shape.Depth)
+	}
+	if shape.LargestDir != "a" || shape.LargestDirFiles != 2 {
+		t.Errorf("Expected a to be the largest directory, got %s (%d)", shape.LargestDir, shape.LargestDirFiles)
+	}
+
+	sizes := make(map[string]int64)
+	for i, b := range shape.FileSizes {
+		sizes[ShapeBucketLabel(shape.FileSizes, i, true)] = b.Count
+	}
+	want := map[string]int64{"<= 0 B": 1, "<= 4 KiB": 1, "<= 64 KiB": 1, "<= 16 MiB": 1, "<= 4 GiB": 1}
+	for label, n := range want {
+		if sizes[label] != n {
+			t.Errorf("Expected %d files %s, got %d", n, label, sizes[label])
+		}
+	}
+	if label := ShapeBucketLabel(shape.FilesPerDir, len(shape.FilesPerDir)-1, false); label != "> 100000" {
+		t.Errorf("Unexpected overflow bucket label %q", label)
+	}
+
+	var perDir int64
+	for _, b := range shape.FilesPerDir {
+		perDir += b.Count
+	}
+	if perDir != 3 {
+		t.Errorf("Expected 3 directories holding files, got %d", perDir)
+	}
+}
+
+func TestNamespaceShape_Recommendations(t *testing.T) {
+	small := make(map[string]provider.FileInfo)
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("f%d", i)
+		small[name] = mockFileInfo{name: name, size: 512}
+	}
+	recs := ShapeOf(small).Recommendations()
+	if len(recs) != 1 || !strings.Contains(recs[0], "-streams") {
+		t.Errorf("Expected a -streams recommendation for small files, got %v", recs)
+	}
+
+	large := map[string]provider.FileInfo{"disk.img": mockFileInfo{name: "disk.img", size: 10 << 30}}
+	recs = ShapeOf(large).Recommendations()
+	if len(recs) != 1 || !strings.Contains(recs[0], "-buffer-size") {
+		t.Errorf("Expected a -buffer-size recommendation for large files, got %v", recs)
+	}
+
+	if recs := ShapeOf(nil).Recommendations(); recs != nil {
+		t.Errorf("Expected no recommendations for an empty tree, got %v", recs)
+	}
+}
@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+// listingPage is the number of listed files written or read per store
+// transaction.
+const listingPage = 1000
+
+// ListingStore keeps the source listings of snapshot runs;
+// store.BoltStore implements it.
+type ListingStore interface {
+	AddListing(runID string, files []store.ListedFile) error
+	ListedFiles(runID, after string, limit int) ([]store.ListedFile, error)
+	DeleteListing(runID string) error
+}
+
+// Snapshot gives a run over a source that changes while it is copied
+// point-in-time semantics: Record lists the whole source into the state
+// store first, Queue then transfers the files of that listing and no
+// others, and Compare reports the files that appeared or disappeared since.
+// A resumed run queues the listing it recorded, so it copies the same set
+// of files however often it is interrupted. Files are read as they are
+// when copied; those that change meanwhile are handled by the run's
+// SourceChangePolicy as in any run.
+type Snapshot struct {
+	Store ListingStore
+	RunID string
+}
+
+// SnapshotChanges lists, by source path, the files that appeared at the
+// source and those that disappeared from it since its listing was recorded.
+type SnapshotChanges struct {
+	Appeared    []string
+	Disappeared []string
+}
+
+// Record walks sourcePath with w's settings and records every file the
+// walk would queue, and the directories it lists, in place of any listing
+// the run recorded before. The destination paths it finds go to w's Seen,
+// and its counts of what it left out are added to w's, as if w had walked;
+// the directories go to w's Dirs once queued. Histories are not recorded:
+// files are listed with their current version. It returns the files and
+// bytes recorded.
+func (s *Snapshot) Record(ctx context.Context, w *Walker, sourcePath, destPath string) (files, bytes int64, err error) {
+	if err := s.Store.DeleteListing(s.RunID); err != nil {
+		return 0, 0, err
+	}
+
+	scan := w.scanner()
+	scan.Versions = false
+	scan.Dirs, scan.Seen, scan.OnError = &DirSet{}, w.Seen, w.OnError
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A failure to record stops the walk, which then returns as cancelled
+	var recordErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		page := make([]store.ListedFile, 0, listingPage)
+		flush := func() {
+			if recordErr == nil && len(page) > 0 {
+				if recordErr = s.Store.AddListing(s.RunID, page); recordErr != nil {
+					cancel()
+				}
+			}
+			page = page[:0]
+		}
+		for job := range scan.JobChan {
+			file := store.ListedFile{SourcePath: job.SourcePath, DestinationPath: job.DestinationPath}
+			if job.FileInfo != nil {
+				info, err := json.Marshal(provider.NewMetadataRecord("", job.FileInfo))
+				if err != nil && recordErr == nil {
+					recordErr = fmt.Errorf("failed to record %s: %w", job.SourcePath, err)
+					cancel()
+				}
+				file.Info = info
+			}
+			files++
+			bytes += jobSize(job)
+			if page = append(page, file); len(page) == listingPage {
+				flush()
+			}
+		}
+		flush()
+	}()
+	err = scan.Walk(walkCtx, sourcePath, destPath)
+	close(scan.JobChan)
+	<-done
+
+	// Directories are recorded too, so a resumed run creates them
+	if recordErr == nil && err == nil {
+		var dirs []store.ListedFile
+		for _, job := range scan.Dirs.jobs {
+			info, _ := json.Marshal(provider.NewMetadataRecord("", job.FileInfo))
+			dirs = append(dirs, store.ListedFile{SourcePath: job.SourcePath, DestinationPath: job.DestinationPath, Dir: true, Info: info})
+		}
+		for len(dirs) > 0 && recordErr == nil {
+			n := min(len(dirs), listingPage)
+			recordErr = s.Store.AddListing(s.RunID, dirs[:n])
+			dirs = dirs[n:]
+		}
+	}
+
+	w.SkippedLinks += scan.SkippedLinks
+	w.Filtered += scan.Filtered
+	w.SkippedMounts += scan.SkippedMounts
+	w.SkippedErrors += scan.SkippedErrors
+	if recordErr != nil {
+		return files, bytes, fmt.Errorf("failed to record the listing: %w", recordErr)
+	}
+	return files, bytes, err
+}
+
+// Queue queues the files of the recorded listing through w, with the
+// metadata they had when listed, as w's walk would have queued them, and
+// adds its directories to w's Dirs.
+func (s *Snapshot) Queue(ctx context.Context, w *Walker) error {
+	return s.each(func(file store.ListedFile) error {
+		job := TransferJob{
+			ID:              NewJobID(),
+			SourcePath:      file.SourcePath,
+			DestinationPath: file.DestinationPath,
+			Ctx:             ctx,
+		}
+		if len(file.Info) > 0 {
+			var record provider.MetadataRecord
+			if err := json.Unmarshal(file.Info, &record); err != nil {
+				return fmt.Errorf("failed to read the listing of %s: %w", file.SourcePath, err)
+			}
+			job.FileInfo = record.FileInfo()
+		}
+		if file.Dir {
+			if w.Dirs != nil {
+				w.Dirs.Add(job)
+			}
+			return nil
+		}
+		return w.enqueue(ctx, job)
+	})
+}
+
+// Compare walks sourcePath again with w's settings and returns the files
+// that appeared or disappeared since the listing was recorded, in path
+// order. The destination paths the walk finds below destPath go to w's
+// Seen, so mirroring keeps what a resumed run, which queued the listing
+// without walking, would otherwise not know of. Files the walk leaves out
+// count as disappeared, so it fails rather than report those of
+// directories it could not read.
+func (s *Snapshot) Compare(ctx context.Context, w *Walker, sourcePath, destPath string) (SnapshotChanges, error) {
+	// found tells, for each listed file, whether the walk found it again
+	found := make(map[string]bool)
+	if err := s.each(func(file store.ListedFile) error {
+		if !file.Dir {
+			found[file.SourcePath] = false
+		}
+		return nil
+	}); err != nil {
+		return SnapshotChanges{}, err
+	}
+
+	var changes SnapshotChanges
+	scan := w.scanner()
+	scan.Versions = false
+	scan.Seen = w.Seen
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for job := range scan.JobChan {
+			if _, ok := found[job.SourcePath]; ok {
+				found[job.SourcePath] = true
+			} else {
+				changes.Appeared = append(changes.Appeared, job.SourcePath)
+			}
+		}
+	}()
+	err := scan.Walk(ctx, sourcePath, destPath)
+	close(scan.JobChan)
+	<-done
+	if err == nil && scan.SkippedErrors > 0 {
+		err = fmt.Errorf("the walk skipped %d unreadable directories", scan.SkippedErrors)
+	}
+	if err != nil {
+		return SnapshotChanges{}, fmt.Errorf("failed to compare the source with its listing: %w", err)
+	}
+
+	for path, ok := range found {
+		if !ok {
+			changes.Disappeared = append(changes.Disappeared, path)
+		}
+	}
+	sort.Strings(changes.Appeared)
+	sort.Strings(changes.Disappeared)
+	return changes, nil
+}
+
+// each calls fn with every file of the recorded listing, in path order,
+// reading it a page at a time.
+func (s *Snapshot) each(fn func(file store.ListedFile) error) error {
+	after := ""
+	for {
+		page, err := s.Store.ListedFiles(s.RunID, after, listingPage)
+		if err != nil {
+			return fmt.Errorf("failed to read the listing: %w", err)
+		}
+		for _, file := range page {
+			if err := fn(file); err != nil {
+				return err
+			}
+		}
+		if len(page) < listingPage {
+			return nil
+		}
+		after = page[len(page)-1].SourcePath
+	}
+}
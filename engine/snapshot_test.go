@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/franksops/gofast/store"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	boltStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{
+		{name: "a.txt", size: 10},
+		{name: "big.bin", size: 5000},
+		{name: "dir", isDir: true},
+	}
+	mp.dirs["/root/dir"] = []mockFileInfo{{name: "b.txt", size: 20}}
+
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(mp, jobChan)
+	walker.Filter = &FileFilter{MaxSize: 100}
+	walker.Seen = NewPathSet()
+	snapshot := &Snapshot{Store: boltStore, RunID: "run"}
+
+	files, bytes, err := snapshot.Record(ctx, walker, "/root", "/dst")
+	if err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if files != 2 || bytes != 30 {
+		t.Errorf("Record = %d files, %d bytes; want 2 files, 30 bytes", files, bytes)
+	}
+	if len(jobChan) != 0 {
+		t.Errorf("Expected nothing queued while recording, got %d jobs", len(jobChan))
+	}
+	if walker.Filtered != 1 || !walker.Seen.Has("/dst/dir/b.txt") {
+		t.Errorf("Expected the walker to account for the listing: %d filtered, seen %v", walker.Filtered, walker.Seen.Has("/dst/dir/b.txt"))
+	}
+
+	// The source changes after the listing: the run still copies what
+	// was listed, with its metadata as listed
+	mp.dirs["/root"] = []mockFileInfo{
+		{name: "a.txt", size: 15},
+		{name: "big.bin", size: 5000},
+		{name: "new.txt", size: 1},
+		{name: "dir", isDir: true},
+	}
+	mp.dirs["/root/dir"] = nil
+
+	// A resumed run queues the listing with a walker of its own
+	walker = NewWalker(mp, jobChan)
+	walker.Filter = &FileFilter{MaxSize: 100}
+	walker.Seen = NewPathSet()
+	walker.Dirs = &DirSet{}
+	var queued []string
+	walker.OnQueue = func(job TransferJob) { queued = append(queued, job.DestinationPath) }
+	if err := snapshot.Queue(ctx, walker); err != nil {
+		t.Fatalf("Queue failed: %v", err)
+	}
+	close(jobChan)
+	if want := []string{"/dst/a.txt", "/dst/dir/b.txt"}; !reflect.DeepEqual(queued, want) {
+		t.Errorf("Expected %v queued, got %v", want, queued)
+	}
+	if walker.Dirs.Len() != 2 {
+		t.Errorf("Expected the listed directories added, got %d", walker.Dirs.Len())
+	}
+	for job := range jobChan {
+		if job.SourcePath == "/root/a.txt" && (job.FileInfo == nil || job.FileInfo.Size() != 10) {
+			t.Errorf("Expected a.txt queued with its listed size, got %v", job.FileInfo)
+		}
+	}
+
+	changes, err := snapshot.Compare(ctx, walker, "/root", "/dst")
+	if err != nil {
+		t.Fatalf("Compare failed: %v", err)
+	}
+	want := SnapshotChanges{Appeared: []string{"/root/new.txt"}, Disappeared: []string{"/root/dir/b.txt"}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Compare = %+v, want %+v", changes, want)
+	}
+	if !walker.Seen.Has("/dst/new.txt") {
+		t.Error("Expected the files found by the comparison to be seen")
+	}
+
+	// Recording again replaces the listing
+	if files, _, err := snapshot.Record(ctx, walker, "/root", "/dst"); err != nil || files != 2 {
+		t.Fatalf("Expected 2 files recorded again, got %d (%v)", files, err)
+	}
+	if changes, err := snapshot.Compare(ctx, walker, "/root", "/dst"); err != nil || len(changes.Appeared)+len(changes.Disappeared) != 0 {
+		t.Errorf("Expected no changes against a fresh listing, got %+v (%v)", changes, err)
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// SourceChangePolicy controls what happens to a file whose source changed
+// while it was copied, such as a log file still being written.
+type SourceChangePolicy string
+
+const (
+	// SourceChangesRetry discards the copy and fails the job, so the retry
+	// sweep copies the file again from a fresh stat.
+	SourceChangesRetry SourceChangePolicy = "retry"
+	// SourceChangesInconsistent keeps the copy but records the job as
+	// Inconsistent rather than Completed, so later runs copy it again.
+	SourceChangesInconsistent SourceChangePolicy = "inconsistent"
+	// SourceChangesIgnore completes the job without checking the source
+	// again.
+	SourceChangesIgnore SourceChangePolicy = "ignore"
+)
+
+// ParseSourceChangePolicy parses a -source-changes flag value.
+func ParseSourceChangePolicy(s string) (SourceChangePolicy, error) {
+	switch p := SourceChangePolicy(s); p {
+	case SourceChangesRetry, SourceChangesInconsistent, SourceChangesIgnore:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown source change policy %q (want retry, inconsistent or ignore)", s)
+}
+
+// SourceModifiedError reports that a source file changed while it was
+// copied, so the copy may mix old and new content.
+type SourceModifiedError struct {
+	Path   string
+	Reason string
+}
+
+func (e *SourceModifiedError) Error() string {
+	return fmt.Sprintf("source %s changed during transfer: %s", e.Path, e.Reason)
+}
+
+// CheckSourceUnchanged stats job's source again after it was copied and
+// returns a *SourceModifiedError if its size or modification time differ
+// from those the walk saw. Sources whose provider replaces files whole
+// (provider.Capabilities.AtomicObjects) cannot be read torn and are not
+// checked, nor are directories and symbolic links.
+func CheckSourceUnchanged(ctx context.Context, job TransferJob, src provider.Provider) error {
+	if job.FileInfo == nil || job.FileInfo.IsDir() || provider.CapabilitiesOf(src).AtomicObjects {
+		return nil
+	}
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return nil
+	}
+	info, err := src.Stat(ctx, job.SourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat source after transfer: %w", err)
+	}
+	switch {
+	case info.Size() != job.FileInfo.Size():
+		return &SourceModifiedError{
+			Path:   job.SourcePath,
+			Reason: fmt.Sprintf("size changed from %d to %d bytes", job.FileInfo.Size(), info.Size()),
+		}
+	case !info.ModTime().Equal(job.FileInfo.ModTime()):
+		return &SourceModifiedError{Path: job.SourcePath, Reason: "modification time changed"}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestParseSourceChangePolicy(t *testing.T) {
+	for _, s := range []string{"retry", "inconsistent", "ignore"} {
+		if p, err := ParseSourceChangePolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseSourceChangePolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseSourceChangePolicy("panic"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestCheckSourceUnchanged(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(name, []byte("line 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := provider.NewLocalProvider("").WithRoot(dir)
+	info, err := src.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := TransferJob{ID: name, SourcePath: name, DestinationPath: "/dst/app.log", FileInfo: info}
+
+	if err := CheckSourceUnchanged(ctx, job, src); err != nil {
+		t.Fatalf("Expected an unchanged source to pass, got %v", err)
+	}
+
+	// A writer appends while the file is copied
+	if err := os.WriteFile(name, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var modified *SourceModifiedError
+	if err := CheckSourceUnchanged(ctx, job, src); !errors.As(err, &modified) || modified.Path != name {
+		t.Fatalf("Expected a SourceModifiedError for a grown source, got %v", err)
+	}
+
+	// Rewritten in place with the same size
+	info, _ = src.Stat(ctx, name)
+	job.FileInfo = info
+	if err := os.Chtimes(name, time.Now(), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSourceUnchanged(ctx, job, src); !errors.As(err, &modified) {
+		t.Fatalf("Expected a SourceModifiedError for a new modification time, got %v", err)
+	}
+
+	// Object stores replace objects whole and are not checked again
+	if err := CheckSourceUnchanged(ctx, job, atomicProvider{src}); err != nil {
+		t.Errorf("Expected atomic sources not to be checked, got %v", err)
+	}
+}
+
+// atomicProvider reports the capabilities of an object store.
+type atomicProvider struct{ provider.Provider }
+
+func (atomicProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{AtomicObjects: true}
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// SparseExtents returns the data extents of a job's source file if it is
+// sparse, or nil if it has no holes or the source cannot tell.
+func SparseExtents(ctx context.Context, job TransferJob, src provider.Provider) ([]provider.Extent, error) {
+	if job.FileInfo == nil || !provider.CapabilitiesOf(src).Sparse {
+		return nil, nil
+	}
+	reader, ok := src.(provider.SparseReader)
+	if !ok {
+		return nil, nil
+	}
+	extents, err := reader.DataExtents(ctx, job.SourcePath)
+	if errors.Is(err, provider.ErrNotSupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if provider.ExtentBytes(extents) >= job.FileInfo.Size() {
+		return nil, nil
+	}
+	return extents, nil
+}
+
+// CopySparse copies a sparse file of the given size to w, reading only its
+// data extents from src and writing zeros for the holes. Destinations that
+// write sparsely turn the zeros back into holes. It returns the number of
+// bytes read from the source.
+func CopySparse(ctx context.Context, w io.Writer, src provider.Provider, path string, size int64, extents []provider.Extent, buf []byte) (int64, error) {
+	var pos, transferred int64
+	for _, extent := range extents {
+		if err := writeZeros(w, extent.Offset-pos, buf); err != nil {
+			return transferred, err
+		}
+
+		rc, err := provider.OpenReadRange(ctx, src, path, extent.Offset, extent.Length)
+		if err != nil {
+			return transferred, err
+		}
+		n, err := io.CopyBuffer(w, io.LimitReader(rc, extent.Length), buf)
+		rc.Close()
+		transferred += n
+		if err != nil {
+			return transferred, err
+		}
+		if n != extent.Length {
+			return transferred, io.ErrUnexpectedEOF
+		}
+		pos = extent.Offset + extent.Length
+	}
+	return transferred, writeZeros(w, size-pos, buf)
+}
+
+// writeZeros writes n zero bytes to w, using buf as scratch space.
+func writeZeros(w io.Writer, n int64, buf []byte) error {
+	clear(buf)
+	for n > 0 {
+		chunk := buf[:min(n, int64(len(buf)))]
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		n -= int64(len(chunk))
+	}
+	return nil
+}
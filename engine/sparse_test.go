@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestCopySparse(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("boot"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("data"), 512<<10); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	want, err := os.ReadFile(filepath.Join(dir, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	src := provider.WithMetrics(provider.NewLocalProvider(dir), provider.NewMetrics())
+	info, err := src.Stat(ctx, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := TransferJob{SourcePath: "disk.img", FileInfo: info}
+
+	extents, err := SparseExtents(ctx, job, src)
+	if err != nil {
+		t.Fatalf("SparseExtents failed: %v", err)
+	}
+	if extents == nil {
+		// No hole support here; copy the whole file as one extent
+		extents = []provider.Extent{{Offset: 0, Length: info.Size()}}
+	}
+
+	var out bytes.Buffer
+	transferred, err := CopySparse(ctx, &out, src, "disk.img", info.Size(), extents, make([]byte, 64<<10))
+	if err != nil {
+		t.Fatalf("CopySparse failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("copied %d bytes that differ from the source", out.Len())
+	}
+	if transferred != provider.ExtentBytes(extents) {
+		t.Errorf("transferred %d bytes; want %d", transferred, provider.ExtentBytes(extents))
+	}
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// FileCount counts files and their bytes.
+type FileCount struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (c *FileCount) add(bytes int64) {
+	c.Files++
+	c.Bytes += bytes
+}
+
+// Stats is a snapshot of a run's progress, as RunStats returns it.
+type Stats struct {
+	// Elapsed is the time since the run started, or that it took once
+	// finished.
+	Elapsed time.Duration `json:"elapsed"`
+	// Scanning means the source is still being walked, so Total is still
+	// growing.
+	Scanning bool `json:"scanning"`
+	Finished bool `json:"finished"`
+	// Interrupted means the run finished before it got through its files.
+	Interrupted bool `json:"interrupted"`
+
+	// Total counts the files found at the source. Completed counts those
+	// done with, Skipped among them those the destination already held,
+	// and Failed those whose last attempt failed.
+	Total     FileCount `json:"total"`
+	Completed FileCount `json:"completed"`
+	Skipped   FileCount `json:"skipped"`
+	Failed    FileCount `json:"failed"`
+	// Failures counts failed attempts, retries included.
+	Failures int64 `json:"failures"`
+	// SparseBytes counts the holes of completed sparse files, included in
+	// Completed but never transferred.
+	SparseBytes int64 `json:"sparse_bytes"`
+
+	// Rate is the run's throughput in bytes per second, and Streams the
+	// transfers running, as measured by the Meter.
+	Rate    float64      `json:"rate"`
+	Streams []StreamRate `json:"streams"`
+}
+
+// Remaining returns the bytes not yet completed, as far as they are known.
+func (s Stats) Remaining() int64 {
+	return max(s.Total.Bytes-s.Completed.Bytes, 0)
+}
+
+// ETA estimates the time left at the current rate. It returns false while
+// the source is still being scanned, or if there is no rate to estimate
+// from.
+func (s Stats) ETA() (time.Duration, bool) {
+	if s.Scanning || s.Rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(s.Remaining()) / s.Rate * float64(time.Second)), true
+}
+
+// RunStats follows the events of a run to count its files by state and,
+// with its Meter, gives the numbers everything reporting on the run shows,
+// so the TUI, progress log, control API and summary agree. Subscribe its
+// Handle method to the run's EventBus. It is safe for concurrent use.
+type RunStats struct {
+	meter *Meter
+
+	mu    sync.Mutex
+	start time.Time
+	end   time.Time
+	stats Stats
+	// failed holds the bytes of the files whose last attempt failed, by
+	// destination path, which identifies a file across its retries
+	failed map[string]int64
+}
+
+// NewRunStats returns stats for a run starting now, taking its rates from
+// meter, which may be nil.
+func NewRunStats(meter *Meter) *RunStats {
+	return &RunStats{
+		meter:  meter,
+		start:  time.Now(),
+		stats:  Stats{Scanning: true},
+		failed: make(map[string]int64),
+	}
+}
+
+// Handle applies e to the stats.
+func (r *RunStats) Handle(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &r.stats
+	switch e := e.(type) {
+	case JobQueued:
+		// Totals grow as files are queued, until the scan reports them
+		if s.Scanning {
+			var size int64
+			if e.Job.FileInfo != nil {
+				size = e.Job.FileInfo.Size()
+			}
+			s.Total.add(size)
+		}
+	case ScanFinished:
+		s.Total = FileCount{Files: e.Files, Bytes: e.Bytes}
+		s.Scanning = false
+	case JobCompleted:
+		s.Completed.add(e.Bytes)
+		if e.Skipped {
+			s.Skipped.add(e.Bytes)
+		}
+		s.SparseBytes += e.Sparse
+		delete(r.failed, e.Job.DestinationPath)
+	case JobFailed:
+		s.Failures++
+		var size int64
+		if e.Job.FileInfo != nil {
+			size = e.Job.FileInfo.Size()
+		}
+		r.failed[e.Job.DestinationPath] = size
+	case RunFinished:
+		s.Finished = true
+		s.Interrupted = e.Interrupted
+		r.end = time.Now()
+	}
+}
+
+// Stats returns a snapshot of the run's progress.
+func (r *RunStats) Stats() Stats {
+	r.mu.Lock()
+	s := r.stats
+	s.Failed = FileCount{}
+	for _, size := range r.failed {
+		s.Failed.Files++
+		s.Failed.Bytes += size
+	}
+	end := r.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	s.Elapsed = end.Sub(r.start)
+	r.mu.Unlock()
+
+	if r.meter != nil {
+		s.Rate = r.meter.Rate()
+		s.Streams = r.meter.Streams()
+	}
+	return s
+}
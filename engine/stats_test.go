@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunStats(t *testing.T) {
+	stats := NewRunStats(nil)
+	var bus EventBus
+	bus.Subscribe(stats.Handle)
+
+	a := TransferJob{ID: "a", DestinationPath: "dst/a", FileInfo: mockFileInfo{name: "a", size: 100}}
+	b := TransferJob{ID: "b", DestinationPath: "dst/b", FileInfo: mockFileInfo{name: "b", size: 50}}
+	c := TransferJob{ID: "c", DestinationPath: "dst/c", FileInfo: mockFileInfo{name: "c", size: 10}}
+	for _, job := range []TransferJob{a, b} {
+		bus.Publish(JobQueued{Job: job})
+	}
+	if s := stats.Stats(); s.Total != (FileCount{2, 150}) || !s.Scanning {
+		t.Fatalf("Expected totals to grow while scanning, got %+v", s)
+	}
+	if _, ok := stats.Stats().ETA(); ok {
+		t.Error("Expected no ETA while scanning")
+	}
+	bus.Publish(JobQueued{Job: c})
+	bus.Publish(ScanFinished{Files: 3, Bytes: 160})
+	// Jobs queued after the scan, such as retries, are already counted
+	bus.Publish(JobQueued{Job: a})
+
+	bus.Publish(JobStarted{Job: a})
+	bus.Publish(JobCompleted{Job: a, Bytes: 100, Sparse: 30})
+	bus.Publish(JobCompleted{Job: c, Bytes: 10, Skipped: true})
+	bus.Publish(JobFailed{Job: b, Err: errors.New("reset")})
+	s := stats.Stats()
+	if s.Total != (FileCount{3, 160}) || s.Completed != (FileCount{2, 110}) || s.Skipped != (FileCount{1, 10}) {
+		t.Errorf("Expected 2 of 3 files completed, 1 skipped, got %+v", s)
+	}
+	if s.Failed != (FileCount{1, 50}) || s.Failures != 1 || s.SparseBytes != 30 || s.Remaining() != 50 {
+		t.Errorf("Expected b failed with 50 bytes left, got %+v", s)
+	}
+
+	// A retry that fails again is one file failing twice; one that
+	// succeeds leaves none failed
+	bus.Publish(JobFailed{Job: b, Err: errors.New("reset")})
+	if s := stats.Stats(); s.Failed.Files != 1 || s.Failures != 2 {
+		t.Errorf("Expected 1 file failed twice, got %+v", s)
+	}
+	bus.Publish(JobCompleted{Job: b, Bytes: 50})
+	bus.Publish(RunFinished{})
+	s = stats.Stats()
+	if s.Failed.Files != 0 || s.Completed.Files != 3 || !s.Finished || s.Interrupted {
+		t.Errorf("Expected every file completed, got %+v", s)
+	}
+	// The time taken stops with the run
+	if time.Sleep(5 * time.Millisecond); stats.Stats().Elapsed != s.Elapsed {
+		t.Error("Expected the elapsed time to stop once the run finished")
+	}
+}
+
+func TestStats_ETA(t *testing.T) {
+	s := Stats{Total: FileCount{Files: 2, Bytes: 3000}, Completed: FileCount{Files: 1, Bytes: 1000}, Rate: 1000}
+	if eta, ok := s.ETA(); !ok || eta != 2*time.Second {
+		t.Errorf("Expected 2s left, got %v %v", eta, ok)
+	}
+	s.Rate = 0
+	if _, ok := s.ETA(); ok {
+		t.Error("Expected no ETA without a rate")
+	}
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// ErrStreamNotFound is returned when changing the rate of a stream that is
+// not running.
+var ErrStreamNotFound = errors.New("stream not found")
+
+// StreamLimits gives every running transfer a token bucket of its own, so
+// an operator can slow one noisy stream while the run continues, on top of
+// any global -bwlimit. Streams follow the default rate until given one of
+// their own. It is safe for concurrent use.
+type StreamLimits struct {
+	mu          sync.Mutex
+	defaultRate int64
+	streams     map[string]*limitedStream
+}
+
+// StreamStatus describes a running stream for the control API.
+type StreamStatus struct {
+	JobID string `json:"job_id"`
+	Path  string `json:"path"`
+	// Rate is the stream's limit in bytes per second, 0 if unlimited.
+	Rate int64 `json:"rate"`
+	// Custom means the rate was set for this stream rather than
+	// following the default.
+	Custom bool  `json:"custom"`
+	Bytes  int64 `json:"bytes"`
+}
+
+type limitedStream struct {
+	job    TransferJob
+	bucket *provider.TokenBucket
+	custom bool
+	bytes  atomic.Int64
+}
+
+// NewStreamLimits returns limits giving each stream defaultRate bytes per
+// second (0 = unlimited).
+func NewStreamLimits(defaultRate int64) *StreamLimits {
+	return &StreamLimits{defaultRate: defaultRate, streams: make(map[string]*limitedStream)}
+}
+
+// Reader registers job as running and returns r limited to the stream's
+// rate. done unregisters the stream; call it once the transfer ends.
+func (l *StreamLimits) Reader(ctx context.Context, job TransferJob, r io.Reader) (limited io.Reader, done func()) {
+	l.mu.Lock()
+	s := &limitedStream{job: job, bucket: provider.NewTokenBucket(l.defaultRate)}
+	l.streams[job.ID] = s
+	l.mu.Unlock()
+
+	done = func() {
+		l.mu.Lock()
+		if l.streams[job.ID] == s {
+			delete(l.streams, job.ID)
+		}
+		l.mu.Unlock()
+	}
+	return &limitedReader{r: r, ctx: ctx, stream: s}, done
+}
+
+// SetRate changes the rate of the running stream of jobID, taking effect
+// from its next read. A rate of zero makes it unlimited.
+func (l *StreamLimits) SetRate(jobID string, bytesPerSec int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.streams[jobID]
+	if !ok {
+		return ErrStreamNotFound
+	}
+	s.bucket.SetRate(bytesPerSec)
+	s.custom = true
+	return nil
+}
+
+// SetDefaultRate changes the rate of new streams and of running streams
+// not given a rate of their own.
+func (l *StreamLimits) SetDefaultRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.defaultRate = bytesPerSec
+	for _, s := range l.streams {
+		if !s.custom {
+			s.bucket.SetRate(bytesPerSec)
+		}
+	}
+}
+
+// DefaultRate returns the rate of streams without one of their own.
+func (l *StreamLimits) DefaultRate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.defaultRate
+}
+
+// Streams returns the running streams, sorted by job ID.
+func (l *StreamLimits) Streams() []StreamStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	statuses := make([]StreamStatus, 0, len(l.streams))
+	for _, s := range l.streams {
+		statuses = append(statuses, StreamStatus{
+			JobID:  s.job.ID,
+			Path:   s.job.SourcePath,
+			Rate:   s.bucket.Rate(),
+			Custom: s.custom,
+			Bytes:  s.bytes.Load(),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].JobID < statuses[j].JobID })
+	return statuses
+}
+
+type limitedReader struct {
+	r      io.Reader
+	ctx    context.Context
+	stream *limitedStream
+}
+
+// Unwrap returns the wrapped reader, for provider.SourceFile.
+func (r *limitedReader) Unwrap() io.Reader { return r.r }
+
+// Sent counts bytes copied from the source in the kernel and waits for
+// their tokens.
+func (r *limitedReader) Sent(n int64) error {
+	r.stream.bytes.Add(n)
+	return r.stream.bucket.WaitN(r.ctx, int(n))
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.stream.bytes.Add(int64(n))
+		if werr := r.stream.bucket.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStreamLimits(t *testing.T) {
+	ctx := context.Background()
+	limits := NewStreamLimits(0)
+	a := TransferJob{ID: "/src/a", SourcePath: "/src/a"}
+	b := TransferJob{ID: "/src/b", SourcePath: "/src/b"}
+
+	ra, doneA := limits.Reader(ctx, a, bytes.NewReader(make([]byte, 1000)))
+	_, doneB := limits.Reader(ctx, b, bytes.NewReader(nil))
+	if n, err := io.Copy(io.Discard, ra); n != 1000 || err != nil {
+		t.Fatalf("Unlimited copy: %d, %v", n, err)
+	}
+
+	if err := limits.SetRate(a.ID, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	limits.SetDefaultRate(512 << 10)
+	streams := limits.Streams()
+	if len(streams) != 2 {
+		t.Fatalf("Expected 2 streams, got %+v", streams)
+	}
+	if s := streams[0]; s.JobID != a.ID || s.Rate != 1<<20 || !s.Custom || s.Bytes != 1000 {
+		t.Errorf("Unexpected stream a: %+v", s)
+	}
+	// The default applies to streams without a rate of their own
+	if s := streams[1]; s.JobID != b.ID || s.Rate != 512<<10 || s.Custom {
+		t.Errorf("Unexpected stream b: %+v", s)
+	}
+
+	doneA()
+	doneB()
+	if streams := limits.Streams(); len(streams) != 0 {
+		t.Errorf("Expected no streams after done, got %+v", streams)
+	}
+	if err := limits.SetRate(a.ID, 1); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("Expected ErrStreamNotFound, got %v", err)
+	}
+}
+
+func TestStreamLimits_Throttles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	limits := NewStreamLimits(0)
+	r, done := limits.Reader(ctx, TransferJob{ID: "a"}, bytes.NewReader(make([]byte, 5000)))
+	defer done()
+
+	// Slowed to 1000 bytes/s while running, 5000 bytes take seconds
+	limits.SetRate("a", 1000)
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the slowed stream to wait past the deadline, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// FailedJobs collects the jobs that failed during a run so a retry sweep
+// can dispatch them again once the main queue has drained. It is safe for
+// concurrent use.
+type FailedJobs struct {
+	mu   sync.Mutex
+	jobs []TransferJob
+}
+
+// Add records a failed job.
+func (f *FailedJobs) Add(job TransferJob) {
+	f.mu.Lock()
+	f.jobs = append(f.jobs, job)
+	f.mu.Unlock()
+}
+
+// Len returns the number of failed jobs recorded.
+func (f *FailedJobs) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.jobs)
+}
+
+// take returns the recorded jobs and forgets them.
+func (f *FailedJobs) take() []TransferJob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	jobs := f.jobs
+	f.jobs = nil
+	return jobs
+}
+
+// RetrySweep dispatches the failed jobs to handler again on workers
+// workers, for up to passes passes. handler is expected to record jobs
+// that fail again in failed, as during the run; the sweep stops early once
+// a pass has no failures. started, if set, is called with the worker pool
+// of each pass before it is given jobs, e.g. to pause it. It returns the
+// number of passes run.
+func RetrySweep(ctx context.Context, failed *FailedJobs, handler JobHandler, workers, passes int, started func(*WorkerPool)) int {
+	run := 0
+	for run < passes && ctx.Err() == nil {
+		jobs := failed.take()
+		if len(jobs) == 0 {
+			break
+		}
+		run++
+
+		jobChan := make(JobChannel)
+		pool := NewWorkerPool(ctx, jobChan, handler)
+		pool.SetWorkerCount(min(workers, len(jobs)))
+		if started != nil {
+			started(pool)
+		}
+	feed:
+		for _, job := range jobs {
+			select {
+			case jobChan <- job:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(jobChan)
+		pool.Wait()
+	}
+	return run
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestRetrySweep(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	failed := &FailedJobs{}
+	// a succeeds on its second attempt, b never does
+	handler := func(ctx context.Context, job TransferJob) error {
+		mu.Lock()
+		attempts[job.ID]++
+		n := attempts[job.ID]
+		mu.Unlock()
+		if job.ID == "b" || n < 2 {
+			failed.Add(job)
+			return errors.New("transient")
+		}
+		return nil
+	}
+
+	for _, id := range []string{"a", "b"} {
+		handler(context.Background(), TransferJob{ID: id})
+	}
+	if passes := RetrySweep(context.Background(), failed, handler, 4, 3, nil); passes != 3 {
+		t.Errorf("Expected 3 passes, got %d", passes)
+	}
+	if attempts["a"] != 2 || attempts["b"] != 4 {
+		t.Errorf("Expected 2 attempts of a and 4 of b, got %v", attempts)
+	}
+	if failed.Len() != 1 {
+		t.Errorf("Expected b still failed, got %d", failed.Len())
+	}
+}
+
+func TestRetrySweep_StopsWhenNoneFail(t *testing.T) {
+	failed := &FailedJobs{}
+	failed.Add(TransferJob{ID: "a"})
+	handler := func(ctx context.Context, job TransferJob) error { return nil }
+	if passes := RetrySweep(context.Background(), failed, handler, 2, 5, nil); passes != 1 {
+		t.Errorf("Expected 1 pass, got %d", passes)
+	}
+	if passes := RetrySweep(context.Background(), &FailedJobs{}, handler, 2, 5, nil); passes != 0 {
+		t.Errorf("Expected no passes without failures, got %d", passes)
+	}
+}
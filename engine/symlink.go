@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// TransferSymlink recreates a symbolic link job at the destination with the
+// same target. It returns false when the job is not a link or the
+// destination cannot store links; the caller then copies the content the
+// link points to instead.
+func TransferSymlink(ctx context.Context, job TransferJob, dst provider.Provider) (bool, error) {
+	target, ok := provider.SymlinkTarget(job.FileInfo)
+	if !ok || !provider.CapabilitiesOf(dst).Symlinks {
+		return false, nil
+	}
+	linker, ok := dst.(provider.Symlinker)
+	if !ok {
+		return false, nil
+	}
+	return true, linker.Symlink(ctx, target, job.DestinationPath)
+}
+
+// SymlinkPolicy controls how the walker treats symbolic links.
+type SymlinkPolicy string
+
+const (
+	// SymlinkPreserve queues links as links, to be recreated at the
+	// destination. Destinations that cannot store links receive the
+	// content the link points to instead.
+	SymlinkPreserve SymlinkPolicy = "preserve"
+	// SymlinkFollow copies what links point to: files as regular files
+	// and directories as the trees below them. Links that lead back into
+	// a directory being walked are skipped rather than followed forever.
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkSkip leaves links out of the migration.
+	SymlinkSkip SymlinkPolicy = "skip"
+)
+
+// maxLinkDepth bounds the number of directory links followed in a single
+// branch of the walk, like the kernel's ELOOP limit.
+const maxLinkDepth = 40
+
+// ParseSymlinkPolicy parses a -symlinks flag value.
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch p := SymlinkPolicy(s); p {
+	case SymlinkPreserve, SymlinkFollow, SymlinkSkip:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown symlink policy %q (want preserve, follow or skip)", s)
+}
+
+// linkLeadsBack reports whether following a directory link to target, from
+// a walk currently inside the directories of chain, would revisit one of
+// them. Paths are compared lexically, as the provider names them.
+func linkLeadsBack(target string, chain []string) bool {
+	for _, dir := range chain {
+		rel, err := filepath.Rel(target, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
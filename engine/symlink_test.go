@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestTransferSymlink(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(srcDir, "real.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real.txt", filepath.Join(srcDir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	src := provider.NewLocalProvider(srcDir)
+	dst := provider.NewLocalProvider(dstDir)
+	ctx := context.Background()
+
+	entries, err := src.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	var handledLinks int
+	for _, entry := range entries {
+		job := TransferJob{SourcePath: entry.Name(), DestinationPath: entry.Name(), FileInfo: entry}
+		handled, err := TransferSymlink(ctx, job, dst)
+		if err != nil {
+			t.Fatalf("TransferSymlink(%s) failed: %v", entry.Name(), err)
+		}
+		if handled {
+			handledLinks++
+		}
+	}
+
+	if handledLinks != 1 {
+		t.Fatalf("expected exactly one symlink to be handled, got %d", handledLinks)
+	}
+
+	target, err := os.Readlink(filepath.Join(dstDir, "link.txt"))
+	if err != nil {
+		t.Fatalf("expected link at destination: %v", err)
+	}
+	if target != "real.txt" {
+		t.Errorf("expected target real.txt, got %s", target)
+	}
+
+	// Destinations without link support fall back to copying content.
+	job := TransferJob{DestinationPath: "link.txt", FileInfo: entries[0]}
+	for _, e := range entries {
+		if _, ok := provider.SymlinkTarget(e); ok {
+			job.FileInfo = e
+		}
+	}
+	if handled, _ := TransferSymlink(ctx, job, newMockProvider()); handled {
+		t.Error("expected fallback for destination without Symlinker")
+	}
+}
+
+func TestWalker_SymlinkPolicy(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "shared.txt"), []byte("shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"file-link":     "sub/a.txt",
+		"dir-link":      outside,
+		"sub/loop":      "..",
+		"dangling-link": "missing",
+	} {
+		if err := os.Symlink(target, filepath.Join(srcDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seen *PathSet
+	walk := func(policy SymlinkPolicy) (map[string]TransferJob, int64) {
+		t.Helper()
+		jobChan := make(JobChannel, 100)
+		w := NewWalker(provider.NewLocalProvider(""), jobChan)
+		w.Symlinks = policy
+		seen = NewPathSet()
+		w.Seen = seen
+		if err := w.Walk(context.Background(), srcDir, "/dst"); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		close(jobChan)
+		jobs := make(map[string]TransferJob)
+		for job := range jobChan {
+			rel, _ := filepath.Rel("/dst", job.DestinationPath)
+			jobs[filepath.ToSlash(rel)] = job
+		}
+		return jobs, w.SkippedLinks
+	}
+
+	jobs, skipped := walk(SymlinkPreserve)
+	if len(jobs) != 5 || skipped != 0 {
+		t.Errorf("preserve: expected every link queued as is, got %d jobs and %d skipped", len(jobs), skipped)
+	}
+	if _, ok := provider.SymlinkTarget(jobs["dir-link"].FileInfo); !ok {
+		t.Error("preserve: expected dir-link to be queued as a link")
+	}
+
+	jobs, skipped = walk(SymlinkSkip)
+	if len(jobs) != 1 || skipped != 4 {
+		t.Errorf("skip: expected only sub/a.txt and 4 skipped links, got %v and %d", jobs, skipped)
+	}
+	// Mirroring keeps what the destination holds in place of skipped links
+	for _, path := range []string{"/dst/file-link", "/dst/dir-link/shared.txt", "/dst/sub/loop/a.txt", "/dst/dangling-link"} {
+		if !seen.Has(path) {
+			t.Errorf("skip: expected %s to be left out on purpose", path)
+		}
+	}
+
+	jobs, skipped = walk(SymlinkFollow)
+	// sub/loop leads back to the root and dangling-link nowhere
+	if skipped != 2 {
+		t.Errorf("follow: expected 2 skipped links, got %d", skipped)
+	}
+	for _, path := range []string{"/dst/sub/loop/a.txt", "/dst/dangling-link"} {
+		if !seen.Has(path) {
+			t.Errorf("follow: expected %s to be left out on purpose", path)
+		}
+	}
+	for _, rel := range []string{"sub/a.txt", "file-link", "dir-link/shared.txt"} {
+		job, ok := jobs[rel]
+		if !ok {
+			t.Errorf("follow: expected a job for %s, got %v", rel, jobs)
+			continue
+		}
+		if _, isLink := provider.SymlinkTarget(job.FileInfo); isLink || job.FileInfo.IsDir() {
+			t.Errorf("follow: expected %s to be queued as a regular file", rel)
+		}
+	}
+	if len(jobs) != 3 {
+		t.Errorf("follow: expected 3 jobs, got %d", len(jobs))
+	}
+	if job := jobs["dir-link/shared.txt"]; job.SourcePath != filepath.Join(srcDir, "dir-link", "shared.txt") {
+		t.Errorf("follow: expected the file to be read through the link, got %s", job.SourcePath)
+	}
+
+	if _, err := ParseSymlinkPolicy("hardlink"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
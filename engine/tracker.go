@@ -1,10 +1,18 @@
 package engine
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/franksops/gofast/provider"
 	"github.com/franksops/gofast/store"
 )
 
@@ -22,10 +30,18 @@ var DefaultCheckpointConfig = CheckpointConfig{
 	TimeInterval:  5 * time.Second,
 }
 
+// ErrDestinationBusy is returned by JobTracker.Claim when another job is
+// writing the same destination.
+var ErrDestinationBusy = errors.New("another job is writing the destination")
+
 // JobTracker wraps a store to provide job tracking and checkpointing capabilities
 type JobTracker struct {
 	store  store.Store
 	config CheckpointConfig
+
+	// active maps the destinations being written to their jobs' IDs.
+	mu     sync.Mutex
+	active map[string]string
 }
 
 // NewJobTracker creates a new JobTracker
@@ -33,7 +49,49 @@ func NewJobTracker(store store.Store, config CheckpointConfig) *JobTracker {
 	return &JobTracker{
 		store:  store,
 		config: config,
+		active: make(map[string]string),
+	}
+}
+
+// Identify returns job under the ID an earlier run recorded for the same
+// source, destination and target, so that run's progress and completion apply to
+// it. Jobs seen for the first time keep their own ID. State directories
+// written before jobs had UUIDs keyed them by source path, and are
+// recognised too.
+func (jt *JobTracker) Identify(job TransferJob) (TransferJob, error) {
+	record, err := jt.store.FindJob(job.SourcePath, job.DestinationPath, job.Target)
+	if errors.Is(err, store.ErrJobNotFound) && job.Target == "" {
+		record, err = jt.store.GetJob(job.SourcePath)
+		if err == nil && (record.DestinationPath != job.DestinationPath || record.VersionID != "") {
+			err = store.ErrJobNotFound
+		}
+	}
+	if errors.Is(err, store.ErrJobNotFound) {
+		return job, nil
+	}
+	if err != nil {
+		return job, err
 	}
+	job.ID = record.ID
+	return job, nil
+}
+
+// Claim records that job is writing its destination until release is
+// called. It returns ErrDestinationBusy if another job is writing it
+// already, such as one for a second source mapped to the same destination,
+// or the same job queued twice.
+func (jt *JobTracker) Claim(job TransferJob) (release func(), err error) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	if id, ok := jt.active[job.DestinationPath]; ok {
+		return nil, fmt.Errorf("%s (job %s): %w", job.DestinationPath, id, ErrDestinationBusy)
+	}
+	jt.active[job.DestinationPath] = job.ID
+	return func() {
+		jt.mu.Lock()
+		delete(jt.active, job.DestinationPath)
+		jt.mu.Unlock()
+	}, nil
 }
 
 // InitJob initializes a job in the store and returns a tracker for that job
@@ -47,14 +105,52 @@ func (jt *JobTracker) InitJob(job TransferJob) error {
 		ID:               job.ID,
 		SourcePath:       job.SourcePath,
 		DestinationPath:  job.DestinationPath,
+		VersionID:        job.VersionID,
+		Target:           job.Target,
 		State:            store.StatePending,
 		BytesTransferred: 0,
 		TotalBytes:       totalBytes,
 	}
+	if job.FileInfo != nil {
+		record.SourceSize = job.FileInfo.Size()
+		record.SourceModTime = job.FileInfo.ModTime()
+	}
 
 	return jt.store.SaveJob(record)
 }
 
+// IsCompleted reports whether a job has been recorded as completed
+func (jt *JobTracker) IsCompleted(jobID string) (bool, error) {
+	record, err := jt.store.GetJob(jobID)
+	if errors.Is(err, store.ErrJobNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return record.State == store.StateCompleted, nil
+}
+
+// CompletedUnchanged reports whether an earlier run completed job to the
+// same destination and the source still has the size and modification time
+// it had then, so the job need not be copied again.
+func (jt *JobTracker) CompletedUnchanged(job TransferJob) (bool, error) {
+	if job.FileInfo == nil {
+		return false, nil
+	}
+	record, err := jt.store.GetJob(job.ID)
+	if errors.Is(err, store.ErrJobNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return record.State == store.StateCompleted &&
+		record.DestinationPath == job.DestinationPath &&
+		record.SourceSize == job.FileInfo.Size() &&
+		record.SourceModTime.Equal(job.FileInfo.ModTime()), nil
+}
+
 // MarkInProgress updates a job's state to InProgress
 func (jt *JobTracker) MarkInProgress(jobID string) error {
 	record, err := jt.store.GetJob(jobID)
@@ -73,6 +169,7 @@ func (jt *JobTracker) MarkCompleted(jobID string) error {
 	}
 	record.State = store.StateCompleted
 	record.BytesTransferred = record.TotalBytes // Ensure it matches
+	record.UploadID, record.UploadPartSize, record.UploadParts = "", 0, nil
 	return jt.store.SaveJob(record)
 }
 
@@ -89,21 +186,221 @@ func (jt *JobTracker) MarkFailed(jobID string, err error) error {
 	return jt.store.SaveJob(record)
 }
 
-// TrackedWriter wraps an io.Writer to track bytes written and checkpoint progress
+// MarkInconsistent updates a job's state to Inconsistent, recording why its
+// copy may not match any one version of the source
+func (jt *JobTracker) MarkInconsistent(jobID string, err error) error {
+	record, getErr := jt.store.GetJob(jobID)
+	if getErr != nil {
+		return getErr
+	}
+	record.State = store.StateInconsistent
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return jt.store.SaveJob(record)
+}
+
+// MarkWaitingRestore updates a job's state to WaitingRestore, recording the
+// archive storage class its source is restored from
+func (jt *JobTracker) MarkWaitingRestore(jobID, storageClass string) error {
+	record, err := jt.store.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	record.State = store.StateWaitingRestore
+	record.StorageClass = storageClass
+	return jt.store.SaveJob(record)
+}
+
+// MarkMetadataError records that a job's metadata could not be applied,
+// without changing its state
+func (jt *JobTracker) MarkMetadataError(jobID string, err error) error {
+	record, getErr := jt.store.GetJob(jobID)
+	if getErr != nil {
+		return getErr
+	}
+	record.MetadataError = err.Error()
+	return jt.store.SaveJob(record)
+}
+
+// RecordProviderChecksum stores the checksum the destination reported for a
+// job it copied server-side
+func (jt *JobTracker) RecordProviderChecksum(jobID, checksum string) error {
+	record, err := jt.store.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	record.ProviderChecksum = checksum
+	return jt.store.SaveJob(record)
+}
+
+// RecordChecksum stores the checksum a job's content was verified with
+// while it was streamed
+func (jt *JobTracker) RecordChecksum(jobID, checksum string) error {
+	record, err := jt.store.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	record.Checksum = checksum
+	return jt.store.SaveJob(record)
+}
+
+// RecordMTimeAdjustment notes an out-of-range source modification time on
+// a job
+func (jt *JobTracker) RecordMTimeAdjustment(jobID, adjustment string) error {
+	record, err := jt.store.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	record.MTimeAdjustment = adjustment
+	return jt.store.SaveJob(record)
+}
+
+// RecordUpload checkpoints the multipart upload of a job's destination
+func (jt *JobTracker) RecordUpload(jobID string, cp provider.UploadCheckpoint) error {
+	record, err := jt.store.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	record.UploadID = cp.UploadID
+	record.UploadPartSize = cp.PartSize
+	// The stored parts are what a resume continues from, so they are the
+	// bytes the destination has committed
+	record.BytesTransferred = cp.Offset()
+	if record.TotalBytes > 0 {
+		record.BytesTransferred = min(record.BytesTransferred, record.TotalBytes)
+	}
+	record.UploadParts = make([]store.UploadPart, len(cp.Parts))
+	for i, part := range cp.Parts {
+		record.UploadParts[i] = store.UploadPart{Number: part.Number, ETag: part.ETag, Checksum: part.Checksum}
+	}
+	return jt.store.SaveJob(record)
+}
+
+// RecordedUploads returns the IDs of the multipart uploads checkpointed for
+// resuming, which must not be aborted as stale. Stores that cannot list
+// their jobs report none.
+func (jt *JobTracker) RecordedUploads() (map[string]bool, error) {
+	lister, ok := jt.store.(interface {
+		ForEachJob(fn func(*store.JobRecord) error) error
+	})
+	if !ok {
+		return nil, nil
+	}
+	ids := make(map[string]bool)
+	err := lister.ForEachJob(func(record *store.JobRecord) error {
+		if record.UploadID != "" {
+			ids[record.UploadID] = true
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// Failed returns a job for every file recorded as Failed or Inconsistent,
+// for retrying them without walking the source again. Jobs carry no FileInfo; see
+// EnsureFileInfo. Versions replayed by ReplayVersions are left out, as
+// they cannot be retried on their own, including those recorded before
+// versions were marked, whose IDs extend their file's source path. The
+// jobs of replicas keep their Target; see FanOut.Primary. Stores that
+// cannot list their jobs report none.
+func (jt *JobTracker) Failed() ([]TransferJob, error) {
+	lister, ok := jt.store.(interface {
+		ForEachJob(fn func(*store.JobRecord) error) error
+	})
+	if !ok {
+		return nil, nil
+	}
+	var jobs []TransferJob
+	err := lister.ForEachJob(func(record *store.JobRecord) error {
+		legacyVersion := record.ID != record.SourcePath && strings.HasPrefix(record.ID, record.SourcePath+"@")
+		retry := record.State == store.StateFailed || record.State == store.StateInconsistent
+		if retry && record.VersionID == "" && !legacyVersion {
+			jobs = append(jobs, TransferJob{
+				ID:              record.ID,
+				SourcePath:      record.SourcePath,
+				DestinationPath: record.DestinationPath,
+				Target:          record.Target,
+			})
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// uploadCheckpoint returns the multipart upload recorded for a job, or nil
+// if there is none.
+func uploadCheckpoint(record *store.JobRecord) *provider.UploadCheckpoint {
+	if record.UploadID == "" {
+		return nil
+	}
+	cp := &provider.UploadCheckpoint{UploadID: record.UploadID, PartSize: record.UploadPartSize}
+	for _, part := range record.UploadParts {
+		cp.Parts = append(cp.Parts, provider.UploadedPart{Number: part.Number, ETag: part.ETag, Checksum: part.Checksum})
+	}
+	return cp
+}
+
+// TrackedReader wraps a job's source to count the bytes read from it, which
+// its TrackedWriter records alongside those the destination committed
+type TrackedReader struct {
+	io.Reader
+	n atomic.Int64
+}
+
+// NewTrackedReader creates a TrackedReader for a source read from
+// startBytes on
+func NewTrackedReader(r io.Reader, startBytes int64) *TrackedReader {
+	tr := &TrackedReader{Reader: r}
+	tr.n.Store(startBytes)
+	return tr
+}
+
+// Read implements io.Reader and counts the bytes read
+func (tr *TrackedReader) Read(p []byte) (int, error) {
+	n, err := tr.Reader.Read(p)
+	tr.n.Add(int64(n))
+	return n, err
+}
+
+// Unwrap returns the wrapped reader, for provider.SourceFile.
+func (tr *TrackedReader) Unwrap() io.Reader { return tr.Reader }
+
+// Sent counts bytes copied from the source in the kernel as read.
+func (tr *TrackedReader) Sent(n int64) error {
+	tr.n.Add(n)
+	return nil
+}
+
+// BytesRead returns the offset in the source read up to
+func (tr *TrackedReader) BytesRead() int64 {
+	return tr.n.Load()
+}
+
+// TrackedWriter wraps an io.Writer to track bytes written and checkpoint
+// progress. Writers that acknowledge bytes before storing them, such as S3
+// uploads, are checkpointed at the bytes they report committed (see
+// provider.Committed), so a resume never skips bytes the destination lost.
 type TrackedWriter struct {
 	io.Writer
 	tracker *JobTracker
 	jobID   string
+	source  *TrackedReader
 
 	mu              sync.Mutex
 	bytesWritten    int64
 	lastCheckpoint  int64
 	lastCheckpointT time.Time
+
+	// head hashes the first FingerprintHeadSize bytes of the file so a
+	// later resume can check the source is unchanged. It is nil when the
+	// writer starts mid-file.
+	head hash.Hash64
 }
 
 // NewTrackedWriter creates a new TrackedWriter
 func (jt *JobTracker) NewTrackedWriter(w io.Writer, jobID string, startBytes int64) *TrackedWriter {
-	return &TrackedWriter{
+	tw := &TrackedWriter{
 		Writer:          w,
 		tracker:         jt,
 		jobID:           jobID,
@@ -111,6 +408,10 @@ func (jt *JobTracker) NewTrackedWriter(w io.Writer, jobID string, startBytes int
 		lastCheckpoint:  startBytes,
 		lastCheckpointT: time.Now(),
 	}
+	if startBytes == 0 {
+		tw.head, _ = NewHash64(AlgorithmXXH3)
+	}
+	return tw
 }
 
 // Write implements io.Writer and checkpoints progress
@@ -118,23 +419,77 @@ func (tw *TrackedWriter) Write(p []byte) (int, error) {
 	n, err := tw.Writer.Write(p)
 	if n > 0 {
 		tw.mu.Lock()
-		tw.bytesWritten += int64(n)
-
-		needsCheckpoint := false
-		if tw.bytesWritten-tw.lastCheckpoint >= tw.tracker.config.BytesInterval {
-			needsCheckpoint = true
-		} else if time.Since(tw.lastCheckpointT) >= tw.tracker.config.TimeInterval {
-			needsCheckpoint = true
+		if tw.head != nil && tw.bytesWritten < FingerprintHeadSize {
+			tw.head.Write(p[:min(int64(n), FingerprintHeadSize-tw.bytesWritten)])
 		}
-
-		currentBytes := tw.bytesWritten
 		tw.mu.Unlock()
+		tw.advance(int64(n))
+	}
+	return n, err
+}
+
+// advance counts n bytes written and checkpoints progress when due.
+func (tw *TrackedWriter) advance(n int64) {
+	tw.mu.Lock()
+	tw.bytesWritten += n
+
+	needsCheckpoint := false
+	if tw.bytesWritten-tw.lastCheckpoint >= tw.tracker.config.BytesInterval {
+		needsCheckpoint = true
+	} else if time.Since(tw.lastCheckpointT) >= tw.tracker.config.TimeInterval {
+		needsCheckpoint = true
+	}
+
+	currentBytes := tw.bytesWritten
+	tw.mu.Unlock()
+
+	if needsCheckpoint {
+		tw.checkpoint(currentBytes)
+	}
+}
+
+// SetSource records the source the writer is fed from, whose bytes read
+// are checkpointed with the job.
+func (tw *TrackedWriter) SetSource(r *TrackedReader) {
+	tw.source = r
+}
 
-		if needsCheckpoint {
-			tw.checkpoint(currentBytes)
+// SendFile copies from src in the kernel through the wrapped writer and
+// tracks the bytes as Write does. While the head of the file is being
+// fingerprinted it is hashed from src with a positioned read, which leaves
+// src's offset where the copy starts.
+func (tw *TrackedWriter) SendFile(ctx context.Context, src *os.File, n int64) (int64, error) {
+	if _, ok := tw.Writer.(provider.FileSender); !ok {
+		return 0, provider.ErrNotSupported
+	}
+	tw.mu.Lock()
+	hashHead := tw.head != nil && tw.bytesWritten < FingerprintHeadSize
+	written := tw.bytesWritten
+	tw.mu.Unlock()
+	var head []byte
+	if hashHead {
+		offset, err := src.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, provider.ErrNotSupported
 		}
+		head = make([]byte, min(n, FingerprintHeadSize-written))
+		k, err := src.ReadAt(head, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		head = head[:k]
 	}
-	return n, err
+
+	m, err := provider.SendFile(ctx, tw.Writer, src, n)
+	if m > 0 {
+		tw.mu.Lock()
+		if hashHead {
+			tw.head.Write(head[:min(int64(len(head)), m)])
+		}
+		tw.mu.Unlock()
+		tw.advance(m)
+	}
+	return m, err
 }
 
 func (tw *TrackedWriter) checkpoint(bytes int64) {
@@ -142,6 +497,20 @@ func (tw *TrackedWriter) checkpoint(bytes int64) {
 	record, err := tw.tracker.store.GetJob(tw.jobID)
 	if err == nil {
 		record.BytesTransferred = bytes
+		if committed, ok := provider.Committed(tw.Writer); ok {
+			record.BytesTransferred = min(bytes, committed)
+		}
+		if tw.source != nil {
+			// Every byte written was read, though a kernel copy counts
+			// its reads only after its writes
+			record.BytesRead = max(tw.source.BytesRead(), bytes)
+		}
+		tw.mu.Lock()
+		if tw.head != nil {
+			record.HeadSize = min(bytes, FingerprintHeadSize)
+			record.HeadHash = FormatChecksum(tw.head.Sum64())
+		}
+		tw.mu.Unlock()
 		// Ignore save error as it's just a checkpoint
 		_ = tw.tracker.store.SaveJob(record)
 
@@ -152,6 +521,13 @@ func (tw *TrackedWriter) checkpoint(bytes int64) {
 	}
 }
 
+// Checkpoint records the bytes written so far at once, rather than when
+// the next checkpoint is due, e.g. for a copy given up on because its run
+// was interrupted, so the next run resumes from them.
+func (tw *TrackedWriter) Checkpoint() {
+	tw.checkpoint(tw.BytesWritten())
+}
+
 // BytesWritten returns the total number of bytes written
 func (tw *TrackedWriter) BytesWritten() int64 {
 	tw.mu.Lock()
@@ -2,9 +2,12 @@ package engine
 
 import (
 	"bytes"
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/franksops/gofast/provider"
 	"github.com/franksops/gofast/store"
 )
 
@@ -25,6 +28,15 @@ func (m *MockStore) GetJob(id string) (*store.JobRecord, error) {
 	return job, nil
 }
 
+func (m *MockStore) FindJob(sourcePath, destinationPath, target string) (*store.JobRecord, error) {
+	for _, job := range m.Jobs {
+		if job.SourcePath == sourcePath && job.DestinationPath == destinationPath && job.Target == target && job.VersionID == "" {
+			return job, nil
+		}
+	}
+	return nil, store.ErrJobNotFound
+}
+
 func (m *MockStore) Close() error { return nil }
 
 func TestJobTracker(t *testing.T) {
@@ -67,6 +79,35 @@ func TestJobTracker(t *testing.T) {
 	if record.State != store.StateCompleted {
 		t.Errorf("Expected state %s, got %s", store.StateCompleted, record.State)
 	}
+
+	err = tracker.MarkMetadataError("test-job", errors.New("chown: operation not permitted"))
+	if err != nil {
+		t.Fatalf("Failed to mark metadata error: %v", err)
+	}
+	if record.State != store.StateCompleted || record.MetadataError == "" {
+		t.Errorf("Expected a completed job with a metadata error, got %s %q", record.State, record.MetadataError)
+	}
+
+	if err := tracker.RecordProviderChecksum("test-job", "crc32c:c99465aa"); err != nil {
+		t.Fatalf("Failed to record provider checksum: %v", err)
+	}
+	if record.ProviderChecksum != "crc32c:c99465aa" {
+		t.Errorf("Expected provider checksum to be recorded, got %q", record.ProviderChecksum)
+	}
+
+	if err := tracker.RecordChecksum("test-job", "crc64:6c40d2e8d5bd0c8d"); err != nil {
+		t.Fatalf("Failed to record checksum: %v", err)
+	}
+	if record.Checksum != "crc64:6c40d2e8d5bd0c8d" {
+		t.Errorf("Expected checksum to be recorded, got %q", record.Checksum)
+	}
+
+	if err := tracker.RecordMTimeAdjustment("test-job", "mtime is in the future; preserved"); err != nil {
+		t.Fatalf("Failed to record mtime adjustment: %v", err)
+	}
+	if record.MTimeAdjustment == "" {
+		t.Error("Expected the mtime adjustment to be recorded")
+	}
 }
 
 func TestTrackedWriter_Checkpointing(t *testing.T) {
@@ -111,3 +152,230 @@ func TestTrackedWriter_Checkpointing(t *testing.T) {
 		t.Errorf("Expected 11 bytes transferred due to checkpoint, got %d", record.BytesTransferred)
 	}
 }
+
+func TestTrackedWriter_Checkpoint(t *testing.T) {
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, CheckpointConfig{BytesInterval: 100, TimeInterval: time.Hour})
+	if err := tracker.InitJob(TransferJob{ID: "job"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tw := tracker.NewTrackedWriter(new(bytes.Buffer), "job", 0)
+	if _, err := tw.Write([]byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := mockStore.GetJob("job"); record.BytesTransferred != 0 {
+		t.Fatalf("Expected no checkpoint due yet, got %d bytes", record.BytesTransferred)
+	}
+	// An interrupted copy records its progress at once
+	tw.Checkpoint()
+	if record, _ := mockStore.GetJob("job"); record.BytesTransferred != 5 {
+		t.Errorf("Expected 5 bytes checkpointed, got %d", record.BytesTransferred)
+	}
+}
+
+// committingWriter stores writes only once they are committed.
+type committingWriter struct {
+	bytes.Buffer
+	committed int64
+}
+
+func (w *committingWriter) Committed() int64 { return w.committed }
+
+func TestTrackedWriter_Committed(t *testing.T) {
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, CheckpointConfig{BytesInterval: 10, TimeInterval: time.Hour})
+	if err := tracker.InitJob(TransferJob{ID: "job", FileInfo: mockFileInfo{name: "f", size: 100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &committingWriter{}
+	src := NewTrackedReader(bytes.NewReader(make([]byte, 100)), 0)
+	tw := tracker.NewTrackedWriter(dst, "job", 0)
+	tw.SetSource(src)
+
+	buf := make([]byte, 30)
+	n, _ := src.Read(buf)
+	dst.committed = 10
+	tw.Write(buf[:n])
+
+	record, _ := mockStore.GetJob("job")
+	if record.BytesTransferred != 10 || record.BytesRead != 30 {
+		t.Errorf("Checkpointed %d bytes transferred and %d read, want 10 and 30", record.BytesTransferred, record.BytesRead)
+	}
+
+	// A stored part confirms the bytes it holds
+	if err := tracker.RecordUpload("job", provider.UploadCheckpoint{UploadID: "u", PartSize: 20, Parts: []provider.UploadedPart{{Number: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	record, _ = mockStore.GetJob("job")
+	if record.BytesTransferred != 20 {
+		t.Errorf("Expected the stored part to confirm 20 bytes, got %d", record.BytesTransferred)
+	}
+}
+
+func TestJobTracker_RecordedUploads(t *testing.T) {
+	boltStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+	tracker := NewJobTracker(boltStore, DefaultCheckpointConfig)
+
+	for _, id := range []string{"a", "b"} {
+		if err := tracker.InitJob(TransferJob{ID: id, SourcePath: id, DestinationPath: id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tracker.RecordUpload(id, provider.UploadCheckpoint{UploadID: "upload-" + id, PartSize: 5 << 20}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tracker.MarkCompleted("b")
+
+	ids, err := tracker.RecordedUploads()
+	if err != nil || len(ids) != 1 || !ids["upload-a"] {
+		t.Errorf("Expected only the unfinished upload, got %v, %v", ids, err)
+	}
+
+	// Stores that cannot list their jobs report none
+	mock := NewJobTracker(&MockStore{Jobs: make(map[string]*store.JobRecord)}, DefaultCheckpointConfig)
+	if ids, err := mock.RecordedUploads(); err != nil || len(ids) != 0 {
+		t.Errorf("Expected no uploads, got %v, %v", ids, err)
+	}
+}
+
+func TestJobTracker_Failed(t *testing.T) {
+	boltStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+	tracker := NewJobTracker(boltStore, DefaultCheckpointConfig)
+
+	for _, id := range []string{"/src/a", "/src/b", "/src/c", "/src/d"} {
+		if err := tracker.InitJob(TransferJob{ID: id, SourcePath: id, DestinationPath: "/dst" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A replayed version has an ID of its own and is not retried alone,
+	// whether recorded with its version or, by older versions, without
+	version := TransferJob{ID: versionJobID("/src/c", "v1"), SourcePath: "/src/c", DestinationPath: "/dst/src/c", VersionID: "v1"}
+	legacy := TransferJob{ID: versionJobID("/src/c", "v0"), SourcePath: "/src/c", DestinationPath: "/dst/src/c"}
+	for _, job := range []TransferJob{version, legacy} {
+		if err := tracker.InitJob(job); err != nil {
+			t.Fatal(err)
+		}
+		tracker.MarkFailed(job.ID, errors.New("boom"))
+	}
+	tracker.MarkFailed("/src/a", errors.New("boom"))
+	tracker.MarkCompleted("/src/b")
+	// Copies of sources that changed meanwhile are retried too
+	tracker.MarkInconsistent("/src/d", &SourceModifiedError{Path: "/src/d", Reason: "size changed"})
+
+	jobs, err := tracker.Failed()
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("Expected two failed jobs, got %+v, %v", jobs, err)
+	}
+	if job := jobs[0]; job.ID != "/src/a" || job.SourcePath != "/src/a" || job.DestinationPath != "/dst/src/a" || job.FileInfo != nil {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if job := jobs[1]; job.ID != "/src/d" {
+		t.Errorf("Expected the inconsistent job to be retried, got %+v", job)
+	}
+	if record, _ := boltStore.GetJob("/src/d"); record.State != store.StateInconsistent || record.Error == "" {
+		t.Errorf("Unexpected inconsistent record %+v", record)
+	}
+}
+
+func TestJobTracker_CompletedUnchanged(t *testing.T) {
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, DefaultCheckpointConfig)
+	modTime := time.Unix(1700000000, 0)
+	job := TransferJob{ID: "a", SourcePath: "a", DestinationPath: "a", FileInfo: mockFileInfo{name: "a", size: 10, modTime: modTime}}
+
+	if done, err := tracker.CompletedUnchanged(job); err != nil || done {
+		t.Fatalf("Expected an unknown job to be copied, got %v, %v", done, err)
+	}
+	if err := tracker.InitJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if done, _ := tracker.CompletedUnchanged(job); done {
+		t.Fatal("Expected an unfinished job to be copied")
+	}
+	tracker.MarkCompleted(job.ID)
+	if done, err := tracker.CompletedUnchanged(job); err != nil || !done {
+		t.Fatalf("Expected a completed job to be skipped, got %v, %v", done, err)
+	}
+
+	changed := []TransferJob{job, job, job}
+	changed[0].FileInfo = mockFileInfo{name: "a", size: 11, modTime: modTime}
+	changed[1].FileInfo = mockFileInfo{name: "a", size: 10, modTime: modTime.Add(time.Second)}
+	changed[2].DestinationPath = "elsewhere/a"
+	for i, c := range changed {
+		if done, _ := tracker.CompletedUnchanged(c); done {
+			t.Errorf("Case %d: expected a changed job to be copied again", i)
+		}
+	}
+}
+
+func TestJobTracker_Identify(t *testing.T) {
+	boltStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+	tracker := NewJobTracker(boltStore, DefaultCheckpointConfig)
+
+	first := TransferJob{ID: NewJobID(), SourcePath: "/src/a", DestinationPath: "/dst/a"}
+	if job, err := tracker.Identify(first); err != nil || job.ID != first.ID {
+		t.Fatalf("Expected a new job to keep its ID, got %s, %v", job.ID, err)
+	}
+	if err := tracker.InitJob(first); err != nil {
+		t.Fatal(err)
+	}
+	tracker.MarkCompleted(first.ID)
+
+	// A later walk finds the same file under the recorded ID
+	again := TransferJob{ID: NewJobID(), SourcePath: "/src/a", DestinationPath: "/dst/a"}
+	if job, err := tracker.Identify(again); err != nil || job.ID != first.ID {
+		t.Errorf("Expected the recorded ID %s, got %s, %v", first.ID, job.ID, err)
+	}
+	// The same source to another destination is another job
+	other := TransferJob{ID: NewJobID(), SourcePath: "/src/a", DestinationPath: "/other/a"}
+	if job, _ := tracker.Identify(other); job.ID != other.ID {
+		t.Errorf("Expected a job for another destination to keep its ID, got %s", job.ID)
+	}
+
+	// Jobs recorded under their source path by older versions are found
+	if err := tracker.InitJob(TransferJob{ID: "/src/legacy", SourcePath: "/src/legacy", DestinationPath: "/dst/legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	boltStore.SaveJob(&store.JobRecord{ID: "/src/old", SourcePath: "/src/old", DestinationPath: "/dst/old"})
+	legacy := TransferJob{ID: NewJobID(), SourcePath: "/src/old", DestinationPath: "/dst/old"}
+	if job, _ := tracker.Identify(legacy); job.ID != "/src/old" {
+		t.Errorf("Expected the legacy ID, got %s", job.ID)
+	}
+}
+
+func TestJobTracker_Claim(t *testing.T) {
+	tracker := NewJobTracker(&MockStore{Jobs: make(map[string]*store.JobRecord)}, DefaultCheckpointConfig)
+	a := TransferJob{ID: NewJobID(), SourcePath: "/src1/f", DestinationPath: "/dst/f"}
+	b := TransferJob{ID: NewJobID(), SourcePath: "/src2/f", DestinationPath: "/dst/f"}
+
+	release, err := tracker.Claim(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.Claim(b); !errors.Is(err, ErrDestinationBusy) {
+		t.Errorf("Expected ErrDestinationBusy for a second job, got %v", err)
+	}
+	if _, err := tracker.Claim(a); !errors.Is(err, ErrDestinationBusy) {
+		t.Errorf("Expected ErrDestinationBusy for the same job queued twice, got %v", err)
+	}
+	release()
+	if release, err := tracker.Claim(b); err != nil {
+		t.Errorf("Expected the destination free once released, got %v", err)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Built-in transform names.
+const (
+	TransformGzip    = "gzip"
+	TransformGunzip  = "gunzip"
+	TransformEncrypt = "encrypt"
+	TransformDecrypt = "decrypt"
+)
+
+// ReaderStage wraps the stream read from a job's source.
+type ReaderStage func(job TransferJob, r io.Reader) (io.Reader, error)
+
+// WriterStage wraps the stream written to a job's destination. The writer
+// it returns passes its output on to w; closing it flushes what it
+// buffered, without closing w.
+type WriterStage func(job TransferJob, w io.Writer) (io.WriteCloser, error)
+
+// Transform is one stage of a Pipeline: a middleware on the source side,
+// on the destination side, or both.
+type Transform struct {
+	Name   string
+	Reader ReaderStage
+	Writer WriterStage
+}
+
+// Pipeline is an ordered list of transforms that a job's bytes pass
+// through between OpenRead and OpenWrite, such as compression or
+// encryption. Reader stages run first, in order, as the source is read;
+// writer stages then run in order as the destination is written.
+//
+// Transformed files are streamed from start to end: they are never copied
+// server-side, deduplicated, bundled, split into chunks, read sparse,
+// resumed from a checkpoint or checksummed, as none of these sees the
+// bytes the destination receives.
+type Pipeline []Transform
+
+// Names returns the names of p's stages.
+func (p Pipeline) Names() []string {
+	names := make([]string, len(p))
+	for i, t := range p {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// Apply wraps r and w in p's stages for job and returns the streams to copy
+// between. Closing the returned closer flushes the writer stages, which
+// must happen after the copy and before w is closed.
+func (p Pipeline) Apply(job TransferJob, r io.Reader, w io.Writer) (io.Reader, io.Writer, io.Closer, error) {
+	var err error
+	for _, t := range p {
+		if t.Reader == nil {
+			continue
+		}
+		if r, err = t.Reader(job, r); err != nil {
+			return nil, nil, nil, fmt.Errorf("transform %s: %w", t.Name, err)
+		}
+	}
+
+	// The first writer stage sees the bytes first, so it wraps the others
+	var stages pipelineClosers
+	for i := len(p) - 1; i >= 0; i-- {
+		t := p[i]
+		if t.Writer == nil {
+			continue
+		}
+		wc, err := t.Writer(job, w)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("transform %s: %w", t.Name, err)
+		}
+		stages = append(stages, namedCloser{name: t.Name, Closer: wc})
+		w = wc
+	}
+	// Stages are flushed from the first to the last
+	for i, j := 0, len(stages)-1; i < j; i, j = i+1, j-1 {
+		stages[i], stages[j] = stages[j], stages[i]
+	}
+	return r, w, stages, nil
+}
+
+type namedCloser struct {
+	name string
+	io.Closer
+}
+
+// pipelineClosers flushes writer stages in order, stopping at the first
+// that fails.
+type pipelineClosers []namedCloser
+
+func (c pipelineClosers) Close() error {
+	for _, s := range c {
+		if err := s.Close(); err != nil {
+			return fmt.Errorf("transform %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// Transforms maps the stage names used in transform rules to transforms.
+// Programs embedding the engine add their own stages to it before parsing
+// rules.
+type Transforms map[string]Transform
+
+// BuiltinTransforms returns the built-in stages: gzip compresses what is
+// written to the destination and gunzip decompresses what is read from the
+// source. With a key of EncryptionKeySize bytes, encrypt and decrypt
+// encrypt and decrypt the stream with AES-256-GCM, in the format
+// NewEncryptWriter describes.
+func BuiltinTransforms(key []byte) Transforms {
+	t := Transforms{
+		TransformGzip: {Name: TransformGzip, Writer: func(_ TransferJob, w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}},
+		TransformGunzip: {Name: TransformGunzip, Reader: func(_ TransferJob, r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}},
+	}
+	if key != nil {
+		t[TransformEncrypt] = Transform{Name: TransformEncrypt, Writer: func(_ TransferJob, w io.Writer) (io.WriteCloser, error) {
+			return NewEncryptWriter(w, key)
+		}}
+		t[TransformDecrypt] = Transform{Name: TransformDecrypt, Reader: func(_ TransferJob, r io.Reader) (io.Reader, error) {
+			return NewDecryptReader(r, key)
+		}}
+	}
+	return t
+}
+
+// errNoTransformKey is returned for encrypt and decrypt stages given
+// without a key.
+var errNoTransformKey = errors.New("needs an encryption key")
+
+// TransformRule applies Pipeline to files whose base name matches the glob
+// Pattern, or to every file if Pattern is empty.
+type TransformRule struct {
+	Pattern  string
+	Pipeline Pipeline
+}
+
+// TransformRules is an ordered list of rules; the first match wins.
+type TransformRules []TransformRule
+
+// ParseTransformRules parses a comma-separated list of rules, each a
+// pipeline of stage names from stages joined by '+', optionally preceded
+// by a glob and '=': "gzip+encrypt" transforms every file, and
+// "*.log=gzip,*.csv=gzip+encrypt" only logs and CSV files.
+func ParseTransformRules(spec string, stages Transforms) (TransformRules, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var rules TransformRules
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		pattern, names, ok := strings.Cut(part, "=")
+		if !ok {
+			pattern, names = "", part
+		} else if pattern == "" {
+			return nil, fmt.Errorf("invalid transform rule %q, expected glob=stage+stage", part)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob in rule %q: %w", part, err)
+		}
+
+		var pipeline Pipeline
+		for _, name := range strings.Split(names, "+") {
+			name = strings.TrimSpace(name)
+			t, ok := stages[name]
+			if !ok {
+				if name == TransformEncrypt || name == TransformDecrypt {
+					return nil, fmt.Errorf("transform %s %w", name, errNoTransformKey)
+				}
+				return nil, fmt.Errorf("unknown transform %q (want one of %s)", name, strings.Join(stages.names(), ", "))
+			}
+			pipeline = append(pipeline, t)
+		}
+		rules = append(rules, TransformRule{Pattern: pattern, Pipeline: pipeline})
+	}
+	return rules, nil
+}
+
+func (t Transforms) names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Match returns the pipeline for filePath, or nil if no rule applies.
+func (r TransformRules) Match(filePath string) Pipeline {
+	name := path.Base(strings.ReplaceAll(filePath, "\\", "/"))
+	for _, rule := range r {
+		if rule.Pattern == "" {
+			return rule.Pipeline
+		}
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Pipeline
+		}
+	}
+	return nil
+}
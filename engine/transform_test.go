@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseTransformRules(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, EncryptionKeySize)
+	rules, err := ParseTransformRules("*.log=gzip, *.csv=gzip+encrypt, gunzip", BuiltinTransforms(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"var/app.log", "gzip"},
+		{"data/table.csv", "gzip+encrypt"},
+		{"archive.gz", "gunzip"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(rules.Match(tt.path).Names(), "+"); got != tt.expect {
+			t.Errorf("Match(%q) = %q, want %q", tt.path, got, tt.expect)
+		}
+	}
+
+	if rules, _ := ParseTransformRules("*.log=gzip", BuiltinTransforms(nil)); rules.Match("a.txt") != nil {
+		t.Error("Expected files no rule matches to be left alone")
+	}
+
+	for _, spec := range []string{"=gzip", "*.log=zip", "*.log=gzip+", "[=gzip"} {
+		if _, err := ParseTransformRules(spec, BuiltinTransforms(nil)); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+	if _, err := ParseTransformRules("encrypt", BuiltinTransforms(nil)); !errors.Is(err, errNoTransformKey) {
+		t.Errorf("Expected encrypt without a key to be rejected, got %v", err)
+	}
+}
+
+func TestPipeline_Apply(t *testing.T) {
+	var order []string
+	stage := func(name string) Transform {
+		return Transform{Name: name, Writer: func(_ TransferJob, w io.Writer) (io.WriteCloser, error) {
+			return &suffixWriter{w: w, suffix: name, closed: &order}, nil
+		}}
+	}
+	upper := Transform{Name: "upper", Reader: func(_ TransferJob, r io.Reader) (io.Reader, error) {
+		data, err := io.ReadAll(r)
+		return strings.NewReader(strings.ToUpper(string(data))), err
+	}}
+
+	// Reader stages run before writer stages, each in order
+	var dst bytes.Buffer
+	r, w, flush, err := Pipeline{stage("a"), upper, stage("b")}.Apply(TransferJob{}, strings.NewReader("data"), &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := flush.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.String(); got != "DATAab" {
+		t.Errorf("Expected DATAab, got %q", got)
+	}
+	if got := strings.Join(order, ","); got != "a,b" {
+		t.Errorf("Expected stages flushed first to last, got %s", got)
+	}
+}
+
+// suffixWriter appends its suffix to the stream when closed.
+type suffixWriter struct {
+	w      io.Writer
+	suffix string
+	closed *[]string
+}
+
+func (s *suffixWriter) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+func (s *suffixWriter) Close() error {
+	*s.closed = append(*s.closed, s.suffix)
+	_, err := io.WriteString(s.w, s.suffix)
+	return err
+}
+
+func TestBuiltinTransforms_RoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, EncryptionKeySize)
+	stages := BuiltinTransforms(key)
+	data := bytes.Repeat([]byte("gofast transform "), 20000)
+
+	var packed bytes.Buffer
+	_, w, flush, err := Pipeline{stages[TransformGzip], stages[TransformEncrypt]}.Apply(TransferJob{}, nil, &packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := flush.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if packed.Len() >= len(data) || bytes.Contains(packed.Bytes(), []byte("gofast")) {
+		t.Fatalf("Expected compressed and encrypted output, got %d bytes", packed.Len())
+	}
+
+	var unpacked bytes.Buffer
+	r, w, flush, err := Pipeline{stages[TransformDecrypt], stages[TransformGunzip]}.Apply(TransferJob{}, &packed, &unpacked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatal(err)
+	}
+	flush.Close()
+	if !bytes.Equal(unpacked.Bytes(), data) {
+		t.Errorf("Round trip returned %d bytes, want %d", unpacked.Len(), len(data))
+	}
+
+	// A reader stage that cannot start fails the pipeline
+	if _, _, _, err := (Pipeline{stages[TransformGunzip]}).Apply(TransferJob{}, strings.NewReader("plain text, not gzip"), io.Discard); !errors.Is(err, gzip.ErrHeader) {
+		t.Errorf("Expected gunzip of plain text to fail, got %v", err)
+	}
+}
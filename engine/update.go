@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// DefaultModTimeWindow is the tolerance for comparing modification times,
+// covering filesystems and object stores that keep them to the second.
+const DefaultModTimeWindow = time.Second
+
+// CompareMode selects how -update decides a destination file is already
+// current.
+type CompareMode string
+
+const (
+	// CompareSizeMTime compares sizes and modification times, which is
+	// cheap but trusts the destination to keep mtimes.
+	CompareSizeMTime CompareMode = "size-mtime"
+	// CompareChecksum compares sizes and then content checksums, for
+	// destinations whose mtimes are unreliable.
+	CompareChecksum CompareMode = "checksum"
+)
+
+// ParseCompareMode validates a comparison mode as given on the command line.
+func ParseCompareMode(name string) (CompareMode, error) {
+	switch mode := CompareMode(name); mode {
+	case CompareSizeMTime, CompareChecksum:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported comparison mode %q (want size-mtime or checksum)", name)
+}
+
+// DestinationCurrent reports whether dst already holds job's file with the
+// source's size and a modification time within window of the source's, the
+// quick check rsync makes before copying. A missing destination is not
+// current.
+func DestinationCurrent(ctx context.Context, job TransferJob, dst provider.Provider, window time.Duration) (bool, error) {
+	info, err := sameSizeDestination(ctx, job, dst)
+	if info == nil || err != nil {
+		return false, err
+	}
+	return info.ModTime().Sub(job.FileInfo.ModTime()).Abs() <= window, nil
+}
+
+// DestinationMatches reports whether dst already holds job's file with the
+// source's size and content, ignoring modification times. The checksums
+// both providers store are compared when they use the same algorithm;
+// otherwise both files are read and hashed with XXH3 using buf. A missing
+// destination does not match.
+func DestinationMatches(ctx context.Context, job TransferJob, src, dst provider.Provider, buf []byte) (bool, error) {
+	info, err := sameSizeDestination(ctx, job, dst)
+	if info == nil || err != nil {
+		return false, err
+	}
+
+	match, comparable, err := CompareNativeChecksums(ctx, src, job.SourcePath, dst, job.DestinationPath)
+	if err != nil {
+		return false, err
+	}
+	if comparable {
+		return match, nil
+	}
+
+	h, err := NewHash64(AlgorithmXXH3)
+	if err != nil {
+		return false, err
+	}
+	srcSum, _, err := HashFile(ctx, src, job.SourcePath, h, buf)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash source %s: %w", job.SourcePath, err)
+	}
+	dstSum, _, err := HashFile(ctx, dst, job.DestinationPath, h, buf)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash destination %s: %w", job.DestinationPath, err)
+	}
+	return srcSum == dstSum, nil
+}
+
+// sameSizeDestination stats job's destination and returns its info if it
+// is a file of the source's size, or nil if job cannot be skipped.
+func sameSizeDestination(ctx context.Context, job TransferJob, dst provider.Provider) (provider.FileInfo, error) {
+	if job.FileInfo == nil || job.FileInfo.IsDir() {
+		return nil, nil
+	}
+	// Links are recreated rather than compared by their targets' content
+	if _, ok := provider.SymlinkTarget(job.FileInfo); ok {
+		return nil, nil
+	}
+	info, err := dst.Stat(ctx, job.DestinationPath)
+	if provider.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat destination %s: %w", job.DestinationPath, err)
+	}
+	if info.IsDir() || info.Size() != job.FileInfo.Size() {
+		return nil, nil
+	}
+	return info, nil
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestDestinationCurrent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dst := provider.NewLocalProvider(dir)
+	modTime := time.Unix(1700000000, 0)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("abc"), 0644)
+	os.Chtimes(filepath.Join(dir, "a.txt"), modTime, modTime)
+
+	job := func(size int64, mtime time.Time, dest string) TransferJob {
+		return TransferJob{ID: dest, SourcePath: dest, DestinationPath: dest, FileInfo: mockFileInfo{name: dest, size: size, modTime: mtime}}
+	}
+	tests := []struct {
+		name string
+		job  TransferJob
+		want bool
+	}{
+		{"same", job(3, modTime, "a.txt"), true},
+		{"within window", job(3, modTime.Add(-500*time.Millisecond), "a.txt"), true},
+		{"newer source", job(3, modTime.Add(time.Hour), "a.txt"), false},
+		{"older source", job(3, modTime.Add(-time.Hour), "a.txt"), false},
+		{"size differs", job(4, modTime, "a.txt"), false},
+		{"missing", job(3, modTime, "b.txt"), false},
+	}
+	for _, tt := range tests {
+		got, err := DestinationCurrent(ctx, tt.job, dst, DefaultModTimeWindow)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: expected %v, got %v, %v", tt.name, tt.want, got, err)
+		}
+	}
+}
+
+func TestDestinationMatches(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	write := func(dir, name, content string, mtime time.Time) {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		os.Chtimes(filepath.Join(dir, name), mtime, mtime)
+	}
+	write(srcDir, "same.txt", "abc", modTime)
+	write(dstDir, "same.txt", "abc", modTime.Add(time.Hour))
+	write(srcDir, "changed.txt", "abc", modTime)
+	write(dstDir, "changed.txt", "abd", modTime)
+	write(srcDir, "missing.txt", "abc", modTime)
+
+	local := func(dir string) provider.Provider { return provider.NewLocalProvider(dir) }
+	// Hiding the Checksummer forces both files to be read and hashed
+	hashed := func(dir string) provider.Provider { return struct{ provider.Provider }{provider.NewLocalProvider(dir)} }
+	for _, p := range []struct {
+		name string
+		open func(string) provider.Provider
+	}{{"native", local}, {"hashed", hashed}} {
+		src, dst := p.open(srcDir), p.open(dstDir)
+		for name, want := range map[string]bool{"same.txt": true, "changed.txt": false, "missing.txt": false} {
+			info, _ := src.Stat(ctx, name)
+			job := TransferJob{ID: name, SourcePath: name, DestinationPath: name, FileInfo: info}
+			got, err := DestinationMatches(ctx, job, src, dst, make([]byte, 4096))
+			if err != nil || got != want {
+				t.Errorf("%s %s: expected %v, got %v, %v", p.name, name, want, got, err)
+			}
+		}
+	}
+}
+
+func TestParseCompareMode(t *testing.T) {
+	for _, name := range []string{"size-mtime", "checksum"} {
+		if mode, err := ParseCompareMode(name); err != nil || string(mode) != name {
+			t.Errorf("ParseCompareMode(%q) = %q, %v", name, mode, err)
+		}
+	}
+	if _, err := ParseCompareMode("md5"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
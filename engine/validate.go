@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Supported format validator names.
+const (
+	FormatGzip    = "gzip"
+	FormatZip     = "zip"
+	FormatParquet = "parquet"
+)
+
+// FormatValidator checks the structural integrity of a file format while its
+// bytes stream to the destination. Byte checksums only prove the destination
+// matches the source; format validation also catches files that were already
+// corrupt, such as truncated archives.
+type FormatValidator interface {
+	// Write feeds the next chunk of the file. It never fails; problems are
+	// reported by Close so that validation cannot break a transfer midway.
+	io.Writer
+	// Close finishes validation and reports any corruption found.
+	Close() error
+}
+
+// NewFormatValidator returns a validator for the named format.
+func NewFormatValidator(format string) (FormatValidator, error) {
+	switch format {
+	case FormatGzip:
+		return newGzipValidator(), nil
+	case FormatZip:
+		return &footerValidator{format: FormatZip, tailSize: zipMaxTail, check: checkZipFooter}, nil
+	case FormatParquet:
+		return &footerValidator{format: FormatParquet, tailSize: 8, check: checkParquetFooter}, nil
+	}
+	return nil, fmt.Errorf("unknown validation format %q", format)
+}
+
+// ValidationRule applies the validator for Format to files whose base name
+// matches the glob Pattern.
+type ValidationRule struct {
+	Pattern string
+	Format  string
+}
+
+// ValidationRules is an ordered list of rules; the first match wins.
+type ValidationRules []ValidationRule
+
+// DefaultValidationRules covers the common extensions of each supported format.
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		{Pattern: "*.gz", Format: FormatGzip},
+		{Pattern: "*.tgz", Format: FormatGzip},
+		{Pattern: "*.zip", Format: FormatZip},
+		{Pattern: "*.jar", Format: FormatZip},
+		{Pattern: "*.parquet", Format: FormatParquet},
+	}
+}
+
+// ParseValidationRules parses a comma-separated list of glob=format pairs,
+// e.g. "*.gz=gzip,*.parquet=parquet". The value "auto" selects the defaults.
+func ParseValidationRules(spec string) (ValidationRules, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if spec == "auto" {
+		return DefaultValidationRules(), nil
+	}
+
+	var rules ValidationRules
+	for _, part := range strings.Split(spec, ",") {
+		pattern, format, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid validation rule %q, expected glob=format", part)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob in rule %q: %w", part, err)
+		}
+		if _, err := NewFormatValidator(format); err != nil {
+			return nil, err
+		}
+		rules = append(rules, ValidationRule{Pattern: pattern, Format: format})
+	}
+	return rules, nil
+}
+
+// Match returns the format to validate for filePath, if any rule applies.
+func (r ValidationRules) Match(filePath string) (string, bool) {
+	name := path.Base(strings.ReplaceAll(filePath, "\\", "/"))
+	for _, rule := range r {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Format, true
+		}
+	}
+	return "", false
+}
+
+// NewValidator returns a validator for filePath, or nil if no rule matches.
+func (r ValidationRules) NewValidator(filePath string) FormatValidator {
+	format, ok := r.Match(filePath)
+	if !ok {
+		return nil
+	}
+	v, _ := NewFormatValidator(format)
+	return v
+}
+
+// gzipValidator decompresses the stream in a background goroutine, which
+// verifies every member's CRC32 and length trailer.
+type gzipValidator struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newGzipValidator() *gzipValidator {
+	pr, pw := io.Pipe()
+	v := &gzipValidator{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		err := decompressAll(pr)
+		// Keep draining so Write never blocks after the decoder gave up.
+		io.Copy(io.Discard, pr)
+		v.done <- err
+	}()
+	return v
+}
+
+func decompressAll(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return err
+	}
+	return zr.Close()
+}
+
+func (v *gzipValidator) Write(p []byte) (int, error) {
+	v.pw.Write(p)
+	return len(p), nil
+}
+
+func (v *gzipValidator) Close() error {
+	v.pw.Close()
+	if err := <-v.done; err != nil {
+		return fmt.Errorf("gzip validation failed: %w", err)
+	}
+	return nil
+}
+
+// footerValidator keeps the first and last bytes of the stream and checks a
+// format's magic numbers and footer on Close.
+type footerValidator struct {
+	format   string
+	tailSize int
+	check    func(head, tail []byte, size int64) error
+
+	head []byte
+	tail []byte
+	size int64
+}
+
+const footerHeadSize = 4
+
+func (v *footerValidator) Write(p []byte) (int, error) {
+	v.size += int64(len(p))
+
+	if need := footerHeadSize - len(v.head); need > 0 {
+		v.head = append(v.head, p[:min(need, len(p))]...)
+	}
+
+	if len(p) >= v.tailSize {
+		v.tail = append(v.tail[:0], p[len(p)-v.tailSize:]...)
+		return len(p), nil
+	}
+	v.tail = append(v.tail, p...)
+	if over := len(v.tail) - v.tailSize; over > 0 {
+		v.tail = append(v.tail[:0], v.tail[over:]...)
+	}
+	return len(p), nil
+}
+
+func (v *footerValidator) Close() error {
+	if err := v.check(v.head, v.tail, v.size); err != nil {
+		return fmt.Errorf("%s validation failed: %w", v.format, err)
+	}
+	return nil
+}
+
+var parquetMagic = []byte("PAR1")
+
+func checkParquetFooter(head, tail []byte, size int64) error {
+	if size < 12 {
+		return errors.New("file too small")
+	}
+	if !bytes.Equal(head, parquetMagic) {
+		return errors.New("missing leading magic")
+	}
+	if !bytes.Equal(tail[4:], parquetMagic) {
+		return errors.New("missing trailing magic")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLen+12 > size {
+		return fmt.Errorf("footer length %d exceeds file size %d", footerLen, size)
+	}
+	return nil
+}
+
+const (
+	zipEOCDSize = 22
+	// zipMaxTail covers the end of central directory record plus the
+	// largest possible archive comment.
+	zipMaxTail = zipEOCDSize + 0xffff
+)
+
+var zipEOCDSignature = []byte("PK\x05\x06")
+
+func checkZipFooter(head, tail []byte, size int64) error {
+	if size < zipEOCDSize {
+		return errors.New("file too small")
+	}
+
+	// Search backwards for the end of central directory record whose
+	// comment runs exactly to the end of the file.
+	for i := len(tail) - zipEOCDSize; i >= 0; i-- {
+		if !bytes.Equal(tail[i:i+4], zipEOCDSignature) {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20 : i+22]))
+		if i+zipEOCDSize+commentLen != len(tail) {
+			continue
+		}
+
+		cdSize := int64(binary.LittleEndian.Uint32(tail[i+12 : i+16]))
+		cdOffset := int64(binary.LittleEndian.Uint32(tail[i+16 : i+20]))
+		if cdOffset == 0xffffffff || cdSize == 0xffffffff {
+			// Zip64 archive; the real values live in the zip64 record.
+			return nil
+		}
+		eocdOffset := size - int64(len(tail)-i)
+		if cdOffset+cdSize > eocdOffset {
+			return fmt.Errorf("central directory (offset %d, size %d) overlaps end record at %d", cdOffset, cdSize, eocdOffset)
+		}
+		return nil
+	}
+	return errors.New("end of central directory record not found")
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+)
+
+// feed writes data to v in small chunks to exercise the streaming paths.
+func feed(v FormatValidator, data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), 7)
+		v.Write(data[:n])
+		data = data[n:]
+	}
+	return v.Close()
+}
+
+func gzipData(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(bytes.Repeat([]byte("gofast "), 1000))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipData(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("a.txt")
+	w.Write([]byte("hello zip"))
+	zw.SetComment("archive comment")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func parquetData() []byte {
+	footer := []byte("footer-metadata")
+	data := append([]byte("PAR1"), []byte("column chunks")...)
+	data = append(data, footer...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(footer)))
+	return append(data, "PAR1"...)
+}
+
+func TestFormatValidators(t *testing.T) {
+	gz := gzipData(t)
+	zp := zipData(t)
+	pq := parquetData()
+
+	tests := []struct {
+		name    string
+		format  string
+		data    []byte
+		wantErr bool
+	}{
+		{"gzip ok", FormatGzip, gz, false},
+		{"gzip truncated", FormatGzip, gz[:len(gz)-6], true},
+		{"gzip corrupt crc", FormatGzip, append(append([]byte{}, gz[:len(gz)-8]...), 0, 0, 0, 0, gz[len(gz)-4], gz[len(gz)-3], gz[len(gz)-2], gz[len(gz)-1]), true},
+		{"zip ok", FormatZip, zp, false},
+		{"zip truncated", FormatZip, zp[:len(zp)-10], true},
+		{"parquet ok", FormatParquet, pq, false},
+		{"parquet truncated", FormatParquet, pq[:len(pq)-2], true},
+		{"parquet bad footer length", FormatParquet, append(append([]byte{}, pq[:len(pq)-8]...), 0xff, 0xff, 0, 0, 'P', 'A', 'R', '1'), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewFormatValidator(tt.format)
+			if err != nil {
+				t.Fatalf("NewFormatValidator failed: %v", err)
+			}
+			err = feed(v, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validation error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+	rules, err := ParseValidationRules("*.gz=gzip, data-*.bin=parquet")
+	if err != nil {
+		t.Fatalf("ParseValidationRules failed: %v", err)
+	}
+
+	if format, ok := rules.Match("/logs/app.log.gz"); !ok || format != FormatGzip {
+		t.Errorf("expected gzip match, got %q %v", format, ok)
+	}
+	if format, ok := rules.Match("dir/data-01.bin"); !ok || format != FormatParquet {
+		t.Errorf("expected parquet match, got %q %v", format, ok)
+	}
+	if rules.NewValidator("notes.txt") != nil {
+		t.Error("expected no validator for unmatched file")
+	}
+
+	if _, err := ParseValidationRules("*.gz=rar"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := ParseValidationRules("*.gz"); err == nil {
+		t.Error("expected error for malformed rule")
+	}
+
+	auto, _ := ParseValidationRules("auto")
+	if _, ok := auto.Match("report.parquet"); !ok {
+		t.Error("expected default rules to match .parquet")
+	}
+}
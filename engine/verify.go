@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// VerifyProblem is what a verification found wrong with one path.
+type VerifyProblem string
+
+const (
+	// ProblemMissing means the file is not at the destination.
+	ProblemMissing VerifyProblem = "missing"
+	// ProblemSize means the destination file has another size.
+	ProblemSize VerifyProblem = "size"
+	// ProblemChecksum means the files have the same size but different
+	// content.
+	ProblemChecksum VerifyProblem = "checksum"
+	// ProblemError means the files could not be compared, e.g. because one
+	// of them could not be read.
+	ProblemError VerifyProblem = "error"
+)
+
+// VerifyEntry is a path that failed verification, relative to the roots.
+type VerifyEntry struct {
+	Path     string        `json:"path"`
+	Problem  VerifyProblem `json:"problem"`
+	Size     int64         `json:"size"`
+	DestSize int64         `json:"dest_size,omitempty"`
+	// Checksum and DestChecksum are set for checksum mismatches that
+	// gofast hashed itself.
+	Checksum     string `json:"checksum,omitempty"`
+	DestChecksum string `json:"dest_checksum,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// VerifyReport is the result of comparing a destination with its source
+// after a migration.
+type VerifyReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	// Files and Bytes count the source files checked; Verified counts
+	// those the destination holds intact.
+	Files    int64                   `json:"files"`
+	Bytes    int64                   `json:"bytes"`
+	Verified int64                   `json:"verified"`
+	Problems map[VerifyProblem]int64 `json:"problems"`
+	Entries  []VerifyEntry           `json:"entries"`
+}
+
+// OK reports whether every source file was found intact at the destination.
+func (r *VerifyReport) OK() bool {
+	return r.Verified == r.Files
+}
+
+// String summarizes the report in one line.
+func (r *VerifyReport) String() string {
+	var problems []string
+	for _, problem := range []VerifyProblem{ProblemMissing, ProblemSize, ProblemChecksum, ProblemError} {
+		if n := r.Problems[problem]; n > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s", n, problem))
+		}
+	}
+	if len(problems) == 0 {
+		return fmt.Sprintf("%d of %d files verified", r.Verified, r.Files)
+	}
+	return fmt.Sprintf("%d of %d files verified; %s", r.Verified, r.Files, strings.Join(problems, ", "))
+}
+
+// Verifier compares a destination tree with its source without copying
+// anything: every source file must exist at the destination with the same
+// size and, unless SizeOnly is set, the same content.
+type Verifier struct {
+	Source      provider.Provider
+	Destination provider.Provider
+	// Algorithm hashes files whose providers store no comparable
+	// checksums. Defaults to XXH3.
+	Algorithm ChecksumAlgorithm
+	// Streams is the number of files compared at once. Defaults to 1.
+	Streams int
+	// BufferSize is the read buffer of each stream. Defaults to 1 MiB.
+	BufferSize int
+	// SizeOnly skips the content comparison.
+	SizeOnly bool
+	// OnEntry, if set, is called for each problem as it is found, from the
+	// comparing goroutines.
+	OnEntry func(VerifyEntry)
+}
+
+// Verify walks srcRoot and dstRoot and compares every source file with the
+// file at the same relative path under dstRoot. Files found only at the
+// destination are not reported. The error is only set if a tree could not
+// be walked; problems with single files are in the report.
+func (v *Verifier) Verify(ctx context.Context, srcRoot, dstRoot string) (*VerifyReport, error) {
+	algo := v.Algorithm
+	if algo == "" {
+		algo = AlgorithmXXH3
+	}
+	hashers, err := NewChecksumPoolFor(algo)
+	if err != nil {
+		return nil, err
+	}
+	bufferSize := v.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1024 * 1024
+	}
+	buffers := NewBufferPool(bufferSize)
+
+	src, err := listTreeJobs(ctx, v.Source, srcRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+	// A destination that does not exist is simply missing everything
+	dst, err := listTreeJobs(ctx, v.Destination, dstRoot)
+	if err != nil {
+		if _, statErr := v.Destination.Stat(ctx, dstRoot); statErr == nil {
+			return nil, fmt.Errorf("failed to list destination: %w", err)
+		}
+		dst = nil
+	}
+
+	report := &VerifyReport{
+		GeneratedAt: time.Now(),
+		Problems:    make(map[VerifyProblem]int64),
+	}
+	var mu sync.Mutex
+	record := func(entry *VerifyEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		if entry == nil {
+			report.Verified++
+			return
+		}
+		report.Problems[entry.Problem]++
+		report.Entries = append(report.Entries, *entry)
+		if v.OnEntry != nil {
+			v.OnEntry(*entry)
+		}
+	}
+
+	rels := make([]string, 0, len(src))
+	for rel, job := range src {
+		rels = append(rels, rel)
+		report.Files++
+		report.Bytes += job.FileInfo.Size()
+	}
+	sort.Strings(rels)
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range max(v.Streams, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range work {
+				dstJob, ok := dst[rel]
+				record(v.compare(ctx, rel, src[rel], dstJob, ok, hashers, buffers))
+			}
+		}()
+	}
+feed:
+	for _, rel := range rels {
+		select {
+		case work <- rel:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report.Entries, func(i, j int) bool { return report.Entries[i].Path < report.Entries[j].Path })
+	return report, nil
+}
+
+// compare checks one source file against its destination counterpart and
+// returns the problem found, or nil if the destination is intact.
+func (v *Verifier) compare(ctx context.Context, rel string, src, dst TransferJob, found bool, hashers *ChecksumPool, buffers *BufferPool) *VerifyEntry {
+	entry := &VerifyEntry{Path: rel, Size: src.FileInfo.Size()}
+	switch {
+	case !found:
+		entry.Problem = ProblemMissing
+		return entry
+	case dst.FileInfo.Size() != src.FileInfo.Size():
+		entry.Problem = ProblemSize
+		entry.DestSize = dst.FileInfo.Size()
+		return entry
+	case v.SizeOnly:
+		return nil
+	}
+	entry.DestSize = dst.FileInfo.Size()
+
+	match, comparable, err := CompareNativeChecksums(ctx, v.Source, src.SourcePath, v.Destination, dst.SourcePath)
+	if err != nil {
+		entry.Problem = ProblemError
+		entry.Error = err.Error()
+		return entry
+	}
+	if comparable {
+		if match {
+			return nil
+		}
+		entry.Problem = ProblemChecksum
+		return entry
+	}
+
+	h := hashers.Get()
+	defer hashers.Put(h)
+	buf := buffers.Get()
+	defer buffers.Put(buf)
+
+	var sums [2]uint64
+	for i, side := range []struct {
+		p    provider.Provider
+		path string
+		name string
+	}{
+		{v.Source, src.SourcePath, "source"},
+		{v.Destination, dst.SourcePath, "destination"},
+	} {
+		if sums[i], _, err = HashFile(ctx, side.p, side.path, h, *buf); err != nil {
+			entry.Problem = ProblemError
+			entry.Error = fmt.Sprintf("failed to hash %s: %v", side.name, err)
+			return entry
+		}
+	}
+	if sums[0] == sums[1] {
+		return nil
+	}
+	entry.Problem = ProblemChecksum
+	entry.Checksum = string(hashers.Algorithm()) + ":" + FormatChecksum(sums[0])
+	entry.DestChecksum = string(hashers.Algorithm()) + ":" + FormatChecksum(sums[1])
+	return entry
+}
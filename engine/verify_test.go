@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/franksops/gofast/provider"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	write := func(dir, name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(srcDir, "same.txt", "hello")
+	write(dstDir, "same.txt", "hello")
+	write(srcDir, "sub/changed.txt", "hello")
+	write(dstDir, "sub/changed.txt", "jello")
+	write(srcDir, "short.txt", "hello")
+	write(dstDir, "short.txt", "hell")
+	write(srcDir, "missing.txt", "hello")
+	write(dstDir, "extra.txt", "only here")
+
+	var mu sync.Mutex
+	var found []string
+	v := &Verifier{
+		Source:      provider.NewLocalProvider("").WithRoot(srcDir),
+		Destination: provider.NewLocalProvider("").WithRoot(dstDir),
+		Streams:     3,
+		OnEntry: func(entry VerifyEntry) {
+			mu.Lock()
+			found = append(found, entry.Path)
+			mu.Unlock()
+		},
+	}
+	report, err := v.Verify(context.Background(), srcDir, dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Files != 4 || report.Verified != 1 || report.OK() {
+		t.Errorf("Expected 1 of 4 files verified, got %s", report)
+	}
+	want := []struct {
+		path    string
+		problem VerifyProblem
+	}{
+		{"missing.txt", ProblemMissing},
+		{"short.txt", ProblemSize},
+		{"sub/changed.txt", ProblemChecksum},
+	}
+	if len(report.Entries) != len(want) {
+		t.Fatalf("Expected %d problems, got %+v", len(want), report.Entries)
+	}
+	for i, w := range want {
+		if entry := report.Entries[i]; entry.Path != w.path || entry.Problem != w.problem {
+			t.Errorf("Entry %d = %s %s, want %s %s", i, entry.Path, entry.Problem, w.path, w.problem)
+		}
+	}
+	if len(found) != 3 {
+		t.Errorf("Expected OnEntry for each problem, got %v", found)
+	}
+
+	// Without stored checksums both files are hashed
+	v.Source = opaqueProvider{v.Source}
+	v.Destination = opaqueProvider{v.Destination}
+	v.OnEntry = nil
+	if report, err = v.Verify(context.Background(), srcDir, dstDir); err != nil {
+		t.Fatal(err)
+	}
+	if entry := report.Entries[2]; entry.Problem != ProblemChecksum || entry.Checksum == "" || entry.Checksum == entry.DestChecksum {
+		t.Errorf("Expected the hashed checksums to be reported, got %+v", entry)
+	}
+
+	// Comparing sizes only passes the changed file
+	v.SizeOnly = true
+	if report, err = v.Verify(context.Background(), srcDir, dstDir); err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified != 2 || report.Problems[ProblemChecksum] != 0 {
+		t.Errorf("Expected 2 files verified by size, got %s", report)
+	}
+}
+
+func TestVerifier_MissingDestination(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v := &Verifier{
+		Source:      provider.NewLocalProvider("").WithRoot(srcDir),
+		Destination: provider.NewLocalProvider("").WithRoot(srcDir),
+	}
+	report, err := v.Verify(context.Background(), srcDir, filepath.Join(srcDir, "nowhere"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Problems[ProblemMissing] != 1 {
+		t.Errorf("Expected the file to be missing, got %s", report)
+	}
+}
+
+// opaqueProvider hides the optional interfaces of a provider, such as
+// provider.Checksummer.
+type opaqueProvider struct {
+	provider.Provider
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/franksops/gofast/provider"
+)
+
+// versionJobID returns the ID under which one version of a job is tracked.
+func versionJobID(jobID, versionID string) string {
+	return jobID + "@" + versionID
+}
+
+// ReplayVersions recreates the history in job.Versions at the destination,
+// oldest first, so that a versioned destination bucket ends up with the
+// same sequence of versions: each version is written in turn and each
+// delete marker replayed as a delete. Every version is tracked as a job of
+// its own, and those completed by an earlier run are not written again. A
+// version that fails stops the replay, so later versions are never written
+// ahead of it.
+func ReplayVersions(ctx context.Context, job TransferJob, src, dst provider.Provider, tracker *JobTracker, buf []byte) error {
+	v, ok := src.(provider.Versioner)
+	if !ok {
+		return fmt.Errorf("source of %s keeps no versions: %w", job.SourcePath, provider.ErrNotSupported)
+	}
+	if err := tracker.InitJob(job); err != nil {
+		return fmt.Errorf("failed to init job: %w", err)
+	}
+	if err := tracker.MarkInProgress(job.ID); err != nil {
+		return fmt.Errorf("failed to mark job in progress: %w", err)
+	}
+
+	for _, version := range job.Versions {
+		vjob := TransferJob{
+			ID:              versionJobID(job.ID, version.VersionID),
+			SourcePath:      job.SourcePath,
+			DestinationPath: job.DestinationPath,
+			FileInfo:        version.Info,
+			VersionID:       version.VersionID,
+			Ctx:             job.Ctx,
+		}
+		done, err := tracker.IsCompleted(vjob.ID)
+		if err != nil {
+			return err
+		}
+		if done {
+			continue
+		}
+
+		if err := tracker.InitJob(vjob); err != nil {
+			return fmt.Errorf("failed to init job: %w", err)
+		}
+		if err := replayVersion(ctx, vjob, version, v, dst, tracker, buf); err != nil {
+			err = fmt.Errorf("failed to replay version %s of %s: %w", version.VersionID, job.SourcePath, err)
+			tracker.MarkFailed(vjob.ID, err)
+			tracker.MarkFailed(job.ID, err)
+			return err
+		}
+		if err := tracker.MarkCompleted(vjob.ID); err != nil {
+			return fmt.Errorf("failed to mark job completed: %w", err)
+		}
+	}
+	return tracker.MarkCompleted(job.ID)
+}
+
+// replayVersion writes one version, or replays one delete marker, at the
+// destination.
+func replayVersion(ctx context.Context, job TransferJob, version provider.ObjectVersion, src provider.Versioner, dst provider.Provider, tracker *JobTracker, buf []byte) error {
+	if version.DeleteMarker {
+		d, ok := dst.(provider.Deleter)
+		if !ok {
+			return fmt.Errorf("destination cannot delete: %w", provider.ErrNotSupported)
+		}
+		return d.Delete(ctx, job.DestinationPath)
+	}
+
+	r, err := src.OpenReadVersion(ctx, job.SourcePath, version.VersionID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := dst.OpenWrite(ctx, job.DestinationPath, job.FileInfo)
+	if err != nil {
+		return fmt.Errorf("failed to open destination: %w", err)
+	}
+	if _, err := io.CopyBuffer(tracker.NewTrackedWriter(w, job.ID, 0), r, buf); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/provider"
+	"github.com/franksops/gofast/store"
+)
+
+// versionedProvider keeps the history of its files.
+type versionedProvider struct {
+	*mockProvider
+	history  map[string][]provider.ObjectVersion
+	content  map[string]string // by version ID
+	failRead string
+}
+
+func (v *versionedProvider) ListVersions(ctx context.Context, path string, fn func(rel string, versions []provider.ObjectVersion) error) error {
+	for _, rel := range []string{"a.txt", "b.txt"} {
+		if err := fn(rel, v.history[rel]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *versionedProvider) OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error) {
+	if versionID == v.failRead {
+		return nil, fmt.Errorf("read failed")
+	}
+	return io.NopCloser(strings.NewReader(v.content[versionID])), nil
+}
+
+// historyRecorder records the writes and deletes made to it in order.
+type historyRecorder struct {
+	*mockProvider
+	ops []string
+}
+
+type recordedWrite struct {
+	strings.Builder
+	close func(string)
+}
+
+func (w *recordedWrite) Close() error { w.close(w.String()); return nil }
+
+func (h *historyRecorder) OpenWrite(ctx context.Context, path string, metadata provider.FileInfo) (io.WriteCloser, error) {
+	return &recordedWrite{close: func(content string) { h.ops = append(h.ops, "put "+path+" "+content) }}, nil
+}
+
+func (h *historyRecorder) Delete(ctx context.Context, path string) error {
+	h.ops = append(h.ops, "delete "+path)
+	return nil
+}
+
+func newVersionedProvider() *versionedProvider {
+	at := func(min int) time.Time { return time.Date(2024, 1, 1, 0, min, 0, 0, time.UTC) }
+	return &versionedProvider{
+		mockProvider: newMockProvider(),
+		history: map[string][]provider.ObjectVersion{
+			"a.txt": {
+				{VersionID: "a1", Info: mockFileInfo{name: "a.txt", size: 2, modTime: at(1)}},
+				{VersionID: "a2", DeleteMarker: true, Info: mockFileInfo{name: "a.txt", modTime: at(2)}},
+				{VersionID: "a3", Info: mockFileInfo{name: "a.txt", size: 3, modTime: at(3)}},
+			},
+			"b.txt": {
+				{VersionID: "b1", Info: mockFileInfo{name: "b.txt", size: 2, modTime: at(1)}},
+				{VersionID: "b2", DeleteMarker: true, Info: mockFileInfo{name: "b.txt", modTime: at(2)}},
+			},
+		},
+		content: map[string]string{"a1": "v1", "a3": "v3", "b1": "b1"},
+	}
+}
+
+func TestReplayVersions(t *testing.T) {
+	ctx := context.Background()
+	src := newVersionedProvider()
+	src.files["/src"] = mockFileInfo{name: "src", isDir: true}
+
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(src, jobChan)
+	walker.Versions = true
+	if err := walker.Walk(ctx, "/src", "/dst"); err != nil {
+		t.Fatal(err)
+	}
+	close(jobChan)
+
+	dst := &historyRecorder{mockProvider: newMockProvider()}
+	mockStore := &MockStore{Jobs: make(map[string]*store.JobRecord)}
+	tracker := NewJobTracker(mockStore, DefaultCheckpointConfig)
+	buf := make([]byte, 16)
+
+	// The first replay fails at the third version of a.txt
+	src.failRead = "a3"
+	var jobs []TransferJob
+	for job := range jobChan {
+		jobs = append(jobs, job)
+	}
+	if len(jobs) != 2 || len(jobs[0].Versions) != 3 || jobs[0].FileInfo.Size() != 3 {
+		t.Fatalf("Expected a job per file with its history, got %+v", jobs)
+	}
+	if err := ReplayVersions(ctx, jobs[0], src, dst, tracker, buf); err == nil {
+		t.Fatal("Expected the failed read to stop the replay")
+	}
+	if mockStore.Jobs[jobs[0].ID].State != store.StateFailed || mockStore.Jobs[versionJobID(jobs[0].ID, "a3")].State != store.StateFailed {
+		t.Errorf("Expected the file and its version failed")
+	}
+
+	// The rerun writes only what is missing
+	src.failRead = ""
+	for _, job := range jobs {
+		if err := ReplayVersions(ctx, job, src, dst, tracker, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"put /dst/a.txt v1", "delete /dst/a.txt",
+		"put /dst/a.txt v3",
+		"put /dst/b.txt b1", "delete /dst/b.txt",
+	}
+	if fmt.Sprint(dst.ops) != fmt.Sprint(want) {
+		t.Errorf("Got %q, want %q", dst.ops, want)
+	}
+	if mockStore.Jobs[jobs[0].ID].State != store.StateCompleted || mockStore.Jobs[versionJobID(jobs[1].ID, "b2")].State != store.StateCompleted {
+		t.Errorf("Expected the replays completed")
+	}
+}
@@ -0,0 +1,41 @@
+package engine
+
+import "fmt"
+
+// WalkErrorPolicy controls what the walker does when part of the source
+// cannot be read.
+type WalkErrorPolicy string
+
+const (
+	// WalkErrorsFail stops the walk at the first directory or link that
+	// cannot be read.
+	WalkErrorsFail WalkErrorPolicy = "fail"
+	// WalkErrorsSkip leaves the unreadable directory or link out, reports
+	// it, and walks the rest of the source. The source root itself must
+	// still be readable.
+	WalkErrorsSkip WalkErrorPolicy = "skip"
+)
+
+// ParseWalkErrorPolicy parses a -walk-errors flag value.
+func ParseWalkErrorPolicy(s string) (WalkErrorPolicy, error) {
+	switch p := WalkErrorPolicy(s); p {
+	case WalkErrorsFail, WalkErrorsSkip:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown walk error policy %q (want fail or skip)", s)
+}
+
+// WalkError is a part of the source the walker could not read.
+type WalkError struct {
+	// Path is the directory or link that could not be read.
+	Path string
+	Err  error
+}
+
+func (e *WalkError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *WalkError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseWalkErrorPolicy(t *testing.T) {
+	for _, s := range []string{"fail", "skip"} {
+		if p, err := ParseWalkErrorPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseWalkErrorPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseWalkErrorPolicy("ignore"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestWalker_ErrorPolicy(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{
+		{name: "denied", isDir: true},
+		{name: "ok", isDir: true},
+		{name: "top.txt", size: 1},
+	}
+	// /root/denied has no listing, as if permission were denied
+	mp.dirs["/root/ok"] = []mockFileInfo{{name: "a.txt", size: 1}}
+
+	walk := func(policy WalkErrorPolicy, listers int) (*Walker, []string, []*WalkError, error) {
+		jobChan := make(JobChannel, 10)
+		walker := NewWalker(mp, jobChan)
+		walker.Sorted = true
+		walker.ErrorPolicy = policy
+		walker.Listers = listers
+		var reported []*WalkError
+		walker.OnError = func(e *WalkError) { reported = append(reported, e) }
+		err := walker.Walk(context.Background(), "/root", "/dest")
+		close(jobChan)
+		var received []string
+		for job := range jobChan {
+			received = append(received, job.SourcePath)
+		}
+		return walker, received, reported, err
+	}
+
+	for _, policy := range []WalkErrorPolicy{"", WalkErrorsFail} {
+		if _, _, _, err := walk(policy, 1); err == nil {
+			t.Errorf("policy %q: expected the unreadable directory to fail the walk", policy)
+		}
+	}
+
+	for _, listers := range []int{1, 4} {
+		walker, received, reported, err := walk(WalkErrorsSkip, listers)
+		if err != nil {
+			t.Fatalf("listers %d: expected the walk to continue, got %v", listers, err)
+		}
+		if want := "[/root/top.txt /root/ok/a.txt]"; fmt.Sprint(received) != want {
+			t.Errorf("listers %d: got jobs %v, want %s", listers, received, want)
+		}
+		if walker.SkippedErrors != 1 || len(reported) != 1 || reported[0].Path != "/root/denied" {
+			t.Errorf("listers %d: expected /root/denied reported once, got %d, %v", listers, walker.SkippedErrors, reported)
+		}
+	}
+
+	// The root itself must be readable, and cancellation is never skipped
+	delete(mp.dirs, "/root")
+	if _, _, _, err := walk(WalkErrorsSkip, 1); err == nil {
+		t.Error("expected an unreadable root to fail the walk")
+	}
+	mp.dirs["/root"] = []mockFileInfo{{name: "ok", isDir: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	walker := NewWalker(mp, make(JobChannel, 10))
+	walker.ErrorPolicy = WalkErrorsSkip
+	if err := walker.Walk(ctx, "/root", "/dest"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation to end the walk, got %v", err)
+	}
+}
@@ -2,8 +2,13 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/franksops/gofast/provider"
 )
@@ -13,8 +18,101 @@ import (
 type Walker struct {
 	SourceProvider provider.Provider
 	JobChan        JobChannel
+
+	// Sorted makes the walk deterministic: each listing is sorted by name
+	// and subdirectories are visited in that order, so runs over the same
+	// tree queue jobs in the same order.
+	Sorted bool
+
+	// Budget, if set, bounds the memory held by queued jobs. Jobs are
+	// reserved against it before being queued; the consuming WorkerPool
+	// must be given the same budget so it can release them.
+	Budget *QueueBudget
+
+	// Memory, if set, holds the walk back while the transfer buffers of
+	// the workers have nearly used up their budget.
+	Memory *MemoryBudget
+
+	// Flat lists a source directory in one scan when the provider
+	// supports it (provider.FlatLister), queueing files as the scan
+	// returns them instead of listing each subdirectory. Object stores
+	// return keys in lexical order, so Sorted needs no extra work; symbolic
+	// links do not occur there.
+	Flat bool
+
+	// Versions queues one job per file carrying its whole history, when
+	// the provider keeps one (provider.Versioner), for ReplayVersions.
+	// Files whose current version is a delete marker are included.
+	Versions bool
+
+	// Symlinks is how symbolic links are treated; the zero value
+	// preserves them.
+	Symlinks SymlinkPolicy
+
+	// SkippedLinks counts the links left out of the walk: all of them
+	// under SymlinkSkip, and dangling links and links leading back into
+	// the walk under SymlinkFollow. Read it once Walk has returned.
+	SkippedLinks int64
+
+	// Seen, if set, records the destination path of every queued job,
+	// for PruneDestination. Files Filter leaves out are recorded too, so
+	// mirroring does not delete them at the destination.
+	Seen *PathSet
+
+	// Filter, if set, leaves out files by size and age.
+	Filter *FileFilter
+
+	// Filtered counts the files Filter left out. Read it once Walk has
+	// returned.
+	Filtered int64
+
+	// MaxDepth limits the walk to this many levels below the source root:
+	// 1 queues the files in the root only, 2 those of its subdirectories
+	// too, and so on. 0 means no limit.
+	MaxDepth int
+
+	// OneFileSystem leaves out directories and files on other devices
+	// than the source root, such as mount points and bind mounts, for
+	// providers that report devices (provider.Device).
+	OneFileSystem bool
+
+	// SkippedMounts counts the entries OneFileSystem left out. Read it
+	// once Walk has returned.
+	SkippedMounts int64
+
+	// ErrorPolicy decides what a directory that cannot be listed, or a
+	// link that cannot be followed, does to the walk; the zero value fails
+	// it. Errors of flat and version scans always fail it.
+	ErrorPolicy WalkErrorPolicy
+
+	// OnError, if set, is called with each error WalkErrorsSkip skipped.
+	OnError func(*WalkError)
+
+	// SkippedErrors counts the directories and links WalkErrorsSkip left
+	// out. Read it once Walk has returned.
+	SkippedErrors int64
+
+	// Dirs, if set, records every directory the walk lists, the root
+	// included, for DirSet.Create. Flat and version scans record none:
+	// object stores have no directories to carry over.
+	Dirs *DirSet
+
+	// OnQueue, if set, is called with each job once it is queued, e.g.
+	// to keep running totals for progress reporting.
+	OnQueue func(job TransferJob)
+
+	// Listers is the number of directories listed at once. Listings are
+	// fetched ahead of the walk, for the directories it visits next, but
+	// processed in walk order, so jobs are queued in the same order as
+	// with a single lister and Sorted stays deterministic. Values below 2
+	// list one directory at a time.
+	Listers int
 }
 
+// listAhead is the number of directories listed ahead of the walk per
+// lister, which bounds the listings held in memory.
+const listAhead = 4
+
 // NewWalker creates a new iterative directory walker.
 func NewWalker(src provider.Provider, jobChan JobChannel) *Walker {
 	return &Walker{
@@ -34,28 +132,70 @@ func (w *Walker) Walk(ctx context.Context, sourcePath string, destPath string) e
 	// If the root itself is just a file, we send one job and return.
 	if !stat.IsDir() {
 		job := TransferJob{
-			ID:              sourcePath, // A UUID generator would be better here in a full app
+			ID:              NewJobID(),
 			SourcePath:      sourcePath,
 			DestinationPath: destPath,
 			FileInfo:        stat,
 			Ctx:             ctx,
 		}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case w.JobChan <- job:
-			return nil
+		return w.enqueue(ctx, job)
+	}
+
+	if w.Versions && provider.CapabilitiesOf(w.SourceProvider).Versions {
+		if v, ok := w.SourceProvider.(provider.Versioner); ok {
+			return w.walkVersions(ctx, v, sourcePath, destPath)
+		}
+	}
+
+	if w.Flat && provider.CapabilitiesOf(w.SourceProvider).FlatList {
+		if fl, ok := w.SourceProvider.(provider.FlatLister); ok {
+			return w.walkFlat(ctx, fl, sourcePath, destPath)
 		}
 	}
 
 	// For a directory, initialize a stack for the iterative walk.
 	// We'll store paths relative to the sourcePath to easily compute destination paths.
+	// Below followed links, realPath is where the links lead; chain holds
+	// the real directories links were followed from on the way there.
+	// Listings fetched ahead are held in listing.
+	// depth counts the levels below the root.
 	type walkItem struct {
-		relPath string
+		relPath  string
+		info     provider.FileInfo
+		realPath string
+		chain    []string
+		depth    int
+		listing  *listing
 	}
 
-	stack := []walkItem{{relPath: ""}}
+	stack := []walkItem{{relPath: "", info: stat, realPath: sourcePath}}
+	rootDev, hasRootDev := provider.Device(stat)
+	// otherDevice reports whether info is on another filesystem than the
+	// root and is left out
+	otherDevice := func(info provider.FileInfo) bool {
+		if !w.OneFileSystem || !hasRootDev {
+			return false
+		}
+		dev, ok := provider.Device(info)
+		return ok && dev != rootDev
+	}
+	// tooDeep reports whether the directories below curr are beyond
+	// MaxDepth
+	tooDeep := func(curr walkItem) bool {
+		return w.MaxDepth > 0 && curr.depth+1 >= w.MaxDepth
+	}
+
+	// Listers fetch the listings of the directories on top of the stack,
+	// which are visited next. Returning cancels and waits for them.
+	var listers *listerPool
+	if w.Listers > 1 {
+		listCtx, cancel := context.WithCancel(ctx)
+		listers = &listerPool{sem: make(chan struct{}, w.Listers)}
+		defer listers.wg.Wait()
+		defer cancel()
+		ctx = listCtx
+	}
 
 	for len(stack) > 0 {
 		// Check for cancellation
@@ -65,6 +205,14 @@ func (w *Walker) Walk(ctx context.Context, sourcePath string, destPath string) e
 		default:
 		}
 
+		if listers != nil {
+			for i := len(stack) - 1; i >= max(0, len(stack)-w.Listers*listAhead); i-- {
+				if stack[i].listing == nil {
+					stack[i].listing = listers.list(ctx, w.SourceProvider, filepath.Join(sourcePath, stack[i].relPath))
+				}
+			}
+		}
+
 		// Pop item
 		curr := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
@@ -74,40 +222,286 @@ func (w *Walker) Walk(ctx context.Context, sourcePath string, destPath string) e
 			currentSourcePath = filepath.Join(sourcePath, curr.relPath)
 		}
 
-		entries, err := w.SourceProvider.List(ctx, currentSourcePath)
+		var entries []provider.FileInfo
+		if curr.listing != nil {
+			entries, err = curr.listing.wait(ctx)
+		} else {
+			entries, err = w.SourceProvider.List(ctx, currentSourcePath)
+		}
 		if err != nil {
-			// In production, might log and continue, or fail fast based on config.
+			if curr.relPath != "" && w.skip(ctx, currentSourcePath, err) {
+				continue
+			}
 			return fmt.Errorf("failed to list directory %s: %w", currentSourcePath, err)
 		}
+		if w.Dirs != nil {
+			w.Dirs.Add(TransferJob{
+				ID:              NewJobID(),
+				SourcePath:      currentSourcePath,
+				DestinationPath: filepath.Join(destPath, curr.relPath),
+				FileInfo:        curr.info,
+				Ctx:             ctx,
+			})
+		}
+
+		if w.Sorted {
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		}
 
+		var subdirs []walkItem
 		for _, entry := range entries {
 			entryRelPath := entry.Name()
 			if curr.relPath != "" {
 				entryRelPath = filepath.Join(curr.relPath, entry.Name())
 			}
 
+			entrySourcePath := filepath.Join(currentSourcePath, entry.Name())
+			entryRealPath := filepath.Join(curr.realPath, entry.Name())
+
+			// Symbolic links are reported unfollowed. Preserved links are
+			// queued as they are and the transfer recreates them at the
+			// destination; followed links are replaced by what they point to.
+			// Skipped links are left out on purpose: what the destination
+			// holds in their place, file or tree, is kept.
+			if target, ok := provider.SymlinkTarget(entry); ok && w.Symlinks != "" && w.Symlinks != SymlinkPreserve {
+				linkDestPath := filepath.Join(destPath, entryRelPath)
+				if w.Symlinks == SymlinkSkip {
+					w.SkippedLinks++
+					w.leaveOut(linkDestPath, false)
+					w.leaveOut(linkDestPath, true)
+					continue
+				}
+				info, err := w.SourceProvider.Stat(ctx, entrySourcePath)
+				if errors.Is(err, fs.ErrNotExist) {
+					w.SkippedLinks++
+					w.leaveOut(linkDestPath, false)
+					w.leaveOut(linkDestPath, true)
+					continue
+				}
+				if err != nil {
+					if w.skip(ctx, entrySourcePath, err) {
+						continue
+					}
+					return fmt.Errorf("failed to follow link %s: %w", entrySourcePath, err)
+				}
+				if info.IsDir() {
+					if otherDevice(info) {
+						w.SkippedMounts++
+						w.leaveOut(linkDestPath, true)
+						continue
+					}
+					if tooDeep(curr) {
+						w.leaveOut(linkDestPath, true)
+						continue
+					}
+					if !filepath.IsAbs(target) {
+						target = filepath.Join(curr.realPath, target)
+					}
+					chain := append(curr.chain[:len(curr.chain):len(curr.chain)], curr.realPath)
+					if len(chain) > maxLinkDepth || linkLeadsBack(target, chain) {
+						w.SkippedLinks++
+						w.leaveOut(linkDestPath, true)
+						continue
+					}
+					subdirs = append(subdirs, walkItem{relPath: entryRelPath, info: info, realPath: target, chain: chain, depth: curr.depth + 1})
+					continue
+				}
+				entry = info
+			}
+
+			if otherDevice(entry) {
+				w.SkippedMounts++
+				w.leaveOut(filepath.Join(destPath, entryRelPath), entry.IsDir())
+				continue
+			}
+
 			if entry.IsDir() {
-				// Push subdirectory onto stack to process later
-				stack = append(stack, walkItem{relPath: entryRelPath})
+				if tooDeep(curr) {
+					w.leaveOut(filepath.Join(destPath, entryRelPath), true)
+					continue
+				}
+				// Collect subdirectory to push onto the stack after the files
+				subdirs = append(subdirs, walkItem{relPath: entryRelPath, info: entry, realPath: entryRealPath, chain: curr.chain, depth: curr.depth + 1})
 			} else {
 				// It's a file, generate a job
 				job := TransferJob{
-					ID:              filepath.Join(sourcePath, entryRelPath), 
-					SourcePath:      filepath.Join(sourcePath, entryRelPath),
+					ID:              NewJobID(),
+					SourcePath:      entrySourcePath,
 					DestinationPath: filepath.Join(destPath, entryRelPath),
 					FileInfo:        entry,
 					Ctx:             ctx,
 				}
 
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case w.JobChan <- job:
-					// Enqueued
+				if err := w.enqueue(ctx, job); err != nil {
+					return err
 				}
 			}
 		}
+
+		// Push subdirectories in reverse so they are popped in listing order
+		for i := len(subdirs) - 1; i >= 0; i-- {
+			stack = append(stack, subdirs[i])
+		}
 	}
 
 	return nil
 }
+
+// leaveOut records a file or directory at destPath the walk leaves out on
+// purpose, so mirroring keeps it at the destination.
+func (w *Walker) leaveOut(destPath string, dir bool) {
+	if w.Seen == nil {
+		return
+	}
+	if dir {
+		w.Seen.AddTree(destPath)
+	} else {
+		w.Seen.Add(destPath)
+	}
+}
+
+// beyondDepth reports whether rel, a slash-separated path below the source
+// root from a flat or version scan, is deeper than MaxDepth.
+func (w *Walker) beyondDepth(rel string) bool {
+	return w.MaxDepth > 0 && strings.Count(rel, "/") >= w.MaxDepth
+}
+
+// skip reports whether the error reading path is left out of the walk
+// under ErrorPolicy, reporting it if so. Cancellation always ends the walk.
+func (w *Walker) skip(ctx context.Context, path string, err error) bool {
+	if w.ErrorPolicy != WalkErrorsSkip || ctx.Err() != nil {
+		return false
+	}
+	w.SkippedErrors++
+	if w.OnError != nil {
+		w.OnError(&WalkError{Path: path, Err: err})
+	}
+	return true
+}
+
+// listing is a directory listing being fetched by a listerPool.
+type listing struct {
+	done    chan struct{}
+	entries []provider.FileInfo
+	err     error
+}
+
+// wait returns the listing once it has been fetched.
+func (l *listing) wait(ctx context.Context) ([]provider.FileInfo, error) {
+	select {
+	case <-l.done:
+		return l.entries, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// listerPool lists directories concurrently, at most cap(sem) at once.
+type listerPool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+// list starts listing dir and returns the listing to wait for.
+func (p *listerPool) list(ctx context.Context, src provider.Provider, dir string) *listing {
+	l := &listing{done: make(chan struct{})}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(l.done)
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			l.err = ctx.Err()
+			return
+		}
+		defer func() { <-p.sem }()
+		l.entries, l.err = src.List(ctx, dir)
+	}()
+	return l
+}
+
+// walkFlat queues every file below sourcePath from a single flat scan.
+func (w *Walker) walkFlat(ctx context.Context, fl provider.FlatLister, sourcePath, destPath string) error {
+	err := fl.ListAll(ctx, sourcePath, func(rel string, info provider.FileInfo) error {
+		if w.beyondDepth(rel) {
+			w.leaveOut(filepath.Join(destPath, filepath.FromSlash(rel)), false)
+			return nil
+		}
+		rel = filepath.FromSlash(rel)
+		return w.enqueue(ctx, TransferJob{
+			ID:              NewJobID(),
+			SourcePath:      filepath.Join(sourcePath, rel),
+			DestinationPath: filepath.Join(destPath, rel),
+			FileInfo:        info,
+			Ctx:             ctx,
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to list %s: %w", sourcePath, err)
+	}
+	return err
+}
+
+// walkVersions queues the history of every file below sourcePath.
+func (w *Walker) walkVersions(ctx context.Context, v provider.Versioner, sourcePath, destPath string) error {
+	err := v.ListVersions(ctx, sourcePath, func(rel string, versions []provider.ObjectVersion) error {
+		if w.beyondDepth(rel) {
+			w.leaveOut(filepath.Join(destPath, filepath.FromSlash(rel)), false)
+			return nil
+		}
+		rel = filepath.FromSlash(rel)
+		return w.enqueue(ctx, TransferJob{
+			ID:              NewJobID(),
+			SourcePath:      filepath.Join(sourcePath, rel),
+			DestinationPath: filepath.Join(destPath, rel),
+			FileInfo:        versions[len(versions)-1].Info,
+			Versions:        versions,
+			Ctx:             ctx,
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to list versions of %s: %w", sourcePath, err)
+	}
+	return err
+}
+
+// enqueue sends job to the job channel, accounting for it against the
+// budget first if one is set, unless the filter leaves it out.
+func (w *Walker) enqueue(ctx context.Context, job TransferJob) error {
+	if !w.Filter.Match(job.FileInfo) {
+		w.Filtered++
+		if w.Seen != nil {
+			w.Seen.Add(job.DestinationPath)
+		}
+		return nil
+	}
+
+	if w.Memory != nil {
+		if err := w.Memory.WaitBelowHighWater(ctx); err != nil {
+			return err
+		}
+	}
+	if w.Budget != nil {
+		var err error
+		if job, err = w.Budget.Reserve(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		if w.Budget != nil {
+			w.Budget.Release(job)
+		}
+		return ctx.Err()
+	case w.JobChan <- job:
+		if w.Seen != nil {
+			w.Seen.Add(job.DestinationPath)
+		}
+		if w.OnQueue != nil {
+			w.OnQueue(job)
+		}
+		return nil
+	}
+}
@@ -2,8 +2,12 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -155,3 +159,313 @@ func TestWalker_Walk_SingleFile(t *testing.T) {
 		t.Fatal("Expected a job on the channel")
 	}
 }
+
+func TestWalker_Walk_Sorted(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{
+		{name: "zdir", isDir: true},
+		{name: "b.txt"},
+		{name: "adir", isDir: true},
+		{name: "a.txt"},
+	}
+	mp.dirs["/root/adir"] = []mockFileInfo{{name: "y.txt"}, {name: "x.txt"}}
+	mp.dirs["/root/zdir"] = []mockFileInfo{{name: "c.txt"}}
+
+	expected := []string{
+		"/root/a.txt",
+		"/root/b.txt",
+		"/root/adir/x.txt",
+		"/root/adir/y.txt",
+		"/root/zdir/c.txt",
+	}
+
+	// Two runs must produce the identical order.
+	for run := 0; run < 2; run++ {
+		jobChan := make(JobChannel, 10)
+		walker := NewWalker(mp, jobChan)
+		walker.Sorted = true
+
+		if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		close(jobChan)
+
+		var received []string
+		for job := range jobChan {
+			received = append(received, job.SourcePath)
+		}
+
+		if len(received) != len(expected) {
+			t.Fatalf("expected %d jobs, got %d", len(expected), len(received))
+		}
+		for i := range expected {
+			if received[i] != expected[i] {
+				t.Errorf("run %d: job %d = %s; want %s", run, i, received[i], expected[i])
+			}
+		}
+	}
+}
+
+// flatProvider is a mockProvider that can also list everything at once.
+type flatProvider struct {
+	*mockProvider
+	keys     []string
+	listAlls int
+}
+
+func (f *flatProvider) ListAll(ctx context.Context, path string, fn func(rel string, info provider.FileInfo) error) error {
+	f.listAlls++
+	for _, key := range f.keys {
+		if err := fn(key, mockFileInfo{name: key, size: 1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWalker_Walk_Flat(t *testing.T) {
+	fp := &flatProvider{mockProvider: newMockProvider(), keys: []string{"a.txt", "dir1/b.txt", "dir1/dir2/c.txt"}}
+	fp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	// No directory listings: the walk must not fall back to List
+
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(fp, jobChan)
+	walker.Flat = true
+	if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	close(jobChan)
+
+	var received []string
+	for job := range jobChan {
+		received = append(received, job.SourcePath+" -> "+job.DestinationPath)
+	}
+	expected := []string{
+		"/root/a.txt -> /dest/a.txt",
+		"/root/dir1/b.txt -> /dest/dir1/b.txt",
+		"/root/dir1/dir2/c.txt -> /dest/dir1/dir2/c.txt",
+	}
+	if fmt.Sprint(received) != fmt.Sprint(expected) {
+		t.Errorf("Got jobs %v, want %v", received, expected)
+	}
+	if fp.listAlls != 1 {
+		t.Errorf("Expected one flat scan, got %d", fp.listAlls)
+	}
+
+	// Without Flat the tree walk is used
+	walker = NewWalker(fp, make(JobChannel, 10))
+	if err := walker.Walk(context.Background(), "/root", "/dest"); err == nil {
+		t.Error("Expected the tree walk to list /root")
+	}
+}
+
+// slowListProvider is a mockProvider whose listings take a while, and which
+// records how many run at once.
+type slowListProvider struct {
+	*mockProvider
+	delay         time.Duration
+	mu            sync.Mutex
+	running, peak int
+}
+
+func (s *slowListProvider) List(ctx context.Context, path string) ([]provider.FileInfo, error) {
+	s.mu.Lock()
+	s.running++
+	s.peak = max(s.peak, s.running)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}()
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.mockProvider.List(ctx, path)
+}
+
+func TestWalker_Walk_Listers(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	for i := range 6 {
+		dir := fmt.Sprintf("d%d", i)
+		mp.dirs["/root"] = append(mp.dirs["/root"], mockFileInfo{name: dir, isDir: true})
+		mp.dirs["/root/"+dir] = []mockFileInfo{{name: "f.txt", size: 1}, {name: "sub", isDir: true}}
+		mp.dirs["/root/"+dir+"/sub"] = []mockFileInfo{{name: "g.txt", size: 1}}
+	}
+
+	walk := func(listers int) ([]string, int) {
+		t.Helper()
+		sp := &slowListProvider{mockProvider: mp, delay: 5 * time.Millisecond}
+		jobChan := make(JobChannel, 100)
+		walker := NewWalker(sp, jobChan)
+		walker.Sorted = true
+		walker.Listers = listers
+		if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		close(jobChan)
+		var received []string
+		for job := range jobChan {
+			received = append(received, job.SourcePath)
+		}
+		return received, sp.peak
+	}
+
+	sequential, peak := walk(1)
+	if peak != 1 {
+		t.Errorf("Expected one listing at a time, got %d", peak)
+	}
+	parallel, peak := walk(4)
+	if peak < 2 || peak > 4 {
+		t.Errorf("Expected up to 4 listings at once, got %d", peak)
+	}
+	if len(sequential) != 12 || fmt.Sprint(parallel) != fmt.Sprint(sequential) {
+		t.Errorf("Expected the same jobs in the same order, got %v and %v", parallel, sequential)
+	}
+}
+
+func TestWalker_Walk_ListersCancel(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	for i := range 20 {
+		dir := fmt.Sprintf("d%d", i)
+		mp.dirs["/root"] = append(mp.dirs["/root"], mockFileInfo{name: dir, isDir: true})
+		mp.dirs["/root/"+dir] = []mockFileInfo{{name: "f.txt", size: 1}}
+	}
+	sp := &slowListProvider{mockProvider: mp, delay: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	walker := NewWalker(sp, make(JobChannel))
+	walker.Listers = 4
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- walker.Walk(ctx, "/root", "/dest") }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Walk did not return after cancellation")
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.running != 0 {
+		t.Errorf("Expected no listings left running, got %d", sp.running)
+	}
+}
+
+func TestWalker_OnQueue(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{{name: "a.txt", size: 10}, {name: "b.txt", size: 20}}
+
+	walker := NewWalker(mp, make(JobChannel, 10))
+	var bytes int64
+	walker.OnQueue = func(job TransferJob) { bytes += job.FileInfo.Size() }
+	if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if bytes != 30 {
+		t.Errorf("OnQueue saw %d bytes, want 30", bytes)
+	}
+}
+
+func TestWalker_Memory(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root/file1.txt"] = mockFileInfo{name: "file1.txt"}
+
+	memory := NewMemoryBudget(100)
+	release, _ := memory.Acquire(context.Background(), 100)
+	walker := NewWalker(mp, make(JobChannel, 1))
+	walker.Memory = memory
+
+	done := make(chan error, 1)
+	go func() { done <- walker.Walk(context.Background(), "/root/file1.txt", "/dest/file1.txt") }()
+	select {
+	case <-done:
+		t.Fatal("Expected the walk to wait while the memory budget is used up")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the walk to continue once memory was released")
+	}
+}
+
+func TestWalker_MaxDepth(t *testing.T) {
+	mp := newMockProvider()
+	mp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	mp.dirs["/root"] = []mockFileInfo{{name: "a.txt", size: 1}, {name: "d1", isDir: true}}
+	mp.dirs["/root/d1"] = []mockFileInfo{{name: "b.txt", size: 1}, {name: "d2", isDir: true}}
+	mp.dirs["/root/d1/d2"] = []mockFileInfo{{name: "c.txt", size: 1}}
+
+	for depth, want := range map[int]string{
+		0: "[/root/a.txt /root/d1/b.txt /root/d1/d2/c.txt]",
+		1: "[/root/a.txt]",
+		2: "[/root/a.txt /root/d1/b.txt]",
+	} {
+		jobChan := make(JobChannel, 10)
+		walker := NewWalker(mp, jobChan)
+		walker.MaxDepth = depth
+		walker.Seen = NewPathSet()
+		if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+			t.Fatalf("depth %d: Walk failed: %v", depth, err)
+		}
+		close(jobChan)
+		var received []string
+		for job := range jobChan {
+			received = append(received, job.SourcePath)
+		}
+		if fmt.Sprint(received) != want {
+			t.Errorf("depth %d: got %v, want %s", depth, received, want)
+		}
+		// Mirroring keeps what lies beyond the depth
+		if !walker.Seen.Has("/dest/d1/d2/c.txt") {
+			t.Errorf("depth %d: expected the deepest file kept for mirroring", depth)
+		}
+	}
+
+	fp := &flatProvider{mockProvider: newMockProvider(), keys: []string{"a.txt", "d1/b.txt", "d1/d2/c.txt"}}
+	fp.files["/root"] = mockFileInfo{name: "root", isDir: true}
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(fp, jobChan)
+	walker.Flat = true
+	walker.MaxDepth = 2
+	if err := walker.Walk(context.Background(), "/root", "/dest"); err != nil {
+		t.Fatalf("Flat walk failed: %v", err)
+	}
+	close(jobChan)
+	if n := len(jobChan); n != 2 {
+		t.Errorf("Expected 2 files within depth 2 of a flat scan, got %d", n)
+	}
+}
+
+func TestWalker_OneFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0644)
+
+	jobChan := make(JobChannel, 10)
+	walker := NewWalker(provider.NewLocalProvider(""), jobChan)
+	walker.OneFileSystem = true
+	if err := walker.Walk(context.Background(), dir, "/dest"); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	// One temporary directory is on one filesystem
+	if n := len(jobChan); n != 2 || walker.SkippedMounts != 0 {
+		t.Errorf("Expected both files and no mounts skipped, got %d files, %d mounts", n, walker.SkippedMounts)
+	}
+}
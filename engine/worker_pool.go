@@ -3,38 +3,112 @@ package engine
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // JobHandler is a function that processes a TransferJob.
 type JobHandler func(context.Context, TransferJob) error
 
+// IdleTask is low-priority work a worker runs when no job is queued, such as
+// verifying files that already completed. It returns false when it had
+// nothing to do.
+type IdleTask func(context.Context) bool
+
 // WorkerPool manages a dynamic set of workers processing jobs.
 type WorkerPool struct {
 	jobChan JobChannel
 	handler JobHandler
 
+	// ctx is the context jobs run with; it outlives the pool's parent
+	// context by the drain timeout.
 	ctx    context.Context
 	cancel context.CancelFunc
+	// halted is closed once workers stop taking jobs, on Stop or when the
+	// parent context is done.
+	halted   chan struct{}
+	haltOnce sync.Once
+	// exhausted is closed once a worker finds the job channel closed, so
+	// every job has been taken from it.
+	exhausted   chan struct{}
+	exhaustOnce sync.Once
 
 	mu          sync.Mutex
+	idleTask    IdleTask
+	idleWake    <-chan struct{}
+	budget      *QueueBudget
+	slots       *RunSlots
+	limits      *PathLimits
+	grace       time.Duration
 	workers     map[int]chan struct{}
 	workerCount int
 	nextID      int
 	wg          sync.WaitGroup
+
+	// pausing is closed while the pool is paused, waking workers waiting
+	// for a job; resumed is closed when it resumes.
+	paused  bool
+	pausing chan struct{}
+	resumed chan struct{}
 }
 
 // NewWorkerPool creates a new dynamic worker pool.
 func NewWorkerPool(ctx context.Context, jobChan JobChannel, handler JobHandler) *WorkerPool {
-	ctx, cancel := context.WithCancel(ctx)
-	return &WorkerPool{
-		jobChan: jobChan,
-		handler: handler,
-		ctx:     ctx,
-		cancel:  cancel,
-		workers: make(map[int]chan struct{}),
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	p := &WorkerPool{
+		jobChan:   jobChan,
+		handler:   handler,
+		ctx:       jobCtx,
+		cancel:    cancel,
+		halted:    make(chan struct{}),
+		exhausted: make(chan struct{}),
+		workers:   make(map[int]chan struct{}),
+		pausing:   make(chan struct{}),
+	}
+	context.AfterFunc(ctx, p.drain)
+	return p
+}
+
+// Pause stops workers from taking jobs. Each finishes the job it is
+// running, if any, then blocks until Resume; queued jobs wait.
+func (p *WorkerPool) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.paused = true
+		p.resumed = make(chan struct{})
+		close(p.pausing)
+	}
+}
+
+// Resume lets paused workers take jobs again.
+func (p *WorkerPool) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		p.paused = false
+		p.pausing = make(chan struct{})
+		close(p.resumed)
 	}
 }
 
+// Paused reports whether the pool is paused.
+func (p *WorkerPool) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// pauseState returns the channel closed on the next Pause, or, while the
+// pool is paused, nil and the channel closed on Resume.
+func (p *WorkerPool) pauseState() (pausing, resumed <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return nil, p.resumed
+	}
+	return p.pausing, nil
+}
+
 // SetWorkerCount scales the number of workers up or down gracefully.
 func (p *WorkerPool) SetWorkerCount(count int) {
 	p.mu.Lock()
@@ -56,6 +130,108 @@ func (p *WorkerPool) WorkerCount() int {
 	return p.workerCount
 }
 
+// SetIdleTask installs work for workers to pick up while the job channel is
+// empty. Queued jobs always take priority: a worker only runs the idle task
+// after finding no job ready. wake, if non-nil, should receive a value when
+// new idle work becomes available so blocked workers re-check for it.
+func (p *WorkerPool) SetIdleTask(task IdleTask, wake <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idleTask = task
+	p.idleWake = wake
+}
+
+// SetQueueBudget makes workers release each dequeued job from budget, which
+// the producer reserved it against.
+func (p *WorkerPool) SetQueueBudget(budget *QueueBudget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.budget = budget
+}
+
+// SetRunSlots makes workers hold a slot of a shared Scheduler while they
+// run each job, so the pool's run gets only its share of the host. The
+// handler's streams keep to the run's bandwidth when opened through a
+// provider wrapped with slots.Throttle.
+func (p *WorkerPool) SetRunSlots(slots *RunSlots) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.slots = slots
+}
+
+// SetDrainTimeout makes the pool drain when its context is cancelled:
+// workers stop taking jobs at once, but the jobs running are given up to
+// timeout to finish before the context they run with is cancelled too, so
+// an interrupted run does not abort writes it could have completed. Zero,
+// the default, cancels them at once. Stop always does.
+func (p *WorkerPool) SetDrainTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grace = timeout
+}
+
+// SetPathLimits makes workers hold jobs to limits, parking those whose
+// limit is reached rather than waiting on them, so one slow target does
+// not tie up the workers of the others.
+func (p *WorkerPool) SetPathLimits(limits *PathLimits) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limits = limits
+}
+
+// handle releases job from the queue budget, if any, and runs the handler,
+// within a scheduler slot if the pool has one. Under path limits it parks
+// the job if its limit is reached, and goes on to run the parked jobs its
+// own job's completion lets run.
+func (p *WorkerPool) handle(job TransferJob) {
+	p.mu.Lock()
+	budget, slots, limits := p.budget, p.slots, p.limits
+	p.mu.Unlock()
+	if budget != nil {
+		budget.Release(job)
+	}
+	if limits != nil && !limits.Acquire(job) {
+		return
+	}
+	for {
+		p.run(slots, job)
+		if limits == nil {
+			return
+		}
+		next, ok := limits.Release(job)
+		if !ok || p.stopped() {
+			return
+		}
+		job = next
+	}
+}
+
+// take handles a job received from the job channel, or records that the
+// channel is closed if ok is false.
+func (p *WorkerPool) take(job TransferJob, ok bool) {
+	if !ok {
+		p.exhaustOnce.Do(func() { close(p.exhausted) })
+		return
+	}
+	p.handle(job)
+}
+
+func (p *WorkerPool) run(slots *RunSlots, job TransferJob) {
+	if slots != nil {
+		if err := slots.Acquire(p.ctx); err != nil {
+			return
+		}
+		defer slots.Release()
+	}
+	_ = p.handler(p.ctx, job)
+}
+
+func (p *WorkerPool) idle() (IdleTask, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idleTask, p.idleWake
+}
+
 func (p *WorkerPool) addWorker() {
 	quitChan := make(chan struct{})
 	id := p.nextID
@@ -71,25 +247,60 @@ func (p *WorkerPool) addWorker() {
 			select {
 			case <-quit:
 				return
-			case <-p.ctx.Done():
+			case <-p.halted:
 				return
 			default:
 			}
 
+			// Paused workers wait without taking jobs or idle work
+			pausing, resumed := p.pauseState()
+			if resumed != nil {
+				select {
+				case <-quit:
+					return
+				case <-p.halted:
+					return
+				case <-resumed:
+				}
+				continue
+			}
+
+			// Take a queued job if one is ready; only fall back to idle
+			// work when the queue is empty.
+			idleTask, idleWake := p.idle()
+			if idleTask != nil {
+				select {
+				case job, ok := <-p.jobChan:
+					p.take(job, ok)
+					if !ok {
+						return
+					}
+					continue
+				default:
+				}
+				if idleTask(p.ctx) {
+					continue
+				}
+			}
+
 			select {
 			case <-quit:
 				// Worker decommissioned gracefully
 				return
-			case <-p.ctx.Done():
-				// Pool stopped, exit
+			case <-p.halted:
+				// Pool stopped or draining, exit
 				return
+			case <-idleWake:
+				// New idle work available, re-check the queue first
+			case <-pausing:
+				// Paused while waiting for a job
 			case job, ok := <-p.jobChan:
+				// Execute the job
+				p.take(job, ok)
 				if !ok {
 					// Job channel closed, exit
 					return
 				}
-				// Execute the job
-				_ = p.handler(p.ctx, job)
 			}
 		}
 	}(id, quitChan)
@@ -105,9 +316,63 @@ func (p *WorkerPool) removeWorker() {
 	}
 }
 
+// Wait blocks until every job has been handled: the job channel is closed
+// and drained, and each worker has finished the jobs it took, parked ones
+// included, and exited. It does not return early if the workers are scaled
+// down to none meanwhile: the jobs left wait for workers to be added. A
+// stopped pool, or one whose context is done, leaves the jobs not yet
+// started, and Wait returns once its workers have exited.
+func (p *WorkerPool) Wait() {
+	select {
+	case <-p.exhausted:
+	case <-p.halted:
+	}
+	p.wg.Wait()
+}
+
 // Stop initiates termination of all workers and waits for them to exit.
 // Jobs currently running might be aborted since the context is cancelled.
 func (p *WorkerPool) Stop() {
+	p.halt()
 	p.cancel()
 	p.wg.Wait()
 }
+
+// halt stops workers from taking jobs.
+func (p *WorkerPool) halt() {
+	p.haltOnce.Do(func() { close(p.halted) })
+}
+
+// stopped reports whether workers have stopped taking jobs.
+func (p *WorkerPool) stopped() bool {
+	select {
+	case <-p.halted:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain runs once the pool's parent context is done: it halts the workers,
+// waits up to the drain timeout for the jobs running to finish, then
+// cancels the context of any still running.
+func (p *WorkerPool) drain() {
+	p.halt()
+	p.mu.Lock()
+	timeout := p.grace
+	p.mu.Unlock()
+	if timeout > 0 {
+		finished := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(finished)
+		}()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-finished:
+		case <-timer.C:
+		}
+	}
+	p.cancel()
+}
@@ -3,6 +3,7 @@ package engine_test
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -67,3 +68,168 @@ func TestWorkerPool_Execution(t *testing.T) {
 
 	pool.Stop()
 }
+
+func TestWorkerPool_Pause(t *testing.T) {
+	ch := make(engine.JobChannel, 100)
+	var processed atomic.Int64
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := func(ctx context.Context, job engine.TransferJob) error {
+		if job.SourcePath == "running" {
+			close(started)
+			<-finish
+		}
+		processed.Add(1)
+		return nil
+	}
+
+	pool := engine.NewWorkerPool(context.Background(), ch, handler)
+	defer pool.Stop()
+	pool.SetWorkerCount(3)
+
+	// The running job finishes after Pause, but no other starts
+	ch <- engine.TransferJob{SourcePath: "running"}
+	<-started
+	pool.Pause()
+	if !pool.Paused() {
+		t.Fatal("Expected the pool to be paused")
+	}
+	for i := 0; i < 5; i++ {
+		ch <- engine.TransferJob{SourcePath: "queued"}
+	}
+	close(finish)
+	time.Sleep(50 * time.Millisecond)
+	if n := processed.Load(); n != 1 {
+		t.Fatalf("Expected only the running job to finish while paused, got %d", n)
+	}
+
+	pool.Resume()
+	deadline := time.Now().Add(2 * time.Second)
+	for processed.Load() != 6 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := processed.Load(); n != 6 {
+		t.Errorf("Expected every job processed after Resume, got %d", n)
+	}
+	if pool.Paused() {
+		t.Error("Expected the pool to be running")
+	}
+}
+
+func TestWorkerPool_Drain(t *testing.T) {
+	ch := make(engine.JobChannel, 100)
+	var processed atomic.Int64
+	started := make(chan engine.TransferJob, 2)
+	finish := make(chan struct{})
+	handler := func(ctx context.Context, job engine.TransferJob) error {
+		if job.SourcePath == "queued" {
+			processed.Add(1)
+			return nil
+		}
+		started <- job
+		select {
+		case <-finish:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if ctx.Err() != nil {
+			t.Errorf("Expected %s to run to its end uncancelled", job.SourcePath)
+		}
+		processed.Add(1)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := engine.NewWorkerPool(ctx, ch, handler)
+	pool.SetDrainTimeout(5 * time.Second)
+	pool.SetWorkerCount(2)
+
+	// Running jobs finish once the run is cancelled; queued ones stay queued
+	ch <- engine.TransferJob{SourcePath: "a"}
+	ch <- engine.TransferJob{SourcePath: "b"}
+	<-started
+	<-started
+	for i := 0; i < 5; i++ {
+		ch <- engine.TransferJob{SourcePath: "queued"}
+	}
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(finish)
+	pool.Wait()
+	if n := processed.Load(); n != 2 {
+		t.Errorf("Expected only the 2 running jobs processed, got %d", n)
+	}
+	if len(ch) != 5 {
+		t.Errorf("Expected the 5 queued jobs left queued, got %d", len(ch))
+	}
+}
+
+func TestWorkerPool_DrainTimeout(t *testing.T) {
+	ch := make(engine.JobChannel, 1)
+	started := make(chan struct{})
+	var aborted atomic.Bool
+	handler := func(ctx context.Context, job engine.TransferJob) error {
+		close(started)
+		<-ctx.Done()
+		aborted.Store(true)
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := engine.NewWorkerPool(ctx, ch, handler)
+	pool.SetDrainTimeout(50 * time.Millisecond)
+	pool.SetWorkerCount(1)
+	ch <- engine.TransferJob{SourcePath: "stuck"}
+	<-started
+
+	start := time.Now()
+	cancel()
+	pool.Wait()
+	if !aborted.Load() {
+		t.Fatal("Expected the job running past the drain timeout to be cancelled")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the job to get the drain timeout first, cancelled after %v", elapsed)
+	}
+}
+
+func TestWorkerPool_WaitForEveryJob(t *testing.T) {
+	ch := make(engine.JobChannel, 10)
+	var processed atomic.Int64
+	handler := func(ctx context.Context, job engine.TransferJob) error {
+		time.Sleep(time.Millisecond)
+		processed.Add(1)
+		return nil
+	}
+
+	pool := engine.NewWorkerPool(context.Background(), ch, handler)
+	defer pool.Stop()
+	pool.SetWorkerCount(1)
+	pool.SetWorkerCount(0)
+	for i := 0; i < 10; i++ {
+		ch <- engine.TransferJob{SourcePath: "queued"}
+	}
+	close(ch)
+
+	// Jobs still queued hold Wait though no worker is left to run them
+	waited := make(chan struct{})
+	go func() {
+		pool.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatalf("Expected Wait to hold while jobs are queued, %d processed", processed.Load())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.SetWorkerCount(3)
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Wait to return once the queue was worked off")
+	}
+	if n := processed.Load(); n != 10 {
+		t.Errorf("Expected every job processed before Wait returned, got %d", n)
+	}
+}
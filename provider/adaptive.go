@@ -0,0 +1,362 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultThrottleCooldown is how long a ConcurrencyLimiter ignores further
+// throttling after reducing its limit, when Cooldown is unset. Requests
+// already in flight when the limit drops are likely to be throttled too,
+// and should not shrink it again.
+const DefaultThrottleCooldown = time.Second
+
+// ConcurrencyLimiter adapts how many streams may be open against one backend
+// at once: it halves the limit whenever the backend throttles a request and
+// raises it by one after each run of limit successful requests, up to the
+// maximum it was created with.
+type ConcurrencyLimiter struct {
+	// Cooldown is the time after a reduction during which throttling does
+	// not reduce the limit again. Zero means DefaultThrottleCooldown.
+	Cooldown time.Duration
+	// OnChange, if set, is called with the new limit whenever it changes.
+	// It is called with the limiter locked and must not call back into it.
+	OnChange func(limit int)
+
+	mu        sync.Mutex
+	max       int
+	limit     int
+	active    int
+	successes int
+	reducedAt time.Time
+	waiters   []chan struct{}
+	now       func() time.Time
+}
+
+// NewConcurrencyLimiter returns a limiter allowing up to max open streams.
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &ConcurrencyLimiter{max: max, limit: max, now: time.Now}
+}
+
+// Limit returns the number of streams currently allowed at once.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Acquire waits until fewer than Limit streams are open and takes a slot,
+// or returns ctx's error.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.active < l.limit && len(l.waiters) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, w := range l.waiters {
+			if w == ready {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was granted while ctx was cancelled; pass it on
+		l.active--
+		l.grant()
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot taken by Acquire.
+func (l *ConcurrencyLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.grant()
+}
+
+// Observe feeds the outcome of a request to the limiter. Throttling errors
+// reduce the limit, successes raise it again; other errors say nothing
+// about the backend's load and are ignored.
+func (l *ConcurrencyLimiter) Observe(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case err == nil:
+		if l.limit == l.max {
+			return
+		}
+		if l.successes++; l.successes >= l.limit {
+			l.setLimit(l.limit + 1)
+			l.grant()
+		}
+	case IsThrottled(err):
+		cooldown := l.Cooldown
+		if cooldown <= 0 {
+			cooldown = DefaultThrottleCooldown
+		}
+		now := l.now()
+		if !l.reducedAt.IsZero() && now.Sub(l.reducedAt) < cooldown {
+			return
+		}
+		l.reducedAt = now
+		l.setLimit(max(l.limit/2, 1))
+	}
+}
+
+// setLimit changes the limit and restarts the count of successes. The
+// caller holds l.mu.
+func (l *ConcurrencyLimiter) setLimit(limit int) {
+	l.successes = 0
+	if limit == l.limit {
+		return
+	}
+	l.limit = limit
+	if l.OnChange != nil {
+		l.OnChange(limit)
+	}
+}
+
+// grant hands free slots to waiters in arrival order. The caller holds l.mu.
+func (l *ConcurrencyLimiter) grant() {
+	for len(l.waiters) > 0 && l.active < l.limit {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		l.active++
+	}
+}
+
+// adaptiveProvider limits the streams open against the wrapped provider.
+type adaptiveProvider struct {
+	Wrapper
+	limiter *ConcurrencyLimiter
+}
+
+// WithAdaptiveConcurrency wraps p so that each stream opened from it holds
+// a slot of limiter until closed, and server-side copies hold one while
+// they run. Every call reports its outcome to limiter, so the streams open
+// at once shrink while the backend throttles requests and grow back as it
+// recovers. It belongs beneath WithRetry, so that each throttled attempt is
+// counted and the slot is given up during the backoff.
+func WithAdaptiveConcurrency(p Provider, limiter *ConcurrencyLimiter) Provider {
+	if limiter == nil {
+		return p
+	}
+	return &adaptiveProvider{Wrapper: Wrapper{p}, limiter: limiter}
+}
+
+func (a *adaptiveProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	info, err := a.Provider.Stat(ctx, path)
+	a.limiter.Observe(err)
+	return info, err
+}
+
+func (a *adaptiveProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	entries, err := a.Provider.List(ctx, path)
+	a.limiter.Observe(err)
+	return entries, err
+}
+
+func (a *adaptiveProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	return a.openRead(ctx, func() (io.ReadCloser, error) {
+		return a.Provider.OpenRead(ctx, path)
+	})
+}
+
+func (a *adaptiveProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if !CapabilitiesOf(a.Provider).RangedRead {
+		// The fallback reads through OpenRead, which already holds a slot.
+		return OpenReadRange(ctx, a, path, offset, length)
+	}
+	return a.openRead(ctx, func() (io.ReadCloser, error) {
+		return a.Wrapper.OpenReadRange(ctx, path, offset, length)
+	})
+}
+
+func (a *adaptiveProvider) OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error) {
+	return a.openRead(ctx, func() (io.ReadCloser, error) {
+		return a.Wrapper.OpenReadVersion(ctx, path, versionID)
+	})
+}
+
+func (a *adaptiveProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	if err := a.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	wc, err := a.Provider.OpenWrite(ctx, path, metadata)
+	a.limiter.Observe(err)
+	if err != nil {
+		a.limiter.Release()
+		return nil, err
+	}
+	return &adaptiveWriter{WriteCloser: wc, limiter: a.limiter}, nil
+}
+
+func (a *adaptiveProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	if err := a.limiter.Acquire(ctx); err != nil {
+		return nil, 0, err
+	}
+	wc, offset, err := a.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+	a.limiter.Observe(err)
+	if err != nil {
+		a.limiter.Release()
+		return nil, 0, err
+	}
+	return &adaptiveWriter{WriteCloser: wc, limiter: a.limiter}, offset, nil
+}
+
+func (a *adaptiveProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	if err := a.limiter.Acquire(ctx); err != nil {
+		return nil, 0, err
+	}
+	wc, offset, err := a.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+	a.limiter.Observe(err)
+	if err != nil {
+		a.limiter.Release()
+		return nil, 0, err
+	}
+	return &adaptiveWriter{WriteCloser: wc, limiter: a.limiter}, offset, nil
+}
+
+func (a *adaptiveProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	var cf ChunkedFile
+	err := a.limited(ctx, func() (err error) {
+		cf, err = a.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &adaptiveChunkedFile{ChunkedFile: cf, a: a}, nil
+}
+
+// limited runs one request under the limiter.
+func (a *adaptiveProvider) limited(ctx context.Context, fn func() error) error {
+	if err := a.limiter.Acquire(ctx); err != nil {
+		return err
+	}
+	defer a.limiter.Release()
+	err := fn()
+	a.limiter.Observe(err)
+	return err
+}
+
+func (a *adaptiveProvider) CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error) {
+	if err := a.limiter.Acquire(ctx); err != nil {
+		return Digest{}, err
+	}
+	defer a.limiter.Release()
+	digest, err := a.Wrapper.CopyFrom(ctx, src, srcPath, dstPath, info)
+	a.limiter.Observe(err)
+	return digest, err
+}
+
+func (a *adaptiveProvider) openRead(ctx context.Context, open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if err := a.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	rc, err := open()
+	a.limiter.Observe(err)
+	if err != nil {
+		a.limiter.Release()
+		return nil, err
+	}
+	return &adaptiveReader{ReadCloser: rc, limiter: a.limiter}, nil
+}
+
+// adaptiveReader reports throttled reads, such as the part GETs of a
+// parallel download, and releases its slot when closed.
+type adaptiveReader struct {
+	io.ReadCloser
+	limiter *ConcurrencyLimiter
+	once    sync.Once
+}
+
+// Unwrap returns the wrapped reader, for SourceFile.
+func (r *adaptiveReader) Unwrap() io.Reader { return r.ReadCloser }
+
+// Sent has nothing to account for: local files are not throttled.
+func (r *adaptiveReader) Sent(n int64) error { return nil }
+
+func (r *adaptiveReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if IsThrottled(err) {
+		r.limiter.Observe(err)
+	}
+	return n, err
+}
+
+func (r *adaptiveReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.limiter.Release)
+	return err
+}
+
+// adaptiveWriter reports the outcome of an upload, whose part requests are
+// only known once it is closed, and releases its slot.
+// adaptiveChunkedFile limits each chunk, which several streams write at
+// once, as a request of its own.
+type adaptiveChunkedFile struct {
+	ChunkedFile
+	a *adaptiveProvider
+}
+
+func (f *adaptiveChunkedFile) WriteChunk(ctx context.Context, index int, r io.Reader) error {
+	return f.a.limited(ctx, func() error { return f.ChunkedFile.WriteChunk(ctx, index, r) })
+}
+
+func (f *adaptiveChunkedFile) Commit(ctx context.Context) error {
+	return f.a.limited(ctx, func() error { return f.ChunkedFile.Commit(ctx) })
+}
+
+type adaptiveWriter struct {
+	io.WriteCloser
+	limiter *ConcurrencyLimiter
+	once    sync.Once
+}
+
+func (w *adaptiveWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if IsThrottled(err) {
+		w.limiter.Observe(err)
+	}
+	return n, err
+}
+
+// Unwrap returns the wrapped writer, for Committed.
+func (w *adaptiveWriter) Unwrap() io.WriteCloser { return w.WriteCloser }
+
+// SendFile copies in the kernel through the wrapped writer.
+func (w *adaptiveWriter) SendFile(ctx context.Context, src *os.File, n int64) (int64, error) {
+	m, err := SendFile(ctx, w.WriteCloser, src, n)
+	if IsThrottled(err) {
+		w.limiter.Observe(err)
+	}
+	return m, err
+}
+
+func (w *adaptiveWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.once.Do(func() {
+		w.limiter.Observe(err)
+		w.limiter.Release()
+	})
+	return err
+}
@@ -0,0 +1,187 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// busyProvider fails OpenRead and OpenWrite with err while it is set.
+type busyProvider struct {
+	Provider
+	err error
+}
+
+func (b *busyProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return io.NopCloser(strings.NewReader(path)), nil
+}
+
+func (b *busyProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	return &closeErrWriter{err: b.err}, nil
+}
+
+// closeErrWriter fails Close with err, as an upload whose parts were
+// throttled does.
+type closeErrWriter struct{ err error }
+
+func (w *closeErrWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *closeErrWriter) Close() error                { return w.err }
+
+func TestConcurrencyLimiter_AIMD(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewConcurrencyLimiter(8)
+	l.now = func() time.Time { return now }
+	var changes []int
+	l.OnChange = func(limit int) { changes = append(changes, limit) }
+
+	l.Observe(codeError{"SlowDown"})
+	if got := l.Limit(); got != 4 {
+		t.Fatalf("Expected SlowDown to halve the limit to 4, got %d", got)
+	}
+	l.Observe(statusError{503})
+	if got := l.Limit(); got != 4 {
+		t.Errorf("Expected throttling within the cooldown to be ignored, got %d", got)
+	}
+	l.Observe(statusError{404})
+	now = now.Add(DefaultThrottleCooldown)
+	l.Observe(statusError{503})
+	if got := l.Limit(); got != 2 {
+		t.Fatalf("Expected throttling after the cooldown to halve the limit to 2, got %d", got)
+	}
+
+	for i := 0; i < 2+3+4+5+6+7; i++ {
+		l.Observe(nil)
+	}
+	if got := l.Limit(); got != 8 {
+		t.Errorf("Expected successes to restore the limit to 8, got %d", got)
+	}
+	l.Observe(nil)
+	if got := l.Limit(); got != 8 {
+		t.Errorf("Expected the limit to stay at its maximum, got %d", got)
+	}
+
+	want := []int{4, 2, 3, 4, 5, 6, 7, 8}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected changes %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("Expected changes %v, got %v", want, changes)
+		}
+	}
+}
+
+func TestConcurrencyLimiter_Floor(t *testing.T) {
+	l := NewConcurrencyLimiter(1)
+	l.Observe(codeError{"SlowDown"})
+	if got := l.Limit(); got != 1 {
+		t.Errorf("Expected the limit never to drop below 1, got %d", got)
+	}
+}
+
+func TestConcurrencyLimiter_Acquire(t *testing.T) {
+	l := NewConcurrencyLimiter(2)
+	ctx := context.Background()
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reduced limit holds new streams back until enough have closed
+	l.Observe(codeError{"SlowDown"})
+	acquired := make(chan struct{})
+	go func() {
+		l.Acquire(ctx)
+		close(acquired)
+	}()
+	l.Release()
+	select {
+	case <-acquired:
+		t.Fatal("Expected Acquire to wait while the limit is exceeded")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Acquire to proceed once below the limit")
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.Acquire(cctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled Acquire to fail, got %v", err)
+	}
+	l.Release()
+	if err := l.Acquire(ctx); err != nil {
+		t.Errorf("Expected the slot to be free after a cancelled Acquire, got %v", err)
+	}
+}
+
+func TestWithAdaptiveConcurrency(t *testing.T) {
+	backend := &busyProvider{}
+	limiter := NewConcurrencyLimiter(2)
+	p := WithAdaptiveConcurrency(backend, limiter)
+	ctx := context.Background()
+
+	// Open streams hold their slot until closed
+	r1, err := p.OpenRead(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := p.OpenRead(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.OpenRead(tctx, "c"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a third stream to wait for a slot, got %v", err)
+	}
+	r1.Close()
+	r1.Close()
+	r2.Close()
+
+	// A throttled open halves the limit and gives its slot back
+	backend.err = codeError{"SlowDown"}
+	if _, err := p.OpenRead(ctx, "a"); err == nil {
+		t.Fatal("Expected OpenRead to fail")
+	}
+	if got := limiter.Limit(); got != 1 {
+		t.Errorf("Expected a throttled open to halve the limit, got %d", got)
+	}
+
+	// Uploads report throttled parts when they are closed
+	limiter = NewConcurrencyLimiter(2)
+	p = WithAdaptiveConcurrency(backend, limiter)
+	backend.err = statusError{503}
+	w, err := p.OpenWrite(ctx, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Expected Close to fail")
+	}
+	if got := limiter.Limit(); got != 1 {
+		t.Errorf("Expected a throttled upload to halve the limit, got %d", got)
+	}
+	backend.err = nil
+	if _, err := p.OpenRead(ctx, "a"); err != nil {
+		t.Errorf("Expected the upload's slot to be released, got %v", err)
+	}
+}
+
+func TestWithAdaptiveConcurrency_Nil(t *testing.T) {
+	backend := &busyProvider{}
+	if p := WithAdaptiveConcurrency(backend, nil); p != Provider(backend) {
+		t.Error("Expected a nil limiter to leave the provider unwrapped")
+	}
+}
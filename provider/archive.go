@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ArchivedError is returned for files held in archive storage, such as the
+// S3 Glacier storage classes, that must be restored before they can be read.
+type ArchivedError struct {
+	Path         string
+	StorageClass string
+	// Restoring means a restore has been requested and is in progress.
+	Restoring bool
+}
+
+func (e *ArchivedError) Error() string {
+	if e.Restoring {
+		return fmt.Sprintf("%s is archived in %s and still being restored", e.Path, e.StorageClass)
+	}
+	return fmt.Sprintf("%s is archived in %s and must be restored before it can be read", e.Path, e.StorageClass)
+}
+
+// ArchivedFileInfo is implemented by the FileInfo of providers with
+// archive storage.
+type ArchivedFileInfo interface {
+	FileInfo
+	// ArchiveState returns the storage class holding the content, whether
+	// the content is archived and cannot be read until restored, and
+	// whether a restore is in progress.
+	ArchiveState() (storageClass string, archived, restoring bool)
+}
+
+// Archived returns an *ArchivedError for path if info describes content
+// that must be restored before it can be read, or nil if it can be read.
+func Archived(path string, info FileInfo) *ArchivedError {
+	a, ok := archivedInfo(info)
+	if !ok {
+		return nil
+	}
+	class, archived, restoring := a.ArchiveState()
+	if !archived {
+		return nil
+	}
+	return &ArchivedError{Path: path, StorageClass: class, Restoring: restoring}
+}
+
+// archivedInfo returns the ArchivedFileInfo beneath any wrappers of info.
+func archivedInfo(info FileInfo) (ArchivedFileInfo, bool) {
+	for info != nil {
+		switch i := info.(type) {
+		case ArchivedFileInfo:
+			return i, true
+		case *symlinkFileInfo:
+			info = i.UnixFileInfo
+		case *windowsFileInfo:
+			info = i.UnixFileInfo
+		case *unixFileInfo:
+			info = i.FileInfo
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Restore tiers trade cost for speed; S3 Glacier Flexible Retrieval takes
+// minutes with RestoreExpedited, hours with RestoreStandard and up to half a
+// day with RestoreBulk, Deep Archive about four times as long.
+const (
+	RestoreExpedited = "Expedited"
+	RestoreStandard  = "Standard"
+	RestoreBulk      = "Bulk"
+)
+
+// ParseRestoreTier validates a restore tier name, ignoring case.
+func ParseRestoreTier(s string) (string, error) {
+	for _, tier := range []string{RestoreExpedited, RestoreStandard, RestoreBulk} {
+		if strings.EqualFold(s, tier) {
+			return tier, nil
+		}
+	}
+	return "", fmt.Errorf("unknown restore tier %q (want expedited, standard or bulk)", s)
+}
+
+// RestoreOptions configures a restore request.
+type RestoreOptions struct {
+	// Tier is one of RestoreExpedited, RestoreStandard or RestoreBulk;
+	// empty means the provider's default.
+	Tier string
+	// Days is how long the restored copy stays readable.
+	Days int32
+}
+
+// Restorer is implemented by providers with archive storage whose files
+// can be restored for reading.
+type Restorer interface {
+	// Restore requests a readable copy of an archived file. Requesting a
+	// restore that is already in progress succeeds.
+	Restore(ctx context.Context, path string, opts RestoreOptions) error
+}
@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRestoreTier(t *testing.T) {
+	for in, want := range map[string]string{"bulk": RestoreBulk, "Standard": RestoreStandard, "EXPEDITED": RestoreExpedited} {
+		if got, err := ParseRestoreTier(in); err != nil || got != want {
+			t.Errorf("ParseRestoreTier(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseRestoreTier("slow"); err == nil {
+		t.Error("Expected an error for an unknown tier")
+	}
+}
+
+func TestArchived(t *testing.T) {
+	cold := &s3FileInfo{name: "a", modTime: time.Now(), storageClass: "GLACIER", archived: true}
+	if err := Archived("a", NewUnixFileInfo(cold, 1, 1, 0o644)); err == nil || err.StorageClass != "GLACIER" {
+		t.Errorf("Expected archived through a UnixFileInfo, got %v", err)
+	}
+	if err := Archived("a", &s3FileInfo{name: "a", storageClass: "STANDARD"}); err != nil {
+		t.Errorf("Expected STANDARD readable, got %v", err)
+	}
+	if err := Archived("a", &localFileInfo{name: "a"}); err != nil {
+		t.Errorf("Expected local file readable, got %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CachingProvider memoizes Stat and List results of the wrapped provider
+// for a fixed TTL, so repeated or incremental runs do not pay for the same
+// HEAD and LIST requests again. Writes, deletes and renames made through
+// the cache invalidate the affected entries; changes made by anyone else
+// become visible once the TTL expires.
+type CachingProvider struct {
+	Wrapper
+	ttl time.Duration
+
+	mu    sync.Mutex
+	stats map[string]cachedStat
+	lists map[string]cachedList
+	hits  int64
+	miss  int64
+}
+
+type cachedStat struct {
+	info    FileInfo
+	expires time.Time
+}
+
+type cachedList struct {
+	entries []FileInfo
+	expires time.Time
+}
+
+// WithCache wraps p with a Stat/List cache whose entries live for ttl.
+func WithCache(p Provider, ttl time.Duration) *CachingProvider {
+	return &CachingProvider{
+		Wrapper: Wrapper{p},
+		ttl:     ttl,
+		stats:   make(map[string]cachedStat),
+		lists:   make(map[string]cachedList),
+	}
+}
+
+// noCacheKey marks a context from WithoutCache.
+type noCacheKey struct{}
+
+// WithoutCache returns a context under which a CachingProvider's Stat and
+// List skip the cache and ask the wrapped provider, caching the result as
+// on a miss. Use it for lookups that must see changes made by others, such
+// as whether a restore has completed.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(noCacheKey{}).(bool)
+	return bypass
+}
+
+// Stat returns the cached FileInfo for path, or stats it on a miss.
+func (c *CachingProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	c.mu.Lock()
+	if e, ok := c.stats[path]; ok && !cacheBypassed(ctx) && time.Now().Before(e.expires) {
+		c.hits++
+		c.mu.Unlock()
+		return e.info, nil
+	}
+	c.miss++
+	c.mu.Unlock()
+
+	info, err := c.Provider.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	// Archived files change state as restores complete, without being
+	// written through the cache
+	if Archived(path, info) != nil {
+		return info, nil
+	}
+
+	c.mu.Lock()
+	c.stats[path] = cachedStat{info: info, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return info, nil
+}
+
+// List returns the cached listing of path, or lists it on a miss.
+func (c *CachingProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	c.mu.Lock()
+	if e, ok := c.lists[path]; ok && !cacheBypassed(ctx) && time.Now().Before(e.expires) {
+		c.hits++
+		c.mu.Unlock()
+		return append([]FileInfo(nil), e.entries...), nil
+	}
+	c.miss++
+	c.mu.Unlock()
+
+	entries, err := c.Provider.List(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.lists[path] = cachedList{entries: append([]FileInfo(nil), entries...), expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return entries, nil
+}
+
+// OpenWrite invalidates path before opening it for writing.
+func (c *CachingProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	c.Invalidate(path)
+	return c.Provider.OpenWrite(ctx, path, metadata)
+}
+
+// OpenWriteResumable invalidates path before opening it for writing.
+func (c *CachingProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	c.Invalidate(path)
+	return c.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+}
+
+// OpenWriteAt invalidates path before opening it for writing.
+func (c *CachingProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	c.Invalidate(path)
+	return c.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+}
+
+// OpenChunked invalidates path before opening it for writing.
+func (c *CachingProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	c.Invalidate(path)
+	return c.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+}
+
+// Delete invalidates path and deletes it.
+func (c *CachingProvider) Delete(ctx context.Context, path string) error {
+	c.Invalidate(path)
+	return c.Wrapper.Delete(ctx, path)
+}
+
+// DeleteBatch invalidates paths and deletes them.
+func (c *CachingProvider) DeleteBatch(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		c.Invalidate(path)
+	}
+	return c.Wrapper.DeleteBatch(ctx, paths)
+}
+
+// Rename invalidates both paths and renames.
+func (c *CachingProvider) Rename(ctx context.Context, from, to string) error {
+	c.Invalidate(from)
+	c.Invalidate(to)
+	return c.Wrapper.Rename(ctx, from, to)
+}
+
+// Symlink invalidates path and creates the link.
+func (c *CachingProvider) Symlink(ctx context.Context, target, path string) error {
+	c.Invalidate(path)
+	return c.Wrapper.Symlink(ctx, target, path)
+}
+
+// MakeDir invalidates path and creates the directory.
+func (c *CachingProvider) MakeDir(ctx context.Context, path string, info FileInfo) error {
+	c.Invalidate(path)
+	return c.Wrapper.MakeDir(ctx, path, info)
+}
+
+// Link invalidates path and creates the link.
+func (c *CachingProvider) Link(ctx context.Context, existing, path string) error {
+	c.Invalidate(path)
+	return c.Wrapper.Link(ctx, existing, path)
+}
+
+// CopyFrom invalidates dstPath and copies srcPath on src to it.
+func (c *CachingProvider) CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error) {
+	c.Invalidate(dstPath)
+	return c.Wrapper.CopyFrom(ctx, src, srcPath, dstPath, info)
+}
+
+// Undelete invalidates the entry's path and restores it.
+func (c *CachingProvider) Undelete(ctx context.Context, entry DeletedEntry) error {
+	c.Invalidate(entry.Path)
+	return c.Wrapper.Undelete(ctx, entry)
+}
+
+// Restore invalidates path and requests its restore, so that its state is
+// looked up again.
+func (c *CachingProvider) Restore(ctx context.Context, path string, opts RestoreOptions) error {
+	c.Invalidate(path)
+	return c.Wrapper.Restore(ctx, path, opts)
+}
+
+// Invalidate drops the cached Stat of path and the cached listings of path
+// and its parent directory.
+func (c *CachingProvider) Invalidate(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.stats, p)
+	delete(c.lists, p)
+	delete(c.lists, filepath.Dir(p))
+	delete(c.lists, path.Dir(p))
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (c *CachingProvider) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.miss
+}
+
+// cacheFile is the on-disk form of a CachingProvider.
+type cacheFile struct {
+	Stats map[string]persistedStat `json:"stats"`
+	Lists map[string]persistedList `json:"lists"`
+}
+
+type persistedStat struct {
+	Info    persistedInfo `json:"info"`
+	Expires time.Time     `json:"expires"`
+}
+
+type persistedList struct {
+	Entries []persistedInfo `json:"entries"`
+	Expires time.Time       `json:"expires"`
+}
+
+// persistedInfo captures everything the providers report about a file.
+type persistedInfo struct {
+	Name     string         `json:"name"`
+	Size     int64          `json:"size"`
+	IsDir    bool           `json:"is_dir,omitempty"`
+	ModTime  time.Time      `json:"mod_time"`
+	Unix     bool           `json:"unix,omitempty"`
+	UID      uint32         `json:"uid,omitempty"`
+	GID      uint32         `json:"gid,omitempty"`
+	Mode     os.FileMode    `json:"mode,omitempty"`
+	Windows  bool           `json:"windows,omitempty"`
+	Attrs    FileAttributes `json:"attrs,omitempty"`
+	OwnerSID string         `json:"owner_sid,omitempty"`
+	Link     *string        `json:"link,omitempty"`
+	ATime    time.Time      `json:"atime,omitzero"`
+	BTime    time.Time      `json:"btime,omitzero"`
+	Dev      uint64         `json:"dev,omitempty"`
+	HasDev   bool           `json:"has_dev,omitempty"`
+
+	// Tagged means Tags were looked up, so none is different from unknown
+	Tagged bool              `json:"tagged,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
+
+	// ContentTyped means ContentType was looked up
+	ContentTyped bool   `json:"content_typed,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+
+	// Archive means the storage class and archive state were reported
+	Archive      bool   `json:"archive,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	Archived     bool   `json:"archived,omitempty"`
+	Restoring    bool   `json:"restoring,omitempty"`
+}
+
+func persistInfo(info FileInfo) persistedInfo {
+	pi := persistedInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+	}
+	pi.ATime, pi.BTime = FileTimes(info)
+	pi.Dev, pi.HasDev = Device(info)
+	if u, ok := info.(UnixFileInfo); ok {
+		pi.Unix, pi.UID, pi.GID, pi.Mode = true, u.UID(), u.GID(), u.Mode()
+	}
+	if w, ok := info.(WindowsFileInfo); ok {
+		pi.Windows, pi.Attrs, pi.OwnerSID = true, w.Attributes(), w.OwnerSID()
+	}
+	if target, ok := SymlinkTarget(info); ok {
+		pi.Link = &target
+	}
+	pi.Tags, pi.Tagged = ObjectTags(info)
+	pi.ContentType, pi.ContentTyped = ObjectContentType(info)
+	if a, ok := archivedInfo(info); ok {
+		pi.Archive = true
+		pi.StorageClass, pi.Archived, pi.Restoring = a.ArchiveState()
+	}
+	return pi
+}
+
+func (pi persistedInfo) fileInfo() FileInfo {
+	var info FileInfo = &localFileInfo{name: pi.Name, size: pi.Size, isDir: pi.IsDir, modTime: pi.ModTime, atime: pi.ATime, btime: pi.BTime, dev: pi.Dev, hasDev: pi.HasDev}
+	if pi.Archive {
+		// Archived finds the state only on the object itself, not beneath
+		// tag or content type wrappers
+		info = &s3FileInfo{
+			name: pi.Name, size: pi.Size, isDir: pi.IsDir, modTime: pi.ModTime,
+			storageClass: pi.StorageClass, archived: pi.Archived, restoring: pi.Restoring,
+			tags: pi.Tags, tagged: pi.Tagged,
+			contentType: pi.ContentType, contentTyped: pi.ContentTyped,
+		}
+	} else {
+		if pi.Tagged {
+			info = &taggedFileInfo{FileInfo: info, tags: pi.Tags}
+		}
+		if pi.ContentTyped {
+			info = &contentTypedFileInfo{FileInfo: info, contentType: pi.ContentType}
+		}
+	}
+	if pi.Unix {
+		info = NewUnixFileInfo(info, pi.UID, pi.GID, pi.Mode)
+	}
+	if pi.Windows {
+		info = NewWindowsFileInfo(info, pi.Attrs, pi.OwnerSID)
+	}
+	if pi.Link != nil {
+		unixInfo, ok := info.(UnixFileInfo)
+		if !ok {
+			unixInfo = NewUnixFileInfo(info, 0, 0, 0)
+		}
+		info = &symlinkFileInfo{UnixFileInfo: unixInfo, target: *pi.Link}
+	}
+	return info
+}
+
+// Save writes the unexpired entries to file, so that a later run can Load
+// them and skip the requests.
+func (c *CachingProvider) Save(file string) error {
+	now := time.Now()
+	cf := cacheFile{Stats: make(map[string]persistedStat), Lists: make(map[string]persistedList)}
+
+	c.mu.Lock()
+	for p, e := range c.stats {
+		if now.Before(e.expires) {
+			cf.Stats[p] = persistedStat{Info: persistInfo(e.info), Expires: e.expires}
+		}
+	}
+	for p, e := range c.lists {
+		if now.Before(e.expires) {
+			pl := persistedList{Entries: make([]persistedInfo, len(e.entries)), Expires: e.expires}
+			for i, info := range e.entries {
+				pl.Entries[i] = persistInfo(info)
+			}
+			cf.Lists[p] = pl
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(cf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache: %w", err)
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	return os.Rename(tmp, file)
+}
+
+// Load adds the unexpired entries saved in file to the cache. A missing
+// file is not an error.
+func (c *CachingProvider) Load(file string) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+	var cf cacheFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return fmt.Errorf("failed to parse cache %s: %w", file, err)
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p, e := range cf.Stats {
+		if now.Before(e.Expires) {
+			c.stats[p] = cachedStat{info: e.Info.fileInfo(), expires: e.Expires}
+		}
+	}
+	for p, e := range cf.Lists {
+		if now.Before(e.Expires) {
+			entries := make([]FileInfo, len(e.Entries))
+			for i, pi := range e.Entries {
+				entries[i] = pi.fileInfo()
+			}
+			c.lists[p] = cachedList{entries: entries, expires: e.Expires}
+		}
+	}
+	return nil
+}
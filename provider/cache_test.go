@@ -0,0 +1,249 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingProvider counts the Stat and List calls reaching the backend.
+type countingProvider struct {
+	*LocalProvider
+	stats, lists int
+}
+
+func (c *countingProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	c.stats++
+	return c.LocalProvider.Stat(ctx, path)
+}
+
+func (c *countingProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	c.lists++
+	return c.LocalProvider.List(ctx, path)
+}
+
+func TestCachingProvider(t *testing.T) {
+	tempBase := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingProvider{LocalProvider: NewLocalProvider(tempBase)}
+	c := WithCache(backend, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Stat(ctx, "a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.List(ctx, "."); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.stats != 1 || backend.lists != 1 {
+		t.Errorf("Expected one backend call each, got %d stats and %d lists", backend.stats, backend.lists)
+	}
+	if hits, misses := c.Stats(); hits != 4 || misses != 2 {
+		t.Errorf("Expected 4 hits and 2 misses, got %d and %d", hits, misses)
+	}
+
+	// Writing through the cache invalidates the file and its directory
+	wc, err := c.OpenWrite(ctx, "a.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.Write([]byte("abcdef"))
+	wc.Close()
+
+	info, err := c.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 6 || backend.stats != 2 {
+		t.Errorf("Expected a fresh stat of size 6, got size %d after %d stats", info.Size(), backend.stats)
+	}
+	c.List(ctx, ".")
+	if backend.lists != 2 {
+		t.Errorf("Expected the parent listing to be invalidated, got %d lists", backend.lists)
+	}
+}
+
+func TestCachingProvider_Expiry(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+	backend := &countingProvider{LocalProvider: NewLocalProvider(tempBase)}
+	c := WithCache(backend, 10*time.Millisecond)
+
+	c.Stat(context.Background(), "a.txt")
+	time.Sleep(20 * time.Millisecond)
+	c.Stat(context.Background(), "a.txt")
+	if backend.stats != 2 {
+		t.Errorf("Expected an expired entry to be refreshed, got %d stats", backend.stats)
+	}
+}
+
+func TestCachingProvider_SaveLoad(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0640)
+	if err := os.Symlink("a.txt", filepath.Join(tempBase, "link")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	first := WithCache(NewLocalProvider(tempBase), time.Hour)
+	want, err := first.Stat(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.List(context.Background(), ".")
+	if err := first.Save(cacheFile); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	backend := &countingProvider{LocalProvider: NewLocalProvider(tempBase)}
+	second := WithCache(backend, time.Hour)
+	if err := second.Load(cacheFile); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	got, err := second.Stat(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.stats != 0 {
+		t.Error("Expected the loaded entry to be served from the cache")
+	}
+	if got.Size() != want.Size() || !got.ModTime().Equal(want.ModTime()) {
+		t.Errorf("Expected %d/%v, got %d/%v", want.Size(), want.ModTime(), got.Size(), got.ModTime())
+	}
+	if wu, ok := want.(UnixFileInfo); ok {
+		gu, ok := got.(UnixFileInfo)
+		if !ok || gu.UID() != wu.UID() || gu.Mode() != wu.Mode() {
+			t.Errorf("Expected Unix metadata to survive persistence, got %v", got)
+		}
+	}
+
+	entries, _ := second.List(context.Background(), ".")
+	var linkTarget string
+	for _, e := range entries {
+		if target, ok := SymlinkTarget(e); ok {
+			linkTarget = target
+		}
+	}
+	if backend.lists != 0 || linkTarget != "a.txt" {
+		t.Errorf("Expected a cached listing with the link target, got %d lists and target %q", backend.lists, linkTarget)
+	}
+
+	if err := second.Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Expected a missing cache file to be ignored, got %v", err)
+	}
+}
+
+func TestPersistInfo_ContentType(t *testing.T) {
+	info := &s3FileInfo{name: "a.md", size: 3, contentType: "text/markdown", contentTyped: true}
+	if typ, ok := ObjectContentType(persistInfo(info).fileInfo()); !ok || typ != "text/markdown" {
+		t.Errorf("Expected the content type to survive persistence, got %q, %v", typ, ok)
+	}
+	if _, ok := ObjectContentType(persistInfo(&s3FileInfo{name: "b"}).fileInfo()); ok {
+		t.Error("Expected a type never looked up to stay unknown")
+	}
+}
+
+func TestCachingProvider_Fresh(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+	backend := &countingProvider{LocalProvider: NewLocalProvider(tempBase)}
+	c := WithCache(backend, time.Hour)
+	ctx := context.Background()
+
+	c.Stat(ctx, "a.txt")
+	c.Stat(WithoutCache(ctx), "a.txt")
+	c.List(ctx, ".")
+	c.List(WithoutCache(ctx), ".")
+	if backend.stats != 2 || backend.lists != 2 {
+		t.Errorf("Expected lookups without the cache to reach the backend, got %d stats and %d lists", backend.stats, backend.lists)
+	}
+
+	// Requesting a restore changes the file's state
+	c.Restore(ctx, "a.txt", RestoreOptions{})
+	c.Stat(ctx, "a.txt")
+	if backend.stats != 3 {
+		t.Errorf("Expected a restore to invalidate the file, got %d stats", backend.stats)
+	}
+}
+
+func TestPersistInfo_ArchiveState(t *testing.T) {
+	info := &s3FileInfo{name: "cold.bin", size: 3, storageClass: "GLACIER", archived: true, restoring: true, tags: map[string]string{"k": "v"}, tagged: true}
+	got := persistInfo(info).fileInfo()
+	archived := Archived("cold.bin", got)
+	if archived == nil || archived.StorageClass != "GLACIER" || !archived.Restoring {
+		t.Errorf("Expected the archive state to survive persistence, got %v", archived)
+	}
+	if tags, ok := ObjectTags(got); !ok || tags["k"] != "v" {
+		t.Errorf("Expected the tags of an archived object to survive persistence, got %v, %v", tags, ok)
+	}
+	if Archived("warm.bin", persistInfo(&s3FileInfo{name: "warm.bin", storageClass: "STANDARD"}).fileInfo()) != nil {
+		t.Error("Expected a readable object to stay readable")
+	}
+}
+
+func TestCachingProvider_SaveLoadDevice(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+
+	first := WithCache(NewLocalProvider(tempBase), time.Hour)
+	root, err := first.Stat(context.Background(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDev, ok := Device(root)
+	if !ok {
+		t.Skip("the platform does not report devices")
+	}
+	first.List(context.Background(), ".")
+	if err := first.Save(cacheFile); err != nil {
+		t.Fatal(err)
+	}
+
+	second := WithCache(NewLocalProvider(tempBase), time.Hour)
+	if err := second.Load(cacheFile); err != nil {
+		t.Fatal(err)
+	}
+	root, _ = second.Stat(context.Background(), ".")
+	if dev, ok := Device(root); !ok || dev != wantDev {
+		t.Errorf("Expected the root on device %d after loading, got %d, %v", wantDev, dev, ok)
+	}
+	entries, _ := second.List(context.Background(), ".")
+	for _, e := range entries {
+		if dev, ok := Device(e); !ok || dev != wantDev {
+			t.Errorf("Expected %s on device %d after loading, got %d, %v", e.Name(), wantDev, dev, ok)
+		}
+	}
+}
+
+func TestCachingProvider_CopyInvalidates(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+	os.WriteFile(filepath.Join(tempBase, "b.txt"), []byte("old"), 0644)
+	backend := &countingProvider{LocalProvider: NewLocalProvider(tempBase)}
+	c := WithCache(backend, time.Hour)
+	ctx := context.Background()
+
+	c.Stat(ctx, "b.txt")
+	c.List(ctx, ".")
+	c.CopyFrom(ctx, backend, "a.txt", "b.txt", nil)
+	c.Stat(ctx, "b.txt")
+	c.List(ctx, ".")
+	if backend.stats != 2 || backend.lists != 2 {
+		t.Errorf("Expected a copy to invalidate the destination and its directory, got %d stats and %d lists", backend.stats, backend.lists)
+	}
+
+	c.Undelete(ctx, DeletedEntry{Path: "b.txt"})
+	c.Stat(ctx, "b.txt")
+	c.List(ctx, ".")
+	if backend.stats != 3 || backend.lists != 3 {
+		t.Errorf("Expected an undelete to invalidate the entry and its directory, got %d stats and %d lists", backend.stats, backend.lists)
+	}
+}
@@ -0,0 +1,96 @@
+package provider
+
+import "context"
+
+// Capabilities describes the optional features a provider supports. The
+// engine consults it to pick transfer strategies, and the CLI uses it to warn
+// when a requested feature cannot be honoured by a backend.
+type Capabilities struct {
+	// Delete means the provider implements Deleter.
+	Delete bool
+	// Rename means the provider implements Renamer.
+	Rename bool
+	// RangedRead means the provider implements RangeReader natively.
+	RangedRead bool
+	// Checksums means the provider implements Checksummer.
+	Checksums bool
+	// Symlinks means the provider implements Symlinker and reports links
+	// through SymlinkFileInfo.
+	Symlinks bool
+	// Metadata means ownership and permissions are preserved on write.
+	Metadata bool
+	// ServerSideCopy means the provider implements ServerSideCopier.
+	ServerSideCopy bool
+	// Sparse means the provider implements SparseReader.
+	Sparse bool
+	// ResumableWrite means the provider implements ResumableWriter.
+	ResumableWrite bool
+	// AppendWrite means the provider implements AppendWriter.
+	AppendWrite bool
+	// FlatList means the provider implements FlatLister.
+	FlatList bool
+	// Restore means the provider implements Restorer.
+	Restore bool
+	// Tags means Stat reports tags through TaggedFileInfo and writes apply
+	// the tags of the FileInfo given.
+	Tags bool
+	// Versions means the provider implements Versioner.
+	Versions bool
+	// ContentType means Stat reports MIME types through
+	// ContentTypedFileInfo and writes store the type of the FileInfo given.
+	ContentType bool
+	// Presign means the provider implements Presigner.
+	Presign bool
+	// ChunkedWrite means the provider implements ChunkedWriter.
+	ChunkedWrite bool
+	// Hardlinks means the provider implements Linker.
+	Hardlinks bool
+	// Directories means the provider implements DirMaker.
+	Directories bool
+	// AtomicObjects means files are only ever replaced whole, never
+	// modified in place, so a read sees one version from start to end, as
+	// with object stores.
+	AtomicObjects bool
+}
+
+// CapabilityReporter is implemented by providers that describe their
+// capabilities explicitly. Wrapping providers must implement it so that the
+// capabilities of the wrapped backend, not the methods of the wrapper, are
+// reported.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// Renamer is implemented by providers that can move a file within the
+// provider without copying its content.
+type Renamer interface {
+	Rename(ctx context.Context, from, to string) error
+}
+
+// CapabilitiesOf returns the capabilities of p. Providers implementing
+// CapabilityReporter are asked directly; for others the capabilities are
+// inferred from the optional interfaces they implement.
+func CapabilitiesOf(p Provider) Capabilities {
+	if r, ok := p.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+
+	var caps Capabilities
+	_, caps.Delete = p.(Deleter)
+	_, caps.Rename = p.(Renamer)
+	_, caps.RangedRead = p.(RangeReader)
+	_, caps.Checksums = p.(Checksummer)
+	_, caps.Symlinks = p.(Symlinker)
+	_, caps.ServerSideCopy = p.(ServerSideCopier)
+	_, caps.Sparse = p.(SparseReader)
+	_, caps.ResumableWrite = p.(ResumableWriter)
+	_, caps.AppendWrite = p.(AppendWriter)
+	_, caps.FlatList = p.(FlatLister)
+	_, caps.Restore = p.(Restorer)
+	_, caps.Versions = p.(Versioner)
+	_, caps.Presign = p.(Presigner)
+	_, caps.ChunkedWrite = p.(ChunkedWriter)
+	_, caps.Hardlinks = p.(Linker)
+	_, caps.Directories = p.(DirMaker)
+	return caps
+}
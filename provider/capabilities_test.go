@@ -0,0 +1,32 @@
+package provider
+
+import "testing"
+
+func TestCapabilitiesOf(t *testing.T) {
+	local := CapabilitiesOf(NewLocalProvider(""))
+	if !local.Delete || !local.Rename || !local.RangedRead || !local.Checksums || !local.Symlinks || !local.Metadata || !local.ChunkedWrite || local.AtomicObjects {
+		t.Errorf("expected local provider to support everything, got %+v", local)
+	}
+
+	noMeta := CapabilitiesOf(NewLocalProvider("").WithMetadataMapper(nil))
+	if noMeta.Metadata {
+		t.Error("expected no metadata capability without a mapper")
+	}
+	if local.Hardlinks != hardlinksSupported {
+		t.Errorf("expected local hard links only where supported, got %+v", local)
+	}
+
+	s3Caps := CapabilitiesOf(&S3Provider{})
+	if !s3Caps.Delete || !s3Caps.RangedRead || !s3Caps.FlatList || !s3Caps.Restore || !s3Caps.Versions || !s3Caps.Presign || !s3Caps.ContentType || !s3Caps.ChunkedWrite || !s3Caps.AtomicObjects || s3Caps.Symlinks || s3Caps.Rename || s3Caps.Metadata {
+		t.Errorf("unexpected S3 capabilities %+v", s3Caps)
+	}
+	if !CapabilitiesOf((&S3Provider{}).WithMetadata(true)).Metadata {
+		t.Error("expected S3 to store metadata as user metadata when enabled")
+	}
+
+	// Without a CapabilityReporter, capabilities are inferred from methods.
+	plain := CapabilitiesOf(plainProvider{NewLocalProvider("")})
+	if plain != (Capabilities{}) {
+		t.Errorf("expected no capabilities for a bare Provider, got %+v", plain)
+	}
+}
@@ -0,0 +1,269 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChaosConfig describes the faults injected by WithChaos. Rates are
+// probabilities between 0 and 1, evaluated per call.
+type ChaosConfig struct {
+	// Seed makes the sequence of injected faults reproducible.
+	Seed uint64
+	// ErrorRate fails Stat, List and Open calls with a throttling error.
+	ErrorRate float64
+	// EOFRate ends a Read early with io.ErrUnexpectedEOF.
+	EOFRate float64
+	// PartialWriteRate makes a Write store only half of its bytes and
+	// return io.ErrShortWrite.
+	PartialWriteRate float64
+	// Latency is added to every call.
+	Latency time.Duration
+}
+
+// ParseChaosConfig parses a comma-separated list of key=value pairs, e.g.
+// "error=0.1,eof=0.01,partial=0.01,latency=5ms,seed=42".
+func ParseChaosConfig(spec string) (ChaosConfig, error) {
+	var cfg ChaosConfig
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid chaos setting %q: want key=value", field)
+		}
+
+		var err error
+		switch key {
+		case "seed":
+			cfg.Seed, err = strconv.ParseUint(value, 10, 64)
+		case "error":
+			cfg.ErrorRate, err = parseRate(value)
+		case "eof":
+			cfg.EOFRate, err = parseRate(value)
+		case "partial":
+			cfg.PartialWriteRate, err = parseRate(value)
+		case "latency":
+			cfg.Latency, err = time.ParseDuration(value)
+		default:
+			return cfg, fmt.Errorf("unknown chaos setting %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid chaos setting %q: %w", field, err)
+		}
+	}
+	return cfg, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err == nil && (rate < 0 || rate > 1) {
+		err = fmt.Errorf("rate must be between 0 and 1")
+	}
+	return rate, err
+}
+
+// ChaosError is the error injected into failing calls. It looks like an S3
+// SlowDown response, so IsTransient treats it as retryable.
+type ChaosError struct {
+	Op   Operation
+	Path string
+}
+
+func (e *ChaosError) Error() string {
+	return fmt.Sprintf("chaos: injected SlowDown on %s %s", e.Op, e.Path)
+}
+
+func (e *ChaosError) ErrorCode() string   { return "SlowDown" }
+func (e *ChaosError) HTTPStatusCode() int { return 503 }
+
+// chaosProvider injects faults into the wrapped provider.
+type chaosProvider struct {
+	Wrapper
+	cfg ChaosConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// WithChaos wraps p so that its calls and streams fail as described by cfg.
+// It is meant for testing retry, resume and checkpoint behaviour; with a
+// fixed seed and a single caller the faults are reproducible.
+func WithChaos(p Provider, cfg ChaosConfig) Provider {
+	return &chaosProvider{
+		Wrapper: Wrapper{p},
+		cfg:     cfg,
+		rng:     rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x9e3779b97f4a7c15)),
+	}
+}
+
+// roll reports whether a fault with the given rate should be injected.
+func (c *chaosProvider) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < rate
+}
+
+// call adds latency and decides whether op on path fails.
+func (c *chaosProvider) call(ctx context.Context, op Operation, path string) error {
+	if c.cfg.Latency > 0 {
+		timer := time.NewTimer(c.cfg.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if c.roll(c.cfg.ErrorRate) {
+		return &ChaosError{Op: op, Path: path}
+	}
+	return nil
+}
+
+func (c *chaosProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	if err := c.call(ctx, OpStat, path); err != nil {
+		return nil, err
+	}
+	return c.Provider.Stat(ctx, path)
+}
+
+func (c *chaosProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	if err := c.call(ctx, OpList, path); err != nil {
+		return nil, err
+	}
+	return c.Provider.List(ctx, path)
+}
+
+func (c *chaosProvider) ListAll(ctx context.Context, path string, fn func(rel string, info FileInfo) error) error {
+	if err := c.call(ctx, OpList, path); err != nil {
+		return err
+	}
+	return c.Wrapper.ListAll(ctx, path, fn)
+}
+
+func (c *chaosProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := c.call(ctx, OpOpenRead, path); err != nil {
+		return nil, err
+	}
+	rc, err := c.Provider.OpenRead(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosReader{ReadCloser: rc, chaos: c}, nil
+}
+
+func (c *chaosProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if !CapabilitiesOf(c.Provider).RangedRead {
+		// The fallback reads through OpenRead, which already injects faults.
+		return OpenReadRange(ctx, c, path, offset, length)
+	}
+	if err := c.call(ctx, OpOpenRead, path); err != nil {
+		return nil, err
+	}
+	rc, err := c.Wrapper.OpenReadRange(ctx, path, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosReader{ReadCloser: rc, chaos: c}, nil
+}
+
+func (c *chaosProvider) ListVersions(ctx context.Context, path string, fn func(rel string, versions []ObjectVersion) error) error {
+	if err := c.call(ctx, OpList, path); err != nil {
+		return err
+	}
+	return c.Wrapper.ListVersions(ctx, path, fn)
+}
+
+func (c *chaosProvider) OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error) {
+	if err := c.call(ctx, OpOpenRead, path); err != nil {
+		return nil, err
+	}
+	rc, err := c.Wrapper.OpenReadVersion(ctx, path, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosReader{ReadCloser: rc, chaos: c}, nil
+}
+
+func (c *chaosProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	if err := c.call(ctx, OpOpenWrite, path); err != nil {
+		return nil, err
+	}
+	wc, err := c.Provider.OpenWrite(ctx, path, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosWriter{WriteCloser: wc, chaos: c}, nil
+}
+
+func (c *chaosProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	if err := c.call(ctx, OpOpenWrite, path); err != nil {
+		return nil, 0, err
+	}
+	wc, offset, err := c.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &chaosWriter{WriteCloser: wc, chaos: c}, offset, nil
+}
+
+func (c *chaosProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	if err := c.call(ctx, OpOpenWrite, path); err != nil {
+		return nil, 0, err
+	}
+	wc, offset, err := c.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &chaosWriter{WriteCloser: wc, chaos: c}, offset, nil
+}
+
+func (c *chaosProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	if err := c.call(ctx, OpOpenWrite, path); err != nil {
+		return nil, err
+	}
+	return c.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+}
+
+type chaosReader struct {
+	io.ReadCloser
+	chaos *chaosProvider
+}
+
+func (r *chaosReader) Read(p []byte) (int, error) {
+	if r.chaos.roll(r.chaos.cfg.EOFRate) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return r.ReadCloser.Read(p)
+}
+
+type chaosWriter struct {
+	io.WriteCloser
+	chaos *chaosProvider
+}
+
+// Unwrap returns the wrapped writer, for Committed.
+func (w *chaosWriter) Unwrap() io.WriteCloser { return w.WriteCloser }
+
+func (w *chaosWriter) Write(p []byte) (int, error) {
+	if len(p) > 1 && w.chaos.roll(w.chaos.cfg.PartialWriteRate) {
+		n, err := w.WriteCloser.Write(p[:len(p)/2])
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return n, err
+	}
+	return w.WriteCloser.Write(p)
+}
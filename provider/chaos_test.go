@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseChaosConfig(t *testing.T) {
+	cfg, err := ParseChaosConfig("error=0.1, eof=0.05,partial=0.5,latency=5ms,seed=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ChaosConfig{Seed: 42, ErrorRate: 0.1, EOFRate: 0.05, PartialWriteRate: 0.5, Latency: 5 * time.Millisecond}
+	if cfg != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg)
+	}
+
+	for _, bad := range []string{"error", "error=2", "bogus=1", "latency=fast"} {
+		if _, err := ParseChaosConfig(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestWithChaos_Deterministic(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+
+	failures := func() []bool {
+		p := WithChaos(NewLocalProvider(tempBase), ChaosConfig{Seed: 7, ErrorRate: 0.5})
+		var out []bool
+		for i := 0; i < 50; i++ {
+			_, err := p.Stat(context.Background(), "a.txt")
+			out = append(out, err != nil)
+		}
+		return out
+	}
+
+	first, second := failures(), failures()
+	var failed int
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected the same seed to inject the same faults (call %d)", i)
+		}
+		if first[i] {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(first) {
+		t.Errorf("Expected some but not all calls to fail, got %d of %d", failed, len(first))
+	}
+}
+
+func TestWithChaos_RetryRecovers(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+
+	chaos := WithChaos(NewLocalProvider(tempBase), ChaosConfig{Seed: 1, ErrorRate: 0.5})
+	p := WithRetry(chaos, RetryPolicy{MaxAttempts: 20, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond})
+	for i := 0; i < 20; i++ {
+		if _, err := p.Stat(context.Background(), "a.txt"); err != nil {
+			t.Fatalf("Expected retries to absorb injected errors, got %v", err)
+		}
+	}
+
+	var chaosErr *ChaosError
+	if _, err := chaos.Stat(context.Background(), "a.txt"); err != nil && !errors.As(err, &chaosErr) {
+		t.Errorf("Expected a ChaosError, got %v", err)
+	}
+}
+
+func TestWithChaos_Streams(t *testing.T) {
+	tempBase := t.TempDir()
+	os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("abc"), 0644)
+	p := WithChaos(NewLocalProvider(tempBase), ChaosConfig{EOFRate: 1, PartialWriteRate: 1})
+
+	rc, err := p.OpenRead(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := io.ReadAll(rc); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected an injected EOF, got %v", err)
+	}
+
+	wc, err := p.OpenWrite(context.Background(), "b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wc.Close()
+	if n, err := wc.Write([]byte("abcd")); n != 2 || !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Expected a partial write of 2 bytes, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Digest algorithms reported by providers.
+const (
+	DigestCRC32C = "crc32c"
+	DigestCRC32  = "crc32"
+	DigestSHA1   = "sha1"
+	DigestSHA256 = "sha256"
+	// DigestETag is an S3 entity tag that is a hash of the content: that of
+	// an object uploaded in a single part without KMS encryption or a
+	// customer-provided key. Other ETags are reported as DigestComposite.
+	DigestETag = "etag"
+	// DigestComposite marks a checksum of checksums (e.g. a multipart upload
+	// CRC32C or ETag) or an opaque ETag. It identifies the object but cannot
+	// be compared to a digest computed over the whole file.
+	DigestComposite = "composite"
+)
+
+// Digest is a checksum of a file's content as reported by its provider.
+type Digest struct {
+	Algorithm string
+	// Value is the lowercase hex encoding of the checksum.
+	Value string
+}
+
+// Comparable reports whether d and other were computed with the same
+// algorithm over the whole content, so that comparing their values is meaningful.
+func (d Digest) Comparable(other Digest) bool {
+	return d.Algorithm != "" && d.Algorithm != DigestComposite && d.Algorithm == other.Algorithm
+}
+
+// Checksummer is implemented by providers that can report a digest for a
+// path without the caller streaming its content, typically because the
+// backend stores one (S3 additional checksums, GCS CRC32C).
+type Checksummer interface {
+	Checksum(ctx context.Context, path string) (Digest, error)
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32cDigest computes the CRC32C digest of r.
+func crc32cDigest(r io.Reader) (Digest, error) {
+	h := crc32.New(crc32cTable)
+	if _, err := io.Copy(h, r); err != nil {
+		return Digest{}, err
+	}
+	return Digest{Algorithm: DigestCRC32C, Value: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// base64Digest converts a base64 checksum as returned in S3 headers into a
+// Digest. Composite checksums carry a "-<parts>" suffix and are tagged as such.
+func base64Digest(algorithm, value string) (Digest, bool) {
+	encoded, parts, composite := strings.Cut(value, "-")
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Digest{}, false
+	}
+	if composite {
+		return Digest{Algorithm: DigestComposite, Value: hex.EncodeToString(raw) + "-" + parts}, true
+	}
+	return Digest{Algorithm: algorithm, Value: hex.EncodeToString(raw)}, true
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestLocalProvider_Checksum(t *testing.T) {
+	tempBase, err := os.MkdirTemp("", "local-provider-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempBase)
+
+	if err := os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewLocalProvider(tempBase)
+	d, err := p.Checksum(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatalf("Checksum failed: %v", err)
+	}
+
+	// CRC32C("hello world") = 0xc99465aa
+	if d.Algorithm != DigestCRC32C || d.Value != "c99465aa" {
+		t.Errorf("unexpected digest %+v", d)
+	}
+}
+
+func TestS3Digest(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    *s3.HeadObjectOutput
+		expect Digest
+	}{
+		{
+			name:   "crc32c full object",
+			out:    &s3.HeadObjectOutput{ChecksumCRC32C: aws.String("yZRlqg=="), ETag: aws.String(`"abc"`)},
+			expect: Digest{Algorithm: DigestCRC32C, Value: "c99465aa"},
+		},
+		{
+			name:   "composite multipart",
+			out:    &s3.HeadObjectOutput{ChecksumCRC32C: aws.String("yZRlqg==-3"), ChecksumType: types.ChecksumTypeComposite},
+			expect: Digest{Algorithm: DigestComposite, Value: "c99465aa-3"},
+		},
+		{
+			name:   "etag fallback",
+			out:    &s3.HeadObjectOutput{ETag: aws.String(`"5eb63bbbe01eeed093cb22bb8f5acdc3"`)},
+			expect: Digest{Algorithm: DigestETag, Value: "5eb63bbbe01eeed093cb22bb8f5acdc3"},
+		},
+		{
+			name:   "multipart etag",
+			out:    &s3.HeadObjectOutput{ETag: aws.String(`"5eb63bbbe01eeed093cb22bb8f5acdc3-2"`)},
+			expect: Digest{Algorithm: DigestComposite, Value: "5eb63bbbe01eeed093cb22bb8f5acdc3-2"},
+		},
+		{
+			name:   "kms etag",
+			out:    &s3.HeadObjectOutput{ETag: aws.String(`"5eb63bbbe01eeed093cb22bb8f5acdc3"`), ServerSideEncryption: types.ServerSideEncryptionAwsKms},
+			expect: Digest{Algorithm: DigestComposite, Value: "5eb63bbbe01eeed093cb22bb8f5acdc3"},
+		},
+		{
+			name:   "sse-c etag",
+			out:    &s3.HeadObjectOutput{ETag: aws.String(`"5eb63bbbe01eeed093cb22bb8f5acdc3"`), SSECustomerAlgorithm: aws.String("AES256")},
+			expect: Digest{Algorithm: DigestComposite, Value: "5eb63bbbe01eeed093cb22bb8f5acdc3"},
+		},
+		{
+			name:   "sse-s3 etag",
+			out:    &s3.HeadObjectOutput{ETag: aws.String(`"5eb63bbbe01eeed093cb22bb8f5acdc3"`), ServerSideEncryption: types.ServerSideEncryptionAes256},
+			expect: Digest{Algorithm: DigestETag, Value: "5eb63bbbe01eeed093cb22bb8f5acdc3"},
+		},
+		{
+			name:   "nothing",
+			out:    &s3.HeadObjectOutput{},
+			expect: Digest{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s3Digest(headChecksums(tt.out)); got != tt.expect {
+				t.Errorf("s3Digest() = %+v; want %+v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestS3Digest_CopyResult(t *testing.T) {
+	r := &types.CopyObjectResult{ChecksumCRC32C: aws.String("yZRlqg=="), ETag: aws.String(`"abc"`)}
+	want := Digest{Algorithm: DigestCRC32C, Value: "c99465aa"}
+	if got := s3Digest(copyChecksums(&s3.CopyObjectOutput{CopyObjectResult: r})); got != want {
+		t.Errorf("s3Digest() = %+v; want %+v", got, want)
+	}
+
+	r = &types.CopyObjectResult{ETag: aws.String(`"abc"`)}
+	want = Digest{Algorithm: DigestComposite, Value: "abc"}
+	if got := s3Digest(copyChecksums(&s3.CopyObjectOutput{CopyObjectResult: r, ServerSideEncryption: types.ServerSideEncryptionAwsKms})); got != want {
+		t.Errorf("s3Digest() = %+v; want %+v", got, want)
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	got := copySource("bucket", "dir with space/a+b?.txt")
+	want := "bucket/dir%20with%20space/a+b%3F.txt"
+	if got != want {
+		t.Errorf("copySource() = %q; want %q", got, want)
+	}
+}
+
+func TestDigest_Comparable(t *testing.T) {
+	crc := Digest{Algorithm: DigestCRC32C, Value: "1"}
+	if !crc.Comparable(Digest{Algorithm: DigestCRC32C, Value: "2"}) {
+		t.Error("expected digests of the same algorithm to be comparable")
+	}
+	if crc.Comparable(Digest{Algorithm: DigestETag, Value: "1"}) {
+		t.Error("expected digests of different algorithms not to be comparable")
+	}
+	composite := Digest{Algorithm: DigestComposite, Value: "1-2"}
+	if composite.Comparable(composite) {
+		t.Error("expected composite digests not to be comparable")
+	}
+}
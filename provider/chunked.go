@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ChunkedWriter is implemented by providers that can write the chunks of a
+// file independently and in parallel, so one large file can be transferred
+// by several streams: local files with positional writes, S3 objects as
+// the parts of a multipart upload.
+type ChunkedWriter interface {
+	// OpenChunked starts writing a file of size bytes in chunks of
+	// chunkSize bytes, the last one possibly shorter. It returns
+	// ErrNotSupported if the provider cannot write chunks of that size.
+	OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error)
+}
+
+// ChunkedFile is a file being written chunk by chunk.
+type ChunkedFile interface {
+	// WriteChunk writes chunk index, counted from 0, reading its full
+	// length from r. Different chunks may be written concurrently.
+	WriteChunk(ctx context.Context, index int, r io.Reader) error
+	// Commit finishes the file once every chunk has been written and
+	// applies its metadata; errors applying metadata are reported as for
+	// OpenWrite.
+	Commit(ctx context.Context) error
+	// Abort discards the partly written file.
+	Abort(ctx context.Context) error
+}
+
+// ChunkCount returns the number of chunks of chunkSize a file of size
+// bytes is written in.
+func ChunkCount(size, chunkSize int64) int {
+	if size <= 0 || chunkSize <= 0 {
+		return 1
+	}
+	return int((size + chunkSize - 1) / chunkSize)
+}
+
+// chunkLength returns the length of chunk index.
+func chunkLength(index int, size, chunkSize int64) int64 {
+	return min(chunkSize, size-int64(index)*chunkSize)
+}
+
+// OpenChunked creates path at its full size and writes each chunk at its
+// offset. Files written with direct I/O or sparse writes are not
+// supported, as their writers keep state across the whole stream.
+func (p *LocalProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	if p.directIO || p.sparse || chunkSize <= 0 {
+		return nil, ErrNotSupported
+	}
+	lw, err := p.openWrite(ctx, path, metadata, os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	if err := lw.File.Truncate(size); err != nil {
+		lw.File.Close()
+		os.Remove(lw.fullPath)
+		return nil, fmt.Errorf("failed to size %s: %w", lw.fullPath, err)
+	}
+	return &localChunkedFile{lw: lw, size: size, chunkSize: chunkSize}, nil
+}
+
+type localChunkedFile struct {
+	lw        *localWriteCloser
+	size      int64
+	chunkSize int64
+}
+
+func (f *localChunkedFile) WriteChunk(ctx context.Context, index int, r io.Reader) error {
+	length := chunkLength(index, f.size, f.chunkSize)
+	w := io.NewOffsetWriter(f.lw.File, int64(index)*f.chunkSize)
+	n, err := io.Copy(w, io.LimitReader(r, length))
+	if err != nil {
+		return fmt.Errorf("failed to write chunk %d of %s: %w", index, f.lw.fullPath, err)
+	}
+	if n != length {
+		return fmt.Errorf("chunk %d of %s: %w", index, f.lw.fullPath, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (f *localChunkedFile) Commit(ctx context.Context) error {
+	return f.lw.Close()
+}
+
+func (f *localChunkedFile) Abort(ctx context.Context) error {
+	f.lw.File.Close()
+	return os.Remove(f.lw.fullPath)
+}
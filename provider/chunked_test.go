@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChunkCount(t *testing.T) {
+	tests := []struct {
+		size, chunkSize int64
+		want            int
+	}{
+		{0, 10, 1},
+		{10, 10, 1},
+		{11, 10, 2},
+		{95, 10, 10},
+		{5, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := ChunkCount(tt.size, tt.chunkSize); got != tt.want {
+			t.Errorf("ChunkCount(%d, %d) = %d, want %d", tt.size, tt.chunkSize, got, tt.want)
+		}
+	}
+}
+
+func TestLocalProvider_OpenChunked(t *testing.T) {
+	dir := t.TempDir()
+	p := NewLocalProvider(dir)
+	ctx := context.Background()
+
+	content := []byte(strings.Repeat("0123456789", 10) + "tail")
+	const chunkSize = 25
+	f, err := p.OpenChunked(ctx, "sub/big.bin", int64(len(content)), chunkSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks arrive out of order, from several streams
+	var wg sync.WaitGroup
+	for i := ChunkCount(int64(len(content)), chunkSize) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			end := min((i+1)*chunkSize, len(content))
+			if err := f.WriteChunk(ctx, i, bytes.NewReader(content[i*chunkSize:end])); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := f.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "sub", "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Expected %q, got %q", content, got)
+	}
+
+	f, _ = p.OpenChunked(ctx, "short.bin", 50, chunkSize, nil)
+	if err := f.WriteChunk(ctx, 0, strings.NewReader("too short")); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected a short chunk to fail, got %v", err)
+	}
+	if err := f.Abort(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "short.bin")); !os.IsNotExist(err) {
+		t.Error("Expected the aborted file to be removed")
+	}
+
+	if _, err := NewLocalProvider(dir).WithSparseWrites(true).OpenChunked(ctx, "sparse.bin", 50, chunkSize, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected sparse writes to refuse chunks, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package provider
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneSupported reports whether cloneFile can copy in the kernel.
+const cloneSupported = true
+
+// cloneFile copies size bytes from src to dst without passing them through
+// userspace. It first tries a FICLONE reflink, which shares the extents on
+// copy-on-write filesystems such as btrfs and XFS, then copy_file_range. It
+// returns ErrNotSupported if neither works for these files, e.g. because
+// they are on different filesystems.
+func cloneFile(dst, src *os.File, size int64) error {
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err == nil {
+		return nil
+	}
+
+	var written int64
+	for written < size {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(min(size-written, 1<<30)), 0)
+		if err != nil {
+			if written == 0 && cloneUnsupported(err) {
+				return ErrNotSupported
+			}
+			return err
+		}
+		if n == 0 {
+			// The source shrank since it was listed
+			break
+		}
+		written += int64(n)
+	}
+	return nil
+}
+
+// cloneUnsupported reports whether a copy_file_range error means the kernel
+// cannot copy between these files, rather than that the copy failed.
+func cloneUnsupported(err error) bool {
+	return errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package provider
+
+import "os"
+
+// cloneSupported reports whether cloneFile can copy in the kernel.
+const cloneSupported = false
+
+// cloneFile is only implemented on Linux.
+func cloneFile(dst, src *os.File, size int64) error {
+	return ErrNotSupported
+}
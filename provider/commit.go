@@ -0,0 +1,30 @@
+package provider
+
+import "io"
+
+// CommitReporter is implemented by writers that acknowledge writes before
+// the destination has stored them, such as S3 uploads, which buffer parts
+// and upload them in the background.
+type CommitReporter interface {
+	// Committed returns how many bytes from the start of the file the
+	// destination has durably stored. It is called from the goroutine
+	// writing.
+	Committed() int64
+}
+
+// Committed returns how many bytes of the file w writes the destination has
+// stored, and false if w stores each write before acknowledging it.
+// Writers wrapping another are looked through when they have an Unwrap
+// method returning it.
+func Committed(w io.Writer) (int64, bool) {
+	for {
+		switch v := w.(type) {
+		case CommitReporter:
+			return v.Committed(), true
+		case interface{ Unwrap() io.WriteCloser }:
+			w = v.Unwrap()
+		default:
+			return 0, false
+		}
+	}
+}
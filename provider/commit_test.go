@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+type committingWriter struct {
+	bytes.Buffer
+	committed int64
+}
+
+func (w *committingWriter) Close() error { return nil }
+
+func (w *committingWriter) Committed() int64 { return w.committed }
+
+func TestCommitted(t *testing.T) {
+	inner := &committingWriter{committed: 42}
+	var w io.Writer = &throttledWriter{WriteCloser: &meteredWriter{WriteCloser: inner, metrics: NewMetrics()}}
+	if n, ok := Committed(w); !ok || n != 42 {
+		t.Errorf("Committed() = %d, %v, want 42 through the wrappers", n, ok)
+	}
+	if _, ok := Committed(&bytes.Buffer{}); ok {
+		t.Error("Expected a plain writer not to report commits")
+	}
+}
+
+func TestS3Provider_MultipartCommitted(t *testing.T) {
+	server := newMultipartServer()
+	p := newFakeS3Provider(&fakeS3{handler: server.handle}, "bucket", S3Options{})
+	p.uploadPartSize = 100
+	p.uploadConcurrency = 1
+	ctx := context.Background()
+
+	info := &s3FileInfo{name: "big.bin", size: 250, modTime: time.Now()}
+	w, _, err := p.OpenWriteResumable(ctx, "big.bin", info, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 150)); err != nil {
+		t.Fatal(err)
+	}
+	// The first part may still be uploading, the rest is buffered
+	if n, ok := Committed(w); !ok || (n != 0 && n != 100) {
+		t.Errorf("Committed() = %d, %v, want at most the 100 bytes of the first part", n, ok)
+	}
+	w.Write(make([]byte, 100))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := Committed(w); n != 250 {
+		t.Errorf("Committed() = %d after Close, want 250", n)
+	}
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideRoot is returned by a LocalProvider for paths that resolve
+// outside its root, whether through ".." elements or, in strict mode,
+// through symbolic links.
+var ErrOutsideRoot = errors.New("path escapes the provider root")
+
+// WithRoot confines a provider created without a base path, which acts on
+// paths as given, to root: paths that resolve outside it are rejected with
+// ErrOutsideRoot. Providers with a base path are always confined to it.
+func (p *LocalProvider) WithRoot(root string) *LocalProvider {
+	p.root = root
+	return p
+}
+
+// WithStrictSymlinks makes the provider follow symbolic links when
+// confining paths, rejecting any path that reaches outside the root through
+// a link, as well as links created with a target outside the root. Without
+// it only the path itself is checked, which is enough to stop ".."
+// traversal but not a link planted inside the tree.
+func (p *LocalProvider) WithStrictSymlinks(enabled bool) *LocalProvider {
+	p.strict = enabled
+	return p
+}
+
+// confinement returns the directory paths must stay within, or "" if the
+// provider is unconfined.
+func (p *LocalProvider) confinement() string {
+	if p.basePath != "" {
+		return p.basePath
+	}
+	return p.root
+}
+
+// resolve maps path to the file it names on disk, rejecting paths outside
+// the root. In strict mode, symbolic links along the path, including the
+// file itself, must stay within the root too. On Windows, long paths are
+// returned in their extended-length form.
+func (p *LocalProvider) resolve(path string) (string, error) {
+	return p.resolvePath(path, true)
+}
+
+// resolveLink is resolve for operations on a link itself, such as Readlink
+// or Delete: the last element of path is not followed.
+func (p *LocalProvider) resolveLink(path string) (string, error) {
+	return p.resolvePath(path, false)
+}
+
+func (p *LocalProvider) resolvePath(path string, followLast bool) (string, error) {
+	fullPath := filepath.Clean(path)
+	if p.basePath != "" {
+		fullPath = filepath.Join(p.basePath, fullPath)
+	}
+	root := p.confinement()
+	if root == "" {
+		return longPath(fullPath), nil
+	}
+	if !within(root, fullPath) {
+		return "", fmt.Errorf("%s: %w", path, ErrOutsideRoot)
+	}
+	if !p.strict {
+		return longPath(fullPath), nil
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	check := fullPath
+	if !followLast {
+		check = filepath.Dir(fullPath)
+	}
+	real, err := evalExisting(check)
+	if err != nil {
+		return "", err
+	}
+	if !within(realRoot, real) {
+		return "", fmt.Errorf("%s: resolves to %s: %w", path, real, ErrOutsideRoot)
+	}
+	return longPath(fullPath), nil
+}
+
+// checkLinkTarget rejects, in strict mode, a link at fullPath whose target
+// lies outside the root.
+func (p *LocalProvider) checkLinkTarget(target, fullPath string) error {
+	root := p.confinement()
+	if !p.strict || root == "" {
+		return nil
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(fullPath), target)
+	}
+	if !within(root, target) {
+		return fmt.Errorf("link %s to %s: %w", fullPath, target, ErrOutsideRoot)
+	}
+	return nil
+}
+
+// evalExisting resolves the symbolic links in path. Trailing elements that
+// do not exist yet, as for a file about to be created, are kept as they are.
+func evalExisting(path string) (string, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return real, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	// A dangling link would be followed when the file is created
+	if target, err := os.Readlink(path); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return evalExisting(target)
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	realParent, err := evalExisting(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(realParent, filepath.Base(path)), nil
+}
+
+// within reports whether path is root or lies below it. Both are compared
+// as absolute paths so relative roots work too.
+func within(root, path string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
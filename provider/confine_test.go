@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalProvider_Confinement(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("s"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tempBase := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	p := NewLocalProvider(tempBase)
+	for _, path := range []string{"../secret", "sub/../../secret", filepath.Join("..", filepath.Base(outside), "secret")} {
+		if _, err := p.Stat(ctx, path); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("%s: expected ErrOutsideRoot, got %v", path, err)
+		}
+		if _, err := p.OpenWrite(ctx, path, nil); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("%s: expected writes to be rejected, got %v", path, err)
+		}
+	}
+	// ".." that stays inside the root is fine
+	if _, err := p.Stat(ctx, "sub/../a.txt"); err != nil {
+		t.Errorf("Expected a path cleaned back into the root to resolve: %v", err)
+	}
+
+	// Unrooted providers are confined with WithRoot
+	unrooted := NewLocalProvider("").WithRoot(tempBase)
+	if _, err := unrooted.Stat(ctx, filepath.Join(tempBase, "a.txt")); err != nil {
+		t.Error(err)
+	}
+	if _, err := unrooted.Stat(ctx, filepath.Join(outside, "secret")); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected ErrOutsideRoot, got %v", err)
+	}
+	if _, err := NewLocalProvider("").Stat(ctx, filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("Expected an unconfined provider to accept any path: %v", err)
+	}
+}
+
+func TestLocalProvider_StrictSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("s"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tempBase := t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(tempBase, "leak")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(tempBase, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "new"), filepath.Join(tempBase, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Without strict mode links are followed wherever they point
+	lax := NewLocalProvider(tempBase)
+	if rc, err := lax.OpenRead(ctx, "leak"); err != nil {
+		t.Errorf("Expected lax mode to follow the link: %v", err)
+	} else {
+		rc.Close()
+	}
+
+	p := NewLocalProvider(tempBase).WithStrictSymlinks(true)
+	if _, err := p.OpenRead(ctx, "leak"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected reading through an escaping link to fail, got %v", err)
+	}
+	if _, err := p.OpenWrite(ctx, "dir/planted", nil); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected writing through an escaping directory link to fail, got %v", err)
+	}
+	if _, err := p.OpenWrite(ctx, "dangling", nil); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected writing through a dangling escaping link to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be created outside the root")
+	}
+
+	// The links themselves can still be read and removed
+	if target, err := p.Readlink(ctx, "leak"); err != nil || target != filepath.Join(outside, "secret") {
+		t.Errorf("Expected Readlink to work on the link itself, got %q, %v", target, err)
+	}
+	if err := p.Delete(ctx, "leak"); err != nil {
+		t.Errorf("Expected Delete to remove the link itself: %v", err)
+	}
+
+	if err := p.Symlink(ctx, "../../etc/passwd", "sub/link"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected creating an escaping link to fail, got %v", err)
+	}
+	if err := p.Symlink(ctx, "../a.txt", "sub/link"); err != nil {
+		t.Errorf("Expected a link within the root to be created: %v", err)
+	}
+}
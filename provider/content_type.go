@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+)
+
+// ContentTypedFileInfo is implemented by the FileInfo of providers that
+// store a MIME type with files, such as the Content-Type of S3 objects.
+type ContentTypedFileInfo interface {
+	FileInfo
+	// ContentType returns the file's MIME type, and false if it was not
+	// looked up, as for files from a listing.
+	ContentType() (string, bool)
+}
+
+// contentTypedFileInfo attaches a MIME type to a FileInfo rebuilt from
+// persisted form.
+type contentTypedFileInfo struct {
+	FileInfo
+	contentType string
+}
+
+func (c *contentTypedFileInfo) ContentType() (string, bool) { return c.contentType, true }
+
+// ObjectContentType returns the MIME type recorded in info, and false if
+// info carries none because it was never looked up.
+func ObjectContentType(info FileInfo) (string, bool) {
+	for info != nil {
+		switch i := info.(type) {
+		case ContentTypedFileInfo:
+			return i.ContentType()
+		case *symlinkFileInfo:
+			info = i.UnixFileInfo
+		case *windowsFileInfo:
+			info = i.UnixFileInfo
+		case *unixFileInfo:
+			info = i.FileInfo
+		case *taggedFileInfo:
+			info = i.FileInfo
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// ContentTypeMap maps lower-case file extensions, with their leading dot,
+// to the MIME type files with them are given, overriding both the source's
+// type and detection.
+type ContentTypeMap map[string]string
+
+// ParseContentTypeMap parses comma-separated ext=type pairs, e.g.
+// ".md=text/markdown,.wasm=application/wasm". The dot is optional.
+func ParseContentTypeMap(s string) (ContentTypeMap, error) {
+	m := make(ContentTypeMap)
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		ext, typ, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		ext, typ = strings.TrimSpace(ext), strings.TrimSpace(typ)
+		if !ok || ext == "" || typ == "" {
+			return nil, fmt.Errorf("invalid content type mapping %q, expected ext=type", pair)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return nil, fmt.Errorf("invalid content type %q for %s: %w", typ, ext, err)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		m[strings.ToLower(ext)] = typ
+	}
+	return m, nil
+}
+
+// Override returns the type m gives files named name, if any.
+func (m ContentTypeMap) Override(name string) (string, bool) {
+	typ, ok := m[strings.ToLower(path.Ext(name))]
+	return typ, ok
+}
+
+// ContentTypeFor returns the MIME type to store a file named name with: the
+// override for its extension, else the type recorded in info, else the
+// type registered for its extension, or "" if none is known and the
+// content has to be sniffed.
+func ContentTypeFor(name string, info FileInfo, overrides ContentTypeMap) string {
+	if typ, ok := overrides.Override(name); ok {
+		return typ
+	}
+	if typ, _ := ObjectContentType(info); typ != "" {
+		return typ
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
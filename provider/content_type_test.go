@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseContentTypeMap(t *testing.T) {
+	m, err := ParseContentTypeMap(" .MD=text/markdown , wasm=application/wasm,.txt=text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 || m[".md"] != "text/markdown" || m[".wasm"] != "application/wasm" || m[".txt"] != "text/plain; charset=utf-8" {
+		t.Errorf("Unexpected map %v", m)
+	}
+	if typ, ok := m.Override("docs/README.Md"); !ok || typ != "text/markdown" {
+		t.Errorf("Expected extensions to match case-insensitively, got %q, %v", typ, ok)
+	}
+	if _, ok := m.Override("Makefile"); ok {
+		t.Error("Expected no override for a file without an extension")
+	}
+
+	if m, err := ParseContentTypeMap(""); err != nil || len(m) != 0 {
+		t.Errorf("Expected an empty map, got %v, %v", m, err)
+	}
+	for _, s := range []string{".md", "=text/plain", ".md=", ".md=not a type"} {
+		if _, err := ParseContentTypeMap(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestContentTypeFor(t *testing.T) {
+	overrides := ContentTypeMap{".md": "text/markdown"}
+	plain := &localFileInfo{name: "a", modTime: time.Now()}
+	typed := &contentTypedFileInfo{FileInfo: plain, contentType: "application/x-custom"}
+
+	tests := []struct {
+		name string
+		info FileInfo
+		want string
+	}{
+		{"a.md", typed, "text/markdown"},
+		{"a.html", typed, "application/x-custom"},
+		{"a.html", plain, "text/html; charset=utf-8"},
+		{"a.html", nil, "text/html; charset=utf-8"},
+		{"a.unknownext", plain, ""},
+	}
+	for _, tt := range tests {
+		if got := ContentTypeFor(tt.name, tt.info, overrides); got != tt.want {
+			t.Errorf("ContentTypeFor(%q, %T) = %q, want %q", tt.name, tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestObjectContentType_Wrapped(t *testing.T) {
+	info := FileInfo(&contentTypedFileInfo{FileInfo: &localFileInfo{name: "a"}, contentType: "image/png"})
+	info = &taggedFileInfo{FileInfo: info, tags: map[string]string{"k": "v"}}
+	info = WithModTime(info, time.Unix(1700000000, 0))
+	if typ, ok := ObjectContentType(info); !ok || typ != "image/png" {
+		t.Errorf("Expected the type through wrappers, got %q, %v", typ, ok)
+	}
+	if _, ok := ObjectContentType(&localFileInfo{name: "a"}); ok {
+		t.Error("Expected no type for a plain FileInfo")
+	}
+}
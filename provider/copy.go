@@ -0,0 +1,39 @@
+package provider
+
+import "context"
+
+// ServerSideCopier is implemented by providers that can copy a file from
+// another provider of the same kind without the data passing through
+// gofast, such as S3 CopyObject between buckets.
+type ServerSideCopier interface {
+	// CanCopyFrom reports whether files on src can be copied server-side.
+	CanCopyFrom(src Provider) bool
+
+	// CopyFrom copies srcPath on src to dstPath and returns the digest the
+	// backend reports for the copy. It returns ErrNotSupported for files
+	// it cannot copy this way, e.g. because they are too large, so the
+	// caller can fall back to streaming.
+	CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error)
+}
+
+// Unwrap returns the innermost provider beneath any wrappers such as
+// WithRetry or WithMetrics.
+func Unwrap(p Provider) Provider {
+	for {
+		w, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			return p
+		}
+		p = w.Unwrap()
+	}
+}
+
+// CanCopyServerSide reports whether dst can copy files from src without
+// streaming them.
+func CanCopyServerSide(src, dst Provider) bool {
+	if !CapabilitiesOf(dst).ServerSideCopy {
+		return false
+	}
+	copier, ok := dst.(ServerSideCopier)
+	return ok && copier.CanCopyFrom(src)
+}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnwrap(t *testing.T) {
+	local := NewLocalProvider(t.TempDir())
+	wrapped := WithCache(WithRetry(WithMetrics(local, NewMetrics()), DefaultRetryPolicy), time.Minute)
+
+	if got := Unwrap(wrapped); got != Provider(local) {
+		t.Errorf("Unwrap() = %T; want the LocalProvider", got)
+	}
+	if got := Unwrap(local); got != Provider(local) {
+		t.Errorf("Unwrap() of an unwrapped provider = %T", got)
+	}
+}
+
+func TestCanCopyServerSide(t *testing.T) {
+	src := NewLocalProvider(t.TempDir())
+	dst := WithMetrics(NewLocalProvider(t.TempDir()), NewMetrics())
+	if got := CanCopyServerSide(src, dst); got != cloneSupported {
+		t.Errorf("CanCopyServerSide(local, local) = %v; want %v", got, cloneSupported)
+	}
+
+	s3 := &S3Provider{bucket: "b"}
+	if !CanCopyServerSide(WithMetrics(&S3Provider{bucket: "a"}, NewMetrics()), WithRetry(s3, DefaultRetryPolicy)) {
+		t.Error("expected S3 to copy from a wrapped S3 source")
+	}
+	if CanCopyServerSide(src, s3) {
+		t.Error("expected S3 not to copy from a local source")
+	}
+	if CanCopyServerSide(s3, src) {
+		t.Error("expected local providers not to copy from S3")
+	}
+}
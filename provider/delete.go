@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BatchDeleter is implemented by providers that can remove many files in
+// one request, such as S3 with DeleteObjects.
+type BatchDeleter interface {
+	Deleter
+	// DeleteBatch removes the files at paths. Files that could not be
+	// removed are reported in a *BatchDeleteError; all others are gone.
+	DeleteBatch(ctx context.Context, paths []string) error
+}
+
+// DeleteFailure is a file a batch delete could not remove.
+type DeleteFailure struct {
+	Path string
+	Err  error
+}
+
+// BatchDeleteError reports the files of a batch delete that failed.
+type BatchDeleteError struct {
+	Failures []DeleteFailure
+}
+
+func (e *BatchDeleteError) Error() string {
+	if len(e.Failures) == 1 {
+		return fmt.Sprintf("failed to delete %s: %v", e.Failures[0].Path, e.Failures[0].Err)
+	}
+	return fmt.Sprintf("failed to delete %d files, first %s: %v", len(e.Failures), e.Failures[0].Path, e.Failures[0].Err)
+}
+
+// Unwrap returns the error of each failed file, so errors.As and IsTransient
+// see through the batch.
+func (e *BatchDeleteError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// Paths returns the paths of the failed files.
+func (e *BatchDeleteError) Paths() []string {
+	paths := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		paths[i] = f.Path
+	}
+	return paths
+}
+
+// DeleteAll removes paths from p, in batches on providers implementing
+// BatchDeleter and with one Delete per file otherwise. Files that could not
+// be removed are reported in a *BatchDeleteError.
+func DeleteAll(ctx context.Context, p Provider, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if bd, ok := p.(BatchDeleter); ok {
+		if err := bd.DeleteBatch(ctx, paths); !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	d, ok := p.(Deleter)
+	if !ok || !CapabilitiesOf(p).Delete {
+		return ErrNotSupported
+	}
+	var failures []DeleteFailure
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.Delete(ctx, path); err != nil {
+			failures = append(failures, DeleteFailure{Path: path, Err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return &BatchDeleteError{Failures: failures}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// batchStore deletes in batches, failing the paths in fail with their error.
+type batchStore struct {
+	*LocalProvider
+	batches [][]string
+	fail    map[string]error
+}
+
+func (b *batchStore) DeleteBatch(ctx context.Context, paths []string) error {
+	b.batches = append(b.batches, append([]string(nil), paths...))
+	var failures []DeleteFailure
+	for _, path := range paths {
+		if err, ok := b.fail[path]; ok {
+			failures = append(failures, DeleteFailure{Path: path, Err: err})
+			continue
+		}
+		b.LocalProvider.Delete(ctx, path)
+	}
+	if len(failures) > 0 {
+		return &BatchDeleteError{Failures: failures}
+	}
+	return nil
+}
+
+func TestDeleteAll_OneByOne(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	p := NewLocalProvider(dir)
+
+	err := DeleteAll(context.Background(), p, []string{"a", "missing", "b"})
+	var batch *BatchDeleteError
+	if !errors.As(err, &batch) || len(batch.Failures) != 1 || batch.Failures[0].Path != "missing" {
+		t.Fatalf("Expected only the missing file to fail, got %v", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the failure's error to be unwrapped, got %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted", name)
+		}
+	}
+	if err := DeleteAll(context.Background(), p, nil); err != nil {
+		t.Errorf("Expected nothing to delete, got %v", err)
+	}
+}
+
+func TestDeleteAll_Batched(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644)
+	store := &batchStore{LocalProvider: NewLocalProvider(dir)}
+
+	// Wrappers pass batches through to the backend
+	if err := DeleteAll(context.Background(), WithCache(store, 0), []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 1 {
+		t.Errorf("Expected one batch, got %v", store.batches)
+	}
+
+	// Without batch support the wrapper falls back to single deletes
+	os.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0644)
+	if err := DeleteAll(context.Background(), Wrapper{NewLocalProvider(dir)}, []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Error("Expected b to be deleted one by one")
+	}
+}
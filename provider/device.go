@@ -0,0 +1,33 @@
+package provider
+
+// deviceInfo is implemented by FileInfos that carry the device they are on.
+type deviceInfo interface {
+	device() (uint64, bool)
+}
+
+func (l *localFileInfo) device() (uint64, bool) { return l.dev, l.hasDev }
+
+// Device returns the ID of the device (filesystem) info is on, and false
+// when the provider or platform did not report one. Entries on different
+// devices are on different mounts.
+func Device(info FileInfo) (uint64, bool) {
+	for info != nil {
+		switch i := info.(type) {
+		case deviceInfo:
+			return i.device()
+		case *symlinkFileInfo:
+			info = i.UnixFileInfo
+		case *windowsFileInfo:
+			info = i.UnixFileInfo
+		case *unixFileInfo:
+			info = i.FileInfo
+		case *taggedFileInfo:
+			info = i.FileInfo
+		case *contentTypedFileInfo:
+			info = i.FileInfo
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package provider
+
+import "os"
+
+// statDevice reports no device: outside Unix it needs an open handle.
+func statDevice(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
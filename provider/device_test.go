@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDevice(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	p := NewLocalProvider(dir)
+	root, err := p.Stat(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := p.List(context.Background(), "")
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("List: %v, %v", infos, err)
+	}
+	rootDev, ok := Device(root)
+	fileDev, fileOK := Device(infos[0])
+	if ok != fileOK || rootDev != fileDev {
+		t.Errorf("expected files of one directory on one device, got %d, %v and %d, %v", rootDev, ok, fileDev, fileOK)
+	}
+	if _, ok := Device(struct{ FileInfo }{}); ok {
+		t.Error("expected no device for a FileInfo without one")
+	}
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// DirectIOAlignment is the buffer, offset and length alignment direct I/O
+// needs. Buffers handed to direct readers and writers should be allocated
+// with it, e.g. by engine.NewAlignedBufferPool.
+const DirectIOAlignment = 4096
+
+// directAligned reports whether a transfer of p at offset off may bypass
+// the page cache.
+func directAligned(p []byte, off int64) bool {
+	return len(p) > 0 && len(p)%DirectIOAlignment == 0 && off%DirectIOAlignment == 0 &&
+		uintptr(unsafe.Pointer(&p[0]))%DirectIOAlignment == 0
+}
+
+// directFile tracks a file opened for direct I/O. The first transfer that
+// is not aligned, typically the tail of the file, switches it back to
+// buffered I/O for good.
+type directFile struct {
+	file   *os.File
+	direct bool
+	offset int64
+}
+
+// prepare switches f to buffered I/O unless a transfer of p is aligned.
+func (f *directFile) prepare(p []byte) error {
+	if f.direct && !directAligned(p, f.offset) {
+		if err := setDirect(f.file, false); err != nil {
+			return err
+		}
+		f.direct = false
+	}
+	return nil
+}
+
+func (f *directFile) read(p []byte) (int, error) {
+	if err := f.prepare(p); err != nil {
+		return 0, err
+	}
+	n, err := f.file.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *directFile) write(p []byte) (int, error) {
+	if err := f.prepare(p); err != nil {
+		return 0, err
+	}
+	n, err := f.file.Write(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// seek moves the offset of the file.
+func (f *directFile) seek(offset int64, whence int) (int64, error) {
+	pos, err := f.file.Seek(offset, whence)
+	if err == nil {
+		f.offset = pos
+	}
+	return pos, err
+}
+
+// directReader reads a file opened for direct I/O. It deliberately does not
+// implement io.WriterTo, which would bypass Read.
+type directReader struct {
+	df directFile
+}
+
+func (r *directReader) Read(p []byte) (int, error) { return r.df.read(p) }
+func (r *directReader) Close() error               { return r.df.file.Close() }
+
+var _ io.ReadCloser = (*directReader)(nil)
+
+// directWriter writes a file opened for direct I/O and applies metadata on
+// close. It deliberately does not implement io.ReaderFrom.
+type directWriter struct {
+	lw *localWriteCloser
+}
+
+func (w *directWriter) Write(p []byte) (int, error) { return w.lw.write(p) }
+func (w *directWriter) Close() error                { return w.lw.Close() }
@@ -0,0 +1,42 @@
+//go:build linux
+
+package provider
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// directIOSupported reports whether files can be opened for direct I/O.
+const directIOSupported = true
+
+// openDirect opens name with O_DIRECT, or without it if the filesystem
+// refuses, and reports which it got.
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, bool, error) {
+	f, err := os.OpenFile(name, flag|unix.O_DIRECT, perm)
+	if err == nil {
+		return f, true, nil
+	}
+	if !errors.Is(err, unix.EINVAL) {
+		return nil, false, err
+	}
+	f, err = os.OpenFile(name, flag, perm)
+	return f, false, err
+}
+
+// setDirect turns O_DIRECT on or off for an open file.
+func setDirect(f *os.File, direct bool) error {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	if direct {
+		flags |= unix.O_DIRECT
+	} else {
+		flags &^= unix.O_DIRECT
+	}
+	_, err = unix.FcntlInt(f.Fd(), unix.F_SETFL, flags)
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package provider
+
+import "os"
+
+// directIOSupported reports whether files can be opened for direct I/O.
+const directIOSupported = false
+
+// openDirect opens name for buffered I/O: this platform has no O_DIRECT.
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, bool, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	return f, false, err
+}
+
+// setDirect is a no-op without O_DIRECT.
+func setDirect(f *os.File, direct bool) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"unsafe"
+)
+
+// alignedBuffer returns a size-byte slice aligned to DirectIOAlignment.
+func alignedBuffer(size int) []byte {
+	raw := make([]byte, size+DirectIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) % DirectIOAlignment); rem != 0 {
+		offset = DirectIOAlignment - rem
+	}
+	return raw[offset : offset+size]
+}
+
+func TestLocalProvider_DirectIO(t *testing.T) {
+	ctx := context.Background()
+	p := NewLocalProvider(t.TempDir()).WithDirectIO(true)
+
+	// Two aligned blocks and an unaligned tail
+	content := bytes.Repeat([]byte("0123456789abcdef"), (2*DirectIOAlignment+100)/16)
+	content = append(content, "tail"...)
+
+	w, err := p.OpenWrite(ctx, "direct.bin", nil)
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		t.Error("direct writers must not implement io.ReaderFrom")
+	}
+	buf := alignedBuffer(2 * DirectIOAlignment)
+	if _, err := io.CopyBuffer(w, bytes.NewReader(content), buf); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := p.OpenRead(ctx, "direct.bin")
+	if err != nil {
+		t.Fatalf("OpenRead failed: %v", err)
+	}
+	defer r.Close()
+	var got bytes.Buffer
+	if _, err := io.CopyBuffer(struct{ io.Writer }{&got}, r, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("read %d bytes that differ from the %d written", got.Len(), len(content))
+	}
+}
+
+func TestDirectAligned(t *testing.T) {
+	buf := alignedBuffer(2 * DirectIOAlignment)
+	if !directAligned(buf, 0) || !directAligned(buf, DirectIOAlignment) {
+		t.Error("expected an aligned buffer at an aligned offset to be aligned")
+	}
+	if directAligned(buf[:100], 0) || directAligned(buf, 10) || directAligned(buf[1:DirectIOAlignment+1], 0) {
+		t.Error("expected short, offset or misaligned transfers not to be aligned")
+	}
+}
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// DirMaker is implemented by providers that can create directories of
+// their own, so empty directories, and the ownership and permissions of
+// directories, are carried over rather than implied by the files written.
+type DirMaker interface {
+	// MakeDir creates the directory at path, with its parents, unless it
+	// exists, and applies the metadata of info to it as writes apply that
+	// of files. A nil info only creates it.
+	MakeDir(ctx context.Context, path string, info FileInfo) error
+}
+
+// MakeDir creates the directory at path on p, if p supports directories,
+// and returns ErrNotSupported otherwise.
+func MakeDir(ctx context.Context, p Provider, path string, info FileInfo) error {
+	if CapabilitiesOf(p).Directories {
+		if d, ok := p.(DirMaker); ok {
+			return d.MakeDir(ctx, path, info)
+		}
+	}
+	return ErrNotSupported
+}
+
+// MakeDir creates the directory at path and its parents, and applies the
+// ownership and permissions of info through the metadata mapper, if one is
+// configured, and its times, as closing a written file does. An existing
+// directory is kept, with its metadata replaced. Creating entries in a
+// directory changes its modification time, so directories are made after
+// their contents, deepest first; see engine.DirSet.
+func (p *LocalProvider) MakeDir(ctx context.Context, path string, info FileInfo) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fullPath, 0755); err != nil {
+		return err
+	}
+	if info == nil {
+		return nil
+	}
+
+	var metaErrs []error
+	if p.mapper != nil {
+		if err := p.applier.ApplyMetadata(fullPath, info, p.mapper); err != nil {
+			metaErrs = append(metaErrs, err)
+		}
+	}
+	if !info.ModTime().IsZero() {
+		if err := applyTimes(fullPath, info, p.atime, p.btime); err != nil {
+			metaErrs = append(metaErrs, err)
+		}
+	}
+	if len(metaErrs) == 0 || p.onError == MetadataErrorsIgnore {
+		return nil
+	}
+	return &MetadataError{Path: fullPath, Err: errors.Join(metaErrs...), Policy: p.onError}
+}
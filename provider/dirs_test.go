@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLocalProvider_MakeDir(t *testing.T) {
+	base := t.TempDir()
+	p := NewLocalProvider(base)
+	ctx := context.Background()
+
+	src, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	info := NewUnixFileInfo(WithModTime(WrapOSFileInfo(src), mtime), 0, 0, os.ModeDir|0o750)
+	if err := MakeDir(ctx, p, "a/empty", info); err != nil {
+		t.Fatalf("MakeDir failed: %v", err)
+	}
+	got, err := os.Stat(filepath.Join(base, "a/empty"))
+	if err != nil || !got.IsDir() {
+		t.Fatalf("Expected a directory, got %v (%v)", got, err)
+	}
+	if runtime.GOOS != "windows" && got.Mode().Perm() != 0o750 {
+		t.Errorf("Expected mode 0750, got %v", got.Mode().Perm())
+	}
+	if !got.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, got.ModTime())
+	}
+
+	// Existing directories are kept
+	if err := MakeDir(ctx, Wrapper{p}, "a", nil); err != nil {
+		t.Errorf("Expected an existing directory to be kept, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "f"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeDir(ctx, p, "f", nil); err == nil {
+		t.Error("Expected MakeDir over a file to fail")
+	}
+}
+
+func TestMakeDir_NotSupported(t *testing.T) {
+	if err := MakeDir(context.Background(), plainProvider{NewLocalProvider(t.TempDir())}, "d", nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+}
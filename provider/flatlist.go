@@ -0,0 +1,13 @@
+package provider
+
+import "context"
+
+// FlatLister is implemented by providers that can enumerate every file
+// below a directory in one scan instead of one listing per subdirectory,
+// such as object stores whose keys only imitate a hierarchy.
+type FlatLister interface {
+	// ListAll calls fn for each file below path with its slash-separated
+	// path relative to path, as the scan returns them. Directories are
+	// not reported. It stops at the first error fn returns.
+	ListAll(ctx context.Context, path string, fn func(rel string, info FileInfo) error) error
+}
@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Linker is implemented by providers that can give one file several
+// names, so identical files are stored once.
+type Linker interface {
+	// Link makes path a hard link to the existing file at existing,
+	// replacing any file at path. Both names then share one file: its
+	// content, permissions, ownership and modification time.
+	Link(ctx context.Context, existing, path string) error
+}
+
+// Link creates path as a hard link to existing, creating parent
+// directories as needed. The link is created under a temporary name and
+// renamed over path, so path never goes missing.
+func (p *LocalProvider) Link(ctx context.Context, existing, path string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if !hardlinksSupported {
+		return ErrNotSupported
+	}
+
+	existingPath, err := p.resolve(existing)
+	if err != nil {
+		return err
+	}
+	fullPath, err := p.resolveLink(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	tmp := fullPath + ".gofast-link"
+	os.Remove(tmp)
+	if err := os.Link(existingPath, tmp); err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w", fullPath, existingPath, err)
+	}
+	if err := os.Rename(tmp, fullPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// breakLink removes the file at fullPath if it has other names, so
+// rewriting it creates a new file instead of changing the content of the
+// files it is linked with.
+func breakLink(fullPath string) error {
+	info, err := os.Lstat(fullPath)
+	if err != nil || linkCount(info) <= 1 {
+		return nil
+	}
+	return os.Remove(fullPath)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package provider
+
+import "os"
+
+// hardlinksSupported reports whether Link and linkCount work here. Link
+// counts are not reported on this platform, so files written through one
+// name could change the others, and links are not created.
+const hardlinksSupported = false
+
+// linkCount is only implemented on Unix.
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalProvider_Link(t *testing.T) {
+	if !hardlinksSupported {
+		t.Skip("hard links are not supported on this platform")
+	}
+	dir := t.TempDir()
+	p := NewLocalProvider(dir)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("replaced"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"b.txt", "sub/c.txt"} {
+		if err := p.Link(ctx, "a.txt", path); err != nil {
+			t.Fatalf("Link %s: %v", path, err)
+		}
+		a, _ := os.Stat(filepath.Join(dir, "a.txt"))
+		linked, err := os.Stat(filepath.Join(dir, path))
+		if err != nil || !os.SameFile(a, linked) {
+			t.Errorf("expected %s to be a link to a.txt, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt.gofast-link")); !os.IsNotExist(err) {
+		t.Errorf("expected no temporary link left behind, got %v", err)
+	}
+
+	// Rewriting one name must leave the others alone
+	w, err := p.OpenWrite(ctx, "b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(w, strings.NewReader("new content"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"a.txt": "shared", "sub/c.txt": "shared", "b.txt": "new content"} {
+		got, _ := os.ReadFile(filepath.Join(dir, path))
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestWrapper_Link(t *testing.T) {
+	w := Wrapper{Provider: plainProvider{NewLocalProvider(t.TempDir())}}
+	if err := w.Link(context.Background(), "a", "b"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package provider
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardlinksSupported reports whether Link and linkCount work here.
+const hardlinksSupported = true
+
+// linkCount returns the number of names of the file behind info.
+func linkCount(info os.FileInfo) uint64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return uint64(st.Nlink)
+}
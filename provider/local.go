@@ -2,9 +2,13 @@ package provider
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 )
 
@@ -13,6 +17,10 @@ type localFileInfo struct {
 	size    int64
 	isDir   bool
 	modTime time.Time
+	atime   time.Time
+	btime   time.Time
+	dev     uint64
+	hasDev  bool
 }
 
 func (l *localFileInfo) Name() string       { return l.name }
@@ -30,14 +38,27 @@ func (l *localFileInfo) Mode() os.FileMode { return 0 }
 type LocalProvider struct {
 	basePath string
 	mapper   *MetadataMapper
+	applier  MetadataApplier
+	onError  MetadataErrorPolicy
+	sparse   bool
+	directIO bool
+	fsync    bool
+	root     string
+	strict   bool
+	atime    bool
+	btime    bool
+	uring    *IOUring
 }
 
-// NewLocalProvider creates a new LocalProvider rooted at basePath.
-// If basePath is empty, it acts upon absolute or relative paths directly.
+// NewLocalProvider creates a new LocalProvider rooted at basePath; paths
+// that escape it are rejected. If basePath is empty, it acts upon absolute
+// or relative paths directly.
 func NewLocalProvider(basePath string) *LocalProvider {
 	return &LocalProvider{
 		basePath: basePath,
 		mapper:   NewMetadataMapper(), // default empty mapper
+		applier:  DefaultMetadataApplier(),
+		onError:  MetadataErrorsIgnore,
 	}
 }
 
@@ -47,12 +68,63 @@ func (p *LocalProvider) WithMetadataMapper(mapper *MetadataMapper) *LocalProvide
 	return p
 }
 
-func (p *LocalProvider) resolve(path string) string {
-	if p.basePath == "" {
-		return path
+// WithMetadataApplier replaces the platform's default metadata applier.
+func (p *LocalProvider) WithMetadataApplier(applier MetadataApplier) *LocalProvider {
+	p.applier = applier
+	return p
+}
+
+// WithMetadataErrorPolicy sets how failures to apply metadata on close are
+// reported. The default is MetadataErrorsIgnore.
+func (p *LocalProvider) WithMetadataErrorPolicy(policy MetadataErrorPolicy) *LocalProvider {
+	p.onError = policy
+	return p
+}
+
+// WithSparseWrites makes written files sparse: whole blocks of zeros are
+// skipped and left as holes instead of being written.
+func (p *LocalProvider) WithSparseWrites(enabled bool) *LocalProvider {
+	p.sparse = enabled
+	return p
+}
+
+// WithDirectIO makes OpenRead and OpenWrite bypass the page cache with
+// O_DIRECT where the platform and filesystem support it, so that large
+// migrations do not evict the cache of production hosts. Only transfers
+// through buffers aligned to DirectIOAlignment bypass the cache; the
+// unaligned tail of a file, and files on filesystems that refuse O_DIRECT,
+// fall back to buffered I/O.
+func (p *LocalProvider) WithDirectIO(enabled bool) *LocalProvider {
+	p.directIO = enabled && directIOSupported
+	return p
+}
+
+// WithFsync makes closing a written file flush it, and the directory
+// entry in its parent, to stable storage before reporting success, so a
+// crash right after a job is marked completed cannot lose the file.
+func (p *LocalProvider) WithFsync(enabled bool) *LocalProvider {
+	p.fsync = enabled
+	return p
+}
+
+// Capabilities reports the features of the local filesystem. Ownership and
+// permissions are only preserved when a metadata mapper is configured.
+func (p *LocalProvider) Capabilities() Capabilities {
+	return Capabilities{
+		Delete:     true,
+		Rename:     true,
+		RangedRead: true,
+		Checksums:  true,
+		Symlinks:   true,
+		Metadata:   p.mapper != nil,
+
+		ServerSideCopy: cloneSupported,
+		Sparse:         true,
+		ChunkedWrite:   !p.directIO && !p.sparse,
+		AppendWrite:    !p.directIO && !p.sparse,
+		Hardlinks:      hardlinksSupported,
+		Directories:    true,
 	}
-	// To prevent traversing outside base, we could add checks here later
-	return filepath.Join(p.basePath, filepath.Clean(path))
 }
 
 func (p *LocalProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
@@ -62,13 +134,16 @@ func (p *LocalProvider) Stat(ctx context.Context, path string) (FileInfo, error)
 	default:
 	}
 
-	fullPath := p.resolve(path)
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return nil, err
+	}
 	info, err := os.Stat(fullPath)
 	if err != nil {
 		return nil, err
 	}
 
-	return WrapOSFileInfo(info), nil
+	return withOwnerSID(fullPath, WrapOSFileInfo(info)), nil
 }
 
 func (p *LocalProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
@@ -78,7 +153,10 @@ func (p *LocalProvider) List(ctx context.Context, path string) ([]FileInfo, erro
 	default:
 	}
 
-	fullPath := p.resolve(path)
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return nil, err
+	}
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
 		return nil, err
@@ -90,11 +168,62 @@ func (p *LocalProvider) List(ctx context.Context, path string) ([]FileInfo, erro
 		if err != nil {
 			continue // skip files that disappeared between ReadDir and Info
 		}
-		infos = append(infos, WrapOSFileInfo(info))
+
+		entryPath := filepath.Join(fullPath, entry.Name())
+		wrapped := withOwnerSID(entryPath, WrapOSFileInfo(info))
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(entryPath)
+			if err != nil {
+				continue // link disappeared or is unreadable
+			}
+			infos = append(infos, &symlinkFileInfo{UnixFileInfo: wrapped, target: target})
+			continue
+		}
+		infos = append(infos, wrapped)
 	}
 	return infos, nil
 }
 
+// Readlink returns the target of the symbolic link at path.
+func (p *LocalProvider) Readlink(ctx context.Context, path string) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+	}
+
+	fullPath, err := p.resolveLink(path)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(fullPath)
+}
+
+// Symlink creates a symbolic link at path pointing to target, creating parent
+// directories as needed and replacing any existing file at path.
+func (p *LocalProvider) Symlink(ctx context.Context, target, path string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	fullPath, err := p.resolveLink(path)
+	if err != nil {
+		return err
+	}
+	if err := p.checkLinkTarget(target, fullPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, fullPath)
+}
+
 func (p *LocalProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
 	select {
 	case <-ctx.Done():
@@ -102,42 +231,276 @@ func (p *LocalProvider) OpenRead(ctx context.Context, path string) (io.ReadClose
 	default:
 	}
 
-	fullPath := p.resolve(path)
-	return os.Open(fullPath)
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if !p.directIO {
+		file, err := os.Open(fullPath)
+		if err != nil || p.uring == nil {
+			return file, err
+		}
+		return &uringReader{file: file, ring: p.uring}, nil
+	}
+	f, direct, err := openDirect(fullPath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &directReader{df: directFile{file: f, direct: direct}}, nil
+}
+
+// Checksum computes the CRC32C digest of a local file. Local filesystems do
+// not store checksums, so this reads the file, but it yields the same
+// algorithm S3 reports for objects uploaded with CRC32C checksums.
+func (p *LocalProvider) Checksum(ctx context.Context, path string) (Digest, error) {
+	select {
+	case <-ctx.Done():
+		return Digest{}, ctx.Err()
+	default:
+	}
+
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return Digest{}, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return Digest{}, err
+	}
+	defer file.Close()
+	return crc32cDigest(file)
+}
+
+// OpenReadRange opens length bytes of a file starting at offset by seeking
+// the underlying file descriptor. A negative length reads to the end.
+func (p *LocalProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if p.uring != nil {
+		return limitReadCloser(&uringReader{file: file, ring: p.uring, off: max(offset, 0)}, length), nil
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return limitReadCloser(file, length), nil
 }
 
 func (p *LocalProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	lw, err := p.openWrite(ctx, path, metadata, os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.sparse:
+		return &sparseWriter{lw: lw}, nil
+	case lw.direct != nil:
+		return &directWriter{lw: lw}, nil
+	case p.uring != nil:
+		return &uringWriter{lw: lw, ring: p.uring}, nil
+	}
+	return lw, nil
+}
+
+// OpenWriteAt opens path for writing from offset, keeping the bytes an
+// interrupted write left before it. Anything past offset is cut off, and a
+// file shorter than offset, or replaced since, is continued from its end.
+// Sparse and direct writes are not continued: path is rewritten from the
+// start, as by OpenWrite.
+func (p *LocalProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	if p.sparse || p.directIO || offset <= 0 {
+		w, err := p.OpenWrite(ctx, path, metadata)
+		return w, 0, err
+	}
+	lw, err := p.openWrite(ctx, path, metadata, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := lw.Stat()
+	if err != nil {
+		lw.File.Close()
+		return nil, 0, err
+	}
+	offset = min(offset, info.Size())
+	if err := lw.Truncate(offset); err != nil {
+		lw.File.Close()
+		return nil, 0, err
+	}
+	if _, err := lw.Seek(offset, io.SeekStart); err != nil {
+		lw.File.Close()
+		return nil, 0, err
+	}
+	if p.uring != nil {
+		return &uringWriter{lw: lw, ring: p.uring, off: offset}, offset, nil
+	}
+	return lw, offset, nil
+}
+
+// openWrite creates path, with its parent directories, opening it with flag
+// besides O_CREATE|O_WRONLY.
+func (p *LocalProvider) openWrite(ctx context.Context, path string, metadata FileInfo, flag int) (*localWriteCloser, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
 
-	fullPath := p.resolve(path)
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create parent directories if they don't exist
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
 		return nil, err
 	}
+	// A file deduplicated into a hard link is replaced, not written through
+	if err := breakLink(fullPath); err != nil {
+		return nil, err
+	}
 
 	mode := os.FileMode(0644)
 	if uInfo, ok := metadata.(UnixFileInfo); ok && uInfo.Mode() != 0 {
 		mode = uInfo.Mode()
 	}
 
-	file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
-	if err != nil {
-		return nil, err
+	var file *os.File
+	var direct *directFile
+	open := func() error {
+		if p.directIO {
+			f, isDirect, err := openDirect(fullPath, os.O_CREATE|os.O_WRONLY|flag, mode)
+			if err != nil {
+				return err
+			}
+			file, direct = f, &directFile{file: f, direct: isDirect}
+			return nil
+		}
+		f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|flag, mode)
+		file = f
+		return err
+	}
+	if err := open(); err != nil {
+		// An earlier run may have left the file read-only, as its source
+		// was; metadata is applied again once the new content is written
+		if !errors.Is(err, fs.ErrPermission) || makeWritable(fullPath) != nil {
+			return nil, err
+		}
+		if err := open(); err != nil {
+			return nil, err
+		}
 	}
 
 	return &localWriteCloser{
 		File:     file,
+		direct:   direct,
+		fsync:    p.fsync,
+		atime:    p.atime,
+		btime:    p.btime,
 		fullPath: fullPath,
 		metadata: metadata,
 		mapper:   p.mapper,
+		applier:  p.applier,
+		onError:  p.onError,
 	}, nil
 }
 
+// Delete removes a file or empty directory.
+func (p *LocalProvider) Delete(ctx context.Context, path string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	fullPath, err := p.resolveLink(path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fullPath)
+}
+
+// Rename moves a file within the provider, creating the destination's parent
+// directories as needed.
+func (p *LocalProvider) Rename(ctx context.Context, from, to string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	fromPath, err := p.resolveLink(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := p.resolveLink(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(fromPath, toPath)
+}
+
+// CanCopyFrom reports whether src is a local filesystem, whose files can be
+// cloned or copied in the kernel.
+func (p *LocalProvider) CanCopyFrom(src Provider) bool {
+	_, ok := Unwrap(src).(*LocalProvider)
+	return cloneSupported && ok
+}
+
+// CopyFrom copies a file from another local provider with a reflink or
+// copy_file_range, then applies metadata as OpenWrite would. It returns
+// ErrNotSupported when the kernel cannot copy between the two files, e.g.
+// across filesystems, so the caller can fall back to streaming. No digest is
+// reported: the kernel copies the bytes exactly.
+func (p *LocalProvider) CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error) {
+	srcLocal, ok := Unwrap(src).(*LocalProvider)
+	if !ok {
+		return Digest{}, ErrNotSupported
+	}
+
+	srcFullPath, err := srcLocal.resolve(srcPath)
+	if err != nil {
+		return Digest{}, err
+	}
+	srcFile, err := os.Open(srcFullPath)
+	if err != nil {
+		return Digest{}, err
+	}
+	defer srcFile.Close()
+
+	stat, err := srcFile.Stat()
+	if err != nil {
+		return Digest{}, err
+	}
+
+	lw, err := p.openWrite(ctx, dstPath, info, os.O_TRUNC)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	if err := cloneFile(lw.File, srcFile, stat.Size()); err != nil {
+		lw.File.Close()
+		return Digest{}, err
+	}
+	return Digest{}, lw.Close()
+}
+
 // localWriteCloser wraps an os.File and applies metadata (such as timestamps) upon close.
 // This is necessary because writing to the file updates its mtime.
 type localWriteCloser struct {
@@ -145,24 +508,101 @@ type localWriteCloser struct {
 	fullPath string
 	metadata FileInfo
 	mapper   *MetadataMapper
+	applier  MetadataApplier
+	onError  MetadataErrorPolicy
+	// direct is set when the file was opened for direct I/O.
+	direct *directFile
+	fsync  bool
+	atime  bool
+	btime  bool
+}
+
+// write writes p, through the direct I/O state if the file has one.
+func (l *localWriteCloser) write(p []byte) (int, error) {
+	if l.direct != nil {
+		return l.direct.write(p)
+	}
+	return l.File.Write(p)
+}
+
+// SendFile copies from src in the kernel. Files opened for direct I/O write
+// through their aligned buffer and return ErrNotSupported.
+func (l *localWriteCloser) SendFile(ctx context.Context, src *os.File, n int64) (int64, error) {
+	if l.direct != nil {
+		return 0, ErrNotSupported
+	}
+	return sendFile(l.File, src, n)
+}
+
+// seek moves the write offset, keeping the direct I/O state in step.
+func (l *localWriteCloser) seek(offset int64, whence int) (int64, error) {
+	if l.direct != nil {
+		return l.direct.seek(offset, whence)
+	}
+	return l.File.Seek(offset, whence)
 }
 
 func (l *localWriteCloser) Close() error {
+	if l.fsync {
+		if err := l.File.Sync(); err != nil {
+			l.File.Close()
+			return fmt.Errorf("failed to sync %s: %w", l.fullPath, err)
+		}
+	}
 	err := l.File.Close()
 	if err != nil {
 		return err
 	}
+	if l.fsync {
+		if err := syncDir(filepath.Dir(l.fullPath)); err != nil {
+			return fmt.Errorf("failed to sync directory of %s: %w", l.fullPath, err)
+		}
+	}
+
+	var metaErrs []error
 
 	// Apply any ownership and permissions mapped via mapper
 	if l.mapper != nil && l.metadata != nil {
-		// Ignore metadata application errors for now during sync (permissions issues, etc)
-		_ = ApplyMetadata(l.fullPath, l.metadata, l.mapper)
+		if err := l.applier.ApplyMetadata(l.fullPath, l.metadata, l.mapper); err != nil {
+			metaErrs = append(metaErrs, err)
+		}
 	}
 
 	if l.metadata != nil && !l.metadata.ModTime().IsZero() {
-		// Ignore errors on applying timestamp
-		_ = os.Chtimes(l.fullPath, time.Now(), l.metadata.ModTime())
+		if err := l.applyTimes(); err != nil {
+			metaErrs = append(metaErrs, err)
+		}
 	}
 
-	return nil
+	if len(metaErrs) == 0 || l.onError == MetadataErrorsIgnore {
+		return nil
+	}
+	return &MetadataError{Path: l.fullPath, Err: errors.Join(metaErrs...), Policy: l.onError}
+}
+
+// makeWritable gives the owner write permission on an existing regular
+// file. On Windows this clears the read-only attribute.
+func makeWritable(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0o200 != 0 {
+		return fs.ErrPermission
+	}
+	return os.Chmod(path, info.Mode().Perm()|0o200)
+}
+
+// syncDir flushes the entries of directory dir to stable storage. Windows
+// cannot sync directories; its file syncs cover the directory entry.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
@@ -2,9 +2,11 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -192,3 +194,351 @@ func TestLocalProvider_OpenWrite(t *testing.T) {
 		t.Errorf("expected mod time %v, got %v", testModTime, stat.ModTime())
 	}
 }
+
+func TestLocalProvider_OpenReadRange(t *testing.T) {
+	tempBase, err := os.MkdirTemp("", "local-provider-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempBase)
+
+	testFile := "test-range.txt"
+	testContent := []byte("0123456789abcdef")
+	if err := os.WriteFile(filepath.Join(tempBase, testFile), testContent, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewLocalProvider(tempBase)
+	ctx := context.Background()
+
+	tests := []struct {
+		offset, length int64
+		expect         string
+	}{
+		{0, 4, "0123"},
+		{10, 3, "abc"},
+		{12, -1, "cdef"},
+		{14, 10, "ef"},
+		{0, 0, ""},
+	}
+
+	for _, tt := range tests {
+		rc, err := p.OpenReadRange(ctx, testFile, tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("OpenReadRange(%d, %d) failed: %v", tt.offset, tt.length, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if string(content) != tt.expect {
+			t.Errorf("OpenReadRange(%d, %d) = %q; want %q", tt.offset, tt.length, content, tt.expect)
+		}
+	}
+}
+
+// plainProvider hides the optional interfaces of the wrapped provider so the
+// generic fallbacks can be exercised.
+type plainProvider struct {
+	Provider
+}
+
+func TestOpenReadRange_Fallback(t *testing.T) {
+	tempBase, err := os.MkdirTemp("", "local-provider-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempBase)
+
+	testFile := "test-range.txt"
+	if err := os.WriteFile(filepath.Join(tempBase, testFile), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := plainProvider{NewLocalProvider(tempBase)}
+	rc, err := OpenReadRange(context.Background(), p, testFile, 3, 4)
+	if err != nil {
+		t.Fatalf("OpenReadRange failed: %v", err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(content) != "3456" {
+		t.Errorf("expected %q, got %q", "3456", content)
+	}
+}
+
+func TestLocalProvider_OpenWriteAt(t *testing.T) {
+	dir := t.TempDir()
+	p := NewLocalProvider(dir)
+	ctx := context.Background()
+	if err := os.WriteFile(filepath.Join(dir, "part.bin"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		offset        int64
+		expectOffset  int64
+		expectContent string
+	}{
+		{"past the checkpoint is cut off", 4, 4, "0123abc"},
+		{"a shorter file continues from its end", 100, 7, "0123abcabc"},
+		{"missing file starts from zero", 5, 0, "abc"},
+	}
+	for _, tt := range tests {
+		name := "part.bin"
+		if tt.expectOffset == 0 {
+			name = "missing.bin"
+		}
+		w, offset, err := OpenWriteAt(ctx, p, name, nil, tt.offset)
+		if err != nil {
+			t.Fatalf("%s: OpenWriteAt failed: %v", tt.name, err)
+		}
+		if offset != tt.expectOffset {
+			t.Errorf("%s: expected offset %d, got %d", tt.name, tt.expectOffset, offset)
+		}
+		w.Write([]byte("abc"))
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Close failed: %v", tt.name, err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != tt.expectContent {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expectContent, got)
+		}
+	}
+
+	// Sparse writes are rewritten from the start
+	w, offset, err := OpenWriteAt(ctx, NewLocalProvider(dir).WithSparseWrites(true), "part.bin", nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("xyz"))
+	w.Close()
+	if got, _ := os.ReadFile(filepath.Join(dir, "part.bin")); offset != 0 || string(got) != "xyz" {
+		t.Errorf("Expected a sparse write to start over, got offset %d and %q", offset, got)
+	}
+
+	// Providers that cannot append fall back to OpenWrite
+	w, offset, err = OpenWriteAt(ctx, plainProvider{p}, "part.bin", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if offset != 0 {
+		t.Errorf("Expected the fallback to start from zero, got %d", offset)
+	}
+}
+
+func TestLocalProvider_Delete(t *testing.T) {
+	tempBase, err := os.MkdirTemp("", "local-provider-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempBase)
+
+	if err := os.WriteFile(filepath.Join(tempBase, "gone.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewLocalProvider(tempBase)
+	if err := p.Delete(context.Background(), "gone.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempBase, "gone.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected file to be removed, got %v", err)
+	}
+}
+
+func TestLocalProvider_Symlinks(t *testing.T) {
+	tempBase, err := os.MkdirTemp("", "local-provider-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempBase)
+
+	p := NewLocalProvider(tempBase)
+	ctx := context.Background()
+
+	if err := p.Symlink(ctx, "../target", "dir/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	// Replacing an existing link must succeed.
+	if err := p.Symlink(ctx, "../other", "dir/link"); err != nil {
+		t.Fatalf("Symlink replace failed: %v", err)
+	}
+
+	target, err := p.Readlink(ctx, "dir/link")
+	if err != nil {
+		t.Fatalf("Readlink failed: %v", err)
+	}
+	if target != "../other" {
+		t.Errorf("expected ../other, got %s", target)
+	}
+
+	infos, err := p.List(ctx, "dir")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(infos))
+	}
+	if linkTarget, ok := SymlinkTarget(infos[0]); !ok || linkTarget != "../other" {
+		t.Errorf("expected symlink entry with target ../other, got %q %v", linkTarget, ok)
+	}
+	if infos[0].IsDir() {
+		t.Error("symlink entry must not be reported as a directory")
+	}
+}
+
+func TestLocalProvider_MetadataErrorPolicy(t *testing.T) {
+	failing := MetadataApplierFunc(func(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+		return os.ErrPermission
+	})
+	info := NewUnixFileInfo(&localFileInfo{name: "f.txt"}, 0, 0, 0644)
+
+	for _, policy := range []MetadataErrorPolicy{MetadataErrorsIgnore, MetadataErrorsWarn, MetadataErrorsFail} {
+		t.Run(string(policy), func(t *testing.T) {
+			tempBase := t.TempDir()
+			p := NewLocalProvider(tempBase).WithMetadataApplier(failing).WithMetadataErrorPolicy(policy)
+
+			wc, err := p.OpenWrite(context.Background(), "f.txt", info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wc.Write([]byte("data"))
+			err = wc.Close()
+
+			if policy == MetadataErrorsIgnore {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var metaErr *MetadataError
+			if !errors.As(err, &metaErr) {
+				t.Fatalf("Expected a MetadataError, got %v", err)
+			}
+			if metaErr.Fatal() != (policy == MetadataErrorsFail) {
+				t.Errorf("Unexpected Fatal() = %v for %s", metaErr.Fatal(), policy)
+			}
+			if !errors.Is(err, os.ErrPermission) {
+				t.Errorf("Expected the applier error to be wrapped, got %v", err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(tempBase, "f.txt")); string(data) != "data" {
+				t.Errorf("Expected content to be written, got %q", data)
+			}
+		})
+	}
+
+	if _, err := ParseMetadataErrorPolicy("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestLocalProvider_CopyFrom(t *testing.T) {
+	if !cloneSupported {
+		t.Skip("kernel copies are not supported on this platform")
+	}
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	content := []byte("copied by the kernel")
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(srcDir, "a.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	src := NewLocalProvider(srcDir)
+	dst := NewLocalProvider(dstDir)
+	info, err := src.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dst.CopyFrom(ctx, WithMetrics(src, NewMetrics()), "a.txt", "sub/b.txt", info); err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			t.Skip("filesystem does not support kernel copies")
+		}
+		t.Fatalf("CopyFrom failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("copied content = %q; want %q", got, content)
+	}
+	copied, err := dst.Stat(ctx, "sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !copied.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v; want %v", copied.ModTime(), mtime)
+	}
+}
+
+func TestLocalProvider_Fsync(t *testing.T) {
+	dir := t.TempDir()
+	p := NewLocalProvider(dir).WithFsync(true)
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	w, err := p.OpenWrite(context.Background(), "sub/durable.txt", &localFileInfo{name: "durable.txt", modTime: mtime})
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	if _, err := w.Write([]byte("persisted")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close with fsync failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "sub", "durable.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 9 || !info.ModTime().Equal(mtime) {
+		t.Errorf("unexpected file after fsync: size %d, mtime %v", info.Size(), info.ModTime())
+	}
+}
+
+func TestLocalProvider_OverwriteReadOnly(t *testing.T) {
+	tempBase := t.TempDir()
+	ctx := context.Background()
+	p := NewLocalProvider(tempBase).WithMetadataMapper(NewMetadataMapper())
+	info := NewUnixFileInfo(&localFileInfo{name: "ro.txt", size: 3, modTime: time.Now()}, uint32(os.Getuid()), uint32(os.Getgid()), 0o444)
+
+	for _, content := range []string{"one", "two"} {
+		w, err := p.OpenWrite(ctx, "ro.txt", info)
+		if err != nil {
+			t.Fatalf("Expected %q to replace the read-only file: %v", content, err)
+		}
+		w.Write([]byte(content))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempBase, "ro.txt"))
+	if err != nil || string(data) != "two" {
+		t.Errorf("Expected the second write to land, got %q, %v", data, err)
+	}
+	stat, _ := os.Stat(filepath.Join(tempBase, "ro.txt"))
+	if runtime.GOOS != "windows" && stat.Mode().Perm() != 0o444 {
+		t.Errorf("Expected the read-only mode to be restored, got %v", stat.Mode().Perm())
+	}
+
+	if err := makeWritable(filepath.Join(tempBase, "ro.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := os.Stat(filepath.Join(tempBase, "ro.txt")); stat.Mode().Perm()&0o200 == 0 {
+		t.Error("Expected makeWritable to add owner write permission")
+	}
+}
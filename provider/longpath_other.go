@@ -0,0 +1,9 @@
+//go:build !windows
+
+package provider
+
+// longPath returns path unchanged: only Windows limits path lengths below
+// what its filesystems support.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package provider
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is the length from which Win32 calls fail without the
+// extended-length prefix: MAX_PATH less room for an 8.3 file name, the
+// limit CreateDirectory applies.
+const maxShortPath = 248
+
+// longPath returns path in the extended-length \\?\ form when it is too
+// long for the Win32 API. The os package does this for absolute paths, but
+// not for relative ones nor for the golang.org/x/sys/windows calls that
+// apply attributes, owners and times, so every resolved path gets it.
+func longPath(path string) string {
+	if len(path) < maxShortPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// \\server\share\... becomes \\?\UNC\server\share\...
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
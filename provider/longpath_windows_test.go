@@ -0,0 +1,56 @@
+//go:build windows
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	if got := longPath(`C:\short`); got != `C:\short` {
+		t.Errorf("Expected short paths unchanged, got %s", got)
+	}
+	long := `C:\` + strings.Repeat(`d\`, 150) + "f"
+	if got := longPath(long); got != `\\?\`+long {
+		t.Errorf("Expected the extended-length prefix, got %s", got)
+	}
+	unc := `\\server\share\` + strings.Repeat(`d\`, 150) + "f"
+	if got := longPath(unc); got != `\\?\UNC\server\share\`+strings.Repeat(`d\`, 150)+"f" {
+		t.Errorf("Expected the UNC extended-length prefix, got %s", got)
+	}
+}
+
+func TestLocalProvider_LongPaths(t *testing.T) {
+	tempBase := t.TempDir()
+	rel := filepath.Join(strings.Repeat(strings.Repeat("x", 50)+`\`, 6), "file.txt")
+	p := NewLocalProvider(tempBase)
+
+	info := NewWindowsFileInfo(&localFileInfo{name: "file.txt", size: 1}, FileAttributeHidden|FileAttributeReadonly, "")
+	w, err := p.OpenWrite(t.Context(), rel, info)
+	if err != nil {
+		t.Fatalf("OpenWrite of a %d character path failed: %v", len(filepath.Join(tempBase, rel)), err)
+	}
+	w.Write([]byte("x"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.Stat(t.Context(), rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs := got.(WindowsFileInfo).Attributes(); attrs&FileAttributeHidden == 0 || attrs&FileAttributeReadonly == 0 {
+		t.Errorf("Expected hidden and read-only attributes, got %#x", attrs)
+	}
+
+	// A rerun replaces the read-only file
+	w, err = p.OpenWrite(t.Context(), rel, info)
+	if err != nil {
+		t.Fatalf("Expected a read-only destination to be replaced: %v", err)
+	}
+	w.Close()
+	os.Chmod(filepath.Join(tempBase, rel), 0644)
+}
@@ -0,0 +1,20 @@
+package provider
+
+// MemoryEstimator is implemented by providers that buffer data of their own
+// while streaming a file, beyond what the caller reads or writes at a time,
+// such as the parts of a parallel download or a multipart upload.
+type MemoryEstimator interface {
+	// StreamMemory returns the most bytes the provider buffers at once
+	// while reading (write false) or writing (write true) a file of size
+	// bytes.
+	StreamMemory(size int64, write bool) int64
+}
+
+// StreamMemory returns the bytes p buffers while streaming a file of size
+// bytes, or 0 if it buffers nothing beyond the caller's own buffer.
+func StreamMemory(p Provider, size int64, write bool) int64 {
+	if m, ok := p.(MemoryEstimator); ok {
+		return m.StreamMemory(size, write)
+	}
+	return 0
+}
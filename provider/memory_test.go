@@ -0,0 +1,39 @@
+package provider
+
+import "testing"
+
+func TestStreamMemory(t *testing.T) {
+	p := &S3Provider{
+		uploadPartSize:      8 << 20,
+		uploadConcurrency:   4,
+		downloadPartSize:    16 << 20,
+		downloadConcurrency: 2,
+	}
+	tests := []struct {
+		name  string
+		size  int64
+		write bool
+		want  int64
+	}{
+		{"large upload", 1 << 30, true, 32 << 20},
+		{"small upload", 1 << 20, true, 1 << 20},
+		{"large download", 1 << 30, false, 32 << 20},
+		{"small download", 1 << 20, false, 1 << 20},
+		{"empty", 0, true, 0},
+	}
+	for _, tt := range tests {
+		if got := StreamMemory(p, tt.size, tt.write); got != tt.want {
+			t.Errorf("%s: StreamMemory() = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := StreamMemory(WithRetry(p, DefaultRetryPolicy), tt.size, tt.write); got != tt.want {
+			t.Errorf("%s: StreamMemory() through a wrapper = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := StreamMemory(&S3Provider{}, 1<<30, false); got != 0 {
+		t.Errorf("StreamMemory() of a single-stream download = %d, want 0", got)
+	}
+	if got := StreamMemory(NewLocalProvider(""), 1<<30, true); got != 0 {
+		t.Errorf("StreamMemory() of a local provider = %d, want 0", got)
+	}
+}
@@ -1,8 +1,9 @@
 package provider
 
 import (
+	"fmt"
 	"os"
-	"syscall"
+	"time"
 )
 
 // UnixFileInfo extends FileInfo with Unix-specific metadata
@@ -21,10 +22,18 @@ type unixFileInfo struct {
 	mode os.FileMode
 }
 
-func (u *unixFileInfo) UID() uint32  { return u.uid }
-func (u *unixFileInfo) GID() uint32  { return u.gid }
+func (u *unixFileInfo) UID() uint32       { return u.uid }
+func (u *unixFileInfo) GID() uint32       { return u.gid }
 func (u *unixFileInfo) Mode() os.FileMode { return u.mode }
 
+// WithModTime returns a copy of info with its modification time replaced by
+// t, keeping the Unix, Windows and symlink metadata it carries.
+func WithModTime(info FileInfo, t time.Time) FileInfo {
+	pi := persistInfo(info)
+	pi.ModTime = t
+	return pi.fileInfo()
+}
+
 // WrapOSFileInfo converts an os.FileInfo into a UnixFileInfo
 func WrapOSFileInfo(info os.FileInfo) UnixFileInfo {
 	baseInfo := &localFileInfo{
@@ -33,25 +42,23 @@ func WrapOSFileInfo(info os.FileInfo) UnixFileInfo {
 		isDir:   info.IsDir(),
 		modTime: info.ModTime(),
 	}
+	baseInfo.atime, baseInfo.btime = statTimes(info)
+	baseInfo.dev, baseInfo.hasDev = statDevice(info)
 
-	sysStat := info.Sys()
-	if sysStat == nil {
-		return baseInfo
-	}
-	
-	fileStat, ok := sysStat.(*syscall.Stat_t)
-	if !ok {
+	if info.Sys() == nil {
 		return baseInfo
 	}
-	
-	return &unixFileInfo{
-		FileInfo: baseInfo,
-		uid:      fileStat.Uid,
-		gid:      fileStat.Gid,
-		mode:     info.Mode().Perm(),
-	}
+	return wrapPlatformInfo(baseInfo, info)
 }
 
+// symlinkFileInfo marks a UnixFileInfo as a symbolic link to target.
+type symlinkFileInfo struct {
+	UnixFileInfo
+	target string
+}
+
+func (s *symlinkFileInfo) LinkTarget() string { return s.target }
+
 // NewUnixFileInfo creates a UnixFileInfo from raw values
 func NewUnixFileInfo(info FileInfo, uid, gid uint32, mode os.FileMode) UnixFileInfo {
 	return &unixFileInfo{
@@ -62,16 +69,66 @@ func NewUnixFileInfo(info FileInfo, uid, gid uint32, mode os.FileMode) UnixFileI
 	}
 }
 
+// FileAttributes holds Windows file attribute bits.
+type FileAttributes uint32
+
+// Windows file attributes preserved across transfers.
+const (
+	FileAttributeReadonly FileAttributes = 0x1
+	FileAttributeHidden   FileAttributes = 0x2
+	FileAttributeSystem   FileAttributes = 0x4
+	FileAttributeArchive  FileAttributes = 0x20
+
+	preservedAttributes = FileAttributeReadonly | FileAttributeHidden | FileAttributeSystem | FileAttributeArchive
+)
+
+// WindowsFileInfo extends FileInfo with Windows-specific metadata
+type WindowsFileInfo interface {
+	FileInfo
+	Attributes() FileAttributes
+	// OwnerSID returns the owner's security identifier in string form
+	// (e.g. "S-1-5-21-..."), or "" if unknown.
+	OwnerSID() string
+}
+
+// windowsFileInfo wraps FileInfo to provide Windows-specific metadata. It
+// embeds a UnixFileInfo so that Windows sources still carry a mode.
+type windowsFileInfo struct {
+	UnixFileInfo
+	attrs    FileAttributes
+	ownerSID string
+}
+
+func (w *windowsFileInfo) Attributes() FileAttributes { return w.attrs }
+func (w *windowsFileInfo) OwnerSID() string           { return w.ownerSID }
+
+// NewWindowsFileInfo creates a WindowsFileInfo from raw values
+func NewWindowsFileInfo(info FileInfo, attrs FileAttributes, ownerSID string) WindowsFileInfo {
+	unixInfo, ok := info.(UnixFileInfo)
+	if !ok {
+		unixInfo = NewUnixFileInfo(info, 0, 0, 0)
+	}
+	return &windowsFileInfo{
+		UnixFileInfo: unixInfo,
+		attrs:        attrs,
+		ownerSID:     ownerSID,
+	}
+}
+
 // UIDMapping maps source UIDs to destination UIDs
 type UIDMapping map[uint32]uint32
 
 // GIDMapping maps source GIDs to destination GIDs
 type GIDMapping map[uint32]uint32
 
+// SIDMapping maps source owner SIDs to destination owner SIDs
+type SIDMapping map[string]string
+
 // MetadataMapper handles translation of file metadata between source and destination
 type MetadataMapper struct {
 	uidMapping UIDMapping
 	gidMapping GIDMapping
+	sidMapping SIDMapping
 	// If true, preserve source UID/GID when no mapping exists
 	// If false, use destination default (typically the running user)
 	preserveUnmapped bool
@@ -94,6 +151,13 @@ func WithGIDMapping(mapping GIDMapping) MetadataMapperOption {
 	}
 }
 
+// WithSIDMapping sets the Windows owner SID mapping table
+func WithSIDMapping(mapping SIDMapping) MetadataMapperOption {
+	return func(m *MetadataMapper) {
+		m.sidMapping = mapping
+	}
+}
+
 // WithPreserveUnmapped controls whether unmapped UIDs/GIDs are preserved
 func WithPreserveUnmapped(preserve bool) MetadataMapperOption {
 	return func(m *MetadataMapper) {
@@ -106,6 +170,7 @@ func NewMetadataMapper(opts ...MetadataMapperOption) *MetadataMapper {
 	m := &MetadataMapper{
 		uidMapping:       make(UIDMapping),
 		gidMapping:       make(GIDMapping),
+		sidMapping:       make(SIDMapping),
 		preserveUnmapped: true,
 	}
 	for _, opt := range opts {
@@ -136,25 +201,103 @@ func (m *MetadataMapper) MapGID(gid uint32) (uint32, bool) {
 	return 0, false
 }
 
+// MapSID returns the destination owner SID for a source owner SID
+func (m *MetadataMapper) MapSID(sid string) (string, bool) {
+	if sid == "" {
+		return "", false
+	}
+	if mapped, ok := m.sidMapping[sid]; ok {
+		return mapped, true
+	}
+	if m.preserveUnmapped {
+		return sid, true
+	}
+	return "", false
+}
+
+// MetadataErrorPolicy decides what happens when a file's content was
+// written but its metadata could not be applied.
+type MetadataErrorPolicy string
+
+const (
+	// MetadataErrorsIgnore drops metadata errors silently.
+	MetadataErrorsIgnore MetadataErrorPolicy = "ignore"
+	// MetadataErrorsWarn reports metadata errors as non-fatal MetadataErrors.
+	MetadataErrorsWarn MetadataErrorPolicy = "warn"
+	// MetadataErrorsFail reports metadata errors as fatal MetadataErrors.
+	MetadataErrorsFail MetadataErrorPolicy = "fail"
+)
+
+// ParseMetadataErrorPolicy parses "ignore", "warn" or "fail".
+func ParseMetadataErrorPolicy(s string) (MetadataErrorPolicy, error) {
+	switch policy := MetadataErrorPolicy(s); policy {
+	case MetadataErrorsIgnore, MetadataErrorsWarn, MetadataErrorsFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown metadata error policy %q (want ignore, warn or fail)", s)
+}
+
+// MetadataError is returned when closing a written file succeeded but
+// applying its metadata did not. The content is intact; Fatal reports
+// whether the policy treats the file as failed anyway.
+type MetadataError struct {
+	Path   string
+	Err    error
+	Policy MetadataErrorPolicy
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("failed to apply metadata to %s: %v", e.Path, e.Err)
+}
+
+func (e *MetadataError) Unwrap() error { return e.Err }
+
+// Fatal reports whether the file should be treated as failed.
+func (e *MetadataError) Fatal() bool { return e.Policy == MetadataErrorsFail }
+
+// MetadataApplier applies captured source metadata to a file that has been
+// written at the destination. Every platform provides a default, returned by
+// DefaultMetadataApplier; LocalProvider.WithMetadataApplier replaces it, for
+// example to apply ACLs as well or to only record what would change.
+type MetadataApplier interface {
+	ApplyMetadata(path string, fileInfo FileInfo, mapper *MetadataMapper) error
+}
+
+// MetadataApplierFunc adapts a function to the MetadataApplier interface.
+type MetadataApplierFunc func(path string, fileInfo FileInfo, mapper *MetadataMapper) error
+
+// ApplyMetadata calls f(path, fileInfo, mapper).
+func (f MetadataApplierFunc) ApplyMetadata(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+	return f(path, fileInfo, mapper)
+}
+
+// DefaultMetadataApplier returns the applier for the platform gofast was
+// built for.
+func DefaultMetadataApplier() MetadataApplier {
+	return platformApplier
+}
+
 // ApplyMetadata applies file metadata (permissions, ownership) to a file
+// using the platform's default applier. Metadata captured on another
+// platform is applied as far as it has an equivalent here.
 func ApplyMetadata(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
-	unixInfo, ok := fileInfo.(UnixFileInfo)
-	if !ok {
-		// No Unix metadata to apply
-		return nil
-	}
+	return platformApplier.ApplyMetadata(path, fileInfo, mapper)
+}
 
+// applyUnixMetadata applies the permissions of info to path and, if chown is
+// set and the mapper allows it, its ownership.
+func applyUnixMetadata(path string, info UnixFileInfo, mapper *MetadataMapper, chown bool) error {
 	// Apply permissions
-	if unixInfo.Mode() != 0 {
-		if err := os.Chmod(path, unixInfo.Mode()); err != nil {
+	if info.Mode() != 0 {
+		if err := os.Chmod(path, info.Mode()); err != nil {
 			return err
 		}
 	}
 
 	// Apply ownership if mapper is provided
-	if mapper != nil {
-		uid, uidOK := mapper.MapUID(unixInfo.UID())
-		gid, gidOK := mapper.MapGID(unixInfo.GID())
+	if chown && mapper != nil {
+		uid, uidOK := mapper.MapUID(info.UID())
+		gid, gidOK := mapper.MapGID(info.GID())
 		if uidOK && gidOK {
 			if err := os.Chown(path, int(uid), int(gid)); err != nil {
 				return err
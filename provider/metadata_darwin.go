@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// wrapPlatformInfo extracts ownership from the stat_t behind info.
+func wrapPlatformInfo(baseInfo *localFileInfo, info os.FileInfo) UnixFileInfo {
+	fileStat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return baseInfo
+	}
+
+	return &unixFileInfo{
+		FileInfo: baseInfo,
+		uid:      fileStat.Uid,
+		gid:      fileStat.Gid,
+		mode:     info.Mode().Perm(),
+	}
+}
+
+// withOwnerSID is a no-op outside Windows.
+func withOwnerSID(path string, info UnixFileInfo) UnixFileInfo {
+	return info
+}
+
+var platformApplier MetadataApplier = darwinApplier{}
+
+// darwinApplier applies permissions and mapped ownership like other Unix
+// platforms, and carries the Windows hidden attribute over to the Finder's
+// UF_HIDDEN flag.
+type darwinApplier struct{}
+
+func (darwinApplier) ApplyMetadata(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+	unixInfo, ok := fileInfo.(UnixFileInfo)
+	if !ok {
+		// No Unix metadata to apply
+		return nil
+	}
+
+	winInfo, fromWindows := fileInfo.(WindowsFileInfo)
+	if err := applyUnixMetadata(path, unixInfo, mapper, !fromWindows); err != nil {
+		return err
+	}
+	if fromWindows && winInfo.Attributes()&FileAttributeHidden != 0 {
+		var st unix.Stat_t
+		if err := unix.Stat(path, &st); err != nil {
+			return err
+		}
+		return unix.Chflags(path, int(st.Flags|unix.UF_HIDDEN))
+	}
+	return nil
+}
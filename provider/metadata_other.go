@@ -0,0 +1,27 @@
+//go:build !unix && !windows
+
+package provider
+
+import "os"
+
+// wrapPlatformInfo returns baseInfo unchanged: this platform exposes no
+// ownership data.
+func wrapPlatformInfo(baseInfo *localFileInfo, info os.FileInfo) UnixFileInfo {
+	return baseInfo
+}
+
+// withOwnerSID is a no-op outside Windows.
+func withOwnerSID(path string, info UnixFileInfo) UnixFileInfo {
+	return info
+}
+
+var platformApplier MetadataApplier = MetadataApplierFunc(applyPermissions)
+
+// applyPermissions applies only the permission bits; this platform has no
+// notion of file ownership.
+func applyPermissions(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+	if unixInfo, ok := fileInfo.(UnixFileInfo); ok {
+		return applyUnixMetadata(path, unixInfo, mapper, false)
+	}
+	return nil
+}
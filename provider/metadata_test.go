@@ -3,6 +3,7 @@ package provider
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -123,3 +124,85 @@ func TestUnixFileInfo_Wrapper(t *testing.T) {
 		t.Errorf("expected mode 0666, got %v", ui.Mode())
 	}
 }
+
+func TestMetadataMapper_MapSID(t *testing.T) {
+	mapper := NewMetadataMapper(
+		WithSIDMapping(SIDMapping{"S-1-5-21-1-1001": "S-1-5-21-2-2001"}),
+		WithPreserveUnmapped(false),
+	)
+
+	if sid, ok := mapper.MapSID("S-1-5-21-1-1001"); !ok || sid != "S-1-5-21-2-2001" {
+		t.Errorf("expected mapped SID, got %q %v", sid, ok)
+	}
+	if _, ok := mapper.MapSID("S-1-5-21-1-1002"); ok {
+		t.Error("expected unmapped SID to be rejected")
+	}
+	if _, ok := NewMetadataMapper().MapSID(""); ok {
+		t.Error("expected empty SID to never map")
+	}
+}
+
+func TestNewWindowsFileInfo(t *testing.T) {
+	base := &dummyUnixFileInfo{name: "a.txt", size: 3}
+	info := NewWindowsFileInfo(base, FileAttributeHidden|FileAttributeReadonly, "S-1-5-18")
+
+	if info.Attributes()&FileAttributeHidden == 0 {
+		t.Error("expected hidden attribute")
+	}
+	if info.OwnerSID() != "S-1-5-18" {
+		t.Errorf("expected owner SID S-1-5-18, got %s", info.OwnerSID())
+	}
+	if _, ok := info.(UnixFileInfo); !ok {
+		t.Error("expected WindowsFileInfo to also satisfy UnixFileInfo")
+	}
+	if info.Name() != "a.txt" || info.Size() != 3 {
+		t.Errorf("expected base info to be preserved, got %s/%d", info.Name(), info.Size())
+	}
+}
+
+func TestLocalProvider_WithMetadataApplier(t *testing.T) {
+	tempBase := t.TempDir()
+	var applied []string
+	p := NewLocalProvider(tempBase).WithMetadataApplier(MetadataApplierFunc(
+		func(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+			applied = append(applied, filepath.Base(path))
+			return nil
+		}))
+
+	info := NewUnixFileInfo(&dummyUnixFileInfo{name: "f.txt"}, 0, 0, 0600)
+	wc, err := p.OpenWrite(t.Context(), "f.txt", info)
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if len(applied) != 1 || applied[0] != "f.txt" {
+		t.Errorf("Expected the custom applier to run once for f.txt, got %v", applied)
+	}
+}
+
+func TestApplyMetadata_WindowsSourceKeepsOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows sources are restored through attributes on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file captured on Windows has no UID/GID; applying it must not
+	// fail trying to chown to root, and must still apply the mode.
+	info := NewWindowsFileInfo(NewUnixFileInfo(&dummyUnixFileInfo{name: "f.txt"}, 0, 0, 0400), 0, "")
+	if err := ApplyMetadata(path, info, NewMetadataMapper()); err != nil {
+		t.Fatalf("ApplyMetadata failed: %v", err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm()&0200 != 0 {
+		t.Errorf("Expected the file to be read-only, got %v", st.Mode())
+	}
+}
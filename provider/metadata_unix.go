@@ -0,0 +1,45 @@
+//go:build unix && !darwin
+
+package provider
+
+import (
+	"os"
+	"syscall"
+)
+
+// wrapPlatformInfo extracts ownership from the stat_t behind info.
+func wrapPlatformInfo(baseInfo *localFileInfo, info os.FileInfo) UnixFileInfo {
+	fileStat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return baseInfo
+	}
+
+	return &unixFileInfo{
+		FileInfo: baseInfo,
+		uid:      fileStat.Uid,
+		gid:      fileStat.Gid,
+		mode:     info.Mode().Perm(),
+	}
+}
+
+// withOwnerSID is a no-op outside Windows.
+func withOwnerSID(path string, info UnixFileInfo) UnixFileInfo {
+	return info
+}
+
+var platformApplier MetadataApplier = unixApplier{}
+
+// unixApplier applies permissions and mapped ownership. Windows attributes
+// and SIDs have no equivalent here, so files captured on Windows only get
+// their permissions: their zero UID/GID would otherwise chown them to root.
+type unixApplier struct{}
+
+func (unixApplier) ApplyMetadata(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+	unixInfo, ok := fileInfo.(UnixFileInfo)
+	if !ok {
+		// No Unix metadata to apply
+		return nil
+	}
+	_, fromWindows := fileInfo.(WindowsFileInfo)
+	return applyUnixMetadata(path, unixInfo, mapper, !fromWindows)
+}
@@ -0,0 +1,89 @@
+//go:build windows
+
+package provider
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// wrapPlatformInfo extracts the file attributes behind info. The owner SID
+// needs a path and is added by withOwnerSID.
+func wrapPlatformInfo(baseInfo *localFileInfo, info os.FileInfo) UnixFileInfo {
+	attrData, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return baseInfo
+	}
+
+	return &windowsFileInfo{
+		UnixFileInfo: NewUnixFileInfo(baseInfo, 0, 0, info.Mode().Perm()),
+		attrs:        FileAttributes(attrData.FileAttributes) & preservedAttributes,
+	}
+}
+
+// withOwnerSID looks up the owner of path and records it on info.
+// Lookup failures leave the owner unknown rather than failing the listing.
+func withOwnerSID(path string, info UnixFileInfo) UnixFileInfo {
+	winInfo, ok := info.(*windowsFileInfo)
+	if !ok {
+		return info
+	}
+
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return info
+	}
+	owner, _, err := sd.Owner()
+	if err != nil || owner == nil {
+		return info
+	}
+	winInfo.ownerSID = owner.String()
+	return winInfo
+}
+
+var platformApplier MetadataApplier = windowsApplier{}
+
+// windowsApplier restores attributes and owner SIDs captured on Windows.
+// Files captured on Unix only get their permissions, which Windows maps to
+// the read-only attribute; Unix ownership has no meaning here.
+type windowsApplier struct{}
+
+func (windowsApplier) ApplyMetadata(path string, fileInfo FileInfo, mapper *MetadataMapper) error {
+	if winInfo, ok := fileInfo.(WindowsFileInfo); ok {
+		return applyWindowsMetadata(path, winInfo, mapper)
+	}
+	if unixInfo, ok := fileInfo.(UnixFileInfo); ok {
+		return applyUnixMetadata(path, unixInfo, mapper, false)
+	}
+	return nil
+}
+
+// applyWindowsMetadata sets the preserved attributes and, if the mapper
+// allows it, the owner SID on path.
+func applyWindowsMetadata(path string, info WindowsFileInfo, mapper *MetadataMapper) error {
+	if mapper != nil {
+		if sid, ok := mapper.MapSID(info.OwnerSID()); ok {
+			owner, err := windows.StringToSid(sid)
+			if err != nil {
+				return err
+			}
+			if err := windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+				windows.OWNER_SECURITY_INFORMATION, owner, nil, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	current, err := windows.GetFileAttributes(pathPtr)
+	if err != nil {
+		return err
+	}
+	attrs := (FileAttributes(current) &^ preservedAttributes) | (info.Attributes() & preservedAttributes)
+	return windows.SetFileAttributes(pathPtr, uint32(attrs))
+}
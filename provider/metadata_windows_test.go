@@ -0,0 +1,54 @@
+//go:build windows
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestWindowsAttributesRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src.txt")
+	dst := filepath.Join(tmpDir, "dst.txt")
+	for _, p := range []string{src, dst} {
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srcPtr, _ := windows.UTF16PtrFromString(src)
+	if err := windows.SetFileAttributes(srcPtr, windows.FILE_ATTRIBUTE_HIDDEN); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := NewLocalProvider("").Stat(t.Context(), src)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	winInfo, ok := info.(WindowsFileInfo)
+	if !ok {
+		t.Fatal("expected WindowsFileInfo from Stat")
+	}
+	if winInfo.Attributes()&FileAttributeHidden == 0 {
+		t.Error("expected hidden attribute to be captured")
+	}
+	if winInfo.OwnerSID() == "" {
+		t.Error("expected owner SID to be captured")
+	}
+
+	if err := ApplyMetadata(dst, winInfo, NewMetadataMapper()); err != nil {
+		t.Fatalf("ApplyMetadata failed: %v", err)
+	}
+	dstPtr, _ := windows.UTF16PtrFromString(dst)
+	attrs, err := windows.GetFileAttributes(dstPtr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs&windows.FILE_ATTRIBUTE_HIDDEN == 0 {
+		t.Error("expected hidden attribute to be applied")
+	}
+}
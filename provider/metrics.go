@@ -0,0 +1,332 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Operation identifies a provider operation tracked by Metrics.
+type Operation int
+
+const (
+	OpStat Operation = iota
+	OpList
+	OpOpenRead
+	OpOpenWrite
+	// OpRead and OpWrite cover the Read and Write calls on opened streams;
+	// their latency is the time spent waiting on the backend.
+	OpRead
+	OpWrite
+
+	numOperations
+)
+
+var operationNames = [numOperations]string{"stat", "list", "open-read", "open-write", "read", "write"}
+
+func (op Operation) String() string {
+	if op < 0 || op >= numOperations {
+		return fmt.Sprintf("op(%d)", int(op))
+	}
+	return operationNames[op]
+}
+
+// OpStats summarises one operation.
+type OpStats struct {
+	Count      int64
+	Errors     int64
+	Bytes      int64
+	Latency    time.Duration // total across all calls
+	MaxLatency time.Duration
+}
+
+// AvgLatency returns the mean latency per call.
+func (s OpStats) AvgLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Count)
+}
+
+// ErrorRate returns the fraction of calls that failed.
+func (s OpStats) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Count)
+}
+
+type opCounter struct {
+	count, errors, bytes, nanos, maxNanos atomic.Int64
+}
+
+func (c *opCounter) record(d time.Duration, n int64, err error) {
+	c.count.Add(1)
+	if err != nil && err != io.EOF {
+		c.errors.Add(1)
+	}
+	c.bytes.Add(n)
+	c.nanos.Add(int64(d))
+	for {
+		max := c.maxNanos.Load()
+		if int64(d) <= max || c.maxNanos.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+// Metrics accumulates per-operation statistics for a provider. It is safe
+// for concurrent use.
+type Metrics struct {
+	ops [numOperations]opCounter
+}
+
+// NewMetrics creates an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Record adds one call of op that took d, moved n bytes and returned err.
+func (m *Metrics) Record(op Operation, d time.Duration, n int64, err error) {
+	m.ops[op].record(d, n, err)
+}
+
+// Op returns the statistics for op.
+func (m *Metrics) Op(op Operation) OpStats {
+	c := &m.ops[op]
+	return OpStats{
+		Count:      c.count.Load(),
+		Errors:     c.errors.Load(),
+		Bytes:      c.bytes.Load(),
+		Latency:    time.Duration(c.nanos.Load()),
+		MaxLatency: time.Duration(c.maxNanos.Load()),
+	}
+}
+
+// String formats the operations that were used, one per line.
+func (m *Metrics) String() string {
+	var b strings.Builder
+	for op := Operation(0); op < numOperations; op++ {
+		s := m.Op(op)
+		if s.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%-10s calls=%d errors=%d (%.1f%%) avg=%v max=%v",
+			op, s.Count, s.Errors, 100*s.ErrorRate(), s.AvgLatency().Round(time.Microsecond), s.MaxLatency.Round(time.Microsecond))
+		if op == OpRead || op == OpWrite {
+			fmt.Fprintf(&b, " bytes=%d busy=%v", s.Bytes, s.Latency.Round(time.Millisecond))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// metricsProvider records the operations of the wrapped provider.
+type metricsProvider struct {
+	Wrapper
+	metrics *Metrics
+}
+
+// WithMetrics wraps p so that every Stat, List, Open and stream Read/Write
+// is recorded in m. Comparing the metrics of the source and destination
+// shows which side is the bottleneck.
+func WithMetrics(p Provider, m *Metrics) Provider {
+	if m == nil {
+		return p
+	}
+	return &metricsProvider{Wrapper: Wrapper{p}, metrics: m}
+}
+
+func (mp *metricsProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	start := time.Now()
+	info, err := mp.Provider.Stat(ctx, path)
+	mp.metrics.Record(OpStat, time.Since(start), 0, err)
+	return info, err
+}
+
+func (mp *metricsProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	start := time.Now()
+	entries, err := mp.Provider.List(ctx, path)
+	mp.metrics.Record(OpList, time.Since(start), 0, err)
+	return entries, err
+}
+
+// ListAll records a flat scan as one list operation. Its latency leaves out
+// the time spent in fn.
+func (mp *metricsProvider) ListAll(ctx context.Context, path string, fn func(rel string, info FileInfo) error) error {
+	start := time.Now()
+	var inFn time.Duration
+	err := mp.Wrapper.ListAll(ctx, path, func(rel string, info FileInfo) error {
+		t := time.Now()
+		defer func() { inFn += time.Since(t) }()
+		return fn(rel, info)
+	})
+	mp.metrics.Record(OpList, time.Since(start)-inFn, 0, err)
+	return err
+}
+
+// ListVersions records a version scan as one list operation, leaving out
+// the time spent in fn.
+func (mp *metricsProvider) ListVersions(ctx context.Context, path string, fn func(rel string, versions []ObjectVersion) error) error {
+	start := time.Now()
+	var inFn time.Duration
+	err := mp.Wrapper.ListVersions(ctx, path, func(rel string, versions []ObjectVersion) error {
+		t := time.Now()
+		defer func() { inFn += time.Since(t) }()
+		return fn(rel, versions)
+	})
+	mp.metrics.Record(OpList, time.Since(start)-inFn, 0, err)
+	return err
+}
+
+func (mp *metricsProvider) OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := mp.Wrapper.OpenReadVersion(ctx, path, versionID)
+	mp.metrics.Record(OpOpenRead, time.Since(start), 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{ReadCloser: rc, metrics: mp.metrics}, nil
+}
+
+func (mp *metricsProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := mp.Provider.OpenRead(ctx, path)
+	mp.metrics.Record(OpOpenRead, time.Since(start), 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{ReadCloser: rc, metrics: mp.metrics}, nil
+}
+
+func (mp *metricsProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if !CapabilitiesOf(mp.Provider).RangedRead {
+		// The fallback reads through OpenRead, which is already recorded.
+		return OpenReadRange(ctx, mp, path, offset, length)
+	}
+	start := time.Now()
+	rc, err := mp.Wrapper.OpenReadRange(ctx, path, offset, length)
+	mp.metrics.Record(OpOpenRead, time.Since(start), 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{ReadCloser: rc, metrics: mp.metrics}, nil
+}
+
+func (mp *metricsProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	start := time.Now()
+	wc, err := mp.Provider.OpenWrite(ctx, path, metadata)
+	mp.metrics.Record(OpOpenWrite, time.Since(start), 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredWriter{WriteCloser: wc, metrics: mp.metrics}, nil
+}
+
+func (mp *metricsProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	start := time.Now()
+	wc, offset, err := mp.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+	mp.metrics.Record(OpOpenWrite, time.Since(start), 0, err)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &meteredWriter{WriteCloser: wc, metrics: mp.metrics}, offset, nil
+}
+
+func (mp *metricsProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	start := time.Now()
+	wc, offset, err := mp.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+	mp.metrics.Record(OpOpenWrite, time.Since(start), 0, err)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &meteredWriter{WriteCloser: wc, metrics: mp.metrics}, offset, nil
+}
+
+func (mp *metricsProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	start := time.Now()
+	cf, err := mp.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+	mp.metrics.Record(OpOpenWrite, time.Since(start), 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredChunkedFile{ChunkedFile: cf, metrics: mp.metrics}, nil
+}
+
+type meteredReader struct {
+	io.ReadCloser
+	metrics *Metrics
+}
+
+// Unwrap returns the wrapped reader, for SourceFile.
+func (r *meteredReader) Unwrap() io.Reader { return r.ReadCloser }
+
+// Sent records bytes copied in the kernel as a read; the copy's time is
+// recorded on the write side.
+func (r *meteredReader) Sent(n int64) error {
+	r.metrics.Record(OpRead, 0, n, nil)
+	return nil
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.metrics.Record(OpRead, time.Since(start), int64(n), err)
+	return n, err
+}
+
+// meteredChunkedFile records each chunk as one write of its length.
+type meteredChunkedFile struct {
+	ChunkedFile
+	metrics *Metrics
+}
+
+func (f *meteredChunkedFile) WriteChunk(ctx context.Context, index int, r io.Reader) error {
+	cr := &countingReader{r: r}
+	start := time.Now()
+	err := f.ChunkedFile.WriteChunk(ctx, index, cr)
+	f.metrics.Record(OpWrite, time.Since(start), cr.n, err)
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type meteredWriter struct {
+	io.WriteCloser
+	metrics *Metrics
+}
+
+// Unwrap returns the wrapped writer, for Committed.
+func (w *meteredWriter) Unwrap() io.WriteCloser { return w.WriteCloser }
+
+// SendFile records a kernel copy as a write.
+func (w *meteredWriter) SendFile(ctx context.Context, src *os.File, n int64) (int64, error) {
+	start := time.Now()
+	m, err := SendFile(ctx, w.WriteCloser, src, n)
+	if errors.Is(err, ErrNotSupported) {
+		return 0, err
+	}
+	w.metrics.Record(OpWrite, time.Since(start), m, err)
+	return m, err
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.WriteCloser.Write(p)
+	w.metrics.Record(OpWrite, time.Since(start), int64(n), err)
+	return n, err
+}
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithMetrics(t *testing.T) {
+	tempBase := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempBase, "a.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMetrics()
+	p := WithMetrics(NewLocalProvider(tempBase), m)
+	ctx := context.Background()
+
+	if _, err := p.Stat(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Stat(ctx, "missing.txt"); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+
+	rc, err := p.OpenRead(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(rc)
+	rc.Close()
+
+	wc, err := p.OpenWrite(ctx, "b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.Write([]byte("12345"))
+	wc.Close()
+
+	if s := m.Op(OpStat); s.Count != 2 || s.Errors != 1 || s.ErrorRate() != 0.5 {
+		t.Errorf("Unexpected stat stats: %+v", s)
+	}
+	if s := m.Op(OpRead); s.Bytes != 11 || s.Errors != 0 {
+		t.Errorf("Expected 11 bytes read without errors (EOF is not an error), got %+v", s)
+	}
+	if s := m.Op(OpWrite); s.Bytes != 5 || s.Count != 1 {
+		t.Errorf("Expected one 5-byte write, got %+v", s)
+	}
+	if s := m.Op(OpOpenRead); s.Count != 1 {
+		t.Errorf("Expected one open-read, got %+v", s)
+	}
+
+	out := m.String()
+	if !strings.Contains(out, "stat") || strings.Contains(out, "list") {
+		t.Errorf("Expected only used operations in summary, got:\n%s", out)
+	}
+}
+
+func TestOperation_String(t *testing.T) {
+	if OpOpenWrite.String() != "open-write" {
+		t.Errorf("Unexpected name %q", OpOpenWrite.String())
+	}
+	if Operation(42).String() != "op(42)" {
+		t.Errorf("Unexpected name %q", Operation(42).String())
+	}
+}
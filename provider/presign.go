@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PresignedRequest is an HTTP request signed in advance, granting whoever
+// holds it one operation on one object until it expires, without the
+// credentials that signed it. Header holds the headers that were signed and
+// must be sent unchanged, such as user metadata for a PUT.
+type PresignedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header,omitempty"`
+	Expires time.Time   `json:"expires"`
+}
+
+// Presigner is implemented by providers that can sign requests in advance.
+type Presigner interface {
+	// PresignGet signs a request reading the file at path.
+	PresignGet(ctx context.Context, path string, ttl time.Duration) (PresignedRequest, error)
+	// PresignPut signs a request writing the file at path with the
+	// metadata of info, which may be nil.
+	PresignPut(ctx context.Context, path string, info FileInfo, ttl time.Duration) (PresignedRequest, error)
+}
+
+// PresignedError is the error response to a presigned request. It exposes
+// the status and error code like the AWS SDK's errors, so IsTransient and
+// IsThrottled classify it the same way.
+type PresignedError struct {
+	Method string
+	Status int
+	Code   string
+}
+
+func (e *PresignedError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("presigned %s failed: %d %s", e.Method, e.Status, e.Code)
+	}
+	return fmt.Sprintf("presigned %s failed: %d %s", e.Method, e.Status, http.StatusText(e.Status))
+}
+
+func (e *PresignedError) HTTPStatusCode() int { return e.Status }
+func (e *PresignedError) ErrorCode() string   { return e.Code }
+
+// OpenPresigned sends the presigned GET req with client and returns the
+// response body.
+func OpenPresigned(ctx context.Context, client *http.Client, req PresignedRequest) (io.ReadCloser, error) {
+	resp, err := doPresigned(ctx, client, req, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PutPresigned sends the presigned PUT req with client, uploading size
+// bytes from body. Presigned uploads are single requests, so size must be
+// known in advance.
+func PutPresigned(ctx context.Context, client *http.Client, req PresignedRequest, body io.Reader, size int64) error {
+	resp, err := doPresigned(ctx, client, req, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func doPresigned(ctx context.Context, client *http.Client, req PresignedRequest, body io.Reader, size int64) (*http.Response, error) {
+	if !req.Expires.IsZero() && time.Now().After(req.Expires) {
+		return nil, fmt.Errorf("presigned %s expired at %s", req.Method, req.Expires.Format(time.RFC3339))
+	}
+	if size == 0 {
+		// Otherwise an empty body is sent chunked, which S3 refuses
+		body = nil
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presigned request: %w", err)
+	}
+	for k, vs := range req.Header {
+		// The transport sets Host and Content-Length itself
+		if k := http.CanonicalHeaderKey(k); k == "Host" || k == "Content-Length" {
+			continue
+		}
+		for _, v := range vs {
+			httpReq.Header.Add(k, v)
+		}
+	}
+	httpReq.ContentLength = size
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("presigned %s failed: %w", req.Method, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var body struct {
+			Code string `xml:"Code"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body)
+		return nil, &PresignedError{Method: req.Method, Status: resp.StatusCode, Code: body.Code}
+	}
+	return resp, nil
+}
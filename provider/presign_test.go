@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenPresigned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" {
+			t.Errorf("Expected a GET with the signed headers, got %s %v", r.Method, r.Header)
+		}
+		io.WriteString(w, "content")
+	}))
+	defer srv.Close()
+
+	req := PresignedRequest{
+		Method:  http.MethodGet,
+		URL:     srv.URL + "/bucket/key?X-Amz-Signature=sig",
+		Header:  http.Header{"X-Amz-Server-Side-Encryption-Customer-Algorithm": {"AES256"}, "Host": {"s3.test"}},
+		Expires: time.Now().Add(time.Hour),
+	}
+	rc, err := OpenPresigned(context.Background(), srv.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if b, _ := io.ReadAll(rc); string(b) != "content" {
+		t.Errorf("Expected the object's content, got %q", b)
+	}
+}
+
+func TestPutPresigned(t *testing.T) {
+	var got string
+	var length int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, length = string(b), r.ContentLength
+		if len(r.TransferEncoding) > 0 {
+			t.Errorf("Expected no transfer encoding, got %v", r.TransferEncoding)
+		}
+	}))
+	defer srv.Close()
+
+	req := PresignedRequest{Method: http.MethodPut, URL: srv.URL + "/bucket/key"}
+	if err := PutPresigned(context.Background(), srv.Client(), req, strings.NewReader("hello"), 5); err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" || length != 5 {
+		t.Errorf("Expected 5 bytes with a Content-Length, got %q (%d)", got, length)
+	}
+
+	if err := PutPresigned(context.Background(), srv.Client(), req, strings.NewReader(""), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got != "" || length != 0 {
+		t.Errorf("Expected an empty object, got %q (%d)", got, length)
+	}
+}
+
+func TestPresigned_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`)
+	}))
+	defer srv.Close()
+
+	_, err := OpenPresigned(context.Background(), srv.Client(), PresignedRequest{Method: http.MethodGet, URL: srv.URL})
+	pe, ok := err.(*PresignedError)
+	if !ok || pe.Status != 503 || pe.Code != "SlowDown" {
+		t.Fatalf("Expected a 503 SlowDown PresignedError, got %v", err)
+	}
+	if !IsTransient(err) || !IsThrottled(err) {
+		t.Error("Expected SlowDown to be transient and throttling")
+	}
+
+	expired := PresignedRequest{Method: http.MethodGet, URL: srv.URL, Expires: time.Now().Add(-time.Minute)}
+	if _, err := OpenPresigned(context.Background(), srv.Client(), expired); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected an expired request to fail without being sent, got %v", err)
+	}
+}
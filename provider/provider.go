@@ -2,8 +2,12 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"io"
+	"io/fs"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // FileInfo represents the standard metadata for a file or a directory
@@ -30,3 +34,115 @@ type Provider interface {
 	// OpenWrite opens a file for streaming writes, applying metadata if supported.
 	OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error)
 }
+
+// RangeReader is implemented by providers that can open a byte range of a
+// file without streaming it from the beginning. It is the building block for
+// chunked parallel transfers and for resuming partially copied files.
+type RangeReader interface {
+	// OpenReadRange opens length bytes of the file starting at offset.
+	// A negative length reads until the end of the file.
+	OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// OpenReadRange opens a byte range of path on p. Providers implementing
+// RangeReader serve the range natively; for all others the file is opened
+// from the start and the leading offset bytes are discarded.
+func OpenReadRange(ctx context.Context, p Provider, path string, offset, length int64) (io.ReadCloser, error) {
+	if CapabilitiesOf(p).RangedRead {
+		if rr, ok := p.(RangeReader); ok {
+			return rr.OpenReadRange(ctx, path, offset, length)
+		}
+	}
+
+	rc, err := p.OpenRead(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	return limitReadCloser(rc, length), nil
+}
+
+// limitReadCloser restricts rc to length bytes. A negative length returns rc
+// unchanged.
+func limitReadCloser(rc io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return rc
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(rc, length), Closer: rc}
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// IsNotExist reports whether err means the file or object is missing, on a
+// local filesystem or on S3.
+func IsNotExist(err error) bool {
+	var noKey *types.NoSuchKey
+	return errors.Is(err, fs.ErrNotExist) || errors.As(err, &noKey)
+}
+
+// Deleter is implemented by providers that can remove files.
+type Deleter interface {
+	// Delete removes the file at path.
+	Delete(ctx context.Context, path string) error
+}
+
+// DeletedEntry describes a soft-deleted file that can still be restored.
+type DeletedEntry struct {
+	// Path is relative to the provider root.
+	Path string
+	// VersionID identifies the tombstone (e.g. the S3 delete marker).
+	VersionID string
+	DeletedAt time.Time
+}
+
+// SoftDeleter is implemented by providers whose deletes can leave a
+// recoverable tombstone instead of destroying data, such as S3 buckets with
+// versioning enabled.
+type SoftDeleter interface {
+	Deleter
+
+	// SoftDeletes reports whether Delete currently leaves a tombstone.
+	SoftDeletes(ctx context.Context) (bool, error)
+
+	// ListDeleted returns the entries deleted at or after since that have
+	// not been restored.
+	ListDeleted(ctx context.Context, since time.Time) ([]DeletedEntry, error)
+
+	// Undelete restores a deleted entry by removing its tombstone.
+	Undelete(ctx context.Context, entry DeletedEntry) error
+}
+
+// SymlinkFileInfo extends FileInfo for entries that are symbolic links.
+// Providers only return it for links they did not follow.
+type SymlinkFileInfo interface {
+	FileInfo
+	// LinkTarget returns the target of the link exactly as stored, which may
+	// be relative to the directory containing the link.
+	LinkTarget() string
+}
+
+// SymlinkTarget reports whether info describes a symbolic link and, if so,
+// returns its target.
+func SymlinkTarget(info FileInfo) (string, bool) {
+	if link, ok := info.(SymlinkFileInfo); ok {
+		return link.LinkTarget(), true
+	}
+	return "", false
+}
+
+// Symlinker is implemented by providers that can read and create symbolic links.
+type Symlinker interface {
+	// Readlink returns the target of the link at path.
+	Readlink(ctx context.Context, path string) (string, error)
+	// Symlink creates a link at path pointing to target, replacing any
+	// existing file at path.
+	Symlink(ctx context.Context, target, path string) error
+}
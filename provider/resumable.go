@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// UploadCheckpoint identifies an unfinished multipart upload and the parts
+// of it already stored. Every part but the last is PartSize bytes, so the
+// parts hold the first len(Parts)*PartSize bytes of the file.
+type UploadCheckpoint struct {
+	UploadID string
+	PartSize int64
+	Parts    []UploadedPart
+}
+
+// UploadedPart is one stored part of a multipart upload.
+type UploadedPart struct {
+	Number int32
+	ETag   string
+	// Checksum is the base64 CRC32C the backend computed for the part,
+	// if any; it has to be repeated when the upload is completed.
+	Checksum string
+}
+
+// Offset returns the number of bytes of the file the stored parts hold.
+func (c UploadCheckpoint) Offset() int64 {
+	return int64(len(c.Parts)) * c.PartSize
+}
+
+// ResumableWriter is implemented by providers whose writes are stored in
+// parts that outlive the writer, such as S3 multipart uploads, so a write
+// interrupted by a crash can be continued rather than restarted.
+type ResumableWriter interface {
+	// OpenWriteResumable opens path for writing like OpenWrite. If resume
+	// is the checkpoint of an earlier attempt whose parts are still stored,
+	// they are kept and the returned offset is the number of bytes of the
+	// file they hold: the caller writes only the rest. onPart, if set, is
+	// called with the updated checkpoint whenever the upload is created or
+	// a part is stored. Closing a writer that received fewer bytes than
+	// metadata's size leaves the upload unfinished, to be resumed.
+	OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error)
+
+	// AbortUpload discards the stored parts of an unfinished upload.
+	AbortUpload(ctx context.Context, path, uploadID string) error
+}
+
+// OpenWriteResumable opens path on p for writing, continuing the upload in
+// resume where p implements ResumableWriter. Other providers open path with
+// OpenWrite and return offset 0.
+func OpenWriteResumable(ctx context.Context, p Provider, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	if CapabilitiesOf(p).ResumableWrite {
+		if rw, ok := p.(ResumableWriter); ok {
+			return rw.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+		}
+	}
+	w, err := p.OpenWrite(ctx, path, metadata)
+	return w, 0, err
+}
+
+// AppendWriter is implemented by providers whose files can be written from
+// an offset, keeping what is already there, such as local filesystems, so a
+// write interrupted by a crash can be continued where it stopped.
+type AppendWriter interface {
+	// OpenWriteAt opens path for writing like OpenWrite, but keeps up to
+	// the first offset bytes of what an earlier attempt wrote. It returns
+	// the offset from which the caller writes, which is less than offset
+	// if fewer bytes were kept.
+	OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error)
+}
+
+// OpenWriteAt opens path on p for writing from offset where p implements
+// AppendWriter. Other providers open path with OpenWrite, and the returned
+// offset is zero.
+func OpenWriteAt(ctx context.Context, p Provider, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	if CapabilitiesOf(p).AppendWrite {
+		if aw, ok := p.(AppendWriter); ok {
+			return aw.OpenWriteAt(ctx, path, metadata, offset)
+		}
+	}
+	w, err := p.OpenWrite(ctx, path, metadata)
+	return w, 0, err
+}
+
+// PendingUpload is an unfinished multipart upload, whose stored parts are
+// billed until it is completed or aborted.
+type PendingUpload struct {
+	// Path is relative to the path the uploads were listed under.
+	Path      string
+	UploadID  string
+	Initiated time.Time
+}
+
+// UploadLister is implemented by providers that can enumerate their
+// unfinished multipart uploads, such as the ones crashed runs leave behind.
+type UploadLister interface {
+	ResumableWriter
+	// ListUploads calls fn for each unfinished upload below path. It stops
+	// at the first error fn returns.
+	ListUploads(ctx context.Context, path string, fn func(PendingUpload) error) error
+}
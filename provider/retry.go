@@ -0,0 +1,340 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how WithRetry retries failed operations.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt; it doubles for
+	// each further attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// ThrottleDelay replaces BaseDelay when the backend throttled the
+	// request (see IsThrottled), so a busy bucket is given longer to
+	// recover. Zero means BaseDelay.
+	ThrottleDelay time.Duration
+	// Retryable decides whether an error is worth retrying. Nil means
+	// IsTransient.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy retries transient errors five times over roughly the
+// first few seconds of an outage.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   5,
+	BaseDelay:     100 * time.Millisecond,
+	MaxDelay:      10 * time.Second,
+	ThrottleDelay: time.Second,
+}
+
+// Backoff returns the delay before retry number attempt (starting at 1),
+// using exponential backoff with full jitter.
+func (rp RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := rp.BaseDelay
+	for i := 1; i < attempt && ceiling < rp.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if rp.MaxDelay > 0 && ceiling > rp.MaxDelay {
+		ceiling = rp.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// Do runs op until it succeeds, fails with a non-retryable error, runs out
+// of attempts, or ctx is done. It returns the last error.
+func (rp RetryPolicy) Do(ctx context.Context, op func() error) error {
+	retryable := rp.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || attempt >= rp.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(rp.delay(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the backoff before retry number attempt after err, starting
+// from ThrottleDelay if err is throttling.
+func (rp RetryPolicy) delay(attempt int, err error) time.Duration {
+	if rp.ThrottleDelay > 0 && IsThrottled(err) {
+		rp.BaseDelay = rp.ThrottleDelay
+	}
+	return rp.Backoff(attempt)
+}
+
+// IsTransient reports whether err is likely to succeed on retry: connection
+// resets and timeouts, HTTP 5xx responses, and throttling.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// AWS SDK errors expose the HTTP status and service error code.
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) {
+		code := status.HTTPStatusCode()
+		if code >= 500 || code == 429 {
+			return true
+		}
+	}
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestTimeout",
+			"RequestTimeTooSkewed", "InternalError", "ServiceUnavailable":
+			return true
+		}
+	}
+	return false
+}
+
+// IsThrottled reports whether err is the backend asking for fewer
+// requests: an S3 SlowDown, another throttling code, or an HTTP 503 or 429.
+func IsThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "TooManyRequestsException",
+			"RequestLimitExceeded", "ServiceUnavailable":
+			return true
+		}
+	}
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) {
+		code := status.HTTPStatusCode()
+		return code == 503 || code == 429
+	}
+	return false
+}
+
+// retryProvider retries the operations of the wrapped provider.
+type retryProvider struct {
+	Wrapper
+	policy RetryPolicy
+}
+
+// clientRetrier is implemented by providers whose client retries failed
+// requests of its own accord, such as S3.
+type clientRetrier interface {
+	withoutClientRetries()
+}
+
+// WithRetry wraps p so that Stat, List, Restore, batch deletes and the Open
+// calls are retried with exponential backoff and jitter when they fail with a
+// transient error. Errors surfacing later, while reading or writing an open stream, are not
+// retried here; the caller retries the whole transfer. A client beneath p
+// that retries requests itself is made to try each once, so that throttled
+// requests do not back off at two layers and the adaptive limits see every
+// throttling response.
+func WithRetry(p Provider, policy RetryPolicy) Provider {
+	if policy.MaxAttempts < 2 {
+		return p
+	}
+	if c, ok := Unwrap(p).(clientRetrier); ok {
+		c.withoutClientRetries()
+	}
+	return &retryProvider{Wrapper: Wrapper{p}, policy: policy}
+}
+
+func (r *retryProvider) Stat(ctx context.Context, path string) (info FileInfo, err error) {
+	err = r.policy.Do(ctx, func() error {
+		info, err = r.Provider.Stat(ctx, path)
+		return err
+	})
+	return info, err
+}
+
+func (r *retryProvider) List(ctx context.Context, path string) (entries []FileInfo, err error) {
+	err = r.policy.Do(ctx, func() error {
+		entries, err = r.Provider.List(ctx, path)
+		return err
+	})
+	return entries, err
+}
+
+// ListAll retries a scan that fails before reporting any file. Once files
+// have been handed to fn a retry would report them again, so later errors
+// are returned as they are.
+func (r *retryProvider) ListAll(ctx context.Context, path string, fn func(rel string, info FileInfo) error) error {
+	var reported bool
+	policy := r.policy
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	policy.Retryable = func(err error) bool { return !reported && retryable(err) }
+	return policy.Do(ctx, func() error {
+		return r.Wrapper.ListAll(ctx, path, func(rel string, info FileInfo) error {
+			reported = true
+			return fn(rel, info)
+		})
+	})
+}
+
+// ListVersions retries a version scan that fails before reporting any file,
+// for the same reason as ListAll.
+func (r *retryProvider) ListVersions(ctx context.Context, path string, fn func(rel string, versions []ObjectVersion) error) error {
+	var reported bool
+	policy := r.policy
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	policy.Retryable = func(err error) bool { return !reported && retryable(err) }
+	return policy.Do(ctx, func() error {
+		return r.Wrapper.ListVersions(ctx, path, func(rel string, versions []ObjectVersion) error {
+			reported = true
+			return fn(rel, versions)
+		})
+	})
+}
+
+func (r *retryProvider) OpenReadVersion(ctx context.Context, path, versionID string) (rc io.ReadCloser, err error) {
+	err = r.policy.Do(ctx, func() error {
+		rc, err = r.Wrapper.OpenReadVersion(ctx, path, versionID)
+		return err
+	})
+	return rc, err
+}
+
+func (r *retryProvider) OpenRead(ctx context.Context, path string) (rc io.ReadCloser, err error) {
+	err = r.policy.Do(ctx, func() error {
+		rc, err = r.Provider.OpenRead(ctx, path)
+		return err
+	})
+	return rc, err
+}
+
+func (r *retryProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (rc io.ReadCloser, err error) {
+	err = r.policy.Do(ctx, func() error {
+		rc, err = r.Wrapper.OpenReadRange(ctx, path, offset, length)
+		return err
+	})
+	return rc, err
+}
+
+func (r *retryProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (wc io.WriteCloser, err error) {
+	err = r.policy.Do(ctx, func() error {
+		wc, err = r.Provider.OpenWrite(ctx, path, metadata)
+		return err
+	})
+	return wc, err
+}
+
+func (r *retryProvider) MakeDir(ctx context.Context, path string, info FileInfo) error {
+	return r.policy.Do(ctx, func() error {
+		return r.Wrapper.MakeDir(ctx, path, info)
+	})
+}
+
+func (r *retryProvider) Restore(ctx context.Context, path string, opts RestoreOptions) error {
+	return r.policy.Do(ctx, func() error {
+		return r.Wrapper.Restore(ctx, path, opts)
+	})
+}
+
+// DeleteBatch retries the files a batch delete failed on with a transient
+// error until they are gone or the attempts run out. Files failing for
+// other reasons are reported without retrying.
+func (r *retryProvider) DeleteBatch(ctx context.Context, paths []string) error {
+	retryable := r.policy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	var permanent []DeleteFailure
+	pending := paths
+	err := r.policy.Do(ctx, func() error {
+		err := r.Wrapper.DeleteBatch(ctx, pending)
+		var batch *BatchDeleteError
+		if !errors.As(err, &batch) {
+			return err
+		}
+		var transient []DeleteFailure
+		for _, f := range batch.Failures {
+			if retryable(f.Err) {
+				transient = append(transient, f)
+			} else {
+				permanent = append(permanent, f)
+			}
+		}
+		if len(transient) == 0 {
+			return nil
+		}
+		retry := &BatchDeleteError{Failures: transient}
+		pending = retry.Paths()
+		return retry
+	})
+	var batch *BatchDeleteError
+	if errors.As(err, &batch) {
+		permanent = append(permanent, batch.Failures...)
+	} else if err != nil {
+		return err
+	}
+	if len(permanent) > 0 {
+		return &BatchDeleteError{Failures: permanent}
+	}
+	return nil
+}
+
+func (r *retryProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (wc io.WriteCloser, offset int64, err error) {
+	err = r.policy.Do(ctx, func() error {
+		wc, offset, err = r.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+		return err
+	})
+	return wc, offset, err
+}
+
+func (r *retryProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (wc io.WriteCloser, at int64, err error) {
+	err = r.policy.Do(ctx, func() error {
+		wc, at, err = r.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+		return err
+	})
+	return wc, at, err
+}
+
+// OpenChunked retries opening the file. The chunks themselves are not
+// retried, as their readers cannot be replayed; the caller retries the
+// file.
+func (r *retryProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (cf ChunkedFile, err error) {
+	err = r.policy.Do(ctx, func() error {
+		cf, err = r.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+		return err
+	})
+	return cf, err
+}
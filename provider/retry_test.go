@@ -0,0 +1,266 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyProvider fails Stat with err until failures reaches zero.
+type flakyProvider struct {
+	Provider
+	err      error
+	failures int
+	calls    int
+}
+
+func (f *flakyProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return nil, f.err
+	}
+	return &localFileInfo{name: path}, nil
+}
+
+type statusError struct{ code int }
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", e.code) }
+func (e statusError) HTTPStatusCode() int { return e.code }
+
+type codeError struct{ code string }
+
+func (e codeError) Error() string     { return e.code }
+func (e codeError) ErrorCode() string { return e.code }
+
+var fastRetry = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+func TestWithRetry_Transient(t *testing.T) {
+	flaky := &flakyProvider{err: fmt.Errorf("read: %w", syscall.ECONNRESET), failures: 2}
+	p := WithRetry(flaky, fastRetry)
+
+	info, err := p.Stat(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Expected Stat to succeed after retries, got %v", err)
+	}
+	if info.Name() != "a" || flaky.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", flaky.calls)
+	}
+}
+
+func TestWithRetry_GivesUp(t *testing.T) {
+	flaky := &flakyProvider{err: statusError{503}, failures: 10}
+	p := WithRetry(flaky, fastRetry)
+
+	if _, err := p.Stat(context.Background(), "a"); err == nil {
+		t.Fatal("Expected Stat to fail")
+	}
+	if flaky.calls != fastRetry.MaxAttempts {
+		t.Errorf("Expected %d attempts, got %d", fastRetry.MaxAttempts, flaky.calls)
+	}
+}
+
+func TestWithRetry_Permanent(t *testing.T) {
+	flaky := &flakyProvider{err: errors.New("no such file"), failures: 10}
+	p := WithRetry(flaky, fastRetry)
+
+	if _, err := p.Stat(context.Background(), "a"); err == nil {
+		t.Fatal("Expected Stat to fail")
+	}
+	if flaky.calls != 1 {
+		t.Errorf("Expected a permanent error not to be retried, got %d calls", flaky.calls)
+	}
+}
+
+// flakyLister fails its scans with a transient error, after reporting
+// reported files, until failures reaches zero.
+type flakyLister struct {
+	Provider
+	failures int
+	reported int
+	calls    int
+}
+
+func (f *flakyLister) ListAll(ctx context.Context, path string, fn func(string, FileInfo) error) error {
+	f.calls++
+	for i := 0; i < f.reported; i++ {
+		fn(fmt.Sprint(i), &localFileInfo{})
+	}
+	if f.failures > 0 {
+		f.failures--
+		return syscall.ECONNRESET
+	}
+	return nil
+}
+
+func TestWithRetry_ListAll(t *testing.T) {
+	lister := &flakyLister{failures: 2}
+	p := WithRetry(lister, fastRetry).(FlatLister)
+	if err := p.ListAll(context.Background(), "", func(string, FileInfo) error { return nil }); err != nil {
+		t.Fatalf("Expected the scan to succeed after retries, got %v", err)
+	}
+	if lister.calls != 3 {
+		t.Errorf("Expected 3 scans, got %d", lister.calls)
+	}
+
+	// Files already handed out must not be reported twice
+	lister = &flakyLister{failures: 2, reported: 1}
+	p = WithRetry(lister, fastRetry).(FlatLister)
+	if err := p.ListAll(context.Background(), "", func(string, FileInfo) error { return nil }); err == nil {
+		t.Fatal("Expected a scan failing midway to fail")
+	}
+	if lister.calls != 1 {
+		t.Errorf("Expected no retry, got %d scans", lister.calls)
+	}
+}
+
+func TestWithRetry_Disabled(t *testing.T) {
+	flaky := &flakyProvider{}
+	if p := WithRetry(flaky, RetryPolicy{MaxAttempts: 1}); p != Provider(flaky) {
+		t.Error("Expected a single-attempt policy to return the provider unwrapped")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"reset", fmt.Errorf("wrapped: %w", syscall.ECONNRESET), true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"canceled", context.Canceled, false},
+		{"500", statusError{500}, true},
+		{"429", statusError{429}, true},
+		{"404", statusError{404}, false},
+		{"slow down", codeError{"SlowDown"}, true},
+		{"access denied", codeError{"AccessDenied"}, false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	rp := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceiling := 10 * time.Millisecond << (attempt - 1)
+		if ceiling > rp.MaxDelay {
+			ceiling = rp.MaxDelay
+		}
+		for i := 0; i < 20; i++ {
+			if d := rp.Backoff(attempt); d < 0 || d > ceiling {
+				t.Fatalf("Backoff(%d) = %v, want within [0, %v]", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"slow down", fmt.Errorf("put: %w", codeError{"SlowDown"}), true},
+		{"throttling", codeError{"ThrottlingException"}, true},
+		{"503", statusError{503}, true},
+		{"429", statusError{429}, true},
+		{"500", statusError{500}, false},
+		{"reset", syscall.ECONNRESET, false},
+		{"chaos", &ChaosError{Op: OpStat, Path: "a"}, true},
+	}
+	for _, tt := range tests {
+		if got := IsThrottled(tt.err); got != tt.want {
+			t.Errorf("IsThrottled(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy_ThrottleDelay(t *testing.T) {
+	rp := RetryPolicy{BaseDelay: time.Nanosecond, MaxDelay: time.Hour, ThrottleDelay: time.Minute}
+
+	if d := rp.delay(1, statusError{500}); d > time.Nanosecond {
+		t.Errorf("Expected a server error to back off from BaseDelay, got %v", d)
+	}
+	var longer bool
+	for i := 0; i < 20 && !longer; i++ {
+		longer = rp.delay(1, codeError{"SlowDown"}) > time.Nanosecond
+	}
+	if !longer {
+		t.Error("Expected SlowDown to back off from ThrottleDelay")
+	}
+	if d := rp.delay(3, codeError{"SlowDown"}); d > 4*time.Minute {
+		t.Errorf("Expected the throttle delay to double per attempt, got %v", d)
+	}
+}
+
+func TestWithRetry_DeleteBatch(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	store := &batchStore{LocalProvider: NewLocalProvider(dir), fail: map[string]error{
+		"b": codeError{"InternalError"},
+		"c": codeError{"AccessDenied"},
+	}}
+	p := WithRetry(store, fastRetry)
+
+	err := p.(BatchDeleter).DeleteBatch(context.Background(), []string{"a", "b", "c"})
+	var batch *BatchDeleteError
+	if !errors.As(err, &batch) {
+		t.Fatalf("Expected a batch error, got %v", err)
+	}
+	if len(store.batches) != fastRetry.MaxAttempts {
+		t.Fatalf("Expected %d batches, got %v", fastRetry.MaxAttempts, store.batches)
+	}
+	for _, retried := range store.batches[1:] {
+		if len(retried) != 1 || retried[0] != "b" {
+			t.Errorf("Expected only the transient failure to be retried, got %v", retried)
+		}
+	}
+	if paths := batch.Paths(); len(paths) != 2 || paths[0] != "c" || paths[1] != "b" {
+		t.Errorf("Expected both failures reported, got %v", paths)
+	}
+
+	// A transient failure that clears is not reported
+	store.batches = nil
+	store.fail = map[string]error{}
+	os.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0644)
+	if err := p.(BatchDeleter).DeleteBatch(context.Background(), []string{"b"}); err != nil {
+		t.Errorf("Expected the batch to succeed, got %v", err)
+	}
+}
+
+func TestWithRetry_S3SingleLayer(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: 503, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	}}
+	p := WithRetry(WithMetrics(newFakeS3Provider(fake, "bucket", S3Options{}), NewMetrics()), fastRetry)
+
+	if _, err := p.Stat(context.Background(), "a"); err == nil {
+		t.Fatal("Expected Stat to fail")
+	}
+	// The S3 client's own retryer would send several per attempt
+	heads := 0
+	for _, req := range fake.requests {
+		if req.Method == http.MethodHead {
+			heads++
+		}
+	}
+	if heads != fastRetry.MaxAttempts {
+		t.Errorf("Expected %d HEAD requests, got %d", fastRetry.MaxAttempts, heads)
+	}
+}
@@ -4,24 +4,49 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/url"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // ensure interface is implemented
-var _ Provider = (*S3Provider)(nil)
+var (
+	_ Provider           = (*S3Provider)(nil)
+	_ RangeReader        = (*S3Provider)(nil)
+	_ Checksummer        = (*S3Provider)(nil)
+	_ SoftDeleter        = (*S3Provider)(nil)
+	_ CapabilityReporter = (*S3Provider)(nil)
+	_ ServerSideCopier   = (*S3Provider)(nil)
+	_ ResumableWriter    = (*S3Provider)(nil)
+	_ FlatLister         = (*S3Provider)(nil)
+)
+
+// maxCopyObjectSize is the largest object a single CopyObject call copies.
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
 
 type s3FileInfo struct {
 	name    string
 	size    int64
 	isDir   bool
 	modTime time.Time
+
+	storageClass string
+	archived     bool
+	restoring    bool
+
+	tags   map[string]string
+	tagged bool
+
+	contentType  string
+	contentTyped bool
 }
 
 func (f *s3FileInfo) Name() string       { return f.name }
@@ -30,31 +55,112 @@ func (f *s3FileInfo) IsDir() bool        { return f.isDir }
 func (f *s3FileInfo) ModTime() time.Time { return f.modTime }
 
 type S3Provider struct {
-	client *s3.Client
-	bucket string
-	prefix string
+	client   *s3.Client
+	bucket   string
+	prefix   string
 	uploader *manager.Uploader
+
+	downloadConcurrency int
+	downloadPartSize    int64
+	uploadPartSize      int64
+	uploadConcurrency   int
+	maxUploadParts      int
+	sse                 serverSideEncryption
+	metadata            bool
+	tags                bool
+	dirPlaceholders     bool
+	contentTypes        ContentTypeMap
+
+	// express is set for S3 Express One Zone directory buckets, which
+	// have real directories and no tags, versions or archive tiers.
+	express bool
 }
 
 // NewS3Provider creates a new S3Provider.
 // bucket is the S3 bucket name.
 func NewS3Provider(ctx context.Context, bucket string, prefix string) (*S3Provider, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+	return NewS3ProviderWithOptions(ctx, bucket, prefix, S3Options{})
+}
+
+// NewS3ProviderWithOptions creates a new S3Provider configured by opts.
+func NewS3ProviderWithOptions(ctx context.Context, bucket, prefix string, opts S3Options) (*S3Provider, error) {
+	sse, err := newServerSideEncryption(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.validateUpload(); err != nil {
+		return nil, err
+	}
+	if err := validateExpress(bucket, opts); err != nil {
+		return nil, err
+	}
+
+	loadOpts, err := opts.loadOptions()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load AWS config: %w", err)
 	}
+	opts.assumeRole(&cfg)
 
-	client := s3.NewFromConfig(cfg)
-    uploader := manager.NewUploader(client)
+	client := s3.NewFromConfig(cfg, opts.clientOptions)
+	uploader := manager.NewUploader(client, opts.uploaderOptions)
 
 	return &S3Provider{
-		client:   client,
-		bucket:   bucket,
-		prefix:   prefix,
-        uploader: uploader,
+		client:              client,
+		bucket:              bucket,
+		prefix:              prefix,
+		uploader:            uploader,
+		downloadConcurrency: opts.DownloadConcurrency,
+		downloadPartSize:    opts.DownloadPartSize,
+		uploadPartSize:      opts.UploadPartSize,
+		uploadConcurrency:   opts.UploadConcurrency,
+		maxUploadParts:      opts.MaxUploadParts,
+		sse:                 sse,
+		dirPlaceholders:     opts.DirPlaceholders && !IsDirectoryBucket(bucket),
+		express:             IsDirectoryBucket(bucket),
 	}, nil
 }
 
+// withoutClientRetries makes the client, and the uploader using it, send
+// each request once, leaving retries to WithRetry.
+func (p *S3Provider) withoutClientRetries() {
+	if p.client == nil {
+		return
+	}
+	p.client = s3.New(p.client.Options(), func(o *s3.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+	if p.uploader != nil {
+		p.uploader.S3 = p.client
+	}
+}
+
+// Capabilities reports the features of S3. Objects carry POSIX metadata
+// only as user metadata, with WithMetadata, and there is no native rename
+// or symlink. Directory buckets have no archive tiers and no versioning.
+func (p *S3Provider) Capabilities() Capabilities {
+	return Capabilities{
+		Delete:         true,
+		RangedRead:     true,
+		Checksums:      true,
+		Metadata:       p.metadata,
+		ServerSideCopy: true,
+		ResumableWrite: true,
+		FlatList:       true,
+		Restore:        !p.express,
+		Tags:           p.tags,
+		Versions:       !p.express,
+		Presign:        true,
+		ContentType:    true,
+		ChunkedWrite:   true,
+		AtomicObjects:  true,
+		Directories:    p.dirPlaceholders,
+	}
+}
+
 // buildKey constructs the full S3 key based on the provider's prefix
 func (p *S3Provider) buildKey(subPath string) string {
 	subPath = strings.TrimPrefix(subPath, "/")
@@ -71,10 +177,12 @@ func (p *S3Provider) Stat(ctx context.Context, pth string) (FileInfo, error) {
 	key := p.buildKey(pth)
 
 	// exact match
-	headOut, err := p.client.HeadObject(ctx, &s3.HeadObjectInput{
+	head := &s3.HeadObjectInput{
 		Bucket: aws.String(p.bucket),
 		Key:    aws.String(key),
-	})
+	}
+	head.SSECustomerAlgorithm, head.SSECustomerKey, head.SSECustomerKeyMD5 = p.sse.customer()
+	headOut, err := p.client.HeadObject(ctx, head)
 
 	if err == nil {
 		var modTime time.Time
@@ -86,12 +194,24 @@ func (p *S3Provider) Stat(ctx context.Context, pth string) (FileInfo, error) {
 			size = *headOut.ContentLength
 		}
 
-		return &s3FileInfo{
+		info := &s3FileInfo{
 			name:    path.Base(key),
 			size:    size,
-			isDir:   strings.HasSuffix(key, "/"),
+			isDir:   strings.HasSuffix(key, "/") || aws.ToString(headOut.ContentType) == directoryContentType,
 			modTime: modTime,
-		}, nil
+		}
+		info.storageClass, info.archived, info.restoring = headArchiveState(headOut)
+		info.contentType, info.contentTyped = aws.ToString(headOut.ContentType), true
+		if p.tags {
+			if info.tags, err = p.objectTags(ctx, pth, key, aws.ToInt32(headOut.TagCount)); err != nil {
+				return nil, err
+			}
+			info.tagged = true
+		}
+		if p.metadata {
+			return withUserMetadata(info, headOut.Metadata), nil
+		}
+		return info, nil
 	}
 
 	// maybe a directory? Let's check prefix
@@ -113,14 +233,14 @@ func (p *S3Provider) Stat(ctx context.Context, pth string) (FileInfo, error) {
 	// if objects exist, treat as directory
 	// listOut.Contents actually isn't 100% full proof if there are no contents
 	// but there are CommonPrefixes.
-	
+
 	if len(listOut.Contents) > 0 {
 		return &s3FileInfo{
 			name:  path.Base(key),
 			isDir: true,
 		}, nil
 	}
-	
+
 	if len(listOut.CommonPrefixes) > 0 {
 		return &s3FileInfo{
 			name:  path.Base(key),
@@ -128,7 +248,7 @@ func (p *S3Provider) Stat(ctx context.Context, pth string) (FileInfo, error) {
 		}, nil
 	}
 
-	return nil, fmt.Errorf("file not found: %s", pth)
+	return nil, fmt.Errorf("file not found: %s: %w", pth, fs.ErrNotExist)
 }
 
 // List returns the contents of the given directory.
@@ -140,6 +260,13 @@ func (p *S3Provider) List(ctx context.Context, pth string) ([]FileInfo, error) {
 
 	var infos []FileInfo
 	var continuationToken *string
+	dirs := make(map[string]bool)
+	addDir := func(name string) {
+		if !dirs[name] {
+			dirs[name] = true
+			infos = append(infos, &s3FileInfo{name: name, isDir: true})
+		}
+	}
 
 	for {
 		out, err := p.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
@@ -155,22 +282,19 @@ func (p *S3Provider) List(ctx context.Context, pth string) ([]FileInfo, error) {
 		// Add common prefixes as directories
 		for _, cp := range out.CommonPrefixes {
 			name := strings.TrimPrefix(*cp.Prefix, dirPrefix)
-			name = strings.TrimSuffix(name, "/")
-			infos = append(infos, &s3FileInfo{
-				name:  name,
-				isDir: true,
-			})
+			addDir(strings.TrimSuffix(name, "/"))
 		}
 
-		// Add objects as files (or explicit directories if they end in /)
+		// Add objects as files, and placeholders as the directories
+		// they stand for
 		for _, obj := range out.Contents {
 			name := strings.TrimPrefix(*obj.Key, dirPrefix)
 			if name == "" { // sometimes the dir itself is in the results
 				continue
 			}
-			isDir := strings.HasSuffix(name, "/")
-			if isDir {
-				name = strings.TrimSuffix(name, "/")
+			if dir, ok := dirPlaceholder(name); ok {
+				addDir(dir)
+				continue
 			}
 
 			var modTime time.Time
@@ -182,12 +306,13 @@ func (p *S3Provider) List(ctx context.Context, pth string) ([]FileInfo, error) {
 				size = *obj.Size
 			}
 
-			infos = append(infos, &s3FileInfo{
+			info := &s3FileInfo{
 				name:    name,
 				size:    size,
-				isDir:   isDir,
 				modTime: modTime,
-			})
+			}
+			info.storageClass, info.archived = listArchiveState(obj.StorageClass)
+			infos = append(infos, info)
 		}
 
 		if out.IsTruncated != nil && *out.IsTruncated {
@@ -197,43 +322,359 @@ func (p *S3Provider) List(ctx context.Context, pth string) ([]FileInfo, error) {
 		}
 	}
 
+	infos = dropShadowedPlaceholders(infos, dirs)
+	p.sortExpressListing(infos)
 	return infos, nil
 }
 
-// OpenRead opens a file for streaming reads.
+// ListAll scans every key under the prefix of pth with paginated
+// ListObjectsV2 calls without a delimiter, reporting each object as the
+// pages arrive. Directory placeholders of either convention are skipped.
+func (p *S3Provider) ListAll(ctx context.Context, pth string, fn func(rel string, info FileInfo) error) error {
+	dirPrefix := p.buildKey(pth)
+	if dirPrefix != "" && !strings.HasSuffix(dirPrefix, "/") {
+		dirPrefix += "/"
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(p.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(p.bucket),
+		Prefix: aws.String(dirPrefix),
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list %q: %w", pth, err)
+		}
+		for _, obj := range out.Contents {
+			rel := strings.TrimPrefix(aws.ToString(obj.Key), dirPrefix)
+			if _, placeholder := dirPlaceholder(rel); rel == "" || placeholder {
+				continue
+			}
+			info := &s3FileInfo{
+				name:    path.Base(rel),
+				size:    aws.ToInt64(obj.Size),
+				modTime: aws.ToTime(obj.LastModified),
+			}
+			info.storageClass, info.archived = listArchiveState(obj.StorageClass)
+			if err := fn(rel, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OpenRead opens a file for streaming reads. With a download concurrency
+// above one, objects larger than a part are fetched as parallel ranged GETs.
 func (p *S3Provider) OpenRead(ctx context.Context, pth string) (io.ReadCloser, error) {
 	key := p.buildKey(pth)
-	out, err := p.client.GetObject(ctx, &s3.GetObjectInput{
+	if p.downloadConcurrency > 1 {
+		return p.openParallel(ctx, pth, key)
+	}
+	return p.openWhole(ctx, pth, key)
+}
+
+// OpenReadRange opens a byte range of an object using an HTTP Range request.
+// A negative length reads to the end of the object.
+func (p *S3Provider) OpenReadRange(ctx context.Context, pth string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	key := p.buildKey(pth)
+	in := &s3.GetObjectInput{
 		Bucket: aws.String(p.bucket),
 		Key:    aws.String(key),
-	})
+		Range:  aws.String(rangeHeader(offset, length)),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.GetObject(ctx, in)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open read %q: %w", pth, err)
+		if archived := archivedError(pth, err); archived != nil {
+			return nil, archived
+		}
+		return nil, fmt.Errorf("failed to open range read %q: %w", pth, err)
 	}
 	return out.Body, nil
 }
 
+// Checksum returns the digest S3 stored for an object. Additional checksums
+// (CRC32C, CRC32, SHA256, SHA1) are preferred; objects uploaded without one
+// fall back to their ETag.
+func (p *S3Provider) Checksum(ctx context.Context, pth string) (Digest, error) {
+	key := p.buildKey(pth)
+	in := &s3.HeadObjectInput{
+		Bucket:       aws.String(p.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.HeadObject(ctx, in)
+	if err != nil {
+		return Digest{}, fmt.Errorf("failed to head %q: %w", pth, err)
+	}
+	return s3Digest(headChecksums(out)), nil
+}
+
+// objectChecksums are the integrity fields S3 returns for an object, in
+// HeadObject as well as CopyObject responses.
+type objectChecksums struct {
+	CRC32C, CRC32, SHA256, SHA1 *string
+	Type                        types.ChecksumType
+	ETag                        *string
+	// Encryption and CustomerKey tell whether the ETag can be an MD5 of
+	// the content: not under SSE-KMS or customer-provided keys
+	Encryption  types.ServerSideEncryption
+	CustomerKey bool
+}
+
+func headChecksums(out *s3.HeadObjectOutput) objectChecksums {
+	return objectChecksums{
+		CRC32C: out.ChecksumCRC32C,
+		CRC32:  out.ChecksumCRC32,
+		SHA256: out.ChecksumSHA256,
+		SHA1:   out.ChecksumSHA1,
+		Type:   out.ChecksumType,
+		ETag:   out.ETag,
+
+		Encryption:  out.ServerSideEncryption,
+		CustomerKey: out.SSECustomerAlgorithm != nil,
+	}
+}
+
+func copyChecksums(out *s3.CopyObjectOutput) objectChecksums {
+	r := out.CopyObjectResult
+	return objectChecksums{
+		CRC32C: r.ChecksumCRC32C,
+		CRC32:  r.ChecksumCRC32,
+		SHA256: r.ChecksumSHA256,
+		SHA1:   r.ChecksumSHA1,
+		Type:   r.ChecksumType,
+		ETag:   r.ETag,
+
+		Encryption:  out.ServerSideEncryption,
+		CustomerKey: out.SSECustomerAlgorithm != nil,
+	}
+}
+
+// s3Digest picks the most useful checksum S3 reported for an object.
+func s3Digest(out objectChecksums) Digest {
+	candidates := []struct {
+		algorithm string
+		value     *string
+	}{
+		{DigestCRC32C, out.CRC32C},
+		{DigestCRC32, out.CRC32},
+		{DigestSHA256, out.SHA256},
+		{DigestSHA1, out.SHA1},
+	}
+	for _, c := range candidates {
+		if c.value == nil {
+			continue
+		}
+		if d, ok := base64Digest(c.algorithm, *c.value); ok {
+			if out.Type == types.ChecksumTypeComposite {
+				d.Algorithm = DigestComposite
+			}
+			return d
+		}
+	}
+
+	if out.ETag != nil {
+		d := Digest{Algorithm: DigestETag, Value: strings.Trim(*out.ETag, `"`)}
+		// Multipart ETags ("<hash>-<parts>") and those of objects encrypted
+		// with KMS or customer keys are no hash of the content
+		if strings.Contains(d.Value, "-") || out.CustomerKey ||
+			out.Encryption == types.ServerSideEncryptionAwsKms || out.Encryption == types.ServerSideEncryptionAwsKmsDsse {
+			d.Algorithm = DigestComposite
+		}
+		return d
+	}
+	return Digest{}
+}
+
+// CanCopyFrom reports whether src is an S3 bucket, which CopyObject can
+// read from directly.
+func (p *S3Provider) CanCopyFrom(src Provider) bool {
+	_, ok := Unwrap(src).(*S3Provider)
+	return ok
+}
+
+// CopyFrom copies an object from another S3 bucket or prefix with
+// CopyObject, asking S3 to compute a CRC32C of the copy. The source's tags
+// are copied with it unless the provider was configured without tags, and
+// its Content-Type and user metadata always are. Objects larger than a
+// single CopyObject allows are copied in parts with UploadPartCopy, so no
+// object is streamed through gofast however large it is.
+func (p *S3Provider) CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error) {
+	srcS3, ok := Unwrap(src).(*S3Provider)
+	if !ok {
+		return Digest{}, ErrNotSupported
+	}
+	if info != nil && info.Size() > maxCopyObjectSize {
+		return p.copyMultipart(ctx, srcS3, srcPath, dstPath)
+	}
+
+	in := &s3.CopyObjectInput{
+		Bucket:            aws.String(p.bucket),
+		Key:               aws.String(p.buildKey(dstPath)),
+		CopySource:        aws.String(copySource(srcS3.bucket, srcS3.buildKey(srcPath))),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+	}
+	// CopyObject can only change the type by replacing all user metadata,
+	// so the source's is read and written back with the overridden type
+	if _, overridden := p.contentTypes.Override(dstPath); overridden {
+		attrs, err := p.copyAttributes(ctx, srcS3, srcPath, dstPath)
+		if err != nil {
+			return Digest{}, err
+		}
+		in.MetadataDirective = types.MetadataDirectiveReplace
+		in.Metadata, in.ContentType = attrs.metadata, attrs.contentType
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = p.sse.write()
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = srcS3.sse.customer()
+	if !p.tags {
+		in.TaggingDirective = types.TaggingDirectiveReplace
+	}
+	out, err := p.client.CopyObject(ctx, in)
+	if err != nil {
+		if archived := archivedError(srcPath, err); archived != nil {
+			return Digest{}, archived
+		}
+		return Digest{}, fmt.Errorf("failed to copy %q: %w", srcPath, err)
+	}
+	if out.CopyObjectResult == nil {
+		return Digest{}, nil
+	}
+	return s3Digest(copyChecksums(out)), nil
+}
+
+// copySource formats the URL-encoded "bucket/key" CopySource header.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// Delete removes an object. On a bucket with versioning enabled S3 keeps the
+// data and records a delete marker, which Undelete can later remove.
+func (p *S3Provider) Delete(ctx context.Context, pth string) error {
+	_, err := p.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(p.buildKey(pth)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %q: %w", pth, err)
+	}
+	return nil
+}
+
+// SoftDeletes reports whether the bucket has versioning enabled, in which
+// case deletes only add delete markers. Suspended versioning does not count:
+// deleting a null version there is permanent. Directory buckets cannot be
+// versioned.
+func (p *S3Provider) SoftDeletes(ctx context.Context) (bool, error) {
+	if p.express {
+		return false, nil
+	}
+	out, err := p.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(p.bucket),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get versioning for bucket %q: %w", p.bucket, err)
+	}
+	return out.Status == types.BucketVersioningStatusEnabled, nil
+}
+
+// ListDeleted returns objects under the prefix whose current version is a
+// delete marker created at or after since.
+func (p *S3Provider) ListDeleted(ctx context.Context, since time.Time) ([]DeletedEntry, error) {
+	listPrefix := p.buildKey("")
+	if listPrefix != "" && !strings.HasSuffix(listPrefix, "/") {
+		listPrefix += "/"
+	}
+
+	var entries []DeletedEntry
+	var keyMarker, versionMarker *string
+	for {
+		out, err := p.client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+			Bucket:          aws.String(p.bucket),
+			Prefix:          aws.String(listPrefix),
+			KeyMarker:       keyMarker,
+			VersionIdMarker: versionMarker,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object versions: %w", err)
+		}
+
+		entries = append(entries, deletedEntries(out.DeleteMarkers, listPrefix, since)...)
+
+		if out.IsTruncated == nil || !*out.IsTruncated {
+			return entries, nil
+		}
+		keyMarker, versionMarker = out.NextKeyMarker, out.NextVersionIdMarker
+	}
+}
+
+// deletedEntries converts the current delete markers created at or after
+// since into DeletedEntries relative to listPrefix.
+func deletedEntries(markers []types.DeleteMarkerEntry, listPrefix string, since time.Time) []DeletedEntry {
+	var entries []DeletedEntry
+	for _, m := range markers {
+		if m.IsLatest == nil || !*m.IsLatest || m.Key == nil || m.LastModified == nil {
+			continue
+		}
+		if m.LastModified.Before(since) {
+			continue
+		}
+		entries = append(entries, DeletedEntry{
+			Path:      strings.TrimPrefix(*m.Key, listPrefix),
+			VersionID: aws.ToString(m.VersionId),
+			DeletedAt: *m.LastModified,
+		})
+	}
+	return entries
+}
+
+// Undelete removes the delete marker recorded in entry, making the previous
+// version of the object current again.
+func (p *S3Provider) Undelete(ctx context.Context, entry DeletedEntry) error {
+	_, err := p.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(p.bucket),
+		Key:       aws.String(p.buildKey(entry.Path)),
+		VersionId: aws.String(entry.VersionID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove delete marker for %q: %w", entry.Path, err)
+	}
+	return nil
+}
+
+// rangeHeader formats an HTTP Range header value for the given offset and
+// length. A negative length requests everything from offset onwards.
+func rangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
 // OpenWrite opens a file for streaming writes.
 func (p *S3Provider) OpenWrite(ctx context.Context, pth string, metadata FileInfo) (io.WriteCloser, error) {
 	key := p.buildKey(pth)
 
 	// Check if this is just a directory placeholder we need to create
 	if metadata != nil && metadata.IsDir() {
-		// S3 doesn't have true directories, but writing a 0-byte object ending in '/' simulates it
-		if !strings.HasSuffix(key, "/") {
-			key += "/"
+		if !p.dirPlaceholders {
+			return &dummyWriter{}, nil
 		}
-		
-		_, err := p.client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: aws.String(p.bucket),
-			Key:    aws.String(key),
-			Body:   strings.NewReader(""),
-		})
-		
-		if err != nil {
-			return nil, fmt.Errorf("failed to write directory placeholder: %w", err)
+		if err := p.MakeDir(ctx, pth, metadata); err != nil {
+			return nil, err
 		}
-		
+
 		// Return a dummy writer since we're done
 		return &dummyWriter{}, nil
 	}
@@ -241,41 +682,139 @@ func (p *S3Provider) OpenWrite(ctx context.Context, pth string, metadata FileInf
 	// Standard file upload
 	pr, pw := io.Pipe()
 
-	errChan := make(chan error, 1)
+	done := make(chan uploadResult, 1)
 
-	go func() {
-		_, err := p.uploader.Upload(ctx, &s3.PutObjectInput{
-			Bucket: aws.String(p.bucket),
-			Key:    aws.String(key),
-			Body:   pr,
-		})
-		pr.CloseWithError(err)
-		errChan <- err
-	}()
+	// The uploader cannot size parts for a stream of unknown length, so
+	// large files are given parts big enough to stay within the limit
+	partSize := p.partSizeFor(0)
+	if metadata != nil {
+		partSize = p.partSizeFor(metadata.Size())
+	}
+	in := p.putInput(key, pr, metadata)
+	start := func(contentType string) {
+		if contentType != "" {
+			in.ContentType = aws.String(contentType)
+		}
+		go func() {
+			out, err := p.uploader.Upload(ctx, in, func(u *manager.Uploader) {
+				u.PartSize = partSize
+			})
+			pr.CloseWithError(err)
+			done <- uploadResult{out: out, err: err}
+		}()
+	}
 
-	return &asyncS3Writer{
-		pw:      pw,
-		errChan: errChan,
-	}, nil
+	w := &asyncS3Writer{
+		ctx:  ctx,
+		p:    p,
+		path: pth,
+		pw:   pw,
+		hash: newUploadHash(partSize),
+		done: done,
+	}
+	if in.ContentType == nil {
+		// The type can only be told from the content
+		w.start = start
+	} else {
+		start("")
+	}
+	return w, nil
+}
+
+// putInput returns the request writing body to key, with the provider's
+// encryption settings and the user metadata of info.
+func (p *S3Provider) putInput(key string, body io.Reader, info FileInfo) *s3.PutObjectInput {
+	in := &s3.PutObjectInput{
+		Bucket:   aws.String(p.bucket),
+		Key:      aws.String(key),
+		Body:     body,
+		Metadata: p.userMetadata(info),
+		Tagging:  p.tagging(info),
+		// Unknown types are sniffed by OpenWrite or left to S3's default
+		ContentType: p.contentType(key, info),
+		// Stored with the object, so the upload can be verified
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = p.sse.write()
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	return in
 }
 
+type uploadResult struct {
+	out *manager.UploadOutput
+	err error
+}
+
+// asyncS3Writer streams a file to the uploader, computing the CRC32C S3
+// should store for it along the way.
 type asyncS3Writer struct {
-	pw      *io.PipeWriter
-	errChan <-chan error
+	ctx  context.Context
+	p    *S3Provider
+	path string
+	pw   *io.PipeWriter
+	hash *uploadHash
+	done <-chan uploadResult
+
+	// start, until called, holds back the upload while the first bytes are
+	// collected in head to sniff the content type from.
+	start func(contentType string)
+	head  []byte
+
+	// written counts the bytes written; they are committed once the
+	// upload has completed.
+	written   int64
+	committed bool
+}
+
+// Committed returns 0 until the upload has completed, and then the size of
+// the object: the uploader stores no parts a resume could use.
+func (w *asyncS3Writer) Committed() int64 {
+	if !w.committed {
+		return 0
+	}
+	return w.written
 }
 
 func (w *asyncS3Writer) Write(p []byte) (n int, err error) {
-	return w.pw.Write(p)
+	if w.start != nil {
+		n = min(len(p), sniffLen-len(w.head))
+		w.head = append(w.head, p[:n]...)
+		w.hash.Write(p[:n])
+		w.written += int64(n)
+		if len(w.head) < sniffLen {
+			return n, nil
+		}
+		if err := w.begin(); err != nil {
+			return n, err
+		}
+		p = p[n:]
+	}
+	m, err := w.pw.Write(p)
+	w.hash.Write(p[:m])
+	w.written += int64(m)
+	return n + m, err
 }
 
+// Close waits for the upload to complete and checks the checksum S3 stored
+// against the bytes written. An object that does not match is deleted.
 func (w *asyncS3Writer) Close() error {
+	if w.start != nil {
+		if err := w.begin(); err != nil {
+			return err
+		}
+	}
 	if err := w.pw.Close(); err != nil {
 		return err
 	}
 	// Wait for upload to complete
-	if err := <-w.errChan; err != nil {
-		return fmt.Errorf("s3 upload failed: %w", err)
+	res := <-w.done
+	if res.err != nil {
+		return fmt.Errorf("s3 upload failed: %w", res.err)
+	}
+	if err := w.hash.verify(w.path, res.out.ChecksumCRC32C); err != nil {
+		return w.p.discardCorrupt(w.ctx, w.path, err)
 	}
+	w.committed = true
 	return nil
 }
 
@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"net/http"
+	"path"
+)
+
+// sniffLen is how many leading bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// WithContentTypes sets the MIME types given to written objects by file
+// extension, overriding the source's type and detection.
+func (p *S3Provider) WithContentTypes(overrides ContentTypeMap) *S3Provider {
+	p.contentTypes = overrides
+	return p
+}
+
+func (f *s3FileInfo) ContentType() (string, bool) { return f.contentType, f.contentTyped }
+
+// contentType returns the Content-Type to write key with, or nil if it has
+// to be sniffed from the content. Directory placeholders get none.
+func (p *S3Provider) contentType(key string, info FileInfo) *string {
+	if info != nil && info.IsDir() {
+		return nil
+	}
+	if typ := ContentTypeFor(path.Base(key), info, p.contentTypes); typ != "" {
+		return &typ
+	}
+	return nil
+}
+
+// begin starts the upload held back for sniffing, with the type detected
+// from the bytes collected so far, and sends those bytes. An empty object
+// is left with S3's default type.
+func (w *asyncS3Writer) begin() error {
+	var contentType string
+	if len(w.head) > 0 {
+		contentType = http.DetectContentType(w.head)
+	}
+	start := w.start
+	w.start = nil
+	start(contentType)
+	_, err := w.pw.Write(w.head)
+	w.head = nil
+	return err
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// putRecorder answers PUTs with the CRC32C of their body and records the
+// Content-Type they were sent with.
+type putRecorder struct {
+	mu    sync.Mutex
+	types []string
+}
+
+func (r *putRecorder) handle(req *http.Request) *http.Response {
+	if req.Method != http.MethodPut {
+		return nil
+	}
+	var data []byte
+	if req.Body != nil {
+		data, _ = io.ReadAll(req.Body)
+	}
+	r.mu.Lock()
+	r.types = append(r.types, req.Header.Get("Content-Type"))
+	r.mu.Unlock()
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Amz-Checksum-Crc32c": {crc32cBase64(data)}},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+}
+
+func (r *putRecorder) last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.types) == 0 {
+		return ""
+	}
+	return r.types[len(r.types)-1]
+}
+
+func TestS3Provider_ContentTypeOnUpload(t *testing.T) {
+	rec := &putRecorder{}
+	fake := &fakeS3{handler: rec.handle}
+	p := newFakeS3Provider(fake, "bucket", S3Options{}).
+		WithContentTypes(ContentTypeMap{".md": "text/markdown"})
+	ctx := context.Background()
+
+	upload := func(name string, info FileInfo, data []byte) {
+		t.Helper()
+		w, err := p.OpenWrite(ctx, name, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Small writes exercise the sniffing buffer
+		for len(data) > 0 {
+			n := min(len(data), 100)
+			if _, err := w.Write(data[:n]); err != nil {
+				t.Fatal(err)
+			}
+			data = data[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Upload of %s: %v", name, err)
+		}
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1000)...)
+	tests := []struct {
+		name string
+		info FileInfo
+		data []byte
+		want string
+	}{
+		{"page.html", nil, []byte("hello"), "text/html; charset=utf-8"},
+		{"notes.md", nil, []byte("# hi"), "text/markdown"},
+		{"image", nil, png, "image/png"},
+		{"short", nil, []byte("plain words"), "text/plain; charset=utf-8"},
+		{"copied", &contentTypedFileInfo{FileInfo: &s3FileInfo{name: "copied"}, contentType: "application/x-custom"}, []byte("x"), "application/x-custom"},
+	}
+	for _, tt := range tests {
+		upload(tt.name, tt.info, tt.data)
+		if got := rec.last(); got != tt.want {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	// An empty object is left to the default type
+	upload("empty", nil, nil)
+	if got := rec.last(); got != "application/octet-stream" {
+		t.Errorf("Expected the default Content-Type for an empty object, got %q", got)
+	}
+}
+
+func TestS3Provider_StatContentType(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method != http.MethodHead {
+			return nil
+		}
+		header := http.Header{"Content-Length": {"3"}, "Content-Type": {"text/csv"}}
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	info, err := p.Stat(context.Background(), "a.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ, ok := ObjectContentType(info); !ok || typ != "text/csv" {
+		t.Fatalf("Expected the object's Content-Type, got %q, %v", typ, ok)
+	}
+	// The source's type wins over the extension's
+	if got := ContentTypeFor("a.html", info, nil); got != "text/csv" {
+		t.Errorf("Expected the source's type preserved, got %q", got)
+	}
+}
+
+func TestS3Provider_CopyFromContentTypeOverride(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method == http.MethodHead {
+			header := http.Header{"Content-Length": {"3"}, "Content-Type": {"text/plain"}, "X-Amz-Meta-Owner": {"ops"}}
+			return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("<CopyObjectResult></CopyObjectResult>")), Request: req}
+	}}
+	src := newFakeS3Provider(fake, "src", S3Options{})
+	dst := newFakeS3Provider(fake, "dst", S3Options{}).WithContentTypes(ContentTypeMap{".md": "text/markdown"})
+	ctx := context.Background()
+
+	// An overridden object is still copied server-side, with its metadata
+	// written back alongside the new type
+	if _, err := dst.CopyFrom(ctx, src, "a.md", "a.md", nil); err != nil {
+		t.Fatalf("Expected an overridden object to be copied server-side, got %v", err)
+	}
+	if len(fake.requests) != 2 || fake.requests[0].Method != http.MethodHead {
+		t.Fatalf("Expected a HEAD and a copy request, got %d requests", len(fake.requests))
+	}
+	copyReq := fake.requests[1]
+	if got := copyReq.Header.Get("X-Amz-Metadata-Directive"); got != "REPLACE" {
+		t.Errorf("Expected the metadata replaced, got directive %q", got)
+	}
+	if got := copyReq.Header.Get("Content-Type"); got != "text/markdown" {
+		t.Errorf("Expected the overridden type, got %q", got)
+	}
+	if got := copyReq.Header.Get("X-Amz-Meta-Owner"); got != "ops" {
+		t.Errorf("Expected the source's user metadata kept, got %q", got)
+	}
+
+	fake.requests = nil
+	if _, err := dst.CopyFrom(ctx, src, "a.txt", "a.txt", nil); err != nil {
+		t.Errorf("Expected other objects to be copied server-side, got %v", err)
+	}
+	if len(fake.requests) != 1 || fake.requests[0].Header.Get("X-Amz-Metadata-Directive") != "" {
+		t.Errorf("Expected other objects copied with their metadata in a single request")
+	}
+}
@@ -0,0 +1,170 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// copyPartSize is the smallest part of a multipart copy. Copied parts cost
+// a request each but no transfer, so they are much larger than uploaded
+// ones.
+const copyPartSize = 512 * 1024 * 1024
+
+// copyAttributes is what a copy carries besides the content when S3 does
+// not copy it along: the source's user metadata, and the Content-Type of
+// the destination.
+type copyAttributes struct {
+	size        int64
+	metadata    map[string]string
+	contentType *string
+	tagCount    int32
+}
+
+// copyAttributes reads the attributes of srcPath on src for a copy to
+// dstPath. The Content-Type is the one -content-type-map sets for dstPath,
+// if any, else the source's.
+func (p *S3Provider) copyAttributes(ctx context.Context, src *S3Provider, srcPath, dstPath string) (copyAttributes, error) {
+	in := &s3.HeadObjectInput{
+		Bucket: aws.String(src.bucket),
+		Key:    aws.String(src.buildKey(srcPath)),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = src.sse.customer()
+	out, err := p.client.HeadObject(ctx, in)
+	if err != nil {
+		return copyAttributes{}, fmt.Errorf("failed to head %q: %w", srcPath, err)
+	}
+
+	attrs := copyAttributes{
+		size:        aws.ToInt64(out.ContentLength),
+		metadata:    out.Metadata,
+		contentType: out.ContentType,
+		tagCount:    aws.ToInt32(out.TagCount),
+	}
+	if typ, overridden := p.contentTypes.Override(dstPath); overridden {
+		attrs.contentType = aws.String(typ)
+	}
+	return attrs, nil
+}
+
+// copyMultipart copies srcPath on src to dstPath part by part with
+// UploadPartCopy, for objects larger than CopyObject copies at once. Up to
+// the upload concurrency parts are copied at a time. The attributes and,
+// unless p writes no tags, the tags CopyObject would keep are set on the
+// upload, which keeps none of its own, and the composite checksum
+// S3 reports for the object is checked against those of its parts. A copy
+// that fails is aborted.
+func (p *S3Provider) copyMultipart(ctx context.Context, src *S3Provider, srcPath, dstPath string) (Digest, error) {
+	attrs, err := p.copyAttributes(ctx, src, srcPath, dstPath)
+	if err != nil {
+		return Digest{}, err
+	}
+	var tagging *string
+	if p.tags {
+		tags, err := src.objectTags(ctx, srcPath, src.buildKey(srcPath), attrs.tagCount)
+		if err != nil {
+			return Digest{}, err
+		}
+		tagging = p.tagging(&s3FileInfo{tags: tags, tagged: true})
+	}
+
+	key := p.buildKey(dstPath)
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(p.bucket),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+		Metadata:          attrs.metadata,
+		Tagging:           tagging,
+		ContentType:       attrs.contentType,
+	}
+	create.ServerSideEncryption, create.SSEKMSKeyId = p.sse.write()
+	create.SSECustomerAlgorithm, create.SSECustomerKey, create.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.CreateMultipartUpload(ctx, create)
+	if err != nil {
+		return Digest{}, fmt.Errorf("failed to create upload for %q: %w", dstPath, err)
+	}
+	uploadID := aws.ToString(out.UploadId)
+
+	partSize := max(copyPartSize, p.partSizeFor(attrs.size))
+	parts := make([]UploadedPart, max((attrs.size+partSize-1)/partSize, 1))
+	concurrency := p.uploadConcurrency
+	if concurrency <= 0 {
+		concurrency = manager.DefaultUploadConcurrency
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, concurrency)
+	source := aws.String(copySource(src.bucket, src.buildKey(srcPath)))
+	for i := range parts {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := int64(i) * partSize
+			end := min(start+partSize, attrs.size) - 1
+			in := &s3.UploadPartCopyInput{
+				Bucket:     aws.String(p.bucket),
+				Key:        aws.String(key),
+				UploadId:   aws.String(uploadID),
+				PartNumber: aws.Int32(int32(i + 1)),
+				CopySource: source,
+			}
+			if end >= start {
+				in.CopySourceRange = aws.String(fmt.Sprintf("bytes=%d-%d", start, end))
+			}
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+			in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = src.sse.customer()
+			res, err := p.client.UploadPartCopy(ctx, in)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to copy part %d of %q: %w", i+1, srcPath, err)
+				}
+				return
+			}
+			parts[i] = UploadedPart{Number: int32(i + 1)}
+			if r := res.CopyPartResult; r != nil {
+				parts[i].ETag = aws.ToString(r.ETag)
+				parts[i].Checksum = aws.ToString(r.ChecksumCRC32C)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = p.completeUpload(ctx, dstPath, key, uploadID, parts)
+	}
+	if firstErr != nil {
+		// The parts are only a cost; uploads expire or are cleaned up
+		_ = p.AbortUpload(ctx, dstPath, uploadID)
+		if archived := archivedError(srcPath, firstErr); archived != nil {
+			return Digest{}, archived
+		}
+		return Digest{}, firstErr
+	}
+	if sum, ok := partsChecksum(parts); ok {
+		if d, ok := base64Digest(DigestCRC32C, sum); ok {
+			return d, nil
+		}
+	}
+	return Digest{}, nil
+}
@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// partCopyServer fakes the requests of a multipart copy of a large object.
+type partCopyServer struct {
+	size     int64
+	failPart int
+
+	mu      sync.Mutex
+	ranges  map[int]string
+	created http.Header
+	sums    []byte
+	aborted bool
+}
+
+func (s *partCopyServer) handle(req *http.Request) *http.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := req.URL.Query()
+	ok := func(header http.Header, body string) *http.Response {
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+	}
+
+	switch {
+	case req.Method == http.MethodHead:
+		return ok(http.Header{
+			"Content-Length":      {strconv.FormatInt(s.size, 10)},
+			"Content-Type":        {"video/mp4"},
+			"X-Amz-Meta-Owner":    {"ops"},
+			"X-Amz-Tagging-Count": {"1"},
+		}, "")
+	case req.Method == http.MethodGet && q.Has("tagging"):
+		return ok(http.Header{}, "<Tagging><TagSet><Tag><Key>team</Key><Value>media</Value></Tag></TagSet></Tagging>")
+	case req.Method == http.MethodPost && q.Has("uploads"):
+		s.created = req.Header.Clone()
+		s.ranges = make(map[int]string)
+		return ok(http.Header{}, "<InitiateMultipartUploadResult><UploadId>copy-1</UploadId></InitiateMultipartUploadResult>")
+	case req.Method == http.MethodPut && q.Get("uploadId") != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == s.failPart {
+			return xmlError(req, http.StatusBadRequest, "InvalidRequest")
+		}
+		s.ranges[n] = req.Header.Get("X-Amz-Copy-Source-Range")
+		sum := base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, uint32(n)))
+		return ok(http.Header{}, fmt.Sprintf(`<CopyPartResult><ETag>"etag-%d"</ETag><ChecksumCRC32C>%s</ChecksumCRC32C></CopyPartResult>`, n, sum))
+	case req.Method == http.MethodPost && q.Get("uploadId") != "":
+		for n := 1; n <= len(s.ranges); n++ {
+			s.sums = binary.BigEndian.AppendUint32(s.sums, uint32(n))
+		}
+		return ok(http.Header{}, `<CompleteMultipartUploadResult><ChecksumCRC32C>`+compositeChecksum(s.sums)+`</ChecksumCRC32C></CompleteMultipartUploadResult>`)
+	case req.Method == http.MethodDelete && q.Get("uploadId") != "":
+		s.aborted = true
+		return &http.Response{StatusCode: 204, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	}
+	return nil
+}
+
+func TestS3Provider_CopyFromMultipart(t *testing.T) {
+	server := &partCopyServer{size: maxCopyObjectSize + copyPartSize + 10}
+	fake := &fakeS3{handler: server.handle}
+	src := newFakeS3Provider(fake, "src", S3Options{})
+	dst := newFakeS3Provider(fake, "dst", S3Options{}).WithTags(true)
+	info := &s3FileInfo{name: "movie.mp4", size: server.size}
+
+	digest, err := dst.CopyFrom(context.Background(), src, "movie.mp4", "archive/movie.mp4", info)
+	if err != nil {
+		t.Fatalf("Expected a large object to be copied in parts, got %v", err)
+	}
+
+	// Every byte is copied exactly once, by the part that holds it
+	parts := int((server.size + copyPartSize - 1) / copyPartSize)
+	if len(server.ranges) != parts {
+		t.Fatalf("Expected %d parts, got %d", parts, len(server.ranges))
+	}
+	for n := 1; n <= parts; n++ {
+		start := int64(n-1) * copyPartSize
+		end := min(start+copyPartSize, server.size) - 1
+		if want := fmt.Sprintf("bytes=%d-%d", start, end); server.ranges[n] != want {
+			t.Errorf("Part %d copied %q, want %q", n, server.ranges[n], want)
+		}
+	}
+
+	// The attributes CopyObject would keep are set on the upload
+	if got := server.created.Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("Expected the source's Content-Type, got %q", got)
+	}
+	if got := server.created.Get("X-Amz-Meta-Owner"); got != "ops" {
+		t.Errorf("Expected the source's user metadata, got %q", got)
+	}
+	if tags, _ := url.ParseQuery(server.created.Get("X-Amz-Tagging")); tags.Get("team") != "media" {
+		t.Errorf("Expected the source's tags, got %q", server.created.Get("X-Amz-Tagging"))
+	}
+	if digest.Algorithm != DigestComposite || !strings.HasSuffix(digest.Value, "-"+strconv.Itoa(parts)) {
+		t.Errorf("Expected the composite checksum of %d parts, got %+v", parts, digest)
+	}
+}
+
+func TestS3Provider_CopyFromMultipartFailure(t *testing.T) {
+	server := &partCopyServer{size: maxCopyObjectSize + 1, failPart: 2}
+	fake := &fakeS3{handler: server.handle}
+	src := newFakeS3Provider(fake, "src", S3Options{})
+	dst := newFakeS3Provider(fake, "dst", S3Options{})
+	dst.uploadConcurrency = 1
+	info := &s3FileInfo{name: "movie.mp4", size: server.size}
+
+	if _, err := dst.CopyFrom(context.Background(), src, "movie.mp4", "movie.mp4", info); err == nil {
+		t.Fatal("Expected a failed part to fail the copy")
+	}
+	if !server.aborted {
+		t.Error("Expected the upload to be aborted")
+	}
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// loadOptions returns the settings for loading the AWS configuration that
+// implement the region, profile and static credentials of opts.
+func (opts S3Options) loadOptions() ([]func(*config.LoadOptions) error, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(opts.Region))
+	}
+	if opts.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(opts.Profile))
+	}
+
+	switch {
+	case opts.AccessKeyID != "" && opts.SecretAccessKey != "":
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken)))
+	case opts.AccessKeyID != "" || opts.SecretAccessKey != "" || opts.SessionToken != "":
+		return nil, fmt.Errorf("static credentials need both an access key ID and a secret access key")
+	}
+	if opts.RoleARN == "" && (opts.ExternalID != "" || opts.RoleSessionName != "") {
+		return nil, fmt.Errorf("an external ID or role session name requires a role ARN")
+	}
+	return loadOpts, nil
+}
+
+// assumeRole replaces the credentials of cfg with temporary ones for
+// opts.RoleARN, obtained from STS with the credentials cfg had and renewed
+// before they expire.
+func (opts S3Options) assumeRole(cfg *aws.Config) {
+	if opts.RoleARN == "" {
+		return
+	}
+	client := sts.NewFromConfig(*cfg, func(o *sts.Options) {
+		if o.Region == "" {
+			o.Region = defaultCompatibleRegion
+		}
+	})
+	cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(client, opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		if opts.ExternalID != "" {
+			o.ExternalID = aws.String(opts.ExternalID)
+		}
+		if opts.RoleSessionName != "" {
+			o.RoleSessionName = opts.RoleSessionName
+		}
+	}))
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// isolateAWSConfig points the AWS configuration at an empty directory so
+// the tests do not see the credentials of the machine they run on.
+func isolateAWSConfig(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	for _, name := range []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	return dir
+}
+
+func TestS3Options_Credentials(t *testing.T) {
+	dir := isolateAWSConfig(t)
+	creds := "[source]\naws_access_key_id = SOURCEKEY\naws_secret_access_key = sourcesecret\n"
+	if err := os.WriteFile(filepath.Join(dir, "credentials"), []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		opts S3Options
+		key  string
+	}{
+		{S3Options{Profile: "source", Region: "eu-west-1"}, "SOURCEKEY"},
+		{S3Options{AccessKeyID: "DESTKEY", SecretAccessKey: "destsecret", SessionToken: "token"}, "DESTKEY"},
+	} {
+		loadOpts, err := tc.opts.loadOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.AccessKeyID != tc.key {
+			t.Errorf("%+v: expected key %s, got %s", tc.opts, tc.key, got.AccessKeyID)
+		}
+	}
+
+	for _, opts := range []S3Options{
+		{AccessKeyID: "KEY"},
+		{SessionToken: "token"},
+		{ExternalID: "ext"},
+	} {
+		if _, err := opts.loadOptions(); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestS3Options_AssumeRole(t *testing.T) {
+	isolateAWSConfig(t)
+	var form string
+	sts := &fakeS3{handler: func(req *http.Request) *http.Response {
+		body, _ := io.ReadAll(req.Body)
+		form = string(body)
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Request: req, Body: io.NopCloser(strings.NewReader(
+			`<AssumeRoleResponse><AssumeRoleResult><Credentials>
+				<AccessKeyId>ASSUMEDKEY</AccessKeyId><SecretAccessKey>assumed</SecretAccessKey>
+				<SessionToken>session</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration>
+			</Credentials></AssumeRoleResult></AssumeRoleResponse>`))}
+	}}
+
+	opts := S3Options{
+		AccessKeyID:     "BASEKEY",
+		SecretAccessKey: "base",
+		RoleARN:         "arn:aws:iam::123456789012:role/migration",
+		ExternalID:      "partner-42",
+		RoleSessionName: "gofast",
+	}
+	loadOpts, err := opts.loadOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.HTTPClient = sts
+	opts.assumeRole(&cfg)
+
+	got, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AccessKeyID != "ASSUMEDKEY" || got.SessionToken != "session" {
+		t.Errorf("Expected the assumed role's credentials, got %+v", got)
+	}
+	for _, want := range []string{"Action=AssumeRole", "ExternalId=partner-42", "RoleSessionName=gofast", "role%2Fmigration"} {
+		if !strings.Contains(form, want) {
+			t.Errorf("AssumeRole request %q lacks %s", form, want)
+		}
+	}
+	if auth := sts.requests[0].Header.Get("Authorization"); !strings.Contains(auth, "Credential=BASEKEY/") {
+		t.Errorf("Expected AssumeRole to be signed with the base credentials, got %q", auth)
+	}
+}
@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxDeleteObjects is the most keys a single DeleteObjects request may name.
+const maxDeleteObjects = 1000
+
+// s3DeleteError is the error DeleteObjects reports for a single key. It
+// exposes the code like the SDK's errors, so IsTransient classifies it.
+type s3DeleteError struct {
+	code    string
+	message string
+}
+
+func (e *s3DeleteError) Error() string     { return fmt.Sprintf("%s: %s", e.code, e.message) }
+func (e *s3DeleteError) ErrorCode() string { return e.code }
+
+// DeleteBatch removes objects with DeleteObjects, up to 1000 keys per
+// request, in quiet mode so only the keys that failed are returned. When a
+// whole request fails, its keys and all those not yet sent are reported
+// with its error.
+func (p *S3Provider) DeleteBatch(ctx context.Context, paths []string) error {
+	var failures []DeleteFailure
+	for start := 0; start < len(paths); start += maxDeleteObjects {
+		batch := paths[start:min(start+maxDeleteObjects, len(paths))]
+		objects := make([]types.ObjectIdentifier, len(batch))
+		byKey := make(map[string]string, len(batch))
+		for i, pth := range batch {
+			key := p.buildKey(pth)
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+			byKey[key] = pth
+		}
+
+		out, err := p.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(p.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to delete %d objects: %w", len(batch), err)
+			for _, pth := range paths[start:] {
+				failures = append(failures, DeleteFailure{Path: pth, Err: err})
+			}
+			break
+		}
+		for _, e := range out.Errors {
+			pth, ok := byKey[aws.ToString(e.Key)]
+			if !ok {
+				pth = aws.ToString(e.Key)
+			}
+			failures = append(failures, DeleteFailure{
+				Path: pth,
+				Err:  &s3DeleteError{code: aws.ToString(e.Code), message: aws.ToString(e.Message)},
+			})
+		}
+	}
+	if len(failures) > 0 {
+		return &BatchDeleteError{Failures: failures}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestS3Provider_DeleteBatch(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method != http.MethodPost || !req.URL.Query().Has("delete") {
+			return nil
+		}
+		var body struct {
+			Quiet   bool `xml:"Quiet"`
+			Objects []struct {
+				Key string `xml:"Key"`
+			} `xml:"Object"`
+		}
+		if err := xml.NewDecoder(req.Body).Decode(&body); err != nil || !body.Quiet {
+			return xmlError(req, 400, "MalformedXML")
+		}
+		var keys []string
+		for _, o := range body.Objects {
+			keys = append(keys, o.Key)
+		}
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		result := `<DeleteResult>`
+		if keys[0] == "prefix/f0" {
+			result += `<Error><Key>prefix/f1</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`
+		}
+		result += `</DeleteResult>`
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(result)), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+	p.prefix = "prefix"
+
+	paths := make([]string, 2500)
+	for i := range paths {
+		paths[i] = "f" + strconv.Itoa(i)
+	}
+	err := p.DeleteBatch(context.Background(), paths)
+
+	if len(batches) != 3 || len(batches[0]) != 1000 || len(batches[1]) != 1000 || len(batches[2]) != 500 {
+		t.Fatalf("Expected batches of 1000, 1000 and 500 keys, got %d batches", len(batches))
+	}
+	var batch *BatchDeleteError
+	if !errors.As(err, &batch) || len(batch.Failures) != 1 || batch.Failures[0].Path != "f1" {
+		t.Fatalf("Expected f1 to be reported, got %v", err)
+	}
+	var apiErr interface{ ErrorCode() string }
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+		t.Errorf("Expected the per-key error code, got %v", err)
+	}
+}
+
+func TestS3Provider_DeleteBatchRequestFails(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		return xmlError(req, 403, "AccessDenied")
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	paths := make([]string, 1500)
+	for i := range paths {
+		paths[i] = strconv.Itoa(i)
+	}
+	err := p.DeleteBatch(context.Background(), paths)
+	var batch *BatchDeleteError
+	if !errors.As(err, &batch) || len(batch.Failures) != len(paths) {
+		t.Fatalf("Expected every key to be reported, got %v", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("Expected no requests after the failed one, got %d", len(fake.requests))
+	}
+}
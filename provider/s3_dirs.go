@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// folderSuffix marks the directory placeholders written by Hadoop's S3
+// filesystems and older s3fs: an object "dir_$folder$" stands for "dir/".
+const folderSuffix = "_$folder$"
+
+// directoryContentType is the content type s3fs and some consoles give a
+// placeholder object named after its directory without a trailing slash.
+const directoryContentType = "application/x-directory"
+
+// dirPlaceholder returns the directory a placeholder object named rel stands
+// for, whether it is written "dir/" or "dir_$folder$".
+func dirPlaceholder(rel string) (string, bool) {
+	switch {
+	case strings.HasSuffix(rel, "/"):
+		return strings.TrimSuffix(rel, "/"), true
+	case strings.HasSuffix(rel, folderSuffix):
+		return strings.TrimSuffix(rel, folderSuffix), true
+	}
+	return "", false
+}
+
+// dropShadowedPlaceholders removes the empty objects in infos named like a
+// directory also listed, which tools that write placeholders without a
+// trailing slash leave next to the directory's contents.
+func dropShadowedPlaceholders(infos []FileInfo, dirs map[string]bool) []FileInfo {
+	kept := infos[:0]
+	for _, info := range infos {
+		if !info.IsDir() && info.Size() == 0 && dirs[info.Name()] {
+			continue
+		}
+		kept = append(kept, info)
+	}
+	return kept
+}
+
+// MakeDir writes the placeholder object "dir/" standing for the directory
+// at path, carrying the user metadata of info, when the provider writes
+// placeholders (S3Options.DirPlaceholders). Otherwise directories exist
+// only through the keys below them, and MakeDir does nothing. The root of
+// the provider has no placeholder.
+func (p *S3Provider) MakeDir(ctx context.Context, path string, info FileInfo) error {
+	if !p.dirPlaceholders {
+		return nil
+	}
+	key := strings.TrimSuffix(p.buildKey(path), "/")
+	if key == "" || key == strings.TrimSuffix(p.prefix, "/") {
+		return nil
+	}
+	// S3 doesn't have true directories, but writing a 0-byte object ending in '/' simulates it
+	if _, err := p.client.PutObject(ctx, p.putInput(key+"/", strings.NewReader(""), info)); err != nil {
+		return fmt.Errorf("failed to write directory placeholder: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestS3Provider_DirPlaceholders(t *testing.T) {
+	ctx := context.Background()
+	dir := &localFileInfo{name: "d", isDir: true}
+
+	fake := &fakeS3{}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+	if _, err := p.OpenWrite(ctx, "d", dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("Expected no placeholder by default, got %d requests", len(fake.requests))
+	}
+
+	fake = &fakeS3{}
+	p = newFakeS3Provider(fake, "bucket", S3Options{DirPlaceholders: true})
+	if _, err := p.OpenWrite(ctx, "d", dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 1 || fake.requests[0].Method != http.MethodPut || fake.requests[0].URL.Path != "/bucket/d/" {
+		t.Errorf("Expected a PUT of d/, got %v", fake.requests)
+	}
+}
+
+func TestS3Provider_MakeDir(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	p := newFakeS3Provider(fake, "bucket", S3Options{DirPlaceholders: true})
+	p.prefix = "backup"
+	if caps := p.Capabilities(); !caps.Directories {
+		t.Error("Expected directories with placeholders")
+	}
+	for _, dir := range []string{"", "a/b"} {
+		if err := MakeDir(ctx, p, dir, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.requests) != 1 || fake.requests[0].URL.Path != "/bucket/backup/a/b/" {
+		t.Errorf("Expected one PUT of backup/a/b/ and none for the root, got %v", fake.requests)
+	}
+
+	if caps := newFakeS3Provider(&fakeS3{}, "bucket", S3Options{}).Capabilities(); caps.Directories {
+		t.Error("Expected no directories without placeholders")
+	}
+}
+
+func TestS3Provider_ListPlaceholderConventions(t *testing.T) {
+	listing := `<ListBucketResult><IsTruncated>false</IsTruncated>
+		<CommonPrefixes><Prefix>data/a/</Prefix></CommonPrefixes>
+		<CommonPrefixes><Prefix>data/b/</Prefix></CommonPrefixes>
+		<Contents><Key>data/</Key><Size>0</Size></Contents>
+		<Contents><Key>data/a</Key><Size>0</Size></Contents>
+		<Contents><Key>data/b_$folder$</Key><Size>0</Size></Contents>
+		<Contents><Key>data/c_$folder$</Key><Size>0</Size></Contents>
+		<Contents><Key>data/empty.txt</Key><Size>0</Size></Contents>
+		<Contents><Key>data/f.txt</Key><Size>4</Size></Contents></ListBucketResult>`
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(listing)), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	infos, err := p.List(context.Background(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, fmt.Sprintf("%s:%v", info.Name(), info.IsDir()))
+	}
+	sort.Strings(got)
+	want := "[a:true b:true c:true empty.txt:false f.txt:false]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+
+	var rels []string
+	err = p.ListAll(context.Background(), "data", func(rel string, info FileInfo) error {
+		rels = append(rels, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range rels {
+		if strings.HasSuffix(rel, folderSuffix) {
+			t.Errorf("Expected placeholders to be skipped by ListAll, got %v", rels)
+		}
+	}
+}
+
+func TestS3Provider_StatDirectoryContentType(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		header := http.Header{"Content-Type": {directoryContentType}, "Content-Length": {"0"}}
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	info, err := p.Stat(context.Background(), "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Error("Expected an application/x-directory object to be a directory")
+	}
+}
+
+func TestS3Provider_StatNotExist(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method == http.MethodHead {
+			return xmlError(req, 404, "NotFound")
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`<ListBucketResult></ListBucketResult>`)), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	if _, err := p.Stat(context.Background(), "missing.txt"); !IsNotExist(err) {
+		t.Errorf("Expected a missing object to satisfy IsNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultDownloadPartSize is the size of the ranges large objects are
+// fetched in when S3Options.DownloadPartSize is unset.
+const defaultDownloadPartSize = 16 * 1024 * 1024
+
+// openParallel opens an object for reading with ranged GETs. The first part
+// is requested straight away and streamed; its Content-Range tells the
+// object's size, and the parts after it are fetched concurrently while it is
+// read. Objects no larger than one part need only that request.
+func (p *S3Provider) openParallel(ctx context.Context, pth, key string) (io.ReadCloser, error) {
+	partSize := p.downloadPartSize
+	if partSize <= 0 {
+		partSize = defaultDownloadPartSize
+	}
+
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(rangeHeader(0, partSize)),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.GetObject(ctx, in)
+	if err != nil {
+		var apiErr interface{ ErrorCode() string }
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			// Empty objects have no first byte to range over
+			return p.openWhole(ctx, pth, key)
+		}
+		if archived := archivedError(pth, err); archived != nil {
+			return nil, archived
+		}
+		return nil, fmt.Errorf("failed to open read %q: %w", pth, err)
+	}
+
+	// Servers that ignore Range send the whole object
+	size, ok := contentRangeSize(aws.ToString(out.ContentRange))
+	if !ok || size <= partSize {
+		return out.Body, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &partReader{
+		ctx:         ctx,
+		cancel:      cancel,
+		current:     out.Body,
+		next:        partSize,
+		size:        size,
+		partSize:    partSize,
+		concurrency: p.downloadConcurrency,
+		fetch: func(ctx context.Context, offset, length int64) ([]byte, error) {
+			return p.fetchPart(ctx, pth, key, aws.ToString(out.ETag), offset, length)
+		},
+	}
+	r.fill()
+	return r, nil
+}
+
+// openWhole opens an object for reading with a single GET.
+func (p *S3Provider) openWhole(ctx context.Context, pth, key string) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.GetObject(ctx, in)
+	if err != nil {
+		if archived := archivedError(pth, err); archived != nil {
+			return nil, archived
+		}
+		return nil, fmt.Errorf("failed to open read %q: %w", pth, err)
+	}
+	return out.Body, nil
+}
+
+// fetchPart reads one range of an object into memory. etag, if set, makes
+// S3 refuse the request when the object has been replaced since the first
+// part was read, so parts of different versions are never joined.
+func (p *S3Provider) fetchPart(ctx context.Context, pth, key, etag string, offset, length int64) ([]byte, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(rangeHeader(offset, length)),
+	}
+	if etag != "" {
+		in.IfMatch = aws.String(etag)
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.GetObject(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q at offset %d: %w", pth, offset, err)
+	}
+	defer out.Body.Close()
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(out.Body, buf); err != nil {
+		return nil, fmt.Errorf("failed to read %q at offset %d: %w", pth, offset, err)
+	}
+	return buf, nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+// such as "bytes 0-99/1234".
+func contentRangeSize(header string) (int64, bool) {
+	i := strings.LastIndexByte(header, '/')
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+// partResult is a fetched part, or the error fetching it.
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// partReader reads an object as a sequence of parts, keeping up to
+// concurrency parts ahead of the reader in flight.
+type partReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	fetch  func(ctx context.Context, offset, length int64) ([]byte, error)
+
+	current     io.ReadCloser
+	pending     []chan partResult
+	next        int64 // offset of the next part to request
+	size        int64
+	partSize    int64
+	concurrency int
+	err         error
+}
+
+// fill starts fetching parts until concurrency are pending or the whole
+// object has been requested.
+func (r *partReader) fill() {
+	for len(r.pending) < r.concurrency && r.next < r.size {
+		offset, length := r.next, min(r.partSize, r.size-r.next)
+		r.next += length
+		ch := make(chan partResult, 1)
+		r.pending = append(r.pending, ch)
+		go func() {
+			data, err := r.fetch(r.ctx, offset, length)
+			ch <- partResult{data: data, err: err}
+		}()
+	}
+}
+
+func (r *partReader) Read(b []byte) (int, error) {
+	for r.err == nil {
+		if r.current != nil {
+			n, err := r.current.Read(b)
+			if err == io.EOF {
+				r.current.Close()
+				r.current = nil
+				if n == 0 {
+					continue
+				}
+				err = nil
+			}
+			return n, err
+		}
+
+		if len(r.pending) == 0 {
+			return 0, io.EOF
+		}
+		res := <-r.pending[0]
+		r.pending = r.pending[1:]
+		if res.err != nil {
+			r.err = res.err
+			break
+		}
+		r.current = io.NopCloser(bytes.NewReader(res.data))
+		r.fill()
+	}
+	return 0, r.err
+}
+
+// Close abandons the parts still in flight.
+func (r *partReader) Close() error {
+	r.cancel()
+	var err error
+	if r.current != nil {
+		err = r.current.Close()
+		r.current = nil
+	}
+	r.pending = nil
+	r.err = errors.New("read of closed object")
+	return err
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// rangeServer answers GETs for a single object, honouring Range and
+// If-Match like S3.
+func rangeServer(object []byte, etag string) func(*http.Request) *http.Response {
+	return func(req *http.Request) *http.Response {
+		if m := req.Header.Get("If-Match"); m != "" && m != etag {
+			return xmlError(req, http.StatusPreconditionFailed, "PreconditionFailed")
+		}
+		header := http.Header{"Etag": {etag}}
+		rng := req.Header.Get("Range")
+		if rng == "" {
+			return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(bytes.NewReader(object)), Request: req}
+		}
+		var start, end int64
+		fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		if start >= int64(len(object)) {
+			return xmlError(req, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+		}
+		end = min(end, int64(len(object))-1)
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(object)))
+		return &http.Response{StatusCode: 206, Header: header, Body: io.NopCloser(bytes.NewReader(object[start : end+1])), Request: req}
+	}
+}
+
+func xmlError(req *http.Request, status int, code string) *http.Response {
+	body := fmt.Sprintf("<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+	return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {"application/xml"}}, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+}
+
+func TestS3Provider_ParallelDownload(t *testing.T) {
+	object := make([]byte, 10*1000+7)
+	for i := range object {
+		object[i] = byte(i % 251)
+	}
+
+	for _, size := range []int{len(object), 500, 0} {
+		fake := &fakeS3{handler: rangeServer(object[:size], `"v1"`)}
+		p := newFakeS3Provider(fake, "bucket", S3Options{DownloadConcurrency: 3, DownloadPartSize: 1000})
+
+		rc, err := p.OpenRead(context.Background(), "big.bin")
+		if err != nil {
+			t.Fatalf("size %d: OpenRead failed: %v", size, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("size %d: read failed: %v", size, err)
+		}
+		if !bytes.Equal(got, object[:size]) {
+			t.Errorf("size %d: read %d bytes that differ from the object", size, len(got))
+		}
+
+		wantRequests := max(1, (size+999)/1000)
+		if size == 0 {
+			wantRequests = 2 // the ranged GET is refused, then the object is read whole
+		}
+		if len(fake.requests) != wantRequests {
+			t.Errorf("size %d: expected %d requests, got %d", size, wantRequests, len(fake.requests))
+		}
+	}
+}
+
+func TestS3Provider_ParallelDownloadChangedObject(t *testing.T) {
+	object := make([]byte, 6000)
+	fake := &fakeS3{handler: rangeServer(object, `"v1"`)}
+	p := newFakeS3Provider(fake, "bucket", S3Options{DownloadConcurrency: 2, DownloadPartSize: 1000})
+
+	rc, err := p.OpenRead(context.Background(), "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	fake.mu.Lock()
+	fake.handler = rangeServer(object, `"v2"`)
+	fake.mu.Unlock()
+
+	// The parts already requested may still see v1; later ones must not
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Skip("all parts were fetched before the object changed")
+	} else if !strings.Contains(err.Error(), "PreconditionFailed") {
+		t.Errorf("Expected the changed object to fail the read, got %v", err)
+	}
+}
+
+func TestContentRangeSize(t *testing.T) {
+	if size, ok := contentRangeSize("bytes 0-99/1234"); !ok || size != 1234 {
+		t.Errorf("Expected 1234, got %d, %v", size, ok)
+	}
+	for _, header := range []string{"", "bytes 0-99/*"} {
+		if _, ok := contentRangeSize(header); ok {
+			t.Errorf("Expected %q to have no size", header)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expressSuffix ends the names of S3 Express One Zone directory buckets,
+// which also carry the ID of their zone, e.g. "hot-data--use1-az4--x-s3".
+const expressSuffix = "--x-s3"
+
+// IsDirectoryBucket reports whether bucket names an S3 Express One Zone
+// directory bucket. The SDK sends requests for these to the zone's endpoint
+// and signs them with session credentials from CreateSession by itself.
+func IsDirectoryBucket(bucket string) bool {
+	return strings.HasSuffix(bucket, expressSuffix)
+}
+
+// validateExpress rejects options a directory bucket does not support.
+func validateExpress(bucket string, opts S3Options) error {
+	if !IsDirectoryBucket(bucket) {
+		return nil
+	}
+	if mode, _ := ParseSSEMode(opts.SSE); mode == SSEC {
+		return fmt.Errorf("directory bucket %s does not support sse-c encryption", bucket)
+	}
+	return nil
+}
+
+// sortExpressListing puts a listing of a directory bucket, which S3 returns
+// in no particular order, into the lexicographic order of general purpose
+// buckets.
+func (p *S3Provider) sortExpressListing(infos []FileInfo) {
+	if p.express {
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	}
+}
@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const expressBucket = "hot-data--use1-az4--x-s3"
+
+// sessionCredentials stands in for the session credentials CreateSession
+// hands out for directory buckets.
+type sessionCredentials struct{}
+
+func (sessionCredentials) Retrieve(ctx context.Context, bucket string) (aws.Credentials, error) {
+	return aws.Credentials{AccessKeyID: "SESSION", SecretAccessKey: "secret", SessionToken: "token"}, nil
+}
+
+func TestIsDirectoryBucket(t *testing.T) {
+	if !IsDirectoryBucket(expressBucket) {
+		t.Errorf("Expected %s to be a directory bucket", expressBucket)
+	}
+	for _, bucket := range []string{"bucket", "x-s3", "data--x-s3-archive"} {
+		if IsDirectoryBucket(bucket) {
+			t.Errorf("Expected %s to be a general purpose bucket", bucket)
+		}
+	}
+}
+
+func TestS3Provider_DirectoryBucketList(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		body := `<ListBucketResult>` +
+			`<Contents><Key>data/zeta.bin</Key><Size>1</Size></Contents>` +
+			`<Contents><Key>data/alpha.bin</Key><Size>2</Size></Contents>` +
+			`<CommonPrefixes><Prefix>data/mid/</Prefix></CommonPrefixes>` +
+			`</ListBucketResult>`
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+	}}
+	p := newFakeS3Provider(fake, expressBucket, S3Options{DirPlaceholders: true}).WithTags(true)
+	p.client = s3.New(p.client.Options(), func(o *s3.Options) {
+		o.ExpressCredentials = sessionCredentials{}
+	})
+
+	infos, err := p.List(context.Background(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.requests[0].Header.Get("X-Amz-S3session-Token"); got != "token" {
+		t.Errorf("Expected the request signed with a session, got token %q", got)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if strings.Join(names, ",") != "alpha.bin,mid,zeta.bin" {
+		t.Errorf("Expected the listing sorted, got %v", names)
+	}
+
+	caps := p.Capabilities()
+	if caps.Tags || caps.Versions || caps.Restore || p.dirPlaceholders {
+		t.Errorf("Expected no tags, versions, restores or placeholders, got %+v", caps)
+	}
+	if soft, err := p.SoftDeletes(context.Background()); err != nil || soft {
+		t.Errorf("Expected hard deletes, got %v, %v", soft, err)
+	}
+}
+
+func TestValidateExpress(t *testing.T) {
+	if err := validateExpress(expressBucket, S3Options{SSE: "sse-kms"}); err != nil {
+		t.Errorf("Expected SSE-KMS to be accepted, got %v", err)
+	}
+	if err := validateExpress(expressBucket, S3Options{SSE: "sse-c"}); err == nil {
+		t.Error("Expected SSE-C to be rejected")
+	}
+	if err := validateExpress("bucket", S3Options{SSE: "sse-c"}); err != nil {
+		t.Errorf("Expected general purpose buckets to be unaffected, got %v", err)
+	}
+}
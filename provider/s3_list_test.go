@@ -0,0 +1,50 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestS3Provider_ListAll(t *testing.T) {
+	pages := map[string]string{
+		"": `<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page2</NextContinuationToken>
+			<Contents><Key>data/a.txt</Key><Size>1</Size></Contents>
+			<Contents><Key>data/dir/</Key><Size>0</Size></Contents>
+			<Contents><Key>data/dir/b.txt</Key><Size>2</Size></Contents></ListBucketResult>`,
+		"page2": `<ListBucketResult><IsTruncated>false</IsTruncated>
+			<Contents><Key>data/dir/sub/c.txt</Key><Size>3</Size></Contents></ListBucketResult>`,
+	}
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		q := req.URL.Query()
+		if q.Get("delimiter") != "" || q.Get("prefix") != "data/" {
+			return xmlError(req, http.StatusBadRequest, "InvalidArgument")
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(pages[q.Get("continuation-token")])), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	var got []string
+	err := p.ListAll(context.Background(), "data", func(rel string, info FileInfo) error {
+		got = append(got, fmt.Sprintf("%s:%s:%d", rel, info.Name(), info.Size()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[a.txt:a.txt:1 dir/b.txt:b.txt:2 dir/sub/c.txt:c.txt:3]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("Expected two pages, got %d requests", len(fake.requests))
+	}
+
+	stop := fmt.Errorf("stop")
+	if err := p.ListAll(context.Background(), "data", func(string, FileInfo) error { return stop }); err != stop {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+}
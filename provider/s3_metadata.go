@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User metadata keys for POSIX metadata on S3 objects. They follow s3fs
+// and rclone: decimal uid and gid, mode as a decimal st_mode and mtime as
+// seconds since the epoch, so objects written by those tools restore too.
+const (
+	metaUID   = "uid"
+	metaGID   = "gid"
+	metaMode  = "mode"
+	metaMTime = "mtime"
+)
+
+// st_mode file type bits written with the permissions.
+const (
+	statRegular   = 0o100000
+	statDirectory = 0o040000
+)
+
+// WithMetadata makes the provider store the ownership, mode and
+// modification time of written files as user metadata (x-amz-meta-*), and
+// report them from Stat. List cannot return user metadata; callers that
+// need it Stat each object.
+func (p *S3Provider) WithMetadata(enabled bool) *S3Provider {
+	p.metadata = enabled
+	return p
+}
+
+// userMetadata encodes the POSIX metadata of info as user metadata, or
+// returns nil if the provider does not store it.
+func (p *S3Provider) userMetadata(info FileInfo) map[string]string {
+	if !p.metadata || info == nil {
+		return nil
+	}
+	meta := make(map[string]string)
+	if t := info.ModTime(); !t.IsZero() {
+		meta[metaMTime] = formatMTime(t)
+	}
+	if u, ok := info.(UnixFileInfo); ok {
+		meta[metaUID] = strconv.FormatUint(uint64(u.UID()), 10)
+		meta[metaGID] = strconv.FormatUint(uint64(u.GID()), 10)
+		if perm := u.Mode().Perm(); perm != 0 {
+			kind := uint32(statRegular)
+			if info.IsDir() {
+				kind = statDirectory
+			}
+			meta[metaMode] = strconv.FormatUint(uint64(kind|uint32(perm)), 10)
+		}
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// withUserMetadata returns info with the modification time, ownership and
+// mode recorded in meta. Values that do not parse are ignored.
+func withUserMetadata(info *s3FileInfo, meta map[string]string) FileInfo {
+	// S3 returns the keys in whatever case they were written
+	lower := make(map[string]string, len(meta))
+	for k, v := range meta {
+		lower[strings.ToLower(k)] = v
+	}
+
+	if t, ok := parseMTime(lower[metaMTime]); ok {
+		info.modTime = t
+	}
+	uid, uidErr := strconv.ParseUint(lower[metaUID], 10, 32)
+	gid, gidErr := strconv.ParseUint(lower[metaGID], 10, 32)
+	mode, modeErr := strconv.ParseUint(lower[metaMode], 10, 32)
+	if uidErr != nil && gidErr != nil && modeErr != nil {
+		return info
+	}
+	return NewUnixFileInfo(info, uint32(uid), uint32(gid), os.FileMode(mode).Perm())
+}
+
+// formatMTime renders t as seconds since the epoch with nanoseconds, e.g.
+// "1700000000.123456789".
+func formatMTime(t time.Time) string {
+	s := strconv.FormatInt(t.Unix(), 10)
+	if ns := t.Nanosecond(); ns != 0 {
+		s += "." + strings.TrimRight(strconv.FormatInt(int64(ns)+1e9, 10)[1:], "0")
+	}
+	return s
+}
+
+// parseMTime parses whole or fractional seconds since the epoch.
+func parseMTime(s string) (time.Time, bool) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(sec, nsec), true
+}
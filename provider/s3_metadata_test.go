@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3UserMetadata_RoundTrip(t *testing.T) {
+	mtime := time.Date(2023, 4, 5, 6, 7, 8, 120000000, time.UTC)
+	info := NewUnixFileInfo(&localFileInfo{name: "f", size: 3, modTime: mtime}, 1001, 1002, 0640)
+
+	p := (&S3Provider{}).WithMetadata(true)
+	meta := p.userMetadata(info)
+	if meta["uid"] != "1001" || meta["gid"] != "1002" || meta["mode"] != "33184" || meta["mtime"] != "1680674828.12" {
+		t.Fatalf("Unexpected user metadata %v", meta)
+	}
+	if (&S3Provider{}).userMetadata(info) != nil {
+		t.Error("Expected no user metadata unless enabled")
+	}
+
+	got := withUserMetadata(&s3FileInfo{name: "f", size: 3}, map[string]string{"Uid": "1001", "Gid": "1002", "Mode": "33184", "Mtime": "1680674828.12"})
+	unix, ok := got.(UnixFileInfo)
+	if !ok || unix.UID() != 1001 || unix.GID() != 1002 || unix.Mode() != 0640 || !unix.ModTime().Equal(mtime) {
+		t.Errorf("Unexpected restored info %+v", got)
+	}
+	if _, ok := withUserMetadata(&s3FileInfo{name: "f"}, nil).(UnixFileInfo); ok {
+		t.Error("Expected objects without user metadata to stay plain")
+	}
+}
+
+func TestParseMTime(t *testing.T) {
+	for in, want := range map[string]time.Time{
+		"1700000000":            time.Unix(1700000000, 0),
+		"1700000000.5":          time.Unix(1700000000, 500000000),
+		"1700000000.1234567891": time.Unix(1700000000, 123456789),
+	} {
+		if got, ok := parseMTime(in); !ok || !got.Equal(want) {
+			t.Errorf("parseMTime(%q) = %v, %v; want %v", in, got, ok, want)
+		}
+	}
+	if _, ok := parseMTime("yesterday"); ok {
+		t.Error("Expected an invalid mtime to be rejected")
+	}
+}
+
+func TestS3Provider_MetadataHeaders(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method != http.MethodHead {
+			return nil
+		}
+		header := http.Header{"X-Amz-Meta-Uid": {"7"}, "X-Amz-Meta-Gid": {"8"}, "X-Amz-Meta-Mode": {"33261"}, "Content-Length": {"3"}}
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{DirPlaceholders: true}).WithMetadata(true)
+
+	info, err := p.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unix, ok := info.(UnixFileInfo); !ok || unix.UID() != 7 || unix.GID() != 8 || unix.Mode() != 0755 {
+		t.Errorf("Expected metadata from the object, got %+v", info)
+	}
+
+	dir := NewUnixFileInfo(&localFileInfo{name: "d", isDir: true}, 7, 8, 0750)
+	if _, err := p.OpenWrite(ctx, "d", dir); err != nil {
+		t.Fatal(err)
+	}
+	req := fake.requests[len(fake.requests)-1]
+	if req.Header.Get("X-Amz-Meta-Uid") != "7" || req.Header.Get("X-Amz-Meta-Mode") != "16872" {
+		t.Errorf("Expected metadata headers on the write, got %v", req.Header)
+	}
+}
@@ -0,0 +1,230 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// RequestMutator changes an HTTP request to an object store just before it
+// is sent, after it has been signed.
+type RequestMutator func(*http.Request) error
+
+// S3Options configures an S3Provider beyond its bucket and prefix. It can be
+// loaded from a JSON provider profile with LoadS3Options.
+type S3Options struct {
+	// Endpoint is the URL of an S3-compatible service such as MinIO,
+	// Ceph or Wasabi, replacing the AWS endpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region overrides the region from the AWS configuration. It defaults
+	// to us-east-1 when an Endpoint is set and no region is configured,
+	// which most S3-compatible services accept.
+	Region string `json:"region,omitempty"`
+	// Profile selects a named profile from the shared AWS configuration
+	// and credentials files instead of the default one.
+	Profile string `json:"aws_profile,omitempty"`
+	// AccessKeyID, SecretAccessKey and SessionToken are static credentials
+	// used instead of the default credential chain.
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+	// RoleARN is a role assumed with the credentials above, e.g. in the
+	// account on the other side of a cross-account migration. ExternalID
+	// is passed along if the role's trust policy requires one;
+	// RoleSessionName names the session in CloudTrail.
+	RoleARN         string `json:"role_arn,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	RoleSessionName string `json:"role_session_name,omitempty"`
+	// UsePathStyle addresses buckets as endpoint/bucket/key instead of
+	// bucket.endpoint/key, as services without wildcard DNS require.
+	UsePathStyle bool `json:"path_style,omitempty"`
+	// DirPlaceholders makes directories written to the bucket create a
+	// zero-byte "dir/" object, as some tools expect. Without it nothing is
+	// written for a directory and it exists only through its files.
+	DirPlaceholders bool `json:"dir_placeholders,omitempty"`
+	// DownloadConcurrency is how many ranges of an object are fetched at
+	// once when it is read. 0 or 1 reads each object with a single GET.
+	DownloadConcurrency int `json:"download_concurrency,omitempty"`
+	// DownloadPartSize is the size of those ranges; it defaults to 16 MiB.
+	// Each read buffers up to DownloadConcurrency parts in memory.
+	DownloadPartSize int64 `json:"download_part_size,omitempty"`
+	// UploadPartSize is the size of the parts objects are uploaded in; it
+	// defaults to 5 MiB, the smallest S3 accepts, and is raised for files
+	// that would otherwise need more than MaxUploadParts parts.
+	UploadPartSize int64 `json:"upload_part_size,omitempty"`
+	// UploadConcurrency is how many parts of one object are uploaded at
+	// once; it defaults to 5. Each upload buffers that many parts.
+	UploadConcurrency int `json:"upload_concurrency,omitempty"`
+	// MaxUploadParts caps the parts of one upload, at most and by default
+	// 10000.
+	MaxUploadParts int `json:"max_upload_parts,omitempty"`
+	// SSE is the server-side encryption of written objects: none, sse-s3,
+	// sse-kms or sse-c. SSEKMSKeyID picks the KMS key for sse-kms instead
+	// of the bucket's default; SSECustomerKeyFile holds the base64 key for
+	// sse-c, which is then also sent with every read.
+	SSE                string `json:"sse,omitempty"`
+	SSEKMSKeyID        string `json:"sse_kms_key_id,omitempty"`
+	SSECustomerKeyFile string `json:"sse_c_key_file,omitempty"`
+	// Headers are set on every request before it is signed, e.g. tenant
+	// or tracing headers expected by an S3-compatible gateway.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Mutators run on every request after signing, e.g. to apply a custom
+	// auth scheme. They cannot be set from a profile.
+	Mutators []RequestMutator `json:"-"`
+}
+
+// LoadS3Options reads a provider profile: a JSON object with the fields of
+// S3Options.
+func LoadS3Options(file string) (S3Options, error) {
+	var opts S3Options
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return opts, fmt.Errorf("failed to read provider profile: %w", err)
+	}
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return opts, fmt.Errorf("failed to parse provider profile %s: %w", file, err)
+	}
+	return opts, nil
+}
+
+// WithDefaults returns opts with the fields it leaves unset taken from def.
+func (opts S3Options) WithDefaults(def S3Options) S3Options {
+	if opts.Endpoint == "" {
+		opts.Endpoint = def.Endpoint
+	}
+	if opts.Region == "" {
+		opts.Region = def.Region
+	}
+	if opts.Profile == "" {
+		opts.Profile = def.Profile
+	}
+	// Static credentials only make sense as a whole
+	if opts.AccessKeyID == "" {
+		opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken = def.AccessKeyID, def.SecretAccessKey, def.SessionToken
+	}
+	if opts.RoleARN == "" {
+		opts.RoleARN, opts.ExternalID, opts.RoleSessionName = def.RoleARN, def.ExternalID, def.RoleSessionName
+	}
+	opts.UsePathStyle = opts.UsePathStyle || def.UsePathStyle
+	opts.DirPlaceholders = opts.DirPlaceholders || def.DirPlaceholders
+	if opts.DownloadConcurrency == 0 {
+		opts.DownloadConcurrency = def.DownloadConcurrency
+	}
+	if opts.DownloadPartSize == 0 {
+		opts.DownloadPartSize = def.DownloadPartSize
+	}
+	if opts.UploadPartSize == 0 {
+		opts.UploadPartSize = def.UploadPartSize
+	}
+	if opts.UploadConcurrency == 0 {
+		opts.UploadConcurrency = def.UploadConcurrency
+	}
+	if opts.MaxUploadParts == 0 {
+		opts.MaxUploadParts = def.MaxUploadParts
+	}
+	if opts.SSE == "" {
+		opts.SSE = def.SSE
+	}
+	if opts.SSEKMSKeyID == "" {
+		opts.SSEKMSKeyID = def.SSEKMSKeyID
+	}
+	if opts.SSECustomerKeyFile == "" {
+		opts.SSECustomerKeyFile = def.SSECustomerKeyFile
+	}
+	if opts.Headers == nil {
+		opts.Headers = def.Headers
+	}
+	if opts.Mutators == nil {
+		opts.Mutators = def.Mutators
+	}
+	return opts
+}
+
+// defaultCompatibleRegion is the region used for custom endpoints when
+// none is configured.
+const defaultCompatibleRegion = "us-east-1"
+
+// clientOptions returns the S3 client settings implementing opts.
+func (opts S3Options) clientOptions(o *s3.Options) {
+	if opts.Endpoint != "" {
+		o.BaseEndpoint = aws.String(opts.Endpoint)
+		if o.Region == "" {
+			o.Region = defaultCompatibleRegion
+		}
+	}
+	if opts.Region != "" {
+		o.Region = opts.Region
+	}
+	if opts.UsePathStyle {
+		o.UsePathStyle = true
+	}
+
+	if len(opts.Headers) > 0 {
+		headers := opts.Headers
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("gofastHeaders",
+				func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+					if req, ok := in.Request.(*smithyhttp.Request); ok {
+						for k, v := range headers {
+							req.Header.Set(k, v)
+						}
+					}
+					return next.HandleBuild(ctx, in)
+				}), middleware.After)
+		})
+	}
+
+	if len(opts.Mutators) > 0 {
+		mutators := opts.Mutators
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			// Finalize runs once per attempt; adding at the end places
+			// the mutators after the signer
+			return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("gofastMutators",
+				func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+					if req, ok := in.Request.(*smithyhttp.Request); ok {
+						for _, mutate := range mutators {
+							if err := mutate(req.Request); err != nil {
+								return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("request mutator: %w", err)
+							}
+						}
+					}
+					return next.HandleFinalize(ctx, in)
+				}), middleware.After)
+		})
+	}
+}
+
+// validateUpload checks the upload tuning of opts against the limits of S3.
+func (opts S3Options) validateUpload() error {
+	if opts.UploadPartSize != 0 && opts.UploadPartSize < manager.MinUploadPartSize {
+		return fmt.Errorf("upload part size %d is below the S3 minimum of %d bytes", opts.UploadPartSize, manager.MinUploadPartSize)
+	}
+	if opts.UploadConcurrency < 0 {
+		return fmt.Errorf("upload concurrency must not be negative")
+	}
+	if opts.MaxUploadParts < 0 || opts.MaxUploadParts > maxUploadParts {
+		return fmt.Errorf("max upload parts must be between 1 and %d", maxUploadParts)
+	}
+	return nil
+}
+
+// uploaderOptions returns the upload manager settings implementing opts.
+func (opts S3Options) uploaderOptions(u *manager.Uploader) {
+	if opts.UploadPartSize > 0 {
+		u.PartSize = opts.UploadPartSize
+	}
+	if opts.UploadConcurrency > 0 {
+		u.Concurrency = opts.UploadConcurrency
+	}
+	if opts.MaxUploadParts > 0 {
+		u.MaxUploadParts = int32(opts.MaxUploadParts)
+	}
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 answers S3 requests in-process and records them.
+type fakeS3 struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	handler  func(*http.Request) *http.Response
+}
+
+func (f *fakeS3) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	handler := f.handler
+	f.mu.Unlock()
+	if handler != nil {
+		if resp := handler(req); resp != nil {
+			return resp, nil
+		}
+	}
+	return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+// newFakeS3Provider returns an S3Provider for bucket whose client talks to
+// fake, configured by opts.
+func newFakeS3Provider(fake *fakeS3, bucket string, opts S3Options) *S3Provider {
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String("http://s3.test"),
+		UsePathStyle: true,
+		HTTPClient:   fake,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+	}, opts.clientOptions)
+	return &S3Provider{
+		client:              client,
+		bucket:              bucket,
+		uploader:            manager.NewUploader(client),
+		downloadConcurrency: opts.DownloadConcurrency,
+		downloadPartSize:    opts.DownloadPartSize,
+		dirPlaceholders:     opts.DirPlaceholders && !IsDirectoryBucket(bucket),
+		express:             IsDirectoryBucket(bucket),
+	}
+}
+
+func TestS3Options_HeadersAndMutators(t *testing.T) {
+	fake := &fakeS3{}
+	var signedBeforeMutator bool
+	p := newFakeS3Provider(fake, "bucket", S3Options{
+		Headers: map[string]string{"X-Tenant-Id": "acme"},
+		Mutators: []RequestMutator{func(req *http.Request) error {
+			signedBeforeMutator = strings.Contains(req.Header.Get("Authorization"), "x-tenant-id")
+			req.Header.Set("X-Trace-Id", "trace-1")
+			return nil
+		}},
+	})
+
+	if _, err := p.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("a.txt"),
+	}); err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+
+	if len(fake.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(fake.requests))
+	}
+	req := fake.requests[0]
+	if got := req.Header.Get("X-Tenant-Id"); got != "acme" {
+		t.Errorf("X-Tenant-Id = %q; want acme", got)
+	}
+	if got := req.Header.Get("X-Trace-Id"); got != "trace-1" {
+		t.Errorf("X-Trace-Id = %q; want trace-1", got)
+	}
+	if !signedBeforeMutator {
+		t.Error("expected the custom header to be signed before the mutator ran")
+	}
+}
+
+func TestS3Options_Endpoint(t *testing.T) {
+	fake := &fakeS3{}
+	p := newFakeS3Provider(fake, "bucket", S3Options{
+		Endpoint:     "http://minio.test:9000",
+		Region:       "eu-west-1",
+		UsePathStyle: true,
+	})
+
+	if _, err := p.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("a.txt"),
+	}); err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+
+	req := fake.requests[0]
+	if req.URL.Host != "minio.test:9000" || req.URL.Path != "/bucket/a.txt" {
+		t.Errorf("request went to %s; want minio.test:9000/bucket/a.txt", req.URL)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "/eu-west-1/s3/") {
+		t.Errorf("expected the request to be signed for eu-west-1, got %q", auth)
+	}
+}
+
+func TestS3Options_WithDefaults(t *testing.T) {
+	def := S3Options{Endpoint: "http://env.test", Region: "eu-west-1", UsePathStyle: true}
+	got := S3Options{Endpoint: "http://profile.test"}.WithDefaults(def)
+	if got.Endpoint != "http://profile.test" || got.Region != "eu-west-1" || !got.UsePathStyle {
+		t.Errorf("unexpected merged options %+v", got)
+	}
+}
+
+func TestS3Options_Upload(t *testing.T) {
+	for _, opts := range []S3Options{
+		{UploadPartSize: 1024},
+		{UploadConcurrency: -1},
+		{MaxUploadParts: 20000},
+	} {
+		if err := opts.validateUpload(); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+
+	opts := S3Options{UploadPartSize: 64 << 20, UploadConcurrency: 16, MaxUploadParts: 100}
+	if err := opts.validateUpload(); err != nil {
+		t.Fatal(err)
+	}
+	u := manager.NewUploader(s3.New(s3.Options{}), opts.uploaderOptions)
+	if u.PartSize != 64<<20 || u.Concurrency != 16 || u.MaxUploadParts != 100 {
+		t.Errorf("Uploader not configured: part size %d, concurrency %d, max parts %d", u.PartSize, u.Concurrency, u.MaxUploadParts)
+	}
+
+	p := &S3Provider{uploadPartSize: opts.UploadPartSize, maxUploadParts: opts.MaxUploadParts}
+	if got := p.partSizeFor(100 * 128 << 20); got != 128<<20 {
+		t.Errorf("Expected a 12.5 GiB file to be split into 100 parts, got part size %d", got)
+	}
+	if got := p.partSizeFor(1 << 20); got != 64<<20 {
+		t.Errorf("Expected the configured part size, got %d", got)
+	}
+}
+
+func TestLoadS3Options(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(file, []byte(`{"headers": {"X-Tenant-Id": "acme"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := LoadS3Options(file)
+	if err != nil {
+		t.Fatalf("LoadS3Options failed: %v", err)
+	}
+	if opts.Headers["X-Tenant-Id"] != "acme" {
+		t.Errorf("unexpected headers %v", opts.Headers)
+	}
+
+	if err := os.WriteFile(file, []byte(`{"headers": [}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadS3Options(file); err == nil {
+		t.Error("expected a malformed profile to fail")
+	}
+}
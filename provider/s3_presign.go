@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var _ Presigner = (*S3Provider)(nil)
+
+// maxPutObjectSize is the largest object a single PutObject can write;
+// larger ones need a multipart upload, which cannot be presigned in one
+// request.
+const maxPutObjectSize = 5 * 1024 * 1024 * 1024
+
+// PresignGet signs a GetObject request, with the provider's SSE-C key if
+// it reads encrypted objects.
+func (p *S3Provider) PresignGet(ctx context.Context, pth string, ttl time.Duration) (PresignedRequest, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(p.buildKey(pth)),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	req, err := s3.NewPresignClient(p.client).PresignGetObject(ctx, in, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return PresignedRequest{}, fmt.Errorf("failed to presign read of %q: %w", pth, err)
+	}
+	return presigned(req, ttl), nil
+}
+
+// PresignPut signs a PutObject request carrying the provider's encryption
+// settings and the user metadata and tags of info. Unlike OpenWrite, no
+// checksum is stored: it would have to be known when signing.
+func (p *S3Provider) PresignPut(ctx context.Context, pth string, info FileInfo, ttl time.Duration) (PresignedRequest, error) {
+	if info != nil && info.Size() > maxPutObjectSize {
+		return PresignedRequest{}, fmt.Errorf("cannot presign write of %q: %d bytes is over the 5 GiB limit of a single PUT", pth, info.Size())
+	}
+	in := p.putInput(p.buildKey(pth), nil, info)
+	in.ChecksumAlgorithm = ""
+	req, err := s3.NewPresignClient(p.client).PresignPutObject(ctx, in, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return PresignedRequest{}, fmt.Errorf("failed to presign write of %q: %w", pth, err)
+	}
+	return presigned(req, ttl), nil
+}
+
+func presigned(req *v4.PresignedHTTPRequest, ttl time.Duration) PresignedRequest {
+	return PresignedRequest{
+		Method:  req.Method,
+		URL:     req.URL,
+		Header:  req.SignedHeader,
+		Expires: time.Now().Add(ttl),
+	}
+}
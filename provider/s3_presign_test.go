@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3PresignGet(t *testing.T) {
+	fake := &fakeS3{}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+	p.prefix = "data"
+
+	req, err := p.PresignGet(context.Background(), "dir/a.txt", 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if req.Method != http.MethodGet || u.Path != "/bucket/data/dir/a.txt" {
+		t.Errorf("Expected a GET of data/dir/a.txt, got %s %s", req.Method, u.Path)
+	}
+	if q.Get("X-Amz-Signature") == "" || q.Get("X-Amz-Expires") != "900" {
+		t.Errorf("Expected a signature valid for 900s, got %v", q)
+	}
+	if req.Expires.Before(time.Now().Add(14 * time.Minute)) {
+		t.Errorf("Expected the request to expire in 15 minutes, got %v", req.Expires)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("Expected presigning to send no requests, got %d", len(fake.requests))
+	}
+}
+
+func TestS3PresignPut(t *testing.T) {
+	p := newFakeS3Provider(&fakeS3{}, "bucket", S3Options{}).WithMetadata(true)
+	mtime := time.Unix(1700000000, 0)
+	info := NewUnixFileInfo(&localFileInfo{name: "a.txt", size: 3, modTime: mtime}, 1001, 1002, 0640)
+
+	req, err := p.PresignPut(context.Background(), "a.txt", info, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPut {
+		t.Errorf("Expected a PUT, got %s", req.Method)
+	}
+	if got := req.Header.Get("X-Amz-Meta-Mtime"); got != "1700000000" {
+		t.Errorf("Expected the mtime to be a signed header, got %q in %v", got, req.Header)
+	}
+	if strings.Contains(strings.ToLower(req.URL), "checksum") {
+		t.Errorf("Expected no checksum to be required, got %s", req.URL)
+	}
+
+	big := &localFileInfo{name: "big", size: maxPutObjectSize + 1}
+	if _, err := p.PresignPut(context.Background(), "big", big, time.Hour); err == nil {
+		t.Error("Expected objects over 5 GiB to be refused")
+	}
+}
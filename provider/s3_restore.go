@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var _ Restorer = (*S3Provider)(nil)
+
+// defaultRestoreDays is how long a restored copy is kept when
+// RestoreOptions.Days is unset.
+const defaultRestoreDays = 1
+
+func (f *s3FileInfo) ArchiveState() (storageClass string, archived, restoring bool) {
+	return f.storageClass, f.archived, f.restoring
+}
+
+// headArchiveState reads the archive state of an object from HeadObject:
+// objects in GLACIER or DEEP_ARCHIVE, or in an archive tier of
+// INTELLIGENT_TIERING, are archived until a restore completes. The Restore
+// header reads ongoing-request="true" while one runs and
+// ongoing-request="false" once the restored copy can be read.
+func headArchiveState(out *s3.HeadObjectOutput) (storageClass string, archived, restoring bool) {
+	restore := aws.ToString(out.Restore)
+	restored := strings.Contains(restore, `ongoing-request="false"`)
+	restoring = strings.Contains(restore, `ongoing-request="true"`)
+
+	switch {
+	case out.ArchiveStatus != "":
+		return string(out.ArchiveStatus), !restored, restoring
+	case out.StorageClass == types.StorageClassGlacier || out.StorageClass == types.StorageClassDeepArchive:
+		return string(out.StorageClass), !restored, restoring
+	}
+	return string(out.StorageClass), false, false
+}
+
+// listArchiveState reads the archive state of a listed object. Listings do
+// not say whether an object has been restored, so every object in GLACIER or
+// DEEP_ARCHIVE is reported archived; Stat tells for certain.
+func listArchiveState(class types.ObjectStorageClass) (storageClass string, archived bool) {
+	return string(class), class == types.ObjectStorageClassGlacier || class == types.ObjectStorageClassDeepArchive
+}
+
+// archivedError returns an *ArchivedError if err is the InvalidObjectState
+// error S3 fails reads and copies of archived objects with, or nil.
+func archivedError(pth string, err error) *ArchivedError {
+	var state *types.InvalidObjectState
+	if errors.As(err, &state) {
+		class := string(state.StorageClass)
+		if state.AccessTier != "" {
+			class = string(state.AccessTier)
+		}
+		return &ArchivedError{Path: pth, StorageClass: class}
+	}
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState" {
+		return &ArchivedError{Path: pth, StorageClass: "archive"}
+	}
+	return nil
+}
+
+// Restore requests a temporary readable copy of an archived object with
+// RestoreObject. Objects that are readable, or already being restored, need
+// no request. Objects in an INTELLIGENT_TIERING archive tier move back to a
+// frequent access tier instead, so Days does not apply to them.
+func (p *S3Provider) Restore(ctx context.Context, pth string, opts RestoreOptions) error {
+	info, err := p.Stat(ctx, pth)
+	if err != nil {
+		return err
+	}
+	archived := Archived(pth, info)
+	if archived == nil || archived.Restoring {
+		return nil
+	}
+
+	req := &types.RestoreRequest{}
+	if opts.Tier != "" {
+		req.GlacierJobParameters = &types.GlacierJobParameters{Tier: types.Tier(opts.Tier)}
+	}
+	switch types.ArchiveStatus(archived.StorageClass) {
+	case types.ArchiveStatusArchiveAccess, types.ArchiveStatusDeepArchiveAccess:
+	default:
+		days := opts.Days
+		if days <= 0 {
+			days = defaultRestoreDays
+		}
+		req.Days = aws.Int32(days)
+	}
+
+	_, err = p.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(p.bucket),
+		Key:            aws.String(p.buildKey(pth)),
+		RestoreRequest: req,
+	})
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore %q: %w", pth, err)
+	}
+	return nil
+}
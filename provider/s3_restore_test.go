@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// glacierServer answers HeadObject for an object in storageClass with the
+// given x-amz-restore header, and accepts RestoreObject requests.
+func glacierServer(storageClass, restore string, restores *[]string) func(*http.Request) *http.Response {
+	return func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodHead:
+			h := http.Header{"Content-Length": {"42"}, "X-Amz-Storage-Class": {storageClass}}
+			if restore != "" {
+				h.Set("X-Amz-Restore", restore)
+			}
+			return &http.Response{StatusCode: 200, Header: h, Body: io.NopCloser(strings.NewReader("")), Request: req}
+		case req.Method == http.MethodPost && req.URL.Query().Has("restore"):
+			body, _ := io.ReadAll(req.Body)
+			*restores = append(*restores, string(body))
+			return &http.Response{StatusCode: 202, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}
+		case req.Method == http.MethodGet:
+			body := "<Error><Code>InvalidObjectState</Code><StorageClass>" + storageClass + "</StorageClass></Error>"
+			return &http.Response{StatusCode: 403, Header: http.Header{"Content-Type": {"application/xml"}}, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+		}
+		return nil
+	}
+}
+
+func TestS3Provider_StatArchived(t *testing.T) {
+	tests := []struct {
+		class, restore string
+		archived       bool
+		restoring      bool
+	}{
+		{"STANDARD", "", false, false},
+		{"GLACIER_IR", "", false, false},
+		{"GLACIER", "", true, false},
+		{"DEEP_ARCHIVE", `ongoing-request="true"`, true, true},
+		{"GLACIER", `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, false, false},
+	}
+	for _, tt := range tests {
+		fake := &fakeS3{handler: glacierServer(tt.class, tt.restore, nil)}
+		p := newFakeS3Provider(fake, "bucket", S3Options{})
+		info, err := p.Stat(context.Background(), "cold.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		archived := Archived("cold.bin", info)
+		if (archived != nil) != tt.archived {
+			t.Errorf("%s %q: archived = %v, want %v", tt.class, tt.restore, archived, tt.archived)
+			continue
+		}
+		if archived != nil && (archived.StorageClass != tt.class || archived.Restoring != tt.restoring) {
+			t.Errorf("%s %q: got %+v", tt.class, tt.restore, archived)
+		}
+	}
+
+	// User metadata wraps the info without hiding its archive state
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		resp := glacierServer("GLACIER", "", nil)(req)
+		resp.Header.Set("X-Amz-Meta-Uid", "1000")
+		return resp
+	}}
+	info, err := newFakeS3Provider(fake, "bucket", S3Options{}).WithMetadata(true).Stat(context.Background(), "cold.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := info.(UnixFileInfo); !ok || Archived("cold.bin", info) == nil {
+		t.Errorf("Expected archived UnixFileInfo, got %#v", info)
+	}
+}
+
+func TestS3Provider_Restore(t *testing.T) {
+	var restores []string
+	fake := &fakeS3{handler: glacierServer("GLACIER", "", &restores)}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	if err := p.Restore(context.Background(), "cold.bin", RestoreOptions{Tier: RestoreBulk, Days: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if len(restores) != 1 || !strings.Contains(restores[0], "<Days>3</Days>") || !strings.Contains(restores[0], "<Tier>Bulk</Tier>") {
+		t.Fatalf("Unexpected restore requests %q", restores)
+	}
+
+	// Restores in progress are not requested again
+	restores = nil
+	fake.handler = glacierServer("GLACIER", `ongoing-request="true"`, &restores)
+	if err := p.Restore(context.Background(), "cold.bin", RestoreOptions{}); err != nil || len(restores) != 0 {
+		t.Errorf("Expected no request for a restore in progress, got %q, %v", restores, err)
+	}
+
+	// Racing another client's request is not an error
+	fake.handler = func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return xmlError(req, http.StatusConflict, "RestoreAlreadyInProgress")
+		}
+		return glacierServer("GLACIER", "", nil)(req)
+	}
+	if err := p.Restore(context.Background(), "cold.bin", RestoreOptions{}); err != nil {
+		t.Errorf("Expected RestoreAlreadyInProgress to succeed, got %v", err)
+	}
+}
+
+func TestS3Provider_OpenReadArchived(t *testing.T) {
+	fake := &fakeS3{handler: glacierServer("DEEP_ARCHIVE", "", nil)}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	_, err := p.OpenRead(context.Background(), "cold.bin")
+	var archived *ArchivedError
+	if !errors.As(err, &archived) || archived.StorageClass != "DEEP_ARCHIVE" {
+		t.Fatalf("Expected ArchivedError, got %v", err)
+	}
+}
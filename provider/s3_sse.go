@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// SSEMode selects how S3 encrypts the objects gofast writes.
+type SSEMode string
+
+const (
+	// SSENone leaves encryption to the bucket's default.
+	SSENone SSEMode = ""
+	// SSES3 encrypts with keys managed by S3 (AES256).
+	SSES3 SSEMode = "sse-s3"
+	// SSEKMS encrypts with a KMS key, the bucket's or S3Options.SSEKMSKeyID.
+	SSEKMS SSEMode = "sse-kms"
+	// SSEC encrypts with a key supplied by the client on every request,
+	// reads included; S3 does not store it.
+	SSEC SSEMode = "sse-c"
+)
+
+// ParseSSEMode parses an encryption mode; "none" is accepted for SSENone.
+func ParseSSEMode(s string) (SSEMode, error) {
+	switch m := SSEMode(s); m {
+	case SSENone, SSES3, SSEKMS, SSEC:
+		return m, nil
+	case "none":
+		return SSENone, nil
+	}
+	return "", fmt.Errorf("unknown server-side encryption %q (want none, sse-s3, sse-kms or sse-c)", s)
+}
+
+// sseCustomerKeySize is the size of an SSE-C key: AES-256.
+const sseCustomerKeySize = 32
+
+// serverSideEncryption holds the encryption settings of an S3Provider.
+type serverSideEncryption struct {
+	mode     SSEMode
+	kmsKeyID string
+	// customerKey and customerKeyMD5 are the base64 SSE-C key and its
+	// digest, as sent in headers.
+	customerKey    string
+	customerKeyMD5 string
+}
+
+// newServerSideEncryption validates the encryption options and loads the
+// SSE-C key file, which holds a base64-encoded 256-bit key.
+func newServerSideEncryption(opts S3Options) (serverSideEncryption, error) {
+	mode, err := ParseSSEMode(opts.SSE)
+	if err != nil {
+		return serverSideEncryption{}, err
+	}
+	sse := serverSideEncryption{mode: mode, kmsKeyID: opts.SSEKMSKeyID}
+	if sse.kmsKeyID != "" && mode != SSEKMS {
+		return serverSideEncryption{}, fmt.Errorf("a KMS key ID requires sse-kms encryption")
+	}
+	if mode != SSEC {
+		return sse, nil
+	}
+
+	if opts.SSECustomerKeyFile == "" {
+		return serverSideEncryption{}, fmt.Errorf("sse-c encryption requires a key file")
+	}
+	data, err := os.ReadFile(opts.SSECustomerKeyFile)
+	if err != nil {
+		return serverSideEncryption{}, fmt.Errorf("failed to read SSE-C key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != sseCustomerKeySize {
+		return serverSideEncryption{}, fmt.Errorf("SSE-C key file %s must hold a base64-encoded %d-byte key", opts.SSECustomerKeyFile, sseCustomerKeySize)
+	}
+	sum := md5.Sum(key)
+	sse.customerKey = base64.StdEncoding.EncodeToString(key)
+	sse.customerKeyMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	return sse, nil
+}
+
+// write returns the encryption and KMS key to request for new objects.
+func (e serverSideEncryption) write() (types.ServerSideEncryption, *string) {
+	switch e.mode {
+	case SSES3:
+		return types.ServerSideEncryptionAes256, nil
+	case SSEKMS:
+		if e.kmsKeyID != "" {
+			return types.ServerSideEncryptionAwsKms, aws.String(e.kmsKeyID)
+		}
+		return types.ServerSideEncryptionAwsKms, nil
+	}
+	return "", nil
+}
+
+// customer returns the SSE-C algorithm, key and key MD5 to send with every
+// request on an object's content, or nils without SSE-C.
+func (e serverSideEncryption) customer() (algorithm, key, keyMD5 *string) {
+	if e.mode != SSEC {
+		return nil, nil, nil
+	}
+	return aws.String("AES256"), aws.String(e.customerKey), aws.String(e.customerKeyMD5)
+}
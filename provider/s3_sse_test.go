@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSSEMode(t *testing.T) {
+	for in, want := range map[string]SSEMode{"": SSENone, "none": SSENone, "sse-kms": SSEKMS, "sse-c": SSEC} {
+		if got, err := ParseSSEMode(in); err != nil || got != want {
+			t.Errorf("ParseSSEMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseSSEMode("aes"); err == nil {
+		t.Error("Expected an unknown mode to fail")
+	}
+}
+
+func TestNewServerSideEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	key := make([]byte, 32)
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	shortKey := filepath.Join(dir, "short")
+	if err := os.WriteFile(shortKey, []byte(base64.StdEncoding.EncodeToString(key[:16])), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newServerSideEncryption(S3Options{SSE: "sse-c", SSECustomerKeyFile: keyFile}); err != nil {
+		t.Errorf("Expected a valid key file to load: %v", err)
+	}
+	for name, opts := range map[string]S3Options{
+		"no key file": {SSE: "sse-c"},
+		"short key":   {SSE: "sse-c", SSECustomerKeyFile: shortKey},
+		"stray kms":   {SSE: "sse-s3", SSEKMSKeyID: "alias/x"},
+	} {
+		if _, err := newServerSideEncryption(opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestS3Provider_SSEHeaders(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	p := newFakeS3Provider(fake, "bucket", S3Options{DirPlaceholders: true})
+	p.sse, _ = newServerSideEncryption(S3Options{SSE: "sse-kms", SSEKMSKeyID: "alias/migration"})
+	if _, err := p.OpenWrite(ctx, "dir", &s3FileInfo{name: "dir", isDir: true}); err != nil {
+		t.Fatal(err)
+	}
+	req := fake.requests[0]
+	if req.Header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" || req.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "alias/migration" {
+		t.Errorf("Expected SSE-KMS headers on the write, got %v", req.Header)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))), 0600)
+	fake = &fakeS3{}
+	p = newFakeS3Provider(fake, "bucket", S3Options{})
+	p.sse, _ = newServerSideEncryption(S3Options{SSE: "sse-c", SSECustomerKeyFile: keyFile})
+	rc, err := p.OpenRead(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, rc)
+	rc.Close()
+	req = fake.requests[0]
+	if req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" || req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") == "" {
+		t.Errorf("Expected SSE-C headers on the read, got %v", req.Header)
+	}
+}
@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// WithTags makes the provider report object tags from Stat and apply the
+// tags of the source to objects it writes, so cost allocation and lifecycle
+// tags survive a migration. Server-side copies keep the source's tags by
+// themselves; with tags disabled they are dropped instead. Directory
+// buckets do not support tags, so it has no effect on them.
+func (p *S3Provider) WithTags(enabled bool) *S3Provider {
+	p.tags = enabled && !p.express
+	return p
+}
+
+func (f *s3FileInfo) Tags() (map[string]string, bool) { return f.tags, f.tagged }
+
+// objectTags reads the tags of an object with GetObjectTagging. count is the
+// tag count HeadObject reported; objects without tags need no request.
+func (p *S3Provider) objectTags(ctx context.Context, pth, key string, count int32) (map[string]string, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	out, err := p.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags of %q: %w", pth, err)
+	}
+	tags := make(map[string]string, len(out.TagSet))
+	for _, tag := range out.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// tagging encodes the tags of info as the URL query string PutObject and
+// CreateMultipartUpload take, or returns nil if the provider does not write
+// tags or info has none.
+func (p *S3Provider) tagging(info FileInfo) *string {
+	if !p.tags || info == nil {
+		return nil
+	}
+	tags, _ := ObjectTags(info)
+	if len(tags) == 0 {
+		return nil
+	}
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	// Encode escapes spaces as "+", which S3 would keep as a literal plus
+	return aws.String(strings.ReplaceAll(values.Encode(), "+", "%20"))
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestS3Provider_Tags(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodHead:
+			header := http.Header{"Content-Length": {"3"}, "X-Amz-Tagging-Count": {"2"}}
+			return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}
+		case req.Method == http.MethodGet && req.URL.Query().Has("tagging"):
+			body := `<Tagging><TagSet><Tag><Key>cost-center</Key><Value>R&amp;D 42</Value></Tag><Tag><Key>expire</Key><Value>90d</Value></Tag></TagSet></Tagging>`
+			return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+		}
+		return nil
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{DirPlaceholders: true}).WithTags(true)
+
+	info, err := p.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, ok := ObjectTags(info)
+	if !ok || len(tags) != 2 || tags["cost-center"] != "R&D 42" || tags["expire"] != "90d" {
+		t.Fatalf("Expected the object's tags, got %v, %v", tags, ok)
+	}
+
+	// Tags survive the metadata rewrites the engine makes
+	info = WithModTime(info, info.ModTime())
+	if tags, ok := ObjectTags(info); !ok || len(tags) != 2 {
+		t.Fatalf("Expected tags kept, got %v, %v", tags, ok)
+	}
+
+	fake.requests = nil
+	if _, err := p.OpenWrite(ctx, "d", &taggedFileInfo{FileInfo: &localFileInfo{name: "d", isDir: true}, tags: tags}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.requests[0].Header.Get("X-Amz-Tagging"); got != "cost-center=R%26D%2042&expire=90d" {
+		t.Errorf("Unexpected tagging header %q", got)
+	}
+
+	// Without tags none are read or written
+	p.WithTags(false)
+	fake.requests = nil
+	if info, err = p.Stat(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ObjectTags(info); ok || len(fake.requests) != 1 {
+		t.Errorf("Expected no tags looked up, got %d requests", len(fake.requests))
+	}
+	if p.tagging(&taggedFileInfo{FileInfo: info, tags: tags}) != nil {
+		t.Error("Expected no tagging written")
+	}
+}
+
+func TestS3Provider_CopyFromTags(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("<CopyObjectResult></CopyObjectResult>")), Request: req}
+	}}
+	src := newFakeS3Provider(fake, "src", S3Options{})
+
+	for _, withTags := range []bool{true, false} {
+		dst := newFakeS3Provider(fake, "dst", S3Options{}).WithTags(withTags)
+		fake.requests = nil
+		if _, err := dst.CopyFrom(context.Background(), src, "a.txt", "a.txt", nil); err != nil {
+			t.Fatal(err)
+		}
+		directive := fake.requests[0].Header.Get("X-Amz-Tagging-Directive")
+		if withTags && directive != "" || !withTags && directive != "REPLACE" {
+			t.Errorf("With tags %v: unexpected tagging directive %q", withTags, directive)
+		}
+	}
+}
@@ -2,6 +2,10 @@ package provider
 
 import (
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 func TestS3Provider_ImplementsProvider(t *testing.T) {
@@ -36,3 +40,38 @@ func TestS3Provider_BuildKey(t *testing.T) {
 		})
 	}
 }
+
+func TestRangeHeader(t *testing.T) {
+	tests := []struct {
+		offset, length int64
+		expect         string
+	}{
+		{0, 1, "bytes=0-0"},
+		{0, 1024, "bytes=0-1023"},
+		{1024, 1024, "bytes=1024-2047"},
+		{500, -1, "bytes=500-"},
+	}
+
+	for _, tt := range tests {
+		if actual := rangeHeader(tt.offset, tt.length); actual != tt.expect {
+			t.Errorf("rangeHeader(%d, %d) = %q; want %q", tt.offset, tt.length, actual, tt.expect)
+		}
+	}
+}
+
+func TestDeletedEntries(t *testing.T) {
+	now := time.Now()
+	markers := []types.DeleteMarkerEntry{
+		{Key: aws.String("backup/a.txt"), VersionId: aws.String("v1"), IsLatest: aws.Bool(true), LastModified: aws.Time(now.Add(-time.Hour))},
+		{Key: aws.String("backup/old.txt"), VersionId: aws.String("v2"), IsLatest: aws.Bool(true), LastModified: aws.Time(now.Add(-72 * time.Hour))},
+		{Key: aws.String("backup/restored.txt"), VersionId: aws.String("v3"), IsLatest: aws.Bool(false), LastModified: aws.Time(now.Add(-time.Hour))},
+	}
+
+	entries := deletedEntries(markers, "backup/", now.Add(-24*time.Hour))
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Path != "a.txt" || entries[0].VersionID != "v1" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+}
@@ -0,0 +1,514 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxUploadParts is the most parts S3 accepts in one multipart upload.
+const maxUploadParts = 10000
+
+// partSizeFor returns the part size for uploading a file of size bytes:
+// the configured size, raised if needed to stay within the part limit.
+func (p *S3Provider) partSizeFor(size int64) int64 {
+	partSize := p.uploadPartSize
+	if partSize <= 0 {
+		partSize = manager.DefaultUploadPartSize
+	}
+	limit := int64(p.maxUploadParts)
+	if limit <= 0 {
+		limit = maxUploadParts
+	}
+	return max(partSize, (size+limit-1)/limit)
+}
+
+// StreamMemory estimates the bytes S3 buffers for one file: the parts
+// uploaded concurrently while writing, or the ranges fetched ahead while
+// reading with a download concurrency above one. Neither exceeds the file.
+func (p *S3Provider) StreamMemory(size int64, write bool) int64 {
+	if size <= 0 {
+		return 0
+	}
+	if write {
+		concurrency := p.uploadConcurrency
+		if concurrency <= 0 {
+			concurrency = manager.DefaultUploadConcurrency
+		}
+		return min(size, p.partSizeFor(size)*int64(concurrency))
+	}
+	if p.downloadConcurrency <= 1 {
+		return 0
+	}
+	partSize := p.downloadPartSize
+	if partSize <= 0 {
+		partSize = defaultDownloadPartSize
+	}
+	return min(size, partSize*int64(p.downloadConcurrency))
+}
+
+// OpenWriteResumable opens an object for writing as a multipart upload whose
+// parts are reported to onPart as they are stored, continuing the upload in
+// resume if S3 still holds it. Files no larger than one part are written
+// with OpenWrite instead, as there would be nothing to resume.
+func (p *S3Provider) OpenWriteResumable(ctx context.Context, pth string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	if metadata == nil || metadata.IsDir() || metadata.Size() <= p.partSizeFor(metadata.Size()) {
+		w, err := p.OpenWrite(ctx, pth, metadata)
+		return w, 0, err
+	}
+	key := p.buildKey(pth)
+	size := metadata.Size()
+
+	var cp UploadCheckpoint
+	if resume != nil && resume.UploadID != "" && resume.PartSize > 0 {
+		parts, err := p.storedParts(ctx, key, *resume, size)
+		switch {
+		case err == nil:
+			cp = UploadCheckpoint{UploadID: resume.UploadID, PartSize: resume.PartSize, Parts: parts}
+		case !noSuchUpload(err):
+			return nil, 0, fmt.Errorf("failed to list parts of %q: %w", pth, err)
+		}
+		// An upload S3 no longer knows was aborted or expired; start over
+	}
+	if cp.UploadID == "" {
+		uploadID, err := p.createUpload(ctx, pth, key, metadata)
+		if err != nil {
+			return nil, 0, err
+		}
+		cp = UploadCheckpoint{UploadID: uploadID, PartSize: p.partSizeFor(size)}
+	}
+	if onPart != nil {
+		onPart(cp)
+	}
+
+	return newMultipartWriter(ctx, p, pth, key, cp, size, onPart), cp.Offset(), nil
+}
+
+// createUpload starts a multipart upload of pth to key, with the provider's
+// encryption settings and the user metadata of metadata, and returns its ID.
+func (p *S3Provider) createUpload(ctx context.Context, pth, key string, metadata FileInfo) (string, error) {
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(p.bucket),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+		Metadata:          p.userMetadata(metadata),
+		Tagging:           p.tagging(metadata),
+		ContentType:       p.contentType(key, metadata),
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = p.sse.write()
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.CreateMultipartUpload(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload for %q: %w", pth, err)
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+// storedParts lists the parts of the upload in cp and returns those that
+// can be kept: the full-sized parts from the first one up to the first gap,
+// within the first size bytes of the file.
+func (p *S3Provider) storedParts(ctx context.Context, key string, cp UploadCheckpoint, size int64) ([]UploadedPart, error) {
+	stored := make(map[int32]types.Part)
+	var marker *string
+	for {
+		in := &s3.ListPartsInput{
+			Bucket:           aws.String(p.bucket),
+			Key:              aws.String(key),
+			UploadId:         aws.String(cp.UploadID),
+			PartNumberMarker: marker,
+		}
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+		out, err := p.client.ListParts(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range out.Parts {
+			stored[aws.ToInt32(part.PartNumber)] = part
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		marker = out.NextPartNumberMarker
+	}
+
+	var parts []UploadedPart
+	for n := int32(1); int64(n)*cp.PartSize <= size; n++ {
+		part, ok := stored[n]
+		if !ok || aws.ToInt64(part.Size) != cp.PartSize {
+			break
+		}
+		parts = append(parts, UploadedPart{
+			Number:   n,
+			ETag:     aws.ToString(part.ETag),
+			Checksum: aws.ToString(part.ChecksumCRC32C),
+		})
+	}
+	return parts, nil
+}
+
+// AbortUpload discards an unfinished multipart upload. Uploads S3 no longer
+// knows need no aborting.
+func (p *S3Provider) AbortUpload(ctx context.Context, pth, uploadID string) error {
+	_, err := p.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(p.bucket),
+		Key:      aws.String(p.buildKey(pth)),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil && !noSuchUpload(err) {
+		return fmt.Errorf("failed to abort upload of %q: %w", pth, err)
+	}
+	return nil
+}
+
+// ListUploads lists the unfinished multipart uploads under the prefix of
+// pth with paginated ListMultipartUploads calls, reporting each as the pages
+// arrive.
+func (p *S3Provider) ListUploads(ctx context.Context, pth string, fn func(PendingUpload) error) error {
+	dirPrefix := p.buildKey(pth)
+	if dirPrefix != "" && !strings.HasSuffix(dirPrefix, "/") {
+		dirPrefix += "/"
+	}
+
+	paginator := s3.NewListMultipartUploadsPaginator(p.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(p.bucket),
+		Prefix: aws.String(dirPrefix),
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list uploads under %q: %w", pth, err)
+		}
+		for _, u := range out.Uploads {
+			err := fn(PendingUpload{
+				Path:      strings.TrimPrefix(aws.ToString(u.Key), dirPrefix),
+				UploadID:  aws.ToString(u.UploadId),
+				Initiated: aws.ToTime(u.Initiated),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// noSuchUpload reports whether err says the upload does not exist. Not
+// every operation models the error, so it is matched by code.
+func noSuchUpload(err error) bool {
+	var apiErr interface{ ErrorCode() string }
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload"
+}
+
+// multipartWriter uploads a file in parts, up to concurrency at a time, and
+// completes the upload on Close once the whole file was written. Parts are
+// checkpointed in order: a part is reported once every part before it is
+// stored, so a resume never skips a gap.
+type multipartWriter struct {
+	ctx         context.Context
+	p           *S3Provider
+	path        string
+	key         string
+	onPart      func(UploadCheckpoint)
+	concurrency int
+
+	size    int64 // of the whole file
+	written int64 // bytes of the file stored or buffered
+	next    int32 // number of the next part to upload
+	buf     []byte
+	free    chan []byte // buffers of finished parts, for reuse
+	buffers int         // buffers allocated
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	cp      UploadCheckpoint
+	pending map[int32]UploadedPart // stored parts waiting on an earlier one
+	err     error
+}
+
+func newMultipartWriter(ctx context.Context, p *S3Provider, pth, key string, cp UploadCheckpoint, size int64, onPart func(UploadCheckpoint)) *multipartWriter {
+	concurrency := p.uploadConcurrency
+	if concurrency <= 0 {
+		concurrency = manager.DefaultUploadConcurrency
+	}
+	return &multipartWriter{
+		ctx:         ctx,
+		p:           p,
+		path:        pth,
+		key:         key,
+		onPart:      onPart,
+		concurrency: concurrency,
+		size:        size,
+		written:     cp.Offset(),
+		next:        int32(len(cp.Parts) + 1),
+		buf:         make([]byte, 0, cp.PartSize),
+		free:        make(chan []byte, concurrency),
+		buffers:     1,
+		cp:          cp,
+		pending:     make(map[int32]UploadedPart),
+	}
+}
+
+func (w *multipartWriter) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		k := copy(w.buf[len(w.buf):cap(w.buf)], b)
+		w.buf = w.buf[:len(w.buf)+k]
+		b = b[k:]
+		n += k
+		w.written += int64(k)
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Committed returns the bytes held by the parts stored so far that follow
+// on from one another; the last part may be short of the part size.
+func (w *multipartWriter) Committed() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return min(w.cp.Offset(), w.size)
+}
+
+// flush starts uploading the buffered bytes as the next part and takes a
+// fresh buffer, waiting for a part in flight to finish if concurrency are.
+func (w *multipartWriter) flush() error {
+	if err := w.failed(); err != nil {
+		return err
+	}
+	number, data := w.next, w.buf
+	w.next++
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		part, err := w.p.uploadPart(w.ctx, w.path, w.key, w.cp.UploadID, number, data)
+		w.record(part, err)
+		w.free <- data[:0]
+	}()
+
+	select {
+	case w.buf = <-w.free:
+	default:
+		if w.buffers < w.concurrency {
+			w.buffers++
+			w.buf = make([]byte, 0, cap(data))
+		} else {
+			w.buf = <-w.free
+		}
+	}
+	return w.failed()
+}
+
+// uploadPart uploads part number of the upload of pth to key.
+func (p *S3Provider) uploadPart(ctx context.Context, pth, key, uploadID string, number int32, data []byte) (UploadedPart, error) {
+	// Sending the checksum of the buffered bytes makes S3 reject a part
+	// that arrives damaged
+	sum := crc32.Checksum(data, crc32cTable)
+	checksum := base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, sum))
+	in := &s3.UploadPartInput{
+		Bucket:            aws.String(p.bucket),
+		Key:               aws.String(key),
+		UploadId:          aws.String(uploadID),
+		PartNumber:        aws.Int32(number),
+		Body:              bytes.NewReader(data),
+		ContentLength:     aws.Int64(int64(len(data))),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+		ChecksumCRC32C:    aws.String(checksum),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.UploadPart(ctx, in)
+	if err != nil {
+		return UploadedPart{}, fmt.Errorf("failed to upload part %d of %q: %w", number, pth, err)
+	}
+	if err := checkStored(pth, aws.ToString(out.ChecksumCRC32C), checksum); err != nil {
+		return UploadedPart{}, fmt.Errorf("part %d: %w", number, err)
+	}
+	return UploadedPart{
+		Number:   number,
+		ETag:     aws.ToString(out.ETag),
+		Checksum: checksum,
+	}, nil
+}
+
+// record adds a stored part to the checkpoint, along with the parts after
+// it that were waiting for it, or keeps the first error.
+func (w *multipartWriter) record(part UploadedPart, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		return
+	}
+	w.pending[part.Number] = part
+	advanced := false
+	for {
+		next, ok := w.pending[int32(len(w.cp.Parts)+1)]
+		if !ok {
+			break
+		}
+		delete(w.pending, next.Number)
+		w.cp.Parts = append(w.cp.Parts, next)
+		advanced = true
+	}
+	if advanced && w.onPart != nil {
+		w.onPart(w.cp)
+	}
+}
+
+// failed returns the error of a part that failed to upload.
+func (w *multipartWriter) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close uploads the last part and completes the upload. If the file was not
+// written in full, or a part failed, the upload is left unfinished so it can
+// be resumed.
+func (w *multipartWriter) Close() error {
+	if w.written != w.size {
+		w.wg.Wait()
+		return fmt.Errorf("upload of %q incomplete: %d of %d bytes written", w.path, w.written, w.size)
+	}
+	if len(w.buf) > 0 || w.next == 1 {
+		w.flush()
+	}
+	w.wg.Wait()
+	if err := w.failed(); err != nil {
+		return err
+	}
+	return w.p.completeUpload(w.ctx, w.path, w.key, w.cp.UploadID, w.cp.Parts)
+}
+
+// completeUpload completes the upload of pth to key from parts, in order,
+// and checks the checksum S3 computed for the object against theirs.
+func (p *S3Provider) completeUpload(ctx context.Context, pth, key, uploadID string, parts []UploadedPart) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{
+			PartNumber: aws.Int32(part.Number),
+			ETag:       aws.String(part.ETag),
+		}
+		if part.Checksum != "" {
+			completed[i].ChecksumCRC32C = aws.String(part.Checksum)
+		}
+	}
+	in := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(p.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.CompleteMultipartUpload(ctx, in)
+	if err != nil {
+		return fmt.Errorf("failed to complete upload of %q: %w", pth, err)
+	}
+	if local, ok := partsChecksum(parts); ok {
+		if err := checkStored(pth, aws.ToString(out.ChecksumCRC32C), local); err != nil {
+			return p.discardCorrupt(ctx, pth, err)
+		}
+	}
+	return nil
+}
+
+// partsChecksum returns the composite CRC32C of an upload's parts, or false
+// if a part stored on resume has no checksum.
+func partsChecksum(parts []UploadedPart) (string, bool) {
+	var raw []byte
+	for _, part := range parts {
+		sum, err := base64.StdEncoding.DecodeString(part.Checksum)
+		if err != nil || len(sum) != crc32.Size {
+			return "", false
+		}
+		raw = append(raw, sum...)
+	}
+	return compositeChecksum(raw), true
+}
+
+// OpenChunked starts a multipart upload whose parts are the chunks of the
+// file, uploaded as they are written and assembled by Commit. Chunks must
+// be at least S3's minimum part size, and there can be no more of them than
+// an upload has parts.
+func (p *S3Provider) OpenChunked(ctx context.Context, pth string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	limit := p.maxUploadParts
+	if limit <= 0 {
+		limit = maxUploadParts
+	}
+	if chunkSize < manager.MinUploadPartSize || ChunkCount(size, chunkSize) > limit {
+		return nil, ErrNotSupported
+	}
+	key := p.buildKey(pth)
+	uploadID, err := p.createUpload(ctx, pth, key, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ChunkedFile{
+		p:         p,
+		path:      pth,
+		key:       key,
+		uploadID:  uploadID,
+		size:      size,
+		chunkSize: chunkSize,
+		parts:     make([]UploadedPart, ChunkCount(size, chunkSize)),
+	}, nil
+}
+
+type s3ChunkedFile struct {
+	p         *S3Provider
+	path      string
+	key       string
+	uploadID  string
+	size      int64
+	chunkSize int64
+
+	mu    sync.Mutex
+	parts []UploadedPart // by chunk index; Number is 0 until stored
+}
+
+func (f *s3ChunkedFile) WriteChunk(ctx context.Context, index int, r io.Reader) error {
+	data := make([]byte, chunkLength(index, f.size, f.chunkSize))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("failed to read chunk %d of %q: %w", index, f.path, err)
+	}
+	part, err := f.p.uploadPart(ctx, f.path, f.key, f.uploadID, int32(index+1), data)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.parts[index] = part
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *s3ChunkedFile) Commit(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, part := range f.parts {
+		if part.Number == 0 {
+			return fmt.Errorf("upload of %q incomplete: chunk %d not written", f.path, i)
+		}
+	}
+	return f.p.completeUpload(ctx, f.path, f.key, f.uploadID, f.parts)
+}
+
+func (f *s3ChunkedFile) Abort(ctx context.Context) error {
+	return f.p.AbortUpload(ctx, f.path, f.uploadID)
+}
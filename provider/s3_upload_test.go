@@ -0,0 +1,350 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// multipartServer keeps the multipart uploads of a fake bucket in memory.
+type multipartServer struct {
+	mu        sync.Mutex
+	nextID    int
+	uploads   map[string]map[int][]byte
+	completed map[string][]byte
+	failPart  int // UploadPart of this part number fails
+	rotPart   int // this part is damaged after it was stored
+}
+
+func newMultipartServer() *multipartServer {
+	return &multipartServer{uploads: make(map[string]map[int][]byte), completed: make(map[string][]byte)}
+}
+
+func (s *multipartServer) handle(req *http.Request) *http.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := req.URL.Query()
+	id := q.Get("uploadId")
+	ok := func(body string) *http.Response {
+		return &http.Response{StatusCode: 200, Header: http.Header{"Etag": {`"etag"`}}, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+	}
+
+	switch {
+	case req.Method == http.MethodPost && q.Has("uploads"):
+		s.nextID++
+		id = fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = make(map[int][]byte)
+		return ok("<InitiateMultipartUploadResult><UploadId>" + id + "</UploadId></InitiateMultipartUploadResult>")
+	case id != "" && s.uploads[id] == nil:
+		return xmlError(req, http.StatusNotFound, "NoSuchUpload")
+	case req.Method == http.MethodPut && id != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == s.failPart {
+			return xmlError(req, http.StatusBadRequest, "InvalidRequest")
+		}
+		data, _ := io.ReadAll(req.Body)
+		resp := ok("")
+		resp.Header.Set("Etag", fmt.Sprintf(`"etag-%d"`, n))
+		resp.Header.Set("X-Amz-Checksum-Crc32c", crc32cBase64(data))
+		if n == s.rotPart {
+			data[0] ^= 0xff
+		}
+		s.uploads[id][n] = data
+		return resp
+	case req.Method == http.MethodGet && id != "":
+		var parts strings.Builder
+		for n, data := range s.uploads[id] {
+			fmt.Fprintf(&parts, "<Part><PartNumber>%d</PartNumber><ETag>\"etag-%d\"</ETag><Size>%d</Size></Part>", n, n, len(data))
+		}
+		return ok("<ListPartsResult><IsTruncated>false</IsTruncated>" + parts.String() + "</ListPartsResult>")
+	case req.Method == http.MethodPost && id != "":
+		var object, sums []byte
+		for n := 1; n <= len(s.uploads[id]); n++ {
+			object = append(object, s.uploads[id][n]...)
+			sums = binary.BigEndian.AppendUint32(sums, crc32.Checksum(s.uploads[id][n], crc32cTable))
+		}
+		s.completed[req.URL.Path] = object
+		delete(s.uploads, id)
+		return ok(`<CompleteMultipartUploadResult><ETag>"final"</ETag><ChecksumCRC32C>` + compositeChecksum(sums) + `</ChecksumCRC32C></CompleteMultipartUploadResult>`)
+	case req.Method == http.MethodDelete && id != "":
+		delete(s.uploads, id)
+		return &http.Response{StatusCode: 204, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}
+	}
+	return nil
+}
+
+func TestS3Provider_ResumeMultipartUpload(t *testing.T) {
+	server := newMultipartServer()
+	server.failPart = 3
+	p := newFakeS3Provider(&fakeS3{handler: server.handle}, "bucket", S3Options{})
+	p.uploadPartSize = 100
+	p.uploadConcurrency = 1
+	ctx := context.Background()
+
+	object := make([]byte, 450)
+	for i := range object {
+		object[i] = byte(i)
+	}
+	info := &s3FileInfo{name: "big.bin", size: int64(len(object)), modTime: time.Now()}
+
+	var last UploadCheckpoint
+	w, offset, err := p.OpenWriteResumable(ctx, "big.bin", info, nil, func(cp UploadCheckpoint) { last = cp })
+	if err != nil || offset != 0 {
+		t.Fatalf("OpenWriteResumable: offset %d, %v", offset, err)
+	}
+	if _, err := w.Write(object); err == nil {
+		t.Fatal("Expected the third part to fail")
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Expected closing an incomplete upload to fail")
+	}
+	if len(last.Parts) != 2 || last.Parts[1].ETag != `"etag-2"` {
+		t.Fatalf("Expected two checkpointed parts, got %+v", last)
+	}
+
+	// The checkpoint may lag the stored parts; ListParts is authoritative
+	server.failPart = 0
+	stale := last
+	stale.Parts = stale.Parts[:1]
+	w, offset, err = p.OpenWriteResumable(ctx, "big.bin", info, &stale, func(cp UploadCheckpoint) { last = cp })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 200 {
+		t.Fatalf("Expected to resume at byte 200, got %d", offset)
+	}
+	if _, err := w.Write(object[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := server.completed["/bucket/big.bin"]; !bytes.Equal(got, object) {
+		t.Errorf("Completed object differs: %d bytes", len(got))
+	}
+	if last.UploadID != stale.UploadID || len(last.Parts) != 5 {
+		t.Errorf("Expected the original upload to be completed with 5 parts, got %+v", last)
+	}
+
+	// An upload S3 forgot is started over
+	w, offset, err = p.OpenWriteResumable(ctx, "big.bin", info, &last, nil)
+	if err != nil || offset != 0 {
+		t.Fatalf("Expected a new upload from byte 0, got %d, %v", offset, err)
+	}
+	w.Write(object)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3Provider_AbortUpload(t *testing.T) {
+	server := newMultipartServer()
+	p := newFakeS3Provider(&fakeS3{handler: server.handle}, "bucket", S3Options{})
+	p.uploadPartSize = 100
+	ctx := context.Background()
+
+	var cp UploadCheckpoint
+	info := &s3FileInfo{name: "big.bin", size: 300}
+	if _, _, err := p.OpenWriteResumable(ctx, "big.bin", info, nil, func(c UploadCheckpoint) { cp = c }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AbortUpload(ctx, "big.bin", cp.UploadID); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.uploads) != 0 {
+		t.Error("Expected the upload to be aborted")
+	}
+	if err := p.AbortUpload(ctx, "big.bin", cp.UploadID); err != nil {
+		t.Errorf("Expected aborting an unknown upload to succeed, got %v", err)
+	}
+}
+
+func TestS3Provider_MultipartUploadVerified(t *testing.T) {
+	server := newMultipartServer()
+	server.rotPart = 2
+	fake := &fakeS3{handler: server.handle}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+	p.uploadPartSize = 100
+	ctx := context.Background()
+
+	object := bytes.Repeat([]byte("gofast"), 50)
+	info := &s3FileInfo{name: "big.bin", size: int64(len(object))}
+	w, _, err := p.OpenWriteResumable(ctx, "big.bin", info, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(object); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected a checksum mismatch, got %v", err)
+	}
+	last := fake.requests[len(fake.requests)-1]
+	if last.Method != http.MethodDelete || last.URL.Query().Has("uploadId") {
+		t.Errorf("Expected the damaged object to be deleted, last request was %s %s", last.Method, last.URL)
+	}
+
+	server.rotPart = 0
+	w, _, _ = p.OpenWriteResumable(ctx, "big.bin", info, nil, nil)
+	w.Write(object)
+	if err := w.Close(); err != nil {
+		t.Errorf("Expected an intact upload to verify, got %v", err)
+	}
+}
+
+func TestS3Provider_ConcurrentMultipartUpload(t *testing.T) {
+	server := newMultipartServer()
+	var inFlight, peak atomic.Int32
+	p := newFakeS3Provider(&fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPut {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return server.handle(req)
+	}}, "bucket", S3Options{})
+	p.uploadPartSize = 100
+	p.uploadConcurrency = 4
+
+	object := make([]byte, 1050)
+	for i := range object {
+		object[i] = byte(i * 7)
+	}
+	info := &s3FileInfo{name: "big.bin", size: int64(len(object))}
+	var checkpoints []UploadCheckpoint
+	w, _, err := p.OpenWriteResumable(context.Background(), "big.bin", info, nil, func(cp UploadCheckpoint) {
+		checkpoints = append(checkpoints, cp)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(object); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := server.completed["/bucket/big.bin"]; !bytes.Equal(got, object) {
+		t.Errorf("Completed object differs: %d bytes", len(got))
+	}
+	if n := peak.Load(); n < 2 || n > 4 {
+		t.Errorf("Expected 2 to 4 parts in flight, peak was %d", n)
+	}
+	for _, cp := range checkpoints {
+		for i, part := range cp.Parts {
+			if part.Number != int32(i+1) {
+				t.Fatalf("Checkpoint has a gap: %+v", cp.Parts)
+			}
+		}
+	}
+	if last := checkpoints[len(checkpoints)-1]; len(last.Parts) != 11 {
+		t.Errorf("Expected 11 parts checkpointed, got %d", len(last.Parts))
+	}
+}
+
+func TestS3Provider_ListUploads(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if !req.URL.Query().Has("uploads") {
+			return nil
+		}
+		body := `<ListMultipartUploadsResult><IsTruncated>true</IsTruncated><NextKeyMarker>prefix/b.bin</NextKeyMarker><NextUploadIdMarker>u2</NextUploadIdMarker>` +
+			`<Upload><Key>prefix/a.bin</Key><UploadId>u1</UploadId><Initiated>2026-01-02T03:04:05.000Z</Initiated></Upload>` +
+			`<Upload><Key>prefix/b.bin</Key><UploadId>u2</UploadId><Initiated>2026-01-03T03:04:05.000Z</Initiated></Upload>` +
+			`</ListMultipartUploadsResult>`
+		if req.URL.Query().Get("key-marker") != "" {
+			body = `<ListMultipartUploadsResult><IsTruncated>false</IsTruncated>` +
+				`<Upload><Key>prefix/sub/c.bin</Key><UploadId>u3</UploadId><Initiated>2026-01-04T03:04:05.000Z</Initiated></Upload>` +
+				`</ListMultipartUploadsResult>`
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+	p.prefix = "prefix"
+
+	var got []string
+	err := p.ListUploads(context.Background(), "", func(u PendingUpload) error {
+		got = append(got, u.Path+"#"+u.UploadID+"@"+u.Initiated.Format("01-02"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "a.bin#u1@01-02,b.bin#u2@01-03,sub/c.bin#u3@01-04" {
+		t.Errorf("Unexpected uploads %v", got)
+	}
+	if prefix := fake.requests[0].URL.Query().Get("prefix"); prefix != "prefix/" {
+		t.Errorf("Expected the listing limited to the prefix, got %q", prefix)
+	}
+}
+
+func TestS3Provider_OpenChunked(t *testing.T) {
+	server := newMultipartServer()
+	p := newFakeS3Provider(&fakeS3{handler: server.handle}, "bucket", S3Options{})
+	ctx := context.Background()
+
+	const chunkSize = 5 << 20
+	object := make([]byte, 2*chunkSize+100)
+	for i := range object {
+		object[i] = byte(i * 7)
+	}
+	info := &s3FileInfo{name: "big.bin", size: int64(len(object))}
+	if _, err := p.OpenChunked(ctx, "big.bin", info.size, 1<<20, info); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("Expected chunks below the minimum part size to be refused, got %v", err)
+	}
+
+	f, err := p.OpenChunked(ctx, "big.bin", info.size, chunkSize, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteChunk(ctx, 0, bytes.NewReader(object[:chunkSize])); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Commit(ctx); err == nil {
+		t.Fatal("Expected committing with chunks missing to fail")
+	}
+
+	var wg sync.WaitGroup
+	for i := 2; i >= 1; i-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			end := min(int64(i+1)*chunkSize, info.size)
+			if err := f.WriteChunk(ctx, i, bytes.NewReader(object[int64(i)*chunkSize:end])); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := f.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.completed["/bucket/big.bin"]; !bytes.Equal(got, object) {
+		t.Errorf("Completed object differs: %d bytes", len(got))
+	}
+
+	f, _ = p.OpenChunked(ctx, "other.bin", info.size, chunkSize, info)
+	if err := f.Abort(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.uploads) != 0 {
+		t.Error("Expected the upload to be aborted")
+	}
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when closing a writer finds that the
+// checksum the backend stored differs from that of the bytes written.
+var ErrChecksumMismatch = errors.New("stored checksum does not match uploaded content")
+
+// uploadHash computes, while an object is written, the CRC32C S3 reports
+// for it: of the whole content for a single PUT, or the checksum of the part
+// checksums for a multipart upload in parts of partSize.
+type uploadHash struct {
+	partSize int64
+	whole    hash.Hash32
+	part     hash.Hash32
+	inPart   int64
+	parts    []byte // raw CRC32C of each full part
+}
+
+func newUploadHash(partSize int64) *uploadHash {
+	return &uploadHash{
+		partSize: partSize,
+		whole:    crc32.New(crc32cTable),
+		part:     crc32.New(crc32cTable),
+	}
+}
+
+func (h *uploadHash) Write(b []byte) (int, error) {
+	h.whole.Write(b)
+	n := len(b)
+	for len(b) > 0 {
+		k := min(int64(len(b)), h.partSize-h.inPart)
+		h.part.Write(b[:k])
+		h.inPart += k
+		b = b[k:]
+		if h.inPart == h.partSize {
+			h.parts = h.part.Sum(h.parts)
+			h.part.Reset()
+			h.inPart = 0
+		}
+	}
+	return n, nil
+}
+
+// verify compares the base64 checksum S3 stored with the one computed
+// locally, in whichever of the two forms S3 reported.
+func (h *uploadHash) verify(pth string, stored *string) error {
+	if stored == nil {
+		return nil
+	}
+	local := base64.StdEncoding.EncodeToString(h.whole.Sum(nil))
+	if strings.Contains(*stored, "-") {
+		parts := h.parts
+		if h.inPart > 0 {
+			parts = h.part.Sum(parts[:len(parts):len(parts)])
+		}
+		local = compositeChecksum(parts)
+	}
+	return checkStored(pth, *stored, local)
+}
+
+// compositeChecksum returns the S3 checksum of the concatenated raw part
+// CRC32Cs, with its "-<parts>" suffix.
+func compositeChecksum(parts []byte) string {
+	raw := binary.BigEndian.AppendUint32(nil, crc32.Checksum(parts, crc32cTable))
+	return base64.StdEncoding.EncodeToString(raw) + "-" + strconv.Itoa(len(parts)/crc32.Size)
+}
+
+// checkStored fails with ErrChecksumMismatch unless the checksum S3 stored
+// for pth equals the one computed while uploading. Stores that return no
+// checksum at all are not checked.
+func checkStored(pth, stored, local string) error {
+	if stored == "" || stored == local {
+		return nil
+	}
+	return fmt.Errorf("%w: %q stored with crc32c %s, uploaded %s", ErrChecksumMismatch, pth, stored, local)
+}
+
+// discardCorrupt deletes an object whose stored content failed
+// verification, so a damaged copy is not mistaken for a good one, and
+// returns cause.
+func (p *S3Provider) discardCorrupt(ctx context.Context, pth string, cause error) error {
+	if err := p.Delete(ctx, pth); err != nil {
+		return fmt.Errorf("%w (and removing it failed: %v)", cause, err)
+	}
+	return cause
+}
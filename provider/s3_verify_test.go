@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func crc32cBase64(data []byte) string {
+	return base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, crc32cTable)))
+}
+
+func TestUploadHash(t *testing.T) {
+	data := []byte("hello world")
+	h := newUploadHash(4)
+	h.Write(data[:3])
+	h.Write(data[3:])
+
+	if err := h.verify("a", aws.String("yZRlqg==")); err != nil {
+		t.Errorf("Whole object checksum: %v", err)
+	}
+
+	var sums []byte
+	for _, part := range [][]byte{data[:4], data[4:8], data[8:]} {
+		sums = binary.BigEndian.AppendUint32(sums, crc32.Checksum(part, crc32cTable))
+	}
+	composite := compositeChecksum(sums)
+	if !strings.HasSuffix(composite, "-3") {
+		t.Fatalf("Expected a 3 part composite, got %s", composite)
+	}
+	if err := h.verify("a", aws.String(composite)); err != nil {
+		t.Errorf("Composite checksum: %v", err)
+	}
+
+	if err := h.verify("a", aws.String("AAAAAA==")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected a mismatch, got %v", err)
+	}
+	if err := h.verify("a", nil); err != nil {
+		t.Errorf("Expected no stored checksum to pass, got %v", err)
+	}
+}
+
+func TestS3Provider_UploadVerified(t *testing.T) {
+	var damage bool
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.Method != http.MethodPut {
+			return nil
+		}
+		data, _ := io.ReadAll(req.Body)
+		if damage {
+			data[0] ^= 0xff
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"X-Amz-Checksum-Crc32c": {crc32cBase64(data)}},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+	ctx := context.Background()
+
+	upload := func() error {
+		w, err := p.OpenWrite(ctx, "a.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(w, bytes.NewReader([]byte("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+		return w.Close()
+	}
+
+	if err := upload(); err != nil {
+		t.Fatalf("Expected an intact upload to verify, got %v", err)
+	}
+	if got := fake.requests[0].Header.Get("X-Amz-Sdk-Checksum-Algorithm"); got != "CRC32C" {
+		t.Errorf("Expected a CRC32C upload, got %q", got)
+	}
+
+	damage = true
+	if err := upload(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected a checksum mismatch, got %v", err)
+	}
+	last := fake.requests[len(fake.requests)-1]
+	if last.Method != http.MethodDelete {
+		t.Errorf("Expected the damaged object to be deleted, last request was %s", last.Method)
+	}
+}
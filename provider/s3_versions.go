@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var _ Versioner = (*S3Provider)(nil)
+
+// versionEntry is a version or delete marker from a ListObjectVersions page.
+type versionEntry struct {
+	key string
+	ObjectVersion
+}
+
+// ListVersions scans every version and delete marker under the prefix of
+// pth with ListObjectVersions. S3 lists them by key, newest first; each
+// key's history is reported once complete, which may take several pages,
+// oldest first. Directory placeholders are skipped.
+func (p *S3Provider) ListVersions(ctx context.Context, pth string, fn func(rel string, versions []ObjectVersion) error) error {
+	dirPrefix := p.buildKey(pth)
+	if dirPrefix != "" && !strings.HasSuffix(dirPrefix, "/") {
+		dirPrefix += "/"
+	}
+
+	var key string
+	var history []ObjectVersion
+	flush := func() error {
+		if len(history) == 0 {
+			return nil
+		}
+		// Newest first within the key, and equal times in listing order
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Info.ModTime().After(history[j].Info.ModTime())
+		})
+		for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+			history[i], history[j] = history[j], history[i]
+		}
+		versions := history
+		history = nil
+		return fn(strings.TrimPrefix(key, dirPrefix), versions)
+	}
+
+	paginator := s3.NewListObjectVersionsPaginator(p.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(p.bucket),
+		Prefix: aws.String(dirPrefix),
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list versions of %q: %w", pth, err)
+		}
+
+		entries := make([]versionEntry, 0, len(out.Versions)+len(out.DeleteMarkers))
+		for _, v := range out.Versions {
+			entries = append(entries, versionEntry{key: aws.ToString(v.Key), ObjectVersion: ObjectVersion{
+				VersionID: aws.ToString(v.VersionId),
+				Info:      versionInfo(aws.ToString(v.Key), aws.ToInt64(v.Size), aws.ToTime(v.LastModified)),
+			}})
+		}
+		for _, m := range out.DeleteMarkers {
+			entries = append(entries, versionEntry{key: aws.ToString(m.Key), ObjectVersion: ObjectVersion{
+				VersionID:    aws.ToString(m.VersionId),
+				DeleteMarker: true,
+				Info:         versionInfo(aws.ToString(m.Key), 0, aws.ToTime(m.LastModified)),
+			}})
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+		for _, e := range entries {
+			rel := strings.TrimPrefix(e.key, dirPrefix)
+			if _, placeholder := dirPlaceholder(rel); rel == "" || placeholder {
+				continue
+			}
+			if e.key != key {
+				if err := flush(); err != nil {
+					return err
+				}
+				key = e.key
+			}
+			history = append(history, e.ObjectVersion)
+		}
+	}
+	return flush()
+}
+
+// versionInfo returns the FileInfo of one version of key.
+func versionInfo(key string, size int64, modTime time.Time) FileInfo {
+	return &s3FileInfo{name: path.Base(key), size: size, modTime: modTime}
+}
+
+// OpenReadVersion opens one version of an object with a single GET.
+func (p *S3Provider) OpenReadVersion(ctx context.Context, pth, versionID string) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket:    aws.String(p.bucket),
+		Key:       aws.String(p.buildKey(pth)),
+		VersionId: aws.String(versionID),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = p.sse.customer()
+	out, err := p.client.GetObject(ctx, in)
+	if err != nil {
+		if archived := archivedError(pth, err); archived != nil {
+			return nil, archived
+		}
+		return nil, fmt.Errorf("failed to open version %s of %q: %w", versionID, pth, err)
+	}
+	return out.Body, nil
+}
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestS3Provider_ListVersions(t *testing.T) {
+	pages := map[string]string{
+		"": `<ListVersionsResult><IsTruncated>true</IsTruncated><NextKeyMarker>data/b.txt</NextKeyMarker><NextVersionIdMarker>b1</NextVersionIdMarker>
+			<Version><Key>data/a.txt</Key><VersionId>a3</VersionId><Size>3</Size><LastModified>2024-01-01T00:03:00.000Z</LastModified></Version>
+			<Version><Key>data/a.txt</Key><VersionId>a1</VersionId><Size>1</Size><LastModified>2024-01-01T00:01:00.000Z</LastModified></Version>
+			<Version><Key>data/b.txt</Key><VersionId>b1</VersionId><Size>2</Size><LastModified>2024-01-01T00:01:00.000Z</LastModified></Version>
+			<Version><Key>data/dir/</Key><VersionId>d1</VersionId><Size>0</Size><LastModified>2024-01-01T00:01:00.000Z</LastModified></Version>
+			<DeleteMarker><Key>data/a.txt</Key><VersionId>a2</VersionId><LastModified>2024-01-01T00:02:00.000Z</LastModified></DeleteMarker>
+			</ListVersionsResult>`,
+		"data/b.txt": `<ListVersionsResult><IsTruncated>false</IsTruncated>
+			<Version><Key>data/c.txt</Key><VersionId>null</VersionId><Size>4</Size><LastModified>2024-01-01T00:01:00.000Z</LastModified></Version>
+			<DeleteMarker><Key>data/b.txt</Key><VersionId>b0</VersionId><LastModified>2023-12-31T00:00:00.000Z</LastModified></DeleteMarker>
+			</ListVersionsResult>`,
+	}
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		q := req.URL.Query()
+		if !q.Has("versions") || q.Get("prefix") != "data/" {
+			return xmlError(req, http.StatusBadRequest, "InvalidArgument")
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(pages[q.Get("key-marker")])), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	var got []string
+	err := p.ListVersions(context.Background(), "data", func(rel string, versions []ObjectVersion) error {
+		var ids []string
+		for _, v := range versions {
+			id := fmt.Sprintf("%s:%d", v.VersionID, v.Info.Size())
+			if v.DeleteMarker {
+				id = v.VersionID + ":deleted"
+			}
+			ids = append(ids, id)
+		}
+		got = append(got, rel+"="+strings.Join(ids, ","))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[a.txt=a1:1,a2:deleted,a3:3 b.txt=b0:deleted,b1:2 c.txt=null:4]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Got %v, want %s", got, want)
+	}
+}
+
+func TestS3Provider_OpenReadVersion(t *testing.T) {
+	fake := &fakeS3{handler: func(req *http.Request) *http.Response {
+		if req.URL.Query().Get("versionId") != "v2" {
+			return xmlError(req, http.StatusNotFound, "NoSuchVersion")
+		}
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("old")), Request: req}
+	}}
+	p := newFakeS3Provider(fake, "bucket", S3Options{})
+
+	rc, err := p.OpenReadVersion(context.Background(), "a.txt", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if data, _ := io.ReadAll(rc); string(data) != "old" {
+		t.Errorf("Got %q", data)
+	}
+	if _, err := p.OpenReadVersion(context.Background(), "a.txt", "v9"); err == nil {
+		t.Error("Expected an error for a missing version")
+	}
+}
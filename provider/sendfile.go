@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// FileSender is implemented by writers that can copy bytes from a local
+// file in the kernel, without them passing through gofast: local files, and
+// the wrappers that pass writes on unchanged, which account for the bytes
+// as they would for Write.
+type FileSender interface {
+	// SendFile copies up to n bytes from src, starting at its current
+	// offset, and returns how many it copied; fewer than n only at the end
+	// of src. It returns ErrNotSupported, having copied nothing, if the
+	// bytes cannot be copied in the kernel.
+	SendFile(ctx context.Context, src *os.File, n int64) (int64, error)
+}
+
+// FileSource is implemented by the readers wrapping a local file that pass
+// its bytes on unchanged, so a kernel copy can go around them.
+type FileSource interface {
+	// Unwrap returns the wrapped reader.
+	Unwrap() io.Reader
+	// Sent accounts for n bytes copied from the file in the kernel as if
+	// they had been read through the reader. Throttling readers wait here.
+	Sent(n int64) error
+}
+
+// SendFile copies up to n bytes from src to w in the kernel, or returns
+// ErrNotSupported if w is not a FileSender.
+func SendFile(ctx context.Context, w io.Writer, src *os.File, n int64) (int64, error) {
+	if s, ok := w.(FileSender); ok {
+		return s.SendFile(ctx, src, n)
+	}
+	return 0, ErrNotSupported
+}
+
+// SourceFile returns the local file r reads, looking through FileSource
+// wrappers, and false if r reads anything else or through a wrapper that
+// needs to see the bytes.
+func SourceFile(r io.Reader) (*os.File, bool) {
+	for {
+		switch v := r.(type) {
+		case *os.File:
+			return v, true
+		case FileSource:
+			r = v.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Sent accounts for n bytes copied in the kernel from the file beneath r in
+// each FileSource wrapper, outermost first.
+func Sent(r io.Reader, n int64) error {
+	for {
+		s, ok := r.(FileSource)
+		if !ok {
+			return nil
+		}
+		if err := s.Sent(n); err != nil {
+			return err
+		}
+		r = s.Unwrap()
+	}
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package provider
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sendFileSupported reports whether sendFile can copy in the kernel.
+const sendFileSupported = true
+
+// sendFile copies up to n bytes from src to dst, both at their current
+// offsets, without passing them through userspace: with copy_file_range
+// where the kernel supports it for the two files, else with sendfile, which
+// also copies across filesystems. It returns ErrNotSupported if neither
+// can copy the first byte.
+func sendFile(dst, src *os.File, n int64) (int64, error) {
+	var written int64
+	useSendfile := false
+	for written < n {
+		chunk := int(min(n-written, 1<<30))
+		var m int
+		var err error
+		if useSendfile {
+			m, err = unix.Sendfile(int(dst.Fd()), int(src.Fd()), nil, chunk)
+		} else {
+			m, err = unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, chunk, 0)
+			if err != nil && cloneUnsupported(err) {
+				useSendfile = true
+				continue
+			}
+		}
+		if err != nil {
+			if written == 0 && useSendfile && sendfileUnsupported(err) {
+				return 0, ErrNotSupported
+			}
+			return written, err
+		}
+		if m == 0 {
+			break
+		}
+		written += int64(m)
+	}
+	return written, nil
+}
+
+// sendfileUnsupported reports whether a sendfile error means the kernel
+// cannot copy between these files.
+func sendfileUnsupported(err error) bool {
+	return errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EOPNOTSUPP)
+}
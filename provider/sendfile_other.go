@@ -0,0 +1,13 @@
+//go:build !linux
+
+package provider
+
+import "os"
+
+// sendFileSupported reports whether sendFile can copy in the kernel.
+const sendFileSupported = false
+
+// sendFile is only implemented on Linux.
+func sendFile(dst, src *os.File, n int64) (int64, error) {
+	return 0, ErrNotSupported
+}
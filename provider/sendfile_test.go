@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSendFile(t *testing.T) {
+	if !sendFileSupported {
+		t.Skip("kernel copies are not supported on this platform")
+	}
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	content := bytes.Repeat([]byte("sent by the kernel "), 1000)
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	srcMetrics, dstMetrics := NewMetrics(), NewMetrics()
+	src := WithThrottle(WithMetrics(NewLocalProvider(srcDir), srcMetrics), NewTokenBucket(0))
+	dst := WithMetrics(NewLocalProvider(dstDir), dstMetrics)
+
+	r, err := src.OpenRead(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	file, ok := SourceFile(r)
+	if !ok {
+		t.Fatal("Expected the file beneath the metered, throttled reader")
+	}
+	w, err := dst.OpenWrite(ctx, "b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The copy stops at the end of the file
+	n, err := SendFile(ctx, w, file, int64(len(content))+100)
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("SendFile() = %d, %v, want %d", n, err, len(content))
+	}
+	if err := Sent(r, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "b.txt"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("Copied content differs: %d bytes, %v", len(got), err)
+	}
+	if b := dstMetrics.Op(OpWrite).Bytes; b != n {
+		t.Errorf("Expected the copy metered as a write of %d bytes, got %d", n, b)
+	}
+	if b := srcMetrics.Op(OpRead).Bytes; b != n {
+		t.Errorf("Expected the copy metered as a read of %d bytes, got %d", n, b)
+	}
+}
+
+func TestSendFile_NotSupported(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	file, err := os.Open(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Injected faults need to see the bytes
+	chaos := WithChaos(NewLocalProvider(dir), ChaosConfig{})
+	w, err := chaos.OpenWrite(ctx, "b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := SendFile(ctx, w, file, 4); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected a chaos writer to refuse kernel copies, got %v", err)
+	}
+	r, err := chaos.OpenRead(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, ok := SourceFile(r); ok {
+		t.Error("Expected no source file beneath a chaos reader")
+	}
+	if _, err := SendFile(ctx, &bytes.Buffer{}, file, 4); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected a buffer to refuse kernel copies, got %v", err)
+	}
+}
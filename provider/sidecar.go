@@ -0,0 +1,416 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SidecarMode selects where SidecarProvider keeps file metadata.
+type SidecarMode string
+
+const (
+	// SidecarNone disables sidecar metadata.
+	SidecarNone SidecarMode = "none"
+	// SidecarFiles writes one <file>.gofast-meta record next to each file.
+	SidecarFiles SidecarMode = "files"
+	// SidecarManifest collects the records of a run into a single
+	// .gofast-meta.jsonl manifest at the root.
+	SidecarManifest SidecarMode = "manifest"
+)
+
+const (
+	// SidecarSuffix is appended to a file's path to name its sidecar.
+	SidecarSuffix = ".gofast-meta"
+	// SidecarManifestName is the name of the consolidated manifest.
+	SidecarManifestName = ".gofast-meta.jsonl"
+)
+
+// ParseSidecarMode parses a -metadata-sidecar flag value.
+func ParseSidecarMode(s string) (SidecarMode, error) {
+	switch m := SidecarMode(s); m {
+	case SidecarNone, SidecarFiles, SidecarManifest:
+		return m, nil
+	case "":
+		return SidecarNone, nil
+	}
+	return "", fmt.Errorf("unknown sidecar mode %q (want none, files or manifest)", s)
+}
+
+// IsSidecarPath reports whether the last element of p names a sidecar
+// record or manifest rather than user data.
+func IsSidecarPath(p string) bool {
+	name := filepath.Base(filepath.FromSlash(p))
+	return strings.HasSuffix(name, SidecarSuffix) || name == SidecarManifestName
+}
+
+// MetadataRecord is the metadata of one file as stored in a sidecar: its
+// ownership, mode, Windows attributes and link target, enough to rebuild the
+// file on a POSIX or Windows filesystem. Path is relative to the root in a
+// manifest and empty in a per-file sidecar.
+type MetadataRecord struct {
+	Path string `json:"path,omitempty"`
+	persistedInfo
+}
+
+// NewMetadataRecord captures the metadata of info.
+func NewMetadataRecord(path string, info FileInfo) MetadataRecord {
+	return MetadataRecord{Path: path, persistedInfo: persistInfo(info)}
+}
+
+// FileInfo returns the recorded metadata as a FileInfo.
+func (r MetadataRecord) FileInfo() FileInfo {
+	return r.persistedInfo.fileInfo()
+}
+
+// overlay returns info with the recorded ownership, mode, attributes, link
+// target and modification time. Name, size and type stay those of info,
+// which describe the stored object.
+func (r MetadataRecord) overlay(info FileInfo) FileInfo {
+	pi := r.persistedInfo
+	pi.Name, pi.Size, pi.IsDir = info.Name(), info.Size(), info.IsDir()
+	return pi.fileInfo()
+}
+
+// SidecarProvider keeps file metadata that the wrapped provider cannot
+// store, such as ownership on plain S3, in JSON sidecar records. Writing
+// through it records the metadata of every file; reading through it hides
+// the records and reports their metadata instead, so a later run from this
+// provider to a POSIX filesystem restores ownership, modes and links.
+type SidecarProvider struct {
+	Wrapper
+	mode SidecarMode
+	root string
+
+	mu      sync.Mutex
+	pending map[string]MetadataRecord
+	loaded  map[string]MetadataRecord
+	loadErr error
+	load    sync.Once
+}
+
+// WithSidecars wraps p so that metadata is kept in sidecars under root, in
+// the given mode.
+func WithSidecars(p Provider, mode SidecarMode, root string) *SidecarProvider {
+	return &SidecarProvider{
+		Wrapper: Wrapper{p},
+		mode:    mode,
+		root:    root,
+		pending: make(map[string]MetadataRecord),
+	}
+}
+
+// Capabilities reports metadata support, since the sidecars preserve it.
+func (s *SidecarProvider) Capabilities() Capabilities {
+	caps := s.Wrapper.Capabilities()
+	caps.Metadata = true
+	// Sidecar records are filtered and applied per directory listing
+	caps.FlatList = false
+	caps.Versions = false
+	// Presigned requests bypass the sidecars entirely
+	caps.Presign = false
+	return caps
+}
+
+// Stat returns the FileInfo of path with its recorded metadata, if any.
+func (s *SidecarProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	info, err := s.Provider.Stat(ctx, path)
+	if err != nil || info.IsDir() {
+		return info, err
+	}
+	rec, ok, err := s.record(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		info = rec.overlay(info)
+	}
+	return info, nil
+}
+
+// List returns the contents of path without sidecar records, with each
+// file's recorded metadata.
+func (s *SidecarProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	entries, err := s.Provider.List(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := make(map[string]bool)
+	infos := entries[:0]
+	for _, entry := range entries {
+		if IsSidecarPath(entry.Name()) {
+			sidecars[entry.Name()] = true
+			continue
+		}
+		infos = append(infos, entry)
+	}
+
+	for i, info := range infos {
+		// In files mode the listing already shows which files have a
+		// sidecar, which saves a request for each file without one
+		if info.IsDir() || (s.mode == SidecarFiles && !sidecars[info.Name()+SidecarSuffix]) {
+			continue
+		}
+		rec, ok, err := s.record(ctx, filepath.Join(path, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			infos[i] = rec.overlay(info)
+		}
+	}
+	return infos, nil
+}
+
+// OpenWrite opens path on the wrapped provider and records metadata once
+// the file has been written successfully.
+func (s *SidecarProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	w, err := s.Provider.OpenWrite(ctx, path, metadata)
+	if err != nil || metadata == nil {
+		return w, err
+	}
+	return &sidecarWriter{WriteCloser: w, ctx: ctx, s: s, path: path, info: metadata}, nil
+}
+
+// OpenWriteResumable opens path like OpenWrite, continuing the upload in
+// resume if the wrapped provider can.
+func (s *SidecarProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	w, offset, err := s.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+	if err != nil || metadata == nil {
+		return w, offset, err
+	}
+	return &sidecarWriter{WriteCloser: w, ctx: ctx, s: s, path: path, info: metadata}, offset, nil
+}
+
+// OpenWriteAt opens path like OpenWrite, continuing it from offset if the
+// wrapped provider can.
+func (s *SidecarProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	w, offset, err := s.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+	if err != nil || metadata == nil {
+		return w, offset, err
+	}
+	return &sidecarWriter{WriteCloser: w, ctx: ctx, s: s, path: path, info: metadata}, offset, nil
+}
+
+// OpenChunked opens path on the wrapped provider and records metadata once
+// the file has been committed.
+func (s *SidecarProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	cf, err := s.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+	if err != nil || metadata == nil {
+		return cf, err
+	}
+	return &sidecarChunkedFile{ChunkedFile: cf, s: s, path: path, info: metadata}, nil
+}
+
+// CopyFrom copies on the wrapped provider and records the metadata.
+func (s *SidecarProvider) CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error) {
+	digest, err := s.Wrapper.CopyFrom(ctx, src, srcPath, dstPath, info)
+	if err != nil && !isMetadataError(err) {
+		return digest, err
+	}
+	if recErr := s.put(ctx, dstPath, info); recErr != nil {
+		return digest, recErr
+	}
+	return digest, err
+}
+
+// Flush writes the records collected in manifest mode, merged with those
+// of earlier runs, to the manifest at the root. It does nothing in files
+// mode, where each record is written with its file.
+func (s *SidecarProvider) Flush(ctx context.Context) error {
+	if s.mode != SidecarManifest {
+		return nil
+	}
+	existing, err := s.manifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	records := make([]MetadataRecord, 0, len(existing)+len(s.pending))
+	for rel, rec := range existing {
+		if _, ok := s.pending[rel]; !ok {
+			records = append(records, rec)
+		}
+	}
+	for _, rec := range s.pending {
+		records = append(records, rec)
+	}
+	s.mu.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+
+	w, err := s.Provider.OpenWrite(ctx, filepath.Join(s.root, SidecarManifestName), nil)
+	if err != nil {
+		return fmt.Errorf("failed to write metadata manifest: %w", err)
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			w.Close()
+			return fmt.Errorf("failed to write metadata manifest: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write metadata manifest: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write metadata manifest: %w", err)
+	}
+	return nil
+}
+
+// put records the metadata of the file at path.
+func (s *SidecarProvider) put(ctx context.Context, path string, info FileInfo) error {
+	switch s.mode {
+	case SidecarFiles:
+		w, err := s.Provider.OpenWrite(ctx, path+SidecarSuffix, nil)
+		if err != nil {
+			return fmt.Errorf("failed to write metadata sidecar: %w", err)
+		}
+		if err := json.NewEncoder(w).Encode(NewMetadataRecord("", info)); err != nil {
+			w.Close()
+			return fmt.Errorf("failed to write metadata sidecar: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("failed to write metadata sidecar: %w", err)
+		}
+	case SidecarManifest:
+		rel := s.rel(path)
+		s.mu.Lock()
+		s.pending[rel] = NewMetadataRecord(rel, info)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// record returns the stored metadata of the file at path.
+func (s *SidecarProvider) record(ctx context.Context, path string) (MetadataRecord, bool, error) {
+	switch s.mode {
+	case SidecarFiles:
+		rc, err := s.Provider.OpenRead(ctx, path+SidecarSuffix)
+		if IsNotExist(err) {
+			return MetadataRecord{}, false, nil
+		}
+		if err != nil {
+			return MetadataRecord{}, false, fmt.Errorf("failed to read metadata sidecar: %w", err)
+		}
+		defer rc.Close()
+		var rec MetadataRecord
+		if err := json.NewDecoder(rc).Decode(&rec); err != nil {
+			return MetadataRecord{}, false, fmt.Errorf("invalid metadata sidecar for %s: %w", path, err)
+		}
+		return rec, true, nil
+	case SidecarManifest:
+		records, err := s.manifest(ctx)
+		if err != nil {
+			return MetadataRecord{}, false, err
+		}
+		rec, ok := records[s.rel(path)]
+		return rec, ok, nil
+	}
+	return MetadataRecord{}, false, nil
+}
+
+// manifest loads the manifest at the root once. A missing manifest holds
+// no records.
+func (s *SidecarProvider) manifest(ctx context.Context) (map[string]MetadataRecord, error) {
+	s.load.Do(func() {
+		s.loaded = make(map[string]MetadataRecord)
+		rc, err := s.Provider.OpenRead(ctx, filepath.Join(s.root, SidecarManifestName))
+		if IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			s.loadErr = fmt.Errorf("failed to read metadata manifest: %w", err)
+			return
+		}
+		defer rc.Close()
+		dec := json.NewDecoder(rc)
+		for {
+			var rec MetadataRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				return
+			} else if err != nil {
+				s.loadErr = fmt.Errorf("invalid metadata manifest: %w", err)
+				return
+			}
+			s.loaded[rec.Path] = rec
+		}
+	})
+	return s.loaded, s.loadErr
+}
+
+// rel returns path relative to the root, slash-separated, as manifests
+// key their records.
+func (s *SidecarProvider) rel(path string) string {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// sidecarWriter records the metadata of a file once it has been closed
+// without error. A metadata error still records it, since the sidecar is
+// where the metadata the destination rejected is preserved.
+type sidecarWriter struct {
+	io.WriteCloser
+	ctx  context.Context
+	s    *SidecarProvider
+	path string
+	info FileInfo
+}
+
+// Unwrap returns the wrapped writer, for Committed.
+func (w *sidecarWriter) Unwrap() io.WriteCloser { return w.WriteCloser }
+
+// SendFile copies in the kernel through the wrapped writer; the sidecar is
+// written on Close.
+func (w *sidecarWriter) SendFile(ctx context.Context, src *os.File, n int64) (int64, error) {
+	return SendFile(ctx, w.WriteCloser, src, n)
+}
+
+func (w *sidecarWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if err != nil && !isMetadataError(err) {
+		return err
+	}
+	if recErr := w.s.put(w.ctx, w.path, w.info); recErr != nil {
+		return recErr
+	}
+	return err
+}
+
+type sidecarChunkedFile struct {
+	ChunkedFile
+	s    *SidecarProvider
+	path string
+	info FileInfo
+}
+
+func (f *sidecarChunkedFile) Commit(ctx context.Context) error {
+	err := f.ChunkedFile.Commit(ctx)
+	if err != nil && !isMetadataError(err) {
+		return err
+	}
+	if recErr := f.s.put(ctx, f.path, f.info); recErr != nil {
+		return recErr
+	}
+	return err
+}
+
+func isMetadataError(err error) bool {
+	var metaErr *MetadataError
+	return errors.As(err, &metaErr)
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeThrough writes content to path on p with the given metadata.
+func writeThrough(t *testing.T, p Provider, path, content string, info FileInfo) {
+	t.Helper()
+	w, err := p.OpenWrite(context.Background(), path, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSidecarProvider_Files(t *testing.T) {
+	tempBase := t.TempDir()
+	ctx := context.Background()
+	mtime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	base := &localFileInfo{name: "a.txt", size: 5, modTime: mtime}
+	info := NewUnixFileInfo(base, 4242, 4343, 0o640)
+	link := &symlinkFileInfo{UnixFileInfo: NewUnixFileInfo(&localFileInfo{name: "l"}, 1, 1, os.ModeSymlink|0o777), target: "a.txt"}
+
+	s := WithSidecars(NewLocalProvider(tempBase).WithMetadataMapper(nil), SidecarFiles, "")
+	if !CapabilitiesOf(s).Metadata {
+		t.Error("Expected sidecars to report metadata support")
+	}
+	writeThrough(t, s, "a.txt", "hello", info)
+	writeThrough(t, s, "l", "hello", link)
+
+	data, err := os.ReadFile(filepath.Join(tempBase, "a.txt"+SidecarSuffix))
+	if err != nil {
+		t.Fatalf("Expected a sidecar next to the file: %v", err)
+	}
+	var rec MetadataRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Unix || rec.UID != 4242 || rec.GID != 4343 || rec.Mode != 0o640 || !rec.ModTime.Equal(mtime) {
+		t.Errorf("Unexpected sidecar record %s", data)
+	}
+
+	// Reading back hides the sidecars and restores the recorded metadata
+	infos, err := s.List(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Expected sidecars to be hidden, got %d entries", len(infos))
+	}
+	for _, got := range infos {
+		switch got.Name() {
+		case "a.txt":
+			u, ok := got.(UnixFileInfo)
+			if !ok || u.UID() != 4242 || u.Mode() != 0o640 || !got.ModTime().Equal(mtime) || got.Size() != 5 {
+				t.Errorf("Expected recorded metadata for a.txt, got %+v", got)
+			}
+		case "l":
+			if target, ok := SymlinkTarget(got); !ok || target != "a.txt" {
+				t.Errorf("Expected l to be restored as a link to a.txt, got %+v", got)
+			}
+		default:
+			t.Errorf("Unexpected entry %s", got.Name())
+		}
+	}
+
+	got, err := s.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := got.(UnixFileInfo); !ok || u.GID() != 4343 {
+		t.Errorf("Expected Stat to restore the recorded GID, got %+v", got)
+	}
+}
+
+func TestSidecarProvider_Manifest(t *testing.T) {
+	tempBase := t.TempDir()
+	ctx := context.Background()
+	local := NewLocalProvider(tempBase).WithMetadataMapper(nil)
+	if err := os.Mkdir(filepath.Join(tempBase, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	first := WithSidecars(local, SidecarManifest, "")
+	writeThrough(t, first, "a.txt", "a", NewUnixFileInfo(&localFileInfo{name: "a.txt", size: 1}, 1, 1, 0o600))
+	if err := first.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second run merges its records with those already in the manifest
+	second := WithSidecars(local, SidecarManifest, "")
+	writeThrough(t, second, "sub/b.txt", "b", NewUnixFileInfo(&localFileInfo{name: "b.txt", size: 1}, 2, 2, 0o644))
+	if err := second.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempBase, "a.txt"+SidecarSuffix)); !os.IsNotExist(err) {
+		t.Error("Expected no per-file sidecars in manifest mode")
+	}
+
+	restore := WithSidecars(local, SidecarManifest, "")
+	infos, err := restore.List(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("Expected the manifest to be hidden, got %d entries", len(infos))
+	}
+	for path, uid := range map[string]uint32{"a.txt": 1, "sub/b.txt": 2} {
+		info, err := restore.Stat(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u, ok := info.(UnixFileInfo); !ok || u.UID() != uid {
+			t.Errorf("%s: expected UID %d from the manifest, got %+v", path, uid, info)
+		}
+	}
+}
+
+func TestParseSidecarMode(t *testing.T) {
+	for _, s := range []string{"", "none", "files", "manifest"} {
+		if _, err := ParseSidecarMode(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	if _, err := ParseSidecarMode("xattr"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+)
+
+// Extent is a byte range of a file that holds data. The ranges between a
+// sparse file's extents are holes, which read as zeros but occupy no space.
+type Extent struct {
+	Offset int64
+	Length int64
+}
+
+// SparseReader is implemented by providers that can tell where a file's
+// data is, so the holes of sparse files need not be read.
+type SparseReader interface {
+	// DataExtents returns the data extents of path in order. A file without
+	// holes has a single extent covering it.
+	DataExtents(ctx context.Context, path string) ([]Extent, error)
+}
+
+// ExtentBytes returns the total length of extents.
+func ExtentBytes(extents []Extent) int64 {
+	var n int64
+	for _, e := range extents {
+		n += e.Length
+	}
+	return n
+}
+
+// sparseBlockSize is the granularity at which sparse writes look for zeros.
+// It matches the block size of common filesystems, so skipped blocks become
+// holes rather than partially allocated blocks.
+const sparseBlockSize = 4096
+
+var zeroBlock [sparseBlockSize]byte
+
+// sparseWriter writes to a file, seeking over whole blocks of zeros instead
+// of writing them so that they become holes. It deliberately does not
+// implement io.ReaderFrom, which would bypass Write.
+type sparseWriter struct {
+	lw     *localWriteCloser
+	offset int64
+	// skipped means the file may end in a hole that Close must extend the
+	// file over.
+	skipped bool
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Split p into a run of data blocks or a run of zero blocks
+		n, zero := w.run(p)
+		var err error
+		if zero {
+			_, err = w.lw.seek(int64(n), io.SeekCurrent)
+			w.skipped = true
+		} else {
+			n, err = w.lw.write(p[:n])
+		}
+		written += n
+		w.offset += int64(n)
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// run returns the length of the leading run of p that is either all whole,
+// aligned zero blocks or not, and which of the two it is.
+func (w *sparseWriter) run(p []byte) (int, bool) {
+	n := 0
+	zero := false
+	for n < len(p) {
+		size := min(sparseBlockSize-int((w.offset+int64(n))%sparseBlockSize), len(p)-n)
+		block := p[n : n+size]
+		isZero := size == sparseBlockSize && bytes.Equal(block, zeroBlock[:])
+		if n == 0 {
+			zero = isZero
+		} else if isZero != zero {
+			break
+		}
+		n += size
+	}
+	return n, zero
+}
+
+// Close extends the file over a trailing hole, then closes it and applies
+// metadata.
+func (w *sparseWriter) Close() error {
+	if w.skipped {
+		if err := w.lw.File.Truncate(w.offset); err != nil {
+			w.lw.File.Close()
+			return err
+		}
+	}
+	return w.lw.Close()
+}
+
+// DataExtents returns the data extents of a local file, found with
+// SEEK_DATA and SEEK_HOLE where the platform supports them.
+func (p *LocalProvider) DataExtents(ctx context.Context, path string) ([]Extent, error) {
+	fullPath, err := p.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return dataExtents(f, stat.Size())
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package provider
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataExtents walks f with SEEK_DATA and SEEK_HOLE. Filesystems without
+// hole support report the whole file as data.
+func dataExtents(f *os.File, size int64) ([]Extent, error) {
+	fd := int(f.Fd())
+	var extents []Extent
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// Only a hole remains
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, size)
+		extents = append(extents, Extent{Offset: start, Length: end - start})
+		offset = end
+	}
+	return extents, nil
+}
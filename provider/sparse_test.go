@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// makeSparseFile creates a file of size bytes holding data at offset and
+// holes elsewhere.
+func makeSparseFile(t *testing.T, path string, size, offset int64, data []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(data, offset); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalProvider_DataExtents(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 4096)
+	makeSparseFile(t, filepath.Join(dir, "sparse.img"), 1<<20, 256<<10, data)
+
+	p := NewLocalProvider(dir)
+	extents, err := p.DataExtents(context.Background(), "sparse.img")
+	if err != nil {
+		t.Fatalf("DataExtents failed: %v", err)
+	}
+	if len(extents) == 0 {
+		t.Fatal("expected at least one data extent")
+	}
+	if got := ExtentBytes(extents); got == 1<<20 {
+		if runtime.GOOS == "linux" {
+			t.Skip("filesystem does not report holes")
+		}
+		return
+	}
+	first := extents[0]
+	if first.Offset > 256<<10 || first.Offset+first.Length < 256<<10+4096 {
+		t.Errorf("extent %+v does not cover the data", first)
+	}
+}
+
+func TestLocalProvider_SparseWrites(t *testing.T) {
+	dir := t.TempDir()
+	p := NewLocalProvider(dir).WithSparseWrites(true)
+
+	// Data, a hole, more data written across block boundaries, then a
+	// trailing hole
+	content := make([]byte, 5*sparseBlockSize+100)
+	copy(content, "head")
+	copy(content[3*sparseBlockSize-2:], "tail")
+
+	w, err := p.OpenWrite(context.Background(), "out.img", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for chunk := content; len(chunk) > 0; {
+		n := min(1000, len(chunk))
+		if _, err := w.Write(chunk[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		chunk = chunk[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "out.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("sparse write changed the content: got %d bytes, want %d", len(got), len(content))
+	}
+}
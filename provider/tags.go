@@ -0,0 +1,40 @@
+package provider
+
+// TaggedFileInfo is implemented by the FileInfo of providers that store
+// key-value tags with files, such as S3 object tags.
+type TaggedFileInfo interface {
+	FileInfo
+	// Tags returns the file's tags, and false if they were not looked up,
+	// as for files from a listing.
+	Tags() (map[string]string, bool)
+}
+
+// taggedFileInfo attaches tags to a FileInfo rebuilt from persisted form.
+type taggedFileInfo struct {
+	FileInfo
+	tags map[string]string
+}
+
+func (t *taggedFileInfo) Tags() (map[string]string, bool) { return t.tags, true }
+
+// ObjectTags returns the tags recorded in info, and false if info carries
+// none because they were never looked up.
+func ObjectTags(info FileInfo) (map[string]string, bool) {
+	for info != nil {
+		switch i := info.(type) {
+		case TaggedFileInfo:
+			return i.Tags()
+		case *symlinkFileInfo:
+			info = i.UnixFileInfo
+		case *windowsFileInfo:
+			info = i.UnixFileInfo
+		case *unixFileInfo:
+			info = i.FileInfo
+		case *contentTypedFileInfo:
+			info = i.FileInfo
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a byte rate limiter that can be shared by any number of
+// streams and providers. Tokens accrue at the configured rate up to a burst;
+// callers that take more than are available wait for the deficit to refill,
+// which keeps the long-run rate at the limit regardless of read sizes.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second; <= 0 means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a bucket allowing bytesPerSec on average. The burst
+// defaults to one second's worth of bytes. A rate of zero disables limiting.
+func NewTokenBucket(bytesPerSec int64) *TokenBucket {
+	tb := &TokenBucket{last: time.Now()}
+	tb.SetRate(bytesPerSec)
+	tb.tokens = tb.burst
+	return tb
+}
+
+// SetRate changes the rate, taking effect for subsequent waits. It is safe to
+// call while streams are running.
+func (tb *TokenBucket) SetRate(bytesPerSec int64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill(time.Now())
+	tb.rate = float64(bytesPerSec)
+	tb.burst = tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// Rate returns the current rate in bytes per second, or 0 if unlimited.
+func (tb *TokenBucket) Rate() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.rate <= 0 {
+		return 0
+	}
+	return int64(tb.rate)
+}
+
+// WaitN blocks until n bytes may pass, or ctx is done.
+func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	tb.mu.Lock()
+	if tb.rate <= 0 {
+		tb.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	tb.refill(now)
+	// Take the tokens now and wait out any deficit, so concurrent callers
+	// queue behind each other instead of all waking at once.
+	tb.tokens -= float64(n)
+	var wait time.Duration
+	if tb.tokens < 0 {
+		wait = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	tb.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	if tb.rate > 0 {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+	}
+	tb.last = now
+}
+
+// throttleProvider limits the bytes streamed through the wrapped provider.
+type throttleProvider struct {
+	Wrapper
+	bucket *TokenBucket
+}
+
+// WithThrottle wraps p so that every byte read from or written to its
+// streams is paid for from bucket. Sharing one bucket between providers
+// caps their combined throughput.
+func WithThrottle(p Provider, bucket *TokenBucket) Provider {
+	if bucket == nil {
+		return p
+	}
+	return &throttleProvider{Wrapper: Wrapper{p}, bucket: bucket}
+}
+
+func (t *throttleProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := t.Provider.OpenRead(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, bucket: t.bucket}, nil
+}
+
+func (t *throttleProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if !CapabilitiesOf(t.Provider).RangedRead {
+		// The fallback reads through OpenRead, which is already throttled.
+		return OpenReadRange(ctx, t, path, offset, length)
+	}
+	rc, err := t.Wrapper.OpenReadRange(ctx, path, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, bucket: t.bucket}, nil
+}
+
+func (t *throttleProvider) OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error) {
+	rc, err := t.Wrapper.OpenReadVersion(ctx, path, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, bucket: t.bucket}, nil
+}
+
+func (t *throttleProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	wc, err := t.Provider.OpenWrite(ctx, path, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledWriter{WriteCloser: wc, ctx: ctx, bucket: t.bucket}, nil
+}
+
+func (t *throttleProvider) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	wc, offset, err := t.Wrapper.OpenWriteResumable(ctx, path, metadata, resume, onPart)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &throttledWriter{WriteCloser: wc, ctx: ctx, bucket: t.bucket}, offset, nil
+}
+
+func (t *throttleProvider) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	wc, offset, err := t.Wrapper.OpenWriteAt(ctx, path, metadata, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &throttledWriter{WriteCloser: wc, ctx: ctx, bucket: t.bucket}, offset, nil
+}
+
+func (t *throttleProvider) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	cf, err := t.Wrapper.OpenChunked(ctx, path, size, chunkSize, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledChunkedFile{ChunkedFile: cf, bucket: t.bucket}, nil
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	bucket *TokenBucket
+}
+
+// Unwrap returns the wrapped reader, for SourceFile.
+func (r *throttledReader) Unwrap() io.Reader { return r.ReadCloser }
+
+// Sent takes the tokens for bytes copied in the kernel.
+func (r *throttledReader) Sent(n int64) error {
+	return r.bucket.WaitN(r.ctx, int(n))
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.bucket.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type throttledChunkedFile struct {
+	ChunkedFile
+	bucket *TokenBucket
+}
+
+func (f *throttledChunkedFile) WriteChunk(ctx context.Context, index int, r io.Reader) error {
+	rc := &throttledReader{ReadCloser: io.NopCloser(r), ctx: ctx, bucket: f.bucket}
+	return f.ChunkedFile.WriteChunk(ctx, index, rc)
+}
+
+type throttledWriter struct {
+	io.WriteCloser
+	ctx    context.Context
+	bucket *TokenBucket
+}
+
+// Unwrap returns the wrapped writer, for Committed.
+func (w *throttledWriter) Unwrap() io.WriteCloser { return w.WriteCloser }
+
+// SendFile waits for the tokens of n bytes before copying them in the
+// kernel, and returns those it did not use.
+func (w *throttledWriter) SendFile(ctx context.Context, src *os.File, n int64) (int64, error) {
+	if _, ok := w.WriteCloser.(FileSender); !ok {
+		return 0, ErrNotSupported
+	}
+	if err := w.bucket.WaitN(w.ctx, int(n)); err != nil {
+		return 0, err
+	}
+	m, err := SendFile(ctx, w.WriteCloser, src, n)
+	if m < n {
+		// Give back the tokens of the bytes the file ended short of; a
+		// negative count never waits
+		w.bucket.WaitN(w.ctx, -int(n-m))
+	}
+	return m, err
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	if err := w.bucket.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.WriteCloser.Write(p)
+}
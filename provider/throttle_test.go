@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Limits(t *testing.T) {
+	tb := NewTokenBucket(10000)
+	ctx := context.Background()
+
+	start := time.Now()
+	// The first second's worth is available immediately as burst; the
+	// next 5000 bytes must wait for half a second of refill.
+	for i := 0; i < 15; i++ {
+		if err := tb.WaitN(ctx, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms, took %v", elapsed)
+	}
+}
+
+func TestTokenBucket_Unlimited(t *testing.T) {
+	tb := NewTokenBucket(0)
+	start := time.Now()
+	if err := tb.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("Expected an unlimited bucket not to wait")
+	}
+	if tb.Rate() != 0 {
+		t.Errorf("Expected rate 0, got %d", tb.Rate())
+	}
+}
+
+func TestTokenBucket_Cancel(t *testing.T) {
+	tb := NewTokenBucket(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tb.WaitN(ctx, 1000); err == nil {
+		t.Error("Expected WaitN to return the context error")
+	}
+}
+
+func TestWithThrottle(t *testing.T) {
+	tempBase := t.TempDir()
+	content := bytes.Repeat([]byte("x"), 3000)
+	if err := os.WriteFile(filepath.Join(tempBase, "f"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := NewTokenBucket(2000)
+	p := WithThrottle(NewLocalProvider(tempBase), bucket)
+	if CapabilitiesOf(p) != CapabilitiesOf(NewLocalProvider(tempBase)) {
+		t.Error("Expected throttled provider to report the wrapped capabilities")
+	}
+
+	start := time.Now()
+	rc, err := p.OpenRead(context.Background(), "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Unexpected read: %d bytes, %v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected 3000 bytes at 2000 B/s to take about 500ms, took %v", elapsed)
+	}
+
+	// A shared bucket also paces writes through the same provider.
+	bucket.SetRate(1 << 30)
+	wc, err := p.OpenWrite(context.Background(), "g", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
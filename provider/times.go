@@ -0,0 +1,74 @@
+package provider
+
+import "time"
+
+// TimesFileInfo is implemented by FileInfos that carry access and birth
+// times.
+type TimesFileInfo interface {
+	FileInfo
+	// FileTimes returns the access and birth times, either zero if not
+	// reported.
+	FileTimes() (atime, btime time.Time)
+}
+
+func (l *localFileInfo) FileTimes() (time.Time, time.Time) { return l.atime, l.btime }
+
+// FileTimes returns the access and birth (creation) times recorded in info.
+// Either is zero when the provider or platform did not report it.
+func FileTimes(info FileInfo) (atime, btime time.Time) {
+	for info != nil {
+		switch i := info.(type) {
+		case TimesFileInfo:
+			return i.FileTimes()
+		case *symlinkFileInfo:
+			info = i.UnixFileInfo
+		case *windowsFileInfo:
+			info = i.UnixFileInfo
+		case *unixFileInfo:
+			info = i.FileInfo
+		case *taggedFileInfo:
+			info = i.FileInfo
+		case *contentTypedFileInfo:
+			info = i.FileInfo
+		default:
+			return time.Time{}, time.Time{}
+		}
+	}
+	return time.Time{}, time.Time{}
+}
+
+// WithAccessTimes makes closing a written file set its access time to the
+// source's, as captured when the source was listed, instead of to the
+// current time.
+func (p *LocalProvider) WithAccessTimes(enabled bool) *LocalProvider {
+	p.atime = enabled
+	return p
+}
+
+// WithBirthTimes makes closing a written file set its birth (creation) time
+// to the source's. Only platforms where BirthTimesSupported is true can set
+// birth times; elsewhere the option has no effect.
+func (p *LocalProvider) WithBirthTimes(enabled bool) *LocalProvider {
+	p.btime = enabled && BirthTimesSupported
+	return p
+}
+
+// applyTimes sets the modification time of the written file from its
+// metadata and, as configured, its access and birth times.
+func (l *localWriteCloser) applyTimes() error {
+	return applyTimes(l.fullPath, l.metadata, l.atime, l.btime)
+}
+
+// applyTimes sets the modification time of path from info and, if atime
+// and btime are set, its access and birth times. The access time defaults
+// to now, as writing the file would have left it.
+func applyTimes(path string, info FileInfo, atime, btime bool) error {
+	at, bt := FileTimes(info)
+	if !atime || at.IsZero() {
+		at = time.Now()
+	}
+	if !btime {
+		bt = time.Time{}
+	}
+	return setFileTimes(path, at, info.ModTime(), bt)
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// BirthTimesSupported reports whether birth times can be set on this
+// platform; macOS sets them with setattrlist.
+const BirthTimesSupported = true
+
+// statTimes returns the access and birth times behind info.
+func statTimes(info os.FileInfo) (atime, btime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, time.Time{}
+	}
+	return time.Unix(st.Atimespec.Unix()), time.Unix(st.Birthtimespec.Unix())
+}
+
+// setFileTimes sets the access and modification times of path and, unless
+// btime is zero, its birth time. APFS and HFS+ reject birth times later
+// than the modification time, so the birth time is set last.
+func setFileTimes(path string, atime, mtime, btime time.Time) error {
+	if err := os.Chtimes(path, atime, mtime); err != nil {
+		return err
+	}
+	if btime.IsZero() {
+		return nil
+	}
+	ts, err := unix.TimeToTimespec(btime)
+	if err != nil {
+		return err
+	}
+	attrs := unix.Attrlist{Bitmapcount: unix.ATTR_BIT_MAP_COUNT, Commonattr: unix.ATTR_CMN_CRTIME}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&ts)), unsafe.Sizeof(ts))
+	return unix.Setattrlist(path, &attrs, buf, 0)
+}
@@ -0,0 +1,27 @@
+package provider
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// BirthTimesSupported reports whether birth times can be set on this
+// platform. Linux exposes them through statx but offers no way to set them.
+const BirthTimesSupported = false
+
+// statTimes returns the access time behind info. The birth time is not in
+// stat_t and is left zero.
+func statTimes(info os.FileInfo) (atime, btime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, time.Time{}
+	}
+	return time.Unix(st.Atim.Unix()), time.Time{}
+}
+
+// setFileTimes sets the access and modification times of path with
+// utimensat, which keeps nanoseconds. btime cannot be set and is ignored.
+func setFileTimes(path string, atime, mtime, btime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
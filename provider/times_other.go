@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !windows
+
+package provider
+
+import (
+	"os"
+	"time"
+)
+
+// BirthTimesSupported reports whether birth times can be set on this
+// platform.
+const BirthTimesSupported = false
+
+// statTimes reports no times: their location in stat_t varies across the
+// remaining platforms.
+func statTimes(info os.FileInfo) (atime, btime time.Time) {
+	return time.Time{}, time.Time{}
+}
+
+// setFileTimes sets the access and modification times of path. btime
+// cannot be set and is ignored.
+func setFileTimes(path string, atime, mtime, btime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTimes(t *testing.T) {
+	atime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	btime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	base := &localFileInfo{name: "f", atime: atime, btime: btime}
+
+	infos := map[string]FileInfo{
+		"local":   base,
+		"unix":    NewUnixFileInfo(base, 1, 1, 0o644),
+		"windows": NewWindowsFileInfo(base, FileAttributeHidden, "S-1-5-32-544"),
+		"link":    &symlinkFileInfo{UnixFileInfo: NewUnixFileInfo(base, 1, 1, 0o777), target: "x"},
+		"persist": persistInfo(NewUnixFileInfo(base, 1, 1, 0o644)).fileInfo(),
+	}
+	for name, info := range infos {
+		gotA, gotB := FileTimes(info)
+		if !gotA.Equal(atime) || !gotB.Equal(btime) {
+			t.Errorf("%s: expected %v and %v, got %v and %v", name, atime, btime, gotA, gotB)
+		}
+	}
+	if a, b := FileTimes(&s3FileInfo{name: "o"}); !a.IsZero() || !b.IsZero() {
+		t.Errorf("Expected no times for an object, got %v and %v", a, b)
+	}
+}
+
+func TestLocalProvider_AccessTimes(t *testing.T) {
+	tempBase := t.TempDir()
+	ctx := context.Background()
+	mtime := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	atime := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	info := &localFileInfo{name: "f", size: 1, modTime: mtime, atime: atime}
+
+	for _, preserve := range []bool{false, true} {
+		p := NewLocalProvider(tempBase).WithMetadataMapper(nil).WithAccessTimes(preserve)
+		before := time.Now().Add(-time.Minute)
+		w, err := p.OpenWrite(ctx, "f", info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("x"))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stat, err := os.Stat(filepath.Join(tempBase, "f"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stat.ModTime().Equal(mtime) {
+			t.Errorf("preserve=%v: expected mtime %v, got %v", preserve, mtime, stat.ModTime())
+		}
+		got, _ := statTimes(stat)
+		if got.IsZero() {
+			t.Skip("platform does not report access times")
+		}
+		if preserve && !got.Equal(atime) {
+			t.Errorf("Expected the source's access time %v, got %v", atime, got)
+		}
+		if !preserve && got.Before(before) {
+			t.Errorf("Expected the access time to be the time of writing, got %v", got)
+		}
+	}
+}
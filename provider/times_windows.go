@@ -0,0 +1,52 @@
+//go:build windows
+
+package provider
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// BirthTimesSupported reports whether birth times can be set on this
+// platform; Windows calls them creation times.
+const BirthTimesSupported = true
+
+// statTimes returns the access and creation times behind info.
+func statTimes(info os.FileInfo) (atime, btime time.Time) {
+	attrData, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, time.Time{}
+	}
+	return time.Unix(0, attrData.LastAccessTime.Nanoseconds()), time.Unix(0, attrData.CreationTime.Nanoseconds())
+}
+
+// setFileTimes sets all three times of path in one SetFileTime call. A zero
+// btime leaves the creation time unchanged. Windows keeps times in 100ns
+// units, so nanoseconds are truncated.
+func setFileTimes(path string, atime, mtime, btime time.Time) error {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(pathPtr, windows.FILE_WRITE_ATTRIBUTES, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: path, Err: err}
+	}
+	defer windows.CloseHandle(h)
+
+	a := windows.NsecToFiletime(atime.UnixNano())
+	m := windows.NsecToFiletime(mtime.UnixNano())
+	var c *windows.Filetime
+	if !btime.IsZero() {
+		ft := windows.NsecToFiletime(btime.UnixNano())
+		c = &ft
+	}
+	if err := windows.SetFileTime(h, c, &a, &m); err != nil {
+		return &os.PathError{Op: "chtimes", Path: path, Err: err}
+	}
+	return nil
+}
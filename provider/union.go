@@ -0,0 +1,350 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// CollisionPolicy decides which file a UnionProvider serves when the same
+// relative path holds a file in more than one of its sources.
+type CollisionPolicy string
+
+const (
+	// CollisionFirst serves the file of the source listed first.
+	CollisionFirst CollisionPolicy = "first"
+	// CollisionNewest serves the file modified last, and of those the file
+	// of the source listed first.
+	CollisionNewest CollisionPolicy = "newest"
+	// CollisionLargest serves the largest file, and of those the file of
+	// the source listed first.
+	CollisionLargest CollisionPolicy = "largest"
+	// CollisionSkip serves none of them, leaving the path out of the
+	// merged tree.
+	CollisionSkip CollisionPolicy = "skip"
+)
+
+// ParseCollisionPolicy parses a -source-collisions flag value.
+func ParseCollisionPolicy(s string) (CollisionPolicy, error) {
+	switch p := CollisionPolicy(s); p {
+	case CollisionFirst, CollisionNewest, CollisionLargest, CollisionSkip:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown collision policy %q (want first, newest, largest or skip)", s)
+}
+
+// ErrCollision is returned by a UnionProvider for paths that hold a file
+// in more than one source under CollisionSkip.
+var ErrCollision = errors.New("path exists in more than one source")
+
+// UnionMember is one source of a UnionProvider: a provider and the root
+// within it whose tree is merged.
+type UnionMember struct {
+	Provider Provider
+	Root     string
+}
+
+// Collision describes a relative path found in more than one source of a
+// UnionProvider.
+type Collision struct {
+	// Path is the path within the merged tree.
+	Path string
+	// Sources are the indexes of the members holding an entry at Path, in
+	// order.
+	Sources []int
+	// Winner is the index of the member whose entry is served, or -1 if
+	// none is.
+	Winner int
+}
+
+// UnionProvider merges the trees of several sources, such as the volumes
+// of old file servers being consolidated, into one read-only tree rooted at
+// "". Directories present in more than one source are merged, and a
+// directory wins over files of the same path. Files present in more than
+// one source are resolved by the provider's CollisionPolicy.
+//
+// Stat and reads look a path up in every source, so that they serve the
+// same file as List did.
+type UnionProvider struct {
+	members     []UnionMember
+	policy      CollisionPolicy
+	onCollision func(Collision)
+
+	mu sync.Mutex
+	// decided holds the winner of every collision seen, so each is
+	// reported once
+	decided map[string]int
+}
+
+var (
+	_ Provider           = (*UnionProvider)(nil)
+	_ CapabilityReporter = (*UnionProvider)(nil)
+	_ RangeReader        = (*UnionProvider)(nil)
+	_ Checksummer        = (*UnionProvider)(nil)
+	_ Symlinker          = (*UnionProvider)(nil)
+)
+
+// NewUnionProvider merges the trees of members, resolving files present in
+// more than one of them by policy; an empty policy is CollisionFirst.
+func NewUnionProvider(policy CollisionPolicy, members ...UnionMember) *UnionProvider {
+	if policy == "" {
+		policy = CollisionFirst
+	}
+	return &UnionProvider{members: members, policy: policy, decided: make(map[string]int)}
+}
+
+// WithCollisionHandler has fn called once for every path found in more
+// than one source, as it is first listed or looked up. It may be called
+// from several goroutines at once.
+func (u *UnionProvider) WithCollisionHandler(fn func(Collision)) *UnionProvider {
+	u.onCollision = fn
+	return u
+}
+
+// Capabilities reports what every source supports.
+func (u *UnionProvider) Capabilities() Capabilities {
+	caps := Capabilities{
+		RangedRead:    true,
+		Checksums:     true,
+		Symlinks:      true,
+		Metadata:      true,
+		Tags:          true,
+		ContentType:   true,
+		AtomicObjects: true,
+	}
+	for _, m := range u.members {
+		c := CapabilitiesOf(m.Provider)
+		caps.RangedRead = caps.RangedRead && c.RangedRead
+		caps.Checksums = caps.Checksums && c.Checksums
+		caps.Symlinks = caps.Symlinks && c.Symlinks
+		caps.Metadata = caps.Metadata && c.Metadata
+		caps.Tags = caps.Tags && c.Tags
+		caps.ContentType = caps.ContentType && c.ContentType
+		caps.AtomicObjects = caps.AtomicObjects && c.AtomicObjects
+	}
+	return caps
+}
+
+// memberPath maps path in the merged tree to its path in member i.
+func (u *UnionProvider) memberPath(i int, path string) string {
+	root := u.members[i].Root
+	switch {
+	case root == "":
+		return path
+	case path == "":
+		return root
+	}
+	return filepath.Join(root, path)
+}
+
+// unionEntry is an entry of one member.
+type unionEntry struct {
+	member int
+	info   FileInfo
+}
+
+// Stat returns the entry at path of the member that serves it.
+func (u *UnionProvider) Stat(ctx context.Context, path string) (FileInfo, error) {
+	e, err := u.resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+// resolve finds the member serving path.
+func (u *UnionProvider) resolve(ctx context.Context, path string) (unionEntry, error) {
+	var hits []unionEntry
+	for i, m := range u.members {
+		info, err := m.Provider.Stat(ctx, u.memberPath(i, path))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return unionEntry{}, err
+		}
+		hits = append(hits, unionEntry{member: i, info: info})
+	}
+	if len(hits) == 0 {
+		return unionEntry{}, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	e, ok := u.choose(path, hits)
+	if !ok {
+		return unionEntry{}, fmt.Errorf("%s: %w", path, ErrCollision)
+	}
+	return e, nil
+}
+
+// List merges the listings of path in every member holding it as a
+// directory.
+func (u *UnionProvider) List(ctx context.Context, path string) ([]FileInfo, error) {
+	var (
+		names  []string
+		byName = make(map[string][]unionEntry)
+		found  bool
+	)
+	for i, m := range u.members {
+		infos, err := m.Provider.List(ctx, u.memberPath(i, path))
+		if err != nil {
+			if u.absentDir(ctx, i, path, err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, info := range infos {
+			name := info.Name()
+			if _, ok := byName[name]; !ok {
+				names = append(names, name)
+			}
+			byName[name] = append(byName[name], unionEntry{member: i, info: info})
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+
+	infos := make([]FileInfo, 0, len(names))
+	for _, name := range names {
+		if e, ok := u.choose(filepath.Join(path, name), byName[name]); ok {
+			infos = append(infos, e.info)
+		}
+	}
+	return infos, nil
+}
+
+// absentDir reports whether listing path failed with err because member i
+// holds no directory there.
+func (u *UnionProvider) absentDir(ctx context.Context, i int, path string, err error) bool {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return true
+	}
+	info, statErr := u.members[i].Provider.Stat(ctx, u.memberPath(i, path))
+	return errors.Is(statErr, fs.ErrNotExist) || (statErr == nil && !info.IsDir())
+}
+
+// choose picks the entry served at path from the entries of the members
+// holding it, reporting collisions the first time they are seen. It
+// returns false if none is served.
+func (u *UnionProvider) choose(path string, hits []unionEntry) (unionEntry, bool) {
+	if len(hits) == 1 {
+		return hits[0], true
+	}
+	files := 0
+	for _, e := range hits {
+		if !e.info.IsDir() {
+			files++
+		}
+	}
+	if files == 0 {
+		return hits[0], true
+	}
+
+	winner := u.pick(hits)
+	u.mu.Lock()
+	decided, seen := u.decided[path]
+	if !seen {
+		u.decided[path] = winner
+	}
+	u.mu.Unlock()
+	if seen {
+		winner = decided
+	} else if u.onCollision != nil {
+		c := Collision{Path: path, Winner: winner}
+		for _, e := range hits {
+			c.Sources = append(c.Sources, e.member)
+		}
+		u.onCollision(c)
+	}
+
+	for _, e := range hits {
+		if e.member == winner {
+			return e, true
+		}
+	}
+	return unionEntry{}, false
+}
+
+// pick returns the member whose entry wins among hits, or -1 if none does.
+func (u *UnionProvider) pick(hits []unionEntry) int {
+	// Directories are merged, and files cannot be merged into them
+	for _, e := range hits {
+		if e.info.IsDir() {
+			return e.member
+		}
+	}
+	best := hits[0]
+	for _, e := range hits[1:] {
+		switch u.policy {
+		case CollisionNewest:
+			if e.info.ModTime().After(best.info.ModTime()) {
+				best = e
+			}
+		case CollisionLargest:
+			if e.info.Size() > best.info.Size() {
+				best = e
+			}
+		}
+	}
+	if u.policy == CollisionSkip {
+		return -1
+	}
+	return best.member
+}
+
+// OpenRead opens path on the member that serves it.
+func (u *UnionProvider) OpenRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	e, err := u.resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return u.members[e.member].Provider.OpenRead(ctx, u.memberPath(e.member, path))
+}
+
+// OpenReadRange opens a range of path on the member that serves it.
+func (u *UnionProvider) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	e, err := u.resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenReadRange(ctx, u.members[e.member].Provider, u.memberPath(e.member, path), offset, length)
+}
+
+// Checksum asks the member that serves path.
+func (u *UnionProvider) Checksum(ctx context.Context, path string) (Digest, error) {
+	e, err := u.resolve(ctx, path)
+	if err != nil {
+		return Digest{}, err
+	}
+	if c, ok := u.members[e.member].Provider.(Checksummer); ok {
+		return c.Checksum(ctx, u.memberPath(e.member, path))
+	}
+	return Digest{}, ErrNotSupported
+}
+
+// Readlink asks the member that serves path.
+func (u *UnionProvider) Readlink(ctx context.Context, path string) (string, error) {
+	e, err := u.resolve(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := u.members[e.member].Provider.(Symlinker); ok {
+		return s.Readlink(ctx, u.memberPath(e.member, path))
+	}
+	return "", ErrNotSupported
+}
+
+// OpenWrite is not supported: a union is only read from.
+func (u *UnionProvider) OpenWrite(ctx context.Context, path string, metadata FileInfo) (io.WriteCloser, error) {
+	return nil, ErrNotSupported
+}
+
+// Symlink is not supported: a union is only read from.
+func (u *UnionProvider) Symlink(ctx context.Context, target, path string) error {
+	return ErrNotSupported
+}
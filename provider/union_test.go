@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unionVolume creates a local tree holding files, mapping relative paths
+// to content, each modified at the given time.
+func unionVolume(t *testing.T, files map[string]string, mtime time.Time) UnionMember {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(full, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return UnionMember{Provider: NewLocalProvider("").WithRoot(root), Root: root}
+}
+
+func readUnion(t *testing.T, u *UnionProvider, path string) string {
+	t.Helper()
+	r, err := u.OpenRead(context.Background(), path)
+	if err != nil {
+		t.Fatalf("OpenRead %s failed: %v", path, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUnionProvider_Merge(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := unionVolume(t, map[string]string{"shared/a.txt": "a", "dup.txt": "from a", "only-a": "1"}, old)
+	b := unionVolume(t, map[string]string{"shared/b.txt": "b", "dup.txt": "from b, longer", "only-b": "2"}, old.Add(time.Hour))
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var collisions []Collision
+	u := NewUnionProvider("", a, b).WithCollisionHandler(func(c Collision) {
+		mu.Lock()
+		collisions = append(collisions, c)
+		mu.Unlock()
+	})
+
+	root, err := u.Stat(ctx, "")
+	if err != nil || !root.IsDir() {
+		t.Fatalf("Expected the root to be a directory, got %v (%v)", root, err)
+	}
+	infos, err := u.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"dup.txt", "only-a", "only-b", "shared"}; !equalStrings(names, want) {
+		t.Fatalf("Expected merged root %v, got %v", want, names)
+	}
+	infos, err = u.List(ctx, "shared")
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("Expected shared directories to merge, got %d entries (%v)", len(infos), err)
+	}
+
+	if got := readUnion(t, u, "dup.txt"); got != "from a" {
+		t.Errorf("Expected the first source to win, read %q", got)
+	}
+	if got := readUnion(t, u, "only-b"); got != "2" {
+		t.Errorf("Expected files of later sources to be read from them, read %q", got)
+	}
+	if len(collisions) != 1 || collisions[0].Path != "dup.txt" || collisions[0].Winner != 0 || !equalInts(collisions[0].Sources, []int{0, 1}) {
+		t.Errorf("Expected one collision on dup.txt reported once, got %+v", collisions)
+	}
+	if _, err := u.OpenWrite(ctx, "x", nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected a union to be read-only, got %v", err)
+	}
+}
+
+func TestUnionProvider_CollisionPolicies(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	tests := []struct {
+		policy CollisionPolicy
+		want   string
+	}{
+		{CollisionFirst, "first"},
+		{CollisionNewest, "newer"},
+		{CollisionLargest, "largest of all"},
+		{CollisionSkip, ""},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			u := NewUnionProvider(tt.policy,
+				unionVolume(t, map[string]string{"f": "first"}, old),
+				unionVolume(t, map[string]string{"f": "largest of all"}, old.Add(time.Hour)),
+				unionVolume(t, map[string]string{"f": "newer"}, old.Add(2*time.Hour)),
+			)
+			infos, err := u.List(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.policy == CollisionSkip {
+				if len(infos) != 0 {
+					t.Errorf("Expected the colliding file to be left out, got %d entries", len(infos))
+				}
+				if _, err := u.Stat(ctx, "f"); !errors.Is(err, ErrCollision) {
+					t.Errorf("Expected ErrCollision, got %v", err)
+				}
+				return
+			}
+			if len(infos) != 1 {
+				t.Fatalf("Expected one entry, got %d", len(infos))
+			}
+			if got := readUnion(t, u, "f"); got != tt.want {
+				t.Errorf("Read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnionProvider_DirectoryWinsOverFile(t *testing.T) {
+	now := time.Now()
+	u := NewUnionProvider(CollisionFirst,
+		unionVolume(t, map[string]string{"x": "file"}, now),
+		unionVolume(t, map[string]string{"x/inner": "in dir"}, now),
+	)
+	ctx := context.Background()
+	info, err := u.Stat(ctx, "x")
+	if err != nil || !info.IsDir() {
+		t.Fatalf("Expected x to be a directory, got %v (%v)", info, err)
+	}
+	infos, err := u.List(ctx, "x")
+	if err != nil || len(infos) != 1 || infos[0].Name() != "inner" {
+		t.Fatalf("Expected the directory's listing, got %v (%v)", infos, err)
+	}
+	if _, err := u.List(ctx, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected listing a missing directory to fail with ErrNotExist, got %v", err)
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	for _, s := range []string{"first", "newest", "largest", "skip"} {
+		if p, err := ParseCollisionPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseCollisionPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseCollisionPolicy("oldest"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package provider
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// DefaultIOUringEntries is the submission queue size NewIOUring uses when
+// IOUringOptions.Entries is zero.
+const DefaultIOUringEntries = 256
+
+// IOUringOptions configures an IOUring.
+type IOUringOptions struct {
+	// Entries is the size of the submission queue; the kernel rounds it up
+	// to a power of two. The completion queue is twice as large, and bounds
+	// the reads and writes in flight at once.
+	Entries int
+	// SQPoll has a kernel thread poll the submission queue, so that while
+	// it is busy submitting takes no system call at all. It needs
+	// CAP_SYS_NICE on kernels before 5.11.
+	SQPoll bool
+	// FixedBuffers is how many transfer buffers are registered with the
+	// kernel, which then maps them once instead of on every read and
+	// write. Buffers are registered the first time they are used, so the
+	// buffers of a BufferPool, which are reused, stay registered; zero
+	// registers none.
+	FixedBuffers int
+}
+
+// IOUringStats counts the operations an IOUring has completed.
+type IOUringStats struct {
+	// Ops is the number of reads and writes.
+	Ops int64
+	// Fixed is the number of them that used a registered buffer.
+	Fixed int64
+}
+
+// IOUring submits the reads and writes of local files to a Linux io_uring
+// instead of making a system call for each, so that many transfers of
+// small files share the cost of entering the kernel, and queue deeply
+// enough to keep fast NVMe devices busy. One ring serves every transfer of
+// a run; it is safe for concurrent use.
+type IOUring struct {
+	ring *ring
+
+	ops   atomic.Int64
+	fixed atomic.Int64
+}
+
+// NewIOUring sets up a ring. It returns an error wrapping ErrNotSupported
+// on platforms without io_uring, and on Linux hosts where it is missing or
+// disabled, as it is in many container sandboxes.
+func NewIOUring(opts IOUringOptions) (*IOUring, error) {
+	if opts.Entries <= 0 {
+		opts.Entries = DefaultIOUringEntries
+	}
+	r, err := newRing(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &IOUring{ring: r}, nil
+}
+
+// Close waits for the operations in flight and releases the ring and the
+// buffers registered with it. Reads and writes submitted afterwards fail
+// with os.ErrClosed.
+func (u *IOUring) Close() error {
+	return u.ring.close()
+}
+
+// Stats returns the operations completed so far.
+func (u *IOUring) Stats() IOUringStats {
+	return IOUringStats{Ops: u.ops.Load(), Fixed: u.fixed.Load()}
+}
+
+// pread reads into p from f at off, like f.ReadAt but for a single read.
+func (u *IOUring) pread(f *os.File, p []byte, off int64) (int, error) {
+	return u.do(opRead, f, p, off)
+}
+
+// pwrite writes p to f at off and returns how many bytes were written,
+// which may be fewer than len(p).
+func (u *IOUring) pwrite(f *os.File, p []byte, off int64) (int, error) {
+	return u.do(opWrite, f, p, off)
+}
+
+func (u *IOUring) do(op ringOp, f *os.File, p []byte, off int64) (int, error) {
+	n, fixed, err := u.ring.do(op, int(f.Fd()), p, off)
+	if err != nil {
+		return n, &os.PathError{Op: op.String(), Path: f.Name(), Err: err}
+	}
+	u.ops.Add(1)
+	if fixed {
+		u.fixed.Add(1)
+	}
+	return n, nil
+}
+
+// ringOp is a read or a write submitted to a ring.
+type ringOp int
+
+const (
+	opRead ringOp = iota
+	opWrite
+)
+
+func (op ringOp) String() string {
+	if op == opWrite {
+		return "write"
+	}
+	return "read"
+}
+
+// WithIOUring makes OpenRead, OpenReadRange and OpenWrite submit their
+// reads and writes to ring. It has no effect on files opened for direct
+// I/O or written sparse, which keep their own write paths; nil turns it
+// off.
+func (p *LocalProvider) WithIOUring(ring *IOUring) *LocalProvider {
+	p.uring = ring
+	return p
+}
+
+// uringReader reads a local file through a ring, from its own offset.
+type uringReader struct {
+	file *os.File
+	ring *IOUring
+	off  int64
+}
+
+func (r *uringReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.ring.pread(r.file, p, r.off)
+	r.off += int64(n)
+	if err == nil && n == 0 {
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+func (r *uringReader) Close() error {
+	return r.file.Close()
+}
+
+// uringWriter writes a local file through a ring, from its start. Closing
+// it closes the file as the plain writer does, applying metadata.
+type uringWriter struct {
+	lw   *localWriteCloser
+	ring *IOUring
+	off  int64
+}
+
+func (w *uringWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := w.ring.pwrite(w.lw.File, p[written:], w.off)
+		written += n
+		w.off += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+func (w *uringWriter) Close() error {
+	return w.lw.Close()
+}
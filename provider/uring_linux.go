@@ -0,0 +1,483 @@
+//go:build linux
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The parts of the io_uring ABI the ring uses, from linux/io_uring.h.
+const (
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringSetupSQPoll    = 1 << 1
+	ioringFeatSingleMmap = 1 << 0
+
+	ioringEnterGetEvents = 1 << 0
+	ioringEnterSQWakeup  = 1 << 1
+	ioringSQNeedWakeup   = 1 << 0
+
+	ioringOpNop        = 0
+	ioringOpReadFixed  = 4
+	ioringOpWriteFixed = 5
+	ioringOpRead       = 22
+	ioringOpWrite      = 23
+
+	ioringRegisterBuffers2      = 15
+	ioringRegisterBuffersUpdate = 16
+	ioringRsrcRegisterSparse    = 1 << 0
+)
+
+type ioSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioUringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  ioSQRingOffsets
+	cqOff                                                                  ioCQRingOffsets
+}
+
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type ioUringRsrcRegister struct {
+	nr    uint32
+	flags uint32
+	resv2 uint64
+	data  uint64
+	tags  uint64
+}
+
+type ioUringRsrcUpdate2 struct {
+	offset uint32
+	resv   uint32
+	data   uint64
+	tags   uint64
+	nr     uint32
+	resv2  uint32
+}
+
+// closeUserData marks the no-op Close submits to stop the reaper; the
+// operations of submitters are numbered from 1.
+const closeUserData = 0
+
+// ring is an io_uring shared by concurrent submitters. Each submitter queues
+// an entry under mu and then enters the kernel to submit it;
+// one reaper goroutine waits for completions and hands each result to the
+// submitter waiting for it.
+type ring struct {
+	fd     int
+	sqPoll bool
+
+	sqMem, cqMem, sqeMem []byte
+
+	sqHead, sqTail, sqFlags *uint32
+	sqMask                  uint32
+	sqArray                 []uint32
+	sqes                    []ioUringSQE
+	cqHead, cqTail          *uint32
+	cqMask                  uint32
+	cqes                    []ioUringCQE
+
+	// slots bounds the operations in flight to the completion queue's
+	// size, so completions never overflow it.
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+	reaped   chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	nextID  uint64
+	waiting map[uint64]chan int32
+	// fixed is the table of registered buffers, nil if none could be
+	// registered. A buffer stays registered, and referenced here so it
+	// cannot be freed while the kernel maps it, until its slot is needed
+	// for another buffer and no operation is using it.
+	fixed     []fixedBuffer
+	nextEvict int
+	// pinLimit is set once registering a buffer has failed for lack of
+	// lockable memory; the buffers registered until then stay so.
+	pinLimit bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type fixedBuffer struct {
+	buf   []byte
+	inUse int
+}
+
+func newRing(opts IOUringOptions) (*ring, error) {
+	var params ioUringParams
+	if opts.SQPoll {
+		params.flags |= ioringSetupSQPoll
+		params.sqThreadIdle = 1000 // milliseconds
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(opts.Entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		if errno == unix.ENOSYS || errno == unix.EPERM {
+			return nil, fmt.Errorf("%w: io_uring_setup: %v", ErrNotSupported, errno)
+		}
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+
+	r := &ring{
+		fd:      int(fd),
+		sqPoll:  opts.SQPoll,
+		slots:   make(chan struct{}, params.cqEntries),
+		reaped:  make(chan struct{}),
+		waiting: make(map[uint64]chan int32),
+	}
+	if err := r.mmap(&params); err != nil {
+		r.unmap()
+		unix.Close(r.fd)
+		return nil, err
+	}
+	if opts.FixedBuffers > 0 && r.registerSparse(opts.FixedBuffers) == nil {
+		r.fixed = make([]fixedBuffer, opts.FixedBuffers)
+	}
+	go r.reap()
+	return r, nil
+}
+
+// mmap maps the queues the kernel shares with the ring.
+func (r *ring) mmap(params *ioUringParams) error {
+	const prot, flags = unix.PROT_READ | unix.PROT_WRITE, unix.MAP_SHARED | unix.MAP_POPULATE
+	sqSize := int(params.sqOff.array + params.sqEntries*4)
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{})))
+	single := params.features&ioringFeatSingleMmap != 0
+	if single {
+		sqSize = max(sqSize, cqSize)
+	}
+
+	var err error
+	if r.sqMem, err = unix.Mmap(r.fd, ioringOffSQRing, sqSize, prot, flags); err != nil {
+		return fmt.Errorf("failed to map io_uring submission queue: %w", err)
+	}
+	r.cqMem = r.sqMem
+	if !single {
+		if r.cqMem, err = unix.Mmap(r.fd, ioringOffCQRing, cqSize, prot, flags); err != nil {
+			return fmt.Errorf("failed to map io_uring completion queue: %w", err)
+		}
+	}
+	sqesSize := int(params.sqEntries) * int(unsafe.Sizeof(ioUringSQE{}))
+	if r.sqeMem, err = unix.Mmap(r.fd, ioringOffSQEs, sqesSize, prot, flags); err != nil {
+		return fmt.Errorf("failed to map io_uring submission entries: %w", err)
+	}
+
+	sq, cq := &params.sqOff, &params.cqOff
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqMem[sq.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqMem[sq.tail]))
+	r.sqFlags = (*uint32)(unsafe.Pointer(&r.sqMem[sq.flags]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqMem[sq.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqMem[sq.array])), params.sqEntries)
+	r.sqes = unsafe.Slice((*ioUringSQE)(unsafe.Pointer(&r.sqeMem[0])), params.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqMem[cq.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqMem[cq.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqMem[cq.ringMask]))
+	r.cqes = unsafe.Slice((*ioUringCQE)(unsafe.Pointer(&r.cqMem[cq.cqes])), params.cqEntries)
+	return nil
+}
+
+func (r *ring) unmap() {
+	if r.sqeMem != nil {
+		unix.Munmap(r.sqeMem)
+	}
+	if r.cqMem != nil && &r.cqMem[0] != &r.sqMem[0] {
+		unix.Munmap(r.cqMem)
+	}
+	if r.sqMem != nil {
+		unix.Munmap(r.sqMem)
+	}
+}
+
+// registerSparse registers a table of n empty buffer slots, filled as
+// buffers are first used.
+func (r *ring) registerSparse(n int) error {
+	reg := ioUringRsrcRegister{nr: uint32(n), flags: ioringRsrcRegisterSparse}
+	return r.register(ioringRegisterBuffers2, unsafe.Pointer(&reg), unsafe.Sizeof(reg))
+}
+
+func (r *ring) register(opcode int, arg unsafe.Pointer, n uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), uintptr(opcode), uintptr(arg), n, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// fixedSlot returns the slot of the registered buffer holding p, registering
+// the array behind p if it is not yet, or -1 if p cannot be registered. The
+// caller holds mu, and releases the slot once its operation completes.
+func (r *ring) fixedSlot(p []byte) int {
+	if len(r.fixed) == 0 || len(p) == 0 {
+		return -1
+	}
+	start := uintptr(unsafe.Pointer(unsafe.SliceData(p)))
+	end := start + uintptr(len(p))
+	for i, f := range r.fixed {
+		if f.buf == nil {
+			continue
+		}
+		base := uintptr(unsafe.Pointer(unsafe.SliceData(f.buf)))
+		if start >= base && end <= base+uintptr(len(f.buf)) {
+			r.fixed[i].inUse++
+			return i
+		}
+	}
+
+	if r.pinLimit {
+		return -1
+	}
+	// Take an empty slot, else the next idle one round-robin
+	slot := -1
+	for n := range len(r.fixed) {
+		i := (r.nextEvict + n) % len(r.fixed)
+		if r.fixed[i].buf == nil {
+			slot = i
+			break
+		}
+		if slot < 0 && r.fixed[i].inUse == 0 {
+			slot = i
+		}
+	}
+	if slot < 0 {
+		return -1
+	}
+	r.nextEvict = (slot + 1) % len(r.fixed)
+
+	buf := p[:cap(p)]
+	iov := unix.Iovec{Base: unsafe.SliceData(buf)}
+	iov.SetLen(len(buf))
+	up := ioUringRsrcUpdate2{offset: uint32(slot), data: uint64(uintptr(unsafe.Pointer(&iov))), nr: 1}
+	err := r.register(ioringRegisterBuffersUpdate, unsafe.Pointer(&up), unsafe.Sizeof(up))
+	runtime.KeepAlive(&iov)
+	if err != nil {
+		// Pinning is limited by RLIMIT_MEMLOCK; once it is reached,
+		// further buffers are used unregistered
+		r.pinLimit = errors.Is(err, unix.ENOMEM)
+		return -1
+	}
+	r.fixed[slot] = fixedBuffer{buf: buf, inUse: 1}
+	return slot
+}
+
+// do submits op on fd and waits for it to complete. It reports whether p
+// was a registered buffer.
+func (r *ring) do(op ringOp, fd int, p []byte, off int64) (int, bool, error) {
+	if len(p) == 0 {
+		return 0, false, nil
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, false, os.ErrClosed
+	}
+	r.inFlight.Add(1)
+	r.mu.Unlock()
+	defer r.inFlight.Done()
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	done := make(chan int32, 1)
+	r.mu.Lock()
+	if r.closed {
+		// The reaper failed while this waited for a slot
+		r.mu.Unlock()
+		return 0, false, os.ErrClosed
+	}
+	slot := r.fixedSlot(p)
+	sqe := ioUringSQE{
+		fd:   int32(fd),
+		off:  uint64(off),
+		addr: uint64(uintptr(unsafe.Pointer(unsafe.SliceData(p)))),
+		len:  uint32(min(len(p), 1<<30)),
+	}
+	switch {
+	case op == opRead && slot >= 0:
+		sqe.opcode, sqe.bufIndex = ioringOpReadFixed, uint16(slot)
+	case op == opWrite && slot >= 0:
+		sqe.opcode, sqe.bufIndex = ioringOpWriteFixed, uint16(slot)
+	case op == opRead:
+		sqe.opcode = ioringOpRead
+	default:
+		sqe.opcode = ioringOpWrite
+	}
+	r.nextID++
+	sqe.userData = r.nextID
+	r.waiting[sqe.userData] = done
+	r.push(&sqe)
+	r.mu.Unlock()
+
+	if errno := r.submit(); errno != 0 {
+		r.failWaiting(errno)
+	}
+	res := <-done
+	runtime.KeepAlive(p)
+	if slot >= 0 {
+		r.mu.Lock()
+		if slot < len(r.fixed) {
+			r.fixed[slot].inUse--
+		}
+		r.mu.Unlock()
+	}
+	if res < 0 {
+		return 0, false, unix.Errno(-res)
+	}
+	return int(res), slot >= 0, nil
+}
+
+// push queues sqe for the next submit. The caller holds mu, which makes it
+// the only writer of the submission queue's tail.
+func (r *ring) push(sqe *ioUringSQE) {
+	for {
+		tail := *r.sqTail
+		if tail-atomic.LoadUint32(r.sqHead) <= r.sqMask {
+			idx := tail & r.sqMask
+			r.sqes[idx] = *sqe
+			r.sqArray[idx] = idx
+			atomic.StoreUint32(r.sqTail, tail+1)
+			return
+		}
+		// The queue is full of entries the kernel has yet to take
+		r.submit()
+		runtime.Gosched()
+	}
+}
+
+// submit tells the kernel about queued entries: with SQPOLL only if its
+// thread has gone idle, else by entering the kernel. Another submitter may
+// already have submitted them.
+func (r *ring) submit() unix.Errno {
+	var toSubmit, flags uintptr
+	if r.sqPoll {
+		if atomic.LoadUint32(r.sqFlags)&ioringSQNeedWakeup == 0 {
+			return 0
+		}
+		flags = ioringEnterSQWakeup
+	} else {
+		toSubmit = uintptr(atomic.LoadUint32(r.sqTail) - atomic.LoadUint32(r.sqHead))
+		if toSubmit == 0 {
+			return 0
+		}
+	}
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), toSubmit, 0, flags, 0, 0)
+		if errno != unix.EINTR && errno != unix.EAGAIN && errno != unix.EBUSY {
+			return errno
+		}
+		runtime.Gosched()
+	}
+}
+
+// reap waits for completions and hands them to their submitters until it
+// sees the no-op Close submits.
+func (r *ring) reap() {
+	defer close(r.reaped)
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), 0, 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != unix.EINTR && errno != unix.EAGAIN && errno != unix.EBUSY {
+			r.failWaiting(errno)
+			return
+		}
+		stop := false
+		head := *r.cqHead
+		for tail := atomic.LoadUint32(r.cqTail); head != tail; head++ {
+			cqe := r.cqes[head&r.cqMask]
+			if cqe.userData == closeUserData {
+				stop = true
+				continue
+			}
+			r.mu.Lock()
+			done := r.waiting[cqe.userData]
+			delete(r.waiting, cqe.userData)
+			r.mu.Unlock()
+			if done != nil {
+				done <- cqe.res
+			}
+		}
+		atomic.StoreUint32(r.cqHead, head)
+		if stop {
+			return
+		}
+	}
+}
+
+// failWaiting fails the operations in flight once completions can no
+// longer be waited for.
+func (r *ring) failWaiting(errno unix.Errno) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for id, done := range r.waiting {
+		done <- -int32(errno)
+		delete(r.waiting, id)
+	}
+}
+
+func (r *ring) close() error {
+	r.closeOnce.Do(func() { r.closeErr = r.shutdown() })
+	return r.closeErr
+}
+
+func (r *ring) shutdown() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.inFlight.Wait()
+
+	select {
+	case <-r.reaped:
+		// The reaper stopped on an error
+	default:
+		r.mu.Lock()
+		r.push(&ioUringSQE{opcode: ioringOpNop, userData: closeUserData})
+		r.mu.Unlock()
+		if errno := r.submit(); errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		<-r.reaped
+	}
+	r.unmap()
+	r.fixed = nil
+	return unix.Close(r.fd)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package provider
+
+import "fmt"
+
+// ring is only implemented on Linux.
+type ring struct{}
+
+func newRing(opts IOUringOptions) (*ring, error) {
+	return nil, fmt.Errorf("%w: io_uring is only available on Linux", ErrNotSupported)
+}
+
+func (r *ring) do(op ringOp, fd int, p []byte, off int64) (int, bool, error) {
+	return 0, false, ErrNotSupported
+}
+
+func (r *ring) close() error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestRing(t *testing.T, opts IOUringOptions) *IOUring {
+	t.Helper()
+	ring, err := NewIOUring(opts)
+	if errors.Is(err, ErrNotSupported) {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Failed to set up ring: %v", err)
+	}
+	t.Cleanup(func() { ring.Close() })
+	return ring
+}
+
+func TestLocalProvider_IOUringRoundTrip(t *testing.T) {
+	ring := newTestRing(t, IOUringOptions{Entries: 8})
+	dir := t.TempDir()
+	p := NewLocalProvider(dir).WithIOUring(ring)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	w, err := p.OpenWrite(ctx, "a/file", nil)
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	if _, ok := w.(*uringWriter); !ok {
+		t.Fatalf("Expected writes through the ring, got %T", w)
+	}
+	if _, err := io.CopyBuffer(struct{ io.Writer }{w}, bytes.NewReader(data), make([]byte, 4096)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "a/file")); !bytes.Equal(got, data) {
+		t.Fatalf("Written file differs: %d bytes, want %d", len(got), len(data))
+	}
+
+	r, err := p.OpenRead(ctx, "a/file")
+	if err != nil {
+		t.Fatalf("OpenRead failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Read back %d bytes (%v), want %d", len(got), err, len(data))
+	}
+
+	rr, err := p.OpenReadRange(ctx, "a/file", 100, 50)
+	if err != nil {
+		t.Fatalf("OpenReadRange failed: %v", err)
+	}
+	got, err = io.ReadAll(rr)
+	rr.Close()
+	if err != nil || !bytes.Equal(got, data[100:150]) {
+		t.Fatalf("Range read %q (%v), want %q", got, err, data[100:150])
+	}
+	if ring.Stats().Ops == 0 {
+		t.Error("Expected the ring to count its operations")
+	}
+}
+
+func TestIOUring_FixedBuffers(t *testing.T) {
+	ring := newTestRing(t, IOUringOptions{Entries: 8, FixedBuffers: 2})
+	f, err := os.CreateTemp(t.TempDir(), "fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The same buffer, as a pool hands out, is registered once and reused
+	buf := make([]byte, 64*1024)
+	for i := range 4 {
+		copy(buf, fmt.Sprintf("block %d", i))
+		if _, err := ring.pwrite(f, buf, int64(i*len(buf))); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+	n, err := ring.pread(f, buf[:100], int64(2*len(buf)))
+	if err != nil || !bytes.HasPrefix(buf[:n], []byte("block 2")) {
+		t.Fatalf("Read %q (%v), want block 2", buf[:n], err)
+	}
+	stats := ring.Stats()
+	if stats.Ops != 5 {
+		t.Errorf("Expected 5 operations, got %d", stats.Ops)
+	}
+	// Registration can be refused by RLIMIT_MEMLOCK, which leaves the
+	// operations unregistered but correct
+	if stats.Fixed != 0 && stats.Fixed != 5 {
+		t.Errorf("Expected every operation on the registered buffer to use it, got %d of 5", stats.Fixed)
+	}
+
+	// Buffers beyond the table's size are used as they are
+	for i := range 3 {
+		other := make([]byte, 4096)
+		if _, err := ring.pwrite(f, other, int64(i*4096)); err != nil {
+			t.Fatalf("Write of unregistered buffer failed: %v", err)
+		}
+	}
+}
+
+func TestIOUring_Concurrent(t *testing.T) {
+	ring := newTestRing(t, IOUringOptions{Entries: 4, FixedBuffers: 4})
+	dir := t.TempDir()
+	p := NewLocalProvider(dir).WithIOUring(ring)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := range 32 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("f%d", i)
+			data := bytes.Repeat([]byte{byte(i)}, 10000+i)
+			w, err := p.OpenWrite(ctx, name, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := w.Write(data); err != nil {
+				errs <- err
+				return
+			}
+			if err := w.Close(); err != nil {
+				errs <- err
+				return
+			}
+			r, err := p.OpenRead(ctx, name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, data) {
+				errs <- fmt.Errorf("%s: read %d bytes, want %d", name, len(got), len(data))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestIOUring_Close(t *testing.T) {
+	ring := newTestRing(t, IOUringOptions{})
+	f, err := os.CreateTemp(t.TempDir(), "closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ring.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := ring.pwrite(f, []byte("late"), 0); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected writes after Close to fail with os.ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package provider
+
+import (
+	"context"
+	"io"
+)
+
+// ObjectVersion is one version in the history of a file.
+type ObjectVersion struct {
+	VersionID string
+	// DeleteMarker means the file was deleted at this point in its history;
+	// Info then has only a name and modification time.
+	DeleteMarker bool
+	Info         FileInfo
+}
+
+// Versioner is implemented by providers that keep the history of every
+// file, such as S3 buckets with versioning enabled.
+type Versioner interface {
+	// ListVersions reports every file under path, relative to it, with its
+	// versions and delete markers oldest first.
+	ListVersions(ctx context.Context, path string, fn func(rel string, versions []ObjectVersion) error) error
+	// OpenReadVersion opens one version of a file for reading.
+	OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error)
+}
@@ -0,0 +1,261 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotSupported is returned by wrapping providers when the wrapped backend
+// does not implement the requested optional operation.
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+// Wrapper is the base for providers that decorate another provider, such as
+// WithRetry. It forwards every optional interface to the wrapped provider
+// and reports that provider's capabilities, so the engine keeps choosing the
+// backend's native strategies. Decorators embed Wrapper and override only
+// the methods they change.
+type Wrapper struct {
+	Provider
+}
+
+var (
+	_ Provider           = Wrapper{}
+	_ CapabilityReporter = Wrapper{}
+	_ RangeReader        = Wrapper{}
+	_ Checksummer        = Wrapper{}
+	_ SoftDeleter        = Wrapper{}
+	_ BatchDeleter       = Wrapper{}
+	_ Renamer            = Wrapper{}
+	_ Symlinker          = Wrapper{}
+	_ ServerSideCopier   = Wrapper{}
+	_ SparseReader       = Wrapper{}
+	_ ResumableWriter    = Wrapper{}
+	_ AppendWriter       = Wrapper{}
+	_ UploadLister       = Wrapper{}
+	_ FlatLister         = Wrapper{}
+	_ Restorer           = Wrapper{}
+	_ Versioner          = Wrapper{}
+	_ Presigner          = Wrapper{}
+	_ ChunkedWriter      = Wrapper{}
+	_ Linker             = Wrapper{}
+	_ DirMaker           = Wrapper{}
+	_ MemoryEstimator    = Wrapper{}
+)
+
+// Unwrap returns the wrapped provider.
+func (w Wrapper) Unwrap() Provider {
+	return w.Provider
+}
+
+// Capabilities reports the capabilities of the wrapped provider.
+func (w Wrapper) Capabilities() Capabilities {
+	return CapabilitiesOf(w.Provider)
+}
+
+// OpenReadRange forwards to the wrapped provider, falling back to reading
+// from the start if it cannot serve ranges natively.
+func (w Wrapper) OpenReadRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return OpenReadRange(ctx, w.Provider, path, offset, length)
+}
+
+// Checksum forwards to the wrapped provider.
+func (w Wrapper) Checksum(ctx context.Context, path string) (Digest, error) {
+	if c, ok := w.Provider.(Checksummer); ok {
+		return c.Checksum(ctx, path)
+	}
+	return Digest{}, ErrNotSupported
+}
+
+// Delete forwards to the wrapped provider.
+func (w Wrapper) Delete(ctx context.Context, path string) error {
+	if d, ok := w.Provider.(Deleter); ok {
+		return d.Delete(ctx, path)
+	}
+	return ErrNotSupported
+}
+
+// DeleteBatch forwards to the wrapped provider.
+func (w Wrapper) DeleteBatch(ctx context.Context, paths []string) error {
+	if bd, ok := w.Provider.(BatchDeleter); ok {
+		return bd.DeleteBatch(ctx, paths)
+	}
+	return ErrNotSupported
+}
+
+// SoftDeletes forwards to the wrapped provider; providers without soft
+// deletes report false.
+func (w Wrapper) SoftDeletes(ctx context.Context) (bool, error) {
+	if sd, ok := w.Provider.(SoftDeleter); ok {
+		return sd.SoftDeletes(ctx)
+	}
+	return false, nil
+}
+
+// ListDeleted forwards to the wrapped provider.
+func (w Wrapper) ListDeleted(ctx context.Context, since time.Time) ([]DeletedEntry, error) {
+	if sd, ok := w.Provider.(SoftDeleter); ok {
+		return sd.ListDeleted(ctx, since)
+	}
+	return nil, ErrNotSupported
+}
+
+// Undelete forwards to the wrapped provider.
+func (w Wrapper) Undelete(ctx context.Context, entry DeletedEntry) error {
+	if sd, ok := w.Provider.(SoftDeleter); ok {
+		return sd.Undelete(ctx, entry)
+	}
+	return ErrNotSupported
+}
+
+// Rename forwards to the wrapped provider.
+func (w Wrapper) Rename(ctx context.Context, from, to string) error {
+	if r, ok := w.Provider.(Renamer); ok {
+		return r.Rename(ctx, from, to)
+	}
+	return ErrNotSupported
+}
+
+// Readlink forwards to the wrapped provider.
+func (w Wrapper) Readlink(ctx context.Context, path string) (string, error) {
+	if s, ok := w.Provider.(Symlinker); ok {
+		return s.Readlink(ctx, path)
+	}
+	return "", ErrNotSupported
+}
+
+// Symlink forwards to the wrapped provider.
+func (w Wrapper) Symlink(ctx context.Context, target, path string) error {
+	if s, ok := w.Provider.(Symlinker); ok {
+		return s.Symlink(ctx, target, path)
+	}
+	return ErrNotSupported
+}
+
+// MakeDir forwards to the wrapped provider.
+func (w Wrapper) MakeDir(ctx context.Context, path string, info FileInfo) error {
+	return MakeDir(ctx, w.Provider, path, info)
+}
+
+// Link forwards to the wrapped provider.
+func (w Wrapper) Link(ctx context.Context, existing, path string) error {
+	if l, ok := w.Provider.(Linker); ok {
+		return l.Link(ctx, existing, path)
+	}
+	return ErrNotSupported
+}
+
+// CanCopyFrom forwards to the wrapped provider.
+func (w Wrapper) CanCopyFrom(src Provider) bool {
+	if c, ok := w.Provider.(ServerSideCopier); ok {
+		return c.CanCopyFrom(src)
+	}
+	return false
+}
+
+// CopyFrom forwards to the wrapped provider. The data never passes through
+// the wrapper, so decorators that act on streams do not see it.
+func (w Wrapper) CopyFrom(ctx context.Context, src Provider, srcPath, dstPath string, info FileInfo) (Digest, error) {
+	if c, ok := w.Provider.(ServerSideCopier); ok {
+		return c.CopyFrom(ctx, src, srcPath, dstPath, info)
+	}
+	return Digest{}, ErrNotSupported
+}
+
+// DataExtents forwards to the wrapped provider.
+func (w Wrapper) DataExtents(ctx context.Context, path string) ([]Extent, error) {
+	if s, ok := w.Provider.(SparseReader); ok {
+		return s.DataExtents(ctx, path)
+	}
+	return nil, ErrNotSupported
+}
+
+// OpenWriteResumable forwards to the wrapped provider, falling back to
+// OpenWrite if it cannot resume uploads.
+func (w Wrapper) OpenWriteResumable(ctx context.Context, path string, metadata FileInfo, resume *UploadCheckpoint, onPart func(UploadCheckpoint)) (io.WriteCloser, int64, error) {
+	return OpenWriteResumable(ctx, w.Provider, path, metadata, resume, onPart)
+}
+
+// OpenWriteAt forwards to the wrapped provider, falling back to OpenWrite
+// if it cannot continue files.
+func (w Wrapper) OpenWriteAt(ctx context.Context, path string, metadata FileInfo, offset int64) (io.WriteCloser, int64, error) {
+	return OpenWriteAt(ctx, w.Provider, path, metadata, offset)
+}
+
+// OpenChunked forwards to the wrapped provider.
+func (w Wrapper) OpenChunked(ctx context.Context, path string, size, chunkSize int64, metadata FileInfo) (ChunkedFile, error) {
+	if cw, ok := w.Provider.(ChunkedWriter); ok {
+		return cw.OpenChunked(ctx, path, size, chunkSize, metadata)
+	}
+	return nil, ErrNotSupported
+}
+
+// AbortUpload forwards to the wrapped provider.
+func (w Wrapper) AbortUpload(ctx context.Context, path, uploadID string) error {
+	if rw, ok := w.Provider.(ResumableWriter); ok {
+		return rw.AbortUpload(ctx, path, uploadID)
+	}
+	return ErrNotSupported
+}
+
+// ListUploads forwards to the wrapped provider.
+func (w Wrapper) ListUploads(ctx context.Context, path string, fn func(PendingUpload) error) error {
+	if ul, ok := w.Provider.(UploadLister); ok {
+		return ul.ListUploads(ctx, path, fn)
+	}
+	return ErrNotSupported
+}
+
+// ListAll forwards to the wrapped provider.
+func (w Wrapper) ListAll(ctx context.Context, path string, fn func(rel string, info FileInfo) error) error {
+	if fl, ok := w.Provider.(FlatLister); ok {
+		return fl.ListAll(ctx, path, fn)
+	}
+	return ErrNotSupported
+}
+
+// Restore forwards to the wrapped provider.
+func (w Wrapper) Restore(ctx context.Context, path string, opts RestoreOptions) error {
+	if r, ok := w.Provider.(Restorer); ok {
+		return r.Restore(ctx, path, opts)
+	}
+	return ErrNotSupported
+}
+
+// ListVersions forwards to the wrapped provider.
+func (w Wrapper) ListVersions(ctx context.Context, path string, fn func(rel string, versions []ObjectVersion) error) error {
+	if v, ok := w.Provider.(Versioner); ok {
+		return v.ListVersions(ctx, path, fn)
+	}
+	return ErrNotSupported
+}
+
+// OpenReadVersion forwards to the wrapped provider.
+func (w Wrapper) OpenReadVersion(ctx context.Context, path, versionID string) (io.ReadCloser, error) {
+	if v, ok := w.Provider.(Versioner); ok {
+		return v.OpenReadVersion(ctx, path, versionID)
+	}
+	return nil, ErrNotSupported
+}
+
+// PresignGet forwards to the wrapped provider.
+func (w Wrapper) PresignGet(ctx context.Context, path string, ttl time.Duration) (PresignedRequest, error) {
+	if p, ok := w.Provider.(Presigner); ok {
+		return p.PresignGet(ctx, path, ttl)
+	}
+	return PresignedRequest{}, ErrNotSupported
+}
+
+// PresignPut forwards to the wrapped provider.
+func (w Wrapper) PresignPut(ctx context.Context, path string, info FileInfo, ttl time.Duration) (PresignedRequest, error) {
+	if p, ok := w.Provider.(Presigner); ok {
+		return p.PresignPut(ctx, path, info, ttl)
+	}
+	return PresignedRequest{}, ErrNotSupported
+}
+
+// StreamMemory forwards to the wrapped provider.
+func (w Wrapper) StreamMemory(size int64, write bool) int64 {
+	return StreamMemory(w.Provider, size, write)
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrapper_ForwardsCapabilities(t *testing.T) {
+	tempBase := t.TempDir()
+	local := NewLocalProvider(tempBase)
+
+	w := Wrapper{local}
+	if got, want := CapabilitiesOf(w), CapabilitiesOf(local); got != want {
+		t.Errorf("Expected wrapped capabilities %+v, got %+v", want, got)
+	}
+
+	if err := os.WriteFile(filepath.Join(tempBase, "f.txt"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := w.OpenReadRange(context.Background(), "f.txt", 2, 3)
+	if err != nil {
+		t.Fatalf("OpenReadRange failed: %v", err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if string(data) != "234" {
+		t.Errorf("Expected %q, got %q", "234", data)
+	}
+}
+
+func TestWrapper_NotSupported(t *testing.T) {
+	w := Wrapper{plainProvider{NewLocalProvider(t.TempDir())}}
+
+	if caps := CapabilitiesOf(w); caps.Delete || caps.Symlinks || caps.Checksums {
+		t.Errorf("Expected no optional capabilities, got %+v", caps)
+	}
+	if err := w.Delete(context.Background(), "f.txt"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Delete, got %v", err)
+	}
+	if err := DeleteAll(context.Background(), w, []string{"f.txt"}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from DeleteAll, got %v", err)
+	}
+	if ok, err := w.SoftDeletes(context.Background()); ok || err != nil {
+		t.Errorf("Expected no soft deletes, got %v, %v", ok, err)
+	}
+	wc, offset, err := w.OpenWriteResumable(context.Background(), "f.txt", nil, &UploadCheckpoint{UploadID: "u1"}, nil)
+	if err != nil || offset != 0 {
+		t.Fatalf("Expected a plain write from offset 0, got %d, %v", offset, err)
+	}
+	wc.Close()
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// listingsBucket holds a bucket per run with the source listing it
+	// recorded, keyed by source path.
+	listingsBucket = []byte("listings")
+)
+
+// ListedFile is one file or directory of a run's recorded source listing.
+type ListedFile struct {
+	SourcePath      string `json:"source_path"`
+	DestinationPath string `json:"destination_path"`
+	Dir             bool   `json:"dir,omitempty"`
+	// Info is the metadata of the file when it was listed, as the engine
+	// encoded it.
+	Info json.RawMessage `json:"info,omitempty"`
+}
+
+// AddListing adds files to the listing recorded for a run, replacing those
+// with the same source path.
+func (s *BoltStore) AddListing(runID string, files []ListedFile) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(listingsBucket)
+		if err != nil {
+			return fmt.Errorf("failed to create listings bucket: %w", err)
+		}
+		b, err := root.CreateBucketIfNotExists([]byte(runID))
+		if err != nil {
+			return fmt.Errorf("failed to create listing of run %s: %w", runID, err)
+		}
+		for i := range files {
+			data, err := json.Marshal(&files[i])
+			if err != nil {
+				return fmt.Errorf("failed to marshal listed file: %w", err)
+			}
+			if err := b.Put([]byte(files[i].SourcePath), data); err != nil {
+				return fmt.Errorf("failed to put listed file: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListedFiles returns up to limit files of the listing recorded for a run
+// whose source paths sort after after, in source path order, so a long
+// listing is read a page at a time rather than in one transaction held
+// open while the run writes to the store.
+func (s *BoltStore) ListedFiles(runID, after string, limit int) ([]ListedFile, error) {
+	var files []ListedFile
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(listingsBucket)
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(runID))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		k, data := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, data = c.Next()
+		}
+		for ; k != nil && len(files) < limit; k, data = c.Next() {
+			var file ListedFile
+			if err := json.Unmarshal(data, &file); err != nil {
+				return fmt.Errorf("failed to unmarshal listed file %s: %w", k, err)
+			}
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// DeleteListing removes the listing recorded for a run, if any.
+func (s *BoltStore) DeleteListing(runID string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(listingsBucket)
+		if root == nil || root.Bucket([]byte(runID)) == nil {
+			return nil
+		}
+		if err := root.DeleteBucket([]byte(runID)); err != nil {
+			return fmt.Errorf("failed to delete listing of run %s: %w", runID, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore_Listing(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create BoltStore: %v", err)
+	}
+	defer store.Close()
+
+	listed := func(runID string) []ListedFile {
+		files, err := store.ListedFiles(runID, "", 10)
+		if err != nil {
+			t.Fatalf("ListedFiles failed: %v", err)
+		}
+		return files
+	}
+	if files := listed("run1"); len(files) != 0 {
+		t.Fatalf("Expected no listing yet, got %v", files)
+	}
+
+	if err := store.AddListing("run1", []ListedFile{
+		{SourcePath: "/src/b", DestinationPath: "/dst/b", Info: json.RawMessage(`{"size":2}`)},
+		{SourcePath: "/src/a", DestinationPath: "/dst/a"},
+	}); err != nil {
+		t.Fatalf("AddListing failed: %v", err)
+	}
+	if err := store.AddListing("run2", []ListedFile{{SourcePath: "/src/c", DestinationPath: "/dst/c"}}); err != nil {
+		t.Fatalf("AddListing failed: %v", err)
+	}
+
+	files := listed("run1")
+	if len(files) != 2 || files[0].SourcePath != "/src/a" || files[1].DestinationPath != "/dst/b" {
+		t.Fatalf("Expected the run's files in path order, got %+v", files)
+	}
+	if string(files[1].Info) != `{"size":2}` {
+		t.Errorf("Expected the file's info kept, got %s", files[1].Info)
+	}
+
+	// Pages continue after the last path read
+	page, err := store.ListedFiles("run1", "", 1)
+	if err != nil || len(page) != 1 || page[0].SourcePath != "/src/a" {
+		t.Fatalf("Expected the first page to hold /src/a, got %+v (%v)", page, err)
+	}
+	page, err = store.ListedFiles("run1", page[0].SourcePath, 1)
+	if err != nil || len(page) != 1 || page[0].SourcePath != "/src/b" {
+		t.Fatalf("Expected the second page to hold /src/b, got %+v (%v)", page, err)
+	}
+	if page, err = store.ListedFiles("run1", page[0].SourcePath, 1); err != nil || len(page) != 0 {
+		t.Fatalf("Expected no third page, got %+v (%v)", page, err)
+	}
+
+	// Deleting a run's listing leaves the others alone
+	if err := store.DeleteListing("run1"); err != nil {
+		t.Fatalf("DeleteListing failed: %v", err)
+	}
+	if err := store.DeleteListing("missing"); err != nil {
+		t.Fatalf("DeleteListing of a run without a listing failed: %v", err)
+	}
+	if files := listed("run1"); len(files) != 0 {
+		t.Errorf("Expected the listing deleted, got %v", files)
+	}
+	if files := listed("run2"); len(files) != 1 {
+		t.Errorf("Expected the other run's listing kept, got %v", files)
+	}
+}
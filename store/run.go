@@ -0,0 +1,108 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrRunNotFound is returned when a run is not found in the state store.
+	ErrRunNotFound = errors.New("run not found")
+)
+
+var (
+	runsBucket = []byte("runs")
+)
+
+// RunRecord describes one invocation of a migration so that it can be
+// resumed later with exactly the same options.
+type RunRecord struct {
+	ID        string    `json:"id"`
+	Args      []string  `json:"args"`
+	WorkDir   string    `json:"work_dir,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at,omitempty"`
+	Completed bool      `json:"completed"`
+	Resumes   int       `json:"resumes,omitempty"`
+	// ListedAt is when the run finished recording its source listing (see
+	// AddListing), for runs transferring from one; zero until then.
+	ListedAt time.Time `json:"listed_at,omitempty"`
+}
+
+// SaveRun saves a run to the state store.
+func (s *BoltStore) SaveRun(run *RunRecord) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return fmt.Errorf("failed to create runs bucket: %w", err)
+		}
+
+		data, err := json.Marshal(run)
+		if err != nil {
+			return fmt.Errorf("failed to marshal run: %w", err)
+		}
+
+		if err := b.Put([]byte(run.ID), data); err != nil {
+			return fmt.Errorf("failed to put run: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetRun retrieves a run from the state store.
+func (s *BoltStore) GetRun(id string) (*RunRecord, error) {
+	var run RunRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return ErrRunNotFound
+		}
+		data := b.Get([]byte(id))
+		if data == nil {
+			return ErrRunNotFound
+		}
+
+		if err := json.Unmarshal(data, &run); err != nil {
+			return fmt.Errorf("failed to unmarshal run: %w", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// LatestRun returns the most recently started run in the state store.
+func (s *BoltStore) LatestRun() (*RunRecord, error) {
+	var latest *RunRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return ErrRunNotFound
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var run RunRecord
+			if err := json.Unmarshal(v, &run); err != nil {
+				return fmt.Errorf("failed to unmarshal run: %w", err)
+			}
+			if latest == nil || run.StartedAt.After(latest.StartedAt) {
+				latest = &run
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, ErrRunNotFound
+	}
+	return latest, nil
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBoltStore_SaveAndGetRun(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create BoltStore: %v", err)
+	}
+	defer store.Close()
+
+	if _, err := store.GetRun("missing"); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("Expected ErrRunNotFound, got %v", err)
+	}
+
+	run := &RunRecord{
+		ID:        "abc123",
+		Args:      []string{"-source", "/src", "-dest", "/dst", "-streams", "8"},
+		StartedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := store.SaveRun(run); err != nil {
+		t.Fatalf("Failed to save run: %v", err)
+	}
+
+	got, err := store.GetRun("abc123")
+	if err != nil {
+		t.Fatalf("Failed to get run: %v", err)
+	}
+	if !reflect.DeepEqual(got.Args, run.Args) {
+		t.Errorf("Expected args %v, got %v", run.Args, got.Args)
+	}
+	if !got.StartedAt.Equal(run.StartedAt) {
+		t.Errorf("Expected start %v, got %v", run.StartedAt, got.StartedAt)
+	}
+	if got.Completed {
+		t.Error("Expected run not to be completed")
+	}
+}
+
+func TestBoltStore_LatestRunAndSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create BoltStore: %v", err)
+	}
+	defer store.Close()
+
+	if _, err := store.LatestRun(); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("Expected ErrRunNotFound, got %v", err)
+	}
+
+	start := time.Now().UTC().Truncate(time.Second)
+	for i, id := range []string{"old", "new", "older"} {
+		offset := []time.Duration{-time.Hour, 0, -2 * time.Hour}[i]
+		if err := store.SaveRun(&RunRecord{ID: id, StartedAt: start.Add(offset)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := store.LatestRun()
+	if err != nil || latest.ID != "new" {
+		t.Fatalf("Expected run new, got %+v (%v)", latest, err)
+	}
+
+	// A snapshot opens as a store with the same contents
+	f, err := os.Create(filepath.Join(dir, "snapshot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.WriteTo(f); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	f.Close()
+
+	snapshot, err := NewBoltStore(filepath.Join(dir, "snapshot.db"))
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer snapshot.Close()
+	if _, err := snapshot.GetRun("older"); err != nil {
+		t.Errorf("Expected the snapshot to hold run older: %v", err)
+	}
+}
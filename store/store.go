@@ -1,9 +1,13 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"time"
+
 	"go.etcd.io/bbolt"
 )
 
@@ -14,6 +18,9 @@ var (
 
 var (
 	jobsBucket = []byte("jobs")
+	// jobPathsBucket indexes the jobs bucket by source and destination
+	// path, so a later run finds the job of a file under its ID.
+	jobPathsBucket = []byte("job_paths")
 )
 
 // JobState represents the current state of a file transfer.
@@ -24,6 +31,13 @@ const (
 	StateInProgress JobState = "InProgress"
 	StateCompleted  JobState = "Completed"
 	StateFailed     JobState = "Failed"
+	// StateWaitingRestore marks a job whose source is archived and
+	// waiting for a restore to complete before it can be read.
+	StateWaitingRestore JobState = "WaitingRestore"
+	// StateInconsistent marks a job whose source changed while it was
+	// copied, and whose destination was kept although it may mix old and
+	// new content.
+	StateInconsistent JobState = "Inconsistent"
 )
 
 // JobRecord represents the state of a job in the store.
@@ -35,12 +49,69 @@ type JobRecord struct {
 	BytesTransferred int64    `json:"bytes_transferred"`
 	TotalBytes       int64    `json:"total_bytes"`
 	Error            string   `json:"error,omitempty"`
+	// BytesRead counts the bytes read from the source. It may run ahead of
+	// BytesTransferred, which counts only those the destination has
+	// committed.
+	BytesRead int64 `json:"bytes_read,omitempty"`
+	// MetadataError records metadata that could not be applied to an
+	// otherwise completed file.
+	MetadataError string `json:"metadata_error,omitempty"`
+	// ProviderChecksum is the "algorithm:value" checksum the destination
+	// reported for a server-side copy, kept for audit since gofast did not
+	// hash the content itself.
+	ProviderChecksum string `json:"provider_checksum,omitempty"`
+	// Checksum is the "algorithm:value" checksum gofast computed while
+	// streaming the file, verified on both sides of the transfer.
+	Checksum string `json:"checksum,omitempty"`
+	// MTimeAdjustment describes a source modification time that was out
+	// of range, and what was done about it.
+	MTimeAdjustment string `json:"mtime_adjustment,omitempty"`
+	// StorageClass is the archive storage class a job in
+	// StateWaitingRestore is being restored from.
+	StorageClass string `json:"storage_class,omitempty"`
+	// VersionID is set on the jobs tracking one version of a replayed
+	// history, which share the paths of the file's own job and are not
+	// indexed by them.
+	VersionID string `json:"version_id,omitempty"`
+	// Target names the destination the job writes to in a run fanning out
+	// to several, and is empty for the first, so that each destination's
+	// job for a file has a state of its own.
+	Target string `json:"target,omitempty"`
+
+	// SourceSize and SourceModTime fingerprint the source when the job
+	// started, and HeadHash is the xxh3 of its first HeadSize bytes as
+	// transferred, so a resume can tell whether the source changed.
+	SourceSize    int64     `json:"source_size,omitempty"`
+	SourceModTime time.Time `json:"source_mod_time,omitempty"`
+	HeadSize      int64     `json:"head_size,omitempty"`
+	HeadHash      string    `json:"head_hash,omitempty"`
+	// Restarts counts resumes discarded because the source had changed;
+	// RestartReason explains the last one.
+	Restarts      int    `json:"restarts,omitempty"`
+	RestartReason string `json:"restart_reason,omitempty"`
+
+	// UploadID identifies the unfinished multipart upload of the
+	// destination, and UploadPartSize and UploadParts the parts stored so
+	// far, so a resume can continue it instead of uploading from zero.
+	UploadID       string       `json:"upload_id,omitempty"`
+	UploadPartSize int64        `json:"upload_part_size,omitempty"`
+	UploadParts    []UploadPart `json:"upload_parts,omitempty"`
+}
+
+// UploadPart is a stored part of a multipart upload.
+type UploadPart struct {
+	Number   int32  `json:"number"`
+	ETag     string `json:"etag"`
+	Checksum string `json:"checksum,omitempty"`
 }
 
 // Store define the interface for tracking file status.
 type Store interface {
 	SaveJob(job *JobRecord) error
 	GetJob(id string) (*JobRecord, error)
+	// FindJob returns the job last saved for a source and destination
+	// path of target, other than a version's, or ErrJobNotFound.
+	FindJob(sourcePath, destinationPath, target string) (*JobRecord, error)
 	Close() error
 }
 
@@ -57,7 +128,10 @@ func NewBoltStore(path string) (*BoltStore, error) {
 	}
 
 	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(jobPathsBucket)
 		return err
 	})
 	if err != nil {
@@ -83,10 +157,38 @@ func (s *BoltStore) SaveJob(job *JobRecord) error {
 			return fmt.Errorf("failed to put job: %w", err)
 		}
 
+		if job.VersionID == "" {
+			if err := tx.Bucket(jobPathsBucket).Put(jobPathKey(job.SourcePath, job.DestinationPath, job.Target), []byte(job.ID)); err != nil {
+				return fmt.Errorf("failed to index job: %w", err)
+			}
+		}
 		return nil
 	})
 }
 
+// jobPathKey is the key of a job in jobPathsBucket. Paths hold no NUL, so
+// the keys of targets, which hold one more, never clash with the others.
+func jobPathKey(sourcePath, destinationPath, target string) []byte {
+	if target != "" {
+		return []byte(target + "\x00" + sourcePath + "\x00" + destinationPath)
+	}
+	return []byte(sourcePath + "\x00" + destinationPath)
+}
+
+// FindJob retrieves the job last saved for a source and destination path
+// of target.
+func (s *BoltStore) FindJob(sourcePath, destinationPath, target string) (*JobRecord, error) {
+	var id []byte
+	s.db.View(func(tx *bbolt.Tx) error {
+		id = bytes.Clone(tx.Bucket(jobPathsBucket).Get(jobPathKey(sourcePath, destinationPath, target)))
+		return nil
+	})
+	if id == nil {
+		return nil, ErrJobNotFound
+	}
+	return s.GetJob(string(id))
+}
+
 // GetJob retrieves a job from the state store.
 func (s *BoltStore) GetJob(id string) (*JobRecord, error) {
 	var job JobRecord
@@ -110,6 +212,33 @@ func (s *BoltStore) GetJob(id string) (*JobRecord, error) {
 	return &job, nil
 }
 
+// ForEachJob calls fn with every job in the state store, in ID order. It
+// stops at the first error fn returns. fn must not write to the store.
+func (s *BoltStore) ForEachJob(fn func(job *JobRecord) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, data []byte) error {
+			var job JobRecord
+			if err := json.Unmarshal(data, &job); err != nil {
+				return fmt.Errorf("failed to unmarshal job %s: %w", k, err)
+			}
+			return fn(&job)
+		})
+	})
+}
+
+// WriteTo writes a consistent snapshot of the whole database to w, while
+// other goroutines keep updating it. The snapshot can be opened with
+// NewBoltStore once saved to a file.
+func (s *BoltStore) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
 // Close closes the underlying store.
 func (s *BoltStore) Close() error {
 	return s.db.Close()
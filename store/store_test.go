@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"path/filepath"
 	"testing"
 )
@@ -91,3 +92,75 @@ func TestBoltStore_Close(t *testing.T) {
 		t.Error("Expected error when accessing closed store, got nil")
 	}
 }
+
+func TestBoltStore_ForEachJob(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, id := range []string{"b", "a", "c"} {
+		if err := store.SaveJob(&JobRecord{ID: id, State: StatePending}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	err = store.ForEachJob(func(job *JobRecord) error {
+		ids = append(ids, job.ID)
+		return nil
+	})
+	if err != nil || len(ids) != 3 || ids[0] != "a" || ids[2] != "c" {
+		t.Errorf("Expected every job in ID order, got %v, %v", ids, err)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.ForEachJob(func(job *JobRecord) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Expected iteration to stop at the first error, got %d calls, %v", calls, err)
+	}
+}
+
+func TestBoltStore_FindJob(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create BoltStore: %v", err)
+	}
+	defer store.Close()
+
+	if _, err := store.FindJob("/src/a", "/dst/a", ""); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("Expected ErrJobNotFound, got %v", err)
+	}
+	for _, job := range []*JobRecord{
+		{ID: "first", SourcePath: "/src/a", DestinationPath: "/dst/a"},
+		{ID: "other-dest", SourcePath: "/src/a", DestinationPath: "/elsewhere/a"},
+		{ID: "second", SourcePath: "/src/a", DestinationPath: "/dst/a", State: StateCompleted},
+		{ID: "second@v1", SourcePath: "/src/a", DestinationPath: "/dst/a", VersionID: "v1"},
+		{ID: "replica", SourcePath: "/src/a", DestinationPath: "/dst/a", Target: "s3://dr"},
+	} {
+		if err := store.SaveJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The last job saved for the paths wins, and versions are not indexed
+	job, err := store.FindJob("/src/a", "/dst/a", "")
+	if err != nil || job.ID != "second" || job.State != StateCompleted {
+		t.Errorf("Expected job second, got %+v, %v", job, err)
+	}
+	if job, err := store.FindJob("/src/a", "/elsewhere/a", ""); err != nil || job.ID != "other-dest" {
+		t.Errorf("Expected job other-dest, got %+v, %v", job, err)
+	}
+
+	// Each target of a fan-out has jobs of its own for the same paths
+	if job, err := store.FindJob("/src/a", "/dst/a", "s3://dr"); err != nil || job.ID != "replica" {
+		t.Errorf("Expected job replica, got %+v, %v", job, err)
+	}
+	if _, err := store.FindJob("/src/a", "/dst/a", "s3://other"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound for another target, got %v", err)
+	}
+}
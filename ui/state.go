@@ -0,0 +1,65 @@
+package ui
+
+import (
+	"sync"
+
+	"github.com/franksops/gofast/engine"
+)
+
+// StateTracker builds the UIState the TUI shows from the run's
+// engine.RunStats, with the state the TUI and signals control: the number
+// of workers and whether the run is paused. It is safe for concurrent use.
+type StateTracker struct {
+	stats *engine.RunStats
+
+	mu      sync.Mutex
+	workers int
+	paused  bool
+}
+
+// NewStateTracker returns a tracker showing stats for a run on workers
+// workers.
+func NewStateTracker(stats *engine.RunStats, workers int) *StateTracker {
+	return &StateTracker{stats: stats, workers: workers}
+}
+
+// SetWorkers records the number of workers the run has, e.g. once the
+// auto-tuner changed it.
+func (t *StateTracker) SetWorkers(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.workers = n
+}
+
+// SetPaused records whether the run is paused.
+func (t *StateTracker) SetPaused(paused bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = paused
+}
+
+// State returns the current state.
+func (t *StateTracker) State() *UIState {
+	s := t.stats.Stats()
+	streams := make([]*ActiveStream, len(s.Streams))
+	for i, r := range s.Streams {
+		streams[i] = &ActiveStream{JobID: r.JobID, FilePath: r.Path, Progress: r.Progress(), BytesSec: r.Rate}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &UIState{
+		TotalFiles:     s.Total.Files,
+		TotalBytes:     s.Total.Bytes,
+		CompletedFiles: s.Completed.Files,
+		CompletedBytes: s.Completed.Bytes,
+		SparseBytes:    s.SparseBytes,
+		ActiveStreams:  streams,
+		ActiveWorkers:  t.workers,
+		MaxWorkers:     t.workers,
+		ThroughputBPms: s.Rate / 1000,
+		IsRunning:      !s.Finished,
+		Done:           s.Finished,
+		Scanning:       s.Scanning,
+		Paused:         t.paused,
+	}
+}
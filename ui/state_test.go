@@ -0,0 +1,47 @@
+package ui
+
+import (
+	"testing"
+	"time"
+
+	"github.com/franksops/gofast/engine"
+)
+
+type sizedInfo int64
+
+func (s sizedInfo) Name() string       { return "f" }
+func (s sizedInfo) Size() int64        { return int64(s) }
+func (s sizedInfo) ModTime() time.Time { return time.Time{} }
+func (s sizedInfo) IsDir() bool        { return false }
+
+func TestStateTracker_State(t *testing.T) {
+	stats := engine.NewRunStats(nil)
+	var bus engine.EventBus
+	bus.Subscribe(stats.Handle)
+	tracker := NewStateTracker(stats, 4)
+
+	a := engine.TransferJob{ID: "a", DestinationPath: "a", FileInfo: sizedInfo(100)}
+	bus.Publish(engine.JobQueued{Job: a})
+	if s := tracker.State(); s.TotalFiles != 1 || s.TotalBytes != 100 || !s.Scanning || !s.IsRunning || s.MaxWorkers != 4 {
+		t.Fatalf("Expected a running scan on 4 workers, got %+v", s)
+	}
+
+	bus.Publish(engine.ScanFinished{Files: 2, Bytes: 150})
+	bus.Publish(engine.JobCompleted{Job: a, Bytes: 100, Sparse: 40})
+	tracker.SetPaused(true)
+	tracker.SetWorkers(8)
+	bus.Publish(engine.RunFinished{})
+	s := tracker.State()
+	if s.TotalFiles != 2 || s.CompletedFiles != 1 || s.CompletedBytes != 100 || s.SparseBytes != 40 || s.Scanning {
+		t.Errorf("Expected the run's stats, got %+v", s)
+	}
+	if !s.Paused || s.MaxWorkers != 8 || s.ActiveWorkers != 8 || !s.Done || s.IsRunning {
+		t.Errorf("Expected a paused, finished run on 8 workers, got %+v", s)
+	}
+
+	// States handed out are not changed by later events
+	bus.Publish(engine.JobCompleted{Job: a, Bytes: 50})
+	if s.CompletedFiles != 1 {
+		t.Error("Expected the earlier state to stay as it was")
+	}
+}
@@ -18,12 +18,20 @@ type UIState struct {
 	TotalBytes     int64
 	CompletedFiles int64
 	CompletedBytes int64
+	// SparseBytes counts the holes of completed sparse files, included
+	// in CompletedBytes but never transferred.
+	SparseBytes    int64
 	ActiveStreams  []*ActiveStream
 	ActiveWorkers  int
 	MaxWorkers     int
 	ThroughputBPms float64 // bytes per millisecond
 	IsRunning      bool
 	Done           bool
+	// Scanning means the source is still being walked, so TotalFiles and
+	// TotalBytes are still growing.
+	Scanning bool
+	// Paused means the workers take no new jobs until resumed.
+	Paused bool
 }
 
 // ActiveStream represents a current running transfer
@@ -51,6 +59,10 @@ type TUIModel struct {
 	helpStyle    lipgloss.Style
 	errorStyle   lipgloss.Style
 	successStyle lipgloss.Style
+
+	// TogglePause, if set, is called when 'p' is pressed to pause or
+	// resume the engine, and returns whether it is now paused.
+	TogglePause func() bool
 }
 
 // TUIUpdateMsg is sent periodically to update the UI state
@@ -102,6 +114,10 @@ func (m TUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "-":
 			// Decrease workers
 			return m, func() tea.Msg { return WorkerCountMsg(-1) }
+		case "p":
+			if m.TogglePause != nil {
+				m.engineState.Paused = m.TogglePause()
+			}
 		}
 
 	case tea.WindowSizeMsg:
@@ -153,12 +169,30 @@ func (m TUIModel) View() string {
 	totalTB := float64(m.engineState.TotalBytes) / (1024 * 1024 * 1024 * 1024)
 	compTB := float64(m.engineState.CompletedBytes) / (1024 * 1024 * 1024 * 1024)
 
-	opsInfo := fmt.Sprintf("ETA: %s | Workers: %d/%d | %.2f TB / %.2f TB",
-		formatETA(percent, m.engineState.ThroughputBPms, m.engineState.TotalBytes, m.engineState.CompletedBytes),
+	// Totals still being discovered are lower bounds
+	eta := formatETA(percent, m.engineState.ThroughputBPms, m.engineState.TotalBytes, m.engineState.CompletedBytes)
+	more := ""
+	if m.engineState.Scanning {
+		more = "+"
+		if eta != "Calculating..." {
+			eta = ">" + eta
+		}
+	}
+	opsInfo := fmt.Sprintf("ETA: %s | Workers: %d/%d | Files: %d / %d%s | %.2f TB / %.2f TB%s",
+		eta,
 		m.engineState.ActiveWorkers, m.engineState.MaxWorkers,
-		compTB, totalTB)
+		m.engineState.CompletedFiles, m.engineState.TotalFiles, more,
+		compTB, totalTB, more)
+	if m.engineState.SparseBytes > 0 {
+		opsInfo += fmt.Sprintf(" (%.2f TB sparse)", float64(m.engineState.SparseBytes)/(1024*1024*1024*1024))
+	}
 
-	sb.WriteString(m.infoStyle.Render(opsInfo) + "\n")
+	if m.engineState.Paused {
+		opsInfo = m.errorStyle.Render("PAUSED") + " " + m.infoStyle.Render(opsInfo)
+	} else {
+		opsInfo = m.infoStyle.Render(opsInfo)
+	}
+	sb.WriteString(opsInfo + "\n")
 	sb.WriteString(m.progress.ViewAs(percent) + "\n\n")
 
 	// Active Streams
@@ -186,7 +220,11 @@ func (m TUIModel) View() string {
 	sb.WriteString(m.viewport.View())
 
 	// Footer
-	help := m.helpStyle.Render("q/ctrl+c: quit • +/-: adjust workers")
+	keys := "q/ctrl+c: quit • +/-: adjust workers"
+	if m.TogglePause != nil {
+		keys += " • p: pause/resume"
+	}
+	help := m.helpStyle.Render(keys)
 	if m.engineState.Done {
 		help = m.successStyle.Render("Migration Complete!") + " Press 'q' to exit."
 	}
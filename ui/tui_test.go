@@ -3,6 +3,8 @@ package ui
 import (
 	"strings"
 	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
 )
 
 func TestFormatSpeed(t *testing.T) {
@@ -70,3 +72,26 @@ func TestTUIModelInitialization(t *testing.T) {
 		t.Errorf("Expected Initializing view when width is 0")
 	}
 }
+
+func TestTUIModelPauseKey(t *testing.T) {
+	state := &UIState{MaxWorkers: 10}
+	model := NewTUIModel(state)
+	paused := false
+	model.TogglePause = func() bool {
+		paused = !paused
+		return paused
+	}
+	model.width = 80
+
+	key := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")}
+	updated, _ := model.Update(key)
+	if !state.Paused {
+		t.Fatal("Expected 'p' to pause")
+	}
+	if view := updated.(TUIModel).View(); !strings.Contains(view, "PAUSED") || !strings.Contains(view, "p: pause/resume") {
+		t.Errorf("Expected the view to show the pause, got:\n%s", view)
+	}
+	if model.Update(key); state.Paused {
+		t.Error("Expected 'p' again to resume")
+	}
+}